@@ -0,0 +1,13 @@
+// Command buildvault-helper is the static helper binary injected into task containers.
+// Build it with CGO_ENABLED=0 so it runs on images without a libc.
+package main
+
+import (
+	"os"
+
+	"github.com/benjaminstrasser/buildvault/pkg/helper"
+)
+
+func main() {
+	os.Exit(helper.Run(os.Args[1:], os.Stdout, os.Stderr))
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/spf13/cobra"
+)
+
+var bundleOpts struct {
+	output   string
+	logFiles []string
+}
+
+var bundleCmd = &cobra.Command{
+	Use:   "bundle",
+	Short: "Package the pipeline, resolved plan and diagnostics into a tarball for bug reports",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := pkg.BundleOptions{
+			PipelinePath: pipelineFile,
+			LogFiles:     bundleOpts.logFiles,
+		}
+		if len(opts.LogFiles) == 0 {
+			logFiles, err := workspace.LogFiles()
+			if err != nil {
+				return err
+			}
+			opts.LogFiles = logFiles
+		}
+		// A broken pipeline file is a common reason for a bug report, so it is bundled regardless
+		pipeline, err := loadPipeline()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		} else {
+			opts.Pipeline = pipeline
+		}
+
+		cli, err := newDockerClient(opts.Pipeline)
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+
+		output := bundleOpts.output
+		if output == "" {
+			output = fmt.Sprintf("buildvault-bundle-%s.tar.gz", time.Now().Format("20060102-150405"))
+		}
+		file, err := os.Create(output)
+		if err != nil {
+			return fmt.Errorf("error creating bundle: %w", err)
+		}
+		defer file.Close()
+
+		if err := pkg.WriteBundle(cmd.Context(), cli, file, opts); err != nil {
+			return err
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("error writing bundle: %w", err)
+		}
+
+		fmt.Printf("Wrote %s, please review it before attaching it to a bug report\n", output)
+		return nil
+	},
+}
+
+func init() {
+	bundleCmd.Flags().StringVarP(&bundleOpts.output, "output", "o", "", "path of the bundle (default buildvault-bundle-<time>.tar.gz)")
+	bundleCmd.Flags().StringArrayVar(&bundleOpts.logFiles, "log", nil, "log file of a failing run to include (repeatable, default the files in .buildvault/logs of the workspace)")
+	rootCmd.AddCommand(bundleCmd)
+}
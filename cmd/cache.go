@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/spf13/cobra"
+)
+
+var cacheOpts struct {
+	artifactStore string
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the artifact store",
+}
+
+var cacheBrowseCmd = &cobra.Command{
+	Use:   "browse",
+	Short: "Browse the generations of the artifact store: inspect manifests, pin, invalidate or open a shell",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir := cacheOpts.artifactStore
+		if dir == "" {
+			// The local artifact store of the workspace, if it has one
+			dir = workspace.ArtifactStoreDir()
+			if _, err := os.Stat(dir); err != nil {
+				return fmt.Errorf("--artifact-store is required, %s has no artifact store", workspace.Root)
+			}
+		}
+		store, err := pkg.NewArtifactStore(dir)
+		if err != nil {
+			return err
+		}
+
+		// Without a pipeline, shells run as the image's user
+		var pipeline *pkg.Pipeline
+		var tasks []*pkg.Task
+		if _, err := os.Stat(pipelineFile); err == nil {
+			if pipeline, err = loadPipeline(); err != nil {
+				return err
+			}
+			tasks = pipeline.Tasks
+		}
+
+		cli, err := newDockerClient(pipeline)
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+
+		return pkg.BrowseCache(cmd.Context(), cli, store, tasks)
+	},
+}
+
+func init() {
+	cacheCmd.PersistentFlags().StringVar(&cacheOpts.artifactStore, "artifact-store", "", "directory of the artifact store (default .buildvault/artifacts in the workspace)")
+	cacheCmd.AddCommand(cacheBrowseCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/spf13/cobra"
+)
+
+var configOpts struct {
+	workspace bool
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "View or change the defaults of the command line, layered from the global and the workspace config file and the environment",
+}
+
+var configViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Show the effective configuration and where each value comes from",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config, err := pkg.LoadConfig(workspace)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tFROM")
+		for _, key := range pkg.ConfigKeys() {
+			value, _ := config.Get(key)
+			origin := config.Origin(key)
+			if value == "" {
+				value, origin = "-", "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", key, value, origin)
+		}
+		return w.Flush()
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set key [value]",
+	Short: "Set a key in the global config file, or in the one of the workspace with --workspace; without a value, unset it",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := pkg.GlobalConfigPath()
+		if err != nil {
+			return err
+		}
+		if configOpts.workspace {
+			path = workspace.ConfigPath()
+		}
+
+		file, err := pkg.ReadConfigFile(path)
+		if err != nil {
+			return err
+		}
+		value := ""
+		if len(args) == 2 {
+			value = args[1]
+		}
+		if err := file.Set(args[0], value); err != nil {
+			return err
+		}
+		if err := file.WriteFile(path); err != nil {
+			return err
+		}
+		if value == "" {
+			fmt.Printf("Unset %s in %s\n", args[0], path)
+		} else {
+			fmt.Printf("Set %s to %s in %s\n", args[0], value, path)
+		}
+		return nil
+	},
+}
+
+func init() {
+	configSetCmd.Flags().BoolVar(&configOpts.workspace, "workspace", false, "change the config file of the workspace, .buildvault/config.yaml, instead of the global one")
+	configCmd.AddCommand(configViewCmd, configSetCmd)
+	rootCmd.AddCommand(configCmd)
+}
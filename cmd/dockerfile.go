@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/spf13/cobra"
+)
+
+var dockerfileOutput string
+
+var dockerfileCmd = &cobra.Command{
+	Use:   "dockerfile [task...]",
+	Short: "Print a multi-stage Dockerfile approximating the tasks (all root tasks if none are given)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pipeline, err := loadPipeline()
+		if err != nil {
+			return err
+		}
+		targets, err := resolveTargets(pipeline, args)
+		if err != nil {
+			return err
+		}
+
+		dockerfile, err := pkg.Dockerfile(targets)
+		if err != nil {
+			return err
+		}
+		if dockerfileOutput == "" {
+			fmt.Print(dockerfile)
+			return nil
+		}
+		return os.WriteFile(dockerfileOutput, []byte(dockerfile), 0o644)
+	},
+}
+
+func init() {
+	dockerfileCmd.Flags().StringVarP(&dockerfileOutput, "output", "o", "", "write the Dockerfile to this file instead of stdout")
+	rootCmd.AddCommand(dockerfileCmd)
+}
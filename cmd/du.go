@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+var duOpts struct {
+	containers bool
+}
+
+var duCmd = &cobra.Command{
+	Use:   "du",
+	Short: "Show disk usage of preserved task containers",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cli, err := newDockerClient(nil)
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+
+		report, err := pkg.Usage(cmd.Context(), cli)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		if duOpts.containers {
+			fmt.Fprintln(w, "TASK\tCONTAINER\tSTATE\tCREATED\tSIZE")
+			for _, task := range report.Tasks {
+				for _, container := range task.Containers {
+					fmt.Fprintf(w, "%s\t%s\t%s\t%s ago\t%s\n", task.TaskName, container.Name, container.State,
+						units.HumanDuration(time.Since(container.Created)), units.HumanSize(float64(container.SizeRw)))
+				}
+			}
+		} else {
+			fmt.Fprintln(w, "TASK\tCONTAINERS\tSIZE")
+			for _, task := range report.Tasks {
+				fmt.Fprintf(w, "%s\t%d\t%s\n", task.TaskName, len(task.Containers), units.HumanSize(float64(task.SizeRw)))
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Printf("\nTotal: %s in writable container layers\n", units.HumanSize(float64(report.TotalSizeRw)))
+		return nil
+	},
+}
+
+func init() {
+	duCmd.Flags().BoolVar(&duOpts.containers, "containers", false, "list every container instead of per-task totals")
+	rootCmd.AddCommand(duCmd)
+}
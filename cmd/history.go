@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+var historyOpts struct {
+	limit    int
+	task     string
+	manifest bool
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history [run-id]",
+	Short: "List past runs of the pipeline, or show the tasks of one run",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		history, err := pkg.OpenHistory(workspace.HistoryPath())
+		if err != nil {
+			return err
+		}
+		defer history.Close()
+
+		if historyOpts.manifest && len(args) != 1 {
+			return fmt.Errorf("--manifest needs a run ID")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		switch {
+		case len(args) == 1:
+			id, err := strconv.ParseUint(args[0], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid run ID '%s'", args[0])
+			}
+			run, err := history.Run(id)
+			if err != nil {
+				return err
+			}
+			if historyOpts.manifest {
+				return printArtifactManifests(run)
+			}
+			fmt.Printf("Run %d of %s, started %s ago, %s after %s\n", run.ID, strings.Join(run.Targets, ", "),
+				units.HumanDuration(time.Since(run.Started)), run.Status, run.Duration.Round(time.Millisecond))
+			if run.Error != "" {
+				fmt.Printf("Error: %s\n", run.Error)
+			}
+			fmt.Fprintln(w, "\nTASK\tSTATUS\tDURATION\tHASH\tCONTAINER")
+			for _, task := range run.Tasks {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", task.Name, task.Status, task.Duration.Round(time.Millisecond),
+					shortID(task.Hash), shortID(task.ContainerID))
+			}
+		case historyOpts.task != "":
+			runs, err := history.TaskRuns(historyOpts.task, historyOpts.limit)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, "RUN\tSTARTED\tSTATUS\tDURATION\tHASH")
+			for _, run := range runs {
+				fmt.Fprintf(w, "%d\t%s ago\t%s\t%s\t%s\n", run.RunID, units.HumanDuration(time.Since(run.Started)),
+					run.Status, run.Duration.Round(time.Millisecond), shortID(run.Hash))
+			}
+		default:
+			runs, err := history.Runs(historyOpts.limit)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, "RUN\tSTARTED\tDURATION\tSTATUS\tTARGETS")
+			for _, run := range runs {
+				fmt.Fprintf(w, "%d\t%s ago\t%s\t%s\t%s\n", run.ID, units.HumanDuration(time.Since(run.Started)),
+					run.Duration.Round(time.Millisecond), run.Status, strings.Join(run.Targets, ", "))
+			}
+		}
+		return w.Flush()
+	},
+}
+
+// printArtifactManifests writes the checksums of the outputs of the tasks of run to stdout as JSON
+func printArtifactManifests(run *pkg.RunRecord) error {
+	manifests := []pkg.ArtifactManifest{}
+	for _, task := range run.Tasks {
+		if len(task.Artifacts) > 0 {
+			manifests = append(manifests, task.ArtifactManifest())
+		}
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(manifests)
+}
+
+// shortID shortens hashes and container IDs to 12 characters like docker does
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+func init() {
+	historyCmd.Flags().IntVar(&historyOpts.limit, "limit", 20, "number of runs to list, 0 for all")
+	historyCmd.Flags().StringVar(&historyOpts.task, "task", "", "list the recorded runs of a single task")
+	historyCmd.Flags().BoolVar(&historyOpts.manifest, "manifest", false, "print the SHA-256 digests and sizes of the outputs of the tasks of the run as JSON")
+	rootCmd.AddCommand(historyCmd)
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/spf13/cobra"
+)
+
+var imageOpts pkg.ImageExportOptions
+
+var exportImageCmd = &cobra.Command{
+	Use:   "export-image <task>",
+	Short: "Export the preserved container of a task as an OCI image layout or docker save tarball",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pipeline, err := loadPipeline()
+		if err != nil {
+			return err
+		}
+		targets, err := resolveTargets(pipeline, args)
+		if err != nil {
+			return err
+		}
+
+		cli, err := newDockerClient(pipeline)
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+
+		return targets[0].ExportImage(cmd.Context(), cli, imageOpts)
+	},
+}
+
+func init() {
+	exportImageCmd.Flags().StringVarP(&imageOpts.Path, "output", "o", "", "directory (oci) or file (tar) to write")
+	exportImageCmd.Flags().StringVar(&imageOpts.Format, "format", pkg.ImageFormatOCI, "oci for an OCI image layout directory or tar for a docker save tarball")
+	exportImageCmd.Flags().StringVar(&imageOpts.Base, "base", "", "put only the declared outputs of the task on this image instead of exporting its whole filesystem")
+	exportImageCmd.Flags().StringVar(&imageOpts.Tag, "tag", "", "name of the image in the export (default <task>:latest)")
+	exportImageCmd.MarkFlagRequired("output")
+	rootCmd.AddCommand(exportImageCmd)
+}
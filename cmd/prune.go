@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/spf13/cobra"
+)
+
+var pruneOpts struct {
+	task         string
+	olderThan    time.Duration
+	unreferenced bool
+	images       bool
+	cache        bool
+	snapshots    bool
+	networks     bool
+	all          bool
+	dryRun       bool
+}
+
+var pruneCmd = &cobra.Command{
+	Use:     "prune",
+	Aliases: []string{"clean"},
+	Short:   "Remove preserved buildvault task containers and, optionally, the other resources buildvault creates",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if pruneOpts.all {
+			pruneOpts.images, pruneOpts.cache, pruneOpts.snapshots, pruneOpts.networks = true, true, true, true
+		}
+		opts := pkg.PruneOptions{
+			TaskName:  pruneOpts.task,
+			OlderThan: pruneOpts.olderThan,
+			DryRun:    pruneOpts.dryRun,
+		}
+
+		var pipeline *pkg.Pipeline
+		if pruneOpts.unreferenced {
+			var err error
+			if pipeline, err = loadPipeline(); err != nil {
+				return err
+			}
+		}
+
+		cli, err := newDockerClient(pipeline)
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+
+		if pruneOpts.unreferenced {
+			// Hashes depend on the digests of built images and host inputs
+			if err := pkg.ResolveBuiltImages(cmd.Context(), cli, pipeline.Tasks); err != nil {
+				return err
+			}
+			if err := pkg.ResolveHostInputs(pipeline.Tasks); err != nil {
+				return err
+			}
+			opts.Keep = pipeline.Tasks
+		}
+
+		pruned, err := pkg.Prune(cmd.Context(), cli, opts)
+		if err != nil {
+			return err
+		}
+
+		var images []string
+		if pruneOpts.images {
+			// Runs after the containers are gone, since images are only removable once unused
+			images, err = pkg.PruneImages(cmd.Context(), cli, opts.DryRun)
+			if err != nil {
+				return err
+			}
+		}
+
+		var volumes []pkg.PrunedVolume
+		if pruneOpts.cache {
+			// Runs after the containers are gone, since volumes are only removable once unused
+			volumes, err = pkg.PruneCacheVolumes(cmd.Context(), cli, opts)
+			if err != nil {
+				return err
+			}
+		}
+
+		var snapshots []pkg.PrunedImage
+		if pruneOpts.snapshots {
+			if snapshots, err = pkg.PruneSnapshots(cmd.Context(), cli, opts); err != nil {
+				return err
+			}
+		}
+
+		var networks []pkg.PrunedNetwork
+		if pruneOpts.networks {
+			if networks, err = pkg.PruneNetworks(cmd.Context(), cli, opts); err != nil {
+				return err
+			}
+		}
+
+		if opts.DryRun {
+			for _, container := range pruned {
+				fmt.Printf("Would remove %s (created %s)\n", container.Name, container.Created.Format(time.RFC3339))
+			}
+			for _, image := range images {
+				fmt.Printf("Would remove image %s\n", image)
+			}
+			for _, volume := range volumes {
+				fmt.Printf("Would remove cache volume %s (task '%s', %s)\n", volume.Name, volume.TaskName, volume.Dir)
+			}
+			for _, snapshot := range snapshots {
+				fmt.Printf("Would remove snapshot %s (task '%s', created %s)\n", snapshot.Ref, snapshot.TaskName, snapshot.Created.Format(time.RFC3339))
+			}
+			for _, network := range networks {
+				fmt.Printf("Would remove network %s (task '%s')\n", network.Name, network.TaskName)
+			}
+			fmt.Printf("Would remove %d container(s), %d image(s), %d snapshot(s), %d cache volume(s) and %d network(s)\n",
+				len(pruned), len(images), len(snapshots), len(volumes), len(networks))
+			return nil
+		}
+
+		fmt.Printf("Removed %d container(s), %d image(s), %d snapshot(s), %d cache volume(s) and %d network(s)\n",
+			len(pruned), len(images), len(snapshots), len(volumes), len(networks))
+		return nil
+	},
+}
+
+func init() {
+	pruneCmd.Flags().StringVar(&pruneOpts.task, "task", "", "only prune containers of this task")
+	pruneCmd.Flags().DurationVar(&pruneOpts.olderThan, "older-than", 0, "only prune containers older than this duration (e.g. 24h)")
+	pruneCmd.Flags().BoolVar(&pruneOpts.unreferenced, "unreferenced", false, "only prune containers not matching a task hash of the current pipeline")
+	pruneCmd.Flags().BoolVar(&pruneOpts.images, "images", false, "also remove outdated images built from Dockerfiles that no container uses anymore")
+	pruneCmd.Flags().BoolVar(&pruneOpts.cache, "cache", false, "also remove the cache volumes of task cache directories (subject to the same filters)")
+	pruneCmd.Flags().BoolVar(&pruneOpts.snapshots, "snapshots", false, "also remove the buildvault-debug images of failed task containers (subject to the same filters)")
+	pruneCmd.Flags().BoolVar(&pruneOpts.networks, "networks", false, "also remove services networks left behind by interrupted runs (subject to the same filters)")
+	pruneCmd.Flags().BoolVar(&pruneOpts.all, "all", false, "prune every kind of resource buildvault creates: containers, images, snapshots, cache volumes and networks")
+	pruneCmd.Flags().BoolVar(&pruneOpts.dryRun, "dry-run", false, "print the containers that would be removed without removing them")
+	rootCmd.AddCommand(pruneCmd)
+}
@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/docker/docker/client"
+	"github.com/spf13/cobra"
+)
+
+var pipelineFile string
+
+// workspace is the project directory of the pipeline file, see pkg.Workspace
+var workspace *pkg.Workspace
+
+// pipelineVars are NAME=VALUE overrides of pipeline variables
+var pipelineVars []string
+
+// config is the layered configuration providing the defaults of flags, see pkg.Config
+var config *pkg.Config
+
+// configFlags maps flags to the config keys their defaults come from
+var configFlags = map[string]string{
+	"namespace":      "namespace",
+	"artifact-store": "artifact_store",
+	"max-execs":      "max_execs",
+	"max-copies":     "max_copies",
+	"output":         "output",
+	"compress":       "compression",
+}
+
+// namespace isolates the Docker resources of this invocation from those of other namespaces
+var namespace string
+
+var dockerOpts struct {
+	host      string
+	tlsCACert string
+	tlsCert   string
+	tlsKey    string
+}
+
+var rootCmd = &cobra.Command{
+	Use:           "buildvault",
+	Short:         "Run container-based build pipelines with preserved task containers",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := findWorkspace(cmd); err != nil {
+			return err
+		}
+		if cmd.Parent() == configCmd {
+			// The config commands read the config files themselves, a broken one must not keep them from fixing it
+			return nil
+		}
+		if err := applyConfig(cmd); err != nil {
+			return err
+		}
+		return pkg.SetNamespace(namespace)
+	},
+}
+
+func init() {
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &pkg.ConfigError{Err: err}
+	})
+	rootCmd.PersistentFlags().StringVarP(&pipelineFile, "file", "f", "buildvault.yaml", "path to the pipeline file, in HCL if it ends in .hcl; by default found in the current directory or its parents")
+	rootCmd.PersistentFlags().StringArrayVar(&pipelineVars, "var", nil, "set a variable of the pipeline file, as NAME=VALUE (repeatable)")
+	rootCmd.PersistentFlags().StringVar(&namespace, "namespace", "", "isolate containers, volumes and images from other projects or CI agents sharing the Docker daemon, defaults to $BUILDVAULT_NAMESPACE or the namespace config key")
+	rootCmd.PersistentFlags().StringVar(&dockerOpts.host, "docker-host", "", "Docker daemon to use (unix://, tcp:// or ssh://user@host), overrides the pipeline file and DOCKER_HOST")
+	rootCmd.PersistentFlags().StringVar(&dockerOpts.tlsCACert, "docker-tlscacert", "", "CA certificate to verify a tcp:// Docker daemon with")
+	rootCmd.PersistentFlags().StringVar(&dockerOpts.tlsCert, "docker-tlscert", "", "client certificate for a tcp:// Docker daemon")
+	rootCmd.PersistentFlags().StringVar(&dockerOpts.tlsKey, "docker-tlskey", "", "client key for a tcp:// Docker daemon")
+}
+
+// Execute runs the buildvault command line interface.
+func Execute() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(pkg.ExitCode(err))
+	}
+}
+
+// findWorkspace sets the workspace of the pipeline file given on the command line or, without one, the
+// workspace of the working directory. Outside of a workspace, the pipeline file defaults to
+// buildvault.yaml in the working directory, so commands fail as if it was missing.
+func findWorkspace(cmd *cobra.Command) error {
+	var err error
+	if cmd.Flags().Changed("file") {
+		workspace, err = pkg.WorkspaceOf(pipelineFile)
+		return err
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("error getting working directory: %w", err)
+	}
+	workspace, err = pkg.FindWorkspace(dir)
+	if errors.Is(err, pkg.ErrNoWorkspace) {
+		workspace, err = pkg.WorkspaceOf(pipelineFile)
+	}
+	if err != nil {
+		return err
+	}
+	pipelineFile = workspace.Pipeline
+	return nil
+}
+
+// applyConfig loads the configuration and sets the flags of cmd that were not given on the command line
+// to it
+func applyConfig(cmd *cobra.Command) error {
+	var err error
+	if config, err = pkg.LoadConfig(workspace); err != nil {
+		return err
+	}
+	for name, key := range configFlags {
+		flag := cmd.Flags().Lookup(name)
+		value, _ := config.Get(key)
+		if flag == nil || flag.Changed || value == "" {
+			continue
+		}
+		if err := flag.Value.Set(value); err != nil {
+			return &pkg.ConfigError{Err: fmt.Errorf("invalid %s '%s' from %s: %w", key, value, config.Origin(key), err)}
+		}
+	}
+	pkg.SetDownloadCache(config.DownloadCache)
+	pkg.SetRegistryConfig(config.Registry.Config)
+	pkg.SetSecretKeyFile(workspace.SecretKeyPath())
+	return nil
+}
+
+// loadPipeline loads the pipeline file with the variables set on the command line
+func loadPipeline() (*pkg.Pipeline, error) {
+	pipeline, err := pkg.LoadPipeline(pipelineFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, variable := range pipelineVars {
+		name, value, ok := strings.Cut(variable, "=")
+		if !ok {
+			return nil, &pkg.ConfigError{Err: fmt.Errorf("invalid variable '%s', expected NAME=VALUE", variable)}
+		}
+		if err := pipeline.SetVar(name, value); err != nil {
+			return nil, err
+		}
+	}
+	return pipeline, nil
+}
+
+// newDockerClient connects to the Docker daemon selected by the command line flags, the pipeline file
+// or the environment, in that order. pipeline may be nil for commands that do not need one.
+func newDockerClient(pipeline *pkg.Pipeline) (*client.Client, error) {
+	if pipeline == nil {
+		// Commands like du work without a pipeline, but still run against its Docker daemon if there is one
+		if _, err := os.Stat(pipelineFile); err == nil {
+			pipeline, _ = pkg.LoadPipeline(pipelineFile)
+		}
+	}
+
+	var opts []pkg.DockerOption
+	if config != nil {
+		opts = append(opts, pkg.WithDockerEndpoint(pkg.DockerEndpoint{Host: config.Docker.Host}))
+	}
+	if pipeline != nil {
+		opts = append(opts, pkg.WithDockerEndpoint(pipeline.Docker))
+	}
+	opts = append(opts, pkg.WithDockerEndpoint(pkg.DockerEndpoint{
+		Host:      dockerOpts.host,
+		TLSCACert: dockerOpts.tlsCACert,
+		TLSCert:   dockerOpts.tlsCert,
+		TLSKey:    dockerOpts.tlsKey,
+	}))
+	return pkg.NewDockerClient(opts...)
+}
+
+// resolveTargets returns the named tasks of the pipeline, or its root tasks when no names are given.
+func resolveTargets(pipeline *pkg.Pipeline, names []string) ([]*pkg.Task, error) {
+	for _, name := range names {
+		if _, err := pipeline.Target(name); err != nil {
+			return nil, err
+		}
+	}
+	return pipeline.Targets(), nil
+}
@@ -0,0 +1,610 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-units"
+	"github.com/moby/term"
+	"github.com/spf13/cobra"
+)
+
+var runOpts struct {
+	suggestArtifacts  bool
+	artifactStore     string
+	remoteCache       string
+	remoteCacheMode   string
+	helper            string
+	outputMode        string
+	dryRun            bool
+	maxExecs          int
+	maxCopies         int
+	executor          string
+	kubeNamespace     string
+	kubeContext       string
+	keepPods          bool
+	report            string
+	snapshot          bool
+	push              string
+	force             bool
+	noHistory         bool
+	tui               bool
+	quiet             bool
+	verbose           bool
+	color             string
+	cleanup           string
+	gc                bool
+	lockTimeout       time.Duration
+	pullProgress      string
+	timeout           time.Duration
+	compress          string
+	compressLevel     string
+	compressThreshold string
+}
+
+var runCmd = &cobra.Command{
+	Use:   "run [task...]",
+	Short: "Execute tasks of the pipeline (all root tasks if none are given)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pipeline, err := loadPipeline()
+		if err != nil {
+			return err
+		}
+
+		targets, err := resolveTargets(pipeline, args)
+		if err != nil {
+			return err
+		}
+
+		if runOpts.push != "" {
+			// The image of the one target is the deliverable of the run
+			if len(targets) != 1 {
+				return fmt.Errorf("--push needs exactly one target task, got %d", len(targets))
+			}
+			targets[0].OutputImage = runOpts.push
+			targets[0].Push = true
+		}
+
+		if runOpts.remoteCache != "" && runOpts.artifactStore == "" {
+			return fmt.Errorf("--remote-cache requires --artifact-store")
+		}
+
+		if runOpts.artifactStore != "" {
+			store, err := pkg.NewArtifactStore(runOpts.artifactStore)
+			if err != nil {
+				return err
+			}
+			if runOpts.remoteCache != "" {
+				backend, err := pkg.NewRemoteBackend(runOpts.remoteCache)
+				if err != nil {
+					return err
+				}
+				mode, err := pkg.ParseRemoteMode(runOpts.remoteCacheMode)
+				if err != nil {
+					return err
+				}
+				store.SetRemote(backend, mode)
+			}
+			for _, task := range pipeline.Tasks {
+				task.ArtifactStore = store
+			}
+		}
+
+		if runOpts.tui && !cmd.Flags().Changed("output") {
+			// The display of the TUI replaces the configured output
+			runOpts.outputMode = ""
+		}
+		if runOpts.tui && runOpts.outputMode != "" {
+			return fmt.Errorf("--tui cannot be combined with --output")
+		}
+		if runOpts.quiet && runOpts.verbose {
+			return fmt.Errorf("--quiet cannot be combined with --verbose")
+		}
+
+		if runOpts.outputMode == "json" {
+			// stdout only carries the events, the human readable progress goes to stderr
+			events := pkg.NewJSONEventSink(os.Stdout)
+			os.Stdout = os.Stderr
+			for _, task := range pipeline.Tasks {
+				task.Events = events
+			}
+		} else if runOpts.outputMode != "" || (!runOpts.tui && len(reachableTasks(targets)) > 1) {
+			// Tasks may run at the same time, so their output is prefixed with their names by default
+			mode := pkg.OutputPrefixed
+			if runOpts.outputMode != "" {
+				if mode, err = pkg.ParseOutputMode(runOpts.outputMode); err != nil {
+					return err
+				}
+			}
+			colors, err := useColors(runOpts.color)
+			if err != nil {
+				return err
+			}
+			mux := pkg.NewOutputMux(os.Stdout, mode)
+			mux.SetColors(colors)
+			for _, task := range pipeline.Tasks {
+				task.OutputMux = mux
+			}
+		}
+
+		if runOpts.helper != "" {
+			for _, task := range pipeline.Tasks {
+				if task.Helper == "" {
+					task.Helper = runOpts.helper
+				}
+			}
+		}
+
+		if runOpts.dryRun {
+			return printPlan(cmd.Context(), pipeline)
+		}
+
+		switch runOpts.executor {
+		case "docker":
+		case "kubernetes":
+			return runOnKubernetes(cmd.Context(), targets)
+		default:
+			return fmt.Errorf("unknown executor '%s', expected docker or kubernetes", runOpts.executor)
+		}
+
+		if runOpts.snapshot {
+			for _, task := range pipeline.Tasks {
+				task.SnapshotOnFailure = true
+			}
+		}
+
+		if runOpts.cleanup != "" {
+			policy, err := pkg.ParseCleanupPolicy(runOpts.cleanup)
+			if err != nil {
+				return &pkg.ConfigError{Err: err}
+			}
+			for _, task := range pipeline.Tasks {
+				task.Cleanup = policy
+			}
+		}
+
+		pullProgress, err := pkg.ParsePullProgress(runOpts.pullProgress)
+		if err != nil {
+			return &pkg.ConfigError{Err: err}
+		}
+		pkg.SetPullProgress(pullProgress)
+		if err := setCompression(runOpts.compress, runOpts.compressLevel, runOpts.compressThreshold); err != nil {
+			return err
+		}
+
+		cli, err := newDockerClient(pipeline)
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+
+		limits := pipeline.DaemonLimits
+		if runOpts.maxExecs > 0 {
+			limits.MaxExecs = runOpts.maxExecs
+		}
+		if runOpts.maxCopies > 0 {
+			limits.MaxCopies = runOpts.maxCopies
+		}
+		pkg.SetDaemonLimits(cli, limits)
+
+		// Cancelling the run still records which tasks completed
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		statePath := workspace.RunStatePath()
+		if err := reportResumption(ctx, statePath, targets); err != nil {
+			return err
+		}
+
+		started := time.Now()
+		if runOpts.report != "" {
+			// Failed runs are reported as well
+			defer writeReport(runOpts.report, pipeline, targets, started)
+		}
+
+		var opts []pkg.ExecuteOption
+		if runOpts.force {
+			opts = append(opts, pkg.WithForce())
+		}
+		if runOpts.gc {
+			opts = append(opts, pkg.WithGarbageCollection())
+		}
+		if runOpts.lockTimeout > 0 {
+			opts = append(opts, pkg.WithLockTimeout(runOpts.lockTimeout))
+		}
+		if runOpts.timeout > 0 {
+			opts = append(opts, pkg.WithTimeout(runOpts.timeout))
+		}
+		switch {
+		case runOpts.quiet:
+			// Only the output of failing tasks and errors are shown, not the progress of the run
+			opts = append(opts, pkg.WithLogLevel(pkg.LogQuiet), pkg.WithStdout(os.Stdout))
+			restore, err := silenceStdout()
+			if err != nil {
+				return err
+			}
+			defer restore()
+		case runOpts.verbose:
+			opts = append(opts, pkg.WithLogLevel(pkg.LogVerbose))
+		}
+		if runOpts.tui {
+			// Command output reaches the display through the task events
+			opts = append(opts, pkg.WithStdout(io.Discard), pkg.WithStderr(io.Discard))
+			err = runWithTUI(pipeline, targets, stop, func() error { return pipeline.Run(ctx, cli, opts...) })
+		} else {
+			err = pipeline.Run(ctx, cli, opts...)
+		}
+		if !runOpts.noHistory {
+			recordHistory(targets, started, err, ctx.Err() != nil)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				// Another signal terminates right away
+				stop()
+				saveRunState(statePath, targets)
+			}
+			return err
+		}
+
+		if failed := pipeline.AllowedFailures(); len(failed) > 0 {
+			log.Printf("All tasks completed, except %s, which are allowed to fail", taskNames(failed))
+		} else {
+			log.Println("All tasks completed successfully")
+		}
+		if err := pkg.RemoveRunState(statePath); err != nil {
+			return err
+		}
+
+		if runOpts.suggestArtifacts {
+			return suggestArtifacts(ctx, cli, targets)
+		}
+		return nil
+	},
+}
+
+// reportResumption tells how much of an interrupted run of the same targets does not have to execute again
+func reportResumption(ctx context.Context, statePath string, targets []*pkg.Task) error {
+	state, err := pkg.LoadRunState(statePath)
+	if err != nil || state == nil || !state.Matches(targets) {
+		return err
+	}
+
+	steps, err := pkg.PlanTasks(ctx, targets)
+	if err != nil {
+		return err
+	}
+	satisfied, rerun := state.Satisfied(steps)
+	log.Printf("Resuming the run interrupted at %s: %d/%d tasks already satisfied",
+		state.Interrupted.Local().Format(time.DateTime), satisfied, len(steps))
+	if rerun > 0 {
+		log.Printf("%d tasks completed before the interruption execute again, their outputs are not in an artifact store", rerun)
+	}
+	return nil
+}
+
+// saveRunState records the tasks completed before the run was interrupted
+func saveRunState(statePath string, targets []*pkg.Task) {
+	state := pkg.RecordRunState(targets, reachableTasks(targets), time.Now())
+	if err := state.Save(statePath); err != nil {
+		log.Printf("Failed to save the state of the interrupted run: %v", err)
+		return
+	}
+	log.Printf("Run interrupted with %d/%d tasks completed, state saved to %s", state.Completed(), len(state.Steps), statePath)
+}
+
+// recordHistory adds the run of targets to the history of the pipeline. Failing to is only logged, the
+// history is not worth failing a run for.
+func recordHistory(targets []*pkg.Task, started time.Time, runErr error, interrupted bool) {
+	history, err := pkg.OpenHistory(workspace.HistoryPath())
+	if err != nil {
+		log.Printf("Failed to record the run: %v", err)
+		return
+	}
+	defer history.Close()
+
+	run := pkg.NewRunRecord(targets, reachableTasks(targets), started, time.Now(), runErr, interrupted)
+	if err := history.Record(run); err != nil {
+		log.Printf("Failed to record the run: %v", err)
+		return
+	}
+	log.Printf("Recorded as run %d, see buildvault history %d", run.ID, run.ID)
+}
+
+// writeReport writes the run report of targets as HTML or, unless path ends in .html, as JSON. The
+// formats html and json alone write to buildvault-report.html and buildvault-report.json.
+func writeReport(path string, pipeline *pkg.Pipeline, targets []*pkg.Task, started time.Time) {
+	if path == "html" || path == "json" {
+		path = "buildvault-report." + path
+	}
+	report := pkg.BuildReport(reachableTasks(targets), pipeline.Stages, started, time.Now())
+	file, err := os.Create(path)
+	if err != nil {
+		log.Printf("Failed to write the run report: %v", err)
+		return
+	}
+	defer file.Close()
+
+	if strings.HasSuffix(path, ".html") {
+		err = report.WriteHTML(file)
+	} else {
+		err = report.WriteJSON(file)
+	}
+	if err != nil {
+		log.Printf("Failed to write the run report: %v", err)
+		return
+	}
+	log.Printf("Wrote run report to %s", path)
+}
+
+// runOnKubernetes executes the targets as pods in the cluster of the current kubeconfig
+func runOnKubernetes(ctx context.Context, targets []*pkg.Task) error {
+	if runOpts.suggestArtifacts {
+		return fmt.Errorf("--suggest-artifacts is not supported with the kubernetes executor")
+	}
+
+	executor := &pkg.KubernetesExecutor{
+		Namespace: runOpts.kubeNamespace,
+		Context:   runOpts.kubeContext,
+		KeepPods:  runOpts.keepPods,
+	}
+	var opts []pkg.ExecuteOption
+	if runOpts.force {
+		opts = append(opts, pkg.WithForce())
+	}
+	if runOpts.timeout > 0 {
+		opts = append(opts, pkg.WithTimeout(runOpts.timeout))
+	}
+	for _, task := range targets {
+		log.Printf("Executing task '%s' on Kubernetes...", task.Name)
+		if err := executor.Execute(ctx, task, opts...); err != nil {
+			return err
+		}
+	}
+
+	log.Println("All tasks completed successfully")
+	return nil
+}
+
+// printPlan prints which tasks would execute and which would be skipped, in execution order, followed by
+// the waves of the task graph and the tasks no target needs
+func printPlan(ctx context.Context, pipeline *pkg.Pipeline) error {
+	steps, err := pkg.PlanTasks(ctx, pipeline.Targets())
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Execution plan:")
+	for i, step := range steps {
+		hash := step.Hash
+		if hash == "" {
+			hash = "<unknown>"
+		}
+		if step.External {
+			fmt.Printf("%3d. %s: existing container, artifacts are copied from it\n", i+1, step.Task)
+			continue
+		}
+		if step.Skipped {
+			fmt.Printf("%3d. %s: would be skipped, %s\n", i+1, step.Task, step.Reason)
+			continue
+		}
+		if step.Virtual {
+			fmt.Printf("%3d. %s (%s): virtual, re-exports artifacts\n", i+1, step.Task, hash)
+			continue
+		}
+		if step.CacheHit {
+			fmt.Printf("%3d. %s (%s): cached, would be skipped\n", i+1, step.Task, hash)
+			continue
+		}
+		fmt.Printf("%3d. %s (%s): would execute, %s\n", i+1, step.Task, hash, step.Reason)
+		if runOpts.verbose {
+			for _, change := range step.Changes {
+				fmt.Printf("       changed since the last stored run: %s\n", change)
+			}
+		}
+		for _, c := range step.Copies {
+			fmt.Printf("       copy %s from '%s' to %s\n", c.From, c.Task, c.To)
+		}
+		for _, conflict := range step.Conflicts {
+			fmt.Printf("       conflict (%s): %s\n", step.Policy, conflict)
+		}
+	}
+
+	levels, err := pipeline.Levels()
+	if err != nil {
+		return err
+	}
+	fmt.Println("\nExecution waves (tasks of a wave only depend on earlier waves):")
+	for i, level := range levels {
+		fmt.Printf("%3d. %s\n", i+1, taskNames(level))
+	}
+
+	unreachable, err := pipeline.Unreachable()
+	if err != nil {
+		return err
+	}
+	if len(unreachable) > 0 {
+		fmt.Printf("\nNot needed by the targets: %s\n", taskNames(unreachable))
+	}
+	return nil
+}
+
+// taskNames returns the names of tasks separated by commas
+func taskNames(tasks []*pkg.Task) string {
+	var names []string
+	for _, task := range tasks {
+		names = append(names, task.Name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// suggestArtifacts proposes outputs for every executed task and writes accepted ones to the pipeline file
+func suggestArtifacts(ctx context.Context, cli *client.Client, targets []*pkg.Task) error {
+	stdin := bufio.NewReader(os.Stdin)
+
+	for _, task := range reachableTasks(targets) {
+		suggestions, err := pkg.SuggestArtifacts(ctx, cli, task, 5)
+		if err != nil {
+			return err
+		}
+		if len(suggestions) == 0 {
+			continue
+		}
+
+		fmt.Printf("\nSuggested outputs for task '%s':\n", task.Name)
+		var paths []string
+		for _, suggestion := range suggestions {
+			fmt.Printf("  %s (%s)\n", suggestion.Path, units.HumanSize(float64(suggestion.Size)))
+			paths = append(paths, suggestion.Path)
+		}
+
+		fmt.Printf("Add them to '%s' in %s? [y/N] ", task.Name, pipelineFile)
+		answer, _ := stdin.ReadString('\n')
+		if strings.ToLower(strings.TrimSpace(answer)) != "y" {
+			continue
+		}
+		if err := pkg.AddTaskOutputs(pipelineFile, task.Name, paths); err != nil {
+			return err
+		}
+		fmt.Printf("Updated %s\n", pipelineFile)
+	}
+	return nil
+}
+
+// runWithTUI calls run while an interactive display shows the progress of the tasks of targets. Stopping
+// the run from the display calls stop. The human readable output of the run would garble the display, so
+// it is discarded, the display shows command output and errors instead.
+func runWithTUI(pipeline *pkg.Pipeline, targets []*pkg.Task, stop func(), run func() error) error {
+	ui := pkg.NewTUI(reachableTasks(targets), os.Stdout, stop)
+	for _, task := range pipeline.Tasks {
+		task.Events = ui
+	}
+	restore, err := silenceStdout()
+	if err != nil {
+		return err
+	}
+	defer restore()
+
+	done := make(chan error, 1)
+	go func() {
+		err := run()
+		ui.Finish(err)
+		done <- err
+	}()
+	uiErr := ui.Run()
+	// A broken display does not stop the run
+	if err := <-done; err != nil {
+		return err
+	}
+	return uiErr
+}
+
+// silenceStdout discards what is printed to os.Stdout until restore is called. Writers that were handed
+// os.Stdout before keep writing to the terminal.
+func silenceStdout() (restore func(), err error) {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	stdout := os.Stdout
+	os.Stdout = devNull
+	return func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}, nil
+}
+
+// useColors decides from the --color flag whether task names in command output are colored
+func useColors(mode string) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return os.Getenv("NO_COLOR") == "" && term.IsTerminal(os.Stdout.Fd()), nil
+	}
+	return false, fmt.Errorf("unknown color mode '%s', expected auto, always or never", mode)
+}
+
+// reachableTasks returns the given tasks and all their transitive dependencies, dependencies first
+func reachableTasks(targets []*pkg.Task) []*pkg.Task {
+	var result []*pkg.Task
+	seen := map[*pkg.Task]bool{}
+	var visit func(t *pkg.Task)
+	visit = func(t *pkg.Task) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		for _, dependency := range t.Dependencies {
+			visit(dependency.Task)
+		}
+		result = append(result, t)
+	}
+
+	for _, task := range targets {
+		visit(task)
+	}
+	return result
+}
+
+func init() {
+	runCmd.Flags().BoolVar(&runOpts.suggestArtifacts, "suggest-artifacts", false, "propose output declarations for executed tasks based on the files they created")
+	runCmd.Flags().StringVar(&runOpts.artifactStore, "artifact-store", "", "directory to save declared outputs to; tasks already stored there are not executed again")
+	runCmd.Flags().StringVar(&runOpts.remoteCache, "remote-cache", "", "shared cache for the artifact store (http(s)://, s3://bucket/prefix or gs://bucket/prefix)")
+	runCmd.Flags().StringVar(&runOpts.remoteCacheMode, "remote-cache-mode", "rw", "remote cache mode: ro (download only) or rw (download and upload)")
+	runCmd.Flags().StringVar(&runOpts.helper, "helper", "", "static helper binary (busybox or buildvault-helper) injected into containers of tasks without their own helper")
+	runCmd.Flags().StringVar(&runOpts.outputMode, "output", "", "combine command output of tasks: grouped (one block per task) or prefixed (lines prefixed with the task name, the default for more than one task); json writes events as JSON lines to stdout and everything else to stderr")
+	runCmd.Flags().BoolVar(&runOpts.dryRun, "dry-run", false, "print the execution plan (cache hits, execution order, artifact copies) without running anything")
+	runCmd.Flags().IntVar(&runOpts.maxExecs, "max-execs", 0, fmt.Sprintf("maximum concurrent execs on the Docker daemon (default %d)", pkg.DefaultMaxExecs))
+	runCmd.Flags().IntVar(&runOpts.maxCopies, "max-copies", 0, fmt.Sprintf("maximum concurrent archive copies on the Docker daemon (default %d)", pkg.DefaultMaxCopies))
+	runCmd.Flags().StringVar(&runOpts.executor, "executor", "docker", "where tasks run: docker or kubernetes (pods through kubectl)")
+	runCmd.Flags().StringVar(&runOpts.kubeNamespace, "kube-namespace", "", "namespace of task pods with the kubernetes executor")
+	runCmd.Flags().StringVar(&runOpts.kubeContext, "kube-context", "", "kubeconfig context with the kubernetes executor")
+	runCmd.Flags().BoolVar(&runOpts.keepPods, "keep-pods", false, "keep task pods after execution with the kubernetes executor")
+	runCmd.Flags().StringVar(&runOpts.report, "report", "", "write a report of the run grouped by stage to this file, as HTML if it ends in .html and JSON otherwise; html or json alone write buildvault-report.html or .json")
+	runCmd.Flags().StringVar(&runOpts.push, "push", "", "commit the container of the target task to this image reference and push it to its registry")
+	runCmd.Flags().BoolVarP(&runOpts.quiet, "quiet", "q", false, "only show the command output of failing tasks and errors")
+	runCmd.Flags().BoolVarP(&runOpts.verbose, "verbose", "v", false, "also show the hash of every task and the exit code and duration of every command")
+	runCmd.Flags().StringVar(&runOpts.color, "color", "auto", "color the task names in command output: auto (if stdout is a terminal), always or never")
+	runCmd.Flags().BoolVar(&runOpts.tui, "tui", false, "show the progress of the tasks in an interactive terminal UI instead of their output")
+	runCmd.Flags().BoolVar(&runOpts.noHistory, "no-history", false, "do not record the run in the history")
+	runCmd.Flags().BoolVar(&runOpts.force, "force", false, "execute tasks even if their outputs are in the artifact store")
+	runCmd.Flags().DurationVar(&runOpts.timeout, "timeout", 0, "cancel the run once it takes longer than this (e.g. 30m)")
+	runCmd.Flags().StringVar(&runOpts.cleanup, "cleanup", "", "cleanup policy of all tasks, overriding the pipeline file: keep-always, keep-on-failure or remove-always")
+	runCmd.Flags().BoolVar(&runOpts.gc, "gc", false, "remove containers of earlier definitions of the tasks before running them")
+	runCmd.Flags().StringVar(&runOpts.pullProgress, "pull-progress", "auto", "how image pulls show their progress: auto (bars on a terminal, lines otherwise), lines or quiet")
+	runCmd.Flags().DurationVar(&runOpts.lockTimeout, "lock-timeout", 0, "fail tasks another run is still executing after waiting this long for it, 0 waits as long as it takes")
+	runCmd.Flags().StringVar(&runOpts.compress, "compress", "none", "compress artifact copies into containers and uploads to the remote cache: none, gzip or zstd (trades CPU for throughput to remote daemons and caches)")
+	runCmd.Flags().StringVar(&runOpts.compressLevel, "compress-level", "default", "compression level: fastest, default or best")
+	runCmd.Flags().StringVar(&runOpts.compressThreshold, "compress-threshold", units.BytesSize(pkg.DefaultCompressionThreshold), "artifacts smaller than this are transferred uncompressed")
+	runCmd.Flags().BoolVar(&runOpts.snapshot, "snapshot-on-failure", false, "commit the container of a task whose command failed to a buildvault-debug/<task>:<hash> image")
+	rootCmd.AddCommand(runCmd)
+}
+
+// setCompression configures the compression of artifact transfers from the values of the compression flags
+func setCompression(algorithm, level, threshold string) error {
+	var opts pkg.CompressionOptions
+	var err error
+	if opts.Algorithm, err = pkg.ParseCompression(algorithm); err != nil {
+		return &pkg.ConfigError{Err: err}
+	}
+	if opts.Level, err = pkg.ParseCompressionLevel(level); err != nil {
+		return &pkg.ConfigError{Err: err}
+	}
+	if opts.Threshold, err = units.RAMInBytes(threshold); err != nil {
+		return &pkg.ConfigError{Err: fmt.Errorf("invalid compression threshold '%s': %w", threshold, err)}
+	}
+	pkg.SetCompression(opts)
+	return nil
+}
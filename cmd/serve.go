@@ -0,0 +1,194 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+var serveOpts struct {
+	addr          string
+	grpcAddr      string
+	token         string
+	insecure      bool
+	hostAccess    bool
+	pipelines     []string
+	artifactStore string
+	history       string
+	noHistory     bool
+	triggers      string
+	webhookSecret string
+	schedules     string
+	scheduleState string
+	maxRuns       int
+	keepRuns      int
+	groupLimits   []string
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run buildvault as a build service with an HTTP and gRPC API to submit pipelines and start, watch and cancel runs",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token := serveOpts.token
+		if token == "" {
+			token = os.Getenv("BUILDVAULT_TOKEN")
+		}
+		if token == "" && !serveOpts.insecure {
+			return &pkg.ConfigError{Err: errors.New("the API needs --token or $BUILDVAULT_TOKEN, or --insecure to serve it without authentication")}
+		}
+
+		cli, err := newDockerClient(nil)
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+
+		var history *pkg.History
+		if !serveOpts.noHistory {
+			if history, err = pkg.OpenHistory(serveOpts.history); err != nil {
+				return err
+			}
+			defer history.Close()
+		}
+
+		server := pkg.NewServer(cli, history)
+		defer server.Close()
+		server.MaxRuns = serveOpts.maxRuns
+		server.KeepRuns = serveOpts.keepRuns
+		server.AllowHostAccess = serveOpts.hostAccess
+		server.GroupLimits = map[string]int{}
+		for _, limit := range serveOpts.groupLimits {
+			group, value, ok := strings.Cut(limit, "=")
+			n, err := strconv.Atoi(value)
+			if !ok || err != nil {
+				return &pkg.ConfigError{Err: fmt.Errorf("invalid group limit '%s', expected GROUP=N", limit)}
+			}
+			server.GroupLimits[group] = n
+		}
+		if serveOpts.artifactStore != "" {
+			if server.ArtifactStore, err = pkg.NewArtifactStore(serveOpts.artifactStore); err != nil {
+				return err
+			}
+		}
+		for _, pipeline := range serveOpts.pipelines {
+			name, path, ok := strings.Cut(pipeline, "=")
+			if !ok {
+				return &pkg.ConfigError{Err: fmt.Errorf("invalid pipeline '%s', expected NAME=PATH", pipeline)}
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return &pkg.ConfigError{Err: fmt.Errorf("error reading pipeline file: %w", err)}
+			}
+			if err := server.SubmitPipeline(name, data, filepath.Base(path)); err != nil {
+				return fmt.Errorf("pipeline '%s': %w", name, err)
+			}
+		}
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		if serveOpts.schedules != "" {
+			schedules, err := pkg.LoadSchedules(serveOpts.schedules)
+			if err != nil {
+				return err
+			}
+			scheduler, err := pkg.NewScheduler(server, schedules, serveOpts.scheduleState)
+			if err != nil {
+				return err
+			}
+			go scheduler.Run(ctx)
+		}
+
+		handler := server.Handler(token)
+		if serveOpts.triggers != "" {
+			triggers, err := pkg.LoadTriggers(serveOpts.triggers)
+			if err != nil {
+				return err
+			}
+			secret := serveOpts.webhookSecret
+			if secret == "" {
+				secret = os.Getenv("BUILDVAULT_WEBHOOK_SECRET")
+			}
+			if secret == "" {
+				return &pkg.ConfigError{Err: errors.New("--triggers needs --webhook-secret or $BUILDVAULT_WEBHOOK_SECRET to verify deliveries")}
+			}
+			webhooks, err := pkg.NewWebhooks(server, secret, triggers)
+			if err != nil {
+				return err
+			}
+			// Webhooks authenticate with their signature instead of the bearer token
+			mux := http.NewServeMux()
+			mux.Handle("/webhooks/", http.StripPrefix("/webhooks", webhooks.Handler()))
+			mux.Handle("/", handler)
+			handler = mux
+		}
+
+		httpServer := &http.Server{Addr: serveOpts.addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+		var grpcServer *grpc.Server
+		if serveOpts.grpcAddr != "" {
+			listener, err := net.Listen("tcp", serveOpts.grpcAddr)
+			if err != nil {
+				return fmt.Errorf("error listening for the gRPC API: %w", err)
+			}
+			grpcServer = server.GRPCServer(token)
+			go func() {
+				if err := grpcServer.Serve(listener); err != nil {
+					log.Printf("Error serving the gRPC API: %v", err)
+				}
+			}()
+			log.Printf("Serving the gRPC API on %s", serveOpts.grpcAddr)
+		}
+		go func() {
+			<-ctx.Done()
+			// Event streams only end with their runs, which Close cancels
+			server.Close()
+			if grpcServer != nil {
+				grpcServer.GracefulStop()
+			}
+			httpServer.Shutdown(context.Background())
+		}()
+		if token == "" {
+			log.Printf("Serving the API on %s without authentication", serveOpts.addr)
+		} else {
+			log.Printf("Serving the API on %s", serveOpts.addr)
+		}
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return fmt.Errorf("error serving the API: %w", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveOpts.addr, "addr", "localhost:8080", "address to serve the API on")
+	serveCmd.Flags().StringVar(&serveOpts.grpcAddr, "grpc-addr", "", "address to also serve the gRPC API on, none if empty")
+	serveCmd.Flags().StringVar(&serveOpts.token, "token", "", "bearer token clients have to authenticate with, defaults to $BUILDVAULT_TOKEN")
+	serveCmd.Flags().BoolVar(&serveOpts.insecure, "insecure", false, "serve the API without a token, letting anyone who reaches it run pipelines")
+	serveCmd.Flags().BoolVar(&serveOpts.hostAccess, "allow-host-access", false, "accept pipelines with bind mounts, privileged tasks or secrets read from host files")
+	serveCmd.Flags().StringArrayVar(&serveOpts.pipelines, "pipeline", nil, "submit a pipeline file on startup, as NAME=PATH (repeatable)")
+	serveCmd.Flags().StringVar(&serveOpts.artifactStore, "artifact-store", "", "directory to save declared outputs of all runs to; tasks already stored there are not executed again")
+	serveCmd.Flags().StringVar(&serveOpts.history, "history", filepath.Join(".buildvault", "history.db"), "database to record the runs in")
+	serveCmd.Flags().BoolVar(&serveOpts.noHistory, "no-history", false, "do not record the runs in a history")
+	serveCmd.Flags().StringVar(&serveOpts.triggers, "triggers", "", "YAML file of triggers starting runs for pushes posted to /webhooks/github and /webhooks/gitlab")
+	serveCmd.Flags().StringVar(&serveOpts.webhookSecret, "webhook-secret", "", "secret webhook deliveries are signed with, defaults to $BUILDVAULT_WEBHOOK_SECRET; required with --triggers")
+	serveCmd.Flags().StringVar(&serveOpts.schedules, "schedules", "", "YAML file of schedules starting runs on cron expressions")
+	serveCmd.Flags().StringVar(&serveOpts.scheduleState, "schedule-state", filepath.Join(".buildvault", "schedules.json"), "file to keep when the schedules last fired in, so missed runs start after a restart")
+	serveCmd.Flags().IntVar(&serveOpts.maxRuns, "max-runs", 0, "runs executing at once, more are queued; 0 for no limit")
+	serveCmd.Flags().IntVar(&serveOpts.keepRuns, "keep-runs", pkg.DefaultKeepRuns, "finished runs kept in memory to be listed and watched, older ones only remain in the history")
+	serveCmd.Flags().StringArrayVar(&serveOpts.groupLimits, "group-limit", nil, "runs of a concurrency group executing at once as GROUP=N, 1 for groups not listed (repeatable)")
+	rootCmd.AddCommand(serveCmd)
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var shellOpts struct {
+	snapshot bool
+}
+
+var shellCmd = &cobra.Command{
+	Use:   "shell <task>",
+	Short: "Open an interactive shell in the preserved container of a task, e.g. after it failed",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pipeline, err := loadPipeline()
+		if err != nil {
+			return err
+		}
+		targets, err := resolveTargets(pipeline, args)
+		if err != nil {
+			return err
+		}
+
+		cli, err := newDockerClient(pipeline)
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+
+		if shellOpts.snapshot {
+			return targets[0].DebugSnapshot(cmd.Context(), cli)
+		}
+		return targets[0].Debug(cmd.Context(), cli)
+	},
+}
+
+func init() {
+	shellCmd.Flags().BoolVar(&shellOpts.snapshot, "snapshot", false, "open the shell in a new container of the latest snapshot taken by run --snapshot-on-failure instead")
+	rootCmd.AddCommand(shellCmd)
+}
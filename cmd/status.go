@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+var statusOpts struct {
+	artifactStore string
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status [task...]",
+	Short: "Show which tasks are cached or stale, how they last ran and whether their containers exist",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pipeline, err := loadPipeline()
+		if err != nil {
+			return err
+		}
+		if _, err := resolveTargets(pipeline, args); err != nil {
+			return err
+		}
+		if statusOpts.artifactStore != "" {
+			store, err := pkg.NewArtifactStore(statusOpts.artifactStore)
+			if err != nil {
+				return err
+			}
+			for _, task := range pipeline.Tasks {
+				task.ArtifactStore = store
+			}
+		}
+
+		cli, err := newDockerClient(pipeline)
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+
+		var history *pkg.History
+		if _, err := os.Stat(workspace.HistoryPath()); err == nil {
+			if history, err = pkg.OpenHistory(workspace.HistoryPath()); err != nil {
+				return err
+			}
+			defer history.Close()
+		}
+
+		statuses, err := pipeline.Status(cmd.Context(), cli, history)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TASK\tSTATE\tLAST RUN\tLAST STATUS\tCONTAINER")
+		for _, status := range statuses {
+			lastRun, lastStatus := "never", "-"
+			if status.LastRun != nil {
+				lastRun = units.HumanDuration(time.Since(status.LastRun.Started)) + " ago"
+				lastStatus = status.LastRun.Status
+				if status.LastRun.ExitCode != 0 {
+					lastStatus += fmt.Sprintf(" (exit code %d)", status.LastRun.ExitCode)
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", status.Task, taskState(status), lastRun, lastStatus, containerState(status))
+		}
+		return w.Flush()
+	},
+}
+
+// taskState summarizes whether the task would execute
+func taskState(status pkg.TaskStatus) string {
+	switch {
+	case status.External:
+		return "external"
+	case status.Skipped:
+		return "skipped"
+	case status.Virtual:
+		return "virtual"
+	case status.CacheHit:
+		return "cached"
+	}
+	return "stale, " + status.Reason
+}
+
+// containerState describes the preserved container of the current hash of the task
+func containerState(status pkg.TaskStatus) string {
+	switch {
+	case status.Container != "":
+		return fmt.Sprintf("%s (%s)", status.Container, status.ContainerState)
+	case status.Pruned:
+		return "pruned"
+	}
+	return "-"
+}
+
+func init() {
+	statusCmd.Flags().StringVar(&statusOpts.artifactStore, "artifact-store", "", "directory of the artifact store to look up cached outputs in")
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/spf13/cobra"
+)
+
+var validateSchema bool
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the pipeline file for errors without executing anything",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if validateSchema {
+			_, err := os.Stdout.Write(pkg.PipelineSchema())
+			return err
+		}
+
+		problems, err := pkg.ValidatePipelineFile(pipelineFile)
+		if err != nil {
+			return err
+		}
+		if len(problems) == 0 {
+			fmt.Printf("%s is valid\n", pipelineFile)
+			return nil
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", pipelineFile, problem)
+		}
+		return fmt.Errorf("%s has %d problems", pipelineFile, len(problems))
+	},
+}
+
+func init() {
+	validateCmd.Flags().BoolVar(&validateSchema, "schema", false, "print the JSON Schema of YAML pipeline files instead")
+	rootCmd.AddCommand(validateCmd)
+}
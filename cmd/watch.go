@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	pkg "github.com/benjaminstrasser/buildvault/pkg"
+	"github.com/spf13/cobra"
+)
+
+var watchOpts struct {
+	artifactStore string
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [task...]",
+	Short: "Execute tasks, then execute the ones affected by changes to their host inputs again (all root tasks if none are given)",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pipeline, err := loadPipeline()
+		if err != nil {
+			return err
+		}
+		if _, err := resolveTargets(pipeline, args); err != nil {
+			return err
+		}
+
+		if watchOpts.artifactStore != "" {
+			store, err := pkg.NewArtifactStore(watchOpts.artifactStore)
+			if err != nil {
+				return err
+			}
+			for _, task := range pipeline.Tasks {
+				task.ArtifactStore = store
+			}
+		}
+
+		cli, err := newDockerClient(pipeline)
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+		pkg.SetDaemonLimits(cli, pipeline.DaemonLimits)
+
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return pipeline.Watch(ctx, cli)
+	},
+}
+
+func init() {
+	watchCmd.Flags().StringVar(&watchOpts.artifactStore, "artifact-store", "", "directory to save declared outputs to; tasks already stored there are not executed again")
+	rootCmd.AddCommand(watchCmd)
+}
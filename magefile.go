@@ -111,6 +111,14 @@ func Build() error {
 	return cmd.Run()
 }
 
+// BuildHelper builds the static helper binary injected into containers of minimal images
+func BuildHelper() error {
+	fmt.Println("Building helper...")
+	cmd := exec.Command("go", "build", "-o", "buildvault-helper", "./cmd/buildvault-helper")
+	cmd.Env = append(os.Environ(), "CGO_ENABLED=0", "GOOS=linux")
+	return cmd.Run()
+}
+
 // A custom install step if you need your bin someplace other than go/bin
 func Install() error {
 	mg.Deps(Build)
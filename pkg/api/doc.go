@@ -0,0 +1,5 @@
+// Package api is the gRPC API of buildvault serve, generated from buildvault.proto. Clients connect with
+// NewBuildVaultClient; the server side is implemented by pkg.Server.
+package api
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative buildvault.proto
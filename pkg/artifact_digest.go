@@ -0,0 +1,213 @@
+package pkg
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Artifact digests cover the relative names and contents of the regular files below an artifact path.
+// The same digest is computed inside a container (sha256sum of every file) or on the host from a tar
+// stream, so large outputs can be hashed without copying them out of their container.
+
+// fileListDigest combines per-file digests, keyed by name relative to the parent of the artifact path
+func fileListDigest(files map[string]string) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hasher := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hasher, "%s\x00%s\x00", name, files[name])
+	}
+	return hex.EncodeToString(hasher.Sum(nil))
+}
+
+// digestTar digests a tar stream as returned by CopyFromContainer, ignoring timestamps and ownership
+func digestTar(r io.Reader) (string, error) {
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("error reading archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		hasher := sha256.New()
+		if _, err := io.Copy(hasher, tr); err != nil {
+			return "", fmt.Errorf("error reading archive: %w", err)
+		}
+		files[strings.TrimPrefix(path.Clean(header.Name), "./")] = hex.EncodeToString(hasher.Sum(nil))
+	}
+
+	return fileListDigest(files), nil
+}
+
+// parseChecksums parses sha256sum output into per-file digests
+func parseChecksums(output string) (map[string]string, error) {
+	files := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line == "" {
+			continue
+		}
+		digest, name, ok := strings.Cut(line, "  ")
+		if !ok || len(digest) != 64 || strings.HasPrefix(digest, "\\") {
+			// sha256sum escapes unusual file names, which cannot be matched to archive entries
+			return nil, fmt.Errorf("unexpected sha256sum output line %q", line)
+		}
+		files[strings.TrimPrefix(path.Clean(name), "./")] = digest
+	}
+	return files, nil
+}
+
+// shellQuote quotes s as a single word for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// digestInContainer computes the artifact digest of p inside the running task container, using the
+// helper binary if the task has one and sha256sum from the image otherwise
+func (t *Task) digestInContainer(ctx context.Context, cli ExecAPI, p string) (string, error) {
+	files, err := t.checksumsInContainer(ctx, cli, p)
+	if err != nil {
+		return "", err
+	}
+	return fileListDigest(files), nil
+}
+
+// checksumsInContainer returns the digests of the regular files below p inside the running task
+// container by their paths relative to the parent directory of p
+func (t *Task) checksumsInContainer(ctx context.Context, cli ExecAPI, p string) (map[string]string, error) {
+	dir, base := path.Dir(p), path.Base(p)
+
+	var commands [][]string
+	if t.Helper != "" {
+		commands = append(commands, []string{helperPath, "sha256sum", "-r", base})
+	}
+	commands = append(commands, t.shellCommand(fmt.Sprintf("find %s -type f -exec sha256sum {} +", shellQuote(base))))
+
+	var lastErr error
+	for _, cmd := range commands {
+		output, err := runInContainer(ctx, cli, t.containerID, dir, cmd)
+		if err != nil {
+			// e.g. busybox as helper does not support sha256sum -r
+			lastErr = err
+			continue
+		}
+		files, err := parseChecksums(output)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return files, nil
+	}
+	return nil, fmt.Errorf("error hashing %s in container of task '%s': %w", p, t.Name, lastErr)
+}
+
+// digestOutputs hashes the declared outputs inside the task container, so dependents can use the
+// digests without streaming the outputs. Outputs that cannot be hashed in the container are left
+// to the host-side fallback.
+func (t *Task) digestOutputs(ctx context.Context, cli ExecAPI) {
+	t.outputDigests = map[string]string{}
+	t.outputSizes = map[string]int64{}
+	if t.withoutTools() {
+		// Nothing in the container can hash, the outputs are hashed when they are copied
+		return
+	}
+	for _, output := range t.declaredOutputs() {
+		files, err := t.checksumsInContainer(ctx, cli, output)
+		if err != nil {
+			fmt.Printf("Hashing outputs in the container failed, falling back to copying them: %v\n", err)
+			return
+		}
+		t.outputDigests[output] = fileListDigest(files)
+		size, err := t.sizeInContainer(ctx, cli, path.Dir(output), slices.Sorted(maps.Keys(files)))
+		if err != nil {
+			fmt.Printf("Measuring output %s of task '%s' failed, its size is not recorded: %v\n", output, t.Name, err)
+			continue
+		}
+		t.outputSizes[output] = size
+	}
+}
+
+// artifactDigest returns the digest of an artifact of an executed dependency. Digests computed in the
+// dependency's container or recorded in its artifact store are used when available; anything else is
+// streamed out of the container or store and hashed on the host.
+func artifactDigest(ctx context.Context, cli DockerAPI, dependency *Task, from string) (string, error) {
+	dependency, from, err := resolveArtifactSource(dependency, from)
+	if err != nil {
+		return "", err
+	}
+
+	if digest, ok, err := knownArtifactDigest(ctx, dependency, from); err != nil || ok {
+		return digest, err
+	}
+
+	release, err := acquireCopy(ctx, cli)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	reader, err := openDependencyArtifact(ctx, cli, dependency, from)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	digest, err := digestTar(reader)
+	if err != nil {
+		return "", fmt.Errorf("error hashing artifact %s of task '%s': %w", from, dependency.Name, err)
+	}
+	return digest, nil
+}
+
+// knownArtifactDigest returns the digest of an artifact of an executed, non-virtual dependency if it was
+// computed in the dependency's container or recorded in its artifact store
+func knownArtifactDigest(ctx context.Context, dependency *Task, from string) (string, bool, error) {
+	if digest, ok := dependency.outputDigests[from]; ok {
+		return digest, true, nil
+	}
+
+	if dependency.containerID == "" && dependency.ArtifactStore != nil {
+		manifest, ok, err := dependency.ArtifactStore.Lookup(ctx, dependency.generateHash())
+		if err != nil {
+			return "", false, err
+		}
+		if ok {
+			for _, artifact := range manifest.Artifacts {
+				if artifact.Path == from && artifact.ContentDigest != "" {
+					return artifact.ContentDigest, true, nil
+				}
+			}
+		}
+	}
+	return "", false, nil
+}
+
+// digestBlob computes the artifact digest of a tar blob in the store
+func (s *ArtifactStore) digestBlob(digest string) (string, error) {
+	blob, err := os.Open(s.blobPath(digest))
+	if err != nil {
+		return "", fmt.Errorf("error opening stored artifact: %w", err)
+	}
+	defer blob.Close()
+	return digestTar(blob)
+}
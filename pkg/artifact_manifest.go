@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// After a task executed, the digests and sizes of its declared outputs are recorded with the run in the
+// history and can be exported as an artifact manifest. When a dependent copies an artifact whose digest
+// is known, the stream is digested on its way into the dependent's container and compared, so an
+// artifact corrupted in the producer, the store or in transit fails the copy instead of the build.
+
+// ErrArtifactCorrupted is returned when a copied artifact does not match the digest its producer recorded.
+var ErrArtifactCorrupted = errors.New("artifact corrupted")
+
+// ArtifactChecksum is the digest and size of a declared output of a task.
+type ArtifactChecksum struct {
+	Path   string `json:"path"`
+	Digest string `json:"sha256"`         // SHA-256 over the names and contents of the files below Path
+	Size   int64  `json:"size,omitempty"` // Total size of the files in bytes, absent if it was not computed
+}
+
+// ArtifactManifest lists the checksums of the declared outputs of a task after it executed.
+type ArtifactManifest struct {
+	Task      string             `json:"task"`
+	Hash      string             `json:"hash"`
+	Artifacts []ArtifactChecksum `json:"artifacts"`
+}
+
+// ArtifactManifest returns the checksums of the outputs of the recorded task, sorted by path.
+func (r TaskRecord) ArtifactManifest() ArtifactManifest {
+	manifest := ArtifactManifest{Task: r.Name, Hash: r.Hash, Artifacts: []ArtifactChecksum{}}
+	for output, digest := range r.Artifacts {
+		manifest.Artifacts = append(manifest.Artifacts, ArtifactChecksum{Path: output, Digest: digest, Size: r.Sizes[output]})
+	}
+	sort.Slice(manifest.Artifacts, func(i, j int) bool { return manifest.Artifacts[i].Path < manifest.Artifacts[j].Path })
+	return manifest
+}
+
+// statBatchSize is the number of files stat is run on at once, keeping the command line short
+const statBatchSize = 500
+
+// sizeInContainer returns the total size of files, relative to dir, in the running task container. They
+// are the files just hashed, so only their metadata is read, with the stat applet of the helper if the
+// task has one and stat from the image otherwise.
+func (t *Task) sizeInContainer(ctx context.Context, cli ExecAPI, dir string, files []string) (int64, error) {
+	var size int64
+	for batch := range slices.Chunk(files, statBatchSize) {
+		cmd := append([]string{"stat", "-c", "%s", "--"}, batch...)
+		if t.Helper != "" {
+			cmd = append([]string{helperPath, "stat"}, batch...)
+		}
+		output, err := runInContainer(ctx, cli, t.containerID, dir, cmd)
+		if err != nil {
+			return 0, err
+		}
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			// The helper prints path, type, mode and size, stat only the size
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				return 0, fmt.Errorf("unexpected stat output %q", output)
+			}
+			n, err := strconv.ParseInt(fields[len(fields)-1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("unexpected stat output line %q", line)
+			}
+			size += n
+		}
+	}
+	return size, nil
+}
+
+// expectedArtifactDigest returns the digest an artifact of dependency is verified against when it is
+// copied, if one is known
+func expectedArtifactDigest(ctx context.Context, dependency *Task, from string) (string, bool, error) {
+	source, sourcePath, err := resolveArtifactSource(dependency, from)
+	if err != nil {
+		return "", false, err
+	}
+	if path.Base(sourcePath) != path.Base(from) {
+		// A virtual task re-exports the artifact under another name, which changes its digest
+		return "", false, nil
+	}
+	return knownArtifactDigest(ctx, source, sourcePath)
+}
+
+// artifactVerifier digests the tar stream of an artifact while it is read
+type artifactVerifier struct {
+	reader io.Reader
+	pipe   *io.PipeWriter
+	result chan verifiedDigest
+}
+
+type verifiedDigest struct {
+	digest string
+	err    error
+}
+
+func newArtifactVerifier(r io.Reader) *artifactVerifier {
+	pr, pw := io.Pipe()
+	v := &artifactVerifier{reader: io.TeeReader(r, pw), pipe: pw, result: make(chan verifiedDigest, 1)}
+	go func() {
+		digest, err := digestTar(pr)
+		if err != nil {
+			// The copy fails with the error on its next read
+			pr.CloseWithError(err)
+		} else {
+			// Padding after the end of the archive
+			io.Copy(io.Discard, pr)
+		}
+		v.result <- verifiedDigest{digest, err}
+	}()
+	return v
+}
+
+func (v *artifactVerifier) Read(p []byte) (int, error) {
+	return v.reader.Read(p)
+}
+
+// verify digests what the copy left of the stream and compares the digest of the whole stream to expected
+func (v *artifactVerifier) verify(expected string) error {
+	_, err := io.Copy(io.Discard, v.reader)
+	v.pipe.CloseWithError(err)
+	result := <-v.result
+	if result.err != nil {
+		return fmt.Errorf("error digesting artifact: %w", result.err)
+	}
+	if err != nil {
+		return fmt.Errorf("error digesting artifact: %w", err)
+	}
+	if result.digest != expected {
+		return fmt.Errorf("%w: sha256 %s, expected %s", ErrArtifactCorrupted, result.digest, expected)
+	}
+	return nil
+}
+
+// close stops digesting, e.g. after the copy failed
+func (v *artifactVerifier) close() {
+	v.pipe.Close()
+}
@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+)
+
+// artifactTasks returns a task producing /work/out/app with the given content and a task consuming
+// it. The checksum the producer reports for its output is the one of reported.
+func artifactTasks(t *testing.T, content, reported string) (*dockertest.Fake, *Task, *Task) {
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:3.20"}, Files: map[string]string{"/bin/sh": ""}})
+	cli.Exec = func(e *dockertest.Exec) int {
+		switch command := e.Cmd[len(e.Cmd)-1]; {
+		case command == "build":
+			if err := e.Container.WriteFile("/work/out/app", []byte(content), 0o755); err != nil {
+				t.Errorf("Failed to write output: %v", err)
+			}
+		case strings.Contains(command, "sha256sum"):
+			fmt.Fprintf(e.Stdout, "%x  out/app\n", sha256.Sum256([]byte(reported)))
+		case e.Cmd[0] == "stat":
+			fmt.Fprintf(e.Stdout, "%d\n", len(reported))
+		}
+		return dockertest.Builtins(e)
+	}
+
+	build := &Task{Name: "build", BaseImage: "alpine:3.20", Commands: []string{"build"}, Outputs: []string{"/work/out"}}
+	consume := &Task{
+		Name:         "consume",
+		BaseImage:    "alpine:3.20",
+		Commands:     []string{"echo consuming"},
+		Dependencies: []Dependency{{Task: build, Artifacts: []Artifact{{From: "/work/out", To: "/input/out"}}}},
+	}
+	return cli, build, consume
+}
+
+func TestArtifactManifest(t *testing.T) {
+	cli, build, consume := artifactTasks(t, "binary", "binary")
+	if err := consume.Execute(context.Background(), cli); err != nil {
+		t.Fatalf("Failed to execute tasks: %v", err)
+	}
+	c, _ := cli.Container(consume.generateContainerName())
+	if data, err := c.ReadFile("/input/out/app"); err != nil || string(data) != "binary" {
+		t.Errorf("Unexpected artifact %q: %v", data, err)
+	}
+
+	run := NewRunRecord([]*Task{consume}, []*Task{build, consume}, time.Now(), time.Now(), nil, false)
+	manifest := run.Tasks[0].ArtifactManifest()
+	want := ArtifactManifest{Task: "build", Hash: build.generateHash(), Artifacts: []ArtifactChecksum{
+		{Path: "/work/out", Digest: fileListDigest(map[string]string{"out/app": fmt.Sprintf("%x", sha256.Sum256([]byte("binary")))}), Size: 6},
+	}}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Errorf("Expected manifest %+v, got %+v", want, manifest)
+	}
+	if manifest := run.Tasks[1].ArtifactManifest(); len(manifest.Artifacts) != 0 {
+		t.Errorf("Expected no artifacts of a task without outputs, got %+v", manifest.Artifacts)
+	}
+}
+
+func TestCorruptedArtifact(t *testing.T) {
+	// The output changed after it was digested
+	cli, _, consume := artifactTasks(t, "tampered", "binary")
+	err := consume.Execute(context.Background(), cli)
+	if !errors.Is(err, ErrArtifactCorrupted) {
+		t.Fatalf("Expected the corrupted artifact to fail the copy, got %v", err)
+	}
+}
+
+func TestArtifactSizeWithHelper(t *testing.T) {
+	cli, build, consume := artifactTasks(t, "binary", "binary")
+	build.Helper = filepath.Join(t.TempDir(), "buildvault-helper")
+	if err := os.WriteFile(build.Helper, []byte("helper"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	exec := cli.Exec
+	cli.Exec = func(e *dockertest.Exec) int {
+		switch {
+		case slices.Equal(e.Cmd[:2], []string{helperPath, "sha256sum"}):
+			fmt.Fprintf(e.Stdout, "%x  out/app\n", sha256.Sum256([]byte("binary")))
+			return 0
+		case slices.Equal(e.Cmd[:2], []string{helperPath, "stat"}):
+			// Path, type, mode and size
+			fmt.Fprintf(e.Stdout, "%s file 755 %d\n", e.Cmd[2], len("binary"))
+			return 0
+		}
+		return exec(e)
+	}
+	if err := consume.Execute(context.Background(), cli); err != nil {
+		t.Fatalf("Failed to execute tasks: %v", err)
+	}
+	if size := build.outputSizes["/work/out"]; size != 6 {
+		t.Errorf("Expected the size from the stat applet of the helper, got %d", size)
+	}
+}
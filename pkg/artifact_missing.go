@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/errdefs"
+)
+
+// Copying a path that does not exist fails in the daemon with an error that names neither the task nor
+// what is there instead. Artifacts are checked in the container of their producer before they are copied,
+// and a missing one lists the entries of its closest existing parent directory, which usually shows a
+// typo or an output written somewhere else.
+
+const (
+	maxNearbyPaths   = 10   // Entries listed for a missing artifact
+	maxNearbyScanned = 1000 // Tar entries read looking for them, the directory may be large
+)
+
+// ErrArtifactNotFound is returned when an artifact to copy does not exist in the container of its producer.
+type ErrArtifactNotFound struct {
+	Task   string
+	Path   string
+	Nearby []string // Entries of the closest existing parent directory of Path
+}
+
+func (e *ErrArtifactNotFound) Error() string {
+	msg := fmt.Sprintf("%s does not exist in the container of task '%s'", e.Path, e.Task)
+	if len(e.Nearby) > 0 {
+		msg += fmt.Sprintf(", nearby paths: %s", strings.Join(e.Nearby, ", "))
+	}
+	return msg
+}
+
+// checkArtifactExists returns an ErrArtifactNotFound if p does not exist in the container of task
+func checkArtifactExists(ctx context.Context, cli ContainerFileAPI, task *Task, containerID, p string) error {
+	_, err := cli.ContainerStatPath(ctx, containerID, p)
+	if err == nil {
+		return nil
+	}
+	if !errdefs.IsNotFound(err) {
+		return fmt.Errorf("error checking %s in the container of task '%s': %w", p, task.Name, err)
+	}
+	return &ErrArtifactNotFound{Task: task.Name, Path: p, Nearby: nearbyPaths(ctx, cli, containerID, p)}
+}
+
+// nearbyPaths lists the entries of the closest existing parent directory of the missing path p, except
+// for the root directory, which is not worth archiving for it
+func nearbyPaths(ctx context.Context, cli ContainerFileAPI, containerID, p string) []string {
+	dir := path.Dir(path.Clean(p))
+	for ; dir != "/" && dir != "."; dir = path.Dir(dir) {
+		if _, err := cli.ContainerStatPath(ctx, containerID, dir); err == nil {
+			break
+		}
+	}
+	if dir == "/" || dir == "." {
+		return nil
+	}
+
+	reader, _, err := cli.CopyFromContainer(ctx, containerID, dir)
+	if err != nil {
+		return nil
+	}
+	defer reader.Close()
+	var nearby []string
+	archive := tar.NewReader(reader)
+	for scanned := 0; scanned < maxNearbyScanned && len(nearby) < maxNearbyPaths; scanned++ {
+		header, err := archive.Next()
+		if err != nil {
+			break
+		}
+		// Entries are named relative to the parent of dir, e.g. out/app for /work/out/app
+		name, ok := strings.CutPrefix(strings.TrimSuffix(header.Name, "/"), path.Base(dir)+"/")
+		if ok && name != "" && !strings.Contains(name, "/") {
+			nearby = append(nearby, path.Join(dir, name))
+		}
+	}
+	sort.Strings(nearby)
+	return nearby
+}
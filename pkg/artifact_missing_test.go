@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+)
+
+func TestArtifactNotFound(t *testing.T) {
+	cli, _, consume := artifactTasks(t, "binary", "binary")
+	exec := cli.Exec
+	cli.Exec = func(e *dockertest.Exec) int {
+		if e.Cmd[len(e.Cmd)-1] == "build" {
+			e.Container.WriteFile("/work/dist/app.tar", nil, 0o644)
+			e.Container.WriteFile("/work/src/main.go", nil, 0o644)
+		}
+		return exec(e)
+	}
+	consume.Dependencies[0].Artifacts[0].From = "/work/output"
+
+	err := consume.Execute(context.Background(), cli)
+	var notFound *ErrArtifactNotFound
+	if !errors.As(err, &notFound) {
+		t.Fatalf("Expected the missing artifact to fail the copy, got %v", err)
+	}
+	if notFound.Task != "build" || notFound.Path != "/work/output" {
+		t.Errorf("Unexpected missing artifact %+v", notFound)
+	}
+	if want := []string{"/work/dist", "/work/out", "/work/src"}; !reflect.DeepEqual(notFound.Nearby, want) {
+		t.Errorf("Expected nearby paths %v, got %v", want, notFound.Nearby)
+	}
+	if !strings.Contains(err.Error(), "nearby paths: /work/dist, /work/out, /work/src") {
+		t.Errorf("Expected the error to list the nearby paths, got %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package pkg
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ArtifactStore keeps the declared outputs of executed tasks in a content-addressed directory on the host.
+// Tasks whose hash is present in the store are not executed again, and their dependents restore artifacts
+// from the store, so preserved task containers can be pruned without losing the cache.
+//
+// Layout:
+//
+//	<dir>/blobs/sha256/<digest>  tar archive of one output path, as returned by the Docker archive API
+//	<dir>/tasks/<hash>.json      manifest mapping the outputs of a task hash to blobs
+type ArtifactStore struct {
+	dir        string
+	remote     RemoteBackend // Optional cache shared with other machines
+	remoteMode RemoteMode
+}
+
+// StoredArtifact is one output path of a task saved in the store.
+type StoredArtifact struct {
+	Path          string `json:"path"`
+	Digest        string `json:"digest"`                   // Digest of the stored tar blob
+	Size          int64  `json:"size"`                     // Size of the stored tar blob
+	ContentDigest string `json:"content_digest,omitempty"` // Artifact digest of the files, independent of timestamps
+	Compression   string `json:"compression,omitempty"`    // How the blob is compressed in the remote cache, empty if it is not
+}
+
+// StoreManifest lists the artifacts saved for a task hash.
+type StoreManifest struct {
+	Task        string           `json:"task"`
+	Hash        string           `json:"hash"`
+	HashVersion int              `json:"hash_version,omitempty"` // See HashVersion, 0 if stored before hashes were versioned
+	Created     time.Time        `json:"created"`
+	Artifacts   []StoredArtifact `json:"artifacts"`
+	// Reference the base image was pulled from, recorded for provenance when the run pulled it
+	ImageSource string `json:"image_source,omitempty"`
+	// Components of the hash, to explain later why a new hash of the task differs, see ExplainStaleness
+	Snapshot *Snapshot `json:"snapshot,omitempty"`
+}
+
+// NewArtifactStore opens (and creates if necessary) an artifact store rooted at dir.
+func NewArtifactStore(dir string) (*ArtifactStore, error) {
+	for _, sub := range []string{filepath.Join("blobs", "sha256"), "tasks"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("error creating artifact store directory: %w", err)
+		}
+	}
+	return &ArtifactStore{dir: dir}, nil
+}
+
+func (s *ArtifactStore) blobPath(digest string) string {
+	return filepath.Join(s.dir, "blobs", "sha256", digest)
+}
+
+func (s *ArtifactStore) manifestPath(hash string) string {
+	return filepath.Join(s.dir, "tasks", hash+".json")
+}
+
+// putBlob writes the content of r into the store and returns its digest and size
+func (s *ArtifactStore) putBlob(r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "blobs"), "upload-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("error creating blob file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("error writing blob: %w", err)
+	}
+
+	digest := hex.EncodeToString(hasher.Sum(nil))
+	if _, err := os.Stat(s.blobPath(digest)); err == nil {
+		// Identical content is already stored
+		return digest, size, nil
+	}
+	if err := os.Rename(tmp.Name(), s.blobPath(digest)); err != nil {
+		return "", 0, fmt.Errorf("error storing blob: %w", err)
+	}
+	return digest, size, nil
+}
+
+func (s *ArtifactStore) writeManifest(manifest *StoreManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding store manifest: %w", err)
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated manifest behind. The file
+	// name is unique, since concurrent artifact copies may fetch the same manifest at the same time.
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "tasks"), manifest.Hash+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("error writing store manifest: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing store manifest: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("error writing store manifest: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.manifestPath(manifest.Hash)); err != nil {
+		return fmt.Errorf("error writing store manifest: %w", err)
+	}
+	return nil
+}
+
+func parseStoreManifest(data []byte) (*StoreManifest, error) {
+	var manifest StoreManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("error parsing store manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// Lookup returns the manifest stored for a task hash. Local misses are fetched from the remote backend if one is set.
+func (s *ArtifactStore) Lookup(ctx context.Context, hash string) (*StoreManifest, bool, error) {
+	return s.lookup(ctx, hash, nil, os.Stdout)
+}
+
+// lookup is Lookup fetching the blobs of a remote manifest only if usable accepts it, and telling status
+// about it. A rejected remote manifest is returned without being saved locally.
+func (s *ArtifactStore) lookup(ctx context.Context, hash string, usable func(*StoreManifest) bool, status io.Writer) (*StoreManifest, bool, error) {
+	data, err := os.ReadFile(s.manifestPath(hash))
+	if errors.Is(err, os.ErrNotExist) && s.remote != nil {
+		manifest, found, err := s.remoteManifest(ctx, hash)
+		if err != nil || !found {
+			return nil, false, err
+		}
+		if usable == nil || usable(manifest) {
+			if err := s.fetchRemote(ctx, manifest, status); err != nil {
+				return nil, false, err
+			}
+		}
+		return manifest, true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading store manifest: %w", err)
+	}
+
+	manifest, err := parseStoreManifest(data)
+	if err != nil {
+		return nil, false, err
+	}
+	return manifest, true, nil
+}
+
+// Save extracts the declared outputs of an executed task from its container into the store.
+func (s *ArtifactStore) Save(ctx context.Context, cli ContainerFileAPI, t *Task) error {
+	return s.save(ctx, t, func(output string) (string, int64, error) {
+		return s.saveOutput(ctx, cli, t.containerID, output)
+	})
+}
+
+// save writes the manifest of an executed task, storing each declared output with saveOutput
+func (s *ArtifactStore) save(ctx context.Context, t *Task, saveOutput func(output string) (string, int64, error)) error {
+	manifest := &StoreManifest{
+		Task:        t.Name,
+		Hash:        t.generateHash(),
+		HashVersion: HashVersion,
+		Created:     time.Now().UTC(),
+		ImageSource: t.imageSource,
+	}
+	snapshot := t.CacheKey()
+	manifest.Snapshot = &snapshot
+
+	for _, output := range t.declaredOutputs() {
+		digest, size, err := saveOutput(output)
+		if err != nil {
+			return err
+		}
+
+		contentDigest, ok := t.outputDigests[output]
+		if !ok {
+			if contentDigest, err = s.digestBlob(digest); err != nil {
+				return err
+			}
+		}
+
+		manifest.Artifacts = append(manifest.Artifacts, StoredArtifact{Path: output, Digest: digest, Size: size, ContentDigest: contentDigest})
+	}
+
+	if err := s.writeManifest(manifest); err != nil {
+		return err
+	}
+	t.recordStoredOutputs(manifest)
+
+	if s.remote != nil && s.remoteMode == RemoteReadWrite {
+		return s.pushRemote(ctx, manifest, statusWriter{t})
+	}
+	return nil
+}
+
+// saveOutput copies one output path out of a container into the store
+func (s *ArtifactStore) saveOutput(ctx context.Context, cli ContainerFileAPI, containerID, output string) (string, int64, error) {
+	release, err := acquireCopy(ctx, cli)
+	if err != nil {
+		return "", 0, err
+	}
+	defer release()
+
+	reader, _, err := cli.CopyFromContainer(ctx, containerID, output)
+	if err != nil {
+		return "", 0, fmt.Errorf("error copying output %s from task container: %w", output, err)
+	}
+	defer reader.Close()
+	return s.putBlob(reader)
+}
+
+// Open returns a tar stream of the artifact at path from of the stored task hash, as CopyFromContainer
+// would have returned it. It returns false if the store holds no output covering from.
+func (s *ArtifactStore) Open(ctx context.Context, hash, from string) (io.ReadCloser, bool, error) {
+	manifest, ok, err := s.Lookup(ctx, hash)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	for _, artifact := range manifest.Artifacts {
+		if !isUnderPath(from, artifact.Path) {
+			continue
+		}
+
+		blob, err := os.Open(s.blobPath(artifact.Digest))
+		if err != nil {
+			return nil, false, fmt.Errorf("error opening stored artifact %s: %w", artifact.Path, err)
+		}
+		if from == artifact.Path {
+			return blob, true, nil
+		}
+		return extractTarSubtree(blob, artifact.Path, from), true, nil
+	}
+
+	return nil, false, nil
+}
+
+// readCloser combines a reader with the closer of the underlying resource
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// extractTarSubtree filters a tar archive of root (as returned by CopyFromContainer) down to the entries
+// of sub, re-rooted so the archive looks as if sub had been copied directly. Closing it closes r.
+func extractTarSubtree(r io.ReadCloser, root, sub string) io.ReadCloser {
+	rel := strings.TrimPrefix(strings.TrimPrefix(sub, strings.TrimSuffix(root, "/")), "/")
+	return rewriteTarPrefix(r, path.Join(path.Base(root), rel), path.Base(sub))
+}
+
+// rewriteTarPrefix keeps the entries of a tar archive at or below oldPrefix and moves them to newPrefix.
+// Closing it closes r and ends the goroutine rewriting the archive, also if it was not read to the end.
+func rewriteTarPrefix(r io.ReadCloser, oldPrefix, newPrefix string) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		tr := tar.NewReader(r)
+		tw := tar.NewWriter(pw)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			name := strings.TrimSuffix(header.Name, "/")
+			if name != oldPrefix && !strings.HasPrefix(name, oldPrefix+"/") {
+				continue
+			}
+			header.Name = newPrefix + strings.TrimPrefix(header.Name, oldPrefix)
+
+			if err := tw.WriteHeader(header); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	return readCloser{pr, closerFunc(func() error {
+		pr.Close()
+		return r.Close()
+	})}
+}
@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// buildTar creates a tar archive from name/content pairs; names ending in a slash become directories
+func buildTar(t *testing.T, entries ...string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := 0; i+1 < len(entries); i += 2 {
+		name, content := entries[i], entries[i+1]
+		header := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if strings.HasSuffix(name, "/") {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0o755
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatalf("Failed to write tar header: %v", err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatalf("Failed to write tar content: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestArtifactStoreBlobsAndManifests(t *testing.T) {
+	store, err := NewArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	digest1, size, err := store.putBlob(strings.NewReader("artifact content"))
+	if err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	if size != int64(len("artifact content")) {
+		t.Errorf("Unexpected blob size %d", size)
+	}
+	digest2, _, err := store.putBlob(strings.NewReader("artifact content"))
+	if err != nil || digest1 != digest2 {
+		t.Errorf("Identical content should map to the same digest, got %s and %s (%v)", digest1, digest2, err)
+	}
+
+	if _, ok, err := store.Lookup(context.Background(), "0123456789ab"); ok || err != nil {
+		t.Errorf("Lookup of unknown hash should report a miss, got ok=%v err=%v", ok, err)
+	}
+
+	manifest := &StoreManifest{Task: "build", Hash: "0123456789ab", Artifacts: []StoredArtifact{{Path: "/output", Digest: digest1, Size: size}}}
+	if err := store.writeManifest(manifest); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	loaded, ok, err := store.Lookup(context.Background(), "0123456789ab")
+	if err != nil || !ok {
+		t.Fatalf("Lookup should find the manifest, got ok=%v err=%v", ok, err)
+	}
+	if loaded.Task != "build" || len(loaded.Artifacts) != 1 || loaded.Artifacts[0].Digest != digest1 {
+		t.Errorf("Unexpected manifest: %+v", loaded)
+	}
+}
+
+func TestExtractTarSubtree(t *testing.T) {
+	archive := buildTar(t,
+		"output/", "",
+		"output/app", "binary",
+		"output/docs/", "",
+		"output/docs/readme.md", "docs",
+	)
+
+	tr := tar.NewReader(extractTarSubtree(io.NopCloser(bytes.NewReader(archive)), "/output", "/output/docs"))
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read filtered tar: %v", err)
+		}
+		names = append(names, header.Name)
+	}
+
+	if len(names) != 2 || names[0] != "docs/" || names[1] != "docs/readme.md" {
+		t.Errorf("Unexpected entries in subtree: %v", names)
+	}
+}
+
+func TestRewriteTarPrefixClose(t *testing.T) {
+	// Closing an archive that was not read to the end closes the source, which ends the rewriting goroutine
+	pr, pw := io.Pipe()
+	go pw.Write(buildTar(t, "output/app", "binary"))
+	closed := false
+	source := readCloser{pr, closerFunc(func() error {
+		closed = true
+		return pr.Close()
+	})}
+	if err := rewriteTarPrefix(source, "output", "docs").Close(); err != nil || !closed {
+		t.Errorf("Expected closing the rewritten archive to close its source, got closed=%v err=%v", closed, err)
+	}
+}
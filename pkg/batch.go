@@ -0,0 +1,216 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// batchScriptPath is where the command script of a batched task is uploaded
+const batchScriptPath = internalDir + "/commands.sh"
+
+// batchScript renders the commands of t as one script. Every command still runs in its own shell (or as
+// its raw argv), as with one exec per command, and is framed by marker lines carrying the step number and exit code.
+func (t *Task) batchScript(marker string) string {
+	var script strings.Builder
+	script.WriteString("#!/bin/sh\n")
+	for idx := range t.commandLines() {
+		fmt.Fprintf(&script, "printf '%%s start %d\\n' %s\n", idx+1, shellQuote(marker))
+		fmt.Fprintf(&script, "%s\n", t.scriptLine(idx))
+		fmt.Fprintf(&script, "status=$?\n")
+		fmt.Fprintf(&script, "printf '\\n%%s end %d %%d\\n' %s \"$status\"\n", idx+1, shellQuote(marker))
+		fmt.Fprintf(&script, "[ \"$status\" -eq 0 ] || exit \"$status\"\n")
+	}
+	return script.String()
+}
+
+// stepWriter forwards command output line by line and consumes the marker lines of a batch script
+type stepWriter struct {
+	out      io.Writer
+	marker   string
+	commands []string
+	buf      bytes.Buffer
+	blank    bool // An empty line is held back, it may be the one the script prints before an end marker
+	failed   int  // Step that exited non-zero, 0 if none did
+	exitCode int
+	finished int // Number of steps that completed
+}
+
+func (w *stepWriter) Write(p []byte) (int, error) {
+	w.buf.Write(p)
+	for {
+		end := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if end < 0 {
+			return len(p), nil
+		}
+		if err := w.handleLine(w.buf.Next(end + 1)); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (w *stepWriter) handleLine(line []byte) error {
+	fields := strings.Fields(string(line))
+	isEnd := len(fields) >= 3 && fields[0] == w.marker && fields[1] == "end"
+	if w.blank && !isEnd {
+		if _, err := w.out.Write([]byte("\n")); err != nil {
+			return err
+		}
+	}
+	w.blank = false
+	if string(line) == "\n" {
+		w.blank = true
+		return nil
+	}
+	if len(fields) < 3 || fields[0] != w.marker {
+		_, err := w.out.Write(line)
+		return err
+	}
+
+	step, _ := strconv.Atoi(fields[2])
+	switch {
+	case fields[1] == "start" && step >= 1 && step <= len(w.commands):
+		_, err := fmt.Fprintf(w.out, "Executing command %d: %s\n", step, w.commands[step-1])
+		return err
+	case fields[1] == "end" && len(fields) == 4:
+		w.finished = step
+		if code, _ := strconv.Atoi(fields[3]); code != 0 {
+			w.failed = step
+			w.exitCode = code
+		}
+	}
+	return nil
+}
+
+// flush writes a held back empty line and a trailing partial line
+func (w *stepWriter) flush() error {
+	if w.blank {
+		w.blank = false
+		if _, err := w.out.Write([]byte("\n")); err != nil {
+			return err
+		}
+	}
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	_, err := w.out.Write(w.buf.Bytes())
+	w.buf.Reset()
+	return err
+}
+
+// uploadBatchScript copies the command script into the task container
+func (t *Task) uploadBatchScript(ctx context.Context, cli DockerAPI, script string) error {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: batchScriptPath[1:], Mode: 0o755, Size: int64(len(script))})
+	if err == nil {
+		_, err = tw.Write([]byte(script))
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("error packing command script: %w", err)
+	}
+
+	if _, err := runInContainer(ctx, cli, t.containerID, "", append(t.mkdirCommand(), internalDir)); err != nil {
+		return fmt.Errorf("error uploading command script: %w", err)
+	}
+	release, err := acquireCopy(ctx, cli)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := cli.CopyToContainer(ctx, t.containerID, "/", &buf, container.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("error uploading command script: %w", err)
+	}
+	return nil
+}
+
+// stepMarker returns a marker for the step lines of a batch that the output of its commands cannot
+// forge, as it is random
+func stepMarker() (string, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("error generating step marker: %w", err)
+	}
+	return "::buildvault-step-" + hex.EncodeToString(nonce) + "::", nil
+}
+
+// executeBatch runs all commands of the task with a single exec of an uploaded script, instead of one
+// exec round trip per command
+func (t *Task) executeBatch(ctx context.Context, cli DockerAPI, stdout, stderr io.Writer) error {
+	marker, err := stepMarker()
+	if err != nil {
+		return err
+	}
+
+	if err := t.uploadBatchScript(ctx, cli, t.batchScript(marker)); err != nil {
+		return err
+	}
+
+	release, err := acquireExec(ctx, cli)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	lines := t.commandLines()
+	t.statusf("Executing %d commands as a batch\n", len(lines))
+	execResp, err := cli.ContainerExecCreate(ctx, t.containerID, container.ExecOptions{
+		Cmd:          t.scriptCommand(batchScriptPath),
+		Env:          t.execEnv(),
+		User:         t.containerUser(),
+		WorkingDir:   t.workDir(),
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating exec for command script: %w", err)
+	}
+
+	attachResp, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return fmt.Errorf("error attaching to exec for command script: %w", err)
+	}
+	defer attachResp.Close()
+
+	steps := &stepWriter{out: stdout, marker: marker, commands: lines}
+	if _, err := stdcopy.StdCopy(steps, stderr, attachResp.Reader); err != nil {
+		return fmt.Errorf("error StdCopy: %w", err)
+	}
+	if err := steps.flush(); err != nil {
+		return err
+	}
+
+	inspectResp, err := cli.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return fmt.Errorf("error inspecting exec for command script: %w", err)
+	}
+
+	// Only the whole batch is timed, so the commands are recorded without durations
+	for i, line := range lines[:steps.finished] {
+		exitCode := 0
+		if i+1 == steps.failed {
+			exitCode = steps.exitCode
+		}
+		t.recordCommand(line, exitCode, 0, nil)
+	}
+	if steps.failed != 0 {
+		return &CommandError{Task: t.Name, Command: lines[steps.failed-1], ExitCode: steps.exitCode}
+	}
+	if inspectResp.ExitCode != 0 || steps.finished != len(lines) {
+		return fmt.Errorf("command script failed after %d of %d commands: %w", steps.finished, len(lines), &CommandError{Task: t.Name, ExitCode: inspectResp.ExitCode})
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestBatchScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	task := &Task{Name: "batch", Commands: []string{"echo one", "printf 'no newline'", "cd /tmp && echo 'it''s' two", "exit 3", "echo never"}}
+	marker := "::buildvault-step-test::"
+
+	cmd := exec.Command("sh", "-c", task.batchScript(marker))
+	raw, _ := cmd.Output()
+
+	var out bytes.Buffer
+	steps := &stepWriter{out: &out, marker: marker, commands: task.Commands}
+	steps.Write(raw)
+	steps.flush()
+
+	if steps.failed != 4 || steps.exitCode != 3 || steps.finished != 4 {
+		t.Errorf("Expected command 4 to fail with exit code 3, got step %d code %d (finished %d)", steps.failed, steps.exitCode, steps.finished)
+	}
+	output := out.String()
+	for _, want := range []string{"Executing command 1: echo one\none\n", "no newline\n", "its two\n", "Executing command 4: exit 3\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Output is missing %q:\n%s", want, output)
+		}
+	}
+	if strings.Contains(output, marker) || strings.Contains(output, "never") || strings.Contains(output, "\n\n") {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+}
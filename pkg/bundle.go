@@ -0,0 +1,245 @@
+package pkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BundleOptions selects what goes into a bug report bundle.
+type BundleOptions struct {
+	PipelinePath string    // Pipeline file, included with secret-looking values redacted
+	Pipeline     *Pipeline // Parsed pipeline used to compute the plan
+	LogFiles     []string  // Optional logs of a failing run to include
+}
+
+// PlannedTask is one task of the resolved execution plan in a bundle.
+type PlannedTask struct {
+	Name         string   `json:"name"`
+	Image        string   `json:"image"`
+	ImageID      string   `json:"image_id,omitempty"`
+	Hash         string   `json:"hash"`
+	Container    string   `json:"container"`
+	Dependencies []string `json:"dependencies,omitempty"`
+	Outputs      []string `json:"outputs,omitempty"`
+}
+
+// bundledContainer is a preserved task container listed in a bundle
+type bundledContainer struct {
+	Name    string    `json:"name"`
+	Task    string    `json:"task"`
+	Hash    string    `json:"hash"`
+	State   string    `json:"state"`
+	Created time.Time `json:"created"`
+}
+
+// secretNamePattern matches names of environment variables and YAML keys likely holding credentials
+var secretNamePattern = regexp.MustCompile(`(?i)(token|secret|password|passwd|credential|key)`)
+
+// secretYAMLPattern matches YAML lines assigning a value to a secret-looking key
+var secretYAMLPattern = regexp.MustCompile(`(?im)^(\s*-?\s*[\w.-]*(?:token|secret|password|passwd|credential|key)[\w.-]*\s*:\s*)\S.*$`)
+
+// diagnosticEnvPrefixes selects the environment variables relevant for diagnosing buildvault issues
+var diagnosticEnvPrefixes = []string{"BUILDVAULT_", "DOCKER_", "AWS_", "GCS_"}
+
+const redacted = "<redacted>"
+
+// redactEnvironment returns the diagnostic environment variables with credential values redacted
+func redactEnvironment(environ []string) map[string]string {
+	result := map[string]string{}
+	for _, entry := range environ {
+		name, value, _ := strings.Cut(entry, "=")
+		relevant := false
+		for _, prefix := range diagnosticEnvPrefixes {
+			if strings.HasPrefix(name, prefix) {
+				relevant = true
+			}
+		}
+		if !relevant {
+			continue
+		}
+		if secretNamePattern.MatchString(name) {
+			value = redacted
+		}
+		result[name] = value
+	}
+	return result
+}
+
+// redactPipelineFile replaces the values of secret-looking keys (e.g. build args) in a pipeline file
+func redactPipelineFile(data []byte) []byte {
+	return secretYAMLPattern.ReplaceAll(data, []byte("${1}"+redacted))
+}
+
+// bundlePlan returns the tasks in execution order (dependencies first) with their resolved hashes
+func bundlePlan(tasks []*Task) []PlannedTask {
+	var plan []PlannedTask
+	seen := map[*Task]bool{}
+	var visit func(t *Task)
+	visit = func(t *Task) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+
+		planned := PlannedTask{
+			Name:      t.Name,
+			Image:     t.BaseImage,
+			ImageID:   t.imageID,
+			Hash:      t.generateHash(),
+			Container: t.generateContainerName(),
+			Outputs:   t.declaredOutputs(),
+		}
+		for _, dependency := range t.Dependencies {
+			visit(dependency.Task)
+			planned.Dependencies = append(planned.Dependencies, dependency.Task.Name)
+		}
+		plan = append(plan, planned)
+	}
+
+	for _, task := range tasks {
+		visit(task)
+	}
+	return plan
+}
+
+// WriteBundle writes a gzipped tarball with everything needed to reproduce an issue: the pipeline file,
+// the resolved plan with hashes, versions, environment diagnostics, preserved containers and logs.
+// Diagnostics that cannot be collected (e.g. without a Docker daemon, cli may be nil) are recorded
+// in errors.txt instead of failing the bundle.
+func WriteBundle(ctx context.Context, cli DockerAPI, w io.Writer, opts BundleOptions) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+
+	var problems []string
+	addFile := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: now}); err != nil {
+			return fmt.Errorf("error writing bundle: %w", err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("error writing bundle: %w", err)
+		}
+		return nil
+	}
+	addJSON := func(name string, value any) error {
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return fmt.Errorf("error encoding %s: %w", name, err)
+		}
+		return addFile(name, data)
+	}
+
+	if opts.PipelinePath != "" {
+		data, err := os.ReadFile(opts.PipelinePath)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("pipeline file: %v", err))
+		} else if err := addFile("pipeline/"+filepath.Base(opts.PipelinePath), redactPipelineFile(data)); err != nil {
+			return err
+		}
+	}
+
+	if opts.Pipeline != nil {
+		// Hashes depend on the digests of built images and host inputs
+		if cli != nil {
+			if err := ResolveBuiltImages(ctx, cli, opts.Pipeline.Tasks); err != nil {
+				problems = append(problems, fmt.Sprintf("built images: %v", err))
+			}
+		}
+		if err := ResolveHostInputs(opts.Pipeline.Tasks); err != nil {
+			problems = append(problems, fmt.Sprintf("host inputs: %v", err))
+		}
+		if err := addJSON("plan.json", bundlePlan(opts.Pipeline.Roots())); err != nil {
+			return err
+		}
+	}
+
+	versions := map[string]string{
+		"go":   runtime.Version(),
+		"os":   runtime.GOOS,
+		"arch": runtime.GOARCH,
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		versions["buildvault"] = info.Main.Version
+	}
+	if cli != nil {
+		server, err := cli.ServerVersion(ctx)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("docker version: %v", err))
+		} else {
+			versions["docker"] = server.Version
+			versions["docker_api"] = server.APIVersion
+			versions["docker_os"] = server.Os + "/" + server.Arch
+		}
+		versions["docker_client_api"] = cli.ClientVersion()
+	}
+	if err := addJSON("versions.json", versions); err != nil {
+		return err
+	}
+
+	if err := addJSON("environment.json", map[string]any{
+		"cpus":      runtime.NumCPU(),
+		"variables": redactEnvironment(os.Environ()),
+	}); err != nil {
+		return err
+	}
+
+	if cli != nil {
+		containers, err := listBuildvaultContainers(ctx, cli, false)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("containers: %v", err))
+		} else {
+			var listed []bundledContainer
+			for _, c := range containers {
+				if len(c.Names) == 0 {
+					continue
+				}
+				name := strings.TrimPrefix(c.Names[0], "/")
+				taskName, hash, _ := taskContainer(c)
+				listed = append(listed, bundledContainer{Name: name, Task: taskName, Hash: hash, State: c.State, Created: time.Unix(c.Created, 0).UTC()})
+			}
+			sort.Slice(listed, func(i, j int) bool { return listed[i].Created.After(listed[j].Created) })
+			if err := addJSON("containers.json", listed); err != nil {
+				return err
+			}
+		}
+	} else {
+		problems = append(problems, "docker: no client available")
+	}
+
+	for _, logFile := range opts.LogFiles {
+		data, err := os.ReadFile(logFile)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("log file: %v", err))
+			continue
+		}
+		if err := addFile("logs/"+filepath.Base(logFile), data); err != nil {
+			return err
+		}
+	}
+
+	if len(problems) > 0 {
+		if err := addFile("errors.txt", []byte(strings.Join(problems, "\n")+"\n")); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("error writing bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("error writing bundle: %w", err)
+	}
+	return nil
+}
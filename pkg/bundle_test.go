@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+	env := redactEnvironment([]string{
+		"HOME=/root",
+		"DOCKER_HOST=unix:///var/run/docker.sock",
+		"AWS_SECRET_ACCESS_KEY=abc",
+		"BUILDVAULT_REMOTE_CACHE_TOKEN=xyz",
+	})
+	if _, ok := env["HOME"]; ok {
+		t.Errorf("Unrelated variables should not be bundled")
+	}
+	if env["DOCKER_HOST"] != "unix:///var/run/docker.sock" {
+		t.Errorf("DOCKER_HOST should be kept, got %q", env["DOCKER_HOST"])
+	}
+	if env["AWS_SECRET_ACCESS_KEY"] != redacted || env["BUILDVAULT_REMOTE_CACHE_TOKEN"] != redacted {
+		t.Errorf("Secrets should be redacted: %v", env)
+	}
+
+	file := redactPipelineFile([]byte("tasks:\n  - name: a\n    build:\n      args:\n        NPM_TOKEN: s3cr3t\n        VERSION: \"1\"\n"))
+	if strings.Contains(string(file), "s3cr3t") || !strings.Contains(string(file), "NPM_TOKEN: "+redacted) || !strings.Contains(string(file), `VERSION: "1"`) {
+		t.Errorf("Unexpected redacted pipeline file:\n%s", file)
+	}
+}
+
+func TestWriteBundle(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "buildvault.yaml")
+	data := "tasks:\n  - name: a\n    image: alpine\n  - name: b\n    image: alpine\n    dependencies:\n      - task: a\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := LoadPipeline(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBundle(context.Background(), nil, &buf, BundleOptions{PipelinePath: path, Pipeline: pipeline}); err != nil {
+		t.Fatalf("Failed to write bundle: %v", err)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(tr)
+		files[header.Name] = string(content)
+	}
+
+	for _, name := range []string{"pipeline/buildvault.yaml", "plan.json", "versions.json", "environment.json", "errors.txt"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Bundle is missing %s", name)
+		}
+	}
+	plan := files["plan.json"]
+	if strings.Index(plan, `"name": "a"`) > strings.Index(plan, `"name": "b"`) {
+		t.Errorf("Dependencies should be planned first:\n%s", plan)
+	}
+}
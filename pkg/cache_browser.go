@@ -0,0 +1,268 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/moby/term"
+)
+
+// The cache browser is a full-screen terminal view of the generations in an artifact store. It draws
+// with plain ANSI escape sequences on a raw terminal.
+
+const (
+	clearScreen = "\x1b[H\x1b[2J"
+	hideCursor  = "\x1b[?25l"
+	showCursor  = "\x1b[?25h"
+)
+
+// browserHelp lists the key bindings of the cache browser
+const browserHelp = "↑/k ↓/j move  enter manifest  p pin  d invalidate  s shell  r reload  q quit"
+
+// browserAction is what the cache browser does after a key press
+type browserAction int
+
+const (
+	browserContinue browserAction = iota
+	browserQuit
+	browserShell // Open a shell in the container of the selected generation
+)
+
+// cacheBrowser is the state of the cache browser
+type cacheBrowser struct {
+	store       *ArtifactStore
+	generations []StoreGeneration
+	cursor      int
+	manifest    bool   // The manifest of the selected generation is shown
+	confirm     bool   // Invalidating the selected generation awaits confirmation
+	status      string // Outcome of the last action
+}
+
+func (b *cacheBrowser) reload() error {
+	generations, err := b.store.Generations()
+	if err != nil {
+		return err
+	}
+	b.generations = generations
+	b.cursor = min(b.cursor, max(len(generations)-1, 0))
+	return nil
+}
+
+func (b *cacheBrowser) selected() (StoreGeneration, bool) {
+	if len(b.generations) == 0 {
+		return StoreGeneration{}, false
+	}
+	return b.generations[b.cursor], true
+}
+
+// handleKey applies a key press to the browser
+func (b *cacheBrowser) handleKey(key string) (browserAction, error) {
+	generation, ok := b.selected()
+	if b.confirm {
+		b.confirm = false
+		if key != "y" {
+			b.status = "Kept " + generation.Hash
+			return browserContinue, nil
+		}
+		if err := b.store.Invalidate(generation.Hash); err != nil {
+			b.status = err.Error()
+			return browserContinue, nil
+		}
+		b.status = fmt.Sprintf("Invalidated %s of task '%s'", generation.Hash, generation.Task)
+		return browserContinue, b.reload()
+	}
+
+	b.status = ""
+	switch key {
+	case "q", "\x03":
+		return browserQuit, nil
+	case "up", "k":
+		b.cursor = max(b.cursor-1, 0)
+	case "down", "j":
+		b.cursor = min(b.cursor+1, max(len(b.generations)-1, 0))
+	case "enter", "i":
+		b.manifest = !b.manifest
+	case "r":
+		return browserContinue, b.reload()
+	case "p":
+		if !ok {
+			break
+		}
+		if err := b.store.Pin(generation.Hash, !generation.Pinned); err != nil {
+			return browserContinue, err
+		}
+		b.generations[b.cursor].Pinned = !generation.Pinned
+	case "d":
+		if ok {
+			b.confirm = true
+		}
+	case "s":
+		if ok {
+			return browserShell, nil
+		}
+	}
+	return browserContinue, nil
+}
+
+// render draws the browser for a terminal of the given height at now
+func (b *cacheBrowser) render(height int, now time.Time) string {
+	var lines []string
+	var total int64
+	for _, generation := range b.generations {
+		total += generation.Size
+	}
+	lines = append(lines, fmt.Sprintf("buildvault cache: %s, %d generations, %s", b.store.dir, len(b.generations), units.HumanSize(float64(total))))
+	lines = append(lines, fmt.Sprintf("  %-24s %-16s %-10s %-10s %-9s %s", "TASK", "HASH", "AGE", "SIZE", "ARTIFACTS", "PINNED"))
+
+	var details []string
+	if generation, ok := b.selected(); ok && b.manifest {
+		if data, err := os.ReadFile(b.store.manifestPath(generation.Hash)); err == nil {
+			details = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+		}
+	}
+
+	// Keep the cursor visible in the rows left by the header, the manifest and the footer
+	rows := max(height-len(lines)-len(details)-3, 1)
+	first := max(b.cursor-rows+1, 0)
+	for i := first; i < len(b.generations) && i < first+rows; i++ {
+		generation := b.generations[i]
+		pointer := " "
+		if i == b.cursor {
+			pointer = ">"
+		}
+		pinned := ""
+		if generation.Pinned {
+			pinned = "yes"
+		}
+		lines = append(lines, fmt.Sprintf("%s %-24s %-16s %-10s %-10s %-9d %s", pointer, generation.Task, generation.Hash,
+			units.HumanDuration(now.Sub(generation.Created)), units.HumanSize(float64(generation.Size)), len(generation.Artifacts), pinned))
+	}
+	if len(b.generations) == 0 {
+		lines = append(lines, "  The store holds no generations")
+	}
+
+	lines = append(lines, "")
+	lines = append(lines, details...)
+	status := b.status
+	if b.confirm {
+		generation, _ := b.selected()
+		status = fmt.Sprintf("Invalidate %s of task '%s'? (y/n)", generation.Hash, generation.Task)
+	}
+	lines = append(lines, status, browserHelp)
+	// Raw terminals do not return the carriage on a line feed
+	return clearScreen + strings.Join(lines, "\r\n")
+}
+
+// readKey reads one key press from a raw terminal, naming the arrow and enter keys
+func readKey(in io.Reader) (string, error) {
+	buf := make([]byte, 8)
+	n, err := in.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	switch key := string(buf[:n]); key {
+	case "\x1b[A", "\x1bOA":
+		return "up", nil
+	case "\x1b[B", "\x1bOB":
+		return "down", nil
+	case "\r", "\n":
+		return "enter", nil
+	default:
+		return key, nil
+	}
+}
+
+// BrowseCache opens the cache browser on the terminal for the generations of store. Shells open in the
+// preserved container of the selected generation, as the user of the task with that name in tasks.
+func BrowseCache(ctx context.Context, cli DockerAPI, store *ArtifactStore, tasks []*Task) error {
+	fd, isTerminal := term.GetFdInfo(os.Stdin)
+	if !isTerminal {
+		return fmt.Errorf("the cache browser needs a terminal")
+	}
+	browser := &cacheBrowser{store: store}
+	if err := browser.reload(); err != nil {
+		return err
+	}
+
+	state, err := term.SetRawTerminal(fd)
+	if err != nil {
+		return fmt.Errorf("error setting up the terminal: %w", err)
+	}
+	defer func() {
+		fmt.Print(clearScreen + showCursor)
+		term.RestoreTerminal(fd, state)
+	}()
+	fmt.Print(hideCursor)
+
+	for {
+		height := 24
+		if size, err := term.GetWinsize(fd); err == nil {
+			height = int(size.Height)
+		}
+		fmt.Print(browser.render(height, time.Now()))
+
+		key, err := readKey(os.Stdin)
+		if err != nil {
+			return err
+		}
+		action, err := browser.handleKey(key)
+		if err != nil {
+			return err
+		}
+		switch action {
+		case browserQuit:
+			return nil
+		case browserShell:
+			generation, _ := browser.selected()
+			fmt.Print(clearScreen + showCursor)
+			term.RestoreTerminal(fd, state)
+			shellErr := shellIntoGeneration(ctx, cli, generation, tasks)
+			if state, err = term.SetRawTerminal(fd); err != nil {
+				return fmt.Errorf("error setting up the terminal: %w", err)
+			}
+			fmt.Print(hideCursor)
+			if shellErr != nil {
+				browser.status = shellErr.Error()
+			}
+		}
+	}
+}
+
+// shellIntoGeneration opens a shell in the preserved container of a generation
+func shellIntoGeneration(ctx context.Context, cli DockerAPI, generation StoreGeneration, tasks []*Task) error {
+	containers, err := listBuildvaultContainers(ctx, cli, false)
+	if err != nil {
+		return err
+	}
+	for _, summary := range containers {
+		taskName, hash, ok := taskContainer(summary)
+		if !ok || taskName != generation.Task || hash != generation.Hash || len(summary.Names) == 0 {
+			continue
+		}
+		if err := ensureRunning(ctx, cli, summary); err != nil {
+			return err
+		}
+		shell, err := debugShell(ctx, cli, summary.ID)
+		if err != nil {
+			return err
+		}
+
+		task := &Task{Name: generation.Task}
+		for _, candidate := range tasks {
+			if candidate.Name == generation.Task {
+				task = candidate
+			}
+		}
+		if err := runShell(ctx, cli, summary.ID, shell, task.containerUser(), task.workDir()); err != nil {
+			return err
+		}
+		fmt.Printf("Container %s keeps running until the task runs again\n", strings.TrimPrefix(summary.Names[0], "/"))
+		return nil
+	}
+	return fmt.Errorf("no container of generation %s of task '%s' is preserved", generation.Hash, generation.Task)
+}
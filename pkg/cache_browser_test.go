@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheBrowser(t *testing.T) {
+	store, err := NewArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, manifest := range []*StoreManifest{
+		{Task: "build", Hash: "aaaaaaaaaaaa", Created: now.Add(-2 * time.Hour), Artifacts: []StoredArtifact{{Path: "/out", Digest: "blob1", Size: 100}}},
+		{Task: "build", Hash: "bbbbbbbbbbbb", Created: now.Add(-time.Hour), Artifacts: []StoredArtifact{{Path: "/out", Digest: "blob2", Size: 200}}},
+		{Task: "test", Hash: "cccccccccccc", Created: now},
+	} {
+		if err := store.writeManifest(manifest); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	browser := &cacheBrowser{store: store}
+	if err := browser.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if generation, _ := browser.selected(); generation.Hash != "bbbbbbbbbbbb" {
+		t.Errorf("Expected the newest generation of build first, got %s", generation.Hash)
+	}
+
+	browser.handleKey("p")
+	browser.handleKey("d")
+	if !strings.Contains(browser.render(24, now), "Invalidate bbbbbbbbbbbb of task 'build'? (y/n)") {
+		t.Errorf("Expected a confirmation prompt:\n%s", browser.render(24, now))
+	}
+	browser.handleKey("y")
+	if !strings.Contains(browser.status, "pinned") || len(browser.generations) != 3 {
+		t.Errorf("A pinned generation should not be invalidated, status %q", browser.status)
+	}
+
+	browser.handleKey("down")
+	browser.handleKey("d")
+	browser.handleKey("y")
+	if len(browser.generations) != 2 || browser.generations[1].Task != "test" {
+		t.Errorf("Expected generation aaaaaaaaaaaa to be invalidated, got %+v", browser.generations)
+	}
+
+	browser.handleKey("enter")
+	if screen := browser.render(24, now); !strings.Contains(screen, `"task": "test"`) {
+		t.Errorf("Expected the manifest of the selected generation:\n%s", screen)
+	}
+	if action, _ := browser.handleKey("q"); action != browserQuit {
+		t.Errorf("Expected q to quit")
+	}
+}
+
+func TestInvalidateRemovesUnusedBlobs(t *testing.T) {
+	store, err := NewArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, _, _ := store.putBlob(strings.NewReader("shared"))
+	own, _, _ := store.putBlob(strings.NewReader("own"))
+	store.writeManifest(&StoreManifest{Task: "a", Hash: "old", Artifacts: []StoredArtifact{{Path: "/x", Digest: shared}, {Path: "/y", Digest: own}}})
+	store.writeManifest(&StoreManifest{Task: "a", Hash: "new", Artifacts: []StoredArtifact{{Path: "/x", Digest: shared}}})
+
+	if err := store.Invalidate("old"); err != nil {
+		t.Fatalf("Failed to invalidate: %v", err)
+	}
+	generations, _ := store.Generations()
+	if len(generations) != 1 || generations[0].Hash != "new" {
+		t.Errorf("Unexpected generations %+v", generations)
+	}
+	reader, ok, _ := store.Open(context.Background(), "new", "/x")
+	if !ok {
+		t.Fatalf("The shared blob should be kept")
+	}
+	reader.Close()
+	if entries, _ := filepath.Glob(store.blobPath("*")); len(entries) != 1 {
+		t.Errorf("Expected only the shared blob to remain, got %v", entries)
+	}
+}
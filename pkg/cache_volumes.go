@@ -0,0 +1,160 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/errdefs"
+)
+
+// labelCacheDir is the container path a cache volume is mounted at
+const labelCacheDir = "buildvault.cache_dir"
+
+// volumeNameInvalid matches characters Docker does not allow in volume names
+var volumeNameInvalid = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+// cacheVolumeName returns the name of the volume backing a cache directory of a task. It only depends
+// on the task name and path, so every run of the task reuses the volume regardless of its hash.
+func cacheVolumeName(taskName, dir string) string {
+	sum := sha256.Sum256([]byte(dir))
+	return namespaced("buildvault_cache_") + volumeNameInvalid.ReplaceAllString(taskName, "-") + "_" + hex.EncodeToString(sum[:])[:12]
+}
+
+// cacheVolumeMounts returns the mounts of the cache directories of t
+func (t *Task) cacheVolumeMounts() []mount.Mount {
+	var mounts []mount.Mount
+	for _, dir := range t.CacheDirs {
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: cacheVolumeName(t.Name, dir),
+			Target: dir,
+		})
+	}
+	return mounts
+}
+
+// createCacheVolumes creates the volumes of the cache directories of t, labelled so prune can find
+// them. Existing volumes are reused.
+func (t *Task) createCacheVolumes(ctx context.Context, cli VolumeAPI) error {
+	for _, dir := range t.CacheDirs {
+		_, err := cli.VolumeCreate(ctx, volume.CreateOptions{
+			Name: cacheVolumeName(t.Name, dir),
+			Labels: withNamespace(map[string]string{
+				labelManaged:  "true",
+				labelTask:     t.Name,
+				labelCacheDir: dir,
+			}),
+		})
+		if err != nil {
+			return fmt.Errorf("error creating cache volume for %s of task '%s': %w", dir, t.Name, err)
+		}
+	}
+	return nil
+}
+
+// collectCacheVolumes returns the cache volume names of the given tasks and all their transitive dependencies
+func collectCacheVolumes(tasks []*Task) map[string]bool {
+	names := map[string]bool{}
+	seen := map[*Task]bool{}
+	var visit func(t *Task)
+	visit = func(t *Task) {
+		if seen[t] {
+			return
+		}
+		seen[t] = true
+		for _, dir := range t.CacheDirs {
+			names[cacheVolumeName(t.Name, dir)] = true
+		}
+		for _, dependency := range t.Dependencies {
+			visit(dependency.Task)
+		}
+	}
+
+	for _, task := range tasks {
+		visit(task)
+	}
+	return names
+}
+
+// PrunedVolume describes a cache volume selected by PruneCacheVolumes.
+type PrunedVolume struct {
+	Name     string
+	TaskName string
+	Dir      string
+	Created  time.Time
+}
+
+// selectCacheVolumes applies the prune filters to the given volumes. Volumes without a parsable
+// creation time are treated as new.
+func selectCacheVolumes(volumes []*volume.Volume, opts PruneOptions, now time.Time) []PrunedVolume {
+	keep := collectCacheVolumes(opts.Keep)
+
+	var candidates []PrunedVolume
+	for _, v := range volumes {
+		if v.Labels[labelManaged] != "true" || v.Labels[labelCacheDir] == "" || !inNamespace(v.Labels) {
+			continue
+		}
+		created, _ := time.Parse(time.RFC3339, v.CreatedAt)
+		if created.IsZero() {
+			created = now
+		}
+
+		if opts.TaskName != "" && v.Labels[labelTask] != opts.TaskName {
+			continue
+		}
+		if opts.OlderThan > 0 && now.Sub(created) < opts.OlderThan {
+			continue
+		}
+		if keep[v.Name] {
+			continue
+		}
+
+		candidates = append(candidates, PrunedVolume{
+			Name:     v.Name,
+			TaskName: v.Labels[labelTask],
+			Dir:      v.Labels[labelCacheDir],
+			Created:  created,
+		})
+	}
+	return candidates
+}
+
+// PruneCacheVolumes removes the cache volumes matching the given options and returns the volumes it
+// removed (or would remove in a dry run). Volumes still used by a container are kept, so containers
+// should be pruned first.
+func PruneCacheVolumes(ctx context.Context, cli VolumeAPI, opts PruneOptions) ([]PrunedVolume, error) {
+	listFilters := filters.NewArgs()
+	listFilters.Add("label", labelManaged+"=true")
+	listFilters.Add("label", labelCacheDir)
+
+	resp, err := cli.VolumeList(ctx, volume.ListOptions{Filters: listFilters})
+	if err != nil {
+		return nil, fmt.Errorf("error listing cache volumes: %w", err)
+	}
+
+	candidates := selectCacheVolumes(resp.Volumes, opts, time.Now())
+	if opts.DryRun {
+		return candidates, nil
+	}
+
+	var removed []PrunedVolume
+	for _, candidate := range candidates {
+		fmt.Printf("Removing cache volume %s (task '%s', %s)\n", candidate.Name, candidate.TaskName, candidate.Dir)
+		if err := cli.VolumeRemove(ctx, candidate.Name, false); err != nil {
+			if errdefs.IsConflict(err) {
+				fmt.Printf("Keeping cache volume %s, it is used by a container\n", candidate.Name)
+				continue
+			}
+			return nil, fmt.Errorf("error removing cache volume %s: %w", candidate.Name, err)
+		}
+		removed = append(removed, candidate)
+	}
+	return removed, nil
+}
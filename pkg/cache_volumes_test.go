@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/volume"
+)
+
+func TestCacheVolumeName(t *testing.T) {
+	name := cacheVolumeName("build java", "/root/.m2")
+	if !strings.HasPrefix(name, "buildvault_cache_build-java_") || len(name) != len("buildvault_cache_build-java_")+12 {
+		t.Errorf("Unexpected volume name %s", name)
+	}
+	if cacheVolumeName("build java", "/root/.m2") != name {
+		t.Errorf("Volume names should be stable across runs")
+	}
+	if cacheVolumeName("build java", "/go/pkg/mod") == name {
+		t.Errorf("Different directories should use different volumes")
+	}
+
+	task := &Task{Name: "build", BaseImage: "maven", CacheDirs: []string{"/root/.m2"}}
+	before := task.generateHash()
+	task.CacheDirs = nil
+	if task.generateHash() != before {
+		t.Errorf("Cache directories should not be part of the task hash")
+	}
+}
+
+func TestSelectCacheVolumes(t *testing.T) {
+	now := time.Now()
+	current := &Task{Name: "build", CacheDirs: []string{"/root/.m2"}}
+	cacheVolume := func(taskName, dir string, age time.Duration) *volume.Volume {
+		return &volume.Volume{
+			Name:      cacheVolumeName(taskName, dir),
+			CreatedAt: now.Add(-age).Format(time.RFC3339),
+			Labels:    map[string]string{labelManaged: "true", labelTask: taskName, labelCacheDir: dir},
+		}
+	}
+	volumes := []*volume.Volume{
+		cacheVolume("build", "/root/.m2", time.Hour),
+		cacheVolume("build", "/old/cache", 48*time.Hour),
+		cacheVolume("test", "/go/pkg/mod", 2*time.Hour),
+		{Name: "unrelated", Labels: map[string]string{}},
+	}
+
+	if all := selectCacheVolumes(volumes, PruneOptions{}, now); len(all) != 3 {
+		t.Errorf("Expected all 3 cache volumes without filters, got %+v", all)
+	}
+	if byTask := selectCacheVolumes(volumes, PruneOptions{TaskName: "test"}, now); len(byTask) != 1 || byTask[0].Dir != "/go/pkg/mod" {
+		t.Errorf("Task filter should only select 'test' volumes, got %+v", byTask)
+	}
+	if byAge := selectCacheVolumes(volumes, PruneOptions{OlderThan: 24 * time.Hour}, now); len(byAge) != 1 || byAge[0].Dir != "/old/cache" {
+		t.Errorf("Age filter should only select the old volume, got %+v", byAge)
+	}
+	if unreferenced := selectCacheVolumes(volumes, PruneOptions{Keep: []*Task{current}}, now); len(unreferenced) != 2 {
+		t.Errorf("Keep should protect the cache volumes of current tasks, got %+v", unreferenced)
+	}
+}
@@ -0,0 +1,122 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// The hash of a task is its cache key. It is computed from named parts, like its image, every command
+// and every hash input, and a snapshot keeps the digest of each of them. Comparing the snapshot stored
+// with the artifacts of an earlier hash of a task to its current one tells why the task is stale.
+
+// HashComponent is the digest of one part of a cache key.
+type HashComponent struct {
+	Name   string `json:"name"`   // What the part covers, like "image", "command #3" or "input /src/go.sum"
+	Digest string `json:"digest"` // Digest of what the part contributes to the hash
+}
+
+// Snapshot is a cache key with the components it was computed from.
+type Snapshot struct {
+	Hash       string          `json:"hash"`
+	Version    int             `json:"version"` // HashVersion of the hash
+	Components []HashComponent `json:"components"`
+}
+
+// hashPart is what one component of a task contributes to its hash
+type hashPart struct {
+	name    string
+	data    []byte
+	details []hashPart // Finer components explaining changes of the part, e.g. every command of all commands
+}
+
+type hashParts []hashPart
+
+func (p *hashParts) add(name string, data []byte, details ...hashPart) {
+	*p = append(*p, hashPart{name: name, data: data, details: details})
+}
+
+func (p *hashParts) addf(name, format string, args ...any) {
+	p.add(name, fmt.Appendf(nil, format, args...))
+}
+
+// CacheKey returns the hash of t with the digests of its components. Like the hash, it is only complete
+// once the host inputs of t are resolved and, for some tasks, once its image is built or its dependencies
+// executed.
+func (t *Task) CacheKey() Snapshot {
+	snapshot := Snapshot{Hash: t.generateHash(), Version: HashVersion}
+	for _, part := range t.hashParts() {
+		explained := part.details
+		if explained == nil {
+			explained = []hashPart{part}
+		}
+		for _, component := range explained {
+			// Empty parts only separate others in the hash, they are left out like absent ones
+			if len(component.data) == 0 {
+				continue
+			}
+			sum := sha256.Sum256(component.data)
+			snapshot.Components = append(snapshot.Components, HashComponent{Name: component.name, Digest: hex.EncodeToString(sum[:])})
+		}
+	}
+	return snapshot
+}
+
+// ExplainStaleness returns which components of the cache key of t differ from those of prev, like
+// "command #3 changed" or "input /src/go.sum changed", in the order of the current components. It
+// returns nil if the hash did not change.
+func (t *Task) ExplainStaleness(prev Snapshot) []string {
+	current := t.CacheKey()
+	if current.Hash == prev.Hash {
+		return nil
+	}
+	if current.Version != prev.Version {
+		return []string{fmt.Sprintf("the hash version changed from %d to %d", prev.Version, current.Version)}
+	}
+
+	previous := map[string]string{}
+	for _, component := range prev.Components {
+		previous[component.Name] = component.Digest
+	}
+	var changes []string
+	seen := map[string]bool{}
+	for _, component := range current.Components {
+		seen[component.Name] = true
+		digest, ok := previous[component.Name]
+		switch {
+		case !ok:
+			changes = append(changes, component.Name+" was added")
+		case digest != component.Digest:
+			changes = append(changes, component.Name+" changed")
+		}
+	}
+	for _, component := range prev.Components {
+		if !seen[component.Name] {
+			changes = append(changes, component.Name+" was removed")
+		}
+	}
+	if len(changes) == 0 {
+		// Only the order of the components differs
+		changes = append(changes, "the order of its components changed")
+	}
+	return changes
+}
+
+// lastSnapshot returns the snapshot of the newest generation of the task name in the local store, nil
+// if none of its generations has one
+func (s *ArtifactStore) lastSnapshot(name string) (*Snapshot, error) {
+	manifests, err := s.manifests()
+	if err != nil {
+		return nil, err
+	}
+	var newest *StoreManifest
+	for _, manifest := range manifests {
+		if manifest.Task == name && manifest.Snapshot != nil && (newest == nil || manifest.Created.After(newest.Created)) {
+			newest = manifest
+		}
+	}
+	if newest == nil {
+		return nil, nil
+	}
+	return newest.Snapshot, nil
+}
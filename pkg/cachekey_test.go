@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExplainStaleness(t *testing.T) {
+	task := &Task{Name: "build", BaseImage: "golang:1.22", Commands: []string{"go mod download", "go vet", "go build"},
+		HashInputs: []string{"/src/go.sum"}, inputDigests: map[string]string{"/src/go.sum": "sha256:a"}}
+	previous := task.CacheKey()
+	if previous.Hash != task.generateHash() || previous.Version != HashVersion {
+		t.Fatalf("Unexpected cache key %+v", previous)
+	}
+	if changes := task.ExplainStaleness(previous); changes != nil {
+		t.Errorf("Expected no changes of an identical task, got %v", changes)
+	}
+
+	task.BaseImage = "golang:1.23"
+	task.Commands[2] = "go build -trimpath"
+	task.inputDigests["/src/go.sum"] = "sha256:b"
+	task.Commands = append(task.Commands, "go test")
+	want := []string{"image changed", "command #3 changed", "command #4 was added", "input /src/go.sum changed"}
+	if changes := task.ExplainStaleness(previous); !reflect.DeepEqual(changes, want) {
+		t.Errorf("Expected %v, got %v", want, changes)
+	}
+
+	task = &Task{Name: "build", BaseImage: "golang:1.22", Secrets: []Secret{{Name: "TOKEN", Value: "a"}}}
+	previous = task.CacheKey()
+	task.Secrets = nil
+	if changes := task.ExplainStaleness(previous); !reflect.DeepEqual(changes, []string{"secret TOKEN was removed"}) {
+		t.Errorf("Unexpected changes %v", changes)
+	}
+
+	previous.Version = 1
+	if changes := task.ExplainStaleness(previous); len(changes) != 1 || changes[0] != fmt.Sprintf("the hash version changed from 1 to %d", HashVersion) {
+		t.Errorf("Expected a changed hash version, got %v", changes)
+	}
+}
+
+func TestPlanChanges(t *testing.T) {
+	store, err := NewArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Name: "build", BaseImage: "alpine", Commands: []string{"make"}, ArtifactStore: store}
+	snapshot := task.CacheKey()
+	if err := store.writeManifest(&StoreManifest{Task: "build", Hash: snapshot.Hash, Created: time.Now(), Snapshot: &snapshot}); err != nil {
+		t.Fatal(err)
+	}
+
+	task.Commands = []string{"make all"}
+	steps, err := task.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	if steps[0].CacheHit || !reflect.DeepEqual(steps[0].Changes, []string{"command #1 changed"}) {
+		t.Errorf("Expected the changed command to be explained, got %+v", steps[0])
+	}
+}
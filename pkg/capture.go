@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Host programs consume values generated in task containers, like version numbers, digests or JSON,
+// from the stdout of commands instead of exporting files. The captured stdout is the raw output of the
+// command: it is not masked, the host program knows the secrets it passed anyway.
+
+// validateCapture checks that the commands of t run in execs of their own if their stdout is captured
+func (t *Task) validateCapture() error {
+	if t.CaptureOutput && t.BatchCommands {
+		return fmt.Errorf("task '%s' batches its commands into one exec, which cannot capture the stdout of each command", t.Name)
+	}
+	return nil
+}
+
+// captureStdout makes *stdout also write into the returned buffer if t captures the output of its
+// commands, and returns nil otherwise
+func (t *Task) captureStdout(stdout *io.Writer) *bytes.Buffer {
+	if !t.CaptureOutput {
+		return nil
+	}
+	captured := &bytes.Buffer{}
+	*stdout = io.MultiWriter(*stdout, captured)
+	return captured
+}
+
+// RunAndCapture executes argv in the container of the executed task t, with the user, environment
+// and working directory of its commands, and returns its stdout. The container has to be kept, see
+// CleanupPolicy.
+func (t *Task) RunAndCapture(ctx context.Context, cli ExecAPI, argv ...string) (string, error) {
+	if len(argv) == 0 {
+		return "", errors.New("no command to run")
+	}
+	if t.containerID == "" {
+		return "", fmt.Errorf("task '%s' has no container to run %v in", t.Name, argv)
+	}
+	stdout, err := execCapture(ctx, cli, t.containerID, container.ExecOptions{
+		Cmd:        argv,
+		Env:        t.execEnv(),
+		User:       t.containerUser(),
+		WorkingDir: t.workDir(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("error running %v in task '%s': %w", argv, t.Name, err)
+	}
+	return stdout, nil
+}
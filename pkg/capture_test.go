@@ -0,0 +1,45 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCaptureStdout(t *testing.T) {
+	task := &Task{Name: "version", Commands: []string{"git describe --tags"}}
+	var out bytes.Buffer
+	stdout := io.Writer(&out)
+	if captured := task.captureStdout(&stdout); captured != nil || stdout != &out {
+		t.Fatal("Expected no capture without CaptureOutput")
+	}
+
+	task.CaptureOutput = true
+	captured := task.captureStdout(&stdout)
+	io.WriteString(stdout, "v1.2.3\n")
+	if out.String() != "v1.2.3\n" || captured.String() != "v1.2.3\n" {
+		t.Errorf("Expected the output to be shown and captured, got %q and %q", out.String(), captured.String())
+	}
+
+	task.recordCommand("git describe --tags", 0, 0, captured)
+	if results := task.Result().Commands; len(results) != 1 || strings.TrimSpace(results[0].Stdout) != "v1.2.3" {
+		t.Errorf("Expected the captured stdout in the result, got %+v", results)
+	}
+}
+
+func TestValidateCapture(t *testing.T) {
+	if err := (&Task{Name: "version", CaptureOutput: true, BatchCommands: true}).validateCapture(); err == nil {
+		t.Error("Expected capturing batched commands to be rejected")
+	}
+	if err := (&Task{Name: "version", CaptureOutput: true}).validateCapture(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestRunAndCaptureNeedsContainer(t *testing.T) {
+	if _, err := (&Task{Name: "version"}).RunAndCapture(context.Background(), nil, "cat", "/VERSION"); err == nil {
+		t.Error("Expected an error for a task without container")
+	}
+}
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// CleanupPolicy decides whether the container of a task is preserved after the run. Containers are
+// only removed once the run is over, since dependents copy artifacts from the containers of their
+// dependencies during the run.
+type CleanupPolicy string
+
+const (
+	KeepAlways    CleanupPolicy = "keep-always"     // Preserve the container (default)
+	KeepOnFailure CleanupPolicy = "keep-on-failure" // Preserve the container only for debugging a failure
+	RemoveAlways  CleanupPolicy = "remove-always"   // Remove the container, whether the task failed or not
+)
+
+// ParseCleanupPolicy parses the name of a cleanup policy, empty meaning keep-always.
+func ParseCleanupPolicy(s string) (CleanupPolicy, error) {
+	switch policy := CleanupPolicy(s); policy {
+	case "", KeepAlways:
+		return KeepAlways, nil
+	case KeepOnFailure, RemoveAlways:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown cleanup policy '%s', expected keep-always, keep-on-failure or remove-always", s)
+	}
+}
+
+// removesContainer reports whether the cleanup policy of t removes the container it created
+func (t *Task) removesContainer() bool {
+	if t.Container != "" || t.containerID == "" {
+		// Existing containers are not ours to remove
+		return false
+	}
+	switch t.Cleanup {
+	case RemoveAlways:
+		return true
+	case KeepOnFailure:
+		return t.completed
+	}
+	return false
+}
+
+// containerCleanup collects the tasks of a run whose containers are removed when it is over
+type containerCleanup struct {
+	mu    sync.Mutex
+	tasks []*Task
+}
+
+// add removes the container of t at the end of the run, if its policy says so
+func (c *containerCleanup) add(t *Task) {
+	if !t.removesContainer() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tasks = append(c.tasks, t)
+}
+
+// run removes the collected containers. Failing to is only reported, it does not fail the run.
+func (c *containerCleanup) run(ctx context.Context, cli ContainerAPI) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, task := range c.tasks {
+		if err := cli.ContainerRemove(ctx, task.containerID, container.RemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
+			fmt.Printf("Failed to remove container of task '%s': %v\n", task.Name, err)
+			continue
+		}
+		fmt.Printf("Removed container of task '%s' (cleanup policy %s)\n", task.Name, task.Cleanup)
+		task.containerID = ""
+	}
+	c.tasks = nil
+}
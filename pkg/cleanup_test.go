@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRemovesContainer(t *testing.T) {
+	tests := []struct {
+		policy    CleanupPolicy
+		completed bool
+		want      bool
+	}{
+		{"", true, false},
+		{KeepAlways, false, false},
+		{KeepOnFailure, true, true},
+		{KeepOnFailure, false, false},
+		{RemoveAlways, true, true},
+		{RemoveAlways, false, true},
+	}
+	for _, tt := range tests {
+		task := &Task{Name: "build", Cleanup: tt.policy, containerID: "abc", completed: tt.completed}
+		if got := task.removesContainer(); got != tt.want {
+			t.Errorf("Policy %q with completed=%v: expected %v, got %v", tt.policy, tt.completed, tt.want, got)
+		}
+	}
+
+	cached := &Task{Name: "cached", Cleanup: RemoveAlways, completed: true}
+	external := &Task{Name: "db", Container: "postgres", Cleanup: RemoveAlways, containerID: "def"}
+	if cached.removesContainer() || external.removesContainer() {
+		t.Error("Expected tasks without a container of their own to be left alone")
+	}
+
+	cleanup := &containerCleanup{}
+	for _, task := range []*Task{cached, external, {Name: "test", Cleanup: RemoveAlways, containerID: "ghi"}} {
+		cleanup.add(task)
+	}
+	if len(cleanup.tasks) != 1 || cleanup.tasks[0].Name != "test" {
+		t.Errorf("Expected only the container of test to be removed, got %d", len(cleanup.tasks))
+	}
+}
+
+func TestPipelineCleanupPolicy(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+cleanup: keep-on-failure
+tasks:
+  - name: build
+    image: alpine
+  - name: test
+    image: alpine
+    cleanup: remove-always
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+	build, _ := pipeline.Task("build")
+	test, _ := pipeline.Task("test")
+	if build.Cleanup != KeepOnFailure || test.Cleanup != RemoveAlways {
+		t.Errorf("Expected the default and the policy of test, got %q and %q", build.Cleanup, test.Cleanup)
+	}
+
+	_, err = ParsePipeline([]byte("tasks:\n  - name: build\n    image: alpine\n    cleanup: sometimes\n"))
+	if err == nil || !strings.Contains(err.Error(), "unknown cleanup policy") {
+		t.Errorf("Expected an unknown policy to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Commands run through a shell, sh -c by default and Shell if set (e.g. bash -eo pipefail -c). Cmd is
+// the alternative for images without any shell: each entry is an argv executed as is. A prelude is
+// shared setup, like set -euo pipefail or exporting PATH, that runs in the same shell before every
+// command, so it does not have to be repeated in each of them.
+
+// validateCommands checks that t uses either shell commands or raw commands
+func (t *Task) validateCommands() error {
+	if len(t.Cmd) > 0 && len(t.Commands) > 0 {
+		return fmt.Errorf("task '%s' can either have commands or raw commands (cmd)", t.Name)
+	}
+	if len(t.Cmd) > 0 && len(t.Shell) > 0 {
+		return fmt.Errorf("task '%s' has raw commands, which do not run through its shell", t.Name)
+	}
+	if len(t.Cmd) > 0 && t.Prelude != "" {
+		return fmt.Errorf("task '%s' has raw commands, which do not run through a shell for its prelude", t.Name)
+	}
+	for idx, argv := range t.Cmd {
+		if len(argv) == 0 {
+			return fmt.Errorf("raw command %d of task '%s' is empty", idx+1, t.Name)
+		}
+	}
+	return nil
+}
+
+// commandLines returns the commands of t as shown in output and errors
+func (t *Task) commandLines() []string {
+	if len(t.Cmd) == 0 {
+		return t.Commands
+	}
+	lines := make([]string, len(t.Cmd))
+	for idx, argv := range t.Cmd {
+		lines[idx] = strings.Join(argv, " ")
+	}
+	return lines
+}
+
+// commandArgv returns the argv executing the command at idx in the task container
+func (t *Task) commandArgv(idx int) []string {
+	if len(t.Cmd) > 0 {
+		return t.Cmd[idx]
+	}
+	return t.shellCommand(t.withPrelude(t.Commands[idx]))
+}
+
+// withPrelude returns the shell code cmd preceded by the prelude of t
+func (t *Task) withPrelude(cmd string) string {
+	if t.Prelude == "" {
+		return cmd
+	}
+	return strings.TrimRight(t.Prelude, "\n") + "\n" + cmd
+}
+
+// scriptLine returns the command at idx as a line of a batch script, which always runs in a shell
+func (t *Task) scriptLine(idx int) string {
+	var argv []string
+	switch {
+	case len(t.Cmd) > 0:
+		argv = t.Cmd[idx]
+	case len(t.Shell) > 0:
+		argv = append(slices.Clone(t.Shell), t.withPrelude(t.Commands[idx]))
+	default:
+		argv = []string{"sh", "-c", t.withPrelude(t.Commands[idx])}
+	}
+
+	quoted := make([]string, len(argv))
+	for i, arg := range argv {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
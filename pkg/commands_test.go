@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCommandArgv(t *testing.T) {
+	task := &Task{Name: "test", Commands: []string{"make test"}}
+	if argv := task.commandArgv(0); !slices.Equal(argv, []string{"sh", "-c", "make test"}) {
+		t.Errorf("Unexpected default argv %v", argv)
+	}
+
+	task.Shell = []string{"bash", "-eo", "pipefail", "-c"}
+	if argv := task.commandArgv(0); !slices.Equal(argv, []string{"bash", "-eo", "pipefail", "-c", "make test"}) {
+		t.Errorf("Unexpected argv with a shell %v", argv)
+	}
+
+	raw := &Task{Name: "test", Cmd: [][]string{{"/app/server", "--selftest"}}}
+	if argv := raw.commandArgv(0); !slices.Equal(argv, []string{"/app/server", "--selftest"}) {
+		t.Errorf("Unexpected raw argv %v", argv)
+	}
+	if lines := raw.commandLines(); len(lines) != 1 || lines[0] != "/app/server --selftest" {
+		t.Errorf("Unexpected command lines %v", lines)
+	}
+}
+
+func TestValidateCommands(t *testing.T) {
+	for name, task := range map[string]*Task{
+		"both":  {Name: "test", Commands: []string{"true"}, Cmd: [][]string{{"true"}}},
+		"shell": {Name: "test", Shell: []string{"bash", "-c"}, Cmd: [][]string{{"true"}}},
+		"empty": {Name: "test", Cmd: [][]string{{}}},
+	} {
+		if err := task.validateCommands(); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestBatchScriptRawCommands(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	task := &Task{Name: "batch", Cmd: [][]string{{"echo", "it's", "raw"}, {"sh", "-c", "exit 2"}}}
+	marker := "::buildvault-step-test::"
+	raw, _ := exec.Command("sh", "-c", task.batchScript(marker)).Output()
+
+	var out strings.Builder
+	steps := &stepWriter{out: &out, marker: marker, commands: task.commandLines()}
+	steps.Write(raw)
+	steps.flush()
+
+	if steps.failed != 2 || steps.exitCode != 2 {
+		t.Errorf("Expected command 2 to fail with exit code 2, got step %d code %d", steps.failed, steps.exitCode)
+	}
+	if !strings.Contains(out.String(), "it's raw\n") {
+		t.Errorf("Arguments of raw commands should be passed as is:\n%s", out.String())
+	}
+}
+
+func TestShellChangesTaskHash(t *testing.T) {
+	task := &Task{Name: "test", BaseImage: "alpine", Commands: []string{"make"}}
+	before := task.generateHash()
+	task.Shell = []string{"bash", "-c"}
+	if task.generateHash() == before {
+		t.Errorf("Changing the shell should change the task hash")
+	}
+}
+
+func TestPrelude(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	task := &Task{Name: "test", Prelude: "set -eu\nGREETING=hello\n", Commands: []string{"echo $GREETING"}}
+	if argv := task.commandArgv(0); !slices.Equal(argv, []string{"sh", "-c", "set -eu\nGREETING=hello\necho $GREETING"}) {
+		t.Errorf("Unexpected argv %q", argv)
+	}
+	out, err := exec.Command("sh", "-c", task.scriptLine(0)).Output()
+	if err != nil || string(out) != "hello\n" {
+		t.Errorf("The prelude should run in the shell of the command, got %q (%v)", out, err)
+	}
+
+	before := (&Task{Name: "test", Commands: task.Commands}).generateHash()
+	if task.generateHash() == before {
+		t.Errorf("The prelude should change the task hash")
+	}
+
+	task = &Task{Name: "test", Prelude: "set -eu", Cmd: [][]string{{"make"}}}
+	if err := task.validateCommands(); err == nil {
+		t.Errorf("Expected an error for a prelude with raw commands")
+	}
+}
+
+func TestParsePipelinePrelude(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+prelude: set -euo pipefail
+tasks:
+  - name: build
+    image: alpine
+    commands: [make]
+  - name: test
+    image: alpine
+    prelude: set -x
+    commands: [make test]
+  - name: raw
+    image: alpine
+    cmd: [[make]]
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+	for name, want := range map[string]string{"build": "set -euo pipefail", "test": "set -x", "raw": ""} {
+		if task, _ := pipeline.Task(name); task.Prelude != want {
+			t.Errorf("Expected prelude %q for task '%s', got %q", want, name, task.Prelude)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// labelHash records the task hash an output image was committed for
+const labelHash = "buildvault.hash"
+
+// CommitImage commits the container of t, after its commands ran, to the image ref, so a pipeline can
+// deliver a runnable image. The image runs like the task's base image: with its command, entrypoint,
+// environment, user and working directory rather than the keep-alive command of task containers.
+func (t *Task) CommitImage(ctx context.Context, cli DockerAPI, ref string) error {
+	if t.containerID == "" {
+		return fmt.Errorf("task '%s' has no container to commit", t.Name)
+	}
+
+	config, err := t.outputImageConfig(ctx, cli, t.imageID)
+	if err != nil {
+		return err
+	}
+	config.Labels[labelHash] = t.generateHash()
+
+	id, err := commitContainer(ctx, cli, t.containerID, ref, fmt.Sprintf("Output of task '%s'", t.Name), config)
+	if err != nil {
+		return fmt.Errorf("error committing the container of task '%s' to %s: %w", t.Name, ref, err)
+	}
+	fmt.Printf("Committed the container of task '%s' to image %s (%s)\n", t.Name, ref, id)
+	return nil
+}
+
+// outputImageConfig returns the configuration of images committed from containers of t created from
+// imageID: that of the image, with the user, environment and working directory of the task
+func (t *Task) outputImageConfig(ctx context.Context, cli ImageAPI, imageID string) (*container.Config, error) {
+	config, err := imageConfig(ctx, cli, imageID)
+	if err != nil {
+		return nil, err
+	}
+	taskConfig := t.containerConfig()
+	if taskConfig.User != "" {
+		config.User = taskConfig.User
+	}
+	if taskConfig.Env != nil {
+		config.Env = taskConfig.Env
+	}
+	if taskConfig.WorkingDir != "" {
+		config.WorkingDir = taskConfig.WorkingDir
+	}
+	config.Labels[labelManaged] = "true"
+	config.Labels[labelTask] = t.Name
+	return config, nil
+}
+
+// imageConfig returns a copy of the run configuration of image that can be modified, labels included
+func imageConfig(ctx context.Context, cli ImageAPI, image string) (*container.Config, error) {
+	inspect, err := cli.ImageInspect(ctx, image)
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting image %s: %w", image, err)
+	}
+	config := &container.Config{}
+	if inspect.Config != nil {
+		*config = *inspect.Config
+	}
+	config.Labels = maps.Clone(config.Labels)
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	return config, nil
+}
+
+// commitContainer commits the container id to the image ref with config and returns the image ID
+func commitContainer(ctx context.Context, cli ContainerAPI, id, ref, comment string, config *container.Config) (string, error) {
+	resp, err := cli.ContainerCommit(ctx, id, container.CommitOptions{
+		Reference: ref,
+		Comment:   comment,
+		Config:    config,
+	})
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// pushOutputImage pushes the output image of t if the task asks for it
+func (t *Task) pushOutputImage(ctx context.Context, cli ImageAPI) error {
+	if !t.Push || t.OutputImage == "" {
+		return nil
+	}
+	digest, err := PushImage(ctx, cli, t.OutputImage)
+	if err != nil {
+		return err
+	}
+	t.pushedImage = t.OutputImage + "@" + digest
+	return nil
+}
+
+// outputImageCurrent reports whether the output image of t, if it has one, was committed for its
+// current hash. Tasks whose outputs are stored run again when it was not, to commit it.
+func (t *Task) outputImageCurrent(ctx context.Context, cli ImageAPI) bool {
+	if t.OutputImage == "" {
+		return true
+	}
+	inspect, err := cli.ImageInspect(ctx, t.OutputImage)
+	return err == nil && inspect.Config != nil && inspect.Config.Labels[labelHash] == t.generateHash()
+}
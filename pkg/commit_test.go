@@ -0,0 +1,36 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParsePipelineOutputImage(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+tasks:
+  - name: package
+    image: alpine
+    commands: ["cp /src/app /usr/local/bin/app"]
+    output_image: registry.example.com/app:latest
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+	if image := pipeline.Tasks[0].OutputImage; image != "registry.example.com/app:latest" {
+		t.Errorf("Unexpected output image %q", image)
+	}
+}
+
+func TestOutputImageOfVirtualTask(t *testing.T) {
+	task := &Task{Name: "release", Virtual: true, OutputImage: "app:latest"}
+	if err := task.validateVirtual(); err == nil {
+		t.Errorf("Expected an error for a virtual task with an output image")
+	}
+}
+
+func TestCommitImageWithoutContainer(t *testing.T) {
+	task := &Task{Name: "package", BaseImage: "alpine"}
+	if err := task.CommitImage(context.Background(), nil, "app:latest"); err == nil {
+		t.Errorf("Expected an error for a task without a container")
+	}
+}
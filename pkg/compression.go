@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Artifacts are streamed as uncompressed tar archives. For multi-GB artifacts moving to a remote Docker
+// daemon or a remote cache, compressing the stream trades CPU for throughput: the daemon unpacks gzip and
+// zstd archives itself, and compressed blobs in the remote cache are unpacked when they are fetched.
+// Small artifacts are not worth it and stay uncompressed.
+
+// Compression is the algorithm artifact transfers are compressed with.
+type Compression string
+
+const (
+	CompressionNone Compression = "none"
+	CompressionGzip Compression = "gzip"
+	CompressionZstd Compression = "zstd"
+)
+
+// CompressionLevel trades CPU for smaller transfers.
+type CompressionLevel string
+
+const (
+	CompressionFastest CompressionLevel = "fastest" // Least CPU, for fast networks
+	CompressionDefault CompressionLevel = "default"
+	CompressionBest    CompressionLevel = "best" // Smallest transfers, for slow networks
+)
+
+// DefaultCompressionThreshold is the size below which artifacts are transferred uncompressed.
+const DefaultCompressionThreshold = 1 << 20
+
+// CompressionOptions configures the compression of artifact transfers.
+type CompressionOptions struct {
+	Algorithm Compression      // CompressionNone by default
+	Level     CompressionLevel // CompressionDefault if empty
+	Threshold int64            // Artifacts of a known smaller size are transferred uncompressed, DefaultCompressionThreshold if 0
+}
+
+// compression is how artifact transfers are compressed, set with SetCompression
+var compression = CompressionOptions{Algorithm: CompressionNone}
+
+// ParseCompression parses the name of a compression algorithm, empty meaning none.
+func ParseCompression(s string) (Compression, error) {
+	switch algorithm := Compression(s); algorithm {
+	case "", CompressionNone:
+		return CompressionNone, nil
+	case CompressionGzip, CompressionZstd:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("unknown compression '%s', expected none, gzip or zstd", s)
+	}
+}
+
+// ParseCompressionLevel parses the name of a compression level, empty meaning default.
+func ParseCompressionLevel(s string) (CompressionLevel, error) {
+	switch level := CompressionLevel(s); level {
+	case "":
+		return CompressionDefault, nil
+	case CompressionFastest, CompressionDefault, CompressionBest:
+		return level, nil
+	default:
+		return "", fmt.Errorf("unknown compression level '%s', expected fastest, default or best", s)
+	}
+}
+
+// SetCompression compresses all following artifact copies into containers and uploads to remote caches
+// according to opts.
+func SetCompression(opts CompressionOptions) {
+	if opts.Algorithm == "" {
+		opts.Algorithm = CompressionNone
+	}
+	if opts.Level == "" {
+		opts.Level = CompressionDefault
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultCompressionThreshold
+	}
+	compression = opts
+}
+
+// compressionFor returns the algorithm a transfer of size bytes, negative if unknown, is compressed with
+func compressionFor(size int64) Compression {
+	if compression.Algorithm == CompressionNone || (size >= 0 && size < compression.Threshold) {
+		return CompressionNone
+	}
+	return compression.Algorithm
+}
+
+// artifactSize returns the total size of the files of an artifact of dependency if it was computed in
+// the container of its producer, -1 otherwise
+func artifactSize(dependency *Task, from string) int64 {
+	source, sourcePath, err := resolveArtifactSource(dependency, from)
+	if err != nil {
+		return -1
+	}
+	if size, ok := source.outputSizes[sourcePath]; ok {
+		return size
+	}
+	return -1
+}
+
+// compressWriter returns a writer compressing into w with algorithm at the configured level
+func compressWriter(w io.Writer, algorithm Compression) (io.WriteCloser, error) {
+	switch algorithm {
+	case CompressionGzip:
+		level := map[CompressionLevel]int{CompressionFastest: gzip.BestSpeed, CompressionBest: gzip.BestCompression}[compression.Level]
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CompressionZstd:
+		level := map[CompressionLevel]zstd.EncoderLevel{CompressionFastest: zstd.SpeedFastest, CompressionBest: zstd.SpeedBestCompression}[compression.Level]
+		if level == 0 {
+			level = zstd.SpeedDefault
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	}
+	return nil, fmt.Errorf("unsupported compression '%s'", algorithm)
+}
+
+// compressStream returns r compressed with algorithm, compressed in the background as it is read
+func compressStream(r io.Reader, algorithm Compression) io.ReadCloser {
+	if algorithm == CompressionNone {
+		return io.NopCloser(r)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := compressWriter(pw, algorithm)
+		if err == nil {
+			_, err = io.Copy(w, r)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// decompressStream returns r decompressed with algorithm
+func decompressStream(r io.Reader, algorithm Compression) (io.ReadCloser, error) {
+	switch algorithm {
+	case "", CompressionNone:
+		return io.NopCloser(r), nil
+	case CompressionGzip:
+		return gzip.NewReader(r)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported compression '%s'", algorithm)
+}
+
+// compressionSuffix returns the suffix of the remote cache keys of blobs compressed with algorithm
+func compressionSuffix(algorithm Compression) string {
+	switch algorithm {
+	case CompressionGzip:
+		return ".gz"
+	case CompressionZstd:
+		return ".zst"
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+	"github.com/docker/docker/api/types/container"
+)
+
+// setCompression compresses artifact transfers with opts for the rest of the test
+func setCompression(t *testing.T, opts CompressionOptions) {
+	SetCompression(opts)
+	t.Cleanup(func() { SetCompression(CompressionOptions{}) })
+}
+
+func TestCompressStream(t *testing.T) {
+	data := strings.Repeat("buildvault artifact ", 10000)
+	for _, algorithm := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		for _, level := range []CompressionLevel{CompressionFastest, CompressionBest} {
+			setCompression(t, CompressionOptions{Algorithm: algorithm, Level: level})
+			compressed, err := io.ReadAll(compressStream(strings.NewReader(data), algorithm))
+			if err != nil {
+				t.Fatalf("Failed to compress with %s: %v", algorithm, err)
+			}
+			if algorithm != CompressionNone && len(compressed) >= len(data)/10 {
+				t.Errorf("Expected %s at level %s to compress repetitive data, got %d bytes", algorithm, level, len(compressed))
+			}
+			r, err := decompressStream(bytes.NewReader(compressed), algorithm)
+			if err != nil {
+				t.Fatalf("Failed to decompress with %s: %v", algorithm, err)
+			}
+			if decompressed, err := io.ReadAll(r); err != nil || string(decompressed) != data {
+				t.Errorf("Round trip with %s changed the data: %v", algorithm, err)
+			}
+		}
+	}
+
+	if _, err := ParseCompression("brotli"); err == nil {
+		t.Error("Expected an unknown compression to be rejected")
+	}
+	if _, err := ParseCompressionLevel("9"); err == nil {
+		t.Error("Expected an unknown compression level to be rejected")
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	if algorithm := compressionFor(-1); algorithm != CompressionNone {
+		t.Errorf("Expected no compression by default, got %s", algorithm)
+	}
+	setCompression(t, CompressionOptions{Algorithm: CompressionZstd})
+	for size, want := range map[int64]Compression{
+		-1:                              CompressionZstd,
+		0:                               CompressionNone,
+		DefaultCompressionThreshold - 1: CompressionNone,
+		DefaultCompressionThreshold:     CompressionZstd,
+	} {
+		if algorithm := compressionFor(size); algorithm != want {
+			t.Errorf("Expected %s for %d bytes, got %s", want, size, algorithm)
+		}
+	}
+}
+
+func TestRemoteCacheCompression(t *testing.T) {
+	setCompression(t, CompressionOptions{Algorithm: CompressionZstd, Threshold: 1})
+	server, entries := memoryCacheServer(t)
+	ctx := context.Background()
+	backend, err := NewRemoteBackend(server.URL + "/cache")
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+
+	content := strings.Repeat("compressible ", 1000)
+	storeA, _ := NewArtifactStore(t.TempDir())
+	storeA.SetRemote(backend, RemoteReadWrite)
+	digest, size, err := storeA.putBlob(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	manifest := &StoreManifest{Task: "build", Hash: "0123456789ab", Artifacts: []StoredArtifact{{Path: "/output", Digest: digest, Size: size}}}
+	if err := storeA.writeManifest(manifest); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	if err := storeA.pushRemote(ctx, manifest, io.Discard); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	blob, ok := entries["/cache/"+blobKey(digest)+".zst"]
+	if !ok || len(blob) >= len(content) {
+		t.Fatalf("Expected the blob to be uploaded compressed, got keys %v", entries)
+	}
+
+	// The compressed blob is unpacked into the local store of another machine
+	storeB, _ := NewArtifactStore(t.TempDir())
+	storeB.SetRemote(backend, RemoteReadOnly)
+	loaded, ok, err := storeB.Lookup(ctx, "0123456789ab")
+	if err != nil || !ok {
+		t.Fatalf("Expected remote cache hit, got ok=%v err=%v", ok, err)
+	}
+	if loaded.Artifacts[0].Digest != digest || loaded.Artifacts[0].Compression != "" {
+		t.Errorf("Unexpected manifest from remote: %+v", loaded)
+	}
+}
+
+// copyRecorder records the first bytes of the archives copied into containers
+type copyRecorder struct {
+	*dockertest.Fake
+	copies [][]byte
+}
+
+func (c *copyRecorder) CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options container.CopyToContainerOptions) error {
+	buffered := bufio.NewReader(content)
+	magic, _ := buffered.Peek(4)
+	c.copies = append(c.copies, bytes.Clone(magic))
+	return c.Fake.CopyToContainer(ctx, containerID, dstPath, buffered, options)
+}
+
+func TestArtifactCopyCompression(t *testing.T) {
+	setCompression(t, CompressionOptions{Algorithm: CompressionGzip, Threshold: 4})
+	fake, _, consume := artifactTasks(t, "binary", "binary")
+	cli := &copyRecorder{Fake: fake}
+	if err := consume.Execute(context.Background(), cli); err != nil {
+		t.Fatalf("Failed to execute tasks: %v", err)
+	}
+	c, _ := fake.Container(consume.generateContainerName())
+	if data, err := c.ReadFile("/input/out/app"); err != nil || string(data) != "binary" {
+		t.Errorf("Unexpected artifact %q: %v", data, err)
+	}
+	compressed := false
+	for _, magic := range cli.copies {
+		compressed = compressed || bytes.HasPrefix(magic, []byte{0x1f, 0x8b})
+	}
+	if !compressed {
+		t.Error("Expected the artifact to be copied gzip-compressed")
+	}
+}
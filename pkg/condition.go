@@ -0,0 +1,269 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// A task with a condition only runs when it holds, e.g. a publish task only on the main branch. A task
+// whose condition does not hold is skipped along with every task depending on it, and its own
+// dependencies only execute if another task needs them.
+//
+// Conditions are Go predicates, or expressions in pipeline files:
+//
+//	when: branch == 'main' && env.CI != ''
+//	when: os == 'linux' || branch =~ '^release/'
+//
+// Expressions compare the values branch, os, arch, env.NAME and vars.NAME to each other and to quoted
+// strings with ==, != and =~ (regular expression match), and combine comparisons with !, && and || and
+// parentheses. A value on its own holds if it is not empty.
+
+// Condition decides whether a task runs in env.
+type Condition func(env ConditionEnv) bool
+
+// ConditionEnv is what conditions are evaluated against.
+type ConditionEnv struct {
+	Branch string            // Git branch being built, empty if unknown
+	OS     string            // Operating system of the host, as runtime.GOOS
+	Arch   string            // Architecture of the host, as runtime.GOARCH
+	Env    map[string]string // Environment variables of the host
+	Vars   map[string]string // Pipeline variables, those of the task being evaluated
+}
+
+// DetectConditionEnv returns the environment of this process. The branch is taken from
+// BUILDVAULT_BRANCH, the variables of common CI systems or the git checkout in the working directory.
+func DetectConditionEnv() ConditionEnv {
+	env := ConditionEnv{OS: runtime.GOOS, Arch: runtime.GOARCH, Env: map[string]string{}}
+	for _, variable := range os.Environ() {
+		name, value, _ := strings.Cut(variable, "=")
+		env.Env[name] = value
+	}
+	for _, name := range []string{"BUILDVAULT_BRANCH", "GITHUB_HEAD_REF", "GITHUB_REF_NAME", "CI_COMMIT_BRANCH", "BRANCH_NAME"} {
+		if env.Env[name] != "" {
+			env.Branch = env.Env[name]
+			return env
+		}
+	}
+	if out, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output(); err == nil {
+		if branch := strings.TrimSpace(string(out)); branch != "HEAD" {
+			env.Branch = branch
+		}
+	}
+	return env
+}
+
+// skippedByCondition reports whether t has a condition that does not hold in the environment of the run
+func (t *Task) skippedByCondition() bool {
+	if t.When == nil {
+		return false
+	}
+	if t.options.conditions == nil {
+		env := DetectConditionEnv()
+		t.options.conditions = &env
+	}
+	env := *t.options.conditions
+	if t.Vars != nil {
+		env.Vars = t.Vars
+	}
+	return !t.When(env)
+}
+
+// skippedDependency returns a dependency of t that was skipped, if any
+func (t *Task) skippedDependency() (*Task, bool) {
+	for _, dependency := range t.Dependencies {
+		if dependency.Task.skipped {
+			return dependency.Task, true
+		}
+	}
+	return nil, false
+}
+
+// ParseCondition parses a condition expression.
+func ParseCondition(expr string) (Condition, error) {
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing condition %q: %w", expr, err)
+	}
+	p := &conditionParser{tokens: tokens}
+	condition, err := p.or()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing condition %q: %w", expr, err)
+	}
+	return condition, nil
+}
+
+// conditionValue is an operand of a condition expression
+type conditionValue func(env ConditionEnv) string
+
+// tokenizeCondition splits expr into operators, identifiers and quoted strings, which keep their quotes
+func tokenizeCondition(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, expr[i:i+end+2])
+			i += end + 2
+		case strings.HasPrefix(expr[i:], "==") || strings.HasPrefix(expr[i:], "!=") || strings.HasPrefix(expr[i:], "=~") ||
+			strings.HasPrefix(expr[i:], "&&") || strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, expr[i:i+2])
+			i += 2
+		case c == '!' || c == '(' || c == ')':
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		case isIdentifierByte(c):
+			start := i
+			for i < len(expr) && (isIdentifierByte(expr[i]) || expr[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, expr[start:i])
+		default:
+			return nil, fmt.Errorf("unexpected character %q", c)
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentifierByte(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// conditionParser is a recursive descent parser over the tokens of a condition expression
+type conditionParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *conditionParser) or() (Condition, error) {
+	left, err := p.and()
+	for err == nil && p.peek() == "||" {
+		p.next()
+		var right Condition
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(env ConditionEnv) bool { return l(env) || right(env) }
+		}
+	}
+	return left, err
+}
+
+func (p *conditionParser) and() (Condition, error) {
+	left, err := p.unary()
+	for err == nil && p.peek() == "&&" {
+		p.next()
+		var right Condition
+		if right, err = p.unary(); err == nil {
+			l := left
+			left = func(env ConditionEnv) bool { return l(env) && right(env) }
+		}
+	}
+	return left, err
+}
+
+func (p *conditionParser) unary() (Condition, error) {
+	switch p.peek() {
+	case "!":
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env ConditionEnv) bool { return !operand(env) }, nil
+	case "(":
+		p.next()
+		condition, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return condition, nil
+	}
+	return p.comparison()
+}
+
+func (p *conditionParser) comparison() (Condition, error) {
+	left, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	switch operator := p.peek(); operator {
+	case "==", "!=":
+		p.next()
+		right, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		negate := operator == "!="
+		return func(env ConditionEnv) bool { return (left(env) == right(env)) != negate }, nil
+	case "=~":
+		p.next()
+		pattern := p.next()
+		if !isQuoted(pattern) {
+			return nil, fmt.Errorf("=~ needs a quoted regular expression")
+		}
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return func(env ConditionEnv) bool { return re.MatchString(left(env)) }, nil
+	}
+	return func(env ConditionEnv) bool { return left(env) != "" }, nil
+}
+
+func (p *conditionParser) value() (conditionValue, error) {
+	token := p.next()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of condition")
+	case isQuoted(token):
+		literal := token[1 : len(token)-1]
+		return func(ConditionEnv) string { return literal }, nil
+	case token == "branch":
+		return func(env ConditionEnv) string { return env.Branch }, nil
+	case token == "os":
+		return func(env ConditionEnv) string { return env.OS }, nil
+	case token == "arch":
+		return func(env ConditionEnv) string { return env.Arch }, nil
+	case strings.HasPrefix(token, "env.") && len(token) > len("env."):
+		name := strings.TrimPrefix(token, "env.")
+		return func(env ConditionEnv) string { return env.Env[name] }, nil
+	case strings.HasPrefix(token, "vars.") && len(token) > len("vars."):
+		name := strings.TrimPrefix(token, "vars.")
+		return func(env ConditionEnv) string { return env.Vars[name] }, nil
+	case isIdentifierByte(token[0]):
+		return nil, fmt.Errorf("unknown value '%s', expected branch, os, arch, env.NAME or vars.NAME", token)
+	}
+	return nil, fmt.Errorf("unexpected %s", token)
+}
+
+func isQuoted(token string) bool {
+	return len(token) >= 2 && (token[0] == '\'' || token[0] == '"')
+}
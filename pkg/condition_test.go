@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	env := ConditionEnv{Branch: "release/1.2", OS: "linux", Arch: "amd64", Env: map[string]string{"CI": "true"}}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"branch == 'main'", false},
+		{"branch != \"main\"", true},
+		{"branch =~ '^release/'", true},
+		{"os == 'linux' && arch == 'arm64'", false},
+		{"os == 'linux' && (arch == 'arm64' || env.CI)", true},
+		{"!env.CI", false},
+		{"env.MISSING == ''", true},
+		{"!(os == 'darwin') && !env.MISSING", true},
+	}
+	for _, test := range tests {
+		condition, err := ParseCondition(test.expr)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", test.expr, err)
+			continue
+		}
+		if got := condition(env); got != test.want {
+			t.Errorf("Expected %q to be %v, got %v", test.expr, test.want, got)
+		}
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	for _, expr := range []string{"", "brnch == 'main'", "branch == ", "branch == 'main", "(os == 'linux'", "branch =~ main", "branch =~ '('", "os == 'linux' linux", "branch = 'main'"} {
+		if _, err := ParseCondition(expr); err == nil {
+			t.Errorf("Expected an error for %q", expr)
+		}
+	}
+}
+
+func TestTaskSkippedByCondition(t *testing.T) {
+	main, _ := ParseCondition("branch == 'main'")
+	task := &Task{Name: "publish", BaseImage: "alpine", When: main}
+
+	// A skipped task never needs Docker
+	if err := task.Execute(context.Background(), nil, WithConditionEnv(ConditionEnv{Branch: "feature"})); err != nil {
+		t.Fatalf("Failed to skip task: %v", err)
+	}
+	if task.status() != StatusSkipped || task.completed {
+		t.Errorf("Expected the task to be skipped, got %s", task.status())
+	}
+}
+
+func TestPlanSkipsDependentsOfSkippedTasks(t *testing.T) {
+	never := func(ConditionEnv) bool { return false }
+	publish := &Task{Name: "publish", BaseImage: "alpine", When: never}
+	announce := &Task{Name: "announce", BaseImage: "alpine", Dependencies: []Dependency{{Task: publish}}}
+
+	steps, err := PlanTasks(context.Background(), []*Task{announce})
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	for _, step := range steps {
+		if !step.Skipped {
+			t.Errorf("Expected '%s' to be skipped, got %+v", step.Task, step)
+		}
+	}
+}
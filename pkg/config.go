@@ -0,0 +1,281 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Defaults of the command line are configured in layers, each overriding the one before: the global
+// config file of the user, the config file of the workspace, environment variables and finally the
+// flags of a command. The Docker daemon is the exception, a pipeline file selecting one has precedence
+// over docker.host and $DOCKER_HOST, and only --docker-host overrides it.
+
+// Config is the configuration of the command line.
+type Config struct {
+	Docker        DockerConfig   `yaml:"docker,omitempty"`
+	Registry      RegistryConfig `yaml:"registry,omitempty"`
+	MaxExecs      int            `yaml:"max_execs,omitempty"`      // Concurrent execs on the Docker daemon
+	MaxCopies     int            `yaml:"max_copies,omitempty"`     // Concurrent archive copies on the Docker daemon
+	Output        string         `yaml:"output,omitempty"`         // How run combines the output of tasks: grouped, prefixed or json
+	ArtifactStore string         `yaml:"artifact_store,omitempty"` // Directory of the artifact store
+	DownloadCache string         `yaml:"download_cache,omitempty"` // Directory downloads are cached in
+	Namespace     string         `yaml:"namespace,omitempty"`      // Namespace of the Docker resources, see SetNamespace
+	Compression   string         `yaml:"compression,omitempty"`    // How large artifact transfers are compressed: none, gzip or zstd
+
+	origins map[string]string // Where the value of each key came from
+}
+
+// DockerConfig configures the Docker daemon.
+type DockerConfig struct {
+	Host string `yaml:"host,omitempty"` // Docker daemon, like DOCKER_HOST
+}
+
+// RegistryConfig configures how buildvault authenticates to registries.
+type RegistryConfig struct {
+	Config string `yaml:"config,omitempty"` // Directory of the docker CLI configuration with the credentials, like DOCKER_CONFIG
+}
+
+// configSetting is a key of the configuration
+type configSetting struct {
+	key  string
+	env  string // Environment variable overriding the config files
+	path bool   // Whether the value is a path, relative paths are resolved against the directory of the layer
+	get  func(c *Config) string
+	set  func(c *Config, value string) error
+}
+
+var configSettings = []configSetting{
+	{key: "docker.host", env: "DOCKER_HOST",
+		get: func(c *Config) string { return c.Docker.Host },
+		set: func(c *Config, value string) error { c.Docker.Host = value; return nil }},
+	{key: "registry.config", env: "DOCKER_CONFIG", path: true,
+		get: func(c *Config) string { return c.Registry.Config },
+		set: func(c *Config, value string) error { c.Registry.Config = value; return nil }},
+	{key: "max_execs", env: "BUILDVAULT_MAX_EXECS",
+		get: func(c *Config) string { return formatConfigInt(c.MaxExecs) },
+		set: func(c *Config, value string) (err error) { c.MaxExecs, err = parseConfigInt(value); return err }},
+	{key: "max_copies", env: "BUILDVAULT_MAX_COPIES",
+		get: func(c *Config) string { return formatConfigInt(c.MaxCopies) },
+		set: func(c *Config, value string) (err error) { c.MaxCopies, err = parseConfigInt(value); return err }},
+	{key: "output", env: "BUILDVAULT_OUTPUT",
+		get: func(c *Config) string { return c.Output },
+		set: func(c *Config, value string) error {
+			if value != "" && value != "json" {
+				if _, err := ParseOutputMode(value); err != nil {
+					return errors.New("expected grouped, prefixed or json")
+				}
+			}
+			c.Output = value
+			return nil
+		}},
+	{key: "artifact_store", env: "BUILDVAULT_ARTIFACT_STORE", path: true,
+		get: func(c *Config) string { return c.ArtifactStore },
+		set: func(c *Config, value string) error { c.ArtifactStore = value; return nil }},
+	{key: "download_cache", env: "BUILDVAULT_DOWNLOAD_CACHE", path: true,
+		get: func(c *Config) string { return c.DownloadCache },
+		set: func(c *Config, value string) error { c.DownloadCache = value; return nil }},
+	{key: "namespace", env: "BUILDVAULT_NAMESPACE",
+		get: func(c *Config) string { return c.Namespace },
+		set: func(c *Config, value string) error {
+			if value != "" && !namespaceValid.MatchString(value) {
+				return errors.New("expected lowercase letters, digits, dots and dashes")
+			}
+			c.Namespace = value
+			return nil
+		}},
+	{key: "compression", env: "BUILDVAULT_COMPRESSION",
+		get: func(c *Config) string { return c.Compression },
+		set: func(c *Config, value string) error {
+			if _, err := ParseCompression(value); err != nil {
+				return errors.New("expected none, gzip or zstd")
+			}
+			c.Compression = value
+			return nil
+		}},
+}
+
+func formatConfigInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+func parseConfigInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, errors.New("expected a positive number")
+	}
+	return n, nil
+}
+
+func findConfigSetting(key string) (*configSetting, error) {
+	for i := range configSettings {
+		if configSettings[i].key == key {
+			return &configSettings[i], nil
+		}
+	}
+	return nil, configErrorf("unknown config key '%s', expected one of %s", key, strings.Join(ConfigKeys(), ", "))
+}
+
+// ConfigKeys returns the keys of the configuration.
+func ConfigKeys() []string {
+	var keys []string
+	for _, setting := range configSettings {
+		keys = append(keys, setting.key)
+	}
+	return keys
+}
+
+// GlobalConfigPath returns the path of the global config file: $BUILDVAULT_CONFIG, or
+// buildvault/config.yaml in $XDG_CONFIG_HOME or ~/.config.
+func GlobalConfigPath() (string, error) {
+	if path := os.Getenv("BUILDVAULT_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("error finding global config: %w", err)
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "buildvault", "config.yaml"), nil
+}
+
+// ConfigPath returns the path of the config file of w.
+func (w *Workspace) ConfigPath() string {
+	return filepath.Join(w.StateDir(), "config.yaml")
+}
+
+// LoadConfig returns the configuration layered from the global config file, the config file of
+// workspace, which may be nil, and the environment.
+func LoadConfig(workspace *Workspace) (*Config, error) {
+	config := &Config{origins: map[string]string{}}
+
+	globalPath, err := GlobalConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	home, _ := os.UserHomeDir()
+	layers := []struct{ path, dir string }{{globalPath, home}}
+	if workspace != nil {
+		layers = append(layers, struct{ path, dir string }{workspace.ConfigPath(), workspace.Root})
+	}
+	for _, layer := range layers {
+		file, err := ReadConfigFile(layer.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, setting := range configSettings {
+			if value := setting.get(file); value != "" {
+				if setting.path {
+					value = resolveConfigPath(value, layer.dir)
+				}
+				setting.set(config, value)
+				config.origins[setting.key] = layer.path
+			}
+		}
+	}
+
+	for _, setting := range configSettings {
+		if value := os.Getenv(setting.env); value != "" {
+			if err := setting.set(config, value); err != nil {
+				return nil, configErrorf("invalid $%s '%s': %w", setting.env, value, err)
+			}
+			config.origins[setting.key] = "$" + setting.env
+		}
+	}
+	return config, nil
+}
+
+// resolveConfigPath expands a leading ~ of path to home and makes relative paths relative to dir
+func resolveConfigPath(path, dir string) string {
+	home, _ := os.UserHomeDir()
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(home, path[1:])
+	}
+	if filepath.IsAbs(path) || dir == "" {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// ReadConfigFile reads the config file at path, returning an empty configuration if there is none.
+func ReadConfigFile(path string) (*Config, error) {
+	config := &Config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, configErrorf("error reading config file: %w", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return nil, configErrorf("error parsing config file %s: %w", path, err)
+	}
+	for _, setting := range configSettings {
+		if value := setting.get(config); value != "" {
+			if err := setting.set(&Config{}, value); err != nil {
+				return nil, configErrorf("invalid %s '%s' in %s: %w", setting.key, value, path, err)
+			}
+		}
+	}
+	return config, nil
+}
+
+// WriteFile writes c to the config file at path.
+func (c *Config) WriteFile(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("error encoding config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error creating config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("error writing config file: %w", err)
+	}
+	return nil
+}
+
+// Get returns the value of key, empty if it is not set.
+func (c *Config) Get(key string) (string, error) {
+	setting, err := findConfigSetting(key)
+	if err != nil {
+		return "", err
+	}
+	return setting.get(c), nil
+}
+
+// Set sets key to value, an empty value unsets it.
+func (c *Config) Set(key, value string) error {
+	setting, err := findConfigSetting(key)
+	if err != nil {
+		return err
+	}
+	if err := setting.set(c, value); err != nil {
+		return configErrorf("invalid %s '%s': %w", key, value, err)
+	}
+	return nil
+}
+
+// Origin returns where the value of key of a loaded configuration came from, a config file or an
+// environment variable, empty if it is not set.
+func (c *Config) Origin(key string) string {
+	return c.origins[key]
+}
@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	for _, setting := range configSettings {
+		t.Setenv(setting.env, "")
+	}
+	global := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("BUILDVAULT_CONFIG", global)
+	root := t.TempDir()
+	workspace := &Workspace{Root: root, Pipeline: filepath.Join(root, "buildvault.yaml")}
+
+	globalConfig := &Config{MaxExecs: 4, Output: "grouped", DownloadCache: "/var/cache/buildvault", Namespace: "laptop"}
+	if err := globalConfig.WriteFile(global); err != nil {
+		t.Fatal(err)
+	}
+	workspaceConfig := &Config{}
+	for key, value := range map[string]string{"artifact_store": "build/artifacts", "namespace": "api"} {
+		if err := workspaceConfig.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := workspaceConfig.WriteFile(workspace.ConfigPath()); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BUILDVAULT_OUTPUT", "json")
+
+	config, err := LoadConfig(workspace)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	expected := map[string][2]string{
+		"max_execs":      {"4", global},
+		"download_cache": {"/var/cache/buildvault", global},
+		"artifact_store": {filepath.Join(root, "build", "artifacts"), workspace.ConfigPath()},
+		"namespace":      {"api", workspace.ConfigPath()},
+		"output":         {"json", "$BUILDVAULT_OUTPUT"},
+		"docker.host":    {"", ""},
+	}
+	for key, want := range expected {
+		if value, _ := config.Get(key); value != want[0] || config.Origin(key) != want[1] {
+			t.Errorf("Expected %s to be '%s' from '%s', got '%s' from '%s'", key, want[0], want[1], value, config.Origin(key))
+		}
+	}
+
+	t.Setenv("BUILDVAULT_MAX_EXECS", "many")
+	var configErr *ConfigError
+	if _, err := LoadConfig(workspace); !errors.As(err, &configErr) {
+		t.Errorf("Expected an invalid environment variable to be rejected, got %v", err)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	config, err := ReadConfigFile(path)
+	if err != nil || config.MaxExecs != 0 || config.Output != "" {
+		t.Fatalf("Expected an empty config without a file, got %+v %v", config, err)
+	}
+
+	var configErr *ConfigError
+	for key, value := range map[string]string{"max_execs": "0", "output": "fancy", "namespace": "CI", "parallelism": "4"} {
+		if err := config.Set(key, value); !errors.As(err, &configErr) {
+			t.Errorf("Expected %s '%s' to be rejected, got %v", key, value, err)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("max_execs: 4\nmax_exec: 8\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadConfigFile(path); !errors.As(err, &configErr) {
+		t.Errorf("Expected an unknown key in the file to be rejected, got %v", err)
+	}
+	if err := os.WriteFile(path, []byte("output: fancy\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadConfigFile(path); !errors.As(err, &configErr) {
+		t.Errorf("Expected an invalid value in the file to be rejected, got %v", err)
+	}
+}
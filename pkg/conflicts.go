@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ConflictPolicy decides what happens when artifacts of different dependencies are copied to
+// overlapping destination paths. "First" and "last" refer to the copy order of artifactCopies.
+type ConflictPolicy string
+
+const (
+	ConflictMergeDirs ConflictPolicy = "merge-dirs" // Copy all artifacts: directories are merged, later files overwrite earlier ones (default)
+	ConflictError     ConflictPolicy = "error"      // Refuse to run the task
+	ConflictFirstWins ConflictPolicy = "first-wins" // Skip artifacts overlapping one copied before
+	ConflictLastWins  ConflictPolicy = "last-wins"  // Skip artifacts overlapping one copied after
+)
+
+// ParseConflictPolicy parses the name of a conflict policy, empty meaning merge-dirs.
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch policy := ConflictPolicy(s); policy {
+	case "", ConflictMergeDirs:
+		return ConflictMergeDirs, nil
+	case ConflictError, ConflictFirstWins, ConflictLastWins:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown artifact conflict policy '%s', expected error, first-wins, last-wins or merge-dirs", s)
+	}
+}
+
+// ArtifactConflict is a pair of artifacts of different dependencies with overlapping destinations.
+type ArtifactConflict struct {
+	First PlannedCopy // Copied first
+	Last  PlannedCopy // Copied last, overwriting files of First under merge-dirs
+}
+
+func (c ArtifactConflict) String() string {
+	return fmt.Sprintf("%s from '%s' at %s overlaps %s from '%s' at %s",
+		c.Last.From, c.Last.Task, c.Last.To, c.First.From, c.First.Task, c.First.To)
+}
+
+// copiesConflict reports whether two copies of different dependencies write to the same files. Overlaps
+// between artifacts of one dependency are deliberate layering and no conflict.
+func copiesConflict(a, b artifactCopy) bool {
+	return a.dependency != b.dependency && destinationsOverlap(a.artifact.To, b.artifact.To)
+}
+
+func plannedCopy(c artifactCopy) PlannedCopy {
+	return PlannedCopy{Task: c.dependency.Name, From: c.artifact.From, To: c.artifact.To}
+}
+
+// artifactConflicts returns all conflicting pairs of dependency artifacts of t in copy order
+func (t *Task) artifactConflicts() []ArtifactConflict {
+	copies := t.artifactCopies()
+	var conflicts []ArtifactConflict
+	for i := range copies {
+		for j := i + 1; j < len(copies); j++ {
+			if copiesConflict(copies[i], copies[j]) {
+				conflicts = append(conflicts, ArtifactConflict{First: plannedCopy(copies[i]), Last: plannedCopy(copies[j])})
+			}
+		}
+	}
+	return conflicts
+}
+
+// validateArtifactConflicts checks the conflict policy of t and fails for conflicts under the error policy
+func (t *Task) validateArtifactConflicts() error {
+	policy, err := ParseConflictPolicy(string(t.ArtifactConflicts))
+	if err != nil {
+		return fmt.Errorf("task '%s': %w", t.Name, err)
+	}
+	if policy != ConflictError {
+		return nil
+	}
+
+	conflicts := t.artifactConflicts()
+	if len(conflicts) == 0 {
+		return nil
+	}
+	var descriptions []string
+	for _, conflict := range conflicts {
+		descriptions = append(descriptions, conflict.String())
+	}
+	return fmt.Errorf("conflicting artifacts in task '%s': %s", t.Name, strings.Join(descriptions, "; "))
+}
+
+// resolvedCopies returns the artifact copies of t in copy order after applying its conflict policy
+func (t *Task) resolvedCopies() []artifactCopy {
+	copies := t.artifactCopies()
+	policy, _ := ParseConflictPolicy(string(t.ArtifactConflicts))
+	if policy != ConflictFirstWins && policy != ConflictLastWins {
+		return copies
+	}
+
+	// Walk towards the winning end, keeping copies that do not conflict with the ones kept so far
+	skipped := make([]bool, len(copies))
+	var kept []artifactCopy
+	for n := range copies {
+		i := n
+		if policy == ConflictLastWins {
+			i = len(copies) - 1 - n
+		}
+		for _, k := range kept {
+			if copiesConflict(copies[i], k) {
+				skipped[i] = true
+				break
+			}
+		}
+		if !skipped[i] {
+			kept = append(kept, copies[i])
+		}
+	}
+
+	var resolved []artifactCopy
+	for i, c := range copies {
+		if !skipped[i] {
+			resolved = append(resolved, c)
+		}
+	}
+	return resolved
+}
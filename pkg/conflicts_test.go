@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"slices"
+	"testing"
+)
+
+// conflictingTask copies a's /out and b's /out/config.json, plus a non-conflicting layer of a
+func conflictingTask(policy ConflictPolicy) *Task {
+	a := &Task{Name: "a", BaseImage: "alpine"}
+	b := &Task{Name: "b", BaseImage: "alpine"}
+	return &Task{Name: "c", BaseImage: "alpine", ArtifactConflicts: policy, Dependencies: []Dependency{
+		{Task: a, Artifacts: []Artifact{{From: "/dist", To: "/out"}, {From: "/version", To: "/out/version"}}},
+		{Task: b, Artifacts: []Artifact{{From: "/config.json", To: "/out/config.json"}, {From: "/docs", To: "/docs"}}},
+	}}
+}
+
+func copyNames(copies []artifactCopy) []string {
+	var names []string
+	for _, c := range copies {
+		names = append(names, c.dependency.Name+":"+c.artifact.To)
+	}
+	return names
+}
+
+func TestArtifactConflicts(t *testing.T) {
+	conflicts := conflictingTask("").artifactConflicts()
+	if len(conflicts) != 1 {
+		t.Fatalf("Expected one conflict, got %v", conflicts)
+	}
+	if conflicts[0].First.Task != "a" || conflicts[0].Last.Task != "b" || conflicts[0].Last.To != "/out/config.json" {
+		t.Errorf("Unexpected conflict %+v", conflicts[0])
+	}
+
+	if err := conflictingTask("").validateArtifactConflicts(); err != nil {
+		t.Errorf("merge-dirs should allow conflicts: %v", err)
+	}
+	if err := conflictingTask(ConflictError).validateArtifactConflicts(); err == nil {
+		t.Errorf("Expected an error for conflicting artifacts")
+	}
+	if err := conflictingTask("newest-wins").validateArtifactConflicts(); err == nil {
+		t.Errorf("Expected an error for an unknown policy")
+	}
+}
+
+func TestResolvedCopies(t *testing.T) {
+	for policy, want := range map[ConflictPolicy][]string{
+		ConflictMergeDirs: {"b:/docs", "a:/out", "b:/out/config.json", "a:/out/version"},
+		ConflictFirstWins: {"b:/docs", "a:/out", "a:/out/version"},
+		ConflictLastWins:  {"b:/docs", "b:/out/config.json", "a:/out/version"},
+	} {
+		if got := copyNames(conflictingTask(policy).resolvedCopies()); !slices.Equal(got, want) {
+			t.Errorf("Expected copies %v for %s, got %v", want, policy, got)
+		}
+	}
+}
+
+func TestConflictPolicyChangesTaskHash(t *testing.T) {
+	if conflictingTask("").generateHash() != conflictingTask(ConflictMergeDirs).generateHash() {
+		t.Errorf("The default policy should not change the task hash")
+	}
+	if conflictingTask(ConflictFirstWins).generateHash() == conflictingTask(ConflictLastWins).generateHash() {
+		t.Errorf("Policies copying different artifacts should change the task hash")
+	}
+}
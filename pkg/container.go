@@ -1,15 +1,25 @@
 package pkg
 
 import (
+	"archive/tar"
+	"bytes"
 	"context"
 	"fmt"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"io"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func listContainersByName(ctx context.Context, containerName string, cli *client.Client) ([]container.Summary, error) {
+func listContainersByName(ctx context.Context, containerName string, cli ContainerAPI) ([]container.Summary, error) {
 	listFilters := filters.NewArgs()
 	listFilters.Add("name", containerName)
 
@@ -24,7 +34,7 @@ func listContainersByName(ctx context.Context, containerName string, cli *client
 	return containers, nil
 }
 
-func containerExists(ctx context.Context, containerName string, cli *client.Client) (bool, error) {
+func containerExists(ctx context.Context, containerName string, cli ContainerAPI) (bool, error) {
 	containers, err := listContainersByName(ctx, containerName, cli)
 	if err != nil {
 		return false, err
@@ -33,7 +43,7 @@ func containerExists(ctx context.Context, containerName string, cli *client.Clie
 	return len(containers) > 0, nil
 }
 
-func cleanUpRunningContainer(ctx context.Context, containerName string, cli *client.Client) error {
+func cleanUpRunningContainer(ctx context.Context, containerName string, cli ContainerAPI) error {
 	containers, err := listContainersByName(ctx, containerName, cli)
 	if err != nil {
 		return err
@@ -51,22 +61,122 @@ func cleanUpRunningContainer(ctx context.Context, containerName string, cli *cli
 		}
 
 		// Remove it to start fresh
-		if err := cli.ContainerRemove(ctx, containerID, container.RemoveOptions{}); err != nil {
-			fmt.Errorf("error removing existing container: %w", err)
+		err := retryRemoval(ctx, func(force bool) error {
+			return cli.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: force, RemoveVolumes: true})
+		})
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func createLongLivedContainer(ctx context.Context, containerName string, baseImage string, cli *client.Client) (container.CreateResponse, error) {
+// removalRetries is how often removing a container is tried while docker is still removing it
+const removalRetries = 5
+
+// removalBackoff is the wait before the first retry, doubled after every further one
+var removalBackoff = 200 * time.Millisecond
+
+// retryRemoval removes a container with remove, waiting for a removal already in progress, like one of
+// a concurrent run or of docker run --rm, and forcing the removal if a plain one fails for another reason.
+// A container which is gone in the meantime counts as removed.
+func retryRemoval(ctx context.Context, remove func(force bool) error) error {
+	backoff := removalBackoff
+	var err error
+	for range removalRetries {
+		err = remove(false)
+		if err != nil && !errdefs.IsNotFound(err) && !removalInProgress(err) {
+			err = remove(true)
+		}
+		switch {
+		case err == nil, errdefs.IsNotFound(err):
+			return nil
+		case !removalInProgress(err):
+			return fmt.Errorf("error removing existing container: %w", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return fmt.Errorf("error removing existing container, it is still being removed: %w", err)
+}
+
+// removalInProgress reports whether docker refused to remove a container because it already removes it
+func removalInProgress(err error) bool {
+	return errdefs.IsConflict(err) && strings.Contains(err.Error(), "already in progress")
+}
+
+// Labels of task containers, besides labelManaged, labelTask, labelHash and labelHashVersion
+const (
+	labelPipeline = "buildvault.pipeline" // ID of the pipeline file the task was loaded from
+	labelRunID    = "buildvault.run"      // ID of the run that created the container, see RunID
+	labelVersion  = "buildvault.version"  // Version of buildvault that created the container
+	labelSource   = "buildvault.source"   // What created the container, sourceTask for task containers
+)
+
+// sourceTask is the labelSource of the long-lived containers tasks execute in
+const sourceTask = "task"
+
+// containerLabels identify the container of t without parsing its name
+func (t *Task) containerLabels() map[string]string {
+	return withNamespace(map[string]string{
+		labelManaged:     "true",
+		labelSource:      sourceTask,
+		labelTask:        t.Name,
+		labelHash:        t.generateHash(),
+		labelHashVersion: strconv.Itoa(HashVersion),
+		labelPipeline:    t.pipelineID,
+		labelRunID:       RunID(),
+		labelVersion:     Version,
+	})
+}
+
+// listTaskContainers returns the containers of the current namespace that task taskName executed in,
+// only those of the given hash unless it is empty, found by their labels
+func listTaskContainers(ctx context.Context, cli ContainerAPI, taskName, hash string) ([]container.Summary, error) {
+	listFilters := filters.NewArgs()
+	listFilters.Add("label", labelSource+"="+sourceTask)
+	listFilters.Add("label", labelTask+"="+taskName)
+	if hash != "" {
+		listFilters.Add("label", labelHash+"="+hash)
+	}
+	if namespace != "" {
+		listFilters.Add("label", labelNamespace+"="+namespace)
+	}
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true, Filters: listFilters})
+	if err != nil {
+		return nil, fmt.Errorf("error searching for containers of task '%s': %w", taskName, err)
+	}
+	// The default namespace has no label to filter by
+	return slices.DeleteFunc(containers, func(summary container.Summary) bool { return !inNamespace(summary.Labels) }), nil
+}
+
+// taskContainer returns the task name and hash of a buildvault task container of the current namespace
+// from its labels, or from its name if it was created before task containers were labelled
+func taskContainer(summary container.Summary) (taskName string, hash string, ok bool) {
+	if !inNamespace(summary.Labels) {
+		return "", "", false
+	}
+	if summary.Labels[labelSource] == sourceTask {
+		return summary.Labels[labelTask], summary.Labels[labelHash], true
+	}
+	for _, name := range summary.Names {
+		if taskName, hash, ok := parseContainerName(name); ok {
+			return taskName, hash, true
+		}
+	}
+	return "", "", false
+}
+
+func createLongLivedContainer(ctx context.Context, containerName string, config *container.Config, hostConfig *container.HostConfig, platform *ocispec.Platform, labels map[string]string, cli ContainerAPI) (container.CreateResponse, error) {
 	init := true
-	response, err := cli.ContainerCreate(ctx, &container.Config{
-		Image: baseImage,
-		Cmd:   []string{"tail", "-f", "/dev/null"}, // Keep container alive
-		Tty:   true,
-	}, &container.HostConfig{
-		Init: &init, // This is equivalent to --init flag to indicate that an init process should be used as the PID 1 in the container. Specifying an init process ensures the usual responsibilities of an init system, such as reaping zombie processes, are performed inside the created container. This effectively allows SIGTERMS to stop the container
-	}, nil, nil, containerName)
+	hostConfig.Init = &init // This is equivalent to --init flag to indicate that an init process should be used as the PID 1 in the container. Specifying an init process ensures the usual responsibilities of an init system, such as reaping zombie processes, are performed inside the created container. This effectively allows SIGTERMS to stop the container
+	config.Tty = true
+	config.Labels = labels
+	response, err := cli.ContainerCreate(ctx, config, hostConfig, nil, platform, containerName)
 	if err != nil {
 		return response, fmt.Errorf("error creating container: %w", err)
 	}
@@ -74,7 +184,7 @@ func createLongLivedContainer(ctx context.Context, containerName string, baseIma
 	return response, nil
 }
 
-func startContainer(ctx context.Context, containerID string, cli *client.Client) error {
+func startContainer(ctx context.Context, containerID string, cli ContainerAPI) error {
 	// Start the container
 	if err := cli.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
 		return fmt.Errorf("error starting container: %w", err)
@@ -83,7 +193,7 @@ func startContainer(ctx context.Context, containerID string, cli *client.Client)
 	return nil
 }
 
-func stopContainer(ctx context.Context, containerID string, cli *client.Client) error {
+func stopContainer(ctx context.Context, containerID string, cli ContainerAPI) error {
 	// Stop the container but don't remove it - it will be available for future tasks
 	fmt.Printf("Sending SIGTERM to conatiner: %s\n", containerID)
 	if err := cli.ContainerStop(ctx, containerID, container.StopOptions{
@@ -94,34 +204,115 @@ func stopContainer(ctx context.Context, containerID string, cli *client.Client)
 	return nil
 }
 
-// copyBetweenContainers copies files from one container to another
-func copyBetweenContainers(ctx context.Context, cli *client.Client, sourceContainerID, targetContainerID, sourcePath, targetPath string) error {
-	// Get file content from source container
-	reader, _, err := cli.CopyFromContainer(ctx, sourceContainerID, sourcePath)
+// runInContainer executes cmd in a running container, waits for it to exit successfully and returns its stdout
+func runInContainer(ctx context.Context, cli ExecAPI, containerID, workDir string, cmd []string) (string, error) {
+	return execCapture(ctx, cli, containerID, container.ExecOptions{
+		Cmd:        cmd,
+		WorkingDir: workDir,
+		User:       rootUser, // The container may run as another user, which cannot create directories everywhere
+	})
+}
+
+// execCapture executes an exec with options in a running container, waits for it to exit successfully
+// and returns its stdout. Stderr is part of the error if it fails.
+func execCapture(ctx context.Context, cli ExecAPI, containerID string, options container.ExecOptions) (string, error) {
+	release, err := acquireExec(ctx, cli)
 	if err != nil {
-		return fmt.Errorf("error copying from source container: %w", err)
+		return "", err
+	}
+	defer release()
+
+	cmd := options.Cmd
+	options.AttachStdout, options.AttachStderr = true, true
+	execResp, err := cli.ContainerExecCreate(ctx, containerID, options)
+	if err != nil {
+		return "", fmt.Errorf("error creating exec for %v: %w", cmd, err)
+	}
+
+	attachResp, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return "", fmt.Errorf("error attaching to exec for %v: %w", cmd, err)
+	}
+	defer attachResp.Close()
+
+	var stdout, stderr bytes.Buffer
+	if _, err := stdcopy.StdCopy(&stdout, &stderr, attachResp.Reader); err != nil {
+		return "", fmt.Errorf("error reading output of %v: %w", cmd, err)
 	}
-	defer reader.Close()
 
+	inspectResp, err := cli.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return "", fmt.Errorf("error inspecting exec for %v: %w", cmd, err)
+	}
+	if inspectResp.ExitCode != 0 {
+		return "", fmt.Errorf("%v failed with exit code %d: %s", cmd, inspectResp.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// copyTarToContainer extracts a tar stream as produced by CopyFromContainer into the directory of targetPath.
+// The directory is created with the mkdir command, which differs for images that rely on the helper binary,
+// or copied into containers without tools if mkdir is nil.
+// With copyUIDGID the copied files belong to the user of the target container instead of their original owner.
+func copyTarToContainer(ctx context.Context, cli DockerAPI, targetContainerID, targetPath string, mkdir []string, copyUIDGID bool, reader io.Reader) error {
 	// Create target directory if needed
 	targetDir := filepath.Dir(targetPath)
 	if targetDir != "." {
-		execResp, err := cli.ContainerExecCreate(ctx, targetContainerID, container.ExecOptions{
-			Cmd: []string{"mkdir", "-p", targetDir},
-		})
-		if err != nil {
-			return fmt.Errorf("error creating directory in target container: %w", err)
-		}
-		if err := cli.ContainerExecStart(ctx, execResp.ID, container.ExecStartOptions{}); err != nil {
-			return fmt.Errorf("error creating directory in target container: %w", err)
+		if err := createContainerDir(ctx, cli, targetContainerID, targetDir, mkdir); err != nil {
+			return err
 		}
 	}
 
 	// Copy to target container
-	err = cli.CopyToContainer(ctx, targetContainerID, targetDir, reader, container.CopyToContainerOptions{})
+	err := cli.CopyToContainer(ctx, targetContainerID, targetDir, reader, container.CopyToContainerOptions{CopyUIDGID: copyUIDGID})
 	if err != nil {
 		return fmt.Errorf("error copying to target container: %w", err)
 	}
 
 	return nil
 }
+
+// createContainerDir creates dir and its missing parents in a container with the mkdir command, or by
+// copying them into containers without tools if mkdir is nil
+func createContainerDir(ctx context.Context, cli DockerAPI, containerID, dir string, mkdir []string) error {
+	var err error
+	if mkdir == nil {
+		err = copyDirectories(ctx, cli, containerID, dir)
+	} else {
+		_, err = runInContainer(ctx, cli, containerID, "", append(slices.Clone(mkdir), dir))
+	}
+	if err != nil {
+		return fmt.Errorf("error creating directory in target container: %w", err)
+	}
+	return nil
+}
+
+// copyDirectories creates dir and its missing parents like mkdir -p, by copying an archive of only the
+// missing directories into the container. Existing directories are left out, extracting them would reset
+// their mode and owner.
+func copyDirectories(ctx context.Context, cli ContainerFileAPI, containerID, dir string) error {
+	var missing []string
+	for dir = path.Clean(dir); dir != "/"; dir = path.Dir(dir) {
+		if _, err := cli.ContainerStatPath(ctx, containerID, dir); err == nil {
+			break
+		} else if !errdefs.IsNotFound(err) {
+			return err
+		}
+		missing = append(missing, dir)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: missing[i][1:] + "/", Mode: 0o755}); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return cli.CopyToContainer(ctx, containerID, "/", &buf, container.CopyToContainerOptions{})
+}
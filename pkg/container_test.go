@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+)
+
+func TestRetryRemoval(t *testing.T) {
+	removalBackoff = time.Millisecond
+	ctx := context.Background()
+	inProgress := errdefs.Conflict(errors.New("removal of container abc is already in progress"))
+
+	var calls []bool
+	err := retryRemoval(ctx, func(force bool) error {
+		calls = append(calls, force)
+		if len(calls) < 3 {
+			return inProgress
+		}
+		return errdefs.NotFound(errors.New("no such container: abc"))
+	})
+	if err != nil || len(calls) != 3 {
+		t.Errorf("Expected the removal to be retried until the container is gone, got %v after %d calls", err, len(calls))
+	}
+
+	calls = nil
+	err = retryRemoval(ctx, func(force bool) error {
+		calls = append(calls, force)
+		if !force {
+			return errors.New("container abc is restarting")
+		}
+		return nil
+	})
+	if err != nil || len(calls) != 2 || !calls[1] {
+		t.Errorf("Expected a forced removal after a failing one, got %v with calls %v", err, calls)
+	}
+
+	err = retryRemoval(ctx, func(bool) error { return inProgress })
+	if !errors.Is(err, inProgress) {
+		t.Errorf("Expected the error of the removal once the retries are used up, got %v", err)
+	}
+
+	failure := errors.New("permission denied")
+	if err := retryRemoval(ctx, func(bool) error { return failure }); !errors.Is(err, failure) {
+		t.Errorf("Expected a failing forced removal to be returned, got %v", err)
+	}
+}
+
+func TestTaskContainer(t *testing.T) {
+	task := &Task{Name: "build", BaseImage: "alpine", pipelineID: "0123456789ab"}
+	labels := task.containerLabels()
+	if labels[labelPipeline] != "0123456789ab" || labels[labelRunID] != RunID() || labels[labelVersion] != Version || labels[labelHashVersion] != strconv.Itoa(HashVersion) {
+		t.Errorf("Expected the pipeline, run and version in the labels, got %v", labels)
+	}
+
+	// Labels identify a container even if its name was changed
+	labelled := container.Summary{Names: []string{"/renamed"}, Labels: labels}
+	if name, hash, ok := taskContainer(labelled); !ok || name != "build" || hash != task.generateHash() {
+		t.Errorf("Expected the task and hash of the labels, got %q, %q, %v", name, hash, ok)
+	}
+
+	legacy := container.Summary{Names: []string{"/buildvault_test_abc"}}
+	if name, hash, ok := taskContainer(legacy); !ok || name != "test" || hash != "abc" {
+		t.Errorf("Expected the task and hash of the name of an unlabelled container, got %q, %q, %v", name, hash, ok)
+	}
+
+	if _, _, ok := taskContainer(container.Summary{Names: []string{"/postgres"}}); ok {
+		t.Error("Expected a container not created by buildvault to be ignored")
+	}
+}
+
+func TestListTaskContainers(t *testing.T) {
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine"}})
+	build := &Task{Name: "build", BaseImage: "alpine"}
+	create := func(name string, labels map[string]string) string {
+		resp, err := cli.ContainerCreate(context.Background(), &container.Config{Image: "alpine", Labels: labels}, nil, nil, nil, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.ID
+	}
+	want := create("renamed", build.containerLabels())
+	create(build.generateContainerName()+"_copy", nil)
+	create("other", (&Task{Name: "build-arm", BaseImage: "alpine"}).containerLabels())
+	setNamespace(t, "ci")
+	create("namespaced", build.containerLabels())
+	setNamespace(t, "")
+
+	containers, err := listTaskContainers(context.Background(), cli, "build", build.generateHash())
+	if err != nil {
+		t.Fatalf("Failed to list containers: %v", err)
+	}
+	if len(containers) != 1 || containers[0].ID != want {
+		t.Errorf("Expected only the labelled container of the task in the namespace, got %+v", containers)
+	}
+	if id, ok, err := findTaskContainer(context.Background(), cli, "build"); err != nil || !ok || id != want {
+		t.Errorf("Expected to find the labelled container of the task, got %q, %v, %v", id, ok, err)
+	}
+}
@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/moby/term"
+)
+
+// Debug opens an interactive shell in the most recent container of t, usually one left running by a
+// failed command, to inspect its files and reproduce the failure. A stopped container is started again.
+// The shell runs as the user and in the working directory of the task's commands.
+func (t *Task) Debug(ctx context.Context, cli DockerAPI) error {
+	containers, err := listBuildvaultContainers(ctx, cli, false)
+	if err != nil {
+		return err
+	}
+	summary, ok := latestTaskContainer(containers, t.Name)
+	if !ok {
+		return fmt.Errorf("task '%s' has no preserved container, run it first", t.Name)
+	}
+	name := strings.TrimPrefix(summary.Names[0], "/")
+
+	if err := ensureRunning(ctx, cli, summary); err != nil {
+		return err
+	}
+
+	shell, err := debugShell(ctx, cli, summary.ID)
+	if err != nil {
+		return fmt.Errorf("task '%s': %w", t.Name, err)
+	}
+
+	fmt.Printf("Opening a shell in container %s of task '%s'\n", name, t.Name)
+	if t.Script != "" {
+		fmt.Printf("The script of the task is at %s\n", batchScriptPath)
+	}
+	for idx, cmd := range t.commandLines() {
+		fmt.Printf("  Command %d: %s\n", idx+1, cmd)
+	}
+	if err := runShell(ctx, cli, summary.ID, shell, t.containerUser(), t.workDir()); err != nil {
+		return err
+	}
+	fmt.Printf("Container %s keeps running until the task runs again\n", name)
+	return nil
+}
+
+// runShell runs the interactive shell in the running container id attached to the terminal
+func runShell(ctx context.Context, cli ExecAPI, id string, shell []string, user, workDir string) error {
+	fd, tty := term.GetFdInfo(os.Stdin)
+	execResp, err := cli.ContainerExecCreate(ctx, id, container.ExecOptions{
+		Cmd:          shell,
+		User:         user,
+		WorkingDir:   workDir,
+		Tty:          tty,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating debug shell: %w", err)
+	}
+
+	attachResp, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{Tty: tty})
+	if err != nil {
+		return fmt.Errorf("error attaching to debug shell: %w", err)
+	}
+	defer attachResp.Close()
+
+	if tty {
+		state, err := term.SetRawTerminal(fd)
+		if err != nil {
+			return fmt.Errorf("error setting up the terminal: %w", err)
+		}
+		defer term.RestoreTerminal(fd, state)
+		if size, err := term.GetWinsize(fd); err == nil {
+			cli.ContainerExecResize(ctx, execResp.ID, container.ResizeOptions{Height: uint(size.Height), Width: uint(size.Width)})
+		}
+	}
+
+	go func() {
+		io.Copy(attachResp.Conn, os.Stdin)
+		attachResp.CloseWrite()
+	}()
+	if tty {
+		_, err = io.Copy(os.Stdout, attachResp.Reader)
+	} else {
+		_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, attachResp.Reader)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading debug shell output: %w", err)
+	}
+
+	inspectResp, err := cli.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return fmt.Errorf("error inspecting debug shell: %w", err)
+	}
+	fmt.Printf("\r\nShell exited with code %d\n", inspectResp.ExitCode)
+	return nil
+}
+
+// ensureRunning starts the container of summary again unless it is running
+func ensureRunning(ctx context.Context, cli ContainerAPI, summary container.Summary) error {
+	if summary.State == "running" {
+		return nil
+	}
+	name := strings.TrimPrefix(summary.Names[0], "/")
+	if err := cli.ContainerStart(ctx, summary.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("error starting container %s: %w", name, err)
+	}
+	fmt.Printf("Started container %s (%s before)\n", name, summary.State)
+	return nil
+}
+
+// latestTaskContainer returns the most recently created of the given buildvault containers belonging to
+// the task with the given name
+func latestTaskContainer(containers []container.Summary, taskName string) (container.Summary, bool) {
+	var latest container.Summary
+	found := false
+	for _, summary := range containers {
+		task, _, ok := taskContainer(summary)
+		if ok && task == taskName && (!found || summary.Created > latest.Created) {
+			latest = summary
+			found = true
+		}
+	}
+	return latest, found
+}
+
+// debugShell returns the interactive shell of a task container: /bin/sh of the image, or the shell of
+// the helper injected into containers of images without one
+func debugShell(ctx context.Context, cli ContainerFileAPI, containerID string) ([]string, error) {
+	if _, err := cli.ContainerStatPath(ctx, containerID, shellPath); err == nil {
+		return []string{shellPath}, nil
+	}
+	if _, err := cli.ContainerStatPath(ctx, containerID, helperPath); err == nil {
+		return []string{helperPath, "sh"}, nil
+	}
+	return nil, fmt.Errorf("%w: the container has neither %s nor a helper binary", ErrNoShell, shellPath)
+}
@@ -0,0 +1,23 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestLatestTaskContainer(t *testing.T) {
+	containers := []container.Summary{
+		{ID: "old", Names: []string{"/buildvault_build_000000000001"}, Created: 100},
+		{ID: "new", Names: []string{"/buildvault_build_000000000002"}, Created: 200},
+		{ID: "other", Names: []string{"/buildvault_build_docs_000000000003"}, Created: 300},
+	}
+
+	latest, ok := latestTaskContainer(containers, "build")
+	if !ok || latest.ID != "new" {
+		t.Errorf("Expected the newest container of the task, got %+v", latest)
+	}
+	if _, ok := latestTaskContainer(containers, "test"); ok {
+		t.Errorf("Expected no container for a task that never ran")
+	}
+}
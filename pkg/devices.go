@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/docker/cli/opts"
+	"github.com/docker/docker/api/types/container"
+)
+
+// Devices and GPUs are not part of the task hash, like the network: they decide what hardware a task
+// runs on, not what it computes. Tasks whose outputs depend on the hardware can name it in a hash input.
+
+// parseDevice parses a device like docker run --device does: host path, optionally followed by the
+// path in the container and the cgroup permissions, e.g. /dev/sdb:/dev/xvdc:r
+func parseDevice(device string) (container.DeviceMapping, error) {
+	parts := strings.Split(device, ":")
+	mapping := container.DeviceMapping{PathOnHost: parts[0], PathInContainer: parts[0], CgroupPermissions: "rwm"}
+	switch {
+	case len(parts) == 2 && validDevicePermissions(parts[1]):
+		mapping.CgroupPermissions = parts[1]
+	case len(parts) == 2:
+		mapping.PathInContainer = parts[1]
+	case len(parts) == 3 && validDevicePermissions(parts[2]):
+		mapping.PathInContainer = parts[1]
+		mapping.CgroupPermissions = parts[2]
+	case len(parts) != 1:
+		return container.DeviceMapping{}, fmt.Errorf("invalid device '%s', expected host-path[:container-path][:permissions]", device)
+	}
+	if !path.IsAbs(mapping.PathOnHost) || !path.IsAbs(mapping.PathInContainer) {
+		return container.DeviceMapping{}, fmt.Errorf("invalid device '%s', its paths must be absolute", device)
+	}
+	return mapping, nil
+}
+
+// validDevicePermissions reports whether permissions is a combination of r(ead), w(rite) and m(knod)
+func validDevicePermissions(permissions string) bool {
+	if permissions == "" || len(permissions) > 3 {
+		return false
+	}
+	for _, c := range permissions {
+		if !strings.ContainsRune("rwm", c) || strings.Count(permissions, string(c)) > 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// parseGPUs parses a GPU request like docker run --gpus does: all, a count, or comma-separated options
+// such as device=0 or count=2, with lists quoted like "device=0,1"
+func parseGPUs(gpus string) ([]container.DeviceRequest, error) {
+	var request opts.GpuOpts
+	if err := request.Set(gpus); err != nil {
+		return nil, fmt.Errorf("invalid GPU request '%s': %w", gpus, err)
+	}
+	return request.Value(), nil
+}
+
+// validateDevices checks the devices and GPU request of t
+func (t *Task) validateDevices() error {
+	for _, device := range t.Devices {
+		if _, err := parseDevice(device); err != nil {
+			return fmt.Errorf("task '%s': %w", t.Name, err)
+		}
+	}
+	if t.GPUs != "" {
+		if _, err := parseGPUs(t.GPUs); err != nil {
+			return fmt.Errorf("task '%s': %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// deviceResources returns the devices and GPU requests of the container of t, validated beforehand
+func (t *Task) deviceResources() container.Resources {
+	var resources container.Resources
+	for _, device := range t.Devices {
+		mapping, _ := parseDevice(device)
+		resources.Devices = append(resources.Devices, mapping)
+	}
+	if t.GPUs != "" {
+		resources.DeviceRequests, _ = parseGPUs(t.GPUs)
+	}
+	return resources
+}
@@ -0,0 +1,44 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestParseDevice(t *testing.T) {
+	tests := map[string]container.DeviceMapping{
+		"/dev/fuse":             {PathOnHost: "/dev/fuse", PathInContainer: "/dev/fuse", CgroupPermissions: "rwm"},
+		"/dev/sdb:r":            {PathOnHost: "/dev/sdb", PathInContainer: "/dev/sdb", CgroupPermissions: "r"},
+		"/dev/sdb:/dev/xvdc":    {PathOnHost: "/dev/sdb", PathInContainer: "/dev/xvdc", CgroupPermissions: "rwm"},
+		"/dev/sdb:/dev/xvdc:rw": {PathOnHost: "/dev/sdb", PathInContainer: "/dev/xvdc", CgroupPermissions: "rw"},
+	}
+	for device, want := range tests {
+		if got, err := parseDevice(device); err != nil || got != want {
+			t.Errorf("%s: expected %+v, got %+v (%v)", device, want, got, err)
+		}
+	}
+	for _, device := range []string{"fuse", "/dev/sdb:/dev/xvdc:rx", "/dev/a:/dev/b:r:w"} {
+		if _, err := parseDevice(device); err == nil {
+			t.Errorf("%s: expected an error", device)
+		}
+	}
+}
+
+func TestDeviceResources(t *testing.T) {
+	task := &Task{Name: "train", Devices: []string{"/dev/fuse"}, GPUs: `"device=0,1"`}
+	if err := task.validateDevices(); err != nil {
+		t.Fatalf("Expected the devices to be valid, got %v", err)
+	}
+	hostConfig := task.hostConfig()
+	if len(hostConfig.Devices) != 1 || len(hostConfig.DeviceRequests) != 1 {
+		t.Fatalf("Expected a device and a GPU request, got %+v", hostConfig.Resources)
+	}
+	if ids := hostConfig.DeviceRequests[0].DeviceIDs; len(ids) != 2 || ids[1] != "1" {
+		t.Errorf("Expected GPUs 0 and 1, got %v", ids)
+	}
+
+	if err := (&Task{Name: "train", GPUs: "count=many"}).validateDevices(); err == nil {
+		t.Error("Expected an invalid GPU request to be rejected")
+	}
+}
@@ -0,0 +1,8 @@
+// Package pkg is the Go API of buildvault: tasks, pipelines and the Docker execution of task graphs.
+//
+// The exported API follows semantic versioning from v1.0.0 on. Within a major version, exported
+// identifiers are neither removed nor changed incompatibly; new settings of calls like Task.Execute are
+// added as functional options (ExecuteOption) and new fields of structs like Task default to the
+// previous behavior when unset. Unexported identifiers and the output printed during runs are not part
+// of the API.
+package pkg
@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/cli/cli/connhelper"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// DockerAPI is the part of the Docker client buildvault uses. Tasks and pipelines take it instead of a
+// *client.Client, so they can run against an in-memory fake like the one of package dockertest. Helpers
+// that only need one area of the API take one of the interfaces it is made of instead.
+type DockerAPI interface {
+	DaemonAPI
+	ContainerAPI
+	ContainerFileAPI
+	ExecAPI
+	ImageAPI
+	NetworkAPI
+	VolumeAPI
+}
+
+// DaemonAPI is the connection to the daemon.
+type DaemonAPI interface {
+	ClientVersion() string
+	DaemonHost() string
+	Close() error
+	Ping(ctx context.Context) (types.Ping, error)
+	ServerVersion(ctx context.Context) (types.Version, error)
+}
+
+// ContainerAPI manages the lifecycle of containers.
+type ContainerAPI interface {
+	ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error)
+	ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error
+	ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error
+	ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error
+	ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error)
+	ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error)
+	ContainerCommit(ctx context.Context, containerID string, options container.CommitOptions) (container.CommitResponse, error)
+	ContainerDiff(ctx context.Context, containerID string) ([]container.FilesystemChange, error)
+}
+
+// ContainerFileAPI reads and writes files of containers as tar archives. Transfers are limited per
+// daemon, which DaemonHost identifies.
+type ContainerFileAPI interface {
+	DaemonHost() string
+	ContainerStatPath(ctx context.Context, containerID, path string) (container.PathStat, error)
+	CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options container.CopyToContainerOptions) error
+	CopyFromContainer(ctx context.Context, containerID, srcPath string) (io.ReadCloser, container.PathStat, error)
+}
+
+// ExecAPI runs processes in running containers. Execs are limited per daemon, which DaemonHost
+// identifies.
+type ExecAPI interface {
+	DaemonHost() string
+	ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (container.ExecCreateResponse, error)
+	ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error)
+	ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error)
+	ContainerExecResize(ctx context.Context, execID string, options container.ResizeOptions) error
+}
+
+// ImageAPI pulls, builds, pushes and removes images.
+type ImageAPI interface {
+	ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error)
+	ImageList(ctx context.Context, options image.ListOptions) ([]image.Summary, error)
+	ImagePull(ctx context.Context, refStr string, options image.PullOptions) (io.ReadCloser, error)
+	ImagePush(ctx context.Context, image string, options image.PushOptions) (io.ReadCloser, error)
+	ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error)
+	ImageTag(ctx context.Context, source, target string) error
+	ImageSave(ctx context.Context, imageIDs []string, saveOpts ...client.ImageSaveOption) (io.ReadCloser, error)
+	ImageRemove(ctx context.Context, imageID string, options image.RemoveOptions) ([]image.DeleteResponse, error)
+}
+
+// NetworkAPI manages the networks of services.
+type NetworkAPI interface {
+	NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error)
+	NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error)
+	NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error)
+	NetworkDisconnect(ctx context.Context, networkID, containerID string, force bool) error
+	NetworkRemove(ctx context.Context, networkID string) error
+}
+
+// VolumeAPI manages cache volumes.
+type VolumeAPI interface {
+	VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error)
+	VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error)
+	VolumeRemove(ctx context.Context, volumeID string, force bool) error
+}
+
+var _ DockerAPI = (*client.Client)(nil)
+
+// DockerEndpoint selects the Docker daemon tasks run on, e.g. a bigger remote build machine.
+type DockerEndpoint struct {
+	Host      string // DOCKER_HOST-style URL: unix://, tcp://, npipe:// or ssh://user@host
+	TLSCACert string // CA certificate to verify a tcp:// daemon with
+	TLSCert   string // Client certificate for a tcp:// daemon
+	TLSKey    string // Client key for a tcp:// daemon
+}
+
+// DockerOption configures the endpoint of NewDockerClient.
+type DockerOption func(*DockerEndpoint)
+
+// WithDockerHost selects the daemon by DOCKER_HOST-style URL. ssh:// URLs run `docker system dial-stdio`
+// on the remote machine over ssh, so only ssh access and a docker CLI there are needed.
+func WithDockerHost(host string) DockerOption {
+	return func(e *DockerEndpoint) {
+		e.Host = host
+	}
+}
+
+// WithDockerTLS sets the CA certificate and client key pair used for a tcp:// daemon.
+func WithDockerTLS(caCert, cert, key string) DockerOption {
+	return func(e *DockerEndpoint) {
+		e.TLSCACert = caCert
+		e.TLSCert = cert
+		e.TLSKey = key
+	}
+}
+
+// WithDockerEndpoint applies all settings of an endpoint, e.g. the one of a pipeline file. Empty fields
+// leave earlier settings unchanged.
+func WithDockerEndpoint(endpoint DockerEndpoint) DockerOption {
+	return func(e *DockerEndpoint) {
+		if endpoint.Host != "" {
+			e.Host = endpoint.Host
+		}
+		if endpoint.TLSCACert != "" || endpoint.TLSCert != "" || endpoint.TLSKey != "" {
+			e.TLSCACert, e.TLSCert, e.TLSKey = endpoint.TLSCACert, endpoint.TLSCert, endpoint.TLSKey
+		}
+	}
+}
+
+// NewDockerClient creates a Docker client. Without options it behaves like the docker CLI and reads
+// DOCKER_HOST, DOCKER_TLS_VERIFY and DOCKER_CERT_PATH from the environment.
+func NewDockerClient(opts ...DockerOption) (*client.Client, error) {
+	var endpoint DockerEndpoint
+	for _, opt := range opts {
+		opt(&endpoint)
+	}
+
+	clientOpts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+
+	host := endpoint.Host
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host != "" {
+		helper, err := connhelper.GetConnectionHelper(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Docker host %s: %w", host, err)
+		}
+		if helper != nil {
+			// The connection is tunnelled over ssh, the URL of the HTTP client is never dialed
+			clientOpts = append(clientOpts,
+				client.WithHTTPClient(&http.Client{Transport: &http.Transport{DialContext: helper.Dialer}}),
+				client.WithHost(helper.Host),
+				client.WithDialContext(helper.Dialer),
+			)
+		} else {
+			clientOpts = append(clientOpts, client.WithHost(host))
+		}
+	}
+
+	if endpoint.TLSCACert != "" || endpoint.TLSCert != "" || endpoint.TLSKey != "" {
+		clientOpts = append(clientOpts, client.WithTLSClientConfig(endpoint.TLSCACert, endpoint.TLSCert, endpoint.TLSKey))
+	}
+
+	cli, err := client.NewClientWithOpts(clientOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Docker client: %w", err)
+	}
+	return cli, nil
+}
+
+// resolveTLSPaths makes the certificate paths of an endpoint relative to dir absolute
+func (e *DockerEndpoint) resolveTLSPaths(dir string) {
+	for _, p := range []*string{&e.TLSCACert, &e.TLSCert, &e.TLSKey} {
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+}
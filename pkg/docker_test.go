@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+)
+
+func TestNewDockerClientHosts(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "")
+
+	cli, err := NewDockerClient(WithDockerHost("tcp://build-box:2375"))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	if cli.DaemonHost() != "tcp://build-box:2375" {
+		t.Errorf("Unexpected daemon host %s", cli.DaemonHost())
+	}
+
+	// ssh connections are tunnelled, nothing is dialed until the first request
+	cli, err = NewDockerClient(WithDockerHost("ssh://builder@build-box"))
+	if err != nil {
+		t.Fatalf("Failed to create ssh client: %v", err)
+	}
+	if cli.DaemonHost() == "ssh://builder@build-box" {
+		t.Errorf("ssh hosts should go through the connection helper")
+	}
+
+	// Later options override earlier ones, empty endpoints keep them
+	var endpoint DockerEndpoint
+	for _, opt := range []DockerOption{WithDockerHost("unix:///a.sock"), WithDockerEndpoint(DockerEndpoint{}), WithDockerTLS("ca", "cert", "key")} {
+		opt(&endpoint)
+	}
+	if endpoint.Host != "unix:///a.sock" || endpoint.TLSKey != "key" {
+		t.Errorf("Unexpected endpoint %+v", endpoint)
+	}
+
+	if _, err := NewDockerClient(WithDockerHost("build-box")); err == nil {
+		t.Errorf("Expected an error for a host without a scheme")
+	}
+}
+
+func TestParsePipelineDocker(t *testing.T) {
+	data := "docker:\n  host: ssh://builder@build-box\n  tls:\n    ca: certs/ca.pem\ntasks:\n  - name: a\n    image: alpine\n"
+	pipeline, err := ParsePipeline([]byte(data))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+	if pipeline.Docker.Host != "ssh://builder@build-box" || pipeline.Docker.TLSCACert != "certs/ca.pem" {
+		t.Errorf("Unexpected Docker endpoint %+v", pipeline.Docker)
+	}
+}
+
+var _ DockerAPI = (*dockertest.Fake)(nil)
+
+func TestExecuteWithFakeDocker(t *testing.T) {
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:3.20"}, Files: map[string]string{"/bin/sh": ""}})
+
+	var commands []string
+	cli.Exec = func(e *dockertest.Exec) int {
+		if command := e.Cmd[len(e.Cmd)-1]; strings.Contains(command, "build") {
+			commands = append(commands, command)
+			if err := e.Container.WriteFile("/work/result.txt", []byte("built"), 0o644); err != nil {
+				t.Errorf("Failed to write result: %v", err)
+			}
+		}
+		return dockertest.Builtins(e)
+	}
+
+	build := &Task{Name: "build", BaseImage: "alpine:3.20", Commands: []string{"build"}}
+	consume := &Task{
+		Name:         "consume",
+		BaseImage:    "alpine:3.20",
+		Commands:     []string{"echo consuming"},
+		Dependencies: []Dependency{{Task: build, Artifacts: []Artifact{{From: "/work/result.txt", To: "/input/result.txt"}}}},
+	}
+	if err := consume.Execute(context.Background(), cli); err != nil {
+		t.Fatalf("Failed to execute tasks: %v", err)
+	}
+	if len(commands) != 1 {
+		t.Errorf("Expected the build command to run once, got %v", commands)
+	}
+
+	c, ok := cli.Container(consume.generateContainerName())
+	if !ok {
+		t.Fatalf("No container of task consume")
+	}
+	if data, err := c.ReadFile("/input/result.txt"); err != nil || string(data) != "built" {
+		t.Errorf("Unexpected artifact %q: %v", data, err)
+	}
+}
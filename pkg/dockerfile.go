@@ -0,0 +1,180 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// A Dockerfile equivalent of a task graph is meant for platforms that only accept Dockerfiles. Every
+// task becomes a stage, artifacts become COPY --from instructions and commands become RUN instructions
+// with the task's cache directories and secrets as BuildKit mounts. What a build cannot express (bind
+// mounts, services, networks, external containers) is left out and noted in a comment.
+
+// stageNameInvalid matches the characters not allowed in Dockerfile stage names
+var stageNameInvalid = regexp.MustCompile(`[^a-z0-9_.-]`)
+
+// stageName returns the Dockerfile stage of the task with the given name
+func stageName(taskName string) string {
+	return stageNameInvalid.ReplaceAllString(strings.ToLower(taskName), "-")
+}
+
+// Dockerfile returns a multi-stage Dockerfile approximating the graphs of targets, dependencies first
+// and the last target as the final stage.
+func Dockerfile(targets []*Task) (string, error) {
+	var b strings.Builder
+	b.WriteString("# syntax=docker/dockerfile:1\n")
+	b.WriteString("# Generated by buildvault, an approximation of the pipeline\n")
+
+	seen := map[*Task]bool{}
+	var visit func(t *Task) error
+	visit = func(t *Task) error {
+		if seen[t] {
+			return nil
+		}
+		seen[t] = true
+		for _, dependency := range t.Dependencies {
+			if err := visit(dependency.Task); err != nil {
+				return err
+			}
+		}
+		return t.writeStage(&b)
+	}
+	for _, target := range targets {
+		if !target.isCircularDependencyFree(nil) {
+			return "", fmt.Errorf("circular dependency found in task '%s'", target.Name)
+		}
+		if err := target.resolveVars(); err != nil {
+			return "", err
+		}
+		if err := visit(target); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// writeStage writes the stage of t
+func (t *Task) writeStage(b *strings.Builder) error {
+	switch {
+	case t.Container != "":
+		fmt.Fprintf(b, "\n# Task '%s' adopts the external container %s, which a build cannot reference\n", t.Name, t.Container)
+		return nil
+	case t.Virtual:
+		// Dependents copy the re-exported artifacts from the upstream stages directly
+		fmt.Fprintf(b, "\n# Virtual task '%s' only re-exports artifacts of its dependencies\n", t.Name)
+		return nil
+	}
+
+	b.WriteString("\n")
+	image := t.BaseImage
+	if t.Build != nil {
+		image = builtImageTag(t.Name)
+		fmt.Fprintf(b, "# Build %s from %s first\n", image, path.Join(t.Build.Context, t.Build.Dockerfile))
+	}
+	for _, note := range t.dockerfileNotes() {
+		fmt.Fprintf(b, "# %s\n", note)
+	}
+	if t.Platform != "" {
+		fmt.Fprintf(b, "FROM --platform=%s %s AS %s\n", t.Platform, image, stageName(t.Name))
+	} else {
+		fmt.Fprintf(b, "FROM %s AS %s\n", image, stageName(t.Name))
+	}
+
+	config := t.containerConfig()
+	if config.User != "" && !t.HostUser {
+		fmt.Fprintf(b, "USER %s\n", config.User)
+	}
+	if config.WorkingDir != "" {
+		fmt.Fprintf(b, "WORKDIR %s\n", config.WorkingDir)
+	}
+	for _, env := range config.Env {
+		name, value, _ := strings.Cut(env, "=")
+		fmt.Fprintf(b, "ENV %s=%s\n", name, quoteJSON(value))
+	}
+
+	for _, c := range t.resolvedCopies() {
+		source, from, err := resolveArtifactSource(c.dependency, c.artifact.From)
+		if err != nil {
+			return err
+		}
+		if source.Container != "" {
+			fmt.Fprintf(b, "# COPY %s from the external container %s\n", from, source.Container)
+			continue
+		}
+		// Artifacts keep the base name of their source in the directory of their destination
+		to := path.Join(path.Dir(c.artifact.To), path.Base(from))
+		fmt.Fprintf(b, "COPY --from=%s %s %s\n", stageName(source.Name), from, to)
+	}
+
+	if len(t.Shell) > 0 {
+		fmt.Fprintf(b, "SHELL %s\n", quoteJSON(t.Shell))
+	}
+	mounts := t.runMounts()
+	switch {
+	case t.Script != "":
+		fmt.Fprintf(b, "RUN %s<<'BUILDVAULT_SCRIPT'\n%s%s\nBUILDVAULT_SCRIPT\n", mounts, scriptPrologue, strings.TrimRight(t.withPrelude(t.Script), "\n"))
+	case len(t.Cmd) > 0:
+		for _, argv := range t.Cmd {
+			fmt.Fprintf(b, "RUN %s%s\n", mounts, quoteJSON(argv))
+		}
+	case t.Prelude != "":
+		// Multi-line commands need a heredoc
+		for _, cmd := range t.Commands {
+			fmt.Fprintf(b, "RUN %s<<'BUILDVAULT_SCRIPT'\n%s\nBUILDVAULT_SCRIPT\n", mounts, strings.TrimRight(t.withPrelude(cmd), "\n"))
+		}
+	default:
+		for _, cmd := range t.Commands {
+			fmt.Fprintf(b, "RUN %s%s\n", mounts, cmd)
+		}
+	}
+	return nil
+}
+
+// runMounts returns the BuildKit mounts of the RUN instructions of t: its cache directories and secrets
+func (t *Task) runMounts() string {
+	var mounts []string
+	for _, dir := range t.CacheDirs {
+		mounts = append(mounts, "--mount=type=cache,target="+dir)
+	}
+	for _, secret := range t.Secrets {
+		if secret.Mount {
+			mounts = append(mounts, fmt.Sprintf("--mount=type=secret,id=%s,target=%s", secret.Name, path.Join(secretsDir, secret.Name)))
+		} else {
+			mounts = append(mounts, fmt.Sprintf("--mount=type=secret,id=%s,env=%s", secret.Name, secret.Name))
+		}
+	}
+	if len(mounts) == 0 {
+		return ""
+	}
+	return strings.Join(mounts, " ") + " "
+}
+
+// dockerfileNotes lists the settings of t its stage leaves out
+func (t *Task) dockerfileNotes() []string {
+	var notes []string
+	for _, m := range t.Mounts {
+		notes = append(notes, fmt.Sprintf("Task '%s' mounts %s at %s, which is left out", t.Name, m.Source, m.Target))
+	}
+	for _, service := range t.Services {
+		notes = append(notes, fmt.Sprintf("Task '%s' uses the service %s, which is left out", t.Name, service.Name))
+	}
+	if t.Network != nil {
+		notes = append(notes, fmt.Sprintf("Task '%s' has network settings, which are left out", t.Name))
+	}
+	if t.HostUser {
+		notes = append(notes, fmt.Sprintf("Task '%s' runs as the host user, which is left out", t.Name))
+	}
+	if t.When != nil {
+		notes = append(notes, fmt.Sprintf("Task '%s' has a condition, its stage is always built", t.Name))
+	}
+	return notes
+}
+
+// quoteJSON returns v in JSON notation, as used by the exec form of Dockerfile instructions
+func quoteJSON(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
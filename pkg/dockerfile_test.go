@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDockerfile(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+tasks:
+  - name: Deps
+    image: node:20
+    cache_dirs: [/root/.npm]
+    commands: ["npm ci"]
+    outputs: [/app/node_modules]
+  - name: bundle
+    virtual: true
+    dependencies:
+      - task: Deps
+        artifacts: [{from: /app/node_modules, to: /app/node_modules}]
+    outputs:
+      modules: /app/node_modules
+  - name: build
+    image: node:20
+    secrets: [{name: NPM_TOKEN}]
+    dependencies:
+      - task: bundle
+        artifacts: [{from: /app/node_modules, to: /src/node_modules}]
+    script: |
+      cd /src
+      npm run build
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+	build, _ := pipeline.Task("build")
+
+	dockerfile, err := Dockerfile([]*Task{build})
+	if err != nil {
+		t.Fatalf("Failed to generate Dockerfile: %v", err)
+	}
+	for _, want := range []string{
+		"FROM node:20 AS deps\n",
+		"RUN --mount=type=cache,target=/root/.npm npm ci\n",
+		"# Virtual task 'bundle' only re-exports artifacts of its dependencies\n",
+		"COPY --from=deps /app/node_modules /src/node_modules\n",
+		"RUN --mount=type=secret,id=NPM_TOKEN,env=NPM_TOKEN <<'BUILDVAULT_SCRIPT'\n",
+		"cd /src\nnpm run build\nBUILDVAULT_SCRIPT\n",
+	} {
+		if !strings.Contains(dockerfile, want) {
+			t.Errorf("Expected %q in Dockerfile:\n%s", want, dockerfile)
+		}
+	}
+	if strings.Index(dockerfile, "AS deps") > strings.Index(dockerfile, "AS build") {
+		t.Errorf("Expected dependencies first:\n%s", dockerfile)
+	}
+}
+
+func TestStageName(t *testing.T) {
+	if name := stageName("Build Docs/v2"); name != "build-docs-v2" {
+		t.Errorf("Unexpected stage name %s", name)
+	}
+}
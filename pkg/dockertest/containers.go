@@ -0,0 +1,448 @@
+package dockertest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Container is a container of the fake. Its configuration is the one it was created with, its file
+// system starts as a copy of the one of its image.
+type Container struct {
+	ID         string
+	Name       string // Without the leading slash
+	Image      string // ID of the image
+	Config     *container.Config
+	HostConfig *container.HostConfig
+	Platform   *ocispec.Platform
+
+	running  bool
+	started  bool
+	created  time.Time
+	networks map[string]*network.EndpointSettings
+
+	mu    sync.Mutex
+	files fileSystem
+	base  fileSystem // Files of the image, to diff against
+}
+
+// ReadFile returns the content of the regular file p.
+func (c *Container) ReadFile(p string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, ok := c.files.stat(p)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", p, os.ErrNotExist)
+	}
+	if !f.mode.IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", p)
+	}
+	return slices.Clone(f.data), nil
+}
+
+// WriteFile creates or replaces the regular file p, creating its parent directories.
+func (c *Container) WriteFile(p string, data []byte, mode os.FileMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.files.writeFile(p, data, mode)
+}
+
+// MkdirAll creates the directory p and its parents.
+func (c *Container) MkdirAll(p string, mode os.FileMode) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.files.mkdirAll(p, mode)
+}
+
+// RemoveAll removes p and everything below it.
+func (c *Container) RemoveAll(p string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files.remove(p)
+}
+
+// Exists reports whether there is a file or directory at p.
+func (c *Container) Exists(p string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.files.stat(p)
+	return ok
+}
+
+// mountsVolume reports whether the container mounts the volume name
+func (c *Container) mountsVolume(name string) bool {
+	if c.HostConfig == nil {
+		return false
+	}
+	for _, m := range c.HostConfig.Mounts {
+		if m.Type == mount.TypeVolume && m.Source == name {
+			return true
+		}
+	}
+	for _, bind := range c.HostConfig.Binds {
+		if strings.HasPrefix(bind, name+":") {
+			return true
+		}
+	}
+	return false
+}
+
+// state returns the docker status of the container
+func (c *Container) state() string {
+	switch {
+	case c.running:
+		return "running"
+	case c.started:
+		return "exited"
+	default:
+		return "created"
+	}
+}
+
+// Container returns the container with the ID or name nameOrID, e.g. to check the files a task left in it.
+func (f *Fake) Container(nameOrID string) (*Container, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.findContainer(nameOrID)
+	return c, err == nil
+}
+
+// Containers returns the containers of the fake, sorted by name.
+func (f *Fake) Containers() []*Container {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var containers []*Container
+	for _, c := range f.containers {
+		containers = append(containers, c)
+	}
+	slices.SortFunc(containers, func(a, b *Container) int { return strings.Compare(a.Name, b.Name) })
+	return containers
+}
+
+// findContainer looks up a container by ID, ID prefix or name. The caller must hold the lock.
+func (f *Fake) findContainer(nameOrID string) (*Container, error) {
+	if c, ok := f.containers[nameOrID]; ok {
+		return c, nil
+	}
+	name := strings.TrimPrefix(nameOrID, "/")
+	for _, c := range f.containers {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	if len(nameOrID) >= 12 {
+		for id, c := range f.containers {
+			if strings.HasPrefix(id, nameOrID) {
+				return c, nil
+			}
+		}
+	}
+	return nil, errdefs.NotFound(fmt.Errorf("No such container: %s", nameOrID))
+}
+
+// ContainerCreate creates a container of an image added or committed before.
+func (f *Fake) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	img, err := f.findImage(config.Image)
+	if err != nil {
+		return container.CreateResponse{}, err
+	}
+	id := randomID()
+	if containerName == "" {
+		containerName = "dockertest_" + id[:12]
+	}
+	if _, err := f.findContainer(containerName); err == nil {
+		return container.CreateResponse{}, errdefs.Conflict(fmt.Errorf("the container name \"/%s\" is already in use", containerName))
+	}
+	if hostConfig == nil {
+		hostConfig = &container.HostConfig{}
+	}
+
+	c := &Container{
+		ID:         id,
+		Name:       containerName,
+		Image:      img.ID,
+		Config:     config,
+		HostConfig: hostConfig,
+		Platform:   platform,
+		created:    time.Now(),
+		networks:   map[string]*network.EndpointSettings{},
+		files:      img.files.clone(),
+		base:       img.files,
+	}
+	if mode := string(hostConfig.NetworkMode); mode != "host" && mode != "none" && !strings.HasPrefix(mode, "container:") {
+		if mode == "" || mode == "default" {
+			mode = "bridge"
+		}
+		c.networks[mode] = &network.EndpointSettings{}
+	}
+	if networkingConfig != nil {
+		for name, endpoint := range networkingConfig.EndpointsConfig {
+			settings := &network.EndpointSettings{}
+			if endpoint != nil {
+				settings.Aliases = slices.Clone(endpoint.Aliases)
+			}
+			c.networks[name] = settings
+		}
+	}
+	f.containers[id] = c
+	return container.CreateResponse{ID: id}, nil
+}
+
+// ContainerStart starts a container, which keeps running until it is stopped. Starting connects it to
+// its networks with an address of its own.
+func (f *Fake) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if c.running {
+		return nil
+	}
+	for name, settings := range c.networks {
+		f.addresses++
+		settings.IPAddress = fmt.Sprintf("172.17.%d.%d", f.addresses/254, f.addresses%254+1)
+		if n, err := f.findNetwork(name); err == nil {
+			settings.NetworkID = n.ID
+			n.Containers[c.ID] = network.EndpointResource{Name: c.Name, IPv4Address: settings.IPAddress + "/16"}
+		}
+	}
+	c.running, c.started = true, true
+	return nil
+}
+
+// ContainerStop stops a running container.
+func (f *Fake) ContainerStop(ctx context.Context, containerID string, options container.StopOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+	c.running = false
+	return nil
+}
+
+// ContainerRemove removes a container. Running containers are only removed with Force.
+func (f *Fake) ContainerRemove(ctx context.Context, containerID string, options container.RemoveOptions) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.findContainer(containerID)
+	if err != nil {
+		return err
+	}
+	if c.running && !options.Force {
+		return errdefs.Conflict(fmt.Errorf("cannot remove container \"/%s\": container is running: stop the container before removing or force remove", c.Name))
+	}
+	for _, n := range f.networks {
+		delete(n.Containers, c.ID)
+	}
+	delete(f.containers, c.ID)
+	return nil
+}
+
+// ContainerInspect returns the state and configuration of a container.
+func (f *Fake) ContainerInspect(ctx context.Context, containerID string) (container.InspectResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.findContainer(containerID)
+	if err != nil {
+		return container.InspectResponse{}, err
+	}
+	networks := map[string]*network.EndpointSettings{}
+	for name, settings := range c.networks {
+		copied := *settings
+		networks[name] = &copied
+	}
+	return container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:         c.ID,
+			Name:       "/" + c.Name,
+			Image:      c.Image,
+			Created:    c.created.Format(time.RFC3339Nano),
+			State:      &container.State{Status: c.state(), Running: c.running},
+			HostConfig: c.HostConfig,
+			Platform:   "linux",
+		},
+		Config:          c.Config,
+		NetworkSettings: &container.NetworkSettings{Networks: networks},
+	}, nil
+}
+
+// ContainerList lists the running containers, or all with All, filtered by name, label, ancestor and
+// status.
+func (f *Fake) ContainerList(ctx context.Context, options container.ListOptions) ([]container.Summary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var summaries []container.Summary
+	for _, c := range f.containers {
+		if !c.running && !options.All {
+			continue
+		}
+		if !options.Filters.MatchKVList("label", c.Config.Labels) {
+			continue
+		}
+		if options.Filters.Contains("status") && !options.Filters.ExactMatch("status", c.state()) {
+			continue
+		}
+		if options.Filters.Contains("ancestor") && !slices.ContainsFunc(options.Filters.Get("ancestor"), func(ref string) bool {
+			img, err := f.findImage(ref)
+			return err == nil && img.ID == c.Image
+		}) {
+			continue
+		}
+		if options.Filters.Contains("name") && !slices.ContainsFunc(options.Filters.Get("name"), func(pattern string) bool {
+			matched, err := regexp.MatchString(pattern, "/"+c.Name)
+			return err == nil && matched
+		}) {
+			continue
+		}
+		summaries = append(summaries, container.Summary{
+			ID:      c.ID,
+			Names:   []string{"/" + c.Name},
+			Image:   c.Config.Image,
+			ImageID: c.Image,
+			Labels:  c.Config.Labels,
+			Created: c.created.Unix(),
+			State:   c.state(),
+			Status:  c.state(),
+			SizeRw:  c.files.size() - c.base.size(),
+		})
+	}
+	slices.SortFunc(summaries, func(a, b container.Summary) int { return strings.Compare(a.Names[0], b.Names[0]) })
+	return summaries, nil
+}
+
+// ContainerCommit creates an image of the current files of a container, tagged with Reference.
+func (f *Fake) ContainerCommit(ctx context.Context, containerID string, options container.CommitOptions) (container.CommitResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.findContainer(containerID)
+	if err != nil {
+		return container.CommitResponse{}, err
+	}
+	config := options.Config
+	if config == nil {
+		config = c.Config
+	}
+	var labels map[string]string
+	if config != nil {
+		labels = config.Labels
+	}
+	img := &Image{Architecture: f.images[c.Image].Architecture, Config: config, Labels: labels}
+	if options.Reference != "" {
+		img.Tags = []string{options.Reference}
+	}
+	c.mu.Lock()
+	img.files = c.files.clone()
+	c.mu.Unlock()
+	return container.CommitResponse{ID: f.addImage(img)}, nil
+}
+
+// ContainerDiff lists the files that were added, modified or deleted in a container compared to its image.
+func (f *Fake) ContainerDiff(ctx context.Context, containerID string) ([]container.FilesystemChange, error) {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var changes []container.FilesystemChange
+	for p, current := range c.files {
+		original, ok := c.base[p]
+		switch {
+		case !ok:
+			changes = append(changes, container.FilesystemChange{Kind: container.ChangeAdd, Path: p})
+		case original.mode != current.mode || !bytes.Equal(original.data, current.data):
+			changes = append(changes, container.FilesystemChange{Kind: container.ChangeModify, Path: p})
+		}
+	}
+	for p := range c.base {
+		if _, ok := c.files[p]; !ok {
+			changes = append(changes, container.FilesystemChange{Kind: container.ChangeDelete, Path: p})
+		}
+	}
+	slices.SortFunc(changes, func(a, b container.FilesystemChange) int { return strings.Compare(a.Path, b.Path) })
+	return changes, nil
+}
+
+// lookup returns the container with the ID or name nameOrID
+func (f *Fake) lookup(nameOrID string) (*Container, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.findContainer(nameOrID)
+}
+
+// pathStat describes the file at p
+func pathStat(p string, f *file) container.PathStat {
+	stat := container.PathStat{Name: path.Base(clean(p)), Size: int64(len(f.data)), Mode: f.mode, Mtime: f.modTime}
+	if f.mode&os.ModeSymlink != 0 {
+		stat.LinkTarget = string(f.data)
+	}
+	return stat
+}
+
+// ContainerStatPath describes the file at path in a container.
+func (f *Fake) ContainerStatPath(ctx context.Context, containerID, path string) (container.PathStat, error) {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return container.PathStat{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	file, ok := c.files.stat(path)
+	if !ok {
+		return container.PathStat{}, errdefs.NotFound(fmt.Errorf("Could not find the file %s in container %s", path, containerID))
+	}
+	return pathStat(path, file), nil
+}
+
+// CopyToContainer extracts the tar stream content into the existing directory dstPath of a container.
+func (f *Fake) CopyToContainer(ctx context.Context, containerID, dstPath string, content io.Reader, options container.CopyToContainerOptions) error {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.files.extract(dstPath, content); err != nil {
+		return errdefs.NotFound(fmt.Errorf("error extracting into container %s: %w", containerID, err))
+	}
+	return nil
+}
+
+// CopyFromContainer returns a tar stream of srcPath in a container and everything below it.
+func (f *Fake) CopyFromContainer(ctx context.Context, containerID, srcPath string) (io.ReadCloser, container.PathStat, error) {
+	c, err := f.lookup(containerID)
+	if err != nil {
+		return nil, container.PathStat{}, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	file, ok := c.files.stat(srcPath)
+	if !ok {
+		return nil, container.PathStat{}, errdefs.NotFound(fmt.Errorf("Could not find the file %s in container %s", srcPath, containerID))
+	}
+	archive, err := c.files.archive(srcPath)
+	if err != nil {
+		return nil, container.PathStat{}, fmt.Errorf("error archiving %s: %w", srcPath, err)
+	}
+	return io.NopCloser(bytes.NewReader(archive)), pathStat(srcPath, file), nil
+}
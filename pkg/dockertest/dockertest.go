@@ -0,0 +1,511 @@
+// Package dockertest provides Fake, an in-memory implementation of the Docker API buildvault uses, so
+// tasks and pipelines can be unit-tested without a Docker daemon.
+//
+// The fake keeps images, containers, networks and volumes in memory. Containers have a file system that
+// files can be copied into and out of, and commands executed in them are handed to an ExecFunc, which
+// by default only knows mkdir -p and succeeds without output for everything else. Image builds and
+// saves are not supported and fail with a not-implemented error.
+package dockertest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// Image is an image known to the fake, added with AddImage or committed from a container.
+type Image struct {
+	ID           string            // Generated if empty
+	Tags         []string          // References the image can be pulled and inspected by, e.g. alpine:3.20
+	Architecture string            // Go architecture, runtime.GOARCH if empty
+	Config       *container.Config // Default configuration of containers, like Env and WorkingDir
+	Labels       map[string]string // Labels of the image
+	Files        map[string]string // Contents of the regular files by absolute path, directories are implied
+
+	files   fileSystem
+	created time.Time
+}
+
+// Fake is an in-memory Docker daemon. The zero value is not usable, create one with New.
+type Fake struct {
+	// Exec runs the commands executed in containers, Builtins if nil. It is called without any lock
+	// held, so it may use the file system of the container.
+	Exec ExecFunc
+
+	mu         sync.Mutex
+	images     map[string]*Image // By ID
+	containers map[string]*Container
+	execs      map[string]*execution
+	networks   map[string]*network.Inspect
+	volumes    map[string]*volume.Volume
+	addresses  int
+}
+
+// New creates an empty fake daemon.
+func New() *Fake {
+	return &Fake{
+		images:     map[string]*Image{},
+		containers: map[string]*Container{},
+		execs:      map[string]*execution{},
+		networks:   map[string]*network.Inspect{},
+		volumes:    map[string]*volume.Volume{},
+	}
+}
+
+// AddImage makes img available to pulls and containers and returns its ID. An image with the same tag
+// loses the tag, like on a real daemon.
+func (f *Fake) AddImage(img Image) string {
+	img.files = fileSystem{}
+	for path, content := range img.Files {
+		img.files.writeFile(path, []byte(content), 0o755)
+	}
+	img.Files = nil
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addImage(&img)
+}
+
+func (f *Fake) addImage(img *Image) string {
+	if img.ID == "" {
+		img.ID = "sha256:" + randomID()
+	}
+	if img.Architecture == "" {
+		img.Architecture = runtime.GOARCH
+	}
+	if img.Config == nil {
+		img.Config = &container.Config{}
+	}
+	if img.created.IsZero() {
+		img.created = time.Now()
+	}
+	tags := img.Tags
+	img.Tags = nil
+	f.images[img.ID] = img
+	for _, tag := range tags {
+		f.tag(img, tag)
+	}
+	return img.ID
+}
+
+// tag moves the normalized reference ref to img
+func (f *Fake) tag(img *Image, ref string) {
+	ref = normalize(ref)
+	for _, other := range f.images {
+		other.Tags = slices.DeleteFunc(other.Tags, func(tag string) bool { return tag == ref })
+	}
+	img.Tags = append(img.Tags, ref)
+}
+
+// findImage looks up an image by ID or reference. The caller must hold the lock.
+func (f *Fake) findImage(ref string) (*Image, error) {
+	if img, ok := f.images[ref]; ok {
+		return img, nil
+	}
+	if img, ok := f.images["sha256:"+ref]; ok {
+		return img, nil
+	}
+	normalized := normalize(ref)
+	for _, img := range f.images {
+		if slices.Contains(img.Tags, normalized) {
+			return img, nil
+		}
+	}
+	return nil, errdefs.NotFound(fmt.Errorf("No such image: %s", ref))
+}
+
+// normalize returns the familiar form of an image reference with a tag, e.g. alpine:latest
+func normalize(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ref
+	}
+	return reference.FamiliarString(reference.TagNameOnly(named))
+}
+
+// randomID returns a random 64 hex digit ID like the daemon's
+func randomID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// notImplemented is the error of the operations the fake does not support
+func notImplemented(operation string) error {
+	return errdefs.NotImplemented(fmt.Errorf("%s is not supported by the fake Docker daemon", operation))
+}
+
+// ClientVersion returns the API version of the Docker client the fake imitates.
+func (f *Fake) ClientVersion() string {
+	return api.DefaultVersion
+}
+
+// DaemonHost returns a URL that identifies the fake.
+func (f *Fake) DaemonHost() string {
+	return "fake://dockertest"
+}
+
+// Close does nothing, the fake holds no connections.
+func (f *Fake) Close() error {
+	return nil
+}
+
+// Ping always succeeds.
+func (f *Fake) Ping(ctx context.Context) (types.Ping, error) {
+	return types.Ping{APIVersion: api.DefaultVersion, OSType: "linux"}, nil
+}
+
+// ServerVersion describes the fake as a Linux daemon on the architecture of the tests.
+func (f *Fake) ServerVersion(ctx context.Context) (types.Version, error) {
+	return types.Version{Version: "dockertest", APIVersion: api.DefaultVersion, Os: "linux", Arch: runtime.GOARCH}, nil
+}
+
+// ImageInspect returns the image with the ID or reference imageID.
+func (f *Fake) ImageInspect(ctx context.Context, imageID string, inspectOpts ...client.ImageInspectOption) (image.InspectResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	img, err := f.findImage(imageID)
+	if err != nil {
+		return image.InspectResponse{}, err
+	}
+	config := *img.Config
+	config.Labels = img.Labels
+	return image.InspectResponse{
+		ID:           img.ID,
+		RepoTags:     slices.Clone(img.Tags),
+		Created:      img.created.Format(time.RFC3339Nano),
+		Os:           "linux",
+		Architecture: img.Architecture,
+		Config:       &config,
+	}, nil
+}
+
+// ImageList lists the images, filtered by label, reference and dangling.
+func (f *Fake) ImageList(ctx context.Context, options image.ListOptions) ([]image.Summary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var summaries []image.Summary
+	for _, img := range f.images {
+		if !options.Filters.MatchKVList("label", img.Labels) {
+			continue
+		}
+		if options.Filters.Contains("dangling") && options.Filters.ExactMatch("dangling", "true") != (len(img.Tags) == 0) {
+			continue
+		}
+		if options.Filters.Contains("reference") && !slices.ContainsFunc(img.Tags, func(tag string) bool {
+			return options.Filters.Match("reference", tag)
+		}) {
+			continue
+		}
+		containers := int64(0)
+		for _, c := range f.containers {
+			if c.Image == img.ID {
+				containers++
+			}
+		}
+		summaries = append(summaries, image.Summary{
+			ID:         img.ID,
+			RepoTags:   slices.Clone(img.Tags),
+			Labels:     img.Labels,
+			Created:    img.created.Unix(),
+			Size:       img.files.size(),
+			Containers: containers,
+		})
+	}
+	slices.SortFunc(summaries, func(a, b image.Summary) int { return strings.Compare(a.ID, b.ID) })
+	return summaries, nil
+}
+
+// ImagePull succeeds for images added before and fails with not found for all others, as if the
+// registry did not have them.
+func (f *Fake) ImagePull(ctx context.Context, refStr string, options image.PullOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	img, err := f.findImage(refStr)
+	f.mu.Unlock()
+	if err != nil {
+		return nil, errdefs.NotFound(fmt.Errorf("pull access denied for %s, repository does not exist", refStr))
+	}
+	return messages(
+		map[string]any{"status": "Pulling from " + refStr},
+		map[string]any{"status": "Digest: " + img.ID},
+		map[string]any{"status": "Status: Image is up to date for " + normalize(refStr)},
+	), nil
+}
+
+// ImagePush pretends to push a tagged image and reports its ID as the digest.
+func (f *Fake) ImagePush(ctx context.Context, ref string, options image.PushOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	img, err := f.findImage(ref)
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	tag := "latest"
+	if named, err := reference.ParseNormalizedNamed(ref); err == nil {
+		if tagged, ok := reference.TagNameOnly(named).(reference.Tagged); ok {
+			tag = tagged.Tag()
+		}
+	}
+	return messages(
+		map[string]any{"status": "The push refers to repository " + ref},
+		map[string]any{"status": tag + ": digest: " + img.ID},
+		map[string]any{"progressDetail": map[string]any{}, "aux": map[string]any{"Tag": tag, "Digest": img.ID, "Size": img.files.size()}},
+	), nil
+}
+
+// messages returns a JSON message stream like the ones of pulls and pushes
+func messages(stream ...map[string]any) io.ReadCloser {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	for _, message := range stream {
+		if err := encoder.Encode(message); err != nil {
+			panic(err)
+		}
+	}
+	return io.NopCloser(strings.NewReader(b.String()))
+}
+
+// ImageBuild is not supported.
+func (f *Fake) ImageBuild(ctx context.Context, buildContext io.Reader, options types.ImageBuildOptions) (types.ImageBuildResponse, error) {
+	return types.ImageBuildResponse{}, notImplemented("building images")
+}
+
+// ImageTag adds the reference target to the image source.
+func (f *Fake) ImageTag(ctx context.Context, source, target string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	img, err := f.findImage(source)
+	if err != nil {
+		return err
+	}
+	f.tag(img, target)
+	return nil
+}
+
+// ImageSave is not supported.
+func (f *Fake) ImageSave(ctx context.Context, imageIDs []string, saveOpts ...client.ImageSaveOption) (io.ReadCloser, error) {
+	return nil, notImplemented("saving images")
+}
+
+// ImageRemove removes a tag of an image, and the image with its last tag or when removed by ID. Images
+// used by containers are only removed with Force.
+func (f *Fake) ImageRemove(ctx context.Context, imageID string, options image.RemoveOptions) ([]image.DeleteResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	img, err := f.findImage(imageID)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []image.DeleteResponse
+	if ref := normalize(imageID); slices.Contains(img.Tags, ref) && len(img.Tags) > 1 {
+		img.Tags = slices.DeleteFunc(img.Tags, func(tag string) bool { return tag == ref })
+		return []image.DeleteResponse{{Untagged: ref}}, nil
+	}
+	if !options.Force {
+		for _, c := range f.containers {
+			if c.Image == img.ID {
+				return nil, errdefs.Conflict(fmt.Errorf("unable to remove image %s, container %s is using it", imageID, c.ID[:12]))
+			}
+		}
+	}
+	for _, tag := range img.Tags {
+		deleted = append(deleted, image.DeleteResponse{Untagged: tag})
+	}
+	delete(f.images, img.ID)
+	return append(deleted, image.DeleteResponse{Deleted: img.ID}), nil
+}
+
+// NetworkCreate creates a network. Names are unique.
+func (f *Fake) NetworkCreate(ctx context.Context, name string, options network.CreateOptions) (network.CreateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, n := range f.networks {
+		if n.Name == name {
+			return network.CreateResponse{}, errdefs.Conflict(fmt.Errorf("network with name %s already exists", name))
+		}
+	}
+	driver := options.Driver
+	if driver == "" {
+		driver = "bridge"
+	}
+	id := randomID()
+	f.networks[id] = &network.Inspect{
+		Name:       name,
+		ID:         id,
+		Created:    time.Now(),
+		Driver:     driver,
+		Internal:   options.Internal,
+		Labels:     options.Labels,
+		Containers: map[string]network.EndpointResource{},
+	}
+	return network.CreateResponse{ID: id}, nil
+}
+
+// findNetwork looks up a network by ID or name. The caller must hold the lock.
+func (f *Fake) findNetwork(networkID string) (*network.Inspect, error) {
+	if n, ok := f.networks[networkID]; ok {
+		return n, nil
+	}
+	for _, n := range f.networks {
+		if n.Name == networkID {
+			return n, nil
+		}
+	}
+	return nil, errdefs.NotFound(fmt.Errorf("network %s not found", networkID))
+}
+
+// NetworkInspect returns the network with the ID or name networkID.
+func (f *Fake) NetworkInspect(ctx context.Context, networkID string, options network.InspectOptions) (network.Inspect, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.findNetwork(networkID)
+	if err != nil {
+		return network.Inspect{}, err
+	}
+	return *n, nil
+}
+
+// NetworkList lists the networks, filtered by label and name.
+func (f *Fake) NetworkList(ctx context.Context, options network.ListOptions) ([]network.Summary, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var summaries []network.Summary
+	for _, n := range f.networks {
+		if !options.Filters.MatchKVList("label", n.Labels) {
+			continue
+		}
+		if options.Filters.Contains("name") && !options.Filters.Match("name", n.Name) {
+			continue
+		}
+		summaries = append(summaries, *n)
+	}
+	slices.SortFunc(summaries, func(a, b network.Summary) int { return strings.Compare(a.Name, b.Name) })
+	return summaries, nil
+}
+
+// NetworkDisconnect disconnects a container from a network.
+func (f *Fake) NetworkDisconnect(ctx context.Context, networkID, containerID string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.findNetwork(networkID)
+	if err != nil {
+		return err
+	}
+	c, err := f.findContainer(containerID)
+	if err != nil {
+		if force {
+			delete(n.Containers, containerID)
+			return nil
+		}
+		return err
+	}
+	if _, ok := n.Containers[c.ID]; !ok {
+		return errdefs.InvalidParameter(fmt.Errorf("container %s is not connected to network %s", containerID, n.Name))
+	}
+	delete(n.Containers, c.ID)
+	delete(c.networks, n.Name)
+	return nil
+}
+
+// NetworkRemove removes a network without connected containers.
+func (f *Fake) NetworkRemove(ctx context.Context, networkID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n, err := f.findNetwork(networkID)
+	if err != nil {
+		return err
+	}
+	if len(n.Containers) > 0 {
+		return errdefs.Forbidden(fmt.Errorf("error while removing network: network %s has active endpoints", n.Name))
+	}
+	delete(f.networks, n.ID)
+	return nil
+}
+
+// VolumeCreate creates a volume, or returns the existing one of the same name.
+func (f *Fake) VolumeCreate(ctx context.Context, options volume.CreateOptions) (volume.Volume, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := options.Name
+	if name == "" {
+		name = randomID()
+	}
+	if v, ok := f.volumes[name]; ok {
+		return *v, nil
+	}
+	driver := options.Driver
+	if driver == "" {
+		driver = "local"
+	}
+	v := &volume.Volume{
+		Name:       name,
+		Driver:     driver,
+		Labels:     options.Labels,
+		Mountpoint: "/var/lib/docker/volumes/" + name + "/_data",
+		Scope:      "local",
+		CreatedAt:  time.Now().Format(time.RFC3339),
+	}
+	f.volumes[name] = v
+	return *v, nil
+}
+
+// VolumeList lists the volumes, filtered by label and name.
+func (f *Fake) VolumeList(ctx context.Context, options volume.ListOptions) (volume.ListResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var list volume.ListResponse
+	for _, v := range f.volumes {
+		if !options.Filters.MatchKVList("label", v.Labels) {
+			continue
+		}
+		if options.Filters.Contains("name") && !options.Filters.Match("name", v.Name) {
+			continue
+		}
+		v := *v
+		list.Volumes = append(list.Volumes, &v)
+	}
+	slices.SortFunc(list.Volumes, func(a, b *volume.Volume) int { return strings.Compare(a.Name, b.Name) })
+	return list, nil
+}
+
+// VolumeRemove removes a volume that no container mounts, unless forced.
+func (f *Fake) VolumeRemove(ctx context.Context, volumeID string, force bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.volumes[volumeID]; !ok {
+		if force {
+			return nil
+		}
+		return errdefs.NotFound(fmt.Errorf("get %s: no such volume", volumeID))
+	}
+	if !force {
+		for _, c := range f.containers {
+			if c.mountsVolume(volumeID) {
+				return errdefs.Conflict(fmt.Errorf("remove %s: volume is in use - [%s]", volumeID, c.ID))
+			}
+		}
+	}
+	delete(f.volumes, volumeID)
+	return nil
+}
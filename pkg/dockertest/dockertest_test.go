@@ -0,0 +1,199 @@
+package dockertest
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+func TestContainerFiles(t *testing.T) {
+	ctx := context.Background()
+	fake := New()
+	fake.AddImage(Image{Tags: []string{"alpine"}, Files: map[string]string{"/etc/os-release": "alpine"}})
+
+	resp, err := fake.ContainerCreate(ctx, &container.Config{Image: "alpine:latest"}, nil, nil, nil, "files")
+	if err != nil {
+		t.Fatalf("Failed to create container: %v", err)
+	}
+	if _, err := fake.ContainerCreate(ctx, &container.Config{Image: "alpine"}, nil, nil, nil, "files"); !errdefs.IsConflict(err) {
+		t.Errorf("Expected a conflict for a duplicate name, got %v", err)
+	}
+	if _, err := fake.ContainerCreate(ctx, &container.Config{Image: "debian"}, nil, nil, nil, ""); !errdefs.IsNotFound(err) {
+		t.Errorf("Expected an unknown image not to be found, got %v", err)
+	}
+
+	var archive bytes.Buffer
+	writer := tar.NewWriter(&archive)
+	writer.WriteHeader(&tar.Header{Name: "out/", Typeflag: tar.TypeDir, Mode: 0o755})
+	writer.WriteHeader(&tar.Header{Name: "out/data.txt", Typeflag: tar.TypeReg, Mode: 0o644, Size: 4})
+	writer.Write([]byte("data"))
+	writer.Close()
+	if err := fake.CopyToContainer(ctx, "files", "/missing", bytes.NewReader(archive.Bytes()), container.CopyToContainerOptions{}); !errdefs.IsNotFound(err) {
+		t.Errorf("Expected copying into a missing directory to fail, got %v", err)
+	}
+	if err := fake.CopyToContainer(ctx, resp.ID, "/", &archive, container.CopyToContainerOptions{}); err != nil {
+		t.Fatalf("Failed to copy to container: %v", err)
+	}
+
+	stat, err := fake.ContainerStatPath(ctx, "files", "/out/data.txt")
+	if err != nil || stat.Name != "data.txt" || stat.Size != 4 {
+		t.Errorf("Unexpected stat %+v: %v", stat, err)
+	}
+	if _, err := fake.ContainerStatPath(ctx, "files", "/out/missing"); !errdefs.IsNotFound(err) {
+		t.Errorf("Expected a missing path not to be found, got %v", err)
+	}
+
+	reader, stat, err := fake.CopyFromContainer(ctx, "files", "/out")
+	if err != nil || !stat.Mode.IsDir() {
+		t.Fatalf("Failed to copy from container %+v: %v", stat, err)
+	}
+	var names []string
+	entries := tar.NewReader(reader)
+	for {
+		header, err := entries.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read tar: %v", err)
+		}
+		names = append(names, header.Name)
+	}
+	if strings.Join(names, ",") != "out/,out/data.txt" {
+		t.Errorf("Unexpected tar entries %v", names)
+	}
+
+	changes, err := fake.ContainerDiff(ctx, "files")
+	if err != nil || len(changes) != 2 || changes[0].Path != "/out" || changes[0].Kind != container.ChangeAdd {
+		t.Errorf("Unexpected changes %+v: %v", changes, err)
+	}
+
+	commit, err := fake.ContainerCommit(ctx, "files", container.CommitOptions{Reference: "files:1"})
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	inspect, err := fake.ImageInspect(ctx, "files:1")
+	if err != nil || inspect.ID != commit.ID {
+		t.Errorf("Unexpected committed image %+v: %v", inspect, err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	ctx := context.Background()
+	fake := New()
+	fake.AddImage(Image{Tags: []string{"alpine"}})
+	resp, err := fake.ContainerCreate(ctx, &container.Config{Image: "alpine", WorkingDir: "/work"}, nil, nil, nil, "exec")
+	if err != nil {
+		t.Fatalf("Failed to create container: %v", err)
+	}
+	if _, err := fake.ContainerExecCreate(ctx, resp.ID, container.ExecOptions{Cmd: []string{"true"}}); !errdefs.IsConflict(err) {
+		t.Errorf("Expected execs in stopped containers to fail, got %v", err)
+	}
+	if err := fake.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		t.Fatalf("Failed to start container: %v", err)
+	}
+
+	run := func(options container.ExecOptions, stdin string) (string, string, int) {
+		options.AttachStdout, options.AttachStderr = true, true
+		exec, err := fake.ContainerExecCreate(ctx, resp.ID, options)
+		if err != nil {
+			t.Fatalf("Failed to create exec: %v", err)
+		}
+		attach, err := fake.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+		if err != nil {
+			t.Fatalf("Failed to attach exec: %v", err)
+		}
+		defer attach.Close()
+		if options.AttachStdin {
+			go func() {
+				io.WriteString(attach.Conn, stdin)
+				attach.CloseWrite()
+			}()
+		}
+		var stdout, stderr bytes.Buffer
+		if _, err := stdcopy.StdCopy(&stdout, &stderr, attach.Reader); err != nil {
+			t.Fatalf("Failed to read output: %v", err)
+		}
+		inspect, err := fake.ContainerExecInspect(ctx, exec.ID)
+		if err != nil || inspect.Running {
+			t.Fatalf("Unexpected exec state %+v: %v", inspect, err)
+		}
+		return stdout.String(), stderr.String(), inspect.ExitCode
+	}
+
+	// Builtins also run as busybox applets and resolve paths against the working directory
+	if _, stderr, code := run(container.ExecOptions{Cmd: []string{"/bin/busybox", "mkdir", "-p", "out"}}, ""); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr)
+	}
+	c, _ := fake.Container("exec")
+	if !c.Exists("/work/out") {
+		t.Errorf("mkdir did not create the directory")
+	}
+	if stdout, _, code := run(container.ExecOptions{Cmd: []string{"cat"}, AttachStdin: true}, "piped"); code != 0 || stdout != "piped" {
+		t.Errorf("Unexpected output %q of cat with exit code %d", stdout, code)
+	}
+	if _, stderr, code := run(container.ExecOptions{Cmd: []string{"cat", "missing"}}, ""); code != 1 || !strings.Contains(stderr, "missing") {
+		t.Errorf("Unexpected error %q of cat with exit code %d", stderr, code)
+	}
+
+	fake.Exec = func(e *Exec) int {
+		io.WriteString(e.Stdout, strings.Join(e.Cmd, " "))
+		io.WriteString(e.Stderr, e.User)
+		return 3
+	}
+	if stdout, stderr, code := run(container.ExecOptions{Cmd: []string{"make", "all"}, User: "build"}, ""); stdout != "make all" || stderr != "build" || code != 3 {
+		t.Errorf("Unexpected result %q %q %d of a custom exec", stdout, stderr, code)
+	}
+}
+
+func TestListAndRemove(t *testing.T) {
+	ctx := context.Background()
+	fake := New()
+	fake.AddImage(Image{Tags: []string{"alpine"}})
+	for _, name := range []string{"buildvault_a", "buildvault_b", "other"} {
+		if _, err := fake.ContainerCreate(ctx, &container.Config{Image: "alpine", Labels: map[string]string{"task": name}}, nil, nil, nil, name); err != nil {
+			t.Fatalf("Failed to create container: %v", err)
+		}
+	}
+	if err := fake.ContainerStart(ctx, "buildvault_b", container.StartOptions{}); err != nil {
+		t.Fatalf("Failed to start container: %v", err)
+	}
+
+	named := filters.NewArgs(filters.Arg("name", "buildvault_"))
+	all, _ := fake.ContainerList(ctx, container.ListOptions{All: true, Filters: named})
+	running, _ := fake.ContainerList(ctx, container.ListOptions{Filters: named})
+	labelled, _ := fake.ContainerList(ctx, container.ListOptions{All: true, Filters: filters.NewArgs(filters.Arg("label", "task=other"))})
+	if len(all) != 2 || len(running) != 1 || running[0].Names[0] != "/buildvault_b" || len(labelled) != 1 {
+		t.Errorf("Unexpected lists %v, %v and %v", all, running, labelled)
+	}
+
+	if err := fake.ContainerRemove(ctx, "buildvault_b", container.RemoveOptions{}); !errdefs.IsConflict(err) {
+		t.Errorf("Expected removing a running container to fail, got %v", err)
+	}
+	if _, err := fake.ImageRemove(ctx, "alpine", image.RemoveOptions{}); !errdefs.IsConflict(err) {
+		t.Errorf("Expected removing a used image to fail, got %v", err)
+	}
+	if err := fake.ContainerRemove(ctx, "buildvault_b", container.RemoveOptions{Force: true}); err != nil {
+		t.Errorf("Failed to force remove a running container: %v", err)
+	}
+	if _, ok := fake.Container("buildvault_b"); ok {
+		t.Errorf("Removed container still exists")
+	}
+
+	if _, err := fake.ImagePull(ctx, "debian", image.PullOptions{}); !errdefs.IsNotFound(err) {
+		t.Errorf("Expected pulling an unknown image to fail, got %v", err)
+	}
+	if _, err := fake.ImageBuild(ctx, nil, types.ImageBuildOptions{}); !errdefs.IsNotImplemented(err) {
+		t.Errorf("Expected builds not to be implemented, got %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package dockertest
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// Exec is a command executed in a container of the fake.
+type Exec struct {
+	Container  *Container
+	Cmd        []string
+	Env        []string
+	User       string
+	WorkingDir string
+	Stdin      io.Reader // Empty unless the exec attaches stdin
+	Stdout     io.Writer
+	Stderr     io.Writer
+}
+
+// ExecFunc runs an exec and returns its exit code.
+type ExecFunc func(e *Exec) int
+
+// Builtins is the default ExecFunc. It implements mkdir -p, cat, echo, true and false on the file
+// system of the container, also called like busybox applets with the binary as first argument, and
+// succeeds without output for every other command.
+func Builtins(e *Exec) int {
+	cmd := e.Cmd
+	if len(cmd) > 1 && !isBuiltin(cmd[0]) && isBuiltin(cmd[1]) {
+		cmd = cmd[1:]
+	}
+	if len(cmd) == 0 {
+		return 0
+	}
+
+	switch path.Base(cmd[0]) {
+	case "mkdir":
+		for _, dir := range cmd[1:] {
+			if dir == "-p" {
+				continue
+			}
+			if err := e.Container.MkdirAll(e.resolve(dir), 0o755); err != nil {
+				fmt.Fprintf(e.Stderr, "mkdir: %v\n", err)
+				return 1
+			}
+		}
+	case "cat":
+		if len(cmd) == 1 {
+			if _, err := io.Copy(e.Stdout, e.Stdin); err != nil {
+				return 1
+			}
+		}
+		for _, file := range cmd[1:] {
+			data, err := e.Container.ReadFile(e.resolve(file))
+			if err != nil {
+				fmt.Fprintf(e.Stderr, "cat: %v\n", err)
+				return 1
+			}
+			e.Stdout.Write(data)
+		}
+	case "echo":
+		fmt.Fprintln(e.Stdout, strings.Join(cmd[1:], " "))
+	case "false":
+		return 1
+	}
+	return 0
+}
+
+// isBuiltin reports whether the command name is implemented by Builtins
+func isBuiltin(name string) bool {
+	switch path.Base(name) {
+	case "mkdir", "cat", "echo", "true", "false":
+		return true
+	}
+	return false
+}
+
+// resolve makes p absolute against the working directory of the exec
+func (e *Exec) resolve(p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+	return path.Join("/", e.WorkingDir, p)
+}
+
+// execution is an exec created in a container
+type execution struct {
+	exec     Exec
+	options  container.ExecOptions
+	started  bool
+	running  bool
+	exitCode int
+}
+
+// ContainerExecCreate creates an exec of options.Cmd in a running container.
+func (f *Fake) ContainerExecCreate(ctx context.Context, containerID string, options container.ExecOptions) (container.ExecCreateResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c, err := f.findContainer(containerID)
+	if err != nil {
+		return container.ExecCreateResponse{}, err
+	}
+	if !c.running {
+		return container.ExecCreateResponse{}, errdefs.Conflict(fmt.Errorf("container %s is not running", c.ID))
+	}
+	workingDir := options.WorkingDir
+	if workingDir == "" {
+		workingDir = c.Config.WorkingDir
+	}
+	id := randomID()
+	f.execs[id] = &execution{
+		exec: Exec{
+			Container:  c,
+			Cmd:        options.Cmd,
+			Env:        append(append([]string{}, c.Config.Env...), options.Env...),
+			User:       options.User,
+			WorkingDir: workingDir,
+		},
+		options: options,
+	}
+	return container.ExecCreateResponse{ID: id}, nil
+}
+
+// ContainerExecAttach starts an exec. Its output is multiplexed like the daemon's unless it has a TTY,
+// and writes to the connection go to its stdin until the connection is closed for writing.
+func (f *Fake) ContainerExecAttach(ctx context.Context, execID string, config container.ExecAttachOptions) (types.HijackedResponse, error) {
+	f.mu.Lock()
+	execution, ok := f.execs[execID]
+	if ok && execution.started {
+		f.mu.Unlock()
+		return types.HijackedResponse{}, errdefs.Conflict(fmt.Errorf("exec %s has already been started", execID))
+	}
+	if ok {
+		execution.started, execution.running = true, true
+	}
+	run := f.Exec
+	f.mu.Unlock()
+	if !ok {
+		return types.HijackedResponse{}, errdefs.NotFound(fmt.Errorf("No such exec instance: %s", execID))
+	}
+	if run == nil {
+		run = Builtins
+	}
+
+	output, outputWriter := io.Pipe()
+	stdin, stdinWriter := io.Pipe()
+	conn := &conn{output: output, stdin: stdinWriter}
+
+	e := execution.exec
+	e.Stdin = strings.NewReader("")
+	if execution.options.AttachStdin {
+		e.Stdin = stdin
+	}
+	e.Stdout, e.Stderr = outputWriter, outputWriter
+	if !execution.options.Tty {
+		e.Stdout = stdcopy.NewStdWriter(outputWriter, stdcopy.Stdout)
+		e.Stderr = stdcopy.NewStdWriter(outputWriter, stdcopy.Stderr)
+	}
+	if !execution.options.AttachStdout {
+		e.Stdout = io.Discard
+	}
+	if !execution.options.AttachStderr {
+		e.Stderr = io.Discard
+	}
+
+	go func() {
+		exitCode := run(&e)
+		f.mu.Lock()
+		execution.running, execution.exitCode = false, exitCode
+		f.mu.Unlock()
+		outputWriter.Close()
+	}()
+	return types.HijackedResponse{Conn: conn, Reader: bufio.NewReader(conn)}, nil
+}
+
+// ContainerExecInspect returns whether an exec is running and its exit code once it exited.
+func (f *Fake) ContainerExecInspect(ctx context.Context, execID string) (container.ExecInspect, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	execution, ok := f.execs[execID]
+	if !ok {
+		return container.ExecInspect{}, errdefs.NotFound(fmt.Errorf("No such exec instance: %s", execID))
+	}
+	return container.ExecInspect{
+		ExecID:      execID,
+		ContainerID: execution.exec.Container.ID,
+		Running:     execution.running,
+		ExitCode:    execution.exitCode,
+	}, nil
+}
+
+// ContainerExecResize does nothing, the fake has no terminals.
+func (f *Fake) ContainerExecResize(ctx context.Context, execID string, options container.ResizeOptions) error {
+	return nil
+}
+
+// conn is the hijacked connection of an attached exec
+type conn struct {
+	output *io.PipeReader
+	stdin  *io.PipeWriter
+	once   sync.Once
+}
+
+func (c *conn) Read(b []byte) (int, error)  { return c.output.Read(b) }
+func (c *conn) Write(b []byte) (int, error) { return c.stdin.Write(b) }
+
+// CloseWrite closes the stdin of the exec, like the daemon's connection does.
+func (c *conn) CloseWrite() error {
+	return c.stdin.Close()
+}
+
+// Close stops reading the output and closes the stdin of the exec.
+func (c *conn) Close() error {
+	c.once.Do(func() {
+		c.stdin.Close()
+		c.output.Close()
+	})
+	return nil
+}
+
+func (c *conn) LocalAddr() net.Addr                { return address{} }
+func (c *conn) RemoteAddr() net.Addr               { return address{} }
+func (c *conn) SetDeadline(t time.Time) error      { return nil }
+func (c *conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *conn) SetWriteDeadline(t time.Time) error { return nil }
+
+// address is the address of both ends of a connection to the fake
+type address struct{}
+
+func (address) Network() string { return "dockertest" }
+func (address) String() string  { return "dockertest" }
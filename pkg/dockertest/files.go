@@ -0,0 +1,216 @@
+package dockertest
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// file is a file, directory or symlink in the file system of an image or container
+type file struct {
+	mode    os.FileMode
+	data    []byte // Content of a regular file, or the target of a symlink
+	modTime time.Time
+}
+
+// fileSystem maps cleaned absolute paths to their files. The parent directories of every file are in
+// the map as well, except for the root, which always exists.
+type fileSystem map[string]*file
+
+// clean makes p an absolute path without trailing slash
+func clean(p string) string {
+	return path.Clean("/" + p)
+}
+
+// clone copies the file system, sharing the immutable contents
+func (fs fileSystem) clone() fileSystem {
+	c := make(fileSystem, len(fs))
+	for p, f := range fs {
+		copied := *f
+		c[p] = &copied
+	}
+	return c
+}
+
+// stat returns the file at p, the root being a directory
+func (fs fileSystem) stat(p string) (*file, bool) {
+	p = clean(p)
+	if p == "/" {
+		return &file{mode: os.ModeDir | 0o755}, true
+	}
+	f, ok := fs[p]
+	return f, ok
+}
+
+// mkdirAll creates the directory p and its parents. It fails if one of them is not a directory.
+func (fs fileSystem) mkdirAll(p string, mode os.FileMode) error {
+	p = clean(p)
+	if p == "/" {
+		return nil
+	}
+	if err := fs.mkdirAll(path.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if f, ok := fs[p]; ok {
+		if !f.mode.IsDir() {
+			return fmt.Errorf("%s is not a directory", p)
+		}
+		return nil
+	}
+	fs[p] = &file{mode: os.ModeDir | mode.Perm(), modTime: time.Now()}
+	return nil
+}
+
+// writeFile creates or replaces the regular file p, creating its parent directories
+func (fs fileSystem) writeFile(p string, data []byte, mode os.FileMode) error {
+	p = clean(p)
+	if err := fs.mkdirAll(path.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if f, ok := fs[p]; ok && f.mode.IsDir() {
+		return fmt.Errorf("%s is a directory", p)
+	}
+	fs[p] = &file{mode: mode.Perm(), data: slices.Clone(data), modTime: time.Now()}
+	return nil
+}
+
+// remove removes p and everything below it
+func (fs fileSystem) remove(p string) {
+	p = clean(p)
+	for name := range fs {
+		if name == p || strings.HasPrefix(name, p+"/") || p == "/" {
+			delete(fs, name)
+		}
+	}
+}
+
+// below returns the sorted paths of p and everything below it
+func (fs fileSystem) below(p string) []string {
+	p = clean(p)
+	var paths []string
+	for name := range fs {
+		if name == p || strings.HasPrefix(name, strings.TrimSuffix(p, "/")+"/") {
+			paths = append(paths, name)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
+
+// size is the total size of the regular files
+func (fs fileSystem) size() int64 {
+	var size int64
+	for _, f := range fs {
+		if f.mode.IsRegular() {
+			size += int64(len(f.data))
+		}
+	}
+	return size
+}
+
+// extract unpacks the tar stream r, which may be compressed with gzip or zstd like the daemon accepts,
+// into the existing directory dir
+func (fs fileSystem) extract(dir string, r io.Reader) error {
+	if f, ok := fs.stat(dir); !ok || !f.mode.IsDir() {
+		return fmt.Errorf("could not find the file %s in container", dir)
+	}
+	r, err := decompress(r)
+	if err != nil {
+		return fmt.Errorf("error decompressing tar: %w", err)
+	}
+	reader := tar.NewReader(r)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading tar: %w", err)
+		}
+		target := clean(path.Join(dir, header.Name))
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := fs.mkdirAll(target, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			data, err := io.ReadAll(reader)
+			if err != nil {
+				return fmt.Errorf("error reading %s from tar: %w", header.Name, err)
+			}
+			if err := fs.writeFile(target, data, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := fs.mkdirAll(path.Dir(target), 0o755); err != nil {
+				return err
+			}
+			fs[target] = &file{mode: os.ModeSymlink | 0o777, data: []byte(header.Linkname), modTime: time.Now()}
+		default:
+			return fmt.Errorf("unsupported type %c of %s in tar", header.Typeflag, header.Name)
+		}
+	}
+}
+
+// decompress detects a compressed stream by its magic bytes and returns it decompressed
+func decompress(r io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(4)
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(buffered)
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return zstd.NewReader(buffered)
+	}
+	return buffered, nil
+}
+
+// archive packs p and everything below it into a tar stream, named after the base name of p like the
+// archives of the daemon
+func (fs fileSystem) archive(p string) ([]byte, error) {
+	p = clean(p)
+	var b bytes.Buffer
+	writer := tar.NewWriter(&b)
+	for _, name := range fs.below(p) {
+		f := fs[name]
+		header := &tar.Header{
+			Name:    path.Join(path.Base(p), strings.TrimPrefix(name, p)),
+			Mode:    int64(f.mode.Perm()),
+			ModTime: f.modTime,
+		}
+		switch {
+		case f.mode.IsDir():
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+		case f.mode&os.ModeSymlink != 0:
+			header.Typeflag = tar.TypeSymlink
+			header.Linkname = string(f.data)
+		default:
+			header.Typeflag = tar.TypeReg
+			header.Size = int64(len(f.data))
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg {
+			if _, err := writer.Write(f.data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
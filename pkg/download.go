@@ -0,0 +1,208 @@
+package pkg
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Download is a file fetched over HTTP into the task container before its commands run, instead of a
+// wget command whose result cannot be known without running it. Its checksum is part of the task hash, so
+// the URL may move to a mirror without executing the task again.
+type Download struct {
+	URL         string `json:"url" yaml:"url"`
+	Dest        string `json:"dest" yaml:"dest"`                           // Absolute path of the file in the container
+	SHA256      string `json:"sha256" yaml:"sha256"`                       // Hex checksum the content is verified against
+	Executable  bool   `json:"executable,omitempty" yaml:"executable"`     // Make the file executable, e.g. for tool binaries
+	InContainer bool   `json:"in_container,omitempty" yaml:"in_container"` // Fetch with wget in the container instead of on the host
+}
+
+// hexSHA256 matches the hex checksums of downloads
+var hexSHA256 = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// downloadCache is the directory downloads are cached in, see SetDownloadCache
+var downloadCache string
+
+// SetDownloadCache keeps downloads in dir instead of the user's cache directory. $BUILDVAULT_DOWNLOAD_CACHE
+// takes precedence.
+func SetDownloadCache(dir string) {
+	downloadCache = dir
+}
+
+// downloadCacheDir returns the host directory downloads are kept in by checksum, so every task and run
+// fetching the same file shares one copy
+func downloadCacheDir() (string, error) {
+	if dir := os.Getenv("BUILDVAULT_DOWNLOAD_CACHE"); dir != "" {
+		return dir, nil
+	}
+	if downloadCache != "" {
+		return downloadCache, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("error finding download cache: %w", err)
+	}
+	return filepath.Join(dir, "buildvault", "downloads"), nil
+}
+
+// validateDownloads checks the downloads of t
+func (t *Task) validateDownloads() error {
+	if len(t.Downloads) > 0 && t.Virtual {
+		return configErrorf("virtual task '%s' cannot download files", t.Name)
+	}
+	dests := map[string]bool{}
+	for _, download := range t.Downloads {
+		if download.URL == "" {
+			return configErrorf("download of task '%s' without a url", t.Name)
+		}
+		if !path.IsAbs(download.Dest) {
+			return configErrorf("download %s of task '%s' needs an absolute dest, got '%s'", download.URL, t.Name, download.Dest)
+		}
+		if dests[path.Clean(download.Dest)] {
+			return configErrorf("task '%s' downloads two files to %s", t.Name, download.Dest)
+		}
+		dests[path.Clean(download.Dest)] = true
+		if !hexSHA256.MatchString(download.SHA256) {
+			return configErrorf("download %s of task '%s' needs the hex SHA-256 checksum of its content, got '%s'", download.URL, t.Name, download.SHA256)
+		}
+	}
+	return nil
+}
+
+// fetch returns the host path of the verified content of d, downloading it into the cache first if it
+// is not there yet
+func (d Download) fetch(ctx context.Context) (string, error) {
+	dir, err := downloadCacheDir()
+	if err != nil {
+		return "", err
+	}
+	cached := filepath.Join(dir, d.SHA256)
+	if _, err := os.Stat(cached); err == nil {
+		return cached, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("error creating download cache: %w", err)
+	}
+
+	resp, err := doStallGuarded(ctx, http.DefaultClient, nil, func(ctx context.Context, body io.Reader) (*http.Request, error) {
+		return http.NewRequestWithContext(ctx, http.MethodGet, d.URL, body)
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	// The content only gets its name in the cache once it is verified, so no run sees a partial file
+	tmp, err := os.CreateTemp(dir, "download-*")
+	if err != nil {
+		return "", fmt.Errorf("error creating download file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hasher), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("error downloading: %w", err)
+	}
+	if digest := hex.EncodeToString(hasher.Sum(nil)); digest != d.SHA256 {
+		return "", fmt.Errorf("checksum mismatch, expected %s but got %s", d.SHA256, digest)
+	}
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		return "", fmt.Errorf("error caching download: %w", err)
+	}
+	return cached, nil
+}
+
+// mode returns the file mode of the downloaded file in the container
+func (d Download) mode() int64 {
+	if d.Executable {
+		return 0o755
+	}
+	return 0o644
+}
+
+// downloadFiles provides the downloads of t in its container
+func (t *Task) downloadFiles(ctx context.Context, cli DockerAPI) error {
+	for _, download := range t.Downloads {
+		fmt.Printf("Downloading %s to %s\n", download.URL, download.Dest)
+		var err error
+		if download.InContainer {
+			err = t.downloadInContainer(ctx, cli, download)
+		} else {
+			err = t.copyDownload(ctx, cli, download)
+		}
+		if err != nil {
+			return fmt.Errorf("error downloading %s for task '%s': %w", download.URL, t.Name, err)
+		}
+	}
+	return nil
+}
+
+// copyDownload fetches a download on the host and copies it into the container
+func (t *Task) copyDownload(ctx context.Context, cli DockerAPI, download Download) error {
+	cached, err := download.fetch(ctx)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(cached)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(writer)
+		err := tw.WriteHeader(&tar.Header{Name: path.Base(download.Dest), Mode: download.mode(), Size: info.Size(), ModTime: info.ModTime()})
+		if err == nil {
+			_, err = io.Copy(tw, file)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	defer reader.Close()
+
+	dir := path.Dir(download.Dest)
+	if err := createContainerDir(ctx, cli, t.containerID, dir, t.mkdirCommand()); err != nil {
+		return err
+	}
+	release, err := acquireCopy(ctx, cli)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return cli.CopyToContainer(ctx, t.containerID, dir, reader, container.CopyToContainerOptions{})
+}
+
+// downloadInContainer fetches a download with wget in the container and verifies it with sha256sum, for
+// files the host cannot reach. Both are part of busybox.
+func (t *Task) downloadInContainer(ctx context.Context, cli ExecAPI, download Download) error {
+	if t.noShell {
+		return fmt.Errorf("the image of task '%s' has no shell to download in", t.Name)
+	}
+	script := `mkdir -p "$(dirname "$1")" && wget -q -O "$1" "$2" && echo "$3  $1" | sha256sum -c - >/dev/null && chmod "$4" "$1" || { rm -f "$1"; exit 1; }`
+	mode := fmt.Sprintf("%o", download.mode())
+	_, err := runInContainer(ctx, cli, t.containerID, "", []string{"/bin/sh", "-c", script, "sh", download.Dest, download.URL, download.SHA256, mode})
+	return err
+}
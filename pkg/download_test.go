@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+)
+
+func TestValidateDownloads(t *testing.T) {
+	checksum := strings.Repeat("a", 64)
+	for name, downloads := range map[string][]Download{
+		"no url":        {{Dest: "/bin/tool", SHA256: checksum}},
+		"relative dest": {{URL: "https://example.com/tool", Dest: "tool", SHA256: checksum}},
+		"no checksum":   {{URL: "https://example.com/tool", Dest: "/bin/tool"}},
+		"checksum":      {{URL: "https://example.com/tool", Dest: "/bin/tool", SHA256: "sha256:" + checksum}},
+		"same dest":     {{URL: "https://example.com/a", Dest: "/bin/tool", SHA256: checksum}, {URL: "https://example.com/b", Dest: "/bin/tool", SHA256: checksum}},
+	} {
+		task := &Task{Name: "build", BaseImage: "alpine", Downloads: downloads}
+		var configErr *ConfigError
+		if err := task.validateDownloads(); !errors.As(err, &configErr) {
+			t.Errorf("%s: expected a configuration error, got %v", name, err)
+		}
+	}
+}
+
+func TestDownloadsHash(t *testing.T) {
+	task := &Task{Name: "build", BaseImage: "alpine", Downloads: []Download{{URL: "https://example.com/tool", Dest: "/bin/tool", SHA256: strings.Repeat("a", 64)}}}
+	first := task.generateHash()
+
+	task.Downloads[0].URL = "https://mirror.example.com/tool"
+	if task.generateHash() != first {
+		t.Errorf("Moving a download to another URL should not change the task hash")
+	}
+	task.Downloads[0].SHA256 = strings.Repeat("b", 64)
+	if task.generateHash() == first {
+		t.Errorf("A new checksum should change the task hash")
+	}
+}
+
+func TestExecuteDownloads(t *testing.T) {
+	t.Setenv("BUILDVAULT_DOWNLOAD_CACHE", t.TempDir())
+	var requests atomic.Int32
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Write([]byte("tool content"))
+	}))
+	defer files.Close()
+	sum := sha256.Sum256([]byte("tool content"))
+	checksum := hex.EncodeToString(sum[:])
+
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:latest"}, Files: map[string]string{"/bin/sh": ""}})
+	for _, name := range []string{"first", "second"} {
+		task := &Task{Name: name, BaseImage: "alpine", Commands: []string{"tool"}, Downloads: []Download{{URL: files.URL + "/tool", Dest: "/usr/local/bin/tool", SHA256: checksum, Executable: true}}}
+		if err := task.Execute(context.Background(), cli); err != nil {
+			t.Fatalf("Failed to execute task: %v", err)
+		}
+		c, ok := cli.Container(task.generateContainerName())
+		if !ok {
+			t.Fatalf("No container of task %s", name)
+		}
+		if data, err := c.ReadFile("/usr/local/bin/tool"); err != nil || string(data) != "tool content" {
+			t.Errorf("Unexpected downloaded file %q: %v", data, err)
+		}
+	}
+	if requests.Load() != 1 {
+		t.Errorf("Expected the second task to use the cached download, got %d requests", requests.Load())
+	}
+
+	task := &Task{Name: "tampered", BaseImage: "alpine", Commands: []string{"tool"}, Downloads: []Download{{URL: files.URL + "/tool", Dest: "/tool", SHA256: strings.Repeat("a", 64)}}}
+	if err := task.Execute(context.Background(), cli); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+}
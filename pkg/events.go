@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Types of events
+const (
+	EventTaskStarted    = "task_started"    // The task began its own work, after its dependencies
+	EventCommandOutput  = "command_output"  // A line of output of a command of the task
+	EventArtifactCopied = "artifact_copied" // An artifact of a dependency was copied into the task container
+	EventTaskFinished   = "task_finished"   // The task completed, failed or was skipped
+	EventStatus         = "status"          // A progress line about the task, like the artifacts it copies
+)
+
+// Event is a structured progress event of a run, for CI systems and wrappers that cannot parse the
+// human readable output.
+type Event struct {
+	Type     string        `json:"type"`
+	Time     time.Time     `json:"time"`
+	Task     string        `json:"task"`
+	Stream   string        `json:"stream,omitempty"`      // command_output: stdout or stderr
+	Line     string        `json:"line,omitempty"`        // command_output and status: the line without its newline
+	From     string        `json:"from,omitempty"`        // artifact_copied: path in the dependency
+	To       string        `json:"to,omitempty"`          // artifact_copied: path in the task container
+	Source   string        `json:"source,omitempty"`      // artifact_copied: name of the dependency
+	Bytes    int64         `json:"bytes,omitempty"`       // artifact_copied: size of the copied archive
+	Status   string        `json:"status,omitempty"`      // task_finished: report status, like StatusExecuted
+	Duration time.Duration `json:"duration_ns,omitempty"` // task_finished: time the task's own work took
+	Error    string        `json:"error,omitempty"`       // task_finished: why the task failed
+}
+
+// EventSink receives the events of tasks. It is called from the goroutines executing tasks, so
+// implementations must be safe for concurrent use.
+type EventSink interface {
+	Event(event Event)
+}
+
+// JSONEventSink writes events as newline delimited JSON.
+type JSONEventSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONEventSink creates a sink writing one JSON object per line to w.
+func NewJSONEventSink(w io.Writer) *JSONEventSink {
+	return &JSONEventSink{enc: json.NewEncoder(w)}
+}
+
+func (s *JSONEventSink) Event(event Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// There is nobody to report a failing event stream to, the run goes on without it
+	s.enc.Encode(event)
+}
+
+// emit sends event about t to its event sink, if it has one
+func (t *Task) emit(event Event) {
+	if t.Events == nil {
+		return
+	}
+	event.Task = t.Name
+	event.Time = time.Now().UTC()
+	t.Events.Event(event)
+}
+
+// emitFinished reports the outcome of t, unless it never got to its own work
+func (t *Task) emitFinished(err error) {
+	if t.started.IsZero() && !t.skipped {
+		return
+	}
+	event := Event{Type: EventTaskFinished, Status: t.status(), Duration: t.duration}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	t.emit(event)
+}
+
+// eventWriter turns the command output written to it into command_output events, one per line
+type eventWriter struct {
+	task   *Task
+	stream string
+	mu     sync.Mutex
+	buf    bytes.Buffer
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		end := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if end < 0 {
+			return len(p), nil
+		}
+		line := w.buf.Next(end + 1)
+		w.task.emit(Event{Type: EventCommandOutput, Stream: w.stream, Line: string(bytes.TrimSuffix(line[:end], []byte("\r")))})
+	}
+}
+
+// flush emits a last line that did not end with a newline
+func (w *eventWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() > 0 {
+		w.task.emit(Event{Type: EventCommandOutput, Stream: w.stream, Line: w.buf.String()})
+		w.buf.Reset()
+	}
+}
@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// recordingSink keeps the events it receives
+type recordingSink struct {
+	events []Event
+}
+
+func (s *recordingSink) Event(event Event) {
+	s.events = append(s.events, event)
+}
+
+func TestJSONEventSinkWritesLines(t *testing.T) {
+	var out bytes.Buffer
+	sink := NewJSONEventSink(&out)
+	sink.Event(Event{Type: EventTaskStarted, Task: "build"})
+	sink.Event(Event{Type: EventCommandOutput, Task: "build", Stream: "stdout", Line: "ok"})
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", out.String())
+	}
+	var event Event
+	if err := json.Unmarshal([]byte(lines[1]), &event); err != nil {
+		t.Fatalf("Invalid JSON line %q: %v", lines[1], err)
+	}
+	if event.Type != EventCommandOutput || event.Line != "ok" || event.Stream != "stdout" {
+		t.Errorf("Unexpected event %+v", event)
+	}
+	if strings.Contains(lines[0], "line") || strings.Contains(lines[0], "error") {
+		t.Errorf("Expected empty fields to be omitted, got %s", lines[0])
+	}
+}
+
+func TestEventWriterEmitsLines(t *testing.T) {
+	sink := &recordingSink{}
+	w := &eventWriter{task: &Task{Name: "build", Events: sink}, stream: "stderr"}
+	w.Write([]byte("first\r\nsec"))
+	w.Write([]byte("ond\nthird"))
+	if len(sink.events) != 2 {
+		t.Fatalf("Expected 2 complete lines, got %+v", sink.events)
+	}
+	w.flush()
+
+	var lines []string
+	for _, event := range sink.events {
+		if event.Task != "build" || event.Stream != "stderr" || event.Type != EventCommandOutput {
+			t.Errorf("Unexpected event %+v", event)
+		}
+		lines = append(lines, event.Line)
+	}
+	if strings.Join(lines, "|") != "first|second|third" {
+		t.Errorf("Unexpected lines %q", lines)
+	}
+}
+
+func TestSkippedTaskEmitsFinished(t *testing.T) {
+	sink := &recordingSink{}
+	task := &Task{Name: "publish", BaseImage: "alpine", Events: sink, When: func(env ConditionEnv) bool { return false }}
+	if err := task.Execute(context.Background(), nil, WithConditionEnv(ConditionEnv{})); err != nil {
+		t.Fatalf("Skipping failed: %v", err)
+	}
+	if len(sink.events) != 2 || sink.events[0].Type != EventStatus || sink.events[1].Type != EventTaskFinished || sink.events[1].Status != StatusSkipped {
+		t.Errorf("Expected a status line and a task_finished event with status skipped, got %+v", sink.events)
+	}
+}
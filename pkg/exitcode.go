@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// Exit codes of the buildvault command by kind of failure, so scripts can branch on them
+const (
+	ExitTaskFailure = 1 // A command of a task failed, or the run failed for a reason not listed below
+	ExitConfigError = 2 // The pipeline file or the tasks it defines are invalid
+	ExitInfraError  = 3 // The Docker daemon could not be reached or failed itself
+)
+
+// ConfigError is an error in the definition of a pipeline or its tasks, as opposed to a failure while
+// executing them.
+type ConfigError struct {
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// configErrorf formats a ConfigError
+func configErrorf(format string, args ...any) error {
+	return &ConfigError{Err: fmt.Errorf(format, args...)}
+}
+
+// CommandError is returned when a command of a task exits with a non-zero code.
+type CommandError struct {
+	Task     string
+	Command  string // The failing command, empty for scripts
+	ExitCode int
+}
+
+func (e *CommandError) Error() string {
+	if e.Command == "" {
+		return fmt.Sprintf("script of task '%s' failed with exit code %d", e.Task, e.ExitCode)
+	}
+	return fmt.Sprintf("command '%s' failed with exit code %d", e.Command, e.ExitCode)
+}
+
+// ExitCode returns the exit code the buildvault command exits with after err, 0 for nil.
+func ExitCode(err error) int {
+	var commandErr *CommandError
+	var configErr *ConfigError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &commandErr):
+		return ExitTaskFailure
+	case errors.As(err, &configErr):
+		return ExitConfigError
+	case client.IsErrConnectionFailed(err), errdefs.IsUnavailable(err), errdefs.IsSystem(err), errors.As(err, &netErr):
+		return ExitInfraError
+	}
+	return ExitTaskFailure
+}
+
+// CommandResult is the outcome of a command of a task.
+type CommandResult struct {
+	Command  string        // The command, or "script" for scripts
+	ExitCode int           // Exit code of the command
+	Duration time.Duration // Time the command took
+	Stdout   string        // Standard output of the command if the task captures it, see Task.CaptureOutput
+}
+
+// TaskResult is the outcome of a task after Execute.
+type TaskResult struct {
+	Name     string
+	Status   string          // Report status, like StatusExecuted
+	Duration time.Duration   // Time the task's own work took
+	Commands []CommandResult // Commands that ran, in order, the last one failing if a command failed
+}
+
+// Result returns the outcome of the last execution of t.
+func (t *Task) Result() TaskResult {
+	return TaskResult{Name: t.Name, Status: t.status(), Duration: t.duration, Commands: t.commandResults}
+}
+
+// ExitCode returns the exit code of the failing command, or 0 if no command failed.
+func (r TaskResult) ExitCode() int {
+	if len(r.Commands) == 0 {
+		return 0
+	}
+	return r.Commands[len(r.Commands)-1].ExitCode
+}
+
+// recordCommand adds the outcome of a command to the result of t, with its stdout if it was captured
+func (t *Task) recordCommand(command string, exitCode int, duration time.Duration, captured *bytes.Buffer) {
+	result := CommandResult{Command: command, ExitCode: exitCode, Duration: duration}
+	if captured != nil {
+		result.Stdout = captured.String()
+	}
+	t.commandResults = append(t.commandResults, result)
+}
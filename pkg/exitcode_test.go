@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/client"
+)
+
+func TestExitCode(t *testing.T) {
+	_, loadErr := LoadPipeline(filepath.Join(t.TempDir(), "missing.yaml"))
+	_, targetErr := (&Pipeline{}).Target("build")
+	commandErr := fmt.Errorf("error executing task 'build': %w", &CommandError{Task: "build", Command: "make", ExitCode: 2})
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, 0},
+		{"failing command", commandErr, ExitTaskFailure},
+		{"missing pipeline file", loadErr, ExitConfigError},
+		{"unknown target", targetErr, ExitConfigError},
+		{"unreachable daemon", fmt.Errorf("error pulling image: %w", client.ErrorConnectionFailed("unix:///var/run/docker.sock")), ExitInfraError},
+		{"other failure", errors.New("output /out/app is missing"), ExitTaskFailure},
+	}
+	for _, tt := range tests {
+		if got := ExitCode(tt.err); got != tt.want {
+			t.Errorf("%s: expected exit code %d, got %d", tt.name, tt.want, got)
+		}
+	}
+	if commandErr.Error() != "error executing task 'build': command 'make' failed with exit code 2" {
+		t.Errorf("Unexpected message %q", commandErr)
+	}
+}
+
+func TestTaskResultExitCode(t *testing.T) {
+	task := &Task{Name: "build"}
+	if code := task.Result().ExitCode(); code != 0 {
+		t.Errorf("Expected 0 without commands, got %d", code)
+	}
+	task.recordCommand("go vet ./...", 0, 0, nil)
+	task.recordCommand("go test ./...", 1, 0, nil)
+	result := task.Result()
+	if len(result.Commands) != 2 || result.ExitCode() != 1 || result.Commands[1].Command != "go test ./..." {
+		t.Errorf("Unexpected result %+v", result)
+	}
+}
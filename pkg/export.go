@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Export copies an artifact of a task to a host path once the task has run (or was restored from the
+// artifact store).
+type Export struct {
+	From string `json:"from" yaml:"from"` // Path in the task container
+	To   string `json:"to" yaml:"to"`     // Host path, replaced as a whole on every export
+}
+
+// exportArtifacts writes all exports of t to the host
+func (t *Task) exportArtifacts(ctx context.Context, cli DockerAPI) error {
+	for _, export := range t.Exports {
+		fmt.Printf("  Exporting %s of task '%s' to %s\n", export.From, t.Name, export.To)
+		if err := t.exportArtifact(ctx, cli, export); err != nil {
+			return fmt.Errorf("error exporting %s of task '%s' to %s: %w", export.From, t.Name, export.To, err)
+		}
+	}
+	return nil
+}
+
+// exportArtifact extracts an artifact into a temporary directory next to the destination and swaps it
+// in on success, so host tooling never sees a half-written tree. An interrupted export leaves the
+// previous tree in place and only a hidden temporary directory behind.
+func (t *Task) exportArtifact(ctx context.Context, cli DockerAPI, export Export) error {
+	to := filepath.Clean(export.To)
+	if err := os.MkdirAll(filepath.Dir(to), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(to), ".buildvault-export-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	release, err := acquireCopy(ctx, cli)
+	if err != nil {
+		return err
+	}
+	reader, err := openDependencyArtifact(ctx, cli, t, export.From)
+	if err != nil {
+		release()
+		return err
+	}
+	err = extractTar(reader, tmp)
+	reader.Close()
+	release()
+	if err != nil {
+		return err
+	}
+
+	extracted := filepath.Join(tmp, path.Base(export.From))
+	if _, err := os.Lstat(extracted); err != nil {
+		return fmt.Errorf("artifact archive does not contain %s", path.Base(export.From))
+	}
+	return swapPath(extracted, to, filepath.Join(tmp, "previous"))
+}
+
+// swapPath moves src to dst. An existing dst is moved to backup first and put back if src cannot be
+// moved, both renames stay on the same filesystem.
+func swapPath(src, dst, backup string) error {
+	if _, err := os.Lstat(dst); err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return os.Rename(src, dst)
+	}
+
+	if err := os.Rename(dst, backup); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		if restoreErr := os.Rename(backup, dst); restoreErr != nil {
+			return fmt.Errorf("%w (previous content remains at %s: %v)", err, backup, restoreErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// extractTar writes the regular files, directories and links of a tar archive below dir, which must be
+// empty. Entries escaping dir, directly or through a symlink of the archive, are rejected.
+func extractTar(r io.Reader, dir string) error {
+	symlinks := map[string]bool{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading artifact archive: %w", err)
+		}
+
+		name := path.Clean(header.Name)
+		if !insideArchiveRoot(name, symlinks) || symlinks[name] {
+			return fmt.Errorf("archive entry %s escapes the export directory", header.Name)
+		}
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		mode := os.FileMode(header.Mode).Perm()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+			if err := os.Chmod(target, mode|0o700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(file, tr)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return fmt.Errorf("error writing %s: %w", target, err)
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+			symlinks[name] = true
+		case tar.TypeLink:
+			linkName := path.Clean(header.Linkname)
+			if !insideArchiveRoot(linkName, symlinks) || symlinks[linkName] {
+				return fmt.Errorf("archive entry %s links outside the export directory", header.Name)
+			}
+			if err := os.Link(filepath.Join(dir, filepath.FromSlash(linkName)), target); err != nil {
+				return err
+			}
+		default:
+			// Devices, fifos and the like have no place in build artifacts
+			fmt.Printf("  Skipping special file %s\n", header.Name)
+		}
+	}
+}
+
+// insideArchiveRoot reports whether the cleaned archive path name stays below the extraction directory,
+// neither through ".." nor through a parent that is a symlink extracted before
+func insideArchiveRoot(name string, symlinks map[string]bool) bool {
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return false
+	}
+	for parent := path.Dir(name); parent != "."; parent = path.Dir(parent) {
+		if symlinks[parent] {
+			return false
+		}
+	}
+	return true
+}
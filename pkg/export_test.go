@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractTar(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "dist/", Mode: 0o755, Typeflag: tar.TypeDir})
+	tw.WriteHeader(&tar.Header{Name: "dist/app.js", Mode: 0o644, Size: 5, Typeflag: tar.TypeReg})
+	tw.Write([]byte("hello"))
+	tw.WriteHeader(&tar.Header{Name: "dist/latest.js", Linkname: "app.js", Typeflag: tar.TypeSymlink})
+	tw.Close()
+
+	dir := t.TempDir()
+	if err := extractTar(&buf, dir); err != nil {
+		t.Fatalf("Failed to extract archive: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "dist", "latest.js")); err != nil || string(data) != "hello" {
+		t.Errorf("Unexpected extracted content %q (%v)", data, err)
+	}
+
+	escaping := func(headers ...*tar.Header) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, header := range headers {
+			tw.WriteHeader(header)
+		}
+		tw.Close()
+		return buf.Bytes()
+	}
+	for name, archive := range map[string][]byte{
+		"parent":  escaping(&tar.Header{Name: "../evil", Typeflag: tar.TypeReg}),
+		"symlink": escaping(&tar.Header{Name: "out", Linkname: "/etc", Typeflag: tar.TypeSymlink}, &tar.Header{Name: "out/passwd", Typeflag: tar.TypeReg}),
+		"link":    escaping(&tar.Header{Name: "passwd", Linkname: "../../etc/passwd", Typeflag: tar.TypeLink}),
+	} {
+		if err := extractTar(bytes.NewReader(archive), t.TempDir()); err == nil {
+			t.Errorf("Expected an error for the %s archive", name)
+		}
+	}
+}
+
+func TestSwapPath(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "dist")
+	os.MkdirAll(dst, 0o755)
+	os.WriteFile(filepath.Join(dst, "old.js"), []byte("old"), 0o644)
+
+	src := filepath.Join(dir, ".tmp", "dist")
+	os.MkdirAll(src, 0o755)
+	os.WriteFile(filepath.Join(src, "new.js"), []byte("new"), 0o644)
+
+	if err := swapPath(src, dst, filepath.Join(dir, ".tmp", "previous")); err != nil {
+		t.Fatalf("Failed to swap: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "new.js")); err != nil {
+		t.Errorf("The new tree should be in place: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "old.js")); err == nil {
+		t.Errorf("The previous tree should be replaced as a whole")
+	}
+
+	// A failed swap keeps the previous tree
+	if err := swapPath(filepath.Join(dir, "missing"), dst, filepath.Join(dir, ".tmp", "previous2")); err == nil {
+		t.Fatalf("Expected an error for a missing source")
+	}
+	if _, err := os.Stat(filepath.Join(dst, "new.js")); err != nil {
+		t.Errorf("The previous tree should be restored after a failed swap: %v", err)
+	}
+}
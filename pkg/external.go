@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/errdefs"
+)
+
+// External tasks stand for existing containers that buildvault does not manage, e.g. ones created by
+// legacy build scripts during an incremental migration. They have no image and no commands: dependents
+// copy artifacts straight from the container, which buildvault never starts, stops or removes.
+
+// ExternalContainer returns a task standing for the existing container with the given name or ID, to be
+// used as a dependency.
+func ExternalContainer(ref string) *Task {
+	return &Task{Name: ref, Container: ref}
+}
+
+// validateExternal checks that an external task declares nothing that would require running it
+func (t *Task) validateExternal() error {
+	if t.BaseImage != "" || t.Build != nil || len(t.Commands) > 0 || len(t.Cmd) > 0 || t.Script != "" || len(t.Dependencies) > 0 || len(t.HashInputs) > 0 || t.Virtual {
+		return fmt.Errorf("task '%s' refers to the existing container '%s' and cannot have an image, commands or dependencies", t.Name, t.Container)
+	}
+	return nil
+}
+
+// adoptContainer resolves the container of an external task. Its ID is part of the hash, so dependents
+// are invalidated when the legacy build recreates the container.
+func (t *Task) adoptContainer(ctx context.Context, cli ContainerAPI) error {
+	info, err := cli.ContainerInspect(ctx, t.Container)
+	if errdefs.IsNotFound(err) {
+		return fmt.Errorf("container '%s' of task '%s' does not exist", t.Container, t.Name)
+	}
+	if err != nil {
+		return fmt.Errorf("error inspecting container '%s': %w", t.Container, err)
+	}
+
+	t.containerID = info.ID
+	fmt.Printf("Task: %s (existing container %s)\n", t.Name, info.ID[:12])
+	return nil
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+)
+
+func TestParsePipelineContainerDependency(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+tasks:
+  - name: package
+    image: alpine
+    dependencies:
+      - container: legacy-build
+        artifacts:
+          - from: /src/target/app.jar
+            to: /in/app.jar
+  - name: test
+    image: alpine
+    dependencies:
+      - container: legacy-build
+        artifacts:
+          - from: /src/target/test-classes
+            to: /in/classes
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+	if len(pipeline.Tasks) != 2 {
+		t.Errorf("External containers should not be pipeline tasks, got %d tasks", len(pipeline.Tasks))
+	}
+
+	packageTask, _ := pipeline.Task("package")
+	testTask, _ := pipeline.Task("test")
+	external := packageTask.Dependencies[0].Task
+	if external.Container != "legacy-build" || testTask.Dependencies[0].Task != external {
+		t.Errorf("Dependencies on the same container should share one external task")
+	}
+
+	if _, err := ParsePipeline([]byte("tasks:\n  - name: a\n    image: alpine\n    dependencies:\n      - task: a\n        container: legacy\n")); err == nil {
+		t.Errorf("Expected an error for a dependency on both a task and a container")
+	}
+}
+
+func TestExternalContainerTask(t *testing.T) {
+	external := ExternalContainer("legacy-build")
+	if err := external.validateExternal(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	external.Commands = []string{"make"}
+	if err := external.validateExternal(); err == nil {
+		t.Errorf("External tasks cannot have commands")
+	}
+	external.Commands = nil
+
+	consumer := &Task{Name: "package", BaseImage: "alpine", Dependencies: []Dependency{{Task: external, Artifacts: []Artifact{{From: "/out", To: "/in"}}}}}
+	external.containerID = "aaaa"
+	before := consumer.generateHash()
+	external.containerID = "bbbb"
+	if consumer.generateHash() == before {
+		t.Errorf("A recreated external container should invalidate dependents")
+	}
+
+	steps, err := consumer.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	if len(steps) != 2 || !steps[0].External {
+		t.Errorf("Expected the external container as first plan step, got %+v", steps)
+	}
+}
@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Tasks fetching external data, like package indexes or vulnerability databases, cannot hash everything
+// their result depends on. A freshness limit makes their stored results stale after a while, so they
+// execute again with an identical hash. The hashes of their dependents include the content digests of
+// the stored outputs instead, see recordStoredOutputs, so dependents only execute again if a refresh
+// changed the outputs.
+
+// fresh reports whether the stored result described by manifest is still fresh at now
+func (t *Task) fresh(manifest *StoreManifest, now time.Time) bool {
+	return t.Freshness <= 0 || now.Sub(manifest.Created) < t.Freshness
+}
+
+// staleReason explains why the stored result described by manifest is not used
+func (t *Task) staleReason(manifest *StoreManifest, now time.Time) string {
+	return fmt.Sprintf("its stored outputs are %s old, older than its freshness limit of %s",
+		now.Sub(manifest.Created).Round(time.Second), t.Freshness)
+}
+
+// lookupStored reports whether the artifact store holds a fresh result of t. The blobs of a remote
+// result are only downloaded once it is known to be fresh.
+func (t *Task) lookupStored(ctx context.Context) (bool, error) {
+	now := time.Now()
+	manifest, stored, err := t.ArtifactStore.lookup(ctx, t.generateHash(), func(manifest *StoreManifest) bool {
+		return t.fresh(manifest, now)
+	}, statusWriter{t})
+	if err != nil || !stored {
+		return false, err
+	}
+	if !t.fresh(manifest, now) {
+		t.statusf("Task '%s' executes again, %s\n", t.Name, t.staleReason(manifest, now))
+		return false, nil
+	}
+	t.recordStoredOutputs(manifest)
+	return true, nil
+}
+
+// recordStoredOutputs remembers the outputs of the stored result of a task with a freshness limit for
+// the hashes of its dependents. Without declared outputs nothing can be compared, so every refresh
+// counts as a change.
+func (t *Task) recordStoredOutputs(manifest *StoreManifest) {
+	if t.Freshness <= 0 {
+		return
+	}
+	if len(manifest.Artifacts) == 0 {
+		t.storedOutputs = manifest.Created.UTC().Format(time.RFC3339Nano)
+		return
+	}
+	var outputs strings.Builder
+	for _, artifact := range manifest.Artifacts {
+		digest := artifact.ContentDigest
+		if digest == "" {
+			digest = artifact.Digest
+		}
+		fmt.Fprintf(&outputs, "%s\x00%s\x00", artifact.Path, digest)
+	}
+	t.storedOutputs = outputs.String()
+}
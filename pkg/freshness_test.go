@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+)
+
+func TestFresh(t *testing.T) {
+	now := time.Now()
+	manifest := &StoreManifest{Created: now.Add(-25 * time.Hour)}
+	if !(&Task{Name: "index"}).fresh(manifest, now) {
+		t.Errorf("Results without a freshness limit should never be stale")
+	}
+	if (&Task{Name: "index", Freshness: 24 * time.Hour}).fresh(manifest, now) {
+		t.Errorf("A 25h old result should be stale with a freshness of 24h")
+	}
+	if !(&Task{Name: "index", Freshness: 48 * time.Hour}).fresh(manifest, now) {
+		t.Errorf("A 25h old result should be fresh with a freshness of 48h")
+	}
+}
+
+func TestPlanStaleTask(t *testing.T) {
+	store, err := NewArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &Task{Name: "fetch-index", BaseImage: "alpine", Outputs: []string{"/index"}, ArtifactStore: store, Freshness: time.Hour}
+	err = store.writeManifest(&StoreManifest{Task: task.Name, Hash: task.generateHash(), Created: time.Now().Add(-2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err := task.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	if steps[0].CacheHit || !strings.Contains(steps[0].Reason, "freshness") {
+		t.Errorf("Expected the stale task to execute, got %+v", steps[0])
+	}
+}
+
+func TestParsePipelineFreshness(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+tasks:
+  - name: fetch-index
+    image: alpine
+    freshness: 24h
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+	if pipeline.Tasks[0].Freshness != 24*time.Hour {
+		t.Errorf("Unexpected freshness %s", pipeline.Tasks[0].Freshness)
+	}
+}
+
+// ageManifest makes the stored result of task older than its freshness limit
+func ageManifest(t *testing.T, store *ArtifactStore, task *Task) {
+	manifest, ok, err := store.Lookup(context.Background(), task.generateHash())
+	if err != nil || !ok {
+		t.Fatalf("Expected a stored result of '%s', got ok=%v err=%v", task.Name, ok, err)
+	}
+	manifest.Created = manifest.Created.Add(-2 * task.Freshness)
+	if err := store.writeManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefreshExecutesDependents(t *testing.T) {
+	store, err := NewArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := "v1"
+	executed := map[string]int{}
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:3.20"}, Files: map[string]string{"/bin/sh": ""}})
+	cli.Exec = func(e *dockertest.Exec) int {
+		switch command := e.Cmd[len(e.Cmd)-1]; {
+		case command == "fetch" || command == "search":
+			executed[command]++
+			if command == "fetch" {
+				e.Container.WriteFile("/index", []byte(index), 0o644)
+			}
+		case strings.Contains(command, "sha256sum"):
+			data, _ := e.Container.ReadFile("/index")
+			fmt.Fprintf(e.Stdout, "%x  index\n", sha256.Sum256(data))
+		case e.Cmd[0] == "stat":
+			fmt.Fprintf(e.Stdout, "%d\n", len(index))
+		}
+		return dockertest.Builtins(e)
+	}
+
+	fetch := &Task{Name: "fetch-index", BaseImage: "alpine:3.20", Commands: []string{"fetch"}, Outputs: []string{"/index"}, ArtifactStore: store, Freshness: time.Hour}
+	newSearch := func() *Task {
+		// A new run starts from a fresh task graph
+		fetch = &Task{Name: fetch.Name, BaseImage: fetch.BaseImage, Commands: fetch.Commands, Outputs: fetch.Outputs, ArtifactStore: store, Freshness: fetch.Freshness}
+		return &Task{Name: "search", BaseImage: "alpine:3.20", Commands: []string{"search"}, ArtifactStore: store,
+			Dependencies: []Dependency{{Task: fetch, Artifacts: []Artifact{{From: "/index", To: "/data/index"}}}}}
+	}
+	run := func(want map[string]int) {
+		t.Helper()
+		if err := newSearch().Execute(context.Background(), cli); err != nil {
+			t.Fatalf("Failed to execute: %v", err)
+		}
+		if executed["fetch"] != want["fetch"] || executed["search"] != want["search"] {
+			t.Errorf("Expected executions %v, got %v", want, executed)
+		}
+	}
+
+	run(map[string]int{"fetch": 1, "search": 1})
+	run(map[string]int{"fetch": 1, "search": 1})
+
+	// A refresh with new data executes the dependent again
+	ageManifest(t, store, fetch)
+	index = "v2"
+	run(map[string]int{"fetch": 2, "search": 2})
+
+	// A refresh with the same data does not
+	ageManifest(t, store, fetch)
+	run(map[string]int{"fetch": 3, "search": 2})
+}
+
+func TestStaleRemoteResultNotDownloaded(t *testing.T) {
+	server, _ := memoryCacheServer(t)
+	backend, err := NewRemoteBackend(server.URL + "/cache")
+	if err != nil {
+		t.Fatalf("Failed to create backend: %v", err)
+	}
+	ctx := context.Background()
+	task := &Task{Name: "fetch-index", BaseImage: "alpine", Outputs: []string{"/index"}, Freshness: time.Hour}
+
+	storeA, _ := NewArtifactStore(t.TempDir())
+	storeA.SetRemote(backend, RemoteReadWrite)
+	digest, size, err := storeA.putBlob(strings.NewReader("index"))
+	if err != nil {
+		t.Fatalf("Failed to put blob: %v", err)
+	}
+	manifest := &StoreManifest{Task: task.Name, Hash: task.generateHash(), Created: time.Now().Add(-2 * time.Hour),
+		Artifacts: []StoredArtifact{{Path: "/index", Digest: digest, Size: size}}}
+	if err := storeA.pushRemote(ctx, manifest, io.Discard); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+
+	storeB, _ := NewArtifactStore(t.TempDir())
+	storeB.SetRemote(backend, RemoteReadOnly)
+	task.ArtifactStore = storeB
+	if stored, err := task.lookupStored(ctx); err != nil || stored {
+		t.Fatalf("Expected the stale remote result not to be used, got stored=%v err=%v", stored, err)
+	}
+	if _, err := os.Stat(storeB.blobPath(digest)); err == nil {
+		t.Error("Expected the blobs of the stale remote result not to be downloaded")
+	}
+
+	// A fresh one is
+	manifest.Created = time.Now()
+	if err := storeA.pushRemote(ctx, manifest, io.Discard); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	if stored, err := task.lookupStored(ctx); err != nil || !stored {
+		t.Fatalf("Expected the fresh remote result to be used, got stored=%v err=%v", stored, err)
+	}
+	if _, err := os.Stat(storeB.blobPath(digest)); err != nil {
+		t.Errorf("Expected the blobs of the fresh remote result to be downloaded: %v", err)
+	}
+}
@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Every change to a task, its image or its inputs gives it a new hash and with it a new container, so
+// preserved containers pile up. Garbage collection removes those of earlier definitions of the tasks of
+// a run before it starts. Only containers named after tasks of the run are considered, other pipelines
+// may share the Docker daemon.
+
+// CollectGarbage removes the containers of tasks of the graph of p whose hash no longer matches the
+// task, and returns them. Containers of tasks whose hash is only known during execution, because of
+// Dockerfile builds, existing containers or artifact hash inputs, are kept unless they were created for
+// an older HashVersion. Running containers are always kept.
+func (p *Pipeline) CollectGarbage(ctx context.Context, cli ContainerAPI) ([]PrunedContainer, error) {
+	tasks, err := p.TopoSort()
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		if err := task.resolveVars(); err != nil {
+			return nil, err
+		}
+		if err := task.resolveOutputRefs(); err != nil {
+			return nil, err
+		}
+	}
+	if err := ResolveHostInputs(tasks); err != nil {
+		return nil, err
+	}
+
+	containers, err := listBuildvaultContainers(ctx, cli, false)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, task := range tasks {
+		names[task.Name] = true
+	}
+	orphans := selectOrphans(containers, currentHashes(tasks), names)
+	for _, orphan := range orphans {
+		fmt.Printf("Removing outdated container %s (task '%s')\n", orphan.Name, orphan.TaskName)
+		if err := cli.ContainerRemove(ctx, orphan.ID, container.RemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
+			return nil, fmt.Errorf("error removing container %s: %w", orphan.Name, err)
+		}
+	}
+	return orphans, nil
+}
+
+// currentHashes returns the hashes of tasks, given dependencies first, that are known before execution
+func currentHashes(tasks []*Task) map[string]string {
+	hashes := map[string]string{}
+	known := map[*Task]bool{}
+	for _, task := range tasks {
+		known[task] = task.hashKnownBeforeExecution(known)
+		if known[task] {
+			hashes[task.Name] = task.generateHash()
+		}
+	}
+	return hashes
+}
+
+// hashKnownBeforeExecution reports whether the hash of t can be computed before it executes, given
+// which of its dependencies' hashes are known
+func (t *Task) hashKnownBeforeExecution(known map[*Task]bool) bool {
+	if t.Container != "" || (t.Build != nil && t.imageID == "") {
+		return false
+	}
+	for _, input := range t.HashInputs {
+		if _, _, ok := t.artifactInputSource(input); ok {
+			return false
+		}
+	}
+	for _, dependency := range t.Dependencies {
+		if !known[dependency.Task] {
+			return false
+		}
+	}
+	return true
+}
+
+// selectOrphans returns the containers of tasks in hashes whose hash differs from the current one, and
+// those of the tasks in names created for an older hash version
+func selectOrphans(containers []container.Summary, hashes map[string]string, names map[string]bool) []PrunedContainer {
+	var orphans []PrunedContainer
+	for _, c := range containers {
+		if len(c.Names) == 0 || c.State == "running" {
+			continue
+		}
+		name := strings.TrimPrefix(c.Names[0], "/")
+		taskName, hash, ok := taskContainer(c)
+		if !ok {
+			continue
+		}
+		current, ok := hashes[taskName]
+		outdated := names[taskName] && containerHashVersion(c) < HashVersion
+		if !outdated && (!ok || hash == current) {
+			continue
+		}
+		orphans = append(orphans, PrunedContainer{ID: c.ID, Name: name, TaskName: taskName, Hash: hash, Created: time.Unix(c.Created, 0)})
+	}
+	return orphans
+}
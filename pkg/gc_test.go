@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestCurrentHashes(t *testing.T) {
+	deps := &Task{Name: "deps", BaseImage: "golang"}
+	built := &Task{Name: "built", Build: &ImageBuild{Context: "."}}
+	build := &Task{Name: "build", BaseImage: "golang", Dependencies: []Dependency{{Task: deps}}}
+	image := &Task{Name: "image", BaseImage: "alpine", Dependencies: []Dependency{{Task: built}}}
+	tagged := &Task{Name: "tagged", BaseImage: "alpine", HashInputs: []string{"/in/app"},
+		Dependencies: []Dependency{{Task: build, Artifacts: []Artifact{{From: "/out/app", To: "/in/app"}}}}}
+
+	hashes := currentHashes([]*Task{deps, built, build, image, tagged})
+	if hashes["deps"] != deps.generateHash() || hashes["build"] != build.generateHash() {
+		t.Errorf("Expected the hashes of deps and build, got %v", hashes)
+	}
+	for _, name := range []string{"built", "image", "tagged"} {
+		if _, ok := hashes[name]; ok {
+			t.Errorf("Expected the hash of %s to be unknown before execution", name)
+		}
+	}
+}
+
+func TestSelectOrphans(t *testing.T) {
+	containers := []container.Summary{
+		{ID: "1", Names: []string{"/buildvault_build_new"}, State: "exited"},
+		{ID: "2", Names: []string{"/buildvault_build_old"}, State: "exited"},
+		{ID: "3", Names: []string{"/buildvault_build_older"}, State: "running"},
+		{ID: "4", Names: []string{"/buildvault_other_old"}, State: "exited"},
+		{ID: "5", Names: []string{"/unrelated"}, State: "exited"},
+	}
+	current := map[string]string{labelSource: sourceTask, labelTask: "build", labelHash: "new", labelHashVersion: strconv.Itoa(HashVersion)}
+	containers[0].Labels = current
+	orphans := selectOrphans(containers, map[string]string{"build": "new"}, map[string]bool{"build": true})
+	if len(orphans) != 1 || orphans[0].ID != "2" || orphans[0].TaskName != "build" {
+		t.Errorf("Expected only the stopped outdated container of build, got %+v", orphans)
+	}
+
+	// Containers of older hash versions are outdated even if the current hash is unknown
+	versioned := []container.Summary{
+		{ID: "1", Names: []string{"/buildvault_built_abc"}, State: "exited"},
+		{ID: "2", Names: []string{"/buildvault_built_def"}, State: "exited", Labels: map[string]string{labelSource: sourceTask, labelTask: "built", labelHash: "def", labelHashVersion: strconv.Itoa(HashVersion)}},
+		{ID: "3", Names: []string{"/buildvault_other_abc"}, State: "exited"},
+	}
+	orphans = selectOrphans(versioned, map[string]string{}, map[string]bool{"built": true})
+	if len(orphans) != 1 || orphans[0].ID != "1" {
+		t.Errorf("Expected only the unversioned container of built, got %+v", orphans)
+	}
+}
@@ -0,0 +1,244 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/archive"
+)
+
+// GitSource is a Git repository checked out into the task container before its commands run. Its ref is
+// resolved to a commit when the task is hashed, so pushing to a branch executes the task again. The
+// repository is cloned on the host with git, the image needs no Git of its own.
+type GitSource struct {
+	URL        string   `json:"url" yaml:"url"`
+	Ref        string   `json:"ref,omitempty" yaml:"ref"`               // Branch, tag or commit SHA, HEAD of the remote if empty
+	Path       string   `json:"path" yaml:"path"`                       // Absolute directory in the container the repository is checked out in
+	Depth      int      `json:"depth,omitempty" yaml:"depth"`           // Commits of history fetched, all of them if 0
+	Submodules bool     `json:"submodules,omitempty" yaml:"submodules"` // Also check out the submodules, recursively
+	Auth       *GitAuth `json:"auth,omitempty" yaml:"auth"`
+}
+
+// GitAuth are the host credentials of a private repository. They are never part of the task hash.
+type GitAuth struct {
+	Username    string `json:"username,omitempty" yaml:"username"`         // User of HTTPS URLs, x-access-token if empty
+	PasswordEnv string `json:"password_env,omitempty" yaml:"password_env"` // Host environment variable with the password or token of HTTPS URLs
+	SSHKey      string `json:"ssh_key,omitempty" yaml:"ssh_key"`           // Host path of the private key of SSH URLs
+}
+
+// commitSHA matches full commit IDs, which need not be resolved
+var commitSHA = regexp.MustCompile(`^[0-9a-f]{40}([0-9a-f]{24})?$`)
+
+// validateGit checks the Git sources of t
+func (t *Task) validateGit() error {
+	if len(t.Git) > 0 && t.Virtual {
+		return configErrorf("virtual task '%s' cannot check out Git repositories", t.Name)
+	}
+	paths := map[string]bool{}
+	for _, source := range t.Git {
+		if source.URL == "" {
+			return configErrorf("Git source of task '%s' without a url", t.Name)
+		}
+		if !path.IsAbs(source.Path) {
+			return configErrorf("Git source %s of task '%s' needs an absolute path, got '%s'", source.URL, t.Name, source.Path)
+		}
+		if paths[path.Clean(source.Path)] {
+			return configErrorf("task '%s' checks out two Git repositories at %s", t.Name, source.Path)
+		}
+		paths[path.Clean(source.Path)] = true
+		if source.Depth < 0 {
+			return configErrorf("Git source %s of task '%s' has a negative depth", source.URL, t.Name)
+		}
+		if source.Auth != nil && source.Auth.PasswordEnv != "" && source.Auth.SSHKey != "" {
+			return configErrorf("Git source %s of task '%s' has both a password and an SSH key", source.URL, t.Name)
+		}
+	}
+	return nil
+}
+
+// resolveGitSources resolves the refs of the Git sources of t to the commits they point to. Like the
+// digests of host inputs, they are resolved before anything is executed, without a context.
+func (t *Task) resolveGitSources() error {
+	for _, source := range t.Git {
+		if _, done := t.gitCommits[source.Path]; done {
+			continue
+		}
+		commit, err := resolveGitRef(context.Background(), source)
+		if err != nil {
+			return err
+		}
+		if t.gitCommits == nil {
+			t.gitCommits = map[string]string{}
+		}
+		t.gitCommits[source.Path] = commit
+	}
+	return nil
+}
+
+// resolveGitRef returns the commit the ref of source points to in its remote repository
+func resolveGitRef(ctx context.Context, source GitSource) (string, error) {
+	if commitSHA.MatchString(source.Ref) {
+		return source.Ref, nil
+	}
+	out, err := runGit(ctx, source.Auth, "", "ls-remote", source.URL)
+	if err != nil {
+		return "", fmt.Errorf("error resolving %s of %s: %w", gitRefName(source), source.URL, err)
+	}
+	refs := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if commit, ref, ok := strings.Cut(line, "\t"); ok {
+			refs[ref] = commit
+		}
+	}
+
+	ref := gitRefName(source)
+	// Annotated tags are listed with the commit they point to as ^{}
+	for _, candidate := range []string{ref, "refs/heads/" + ref, "refs/tags/" + ref + "^{}", "refs/tags/" + ref} {
+		if commit, ok := refs[candidate]; ok {
+			return commit, nil
+		}
+	}
+	return "", configErrorf("%s has no branch or tag %s", source.URL, ref)
+}
+
+// gitRefName returns the ref of source, HEAD if it has none
+func gitRefName(source GitSource) string {
+	if source.Ref == "" {
+		return "HEAD"
+	}
+	return source.Ref
+}
+
+// runGit runs git with the credentials of auth in dir, returning its standard output
+func runGit(ctx context.Context, auth *GitAuth, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if auth != nil {
+		env, err := auth.env()
+		if err != nil {
+			return nil, err
+		}
+		cmd.Env = append(cmd.Env, env...)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// env returns the environment passing the credentials to git. The password is passed as configuration
+// in the environment, so it does not show up in the arguments of the process.
+func (a *GitAuth) env() ([]string, error) {
+	switch {
+	case a.SSHKey != "":
+		return []string{"GIT_SSH_COMMAND=ssh -i " + strconv.Quote(a.SSHKey) + " -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new"}, nil
+	case a.PasswordEnv != "":
+		password, ok := os.LookupEnv(a.PasswordEnv)
+		if !ok {
+			return nil, configErrorf("environment variable %s of the Git password is not set", a.PasswordEnv)
+		}
+		username := a.Username
+		if username == "" {
+			username = "x-access-token"
+		}
+		credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		return []string{
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic " + credentials,
+		}, nil
+	}
+	return nil, nil
+}
+
+// checkoutGit checks out the resolved commit of source into the host directory dir
+func checkoutGit(ctx context.Context, source GitSource, commit, dir string) error {
+	fetch := []string{"fetch", "--quiet"}
+	if source.Depth > 0 {
+		fetch = append(fetch, "--depth", strconv.Itoa(source.Depth))
+	}
+	if commitSHA.MatchString(source.Ref) {
+		// Fetching a commit by its ID needs a server that allows it, like GitHub and GitLab do
+		fetch = append(fetch, source.URL, commit)
+	} else {
+		fetch = append(fetch, source.URL, gitRefName(source))
+	}
+
+	steps := [][]string{{"init", "--quiet"}, fetch, {"-c", "advice.detachedHead=false", "checkout", "--quiet", commit}}
+	if source.Submodules {
+		update := []string{"submodule", "update", "--quiet", "--init", "--recursive"}
+		if source.Depth > 0 {
+			update = append(update, "--depth", strconv.Itoa(source.Depth))
+		}
+		steps = append(steps, update)
+	}
+	for _, args := range steps {
+		if _, err := runGit(ctx, source.Auth, dir, args...); err != nil {
+			if args[0] == "checkout" {
+				return fmt.Errorf("%s moved away from the resolved commit %s: %w", gitRefName(source), commit, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// checkoutGitSources clones the Git sources of t on the host and copies them into the task container
+func (t *Task) checkoutGitSources(ctx context.Context, cli DockerAPI) error {
+	for _, source := range t.Git {
+		commit := t.gitCommits[source.Path]
+		fmt.Printf("Checking out %s at %s (%s) to %s\n", source.URL, gitRefName(source), shortCommit(commit), source.Path)
+		if err := t.copyGitSource(ctx, cli, source, commit); err != nil {
+			return fmt.Errorf("error checking out %s: %w", source.URL, err)
+		}
+	}
+	return nil
+}
+
+// copyGitSource checks out one Git source into a temporary host directory and copies it into the container
+func (t *Task) copyGitSource(ctx context.Context, cli DockerAPI, source GitSource, commit string) error {
+	dir, err := os.MkdirTemp("", "buildvault-git-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := checkoutGit(ctx, source, commit, dir); err != nil {
+		return err
+	}
+
+	checkout, err := archive.TarWithOptions(dir, &archive.TarOptions{})
+	if err != nil {
+		return fmt.Errorf("error packing checkout: %w", err)
+	}
+	defer checkout.Close()
+	if err := createContainerDir(ctx, cli, t.containerID, source.Path, t.mkdirCommand()); err != nil {
+		return err
+	}
+	release, err := acquireCopy(ctx, cli)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return cli.CopyToContainer(ctx, t.containerID, source.Path, checkout, container.CopyToContainerOptions{CopyUIDGID: t.containerUser() != ""})
+}
+
+// shortCommit abbreviates a commit ID for output
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
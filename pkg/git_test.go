@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+)
+
+// gitRepo creates a repository with a commit on main and the tag v1, returning its directory and a
+// function running git in it
+func gitRepo(t *testing.T) (string, func(args ...string) string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "--quiet", "--initial-branch=main")
+	os.WriteFile(filepath.Join(dir, "file"), []byte("one"), 0o644)
+	git("add", "file")
+	git("commit", "--quiet", "-m", "one")
+	git("tag", "-a", "v1", "-m", "v1")
+	return dir, git
+}
+
+func TestResolveGitRef(t *testing.T) {
+	dir, git := gitRepo(t)
+	first := git("rev-parse", "HEAD")
+
+	for _, ref := range []string{"", "main", "refs/heads/main", "v1", first} {
+		if commit, err := resolveGitRef(context.Background(), GitSource{URL: dir, Ref: ref}); err != nil || commit != first {
+			t.Errorf("Expected %q to resolve to %s, got %s: %v", ref, first, commit, err)
+		}
+	}
+	var configErr *ConfigError
+	if _, err := resolveGitRef(context.Background(), GitSource{URL: dir, Ref: "missing"}); !errors.As(err, &configErr) {
+		t.Errorf("Expected a configuration error for a missing ref, got %v", err)
+	}
+}
+
+func TestGitSourceHash(t *testing.T) {
+	dir, git := gitRepo(t)
+	task := &Task{Name: "build", BaseImage: "alpine", Git: []GitSource{{URL: dir, Ref: "main", Path: "/src"}}}
+	if err := task.hashHostInputs(); err != nil {
+		t.Fatal(err)
+	}
+	first := task.generateHash()
+
+	os.WriteFile(filepath.Join(dir, "file"), []byte("two"), 0o644)
+	git("commit", "--quiet", "-am", "two")
+	task.gitCommits = nil
+	if err := task.hashHostInputs(); err != nil {
+		t.Fatal(err)
+	}
+	if task.generateHash() == first {
+		t.Errorf("A new commit on the branch should change the task hash")
+	}
+
+	task.gitCommits = nil
+	task.Git[0].Ref = "v1"
+	if err := task.hashHostInputs(); err != nil {
+		t.Fatal(err)
+	}
+	if task.generateHash() != first {
+		t.Errorf("The hash should only depend on the commit, not on the ref naming it")
+	}
+}
+
+func TestValidateGit(t *testing.T) {
+	for name, sources := range map[string][]GitSource{
+		"no url":        {{Path: "/src"}},
+		"relative path": {{URL: "repo", Path: "src"}},
+		"same path":     {{URL: "a", Path: "/src"}, {URL: "b", Path: "/src/"}},
+		"depth":         {{URL: "repo", Path: "/src", Depth: -1}},
+		"auth":          {{URL: "repo", Path: "/src", Auth: &GitAuth{PasswordEnv: "TOKEN", SSHKey: "key"}}},
+	} {
+		task := &Task{Name: "build", BaseImage: "alpine", Git: sources}
+		var configErr *ConfigError
+		if err := task.validateGit(); !errors.As(err, &configErr) {
+			t.Errorf("%s: expected a configuration error, got %v", name, err)
+		}
+	}
+}
+
+func TestParsePipelineGit(t *testing.T) {
+	data := "tasks:\n  - name: build\n    image: alpine\n    git:\n      - url: https://example.com/app.git\n        ref: v1\n        path: /src\n        depth: 1\n        auth:\n          password_env: TOKEN\n"
+	pipeline, err := ParsePipeline([]byte(data))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+	source := pipeline.Tasks[0].Git[0]
+	if source.URL != "https://example.com/app.git" || source.Ref != "v1" || source.Depth != 1 || source.Auth.PasswordEnv != "TOKEN" {
+		t.Errorf("Unexpected Git source %+v", source)
+	}
+}
+
+func TestExecuteGitSource(t *testing.T) {
+	dir, _ := gitRepo(t)
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:latest"}, Files: map[string]string{"/bin/sh": ""}})
+
+	task := &Task{Name: "build", BaseImage: "alpine", Commands: []string{"make"}, Git: []GitSource{{URL: dir, Ref: "v1", Path: "/src/app"}}}
+	if err := task.Execute(context.Background(), cli); err != nil {
+		t.Fatalf("Failed to execute task: %v", err)
+	}
+	c, ok := cli.Container(task.generateContainerName())
+	if !ok {
+		t.Fatalf("No container of task build")
+	}
+	if data, err := c.ReadFile("/src/app/file"); err != nil || string(data) != "one" {
+		t.Errorf("Unexpected checked out file %q: %v", data, err)
+	}
+}
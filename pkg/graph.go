@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"strings"
+)
+
+// The task graph of a pipeline is the targets and everything they depend on. TopoSort and Levels order
+// it for execution, Unreachable lists the tasks outside of it.
+
+// TopoSort returns the tasks reachable from the targets of p, every task after its dependencies. Tasks
+// appear in the order of a depth-first walk from the targets, so the order is stable between runs.
+func (p *Pipeline) TopoSort() ([]*Task, error) {
+	return topoSort(p.Targets())
+}
+
+// Levels groups the tasks of TopoSort into execution waves: the first holds the tasks without
+// dependencies, every later one the tasks whose dependencies are all in earlier waves. The tasks of a
+// wave do not depend on each other.
+func (p *Pipeline) Levels() ([][]*Task, error) {
+	order, err := p.TopoSort()
+	if err != nil {
+		return nil, err
+	}
+
+	var levels [][]*Task
+	depths := dependencyDepths(order)
+	for _, task := range order {
+		for len(levels) <= depths[task] {
+			levels = append(levels, nil)
+		}
+		levels[depths[task]] = append(levels[depths[task]], task)
+	}
+	return levels, nil
+}
+
+// Unreachable returns the tasks of p that none of its targets depends on, in definition order.
+func (p *Pipeline) Unreachable() ([]*Task, error) {
+	order, err := p.TopoSort()
+	if err != nil {
+		return nil, err
+	}
+
+	reachable := map[*Task]bool{}
+	for _, task := range order {
+		reachable[task] = true
+	}
+	var unreachable []*Task
+	for _, task := range p.Tasks {
+		if !reachable[task] {
+			unreachable = append(unreachable, task)
+		}
+	}
+	return unreachable, nil
+}
+
+// topoSort returns targets and their dependencies, dependencies first, or an error naming a cycle
+func topoSort(targets []*Task) ([]*Task, error) {
+	var order []*Task
+	done := map[*Task]bool{}
+	var path []*Task
+
+	var visit func(t *Task) error
+	visit = func(t *Task) error {
+		if done[t] {
+			return nil
+		}
+		for i, parent := range path {
+			if parent == t {
+				return configErrorf("circular dependency found in task '%s': %s", t.Name, cyclePath(append(path[i:], t)))
+			}
+		}
+
+		path = append(path, t)
+		for _, dependency := range t.Dependencies {
+			if err := visit(dependency.Task); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+
+		done[t] = true
+		order = append(order, t)
+		return nil
+	}
+
+	for _, target := range targets {
+		if err := visit(target); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// cyclePath formats a dependency cycle as a -> b -> a
+func cyclePath(tasks []*Task) string {
+	return strings.Join(taskNames(tasks), " -> ")
+}
+
+// taskNames returns the names of tasks
+func taskNames(tasks []*Task) []string {
+	var names []string
+	for _, task := range tasks {
+		names = append(names, task.Name)
+	}
+	return names
+}
+
+// dependencyDepths returns the level of every task of order, given dependencies first: 0 for tasks
+// without dependencies, one more than the deepest dependency otherwise
+func dependencyDepths(order []*Task) map[*Task]int {
+	depths := map[*Task]int{}
+	for _, task := range order {
+		depths[task] = 0
+		for _, dependency := range task.Dependencies {
+			depths[task] = max(depths[task], depths[dependency.Task]+1)
+		}
+	}
+	return depths
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// diamondPipeline returns a pipeline where app and docs share base, release depends on both and lint
+// is unrelated
+func diamondPipeline(t *testing.T) *Pipeline {
+	pipeline := &Pipeline{}
+	base := &Task{Name: "base"}
+	app := &Task{Name: "app", Dependencies: []Dependency{{Task: base}}}
+	docs := &Task{Name: "docs", Dependencies: []Dependency{{Task: base}}}
+	release := &Task{Name: "release", Dependencies: []Dependency{{Task: app}, {Task: docs}}}
+	lint := &Task{Name: "lint"}
+	for _, task := range []*Task{base, app, docs, release, lint} {
+		if err := pipeline.AddTask(task); err != nil {
+			t.Fatalf("Failed to add task: %v", err)
+		}
+	}
+	return pipeline
+}
+
+func names(tasks []*Task) []string {
+	var result []string
+	for _, task := range tasks {
+		result = append(result, task.Name)
+	}
+	return result
+}
+
+func TestTopoSort(t *testing.T) {
+	pipeline := diamondPipeline(t)
+	if _, err := pipeline.Target("release"); err != nil {
+		t.Fatal(err)
+	}
+
+	order, err := pipeline.TopoSort()
+	if err != nil {
+		t.Fatalf("Failed to sort: %v", err)
+	}
+	if want := []string{"base", "app", "docs", "release"}; !slices.Equal(names(order), want) {
+		t.Errorf("Expected order %v, got %v", want, names(order))
+	}
+
+	unreachable, err := pipeline.Unreachable()
+	if err != nil {
+		t.Fatalf("Failed to find unreachable tasks: %v", err)
+	}
+	if want := []string{"lint"}; !slices.Equal(names(unreachable), want) {
+		t.Errorf("Expected unreachable %v, got %v", want, names(unreachable))
+	}
+}
+
+func TestLevels(t *testing.T) {
+	levels, err := diamondPipeline(t).Levels()
+	if err != nil {
+		t.Fatalf("Failed to compute levels: %v", err)
+	}
+
+	var got [][]string
+	for _, level := range levels {
+		got = append(got, names(level))
+	}
+	want := [][]string{{"base", "lint"}, {"app", "docs"}, {"release"}}
+	if !slices.EqualFunc(got, want, slices.Equal) {
+		t.Errorf("Expected levels %v, got %v", want, got)
+	}
+}
+
+func TestTopoSortReportsCycles(t *testing.T) {
+	a := &Task{Name: "a"}
+	b := &Task{Name: "b", Dependencies: []Dependency{{Task: a}}}
+	a.Dependencies = []Dependency{{Task: b}}
+	pipeline := &Pipeline{Tasks: []*Task{a, b}}
+	if _, err := pipeline.Target("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := pipeline.TopoSort()
+	if err == nil || !strings.Contains(err.Error(), "a -> b -> a") {
+		t.Errorf("Expected the cycle in the error, got %v", err)
+	}
+}
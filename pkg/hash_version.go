@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"strconv"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Task hashes are versioned. The version is the first thing hashed, so the hashes of two versions never
+// collide, even for tasks whose hashed fields did not change. Containers are labelled with the version
+// their hash has and stored artifacts record it.
+//
+// When what a hash covers changes, e.g. because a new field has to be part of it, HashVersion is
+// increased and the change is listed below. After an upgrade:
+//   - Containers and stored artifacts of older versions are not found under the new hashes, so every task
+//     executes once. Nothing is ever reused for a task that now hashes differently.
+//   - CollectGarbage removes the containers of older versions of every task of a run, even of tasks whose
+//     hash is only known during execution. Prune removes them like any other container of unused hashes.
+//   - Containers without the label were created before hashes were versioned and count as version 1.
+//
+// Versions:
+//
+//	1  name, image, platform, commands, inputs, secrets (the environment they provide), services,
+//	   dependencies, user and security options
+//	2  the version itself and mounts: their type, target, whether they are read-only and volume names
+//	3  the digests of the stored outputs of dependencies with a freshness limit
+//	4  secrets by HMAC under the key of the workspace instead of a plain digest
+const HashVersion = 4
+
+// labelHashVersion is the label of the version of the hash a container was created for
+const labelHashVersion = "buildvault.hash_version"
+
+// addHashVersion starts a hash of the current version
+func (p *hashParts) addHashVersion() {
+	p.addf("hash version", "buildvault-hash-v%d\x00", HashVersion)
+}
+
+// containerHashVersion returns the version of the hash a task container was created for
+func containerHashVersion(summary container.Summary) int {
+	version, err := strconv.Atoi(summary.Labels[labelHashVersion])
+	if err != nil {
+		return 1
+	}
+	return version
+}
+
+// addMountsHash adds the mounts of t to its hash. Mounts are part of the container, which is reused for
+// the same hash, so the hash has to change for a container with other mounts. Host paths of bind mounts
+// are left out, they differ between machines sharing a cache.
+func (t *Task) addMountsHash(parts *hashParts) {
+	for _, m := range t.Mounts {
+		source := ""
+		if m.Type == MountVolume {
+			source = m.Source
+		}
+		parts.addf("mount "+m.Target, "mount\x00%s\x00%s\x00%s\x00%t\x00", m.Type, source, m.Target, m.ReadOnly)
+	}
+}
@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// helperPath is the reserved path the helper binary of a task is injected at
+const helperPath = internalDir + "/helper"
+
+// helperSleepSeconds keeps the container alive; busybox sleep does not accept "infinity"
+const helperSleepSeconds = "2147483647"
+
+// shellKeepAlive keeps the container alive with whatever the image has: GNU sleep accepts infinity,
+// busybox sleep only seconds, and images without sleep usually still have tail
+const shellKeepAlive = "sleep infinity || sleep " + helperSleepSeconds + " || tail -f /dev/null"
+
+// injectHelper copies the static helper binary at hostPath into a created (not yet started) container
+func injectHelper(ctx context.Context, cli ContainerFileAPI, containerID, hostPath string) error {
+	binary, err := os.ReadFile(hostPath)
+	if err != nil {
+		return fmt.Errorf("error reading helper binary: %w", err)
+	}
+	return injectBinary(ctx, cli, containerID, helperPath, binary)
+}
+
+// injectBinary copies an executable to containerPath in the directory of buildvault's own files
+func injectBinary(ctx context.Context, cli ContainerFileAPI, containerID, containerPath string, binary []byte) error {
+	name := path.Base(containerPath)
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	dir := path.Dir(containerPath)[1:]
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir + "/", Mode: 0o755}); err != nil {
+		return fmt.Errorf("error packing %s binary: %w", name, err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: containerPath[1:], Mode: 0o755, Size: int64(len(binary))}); err != nil {
+		return fmt.Errorf("error packing %s binary: %w", name, err)
+	}
+	if _, err := tw.Write(binary); err != nil {
+		return fmt.Errorf("error packing %s binary: %w", name, err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("error packing %s binary: %w", name, err)
+	}
+
+	release, err := acquireCopy(ctx, cli)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if err := cli.CopyToContainer(ctx, containerID, "/", &buf, container.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("error injecting %s binary: %w", name, err)
+	}
+	return nil
+}
+
+// keepAliveCommand returns the main process of the task container, which only has to stay alive. With
+// an entrypoint, it is passed to the entrypoint as arguments.
+func (t *Task) keepAliveCommand() []string {
+	if t.Pause {
+		return []string{pausePath}
+	}
+	if len(t.KeepAlive) > 0 {
+		return t.KeepAlive
+	}
+	if t.Helper != "" {
+		return []string{helperPath, "sleep", helperSleepSeconds}
+	}
+	return t.shellCommand(shellKeepAlive)
+}
+
+// entrypoint returns the entrypoint the task container is created with: nil for the one of the image,
+// and [""] to reset it, as the Docker API expects, for an empty one. In pause mode the entrypoint of the
+// image is reset too, in distroless images it usually is the application itself.
+func (t *Task) entrypoint() []string {
+	if (t.Entrypoint != nil && len(t.Entrypoint) == 0) || (t.Entrypoint == nil && t.Pause) {
+		return []string{""}
+	}
+	return t.Entrypoint
+}
+
+// mkdirCommand returns the command creating directories (and their parents) in the task container, nil
+// if the container has no tools and the directories are copied into it instead
+func (t *Task) mkdirCommand() []string {
+	if t.Helper != "" {
+		return []string{helperPath, "mkdir", "-p"}
+	}
+	if t.withoutTools() {
+		return nil
+	}
+	return []string{"mkdir", "-p"}
+}
+
+// scriptCommand returns the command running the shell script at path in the task container
+func (t *Task) scriptCommand(path string) []string {
+	if t.noShell {
+		return []string{helperPath, "sh", path}
+	}
+	return []string{"sh", path}
+}
+
+// shellCommand returns the command running cmd in the task container, through the shell of the task if
+// it has one. Images without a shell run commands through the helper, which works if the helper is busybox.
+func (t *Task) shellCommand(cmd string) []string {
+	if len(t.Shell) > 0 {
+		return append(slices.Clone(t.Shell), cmd)
+	}
+	if t.noShell {
+		return []string{helperPath, "sh", "-c", cmd}
+	}
+	return []string{"sh", "-c", cmd}
+}
+
+// writeFileCommand returns the command writing its stdin to the file at path in the task container
+func (t *Task) writeFileCommand(path string) []string {
+	if t.Helper != "" {
+		return []string{helperPath, "tee", path}
+	}
+	return []string{"sh", "-c", `umask 077 && cat > "$1"`, "sh", path}
+}
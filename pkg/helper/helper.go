@@ -0,0 +1,215 @@
+// Package helper implements buildvault-helper, a small static multi-call binary that buildvault injects
+// into task containers whose image lacks the tools buildvault relies on (e.g. distroless or scratch).
+//
+// The applets follow the busybox calling conventions, so a static busybox can be injected instead:
+//
+//	helper sleep <seconds>            keep the container alive
+//	helper mkdir -p <dir>...          create directories
+//	helper stat <path>...             print type, mode and size of paths
+//	helper sha256sum [-r] <path>...   print digests in sha256sum format, -r descends into directories (buildvault-helper only)
+//	helper glob <pattern>...          print the paths matching shell patterns (buildvault-helper only)
+//	helper tee <file>...              copy stdin to the files and stdout
+package helper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Run executes the applet named by args[0] and returns the process exit code.
+func Run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: buildvault-helper <sleep|mkdir|stat|sha256sum|glob|tee> [args...]")
+		return 2
+	}
+
+	var err error
+	switch args[0] {
+	case "sleep":
+		err = sleep(args[1:])
+	case "mkdir":
+		err = mkdir(args[1:])
+	case "stat":
+		err = stat(args[1:], stdout)
+	case "sha256sum":
+		err = sha256sum(args[1:], stdout)
+	case "glob":
+		err = glob(args[1:], stdout)
+	case "tee":
+		err = tee(args[1:], os.Stdin, stdout)
+	default:
+		err = fmt.Errorf("unknown applet %q", args[0])
+	}
+
+	if err != nil {
+		fmt.Fprintf(stderr, "buildvault-helper %s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// sleep blocks for the given number of seconds (or forever for "infinity") until SIGTERM or SIGINT
+func sleep(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("expected a duration in seconds")
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	if args[0] == "infinity" {
+		<-signals
+		return nil
+	}
+
+	seconds, err := strconv.ParseFloat(args[0], 64)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", args[0])
+	}
+	select {
+	case <-signals:
+	case <-time.After(time.Duration(seconds * float64(time.Second))):
+	}
+	return nil
+}
+
+func mkdir(args []string) error {
+	parents := false
+	var dirs []string
+	for _, arg := range args {
+		if arg == "-p" {
+			parents = true
+			continue
+		}
+		dirs = append(dirs, arg)
+	}
+
+	for _, dir := range dirs {
+		var err error
+		if parents {
+			err = os.MkdirAll(dir, 0o755)
+		} else {
+			err = os.Mkdir(dir, 0o755)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tee copies stdin to the given files, which are created readable by the owner only, and stdout
+func tee(args []string, stdin io.Reader, stdout io.Writer) error {
+	writers := []io.Writer{stdout}
+	var files []*os.File
+	for _, name := range args {
+		file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writers = append(writers, file)
+		files = append(files, file)
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), stdin); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := file.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stat(args []string, stdout io.Writer) error {
+	for _, p := range args {
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+
+		kind := "file"
+		switch {
+		case info.IsDir():
+			kind = "directory"
+		case info.Mode()&os.ModeSymlink != 0:
+			kind = "symlink"
+		}
+		fmt.Fprintf(stdout, "%s %s %o %d\n", p, kind, info.Mode().Perm(), info.Size())
+	}
+	return nil
+}
+
+func sha256sum(args []string, stdout io.Writer) error {
+	recursive := false
+	var paths []string
+	for _, arg := range args {
+		if arg == "-r" {
+			recursive = true
+			continue
+		}
+		paths = append(paths, arg)
+	}
+
+	if recursive {
+		var files []string
+		for _, root := range paths {
+			err := filepath.WalkDir(root, func(p string, entry os.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if entry.Type().IsRegular() {
+					files = append(files, p)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		paths = files
+	}
+
+	for _, p := range paths {
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		hasher := sha256.New()
+		_, err = io.Copy(hasher, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s  %s\n", hex.EncodeToString(hasher.Sum(nil)), p)
+	}
+	return nil
+}
+
+func glob(args []string, stdout io.Writer) error {
+	var matches []string
+	for _, pattern := range args {
+		found, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		matches = append(matches, found...)
+	}
+
+	sort.Strings(matches)
+	for _, match := range matches {
+		fmt.Fprintln(stdout, match)
+	}
+	return nil
+}
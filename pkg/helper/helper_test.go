@@ -0,0 +1,58 @@
+package helper
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplets(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "a", "b")
+
+	var stdout, stderr bytes.Buffer
+	if code := Run([]string{"mkdir", "-p", nested}, &stdout, &stderr); code != 0 {
+		t.Fatalf("mkdir failed: %s", stderr.String())
+	}
+
+	file := filepath.Join(nested, "data.txt")
+	if err := os.WriteFile(file, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout.Reset()
+	if code := Run([]string{"sha256sum", file}, &stdout, &stderr); code != 0 {
+		t.Fatalf("sha256sum failed: %s", stderr.String())
+	}
+	want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  " + file + "\n"
+	if stdout.String() != want {
+		t.Errorf("Unexpected sha256sum output %q", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := Run([]string{"sha256sum", "-r", filepath.Join(dir, "a")}, &stdout, &stderr); code != 0 || stdout.String() != want {
+		t.Errorf("Unexpected recursive sha256sum output %q (%s)", stdout.String(), stderr.String())
+	}
+
+	stdout.Reset()
+	if code := Run([]string{"glob", filepath.Join(dir, "a", "*", "*.txt")}, &stdout, &stderr); code != 0 {
+		t.Fatalf("glob failed: %s", stderr.String())
+	}
+	if strings.TrimSpace(stdout.String()) != file {
+		t.Errorf("Unexpected glob output %q", stdout.String())
+	}
+
+	stdout.Reset()
+	if code := Run([]string{"stat", file}, &stdout, &stderr); code != 0 || !strings.Contains(stdout.String(), " file 644 5") {
+		t.Errorf("Unexpected stat output %q (%s)", stdout.String(), stderr.String())
+	}
+
+	if code := Run([]string{"sleep", "0"}, &stdout, &stderr); code != 0 {
+		t.Errorf("sleep failed: %s", stderr.String())
+	}
+	if code := Run([]string{"unknown"}, &stdout, &stderr); code == 0 {
+		t.Errorf("Unknown applets should fail")
+	}
+}
@@ -0,0 +1,214 @@
+package pkg
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Every run is recorded in a bbolt database in the state directory of the workspace, with the outcome,
+// hash, container and artifact digests of each task, for spotting trends and debugging past runs.
+
+const historyFile = "history.db"
+
+// Outcomes of recorded runs
+const (
+	RunSucceeded   = "succeeded"
+	RunFailed      = "failed"
+	RunInterrupted = "interrupted"
+)
+
+// runsBucket holds the JSON of every run, keyed by its ID in big endian so runs sort in order
+var runsBucket = []byte("runs")
+
+// RunRecord is a run in the history.
+type RunRecord struct {
+	ID       uint64        `json:"id"`
+	Targets  []string      `json:"targets"`
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	Status   string        `json:"status"`          // RunSucceeded, RunFailed or RunInterrupted
+	Error    string        `json:"error,omitempty"` // Why the run failed
+	Tasks    []TaskRecord  `json:"tasks"`           // All tasks of the run in execution order
+}
+
+// TaskRecord is a task of a recorded run.
+type TaskRecord struct {
+	Name        string            `json:"name"`
+	Status      string            `json:"status"` // Report status, like StatusExecuted
+	Hash        string            `json:"hash,omitempty"`
+	Duration    time.Duration     `json:"duration_ns"`
+	ExitCode    int               `json:"exit_code,omitempty"` // Of the failing command, if a command failed
+	ContainerID string            `json:"container_id,omitempty"`
+	Artifacts   map[string]string `json:"artifacts,omitempty"` // Digests of the outputs, by path
+	Sizes       map[string]int64  `json:"sizes,omitempty"`     // Total sizes of the files of the outputs in bytes, by path
+}
+
+// History is the database of past runs of a pipeline.
+type History struct {
+	db *bolt.DB
+}
+
+// HistoryPath returns where the history of the pipeline file at pipelinePath is kept.
+func HistoryPath(pipelinePath string) string {
+	return filepath.Join(filepath.Dir(pipelinePath), stateDir, historyFile)
+}
+
+// OpenHistory opens the history database at path, creating it if needed. Only one process can have it
+// open at a time, others wait for up to a few seconds.
+func OpenHistory(path string) (*History, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating history directory: %w", err)
+	}
+	db, err := bolt.Open(path, 0o644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening history %s: %w", path, err)
+	}
+	return &History{db: db}, nil
+}
+
+// Close closes the database.
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+// NewRunRecord records the outcome of a run of targets that started at started, given all tasks of the
+// run in execution order, the error it returned and whether it was interrupted.
+func NewRunRecord(targets, tasks []*Task, started, finished time.Time, runErr error, interrupted bool) *RunRecord {
+	run := &RunRecord{Started: started.UTC(), Duration: finished.Sub(started), Status: RunSucceeded}
+	switch {
+	case interrupted:
+		run.Status = RunInterrupted
+	case runErr != nil:
+		run.Status = RunFailed
+	}
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+	for _, target := range targets {
+		run.Targets = append(run.Targets, target.Name)
+	}
+	for _, task := range tasks {
+		record := TaskRecord{Name: task.Name, Status: task.status(), Duration: task.duration, ExitCode: task.Result().ExitCode(), ContainerID: task.containerID}
+		if task.completed {
+			record.Hash = task.generateHash()
+			record.Artifacts = task.outputDigests
+			record.Sizes = task.outputSizes
+		}
+		run.Tasks = append(run.Tasks, record)
+	}
+	return run
+}
+
+// Record adds run to the history and sets its ID.
+func (h *History) Record(run *RunRecord) error {
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(runsBucket)
+		if err != nil {
+			return err
+		}
+		if run.ID, err = bucket.NextSequence(); err != nil {
+			return err
+		}
+		data, err := json.Marshal(run)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(runKey(run.ID), data)
+	})
+	if err != nil {
+		return fmt.Errorf("error recording run: %w", err)
+	}
+	return nil
+}
+
+// Runs returns up to limit runs, the most recent first. A limit of 0 returns all runs.
+func (h *History) Runs(limit int) ([]RunRecord, error) {
+	var runs []RunRecord
+	err := h.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(runsBucket)
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for key, data := cursor.Last(); key != nil && (limit <= 0 || len(runs) < limit); key, data = cursor.Prev() {
+			var run RunRecord
+			if err := json.Unmarshal(data, &run); err != nil {
+				return err
+			}
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading history: %w", err)
+	}
+	return runs, nil
+}
+
+// ErrRunNotFound is returned for runs that are not in the history.
+var ErrRunNotFound = errors.New("run not found")
+
+// Run returns the run with the given ID.
+func (h *History) Run(id uint64) (*RunRecord, error) {
+	var run *RunRecord
+	err := h.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(runsBucket)
+		if bucket == nil {
+			return nil
+		}
+		data := bucket.Get(runKey(id))
+		if data == nil {
+			return nil
+		}
+		run = &RunRecord{}
+		return json.Unmarshal(data, run)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading history: %w", err)
+	}
+	if run == nil {
+		return nil, fmt.Errorf("%w: %d", ErrRunNotFound, id)
+	}
+	return run, nil
+}
+
+// TaskRun is a task in one of the runs of the history.
+type TaskRun struct {
+	RunID   uint64
+	Started time.Time // Start of the run
+	TaskRecord
+}
+
+// TaskRuns returns up to limit recorded runs of the task with the given name, the most recent first. A
+// limit of 0 returns all of them.
+func (h *History) TaskRuns(name string, limit int) ([]TaskRun, error) {
+	runs, err := h.Runs(0)
+	if err != nil {
+		return nil, err
+	}
+	var taskRuns []TaskRun
+	for _, run := range runs {
+		for _, task := range run.Tasks {
+			if task.Name == name {
+				taskRuns = append(taskRuns, TaskRun{RunID: run.ID, Started: run.Started, TaskRecord: task})
+			}
+		}
+		if limit > 0 && len(taskRuns) >= limit {
+			return taskRuns[:limit], nil
+		}
+	}
+	return taskRuns, nil
+}
+
+func runKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
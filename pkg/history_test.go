@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistoryRecordsRuns(t *testing.T) {
+	build := &Task{Name: "build", BaseImage: "alpine", completed: true, containerID: "abc", duration: time.Second}
+	test := &Task{Name: "test", BaseImage: "alpine", Dependencies: []Dependency{{Task: build}}}
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	history, err := OpenHistory(HistoryPath(filepath.Join(t.TempDir(), "buildvault.yaml")))
+	if err != nil {
+		t.Fatalf("Failed to open history: %v", err)
+	}
+	defer history.Close()
+
+	if runs, err := history.Runs(0); err != nil || len(runs) != 0 {
+		t.Fatalf("Expected an empty history, got %v, %v", runs, err)
+	}
+
+	failed := NewRunRecord([]*Task{test}, []*Task{build, test}, started, started.Add(time.Minute), errors.New("boom"), false)
+	if failed.Status != RunFailed || failed.Error != "boom" || failed.Duration != time.Minute {
+		t.Fatalf("Unexpected run %+v", failed)
+	}
+	if failed.Tasks[0].Hash != build.generateHash() || failed.Tasks[0].ContainerID != "abc" || failed.Tasks[1].Hash != "" {
+		t.Fatalf("Unexpected tasks %+v", failed.Tasks)
+	}
+	if err := history.Record(failed); err != nil {
+		t.Fatalf("Failed to record run: %v", err)
+	}
+	succeeded := NewRunRecord([]*Task{build}, []*Task{build}, started.Add(time.Hour), started.Add(time.Hour), nil, false)
+	if err := history.Record(succeeded); err != nil {
+		t.Fatalf("Failed to record run: %v", err)
+	}
+	if failed.ID != 1 || succeeded.ID != 2 {
+		t.Fatalf("Expected IDs 1 and 2, got %d and %d", failed.ID, succeeded.ID)
+	}
+
+	runs, err := history.Runs(0)
+	if err != nil || len(runs) != 2 || runs[0].ID != 2 || runs[1].ID != 1 {
+		t.Fatalf("Expected runs newest first, got %+v, %v", runs, err)
+	}
+	if runs, _ := history.Runs(1); len(runs) != 1 || runs[0].Status != RunSucceeded {
+		t.Errorf("Expected only the latest run, got %+v", runs)
+	}
+
+	run, err := history.Run(1)
+	if err != nil || run.Targets[0] != "test" || !run.Started.Equal(started) {
+		t.Errorf("Unexpected run %+v, %v", run, err)
+	}
+	if _, err := history.Run(3); !errors.Is(err, ErrRunNotFound) {
+		t.Errorf("Expected ErrRunNotFound, got %v", err)
+	}
+
+	taskRuns, err := history.TaskRuns("build", 0)
+	if err != nil || len(taskRuns) != 2 || taskRuns[0].RunID != 2 || taskRuns[1].Duration != time.Second {
+		t.Errorf("Unexpected runs of build %+v, %v", taskRuns, err)
+	}
+	if taskRuns, _ := history.TaskRuns("test", 0); len(taskRuns) != 1 || taskRuns[0].RunID != 1 {
+		t.Errorf("Unexpected runs of test %+v", taskRuns)
+	}
+}
+
+func TestRunRecordInterrupted(t *testing.T) {
+	run := NewRunRecord(nil, nil, time.Now(), time.Now(), errors.New("context canceled"), true)
+	if run.Status != RunInterrupted {
+		t.Errorf("Expected an interrupted run, got %s", run.Status)
+	}
+}
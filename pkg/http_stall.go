@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// stallTimeout is how long an HTTP request to a remote cache or of a download may make no progress,
+// while connecting, sending, waiting for the response or receiving it, before it is cancelled. Transfers
+// of large files may take longer as a whole.
+var stallTimeout = time.Minute
+
+// errStalled cancels HTTP requests that made no progress for stallTimeout
+var errStalled = errors.New("server stopped responding")
+
+// doStallGuarded sends the request newRequest creates for ctx and body, cancelling it once it stalls for
+// stallTimeout. The response body, closed by the caller, is guarded the same way.
+func doStallGuarded(ctx context.Context, client *http.Client, body io.Reader, newRequest func(ctx context.Context, body io.Reader) (*http.Request, error)) (*http.Response, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	guard := &stallGuard{ctx: ctx, timer: time.AfterFunc(stallTimeout, func() { cancel(errStalled) })}
+	stop := func() {
+		guard.timer.Stop()
+		cancel(nil)
+	}
+	if body != nil {
+		body = guard.reader(body)
+	}
+	req, err := newRequest(ctx, body)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		stop()
+		return nil, guard.cause(err)
+	}
+	respBody := resp.Body
+	resp.Body = readCloser{guard.reader(respBody), closerFunc(func() error {
+		stop()
+		return respBody.Close()
+	})}
+	return resp, nil
+}
+
+// stallGuard restarts the stall timeout of a request whenever data flows
+type stallGuard struct {
+	ctx   context.Context
+	timer *time.Timer
+}
+
+func (g *stallGuard) reader(r io.Reader) io.Reader {
+	return readerFunc(func(p []byte) (int, error) {
+		n, err := r.Read(p)
+		if n > 0 {
+			g.timer.Reset(stallTimeout)
+		}
+		if err != nil && err != io.EOF {
+			err = g.cause(err)
+		}
+		return n, err
+	})
+}
+
+// cause reports a stall instead of the cancellation it caused
+func (g *stallGuard) cause(err error) error {
+	if cause := context.Cause(g.ctx); errors.Is(cause, errStalled) {
+		return fmt.Errorf("%w for %s", errStalled, stallTimeout)
+	}
+	return err
+}
+
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	imagetypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+const (
+	labelManaged = "buildvault.managed" // Marks resources created by buildvault
+	labelTask    = "buildvault.task"    // Name of the task a resource belongs to
+)
+
+// ImageBuild describes how to build a task's base image from a Dockerfile instead of pulling it.
+type ImageBuild struct {
+	Context    string            // Build context directory on the host
+	Dockerfile string            // Path of the Dockerfile relative to the context, defaults to "Dockerfile"
+	Args       map[string]string // Build arguments
+}
+
+// builtImageTag returns the tag under which the image of a Dockerfile-build task is registered
+func builtImageTag(taskName string) string {
+	return fmt.Sprintf("%s/%s:latest", namespacedRepository("buildvault"), strings.ToLower(taskName))
+}
+
+// buildImage builds the base image of the task and records its digest, which becomes part of the task hash
+func (t *Task) buildImage(ctx context.Context, cli ImageAPI) error {
+	buildContext, err := archive.TarWithOptions(t.Build.Context, &archive.TarOptions{})
+	if err != nil {
+		return fmt.Errorf("error packing build context %s: %w", t.Build.Context, err)
+	}
+	defer buildContext.Close()
+
+	buildArgs := map[string]*string{}
+	for key, value := range t.Build.Args {
+		buildArgs[key] = &value
+	}
+
+	tag := builtImageTag(t.Name)
+	fmt.Printf("Building image %s for task '%s'\n", tag, t.Name)
+	resp, err := cli.ImageBuild(ctx, buildContext, types.ImageBuildOptions{
+		Tags:       []string{tag},
+		Dockerfile: t.Build.Dockerfile,
+		BuildArgs:  buildArgs,
+		Labels: withNamespace(map[string]string{
+			labelManaged: "true",
+			labelTask:    t.Name,
+		}),
+		Remove:   true,
+		Platform: t.Platform,
+	})
+	if err != nil {
+		return fmt.Errorf("error building image for task '%s': %w", t.Name, err)
+	}
+	defer resp.Body.Close()
+
+	if err := jsonmessage.DisplayJSONMessagesStream(resp.Body, os.Stdout, 0, false, nil); err != nil {
+		return fmt.Errorf("error building image for task '%s': %w", t.Name, err)
+	}
+
+	return t.resolveBuiltImage(ctx, cli)
+}
+
+// resolveBuiltImage looks up the digest of the image currently registered for the task
+func (t *Task) resolveBuiltImage(ctx context.Context, cli ImageAPI) error {
+	tag := builtImageTag(t.Name)
+	inspect, err := cli.ImageInspect(ctx, tag)
+	if err != nil {
+		return fmt.Errorf("error inspecting built image %s: %w", tag, err)
+	}
+
+	t.BaseImage = tag
+	t.imageID = inspect.ID
+	if inspect.Config != nil {
+		t.imageEnv = inspect.Config.Env
+	}
+	return nil
+}
+
+// ResolveBuiltImages looks up the digests of previously built images for all Dockerfile-build tasks
+// without building anything, so their hashes can be computed (e.g. to find containers still in use).
+// Tasks whose image was never built are left unresolved.
+func ResolveBuiltImages(ctx context.Context, cli ImageAPI, tasks []*Task) error {
+	for _, task := range tasks {
+		if task.Build == nil || task.imageID != "" {
+			continue
+		}
+		if err := task.resolveBuiltImage(ctx, cli); err != nil && !errdefs.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// PruneImages removes images built or committed by buildvault that were replaced by a rebuild or a
+// later commit to the same reference and are no longer used by any container. It returns the IDs of the removed (or, with dryRun, removable) images.
+func PruneImages(ctx context.Context, cli ImageAPI, dryRun bool) ([]string, error) {
+	listFilters := filters.NewArgs()
+	listFilters.Add("label", labelManaged+"=true")
+	listFilters.Add("dangling", "true")
+
+	images, err := cli.ImageList(ctx, imagetypes.ListOptions{Filters: listFilters, ContainerCount: true})
+	if err != nil {
+		return nil, fmt.Errorf("error listing buildvault images: %w", err)
+	}
+
+	var removed []string
+	for _, image := range images {
+		if image.Containers > 0 || !inNamespace(image.Labels) {
+			continue
+		}
+		if !dryRun {
+			fmt.Printf("Removing image %s (task '%s')\n", image.ID, image.Labels[labelTask])
+			if _, err := cli.ImageRemove(ctx, image.ID, imagetypes.RemoveOptions{PruneChildren: true}); err != nil {
+				// Still referenced by a container created in the meantime
+				if errdefs.IsConflict(err) {
+					continue
+				}
+				return nil, fmt.Errorf("error removing image %s: %w", image.ID, err)
+			}
+		}
+		removed = append(removed, image.ID)
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,192 @@
+package pkg
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	imagetypes "github.com/docker/docker/api/types/image"
+)
+
+// Formats of ExportImage. Since Docker 25 the daemon saves images as OCI image layouts, so both formats
+// are read by OCI tools as well as by docker load.
+const (
+	ImageFormatOCI = "oci" // OCI image layout directory
+	ImageFormatTar = "tar" // docker save tarball
+)
+
+// ImageExportOptions selects what ExportImage writes where.
+type ImageExportOptions struct {
+	Path   string // Directory (oci) or file (tar) to write, must not exist yet
+	Format string // ImageFormatOCI or ImageFormatTar
+	Base   string // Image to put only the declared outputs on; the whole container filesystem if empty
+	Tag    string // Reference the image is named as in the export, <task>:latest if empty
+}
+
+// ExportImage turns the most recent container of t into an image file for tools that do not talk to
+// the daemon: either its whole filesystem as committed, or only its declared outputs on top of a base
+// image. The intermediate image is removed from the daemon afterwards.
+func (t *Task) ExportImage(ctx context.Context, cli DockerAPI, opts ImageExportOptions) error {
+	if opts.Format != ImageFormatOCI && opts.Format != ImageFormatTar {
+		return fmt.Errorf("unknown image format '%s', expected %s or %s", opts.Format, ImageFormatOCI, ImageFormatTar)
+	}
+	if _, err := os.Stat(opts.Path); err == nil {
+		return fmt.Errorf("%s already exists", opts.Path)
+	}
+	if opts.Tag == "" {
+		opts.Tag = strings.ToLower(t.Name) + ":latest"
+	}
+
+	containers, err := listBuildvaultContainers(ctx, cli, false)
+	if err != nil {
+		return err
+	}
+	summary, ok := latestTaskContainer(containers, t.Name)
+	if !ok {
+		return fmt.Errorf("task '%s' has no preserved container, run it first", t.Name)
+	}
+
+	if opts.Base == "" {
+		config, err := t.outputImageConfig(ctx, cli, summary.ImageID)
+		if err != nil {
+			return err
+		}
+		if _, err := commitContainer(ctx, cli, summary.ID, opts.Tag, fmt.Sprintf("Filesystem of task '%s'", t.Name), config); err != nil {
+			return fmt.Errorf("error committing the container of task '%s': %w", t.Name, err)
+		}
+	} else if err := t.commitOutputsOnBase(ctx, cli, summary.ID, opts.Base, opts.Tag); err != nil {
+		return err
+	}
+	defer cli.ImageRemove(context.WithoutCancel(ctx), opts.Tag, imagetypes.RemoveOptions{PruneChildren: true})
+
+	reader, err := cli.ImageSave(ctx, []string{opts.Tag})
+	if err != nil {
+		return fmt.Errorf("error saving image %s: %w", opts.Tag, err)
+	}
+	defer reader.Close()
+
+	if opts.Format == ImageFormatOCI {
+		if err := os.MkdirAll(opts.Path, 0o755); err != nil {
+			return fmt.Errorf("error creating %s: %w", opts.Path, err)
+		}
+		if err := extractTar(reader, opts.Path); err != nil {
+			return fmt.Errorf("error writing OCI layout %s: %w", opts.Path, err)
+		}
+	} else if err := writeFileFrom(opts.Path, reader); err != nil {
+		return err
+	}
+	fmt.Printf("Exported task '%s' as image %s to %s (%s)\n", t.Name, opts.Tag, opts.Path, opts.Format)
+	return nil
+}
+
+// commitOutputsOnBase copies the declared outputs of t from the container id into a container of the
+// base image and commits that as tag
+func (t *Task) commitOutputsOnBase(ctx context.Context, cli DockerAPI, id, base, tag string) error {
+	outputs := t.declaredOutputs()
+	if len(outputs) == 0 {
+		return fmt.Errorf("task '%s' declares no outputs to put on %s", t.Name, base)
+	}
+	if err := ensureImage(ctx, cli, base, t.Platform, statusWriter{t}); err != nil {
+		return err
+	}
+	config, err := imageConfig(ctx, cli, base)
+	if err != nil {
+		return err
+	}
+	config.Labels[labelManaged] = "true"
+	config.Labels[labelTask] = t.Name
+
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:  base,
+		Cmd:    []string{shellPath}, // Never executed, but required for images without a default command
+		Labels: map[string]string{labelManaged: "true"},
+	}, nil, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("error creating container of %s: %w", base, err)
+	}
+	defer cli.ContainerRemove(context.WithoutCancel(ctx), resp.ID, container.RemoveOptions{Force: true})
+
+	for _, output := range outputs {
+		if err := copyBetweenContainers(ctx, cli, id, resp.ID, output); err != nil {
+			return fmt.Errorf("error copying output %s of task '%s': %w", output, t.Name, err)
+		}
+	}
+
+	if _, err := commitContainer(ctx, cli, resp.ID, tag, fmt.Sprintf("Outputs of task '%s' on %s", t.Name, base), config); err != nil {
+		return fmt.Errorf("error committing the outputs of task '%s': %w", t.Name, err)
+	}
+	return nil
+}
+
+// copyBetweenContainers copies the path p from the container src to the same path in dst. Missing
+// parent directories are created by the daemon.
+func copyBetweenContainers(ctx context.Context, cli ContainerFileAPI, src, dst, p string) error {
+	release, err := acquireCopy(ctx, cli)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	reader, _, err := cli.CopyFromContainer(ctx, src, p)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// The archive is rooted at the base name of p, extracting it at / needs the parent directories
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(prefixTar(reader, pw, strings.TrimPrefix(path.Dir(path.Clean(p)), "/")))
+	}()
+	defer pr.Close()
+	return cli.CopyToContainer(ctx, dst, "/", pr, container.CopyToContainerOptions{})
+}
+
+// prefixTar copies the tar archive r to w with every entry moved below dir
+func prefixTar(r io.Reader, w io.Writer, dir string) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return tw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(dir, header.Name)
+		if header.Typeflag == tar.TypeDir {
+			header.Name += "/"
+		}
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname = path.Join(dir, header.Linkname)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// writeFileFrom writes the contents of r to a new file at path
+func writeFileFrom(path string, r io.Reader) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", path, err)
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrefixTar(t *testing.T) {
+	var in bytes.Buffer
+	tw := tar.NewWriter(&in)
+	tw.WriteHeader(&tar.Header{Name: "dist/", Typeflag: tar.TypeDir, Mode: 0o755})
+	tw.WriteHeader(&tar.Header{Name: "dist/app", Typeflag: tar.TypeReg, Mode: 0o755, Size: 3})
+	tw.Write([]byte("bin"))
+	tw.WriteHeader(&tar.Header{Name: "dist/app-link", Typeflag: tar.TypeLink, Linkname: "dist/app"})
+	tw.Close()
+
+	var out bytes.Buffer
+	if err := prefixTar(&in, &out, "workspace/build"); err != nil {
+		t.Fatalf("Failed to prefix archive: %v", err)
+	}
+
+	tr := tar.NewReader(&out)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		names = append(names, header.Name)
+		if header.Typeflag == tar.TypeLink && header.Linkname != "workspace/build/dist/app" {
+			t.Errorf("Unexpected link target %s", header.Linkname)
+		}
+	}
+	want := []string{"workspace/build/dist/", "workspace/build/dist/app", "workspace/build/dist/app-link"}
+	if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] || names[2] != want[2] {
+		t.Errorf("Expected entries %v, got %v", want, names)
+	}
+}
+
+func TestExportImageFormat(t *testing.T) {
+	task := &Task{Name: "build", BaseImage: "alpine"}
+	err := task.ExportImage(context.Background(), nil, ImageExportOptions{Path: filepath.Join(t.TempDir(), "out"), Format: "zip"})
+	if err == nil {
+		t.Errorf("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// ImageInheritance selects which settings of the base image apply to the container and commands of a
+// task. Without it all of them apply, as with docker run; with it only the ones set to true do.
+type ImageInheritance struct {
+	Env     bool `json:"env" yaml:"env"`         // ENV variables of the image
+	User    bool `json:"user" yaml:"user"`       // USER of the image, otherwise commands run as root unless the task sets a user
+	WorkDir bool `json:"workdir" yaml:"workdir"` // WORKDIR of the image, otherwise commands run in /
+}
+
+// defaultPath is the PATH of containers whose image environment is not inherited, the one Docker uses
+// for images without a PATH
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// inherits returns which image settings t inherits
+func (t *Task) inherits() ImageInheritance {
+	if t.Inherit == nil {
+		return ImageInheritance{Env: true, User: true, WorkDir: true}
+	}
+	return *t.Inherit
+}
+
+// containerEnv returns the environment of the task container. Docker always merges the image's ENV
+// into it and cannot unset variables, so variables that are not inherited are set to empty values.
+func (t *Task) containerEnv() []string {
+	if t.inherits().Env {
+		return nil
+	}
+	env := []string{"PATH=" + defaultPath}
+	for _, variable := range t.imageEnv {
+		name, _, _ := strings.Cut(variable, "=")
+		if name != "PATH" {
+			env = append(env, name+"=")
+		}
+	}
+	return env
+}
+
+// workDir returns the working directory of the container and command execs, empty for the image's WORKDIR
+func (t *Task) workDir() string {
+	if t.inherits().WorkDir {
+		return ""
+	}
+	return "/"
+}
+
+// containerConfig returns the configuration of the task container. User, environment and working
+// directory also apply to every command exec, which inherit them from the container.
+func (t *Task) containerConfig() *container.Config {
+	return &container.Config{
+		Image:      t.BaseImage,
+		User:       t.containerUser(), // Also the owner of copied files with CopyUIDGID
+		Env:        t.containerEnv(),
+		WorkingDir: t.workDir(),
+		Entrypoint: t.entrypoint(),
+		Cmd:        t.keepAliveCommand(), // Keep container alive
+	}
+}
@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestContainerConfigInheritsImageByDefault(t *testing.T) {
+	task := &Task{Name: "test", BaseImage: "node:20", imageEnv: []string{"PATH=/usr/local/node/bin:/usr/bin", "NODE_ENV=production"}}
+	config := task.containerConfig()
+	if config.User != "" || config.Env != nil || config.WorkingDir != "" {
+		t.Errorf("Expected the image's user, env and workdir, got %+v", config)
+	}
+}
+
+func TestContainerConfigWithoutInheritance(t *testing.T) {
+	task := &Task{Name: "test", BaseImage: "node:20", Inherit: &ImageInheritance{}, imageEnv: []string{"PATH=/usr/local/node/bin:/usr/bin", "NODE_ENV=production"}}
+	config := task.containerConfig()
+	if config.User != rootUser || config.WorkingDir != "/" {
+		t.Errorf("Expected root in /, got user %q in %q", config.User, config.WorkingDir)
+	}
+	if want := []string{"PATH=" + defaultPath, "NODE_ENV="}; !slices.Equal(config.Env, want) {
+		t.Errorf("Expected env %v, got %v", want, config.Env)
+	}
+
+	task.User = "node"
+	task.Inherit.Env = true
+	if config := task.containerConfig(); config.User != "node" || config.Env != nil {
+		t.Errorf("A user of the task should win over the inheritance, got %+v", config)
+	}
+}
+
+func TestInheritanceChangesTaskHash(t *testing.T) {
+	task := &Task{Name: "test", BaseImage: "alpine"}
+	before := task.generateHash()
+	task.Inherit = &ImageInheritance{Env: true, User: true, WorkDir: true}
+	if task.generateHash() != before {
+		t.Errorf("Inheriting everything explicitly should keep the task hash")
+	}
+	task.Inherit.WorkDir = false
+	if task.generateHash() == before {
+		t.Errorf("Changing the inherited settings should change the task hash")
+	}
+}
+
+func TestContainerConfigEntrypointAndKeepAlive(t *testing.T) {
+	task := &Task{Name: "test", BaseImage: "postgres:16"}
+	config := task.containerConfig()
+	if config.Entrypoint != nil || !slices.Equal(config.Cmd, []string{"sh", "-c", shellKeepAlive}) {
+		t.Errorf("Expected the image's entrypoint and the default keep-alive, got %v and %v", config.Entrypoint, config.Cmd)
+	}
+
+	task.Entrypoint = []string{}
+	task.KeepAlive = []string{"sleep", "3600"}
+	config = task.containerConfig()
+	if !slices.Equal(config.Entrypoint, []string{""}) || !slices.Equal(config.Cmd, []string{"sleep", "3600"}) {
+		t.Errorf("Expected a reset entrypoint and the custom keep-alive, got %v and %v", config.Entrypoint, config.Cmd)
+	}
+
+	plain := &Task{Name: "test", BaseImage: "postgres:16"}
+	if plain.generateHash() == task.generateHash() {
+		t.Error("Expected the entrypoint to change the task hash")
+	}
+	plain.Entrypoint = []string{}
+	if plain.generateHash() != task.generateHash() {
+		t.Error("Expected the keep-alive not to change the task hash")
+	}
+}
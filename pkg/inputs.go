@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// hashHostPath digests a host file or directory tree by relative names, file modes and contents.
+// Timestamps are ignored, so touching or re-checking out a file does not change the digest.
+func hashHostPath(root string) (string, error) {
+	hasher := sha256.New()
+
+	err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(hasher, "%s\x00%o\x00", filepath.ToSlash(rel), info.Mode())
+
+		switch {
+		case info.Mode().IsRegular():
+			file, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			if _, err := io.Copy(hasher, file); err != nil {
+				return err
+			}
+		case info.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			hasher.Write([]byte(target))
+		}
+		hasher.Write([]byte{0})
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error hashing input %s: %w", root, err)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// artifactInputSource returns the dependency whose artifact is copied to the given container path,
+// identifying hash inputs that refer to dependency artifacts rather than host paths
+func (t *Task) artifactInputSource(input string) (*Task, Artifact, bool) {
+	for _, dependency := range t.Dependencies {
+		for _, artifact := range dependency.Artifacts {
+			if artifact.To == input {
+				return dependency.Task, artifact, true
+			}
+		}
+	}
+	return nil, Artifact{}, false
+}
+
+// hashHostInputs digests the host path hash inputs, reads the secrets and resolves the Git sources of all
+// tasks in the graph of t
+func (t *Task) hashHostInputs() error {
+	for _, dependency := range t.Dependencies {
+		if err := dependency.Task.hashHostInputs(); err != nil {
+			return err
+		}
+	}
+
+	for _, input := range t.HashInputs {
+		if _, _, ok := t.artifactInputSource(input); ok {
+			continue
+		}
+		if _, done := t.inputDigests[input]; done {
+			continue
+		}
+
+		digest, err := hashHostPath(input)
+		if err != nil {
+			return err
+		}
+		if t.inputDigests == nil {
+			t.inputDigests = map[string]string{}
+		}
+		t.inputDigests[input] = digest
+	}
+
+	if err := t.resolveSecrets(); err != nil {
+		return err
+	}
+
+	if err := t.resolveGitSources(); err != nil {
+		return err
+	}
+
+	// The helper may run the task's commands (busybox sh), so a different binary invalidates the task
+	if t.Helper != "" && t.helperDigest == "" {
+		digest, err := hashHostPath(t.Helper)
+		if err != nil {
+			return err
+		}
+		t.helperDigest = digest
+	}
+	return nil
+}
+
+// hashArtifactInputs digests the dependency artifact hash inputs of t, which requires its dependencies
+// to have been executed
+func (t *Task) hashArtifactInputs(ctx context.Context, cli DockerAPI) error {
+	for _, input := range t.HashInputs {
+		dependency, artifact, ok := t.artifactInputSource(input)
+		if !ok {
+			continue
+		}
+
+		digest, err := artifactDigest(ctx, cli, dependency, artifact.From)
+		if err != nil {
+			return err
+		}
+
+		if t.inputDigests == nil {
+			t.inputDigests = map[string]string{}
+		}
+		t.inputDigests[input] = digest
+	}
+	return nil
+}
+
+// ResolveHostInputs digests the host path hash inputs of the given tasks, so their hashes match the
+// ones computed during execution. Dependency artifact inputs are only known after executing.
+func ResolveHostInputs(tasks []*Task) error {
+	for _, task := range tasks {
+		if err := task.hashHostInputs(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHashHostPath(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "src", "main.go")
+	if err := os.WriteFile(file, []byte("package main"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := hashHostPath(dir)
+	if err != nil {
+		t.Fatalf("Failed to hash directory: %v", err)
+	}
+
+	// Timestamps must not influence the digest
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	second, _ := hashHostPath(dir)
+	if first != second {
+		t.Errorf("Digest changed after touching a file")
+	}
+
+	if err := os.WriteFile(file, []byte("package main // edited"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	third, _ := hashHostPath(dir)
+	if first == third {
+		t.Errorf("Digest should change when file content changes")
+	}
+
+	if _, err := hashHostPath(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("Expected an error for a missing input")
+	}
+}
+
+func TestDigestTarIgnoresTimestamps(t *testing.T) {
+	archive := func(modTime time.Time, content string) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		tw.WriteHeader(&tar.Header{Name: "data.txt", Mode: 0o644, Size: int64(len(content)), ModTime: modTime, Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+		tw.Close()
+		return buf.Bytes()
+	}
+
+	a, err := digestTar(bytes.NewReader(archive(time.Unix(1, 0), "data")))
+	if err != nil {
+		t.Fatalf("Failed to digest archive: %v", err)
+	}
+	b, _ := digestTar(bytes.NewReader(archive(time.Unix(2, 0), "data")))
+	c, _ := digestTar(bytes.NewReader(archive(time.Unix(1, 0), "other")))
+
+	if a != b {
+		t.Errorf("Digest should not depend on modification times")
+	}
+	if a == c {
+		t.Errorf("Digest should depend on content")
+	}
+}
+
+func TestHashInputsChangeTaskHash(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "config.txt")
+	os.WriteFile(input, []byte("v1"), 0o644)
+
+	task := &Task{Name: "build", BaseImage: "alpine", HashInputs: []string{input}}
+	if err := ResolveHostInputs([]*Task{task}); err != nil {
+		t.Fatalf("Failed to hash inputs: %v", err)
+	}
+	before := task.generateHash()
+
+	os.WriteFile(input, []byte("v2"), 0o644)
+	task.inputDigests = nil
+	if err := ResolveHostInputs([]*Task{task}); err != nil {
+		t.Fatalf("Failed to hash inputs: %v", err)
+	}
+	if before == task.generateHash() {
+		t.Errorf("Editing an input file should change the task hash")
+	}
+
+	// Artifact destinations are not treated as host paths
+	producer := &Task{Name: "producer", BaseImage: "alpine"}
+	consumer := &Task{
+		Name:         "consumer",
+		BaseImage:    "alpine",
+		Dependencies: []Dependency{{Task: producer, Artifacts: []Artifact{{From: "/out/app", To: "/in/app"}}}},
+		HashInputs:   []string{"/in/app"},
+	}
+	if err := ResolveHostInputs([]*Task{consumer}); err != nil {
+		t.Errorf("Dependency artifact inputs should not be hashed from the host: %v", err)
+	}
+}
+
+func TestDigestTarMatchesChecksums(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "output/", Mode: 0o755, Typeflag: tar.TypeDir})
+	tw.WriteHeader(&tar.Header{Name: "output/hello.txt", Mode: 0o644, Size: 5, Typeflag: tar.TypeReg})
+	tw.Write([]byte("hello"))
+	tw.Close()
+
+	fromTar, err := digestTar(&buf)
+	if err != nil {
+		t.Fatalf("Failed to digest archive: %v", err)
+	}
+
+	// Output of `find output -type f -exec sha256sum {} +` in the container
+	files, err := parseChecksums("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  output/hello.txt\n")
+	if err != nil {
+		t.Fatalf("Failed to parse checksums: %v", err)
+	}
+	if fromContainer := fileListDigest(files); fromContainer != fromTar {
+		t.Errorf("In-container digest %s differs from host digest %s", fromContainer, fromTar)
+	}
+
+	if _, err := parseChecksums("\\2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824  odd\\nname\n"); err == nil {
+		t.Errorf("Escaped file names should be rejected")
+	}
+}
@@ -0,0 +1,401 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// KubernetesExecutor runs a task graph in a Kubernetes cluster instead of on a Docker daemon. Every task
+// gets a long-lived pod running its base image, commands run through kubectl exec, and artifacts are
+// streamed as tar archives from the pod of the dependency into the pod of the dependent, mirroring the
+// container-based execution. Tasks with an ArtifactStore save their declared outputs into it, are skipped
+// when it holds them already, and their dependents restore artifacts from it like on Docker. Pods are
+// deleted once the whole graph finished, unless KeepPods is set.
+//
+// Only tasks with a base image are supported; images built from Dockerfiles have to be pushed to a
+// registry the cluster can pull from first.
+type KubernetesExecutor struct {
+	Namespace string // Namespace of the pods, defaults to the namespace of the kubeconfig context
+	Context   string // kubeconfig context, defaults to the current context
+	Kubectl   string // kubectl binary, defaults to "kubectl" on the PATH
+	KeepPods  bool   // Keep pods after execution for inspection
+
+	// runKubectl runs kubectl with the given arguments, replaced in tests
+	runKubectl func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error
+
+	pods    map[*Task]string // Pods of executed tasks
+	options executeOptions   // Settings of the current call of Execute, shared by all tasks of the graph
+}
+
+// kubeNameInvalid matches characters that are not allowed in pod names and label values
+var kubeNameInvalid = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// kubeLabelValue turns s into a valid label value, which is limited to 63 characters. Longer values are
+// shortened and end in a hash of s, so distinct task names keep distinct labels.
+func kubeLabelValue(s string) string {
+	value := strings.Trim(kubeNameInvalid.ReplaceAllString(strings.ToLower(s), "-"), "-")
+	if len(value) <= 63 {
+		return value
+	}
+	sum := sha256.Sum256([]byte(s))
+	return strings.TrimRight(value[:54], "-") + "-" + hex.EncodeToString(sum[:])[:8]
+}
+
+// podName returns the deterministic pod name of a task, the Kubernetes counterpart of the container name
+func podName(t *Task) string {
+	name := strings.Trim(kubeNameInvalid.ReplaceAllString(strings.ToLower(t.Name), "-"), "-")
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return fmt.Sprintf("buildvault-%s-%s", name, t.generateHash())
+}
+
+// podManifest returns the pod of a task, which only has to stay alive for commands to be executed in it
+func podManifest(t *Task) ([]byte, error) {
+	var env []map[string]string
+	for _, entry := range t.metadataEnv() {
+		name, value, _ := strings.Cut(entry, "=")
+		env = append(env, map[string]string{"name": name, "value": value})
+	}
+
+	// Nothing can be copied into a pod before it starts, so the pause binary cannot keep it alive
+	keepAlive := t.KeepAlive
+	if len(keepAlive) == 0 {
+		keepAlive = t.shellCommand(shellKeepAlive)
+	}
+	podContainer := map[string]any{
+		"name":  "task",
+		"image": t.BaseImage,
+		"env":   env,
+	}
+	if len(t.Entrypoint) > 0 {
+		podContainer["command"] = t.Entrypoint
+		podContainer["args"] = keepAlive
+	} else {
+		// The command replaces the entrypoint of the image, which is not run on Kubernetes
+		podContainer["command"] = keepAlive
+	}
+
+	manifest := map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]any{
+			"name": podName(t),
+			"labels": map[string]string{
+				labelManaged: "true",
+				labelTask:    kubeLabelValue(t.Name),
+			},
+		},
+		"spec": map[string]any{
+			"restartPolicy": "Never",
+			"containers":    []map[string]any{podContainer},
+		},
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding pod manifest: %w", err)
+	}
+	return data, nil
+}
+
+func (k *KubernetesExecutor) kubectl(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
+	var global []string
+	if k.Context != "" {
+		global = append(global, "--context", k.Context)
+	}
+	if k.Namespace != "" {
+		global = append(global, "--namespace", k.Namespace)
+	}
+	args = append(global, args...)
+
+	if k.runKubectl != nil {
+		return k.runKubectl(ctx, stdin, stdout, stderr, args...)
+	}
+
+	binary := k.Kubectl
+	if binary == "" {
+		binary = "kubectl"
+	}
+	var errOutput bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = io.MultiWriter(stderr, &errOutput)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("kubectl %s failed: %w: %s", args[len(global)], err, strings.TrimSpace(errOutput.String()))
+	}
+	return nil
+}
+
+// Execute runs t and its dependencies as pods. Of the options, those selecting the output, forcing
+// execution and bounding the time apply.
+func (k *KubernetesExecutor) Execute(ctx context.Context, t *Task, opts ...ExecuteOption) error {
+	if !t.isCircularDependencyFree(nil) {
+		return fmt.Errorf("circular dependency found in task '%s'", t.Name)
+	}
+	if err := t.resolveVars(); err != nil {
+		return err
+	}
+	if err := t.resolveOutputRefs(); err != nil {
+		return err
+	}
+	if err := t.hashHostInputs(); err != nil {
+		return err
+	}
+
+	k.options = newExecuteOptions(opts)
+	ctx, cancel := k.options.withTimeout(ctx)
+	defer cancel()
+	k.pods = map[*Task]string{}
+	err := k.execute(ctx, t)
+
+	if !k.KeepPods {
+		for _, pod := range k.pods {
+			if pod == "" {
+				continue
+			}
+			if deleteErr := k.kubectl(context.WithoutCancel(ctx), nil, io.Discard, k.options.stderr, "delete", "pod", pod, "--wait=false", "--ignore-not-found"); deleteErr != nil && err == nil {
+				err = deleteErr
+			}
+		}
+	}
+	return err
+}
+
+func (k *KubernetesExecutor) execute(ctx context.Context, t *Task) error {
+	if _, done := k.pods[t]; done {
+		return nil
+	}
+	t.options = k.options
+	if t.Container != "" {
+		return fmt.Errorf("task '%s' refers to the existing Docker container '%s', which the Kubernetes executor does not support", t.Name, t.Container)
+	}
+	if t.Build != nil {
+		return fmt.Errorf("task '%s' builds its image from a Dockerfile, which the Kubernetes executor does not support", t.Name)
+	}
+	if len(t.Git) > 0 {
+		return fmt.Errorf("task '%s' checks out Git repositories, which the Kubernetes executor does not support", t.Name)
+	}
+	if len(t.Downloads) > 0 {
+		return fmt.Errorf("task '%s' downloads files, which the Kubernetes executor does not support", t.Name)
+	}
+
+	for _, dependency := range t.sortedDependencies() {
+		if err := k.execute(ctx, dependency.Task); err != nil {
+			return fmt.Errorf("error executing task dependency %s:  %w", dependency.Task.Name, err)
+		}
+	}
+	if t.Virtual {
+		k.pods[t] = ""
+		return nil
+	}
+
+	if len(t.Secrets) > 0 {
+		return fmt.Errorf("task '%s' uses secrets, which the Kubernetes executor does not support", t.Name)
+	}
+	if len(t.Mounts) > 0 || len(t.CacheDirs) > 0 {
+		return fmt.Errorf("task '%s' has mounts, which the Kubernetes executor does not support", t.Name)
+	}
+	if len(t.Services) > 0 {
+		return fmt.Errorf("task '%s' has services, which the Kubernetes executor does not support", t.Name)
+	}
+	if t.Network != nil {
+		return fmt.Errorf("task '%s' configures its network, which the Kubernetes executor does not support", t.Name)
+	}
+	if t.User != "" || t.HostUser {
+		return fmt.Errorf("task '%s' sets the user of its commands, which the Kubernetes executor does not support", t.Name)
+	}
+	if t.SnapshotOnFailure {
+		return fmt.Errorf("task '%s' snapshots its container on failure, which the Kubernetes executor does not support", t.Name)
+	}
+	if t.OutputImage != "" {
+		return fmt.Errorf("task '%s' commits an output image, which the Kubernetes executor does not support", t.Name)
+	}
+	if len(t.ImageMirrors) > 0 {
+		return fmt.Errorf("task '%s' pulls its image from mirrors, which the Kubernetes executor does not support", t.Name)
+	}
+	if t.AllowFailure {
+		return fmt.Errorf("task '%s' is allowed to fail, which the Kubernetes executor does not support", t.Name)
+	}
+	if t.When != nil {
+		return fmt.Errorf("task '%s' has a condition, which the Kubernetes executor does not support", t.Name)
+	}
+	if t.Inherit != nil {
+		return fmt.Errorf("task '%s' selects the image settings it inherits, which the Kubernetes executor does not support", t.Name)
+	}
+	if len(t.Exports) > 0 {
+		return fmt.Errorf("task '%s' exports artifacts to the host, which the Kubernetes executor does not support", t.Name)
+	}
+	for _, input := range t.HashInputs {
+		if _, _, ok := t.artifactInputSource(input); ok {
+			return fmt.Errorf("task '%s' hashes dependency artifacts, which the Kubernetes executor does not support", t.Name)
+		}
+	}
+
+	pod := podName(t)
+	_, stderr := t.outputWriters(k.options.stdout, k.options.stderr)
+	t.statusf("Task: %s (Pod: %s)\n", t.Name, pod)
+
+	if t.ArtifactStore != nil && !t.options.force && len(t.Stdin) == 0 {
+		stored, err := t.lookupStored(ctx)
+		if err != nil {
+			return err
+		}
+		if stored {
+			t.cacheHit = true
+			k.pods[t] = ""
+			t.statusf("Task '%s' outputs found in artifact store, skipping execution\n", t.Name)
+			return nil
+		}
+	}
+
+	manifest, err := podManifest(t)
+	if err != nil {
+		return err
+	}
+	if err := k.kubectl(ctx, nil, io.Discard, stderr, "delete", "pod", pod, "--ignore-not-found"); err != nil {
+		return err
+	}
+	if err := k.kubectl(ctx, bytes.NewReader(manifest), io.Discard, stderr, "apply", "-f", "-"); err != nil {
+		return err
+	}
+	k.pods[t] = pod
+	if err := k.kubectl(ctx, nil, io.Discard, stderr, "wait", "--for=condition=Ready", "pod/"+pod, "--timeout=10m"); err != nil {
+		return err
+	}
+
+	for _, c := range t.resolvedCopies() {
+		if t.readOnlyArtifact(c.artifact) {
+			return fmt.Errorf("task '%s' has read-only artifacts, which the Kubernetes executor does not support", t.Name)
+		}
+		t.statusf("  Copying %s from task '%s' to current task at %s\n", c.artifact.From, c.dependency.Name, c.artifact.To)
+		source, sourcePath, err := resolveArtifactSource(c.dependency, c.artifact.From)
+		if err != nil {
+			return err
+		}
+		if err := k.copyArtifact(ctx, source, sourcePath, pod, c.artifact.To); err != nil {
+			return fmt.Errorf("error copying dependency file %s from task '%s' to %s: %w", c.artifact.From, c.dependency.Name, c.artifact.To, err)
+		}
+	}
+
+	if err := k.executeCommands(ctx, t, pod); err != nil {
+		return err
+	}
+
+	for _, output := range t.declaredOutputs() {
+		if err := k.kubectl(ctx, nil, io.Discard, io.Discard, "exec", pod, "--", "test", "-e", output); err != nil {
+			return fmt.Errorf("declared output %s of task '%s' was not produced", output, t.Name)
+		}
+	}
+
+	if t.ArtifactStore != nil {
+		err := t.ArtifactStore.save(ctx, t, func(output string) (string, int64, error) {
+			reader := k.podArchive(ctx, pod, output)
+			defer reader.Close()
+			return t.ArtifactStore.putBlob(reader)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executeCommands runs the commands of t in its pod, either one exec per command or, for tasks with
+// BatchCommands or a script, all of them as a script passed on stdin
+func (k *KubernetesExecutor) executeCommands(ctx context.Context, t *Task, pod string) error {
+	stdout, stderr := t.outputWriters(k.options.stdout, k.options.stderr)
+	defer flushLines(stdout)
+	defer flushLines(stderr)
+
+	if t.Script != "" {
+		t.statusf("Executing script\n")
+		if err := k.kubectl(ctx, strings.NewReader(t.scriptSource()), stdout, stderr, append([]string{"exec", "-i", pod, "--"}, t.stdinScriptCommand()...)...); err != nil {
+			return fmt.Errorf("script of task '%s' failed: %w", t.Name, err)
+		}
+		return nil
+	}
+
+	lines := t.commandLines()
+	if !t.BatchCommands {
+		for idx, cmd := range lines {
+			t.statusf("Executing command %d: %s\n", idx+1, cmd)
+			if err := k.kubectl(ctx, nil, stdout, stderr, append([]string{"exec", pod, "--"}, t.commandArgv(idx)...)...); err != nil {
+				return fmt.Errorf("command '%s' failed: %w", cmd, err)
+			}
+		}
+		return nil
+	}
+
+	marker, err := stepMarker()
+	if err != nil {
+		return err
+	}
+	steps := &stepWriter{out: stdout, marker: marker, commands: lines}
+	err = k.kubectl(ctx, strings.NewReader(t.batchScript(marker)), steps, stderr, "exec", "-i", pod, "--", "sh", "-s")
+	if flushErr := steps.flush(); err == nil {
+		err = flushErr
+	}
+	if steps.failed != 0 {
+		return fmt.Errorf("command '%s' failed with exit code %d", lines[steps.failed-1], steps.exitCode)
+	}
+	if err != nil {
+		return fmt.Errorf("command script failed after %d of %d commands: %w", steps.finished, len(lines), err)
+	}
+	return nil
+}
+
+// stdinScriptCommand returns the command running a script passed on stdin: the shell program of the task
+// if it sets one, like scriptRunner, sh otherwise
+func (t *Task) stdinScriptCommand() []string {
+	if len(t.Shell) > 0 {
+		return []string{t.Shell[0], "-s"}
+	}
+	return []string{"sh", "-s"}
+}
+
+// podArchive streams a tar archive of p in pod, named like the archives of the Docker API
+func (k *KubernetesExecutor) podArchive(ctx context.Context, pod, p string) io.ReadCloser {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(k.kubectl(ctx, nil, writer, k.options.stderr, "exec", pod, "--",
+			"tar", "cf", "-", "-C", path.Dir(p), path.Base(p)))
+	}()
+	return reader
+}
+
+// copyArtifact streams the artifact at from of the source task into the target pod, from the artifact
+// store of the source if it holds it, else from the pod of the source. Like a container copy, the
+// archive is extracted into the directory of the destination path.
+func (k *KubernetesExecutor) copyArtifact(ctx context.Context, source *Task, from, targetPod, to string) error {
+	var reader io.ReadCloser
+	if source.ArtifactStore != nil {
+		stored, ok, err := source.ArtifactStore.Open(ctx, source.generateHash(), from)
+		if err != nil {
+			return err
+		}
+		if ok {
+			reader = stored
+		}
+	}
+	if reader == nil {
+		if k.pods[source] == "" {
+			return fmt.Errorf("%s is not a stored output of task '%s', which was not executed", from, source.Name)
+		}
+		reader = k.podArchive(ctx, k.pods[source], from)
+	}
+	defer reader.Close()
+
+	targetDir := path.Dir(to)
+	return k.kubectl(ctx, reader, io.Discard, k.options.stderr, "exec", "-i", targetPod, "--",
+		"sh", "-c", fmt.Sprintf("mkdir -p %s && tar xf - -C %s", shellQuote(targetDir), shellQuote(targetDir)))
+}
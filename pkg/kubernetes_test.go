@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKubernetesExecutor(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	executor := &KubernetesExecutor{Namespace: "ci"}
+	executor.runKubectl = func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
+		mu.Lock()
+		calls = append(calls, strings.Join(args, " "))
+		mu.Unlock()
+
+		if args[2] == "apply" {
+			var pod map[string]any
+			if err := json.NewDecoder(stdin).Decode(&pod); err != nil {
+				t.Errorf("Invalid pod manifest: %v", err)
+			}
+		}
+		if args[2] == "exec" && args[len(args)-2] == "-" {
+			// tar of the source pod
+			io.WriteString(stdout, "archive")
+		}
+		if stdin != nil {
+			io.Copy(io.Discard, stdin)
+		}
+		return nil
+	}
+
+	generate := &Task{Name: "Generate_File", BaseImage: "alpine", Commands: []string{"echo hi > /out/a"}}
+	consume := &Task{
+		Name:         "consume",
+		BaseImage:    "alpine",
+		Commands:     []string{"cat /in/a"},
+		Outputs:      []string{"/in/a"},
+		Dependencies: []Dependency{{Task: generate, Artifacts: []Artifact{{From: "/out/a", To: "/in/a"}}}},
+	}
+
+	if err := executor.Execute(context.Background(), consume); err != nil {
+		t.Fatalf("Failed to execute: %v", err)
+	}
+
+	generatePod := podName(generate)
+	if !strings.HasPrefix(generatePod, "buildvault-generate-file-") {
+		t.Errorf("Unexpected pod name %s", generatePod)
+	}
+
+	joined := strings.Join(calls, "\n")
+	for _, want := range []string{
+		"--namespace ci apply -f -",
+		"--namespace ci exec " + generatePod + " -- sh -c echo hi > /out/a",
+		"--namespace ci exec " + generatePod + " -- tar cf - -C /out a",
+		"--namespace ci exec -i " + podName(consume) + " -- sh -c mkdir -p '/in' && tar xf - -C '/in'",
+		"--namespace ci exec " + podName(consume) + " -- test -e /in/a",
+		"--namespace ci delete pod " + generatePod + " --wait=false --ignore-not-found",
+	} {
+		if !strings.Contains(joined, want) {
+			t.Errorf("Expected kubectl call %q, got:\n%s", want, joined)
+		}
+	}
+
+	if err := executor.Execute(context.Background(), &Task{Name: "built", Build: &ImageBuild{Context: "."}}); err == nil {
+		t.Errorf("Expected an error for a task built from a Dockerfile")
+	}
+}
+
+func TestKubeLabelValue(t *testing.T) {
+	if value := kubeLabelValue("Generate_File"); value != "generate-file" {
+		t.Errorf("Unexpected label value %s", value)
+	}
+	long := strings.Repeat("integration-test-", 5)
+	a, b := kubeLabelValue(long+"a"), kubeLabelValue(long+"b")
+	if len(a) > 63 || len(b) > 63 || a == b {
+		t.Errorf("Expected distinct label values of at most 63 characters, got %s and %s", a, b)
+	}
+	if kubeNameInvalid.MatchString(a) || strings.HasPrefix(a, "-") || strings.HasSuffix(a, "-") {
+		t.Errorf("Invalid label value %s", a)
+	}
+}
+
+func TestKubernetesArtifactStore(t *testing.T) {
+	store, err := NewArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	archive := buildTar(t, "a", "hi")
+
+	var mu sync.Mutex
+	var calls []string
+	var copied [][]byte
+	runKubectl := func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, strings.Join(args, " "))
+		if slices.Contains(args, "tar") && slices.Contains(args, "cf") {
+			stdout.Write(archive)
+		}
+		if stdin != nil {
+			data, _ := io.ReadAll(stdin)
+			if slices.Contains(args, "-c") && strings.Contains(args[len(args)-1], "tar xf") {
+				copied = append(copied, data)
+			}
+		}
+		return nil
+	}
+
+	generate := &Task{Name: "generate", BaseImage: "alpine", Commands: []string{"echo hi > /out/a"}, Outputs: []string{"/out/a"}, ArtifactStore: store}
+	consume := &Task{
+		Name:         "consume",
+		BaseImage:    "alpine",
+		Commands:     []string{"cat /in/a"},
+		Dependencies: []Dependency{{Task: generate, Artifacts: []Artifact{{From: "/out/a", To: "/in/a"}}}},
+	}
+
+	// The output is archived once into the store, and the dependent restores it from there
+	if err := (&KubernetesExecutor{runKubectl: runKubectl}).Execute(context.Background(), consume); err != nil {
+		t.Fatalf("Failed to execute: %v", err)
+	}
+	manifest, ok, err := store.Lookup(context.Background(), generate.generateHash())
+	if err != nil || !ok || len(manifest.Artifacts) != 1 || manifest.Artifacts[0].Path != "/out/a" {
+		t.Fatalf("Expected the output to be stored, got %+v ok=%v err=%v", manifest, ok, err)
+	}
+	archives := 0
+	for _, call := range calls {
+		if strings.Contains(call, " tar cf ") {
+			archives++
+		}
+	}
+	if archives != 1 {
+		t.Errorf("Expected the output to be archived once, got %d archives:\n%s", archives, strings.Join(calls, "\n"))
+	}
+
+	// Another run skips the stored task and still copies its artifact
+	calls = nil
+	if err := (&KubernetesExecutor{runKubectl: runKubectl}).Execute(context.Background(), consume); err != nil {
+		t.Fatalf("Failed to execute again: %v", err)
+	}
+	if joined := strings.Join(calls, "\n"); strings.Contains(joined, podName(generate)) {
+		t.Errorf("Expected the stored task to be skipped, got:\n%s", joined)
+	}
+	if len(copied) != 2 || !bytes.Equal(copied[0], archive) || !bytes.Equal(copied[1], archive) {
+		t.Errorf("Expected the stored archive to be copied into both runs, got %d copies", len(copied))
+	}
+	// Forced runs execute the stored task again
+	calls = nil
+	if err := (&KubernetesExecutor{runKubectl: runKubectl}).Execute(context.Background(), consume, WithForce()); err != nil {
+		t.Fatalf("Failed to execute with force: %v", err)
+	}
+	if joined := strings.Join(calls, "\n"); !strings.Contains(joined, "exec "+podName(generate)+" -- sh -c echo hi") {
+		t.Errorf("Expected the forced run to execute the stored task, got:\n%s", joined)
+	}
+}
+
+func TestKubernetesScriptAndBatch(t *testing.T) {
+	var calls []string
+	var scripts []string
+	runKubectl := func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, args ...string) error {
+		calls = append(calls, strings.Join(args, " "))
+		if stdin != nil {
+			data, _ := io.ReadAll(stdin)
+			scripts = append(scripts, string(data))
+		}
+		return nil
+	}
+
+	var output bytes.Buffer
+	script := &Task{Name: "script", BaseImage: "bash", Shell: []string{"bash", "-c"}, Script: "echo hi"}
+	if err := (&KubernetesExecutor{runKubectl: runKubectl}).Execute(context.Background(), script, WithStdout(&output)); err != nil {
+		t.Fatalf("Failed to execute: %v", err)
+	}
+	if !slices.Contains(calls, "exec -i "+podName(script)+" -- bash -s") {
+		t.Errorf("Expected the script to run in the shell of the task, got:\n%s", strings.Join(calls, "\n"))
+	}
+	if !strings.Contains(output.String(), "Task: script (Pod: "+podName(script)+")") || !strings.Contains(output.String(), "Executing script") {
+		t.Errorf("Expected the status lines in the output of the task, got %q", output.String())
+	}
+
+	scripts = nil
+	batch := &Task{Name: "batch", BaseImage: "alpine", Commands: []string{"true"}, BatchCommands: true}
+	if err := (&KubernetesExecutor{runKubectl: runKubectl}).Execute(context.Background(), batch, WithStdout(io.Discard)); err != nil {
+		t.Fatalf("Failed to execute batch: %v", err)
+	}
+	if len(scripts) == 0 || !strings.Contains(scripts[len(scripts)-1], "::buildvault-step-") || strings.Contains(scripts[len(scripts)-1], podName(batch)) {
+		t.Errorf("Expected a random step marker in the batch script, got %q", scripts)
+	}
+}
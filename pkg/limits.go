@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"context"
+	"sync"
+)
+
+// DaemonLimits bounds the Docker API operations buildvault runs at the same time against one daemon,
+// independent of how many tasks run in parallel. Older daemons degrade badly with dozens of attached
+// exec streams or archive transfers open at once.
+type DaemonLimits struct {
+	MaxExecs  int // Concurrent execs (commands, mkdir, hashing), defaults to DefaultMaxExecs
+	MaxCopies int // Concurrent archive transfers into or out of containers, defaults to DefaultMaxCopies
+}
+
+const (
+	DefaultMaxExecs  = 16
+	DefaultMaxCopies = 8
+)
+
+// daemonLimiter holds the semaphores of one daemon
+type daemonLimiter struct {
+	execs  chan struct{}
+	copies chan struct{}
+}
+
+// daemonLimiters maps daemons, by the host of their clients, to their limiters. All clients of a
+// daemon share its limits.
+var daemonLimiters = struct {
+	sync.Mutex
+	limiters map[string]*daemonLimiter
+}{limiters: map[string]*daemonLimiter{}}
+
+// SetDaemonLimits sets the limits for operations on the daemon of cli. Zero values select the defaults.
+// Operations already waiting keep the previous limits.
+func SetDaemonLimits(cli DaemonAPI, limits DaemonLimits) {
+	daemonLimiters.Lock()
+	defer daemonLimiters.Unlock()
+	daemonLimiters.limiters[cli.DaemonHost()] = newDaemonLimiter(limits)
+}
+
+func newDaemonLimiter(limits DaemonLimits) *daemonLimiter {
+	if limits.MaxExecs <= 0 {
+		limits.MaxExecs = DefaultMaxExecs
+	}
+	if limits.MaxCopies <= 0 {
+		limits.MaxCopies = DefaultMaxCopies
+	}
+	return &daemonLimiter{
+		execs:  make(chan struct{}, limits.MaxExecs),
+		copies: make(chan struct{}, limits.MaxCopies),
+	}
+}
+
+// limiterFor returns the limiter of the daemon at host
+func limiterFor(host string) *daemonLimiter {
+	daemonLimiters.Lock()
+	defer daemonLimiters.Unlock()
+	limiter, ok := daemonLimiters.limiters[host]
+	if !ok {
+		limiter = newDaemonLimiter(DaemonLimits{})
+		daemonLimiters.limiters[host] = limiter
+	}
+	return limiter
+}
+
+// acquire takes a slot of the semaphore and returns the function releasing it
+func acquire(ctx context.Context, semaphore chan struct{}) (func(), error) {
+	select {
+	case semaphore <- struct{}{}:
+		return func() { <-semaphore }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// acquireExec waits until another exec may run on the daemon of cli
+func acquireExec(ctx context.Context, cli ExecAPI) (func(), error) {
+	return acquire(ctx, limiterFor(cli.DaemonHost()).execs)
+}
+
+// acquireCopy waits until another archive transfer may run on the daemon of cli. A copy between two
+// containers holds a single slot for both directions, and must not acquire another copy slot inside.
+func acquireCopy(ctx context.Context, cli ContainerFileAPI) (func(), error) {
+	return acquire(ctx, limiterFor(cli.DaemonHost()).copies)
+}
@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+)
+
+func TestDaemonLimits(t *testing.T) {
+	cli, err := client.NewClientWithOpts(client.WithHost("tcp://limits-test:2375"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetDaemonLimits(cli, DaemonLimits{MaxExecs: 1})
+
+	release, err := acquireExec(context.Background(), cli)
+	if err != nil {
+		t.Fatalf("Failed to acquire exec slot: %v", err)
+	}
+
+	// The only slot is taken, a second exec waits until its context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := acquireExec(ctx, cli); err == nil {
+		t.Errorf("Expected the second exec to wait")
+	}
+
+	// Another client of the same daemon shares its limits
+	other, err := client.NewClientWithOpts(client.WithHost("tcp://limits-test:2375"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := acquireExec(ctx, other); err == nil {
+		t.Errorf("Expected an exec through another client of the daemon to wait")
+	}
+
+	// Copies are limited separately
+	releaseCopy, err := acquireCopy(context.Background(), cli)
+	if err != nil {
+		t.Fatalf("Failed to acquire copy slot: %v", err)
+	}
+	releaseCopy()
+
+	release()
+	release, err = acquireExec(context.Background(), cli)
+	if err != nil {
+		t.Errorf("Expected the released slot to be available: %v", err)
+	}
+	release()
+
+	if cap(limiterFor(cli.DaemonHost()).copies) != DefaultMaxCopies {
+		t.Errorf("Expected the default copy limit")
+	}
+}
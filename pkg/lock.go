@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// Runs on the same host executing a task with the same hash share its container name. A lock file per
+// container name makes them take turns: the first removes and recreates the container, the others wait
+// for it and then usually find its outputs in the artifact store.
+
+// lockDir holds the lock files of task containers
+var lockDir = filepath.Join(os.TempDir(), "buildvault-locks")
+
+// lockRetryDelay is how often a waiting run checks whether the lock was released
+const lockRetryDelay = 200 * time.Millisecond
+
+// ErrTaskLocked is returned when another run kept executing a task longer than the lock timeout.
+var ErrTaskLocked = errors.New("task is locked by another run")
+
+// lockContainer takes the lock of the container name of t, waiting for a concurrent run holding it.
+// The returned function releases the lock.
+func (t *Task) lockContainer(ctx context.Context, name string) (func(), error) {
+	if err := os.MkdirAll(lockDir, 0o777); err != nil {
+		return nil, fmt.Errorf("error creating lock directory: %w", err)
+	}
+	lock := flock.New(filepath.Join(lockDir, name+".lock"))
+
+	locked, err := lock.TryLock()
+	if err != nil {
+		return nil, fmt.Errorf("error locking container %s: %w", name, err)
+	}
+	if !locked {
+		fmt.Printf("Task '%s' is executed by another run, waiting for it to finish\n", t.Name)
+		waitCtx, cancel := ctx, context.CancelFunc(func() {})
+		if t.options.lockTimeout > 0 {
+			waitCtx, cancel = context.WithTimeout(ctx, t.options.lockTimeout)
+		}
+		defer cancel()
+		locked, err = lock.TryLockContext(waitCtx, lockRetryDelay)
+		switch {
+		case locked:
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case errors.Is(err, context.DeadlineExceeded):
+			return nil, fmt.Errorf("%w: task '%s' (container %s) still executes after %s", ErrTaskLocked, t.Name, name, t.options.lockTimeout)
+		default:
+			return nil, fmt.Errorf("error locking container %s: %w", name, err)
+		}
+	}
+	return func() { lock.Unlock() }, nil
+}
@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockContainer(t *testing.T) {
+	lockDir = t.TempDir()
+	ctx := context.Background()
+
+	first := &Task{Name: "build"}
+	unlock, err := first.lockContainer(ctx, "buildvault_build_abc")
+	if err != nil {
+		t.Fatalf("Expected the first run to take the lock, got %v", err)
+	}
+
+	second := &Task{Name: "build", options: executeOptions{lockTimeout: 300 * time.Millisecond}}
+	if _, err := second.lockContainer(ctx, "buildvault_build_abc"); !errors.Is(err, ErrTaskLocked) {
+		t.Fatalf("Expected the second run to time out waiting for the lock, got %v", err)
+	}
+
+	other := &Task{Name: "test"}
+	unlockOther, err := other.lockContainer(ctx, "buildvault_test_abc")
+	if err != nil {
+		t.Fatalf("Expected containers of other tasks not to be locked, got %v", err)
+	}
+	unlockOther()
+
+	time.AfterFunc(100*time.Millisecond, unlock)
+	second.options.lockTimeout = 0
+	unlock, err = second.lockContainer(ctx, "buildvault_build_abc")
+	if err != nil {
+		t.Fatalf("Expected the second run to take the lock once it is released, got %v", err)
+	}
+	unlock()
+}
@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Environment variables describing the run, set for every command of a task
+const (
+	envTask     = "BUILDVAULT_TASK"      // Name of the task
+	envRunID    = "BUILDVAULT_RUN_ID"    // ID shared by all tasks of one buildvault invocation
+	envHash     = "BUILDVAULT_HASH"      // Hash of the task, as in its container name
+	envCacheHit = "BUILDVAULT_CACHE_HIT" // Comma-separated direct dependencies restored from the artifact store
+)
+
+// Version is the version of buildvault, recorded in the labels of its containers. Release builds set it
+// with -ldflags "-X github.com/benjaminstrasser/buildvault/pkg.Version=<version>".
+var Version = buildVersion()
+
+// buildVersion is the module version buildvault was built from, like v1.2.0 for go install ...@v1.2.0
+func buildVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+var (
+	runIDOnce sync.Once
+	runID     string
+)
+
+// RunID returns the ID of this buildvault invocation. A BUILDVAULT_RUN_ID in the environment is reused,
+// so nested invocations (e.g. buildvault started by a CI job of another run) share their ID.
+func RunID() string {
+	runIDOnce.Do(func() {
+		if id := os.Getenv(envRunID); id != "" {
+			runID = id
+			return
+		}
+		suffix := make([]byte, 4)
+		rand.Read(suffix)
+		runID = time.Now().UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix)
+	})
+	return runID
+}
+
+// metadataEnv returns the run metadata provided to the commands of t. It changes on every run, so it
+// is never part of the task hash.
+func (t *Task) metadataEnv() []string {
+	var cacheHits []string
+	for _, dependency := range t.sortedDependencies() {
+		if dependency.Task.cacheHit {
+			cacheHits = append(cacheHits, dependency.Task.Name)
+		}
+	}
+
+	return []string{
+		envTask + "=" + t.Name,
+		envRunID + "=" + RunID(),
+		envHash + "=" + t.generateHash(),
+		envCacheHit + "=" + strings.Join(cacheHits, ","),
+	}
+}
+
+// execEnv returns the environment of command execs: the run metadata and the environment secrets
+func (t *Task) execEnv() []string {
+	return append(t.metadataEnv(), t.secretEnv()...)
+}
@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMetadataEnv(t *testing.T) {
+	cached := &Task{Name: "deps", BaseImage: "alpine", cacheHit: true}
+	fresh := &Task{Name: "generate", BaseImage: "alpine"}
+	task := &Task{
+		Name:         "build",
+		BaseImage:    "alpine",
+		Dependencies: []Dependency{{Task: fresh}, {Task: cached}},
+		Secrets:      []Secret{{Name: "TOKEN", Value: "secret"}},
+	}
+
+	env := task.execEnv()
+	for _, want := range []string{
+		"BUILDVAULT_TASK=build",
+		"BUILDVAULT_RUN_ID=" + RunID(),
+		"BUILDVAULT_HASH=" + task.generateHash(),
+		"BUILDVAULT_CACHE_HIT=deps",
+		"TOKEN=secret",
+	} {
+		if !slices.Contains(env, want) {
+			t.Errorf("Expected %s in %v", want, env)
+		}
+	}
+
+	if RunID() == "" || RunID() != RunID() {
+		t.Errorf("The run ID should be stable within a process")
+	}
+
+	before := task.generateHash()
+	cached.cacheHit = false
+	if task.generateHash() != before {
+		t.Errorf("Run metadata should not be part of the task hash")
+	}
+}
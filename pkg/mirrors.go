@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// Image mirrors are registries tried in order before the registry of a task's image, e.g. an internal
+// pull-through cache of Docker Hub. An image pulled from a mirror is tagged with the task's image
+// reference, so the rest of the run and later runs find it locally under that name.
+
+// mirrorReference returns image as pulled from mirror, e.g. mirror.example.com/library/alpine:latest
+// for alpine
+func mirrorReference(image, mirror string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", fmt.Errorf("error parsing image %s: %w", image, err)
+	}
+	named = reference.TagNameOnly(named)
+
+	ref := strings.TrimSuffix(mirror, "/") + "/" + reference.Path(named)
+	if tagged, ok := named.(reference.Tagged); ok {
+		ref += ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		ref += "@" + digested.Digest().String()
+	}
+	return ref, nil
+}
+
+// pullMirroredImage makes the base image of t available locally, pulling it from the first of its
+// mirrors that has it and from its own registry last. The reference it came from is kept as its source.
+func (t *Task) pullMirroredImage(ctx context.Context, cli ImageAPI) error {
+	exists, err := imageAvailable(ctx, cli, t.BaseImage, t.Platform)
+	if err != nil {
+		return fmt.Errorf("failed to check for image: %w", err)
+	}
+	if exists {
+		fmt.Printf("Image %s already exists locally\n", t.BaseImage)
+		return nil
+	}
+
+	var errs []error
+	for _, mirror := range t.ImageMirrors {
+		source, err := mirrorReference(t.BaseImage, mirror)
+		if err != nil {
+			return err
+		}
+		if err := pullFrom(ctx, cli, source, t.Platform); err != nil {
+			fmt.Printf("Failed to pull image %s from mirror %s: %v\n", t.BaseImage, mirror, err)
+			errs = append(errs, err)
+			continue
+		}
+		if err := cli.ImageTag(ctx, source, t.BaseImage); err != nil {
+			return fmt.Errorf("error tagging image %s as %s: %w", source, t.BaseImage, err)
+		}
+		t.imageSource = source
+		return nil
+	}
+
+	if err := pullFrom(ctx, cli, t.BaseImage, t.Platform); err != nil {
+		return fmt.Errorf("failed to pull image %s from its mirrors and its registry: %w", t.BaseImage, errors.Join(append(errs, err)...))
+	}
+	t.imageSource = t.BaseImage
+	return nil
+}
@@ -0,0 +1,19 @@
+package pkg
+
+import "testing"
+
+func TestMirrorReference(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := map[string]string{
+		"alpine":                      "mirror.example.com/library/alpine:latest",
+		"node:20":                     "mirror.example.com/library/node:20",
+		"ghcr.io/acme/tool:1.2":       "mirror.example.com/acme/tool:1.2",
+		"quay.io/org/image@" + digest: "mirror.example.com/org/image@" + digest,
+	}
+	for image, want := range tests {
+		ref, err := mirrorReference(image, "mirror.example.com/")
+		if err != nil || ref != want {
+			t.Errorf("Expected %s for %s, got %s (%v)", want, image, ref, err)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// Mount types of task mounts
+const (
+	MountBind   = "bind"   // Host directory
+	MountVolume = "volume" // Named Docker volume, created on first use
+	MountTmpfs  = "tmpfs"  // In-memory filesystem, empty on every start
+)
+
+// Mount is a host directory, named volume or tmpfs mounted into the task container. Mounts are not
+// part of the task hash: they are meant for caches (e.g. /root/.m2 or /go/pkg/mod) that speed up
+// commands without changing their results.
+type Mount struct {
+	Type     string `json:"type" yaml:"type"`           // MountBind, MountVolume or MountTmpfs
+	Source   string `json:"source" yaml:"source"`       // Host path of a bind mount or name of a volume, empty for tmpfs
+	Target   string `json:"target" yaml:"target"`       // Absolute path in the container
+	ReadOnly bool   `json:"read_only" yaml:"read_only"` // Mount read-only
+}
+
+// validateMounts checks that the mounts and cache directories of t can be passed to Docker
+func (t *Task) validateMounts() error {
+	for _, m := range t.Mounts {
+		switch m.Type {
+		case MountBind, MountVolume:
+			if m.Source == "" {
+				return fmt.Errorf("%s mount at %s of task '%s' needs a source", m.Type, m.Target, t.Name)
+			}
+		case MountTmpfs:
+			if m.Source != "" {
+				return fmt.Errorf("tmpfs mount at %s of task '%s' cannot have a source", m.Target, t.Name)
+			}
+		default:
+			return fmt.Errorf("unknown mount type '%s' in task '%s', expected bind, volume or tmpfs", m.Type, t.Name)
+		}
+		if !path.IsAbs(m.Target) {
+			return fmt.Errorf("mount target %s of task '%s' must be an absolute path", m.Target, t.Name)
+		}
+	}
+	for _, dir := range t.CacheDirs {
+		if !path.IsAbs(dir) {
+			return fmt.Errorf("cache directory %s of task '%s' must be an absolute path", dir, t.Name)
+		}
+	}
+	return nil
+}
+
+// containerMounts returns the mounts of the task container: the declared mounts, the cache volumes, the
+// writable paths of a read-only root filesystem and the tmpfs holding the file secrets
+func (t *Task) containerMounts() []mount.Mount {
+	var mounts []mount.Mount
+	for _, m := range t.Mounts {
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.Type(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+	mounts = append(mounts, t.cacheVolumeMounts()...)
+	mounts = append(mounts, t.writableMounts()...)
+	if t.hasSecretFiles() {
+		// Secret files must not end up in the container's filesystem layer. The tmpfs belongs to root, so
+		// another user needs a sticky, world-writable directory; the files themselves are only readable by it.
+		// The daemon passes the mode on as octal digits, hence 0o1777 instead of os.ModeSticky.
+		mode := os.FileMode(0o700)
+		if t.containerUser() != "" {
+			mode = 0o1777
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:         mount.TypeTmpfs,
+			Target:       secretsDir,
+			TmpfsOptions: &mount.TmpfsOptions{Mode: mode},
+		})
+	}
+	return mounts
+}
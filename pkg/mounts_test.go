@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestValidateMounts(t *testing.T) {
+	valid := &Task{Name: "build", Mounts: []Mount{
+		{Type: MountVolume, Source: "m2", Target: "/root/.m2"},
+		{Type: MountBind, Source: "/tmp/cache", Target: "/cache", ReadOnly: true},
+		{Type: MountTmpfs, Target: "/scratch"},
+	}}
+	if err := valid.validateMounts(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	for _, m := range []Mount{
+		{Type: MountVolume, Target: "/root/.m2"},
+		{Type: MountTmpfs, Source: "data", Target: "/scratch"},
+		{Type: MountBind, Source: "/tmp", Target: "relative"},
+		{Type: "overlay", Source: "x", Target: "/x"},
+	} {
+		task := &Task{Name: "build", Mounts: []Mount{m}}
+		if err := task.validateMounts(); err == nil {
+			t.Errorf("Expected an error for mount %+v", m)
+		}
+	}
+}
+
+func TestContainerMounts(t *testing.T) {
+	task := &Task{
+		Name:    "build",
+		Mounts:  []Mount{{Type: MountVolume, Source: "gomod", Target: "/go/pkg/mod"}},
+		Secrets: []Secret{{Name: "key", Value: "x", Mount: true}},
+	}
+
+	mounts := task.containerMounts()
+	if len(mounts) != 2 {
+		t.Fatalf("Expected the declared mount and the secrets tmpfs, got %+v", mounts)
+	}
+	if mounts[0].Type != mount.TypeVolume || mounts[0].Source != "gomod" || mounts[0].Target != "/go/pkg/mod" {
+		t.Errorf("Unexpected mount %+v", mounts[0])
+	}
+	if mounts[1].Type != mount.TypeTmpfs || mounts[1].Target != secretsDir {
+		t.Errorf("Unexpected secrets mount %+v", mounts[1])
+	}
+
+	// Containers are reused for a hash, so their mounts are part of it, except host paths
+	before := task.generateHash()
+	task.Mounts = nil
+	if task.generateHash() == before {
+		t.Errorf("Mounts should be part of the task hash")
+	}
+	bound := &Task{Name: "build", BaseImage: "golang", Mounts: []Mount{{Type: MountBind, Source: "/home/a/src", Target: "/src"}}}
+	before = bound.generateHash()
+	bound.Mounts[0].Source = "/home/b/src"
+	if bound.generateHash() != before {
+		t.Errorf("Host paths of bind mounts should not be part of the task hash")
+	}
+}
+
+func TestLoadPipelineMounts(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "buildvault.yaml")
+	os.WriteFile(path, []byte(`
+tasks:
+  - name: build
+    image: maven
+    mounts:
+      - type: volume
+        source: m2
+        target: /root/.m2
+      - type: bind
+        source: config
+        target: /config
+        read_only: true
+`), 0o644)
+
+	pipeline, err := LoadPipeline(path)
+	if err != nil {
+		t.Fatalf("Failed to load pipeline: %v", err)
+	}
+	mounts := pipeline.Tasks[0].Mounts
+	if len(mounts) != 2 || mounts[0].Source != "m2" || mounts[1].Source != filepath.Join(dir, "config") || !mounts[1].ReadOnly {
+		t.Errorf("Unexpected mounts %+v", mounts)
+	}
+
+	if _, err := ParsePipeline([]byte("tasks:\n  - name: build\n    image: maven\n    mounts:\n      - type: nfs\n        target: /x\n")); err == nil {
+		t.Errorf("Expected an error for an unknown mount type")
+	}
+}
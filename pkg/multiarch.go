@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/containerd/platforms"
+)
+
+// A task fanned out over platforms becomes one instance per platform plus a virtual task under the
+// original name depending on all of them. Dependents of the original name run after every instance.
+// When the instances push their output images, the virtual task assembles them into a manifest list.
+
+// FanOutPlatforms creates an instance of template per platform, named like build-linux-arm64, with
+// its output image tagged per platform, e.g. app:1.0-linux-arm64. The returned group task is a virtual
+// task named like template that depends on the instances. If template pushes its output image, the
+// group pushes a manifest list of the instances' images as that image.
+func FanOutPlatforms(template *Task, platformNames []string) ([]*Task, *Task, error) {
+	if len(platformNames) == 0 {
+		return nil, nil, fmt.Errorf("task '%s' fans out over no platforms", template.Name)
+	}
+	if template.Virtual || template.Container != "" {
+		return nil, nil, fmt.Errorf("task '%s' has no image of its own to fan out over platforms", template.Name)
+	}
+
+	group := &Task{
+		Name:         template.Name,
+		Virtual:      true,
+		Stage:        template.Stage,
+		AllowFailure: template.AllowFailure,
+		Vars:         template.Vars,
+		pipelineID:   template.pipelineID,
+	}
+	if template.Push && template.OutputImage != "" {
+		group.ManifestList = template.OutputImage
+	}
+
+	var instances []*Task
+	seen := map[string]bool{}
+	for _, name := range platformNames {
+		platform, err := parsePlatform(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("task '%s': %w", template.Name, err)
+		}
+		suffix := strings.ReplaceAll(platforms.Format(platforms.Normalize(*platform)), "/", "-")
+		if seen[suffix] {
+			return nil, nil, fmt.Errorf("task '%s' fans out over platform %s twice", template.Name, name)
+		}
+		seen[suffix] = true
+
+		instance := template.clone()
+		instance.Name = template.Name + "-" + suffix
+		instance.Platform = name
+		if template.OutputImage != "" {
+			instance.OutputImage = platformTag(template.OutputImage, suffix)
+		}
+		instances = append(instances, instance)
+		group.Dependencies = append(group.Dependencies, Dependency{Task: instance})
+	}
+	return instances, group, nil
+}
+
+// platformTag appends the platform suffix to the tag of the image ref, latest if it has none. The
+// reference may still contain variables, so it is not parsed.
+func platformTag(ref, suffix string) string {
+	if strings.Contains(ref[strings.LastIndex(ref, "/")+1:], ":") {
+		return ref + "-" + suffix
+	}
+	return ref + ":latest-" + suffix
+}
+
+// clone copies t before it ran, with its own copies of the fields that are rewritten in place, like
+// the variables in commands
+func (t *Task) clone() *Task {
+	c := *t
+	c.Commands = slices.Clone(t.Commands)
+	c.Shell = slices.Clone(t.Shell)
+	c.Cmd = slices.Clone(t.Cmd)
+	for i := range c.Cmd {
+		c.Cmd[i] = slices.Clone(c.Cmd[i])
+	}
+	c.Outputs = slices.Clone(t.Outputs)
+	c.NamedOutputs = maps.Clone(t.NamedOutputs)
+	c.Exports = slices.Clone(t.Exports)
+	c.Dependencies = slices.Clone(t.Dependencies)
+	for i := range c.Dependencies {
+		c.Dependencies[i].Artifacts = slices.Clone(c.Dependencies[i].Artifacts)
+	}
+	if t.Build != nil {
+		build := *t.Build
+		build.Args = maps.Clone(t.Build.Args)
+		c.Build = &build
+	}
+	return &c
+}
+
+// pushManifestList pushes the images its dependencies pushed as one manifest list named ManifestList.
+// The Docker API cannot create manifest lists, so docker buildx imagetools assembles it in the registry.
+func (t *Task) pushManifestList(ctx context.Context) error {
+	var sources []string
+	for _, dependency := range t.sortedDependencies() {
+		if dependency.Task.pushedImage == "" {
+			return fmt.Errorf("task '%s' cannot push manifest list %s, its dependency '%s' pushed no image", t.Name, t.ManifestList, dependency.Task.Name)
+		}
+		sources = append(sources, dependency.Task.pushedImage)
+	}
+
+	fmt.Printf("Pushing manifest list %s of %s\n", t.ManifestList, strings.Join(sources, ", "))
+	args := append([]string{"buildx", "imagetools", "create", "--tag", t.ManifestList}, sources...)
+	if out, err := exec.CommandContext(ctx, "docker", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("error pushing manifest list %s: %w: %s", t.ManifestList, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFanOutPlatforms(t *testing.T) {
+	template := &Task{Name: "image", BaseImage: "golang:1.23", Commands: []string{"go build -o /out/app"}, OutputImage: "registry.example.com:5000/app:1.0", Push: true}
+	instances, group, err := FanOutPlatforms(template, []string{"linux/amd64", "linux/arm64"})
+	if err != nil {
+		t.Fatalf("Expected the task to fan out, got %v", err)
+	}
+	if len(instances) != 2 || instances[0].Name != "image-linux-amd64" || instances[1].Name != "image-linux-arm64" {
+		t.Fatalf("Expected an instance per platform, got %v", taskNames(instances))
+	}
+	if instances[1].Platform != "linux/arm64" || instances[1].OutputImage != "registry.example.com:5000/app:1.0-linux-arm64" {
+		t.Errorf("Expected the platform and a tag per platform, got %s and %s", instances[1].Platform, instances[1].OutputImage)
+	}
+	if instances[0].generateHash() == instances[1].generateHash() {
+		t.Error("Expected the instances to have hashes of their own")
+	}
+	if group.Name != "image" || !group.Virtual || group.ManifestList != "registry.example.com:5000/app:1.0" || len(group.Dependencies) != 2 {
+		t.Errorf("Expected a virtual group pushing the manifest list, got %+v", group)
+	}
+
+	// Variables are resolved in place, instances must not share what they rewrite
+	instances[0].Commands[0] = "changed"
+	if template.Commands[0] == "changed" || instances[1].Commands[0] == "changed" {
+		t.Error("Expected the instances to have commands of their own")
+	}
+
+	if got := platformTag("app", "linux-arm-v7"); got != "app:latest-linux-arm-v7" {
+		t.Errorf("Expected the latest tag with the suffix, got %s", got)
+	}
+	if _, _, err := FanOutPlatforms(template, []string{"linux/arm64", "linux/aarch64"}); err == nil {
+		t.Error("Expected the same platform twice to be rejected")
+	}
+}
+
+func TestParsePipelinePlatforms(t *testing.T) {
+	data := `tasks:
+  - name: build
+    image: golang:1.23
+    commands: ["go build ./..."]
+    platforms: [linux/amd64, linux/arm64]
+  - name: release
+    image: alpine
+    commands: ["true"]
+    dependencies:
+      - task: build
+`
+	pipeline, err := ParsePipeline([]byte(data))
+	if err != nil {
+		t.Fatalf("Expected the pipeline to parse, got %v", err)
+	}
+	if got := strings.Join(taskNames(pipeline.Tasks), ","); got != "build-linux-amd64,build-linux-arm64,build,release" {
+		t.Errorf("Expected the instances before their group, got %s", got)
+	}
+	release, _ := pipeline.Task("release")
+	if group := release.Dependencies[0].Task; !group.Virtual || len(group.Dependencies) != 2 {
+		t.Errorf("Expected release to depend on the group of the instances")
+	}
+	if group, _ := pipeline.Task("build"); group.ManifestList != "" {
+		t.Errorf("Expected no manifest list without a pushed output image, got %s", group.ManifestList)
+	}
+
+	copying := strings.Replace(data, "      - task: build\n", "      - task: build\n        artifacts: [{from: /out, to: /in}]\n", 1)
+	if _, err := ParsePipeline([]byte(copying)); err == nil || !strings.Contains(err.Error(), "build-linux-amd64") {
+		t.Errorf("Expected copying artifacts of the group to point to an instance, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// A namespace isolates the Docker resources of buildvault processes sharing a daemon, like the CI agents
+// of one host or several checkouts of a project. The namespace is part of the names of task containers,
+// services, cache volumes and snapshots, and they are labelled with it. Lookups, garbage collection and
+// prune only ever see the resources of their own namespace; the default namespace is empty, which keeps
+// the names of resources created before namespaces existed.
+
+// labelNamespace is the label of the namespace of a resource, absent in the default namespace
+const labelNamespace = "buildvault.namespace"
+
+// namespaceValid matches namespaces. Underscores separate the segments of resource names and image
+// references have to be lowercase.
+var namespaceValid = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]*$`)
+
+// namespace of the resources of this process, see SetNamespace
+var namespace string
+
+// SetNamespace isolates the Docker resources of this process from those of other namespaces. It has to
+// be called before any task executes.
+func SetNamespace(ns string) error {
+	if ns != "" && !namespaceValid.MatchString(ns) {
+		return configErrorf("invalid namespace '%s', expected lowercase letters, digits, dots and dashes", ns)
+	}
+	namespace = ns
+	return nil
+}
+
+// Namespace returns the namespace of the Docker resources of this process, empty for the default one.
+func Namespace() string {
+	return namespace
+}
+
+// namespaced returns the name prefix of resources of the current namespace, the namespace appended to
+// prefix before its trailing underscore, e.g. buildvault.ci-7_ for buildvault_
+func namespaced(prefix string) string {
+	if namespace == "" {
+		return prefix
+	}
+	return fmt.Sprintf("%s.%s_", strings.TrimSuffix(prefix, "_"), namespace)
+}
+
+// namespacedRepository returns the image repository of the current namespace, the namespace appended as
+// path component to repository
+func namespacedRepository(repository string) string {
+	if namespace == "" {
+		return repository
+	}
+	return repository + "/" + namespace
+}
+
+// containerNamePrefix returns the prefix of the names of task containers
+func containerNamePrefix() string {
+	return namespaced("buildvault_")
+}
+
+// withNamespace adds the namespace label to the labels of a new resource
+func withNamespace(labels map[string]string) map[string]string {
+	if namespace != "" {
+		labels[labelNamespace] = namespace
+	}
+	return labels
+}
+
+// inNamespace reports whether a resource with labels belongs to the current namespace
+func inNamespace(labels map[string]string) bool {
+	return labels[labelNamespace] == namespace
+}
@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/volume"
+)
+
+// setNamespace switches to ns for the rest of the test
+func setNamespace(t *testing.T, ns string) {
+	if err := SetNamespace(ns); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { SetNamespace("") })
+}
+
+func TestSetNamespace(t *testing.T) {
+	for _, ns := range []string{"CI", "ci_7", "-ci", "ci/7"} {
+		var configErr *ConfigError
+		if err := SetNamespace(ns); !errors.As(err, &configErr) {
+			t.Errorf("Expected namespace '%s' to be rejected, got %v", ns, err)
+		}
+	}
+	if Namespace() != "" {
+		t.Errorf("Rejected namespaces should not be set, got '%s'", Namespace())
+	}
+}
+
+func TestNamespacedNames(t *testing.T) {
+	task := &Task{Name: "build", BaseImage: "alpine", Services: []Service{{Name: "db", Image: "postgres"}}}
+	legacy := container.Summary{Names: []string{"/" + task.generateContainerName()}}
+	unnamespaced := container.Summary{Labels: task.containerLabels()}
+
+	setNamespace(t, "ci-7")
+	name := task.generateContainerName()
+	if name != "buildvault.ci-7_build_"+task.generateHash() {
+		t.Errorf("Unexpected container name %s", name)
+	}
+	if taskName, _, ok := parseContainerName(name); !ok || taskName != "build" {
+		t.Errorf("Expected the namespaced name to be parsed, got '%s' %v", taskName, ok)
+	}
+	if service := serviceContainerName(name, task.Services[0]); service != "buildvault-service.ci-7_build_"+task.generateHash()+"_db" {
+		t.Errorf("Unexpected service container name %s", service)
+	}
+	if volume := cacheVolumeName("build", "/root/.m2"); !strings.HasPrefix(volume, "buildvault_cache.ci-7_build_") {
+		t.Errorf("Unexpected cache volume name %s", volume)
+	}
+	if reference := snapshotReference("build", "abc"); reference != "buildvault-debug/ci-7/build:abc" {
+		t.Errorf("Unexpected snapshot reference %s", reference)
+	}
+
+	if _, _, ok := taskContainer(container.Summary{Labels: task.containerLabels()}); !ok {
+		t.Errorf("Expected a container of the namespace to be recognized")
+	}
+	for _, other := range []container.Summary{legacy, unnamespaced} {
+		if _, _, ok := taskContainer(other); ok {
+			t.Errorf("Expected the container %+v of the default namespace to be ignored", other)
+		}
+	}
+
+	volumes := []*volume.Volume{
+		{Name: "a", Labels: map[string]string{labelManaged: "true", labelCacheDir: "/a", labelNamespace: "ci-7"}},
+		{Name: "b", Labels: map[string]string{labelManaged: "true", labelCacheDir: "/b"}},
+	}
+	if selected := selectCacheVolumes(volumes, PruneOptions{}, time.Now()); len(selected) != 1 || selected[0].Name != "a" {
+		t.Errorf("Expected only the cache volume of the namespace, got %+v", selected)
+	}
+}
+
+func TestNamespaceIsolation(t *testing.T) {
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:latest"}, Files: map[string]string{"/bin/sh": ""}})
+	execute := func(ns string) {
+		setNamespace(t, ns)
+		task := &Task{Name: "build", BaseImage: "alpine", Commands: []string{"make"}}
+		if err := task.Execute(context.Background(), cli); err != nil {
+			t.Fatalf("Failed to execute task in namespace '%s': %v", ns, err)
+		}
+	}
+	execute("agent-1")
+	execute("agent-2")
+	execute("")
+
+	for _, ns := range []string{"agent-1", "agent-2", ""} {
+		setNamespace(t, ns)
+		containers, err := listBuildvaultContainers(context.Background(), cli, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(containers) != 1 || !inNamespace(containers[0].Labels) {
+			t.Errorf("Expected namespace '%s' to see only its own container, got %d", ns, len(containers))
+		}
+	}
+}
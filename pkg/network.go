@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Network modes of task containers besides user-defined networks
+const (
+	NetworkNone   = "none"   // No network access at all
+	NetworkBridge = "bridge" // Docker's default bridge network
+	NetworkHost   = "host"   // The network stack of the Docker host
+)
+
+// Network configures the network of a task container. Like mounts it is not part of the task hash: it
+// decides what a task can reach, not what it produces.
+type Network struct {
+	Mode       string   `json:"mode" yaml:"mode"`               // none, bridge, host or the name of a user-defined network; empty for bridge
+	ExtraHosts []string `json:"extra_hosts" yaml:"extra_hosts"` // Additional /etc/hosts entries as "host:ip"
+	DNS        []string `json:"dns" yaml:"dns"`                 // DNS server addresses
+}
+
+// validateNetwork checks the network configuration of t
+func (t *Task) validateNetwork() error {
+	if t.Network == nil {
+		return nil
+	}
+	for _, entry := range t.Network.ExtraHosts {
+		host, ip, ok := strings.Cut(entry, ":")
+		if !ok || host == "" || (ip != "host-gateway" && net.ParseIP(ip) == nil) {
+			return fmt.Errorf("extra host '%s' of task '%s' must be host:ip", entry, t.Name)
+		}
+	}
+	for _, server := range t.Network.DNS {
+		if net.ParseIP(server) == nil {
+			return fmt.Errorf("DNS server '%s' of task '%s' is not an IP address", server, t.Name)
+		}
+	}
+	if t.Network.Mode == NetworkHost || t.Network.Mode == NetworkNone {
+		if len(t.Network.ExtraHosts) > 0 || len(t.Network.DNS) > 0 {
+			return fmt.Errorf("task '%s' uses the %s network, which does not support extra hosts or DNS servers", t.Name, t.Network.Mode)
+		}
+	}
+	return nil
+}
+
+// hostConfig returns the host configuration of the task container: its mounts, devices, privileges and
+// network
+func (t *Task) hostConfig() *container.HostConfig {
+	hostConfig := &container.HostConfig{Mounts: t.containerMounts(), Resources: t.deviceResources(), ReadonlyRootfs: t.ReadOnlyRootfs}
+	t.applySecurity(hostConfig)
+	if t.Network != nil {
+		hostConfig.NetworkMode = container.NetworkMode(t.Network.Mode)
+		hostConfig.ExtraHosts = t.Network.ExtraHosts
+		hostConfig.DNS = t.Network.DNS
+	}
+	return hostConfig
+}
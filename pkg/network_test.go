@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"testing"
+)
+
+func TestValidateNetwork(t *testing.T) {
+	valid := &Task{Name: "test", Network: &Network{
+		Mode:       "ci-services",
+		ExtraHosts: []string{"db:10.0.0.5", "host.docker.internal:host-gateway"},
+		DNS:        []string{"1.1.1.1"},
+	}}
+	if err := valid.validateNetwork(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	for _, network := range []*Network{
+		{ExtraHosts: []string{"db"}},
+		{ExtraHosts: []string{"db:not-an-ip"}},
+		{DNS: []string{"dns.example.com"}},
+		{Mode: NetworkNone, DNS: []string{"1.1.1.1"}},
+	} {
+		task := &Task{Name: "test", Network: network}
+		if err := task.validateNetwork(); err == nil {
+			t.Errorf("Expected an error for %+v", network)
+		}
+	}
+}
+
+func TestHostConfigNetwork(t *testing.T) {
+	task := &Task{Name: "test", BaseImage: "alpine", Network: &Network{Mode: NetworkNone}}
+	hostConfig := task.hostConfig()
+	if !hostConfig.NetworkMode.IsNone() {
+		t.Errorf("Expected network mode none, got %s", hostConfig.NetworkMode)
+	}
+
+	before := task.generateHash()
+	task.Network = nil
+	if task.generateHash() != before {
+		t.Errorf("The network should not be part of the task hash")
+	}
+	if task.hostConfig().NetworkMode != "" {
+		t.Errorf("Tasks without a network should use Docker's default")
+	}
+}
+
+func TestParsePipelineNetwork(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+network:
+  mode: none
+tasks:
+  - name: build
+    image: alpine
+  - name: integration
+    image: alpine
+    network:
+      mode: services
+      extra_hosts: ["db:10.0.0.5"]
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+
+	build, _ := pipeline.Task("build")
+	integration, _ := pipeline.Task("integration")
+	if build.Network == nil || build.Network.Mode != NetworkNone {
+		t.Errorf("Tasks without a network should use the pipeline default, got %+v", build.Network)
+	}
+	if integration.Network.Mode != "services" || len(integration.Network.ExtraHosts) != 1 {
+		t.Errorf("Unexpected network %+v", integration.Network)
+	}
+}
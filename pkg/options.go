@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ExecuteOption configures a call of Execute. New settings are added as options, so the signature of
+// Execute stays stable.
+type ExecuteOption func(*executeOptions)
+
+// executeOptions are the settings of one call of Execute, passed on to the dependencies it executes
+type executeOptions struct {
+	force       bool
+	stdout      io.Writer
+	stderr      io.Writer
+	timeout     time.Duration
+	done        map[*Task]bool // Tasks that completed or were skipped during this run, shared with dependencies
+	doneMu      *sync.Mutex    // Guards done, as the tasks of a wave execute concurrently
+	conditions  *ConditionEnv  // What task conditions are evaluated against, detected when first needed
+	logLevel    LogLevel
+	cleanup     *containerCleanup // Containers removed at the end of the run, shared with dependencies
+	gc          bool
+	lockTimeout time.Duration // How long to wait for another run executing the same task, 0 for as long as it takes
+}
+
+// LogLevel controls how much of the command output of tasks is shown.
+type LogLevel int
+
+const (
+	LogNormal  LogLevel = iota // The output of every command
+	LogQuiet                   // Only the output of tasks whose commands fail
+	LogVerbose                 // Also the hash of every task and the exit code and duration of every command
+)
+
+// WithForce executes the task and its dependencies even if their outputs are in the artifact store.
+func WithForce() ExecuteOption {
+	return func(o *executeOptions) { o.force = true }
+}
+
+// WithStdout writes the standard output of commands to w instead of os.Stdout.
+func WithStdout(w io.Writer) ExecuteOption {
+	return func(o *executeOptions) { o.stdout = w }
+}
+
+// WithStderr writes the standard error of commands to w instead of os.Stderr.
+func WithStderr(w io.Writer) ExecuteOption {
+	return func(o *executeOptions) { o.stderr = w }
+}
+
+// WithTimeout cancels the execution, dependencies included, once it takes longer than d.
+func WithTimeout(d time.Duration) ExecuteOption {
+	return func(o *executeOptions) { o.timeout = d }
+}
+
+// WithConditionEnv evaluates the conditions of tasks against env instead of the detected environment.
+func WithConditionEnv(env ConditionEnv) ExecuteOption {
+	return func(o *executeOptions) { o.conditions = &env }
+}
+
+// WithLogLevel shows the command output of tasks according to level.
+func WithLogLevel(level LogLevel) ExecuteOption {
+	return func(o *executeOptions) { o.logLevel = level }
+}
+
+// WithGarbageCollection removes the outdated containers of the tasks of a run before it starts, see
+// CollectGarbage. It only applies to Pipeline.Run.
+func WithGarbageCollection() ExecuteOption {
+	return func(o *executeOptions) { o.gc = true }
+}
+
+// WithLockTimeout fails tasks that another run on the same host is still executing after waiting d for
+// it, instead of waiting as long as it takes.
+func WithLockTimeout(d time.Duration) ExecuteOption {
+	return func(o *executeOptions) { o.lockTimeout = d }
+}
+
+// inheritOptions passes the options of a dependent on to a dependency. The timeout is not, the context
+// of the dependency is already bounded by it.
+func inheritOptions(parent executeOptions) ExecuteOption {
+	return func(o *executeOptions) {
+		*o = parent
+		o.timeout = 0
+	}
+}
+
+func newExecuteOptions(opts []ExecuteOption) executeOptions {
+	options := executeOptions{stdout: os.Stdout, stderr: os.Stderr}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.done == nil {
+		options.done = map[*Task]bool{}
+	}
+	if options.doneMu == nil {
+		options.doneMu = &sync.Mutex{}
+	}
+	return options
+}
+
+// isDone reports whether t completed or was skipped during this run
+func (o executeOptions) isDone(t *Task) bool {
+	o.doneMu.Lock()
+	defer o.doneMu.Unlock()
+	return o.done[t]
+}
+
+// setDone records whether t completed or was skipped during this run
+func (o executeOptions) setDone(t *Task, done bool) {
+	o.doneMu.Lock()
+	defer o.doneMu.Unlock()
+	o.done[t] = done
+}
+
+// withTimeout bounds ctx by the timeout of the options, if they have one
+func (o executeOptions) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, o.timeout)
+}
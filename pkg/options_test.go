@@ -0,0 +1,27 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestExecuteOptions(t *testing.T) {
+	var out bytes.Buffer
+	options := newExecuteOptions([]ExecuteOption{WithForce(), WithStdout(&out), WithTimeout(time.Minute)})
+	if !options.force || options.stdout != &out || options.stderr == nil || options.timeout != time.Minute {
+		t.Errorf("Unexpected options %+v", options)
+	}
+
+	inherited := newExecuteOptions([]ExecuteOption{inheritOptions(options)})
+	if !inherited.force || inherited.stdout != &out || inherited.timeout != 0 {
+		t.Errorf("Dependencies should inherit everything but the timeout, got %+v", inherited)
+	}
+
+	ctx, cancel := options.withTimeout(context.Background())
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Errorf("Expected a deadline")
+	}
+}
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"sort"
+)
+
+// Everything derived from a task's configuration is iterated in a canonical order, so hashes, copies
+// and reports do not depend on declaration order or on Go's randomized map iteration.
+
+// sortedDependencies returns the dependencies of t ordered by task name
+func (t *Task) sortedDependencies() []Dependency {
+	dependencies := append([]Dependency{}, t.Dependencies...)
+	sort.SliceStable(dependencies, func(i, j int) bool {
+		return dependencies[i].Task.Name < dependencies[j].Task.Name
+	})
+	return dependencies
+}
+
+// sortedArtifacts returns artifacts ordered by destination, then source path
+func sortedArtifacts(artifacts []Artifact) []Artifact {
+	sorted := append([]Artifact{}, artifacts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].To != sorted[j].To {
+			return sorted[i].To < sorted[j].To
+		}
+		return sorted[i].From < sorted[j].From
+	})
+	return sorted
+}
+
+// sortedStrings returns a sorted copy of values
+func sortedStrings(values []string) []string {
+	sorted := append([]string{}, values...)
+	sort.Strings(sorted)
+	return sorted
+}
+
+// sortedSecrets returns a copy of secrets sorted by name
+func sortedSecrets(secrets []Secret) []Secret {
+	sorted := append([]Secret{}, secrets...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// artifactCopy is one artifact copied from a dependency into a task container
+type artifactCopy struct {
+	dependency *Task
+	artifact   Artifact
+}
+
+// artifactCopies returns all dependency artifacts of t ordered by destination path, then dependency
+// name and source path. Parent directories sort before paths inside them, so when destinations
+// overlap the more specific artifact is always copied last and wins.
+func (t *Task) artifactCopies() []artifactCopy {
+	var copies []artifactCopy
+	for _, dependency := range t.Dependencies {
+		for _, artifact := range dependency.Artifacts {
+			copies = append(copies, artifactCopy{dependency: dependency.Task, artifact: artifact})
+		}
+	}
+
+	sort.SliceStable(copies, func(i, j int) bool {
+		a, b := copies[i], copies[j]
+		if a.artifact.To != b.artifact.To {
+			return a.artifact.To < b.artifact.To
+		}
+		if a.dependency.Name != b.dependency.Name {
+			return a.dependency.Name < b.dependency.Name
+		}
+		return a.artifact.From < b.artifact.From
+	})
+	return copies
+}
+
+// destinationsOverlap reports whether two copy destinations write to the same files
+func destinationsOverlap(a, b string) bool {
+	return isUnderPath(a, b) || isUnderPath(b, a)
+}
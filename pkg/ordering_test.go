@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"testing"
+)
+
+func TestHashIgnoresDeclarationOrder(t *testing.T) {
+	a := &Task{Name: "a", BaseImage: "alpine"}
+	b := &Task{Name: "b", BaseImage: "alpine"}
+
+	first := &Task{Name: "c", BaseImage: "alpine", HashInputs: []string{"/x", "/y"}, Dependencies: []Dependency{
+		{Task: a, Artifacts: []Artifact{{From: "/1", To: "/in/1"}, {From: "/2", To: "/in/2"}}},
+		{Task: b, Artifacts: []Artifact{{From: "/3", To: "/in/3"}}},
+	}}
+	second := &Task{Name: "c", BaseImage: "alpine", HashInputs: []string{"/y", "/x"}, Dependencies: []Dependency{
+		{Task: b, Artifacts: []Artifact{{From: "/3", To: "/in/3"}}},
+		{Task: a, Artifacts: []Artifact{{From: "/2", To: "/in/2"}, {From: "/1", To: "/in/1"}}},
+	}}
+
+	if first.generateHash() != second.generateHash() {
+		t.Errorf("Hash should not depend on declaration order")
+	}
+}
+
+func TestArtifactCopiesOrder(t *testing.T) {
+	a := &Task{Name: "a"}
+	b := &Task{Name: "b"}
+	task := &Task{Name: "c", Dependencies: []Dependency{
+		{Task: b, Artifacts: []Artifact{{From: "/config.json", To: "/out/config.json"}}},
+		{Task: a, Artifacts: []Artifact{{From: "/dist", To: "/out"}, {From: "/other", To: "/out-other"}}},
+	}}
+
+	var order []string
+	for _, c := range task.artifactCopies() {
+		order = append(order, c.dependency.Name+":"+c.artifact.To)
+	}
+	want := []string{"a:/out", "a:/out-other", "b:/out/config.json"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("Expected copy order %v, got %v", want, order)
+		}
+	}
+
+	if !destinationsOverlap("/out", "/out/config.json") || destinationsOverlap("/out", "/out-other") {
+		t.Errorf("Unexpected overlap detection")
+	}
+}
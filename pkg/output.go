@@ -0,0 +1,230 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// OutputMode controls how an OutputMux combines the command output of concurrently running tasks.
+type OutputMode int
+
+const (
+	OutputGrouped  OutputMode = iota // Buffer each task's output and write it as one contiguous block when the task finishes
+	OutputPrefixed                   // Write complete lines as they arrive, prefixed with the task name
+)
+
+// ParseOutputMode parses "grouped" or "prefixed".
+func ParseOutputMode(mode string) (OutputMode, error) {
+	switch mode {
+	case "grouped":
+		return OutputGrouped, nil
+	case "prefixed":
+		return OutputPrefixed, nil
+	}
+	return 0, fmt.Errorf("unknown output mode '%s', expected grouped or prefixed", mode)
+}
+
+// OutputMux serializes the command output of several tasks onto one writer, so output of tasks
+// running at the same time is never interleaved within a line.
+type OutputMux struct {
+	mu     sync.Mutex
+	out    io.Writer
+	mode   OutputMode
+	colors bool
+}
+
+// NewOutputMux creates a multiplexer writing to out.
+func NewOutputMux(out io.Writer, mode OutputMode) *OutputMux {
+	return &OutputMux{out: out, mode: mode}
+}
+
+// SetColors colors the task name of every line, or the header of every block, with a color of its own
+// per task, so the output of tasks running at the same time is told apart at a glance.
+func (m *OutputMux) SetColors(enabled bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.colors = enabled
+}
+
+// Writer returns a writer for the output of one task. It must be closed when the task finishes.
+func (m *OutputMux) Writer(taskName string) *TaskOutput {
+	return &TaskOutput{mux: m, taskName: taskName}
+}
+
+// TaskOutput buffers the output of one task for an OutputMux.
+type TaskOutput struct {
+	mux      *OutputMux
+	taskName string
+	mu       sync.Mutex // stdout and stderr of a task are written from different goroutines
+	buf      bytes.Buffer
+}
+
+func (w *TaskOutput) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	if w.mux.mode != OutputPrefixed {
+		return len(p), nil
+	}
+
+	// Flush complete lines, keep a partial last line until it is completed
+	end := bytes.LastIndexByte(w.buf.Bytes(), '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	lines := w.buf.Next(end + 1)
+	if err := w.mux.writePrefixed(w.taskName, lines); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close flushes the remaining output of the task.
+func (w *TaskOutput) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() == 0 {
+		return nil
+	}
+	rest := w.buf.Bytes()
+	if rest[len(rest)-1] != '\n' {
+		rest = append(rest, '\n')
+	}
+	w.buf.Reset()
+
+	if w.mux.mode == OutputPrefixed {
+		return w.mux.writePrefixed(w.taskName, rest)
+	}
+
+	w.mux.mu.Lock()
+	defer w.mux.mu.Unlock()
+	header := fmt.Sprintf("=== Output of task '%s' ===", w.taskName)
+	_, err := fmt.Fprintf(w.mux.out, "%s\n%s", w.mux.colorize(w.taskName, header), rest)
+	return err
+}
+
+// writePrefixed writes complete lines, each prefixed with the task name
+func (m *OutputMux) writePrefixed(taskName string, lines []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := m.colorize(taskName, "["+taskName+"]")
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(lines, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		fmt.Fprintf(&out, "%s %s", prefix, line)
+	}
+	_, err := m.out.Write(out.Bytes())
+	return err
+}
+
+// taskColors are the ANSI foreground colors tasks are told apart by: red, green, yellow, blue, magenta,
+// cyan and their bright variants
+var taskColors = []int{31, 32, 33, 34, 35, 36, 91, 92, 93, 94, 95, 96}
+
+// colorize wraps text in the color of the task if colors are enabled. The color only depends on the
+// name, so a task keeps its color across runs.
+func (m *OutputMux) colorize(taskName, text string) string {
+	if !m.colors {
+		return text
+	}
+	hasher := fnv.New32a()
+	hasher.Write([]byte(taskName))
+	return fmt.Sprintf("\x1b[%dm%s\x1b[0m", taskColors[hasher.Sum32()%uint32(len(taskColors))], text)
+}
+
+// LineWriter calls a function with every line written to it, without its newline, for programs that
+// embed buildvault and consume the output of tasks line by line, like a server streaming it to clients.
+// Set it as Stdout or Stderr of a task. It is safe for concurrent use.
+type LineWriter struct {
+	fn  func(line string)
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// NewLineWriter creates a writer calling fn with every line.
+func NewLineWriter(fn func(line string)) *LineWriter {
+	return &LineWriter{fn: fn}
+}
+
+func (w *LineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf.Write(p)
+	for {
+		end := bytes.IndexByte(w.buf.Bytes(), '\n')
+		if end < 0 {
+			return len(p), nil
+		}
+		line := w.buf.Next(end + 1)
+		w.fn(string(bytes.TrimSuffix(line[:end], []byte("\r"))))
+	}
+}
+
+// Flush passes on a last line that did not end with a newline. Tasks flush their writers once their
+// commands finished.
+func (w *LineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.buf.Len() > 0 {
+		w.fn(w.buf.String())
+		w.buf.Reset()
+	}
+}
+
+// outputWriters returns where the command output of t goes: its own writers if it has them, stdout and
+// stderr otherwise
+func (t *Task) outputWriters(stdout, stderr io.Writer) (io.Writer, io.Writer) {
+	if t.Stdout != nil {
+		stdout = t.Stdout
+	}
+	if t.Stderr != nil {
+		stderr = t.Stderr
+	}
+	return stdout, stderr
+}
+
+// statusWriter writes progress lines about a task, like the artifacts it copies, to the standard output
+// of the task and as status events to its event sink. Quiet runs only send the events.
+type statusWriter struct {
+	task *Task
+}
+
+func (w statusWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		w.task.emit(Event{Type: EventStatus, Line: line})
+	}
+	if w.task.options.logLevel == LogQuiet {
+		return len(p), nil
+	}
+	stdout := w.task.options.stdout
+	if stdout == nil {
+		// Not called during Execute
+		stdout = os.Stdout
+	}
+	stdout, _ = w.task.outputWriters(stdout, nil)
+	return stdout.Write(p)
+}
+
+// statusf writes a progress line about t, see statusWriter
+func (t *Task) statusf(format string, args ...any) {
+	fmt.Fprintf(statusWriter{t}, format, args...)
+}
+
+// flushLines flushes w if it is a LineWriter
+func flushLines(w io.Writer) {
+	if lines, ok := w.(*LineWriter); ok {
+		lines.Flush()
+	}
+}
@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestOutputMuxPrefixed(t *testing.T) {
+	var out bytes.Buffer
+	mux := NewOutputMux(&out, OutputPrefixed)
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b", "c"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := mux.Writer(name)
+			for i := 0; i < 100; i++ {
+				// Lines arrive in fragments, as they do from a container
+				fmt.Fprintf(w, "line %d of ", i)
+				fmt.Fprintf(w, "%s\n", name)
+			}
+			fmt.Fprint(w, "unterminated")
+			w.Close()
+		}()
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 303 {
+		t.Fatalf("Expected 303 lines, got %d", len(lines))
+	}
+	for _, line := range lines {
+		name := line[1:2]
+		if line != fmt.Sprintf("[%s] unterminated", name) && !strings.HasSuffix(line, " of "+name) {
+			t.Errorf("Interleaved line: %q", line)
+		}
+	}
+}
+
+func TestOutputMuxGrouped(t *testing.T) {
+	var out bytes.Buffer
+	mux := NewOutputMux(&out, OutputGrouped)
+
+	a, b := mux.Writer("a"), mux.Writer("b")
+	fmt.Fprintln(a, "first a")
+	fmt.Fprintln(b, "first b")
+	fmt.Fprintln(a, "second a")
+	b.Close()
+	a.Close()
+
+	want := "=== Output of task 'b' ===\nfirst b\n=== Output of task 'a' ===\nfirst a\nsecond a\n"
+	if out.String() != want {
+		t.Errorf("Unexpected grouped output:\n%s", out.String())
+	}
+}
+
+func TestOutputMuxColors(t *testing.T) {
+	var out bytes.Buffer
+	mux := NewOutputMux(&out, OutputPrefixed)
+	mux.SetColors(true)
+	for _, name := range []string{"build", "test", "build"} {
+		w := mux.Writer(name)
+		fmt.Fprintln(w, "ok")
+		w.Close()
+	}
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "\x1b[") || !strings.HasSuffix(lines[0], "[build]\x1b[0m ok") {
+		t.Fatalf("Expected colored task names, got %q", lines)
+	}
+	if lines[0] != lines[2] {
+		t.Errorf("Expected a task to keep its color, got %q and %q", lines[0], lines[2])
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var lines []string
+	w := NewLineWriter(func(line string) { lines = append(lines, line) })
+	io.WriteString(w, "Compiling\r\nlin")
+	io.WriteString(w, "king\n\nno newline")
+	if !slices.Equal(lines, []string{"Compiling", "linking", ""}) {
+		t.Errorf("Unexpected lines %q", lines)
+	}
+	w.Flush()
+	if lines[len(lines)-1] != "no newline" {
+		t.Errorf("Expected the last line on flush, got %q", lines)
+	}
+}
+
+func TestTaskOutputWriters(t *testing.T) {
+	var run, own bytes.Buffer
+	task := &Task{Name: "build", Stderr: &own}
+	stdout, stderr := task.outputWriters(&run, &run)
+	if stdout != &run || stderr != &own {
+		t.Error("Expected the writer of the task to replace the one of the run")
+	}
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"fmt"
+	"sort"
+)
+
+// OutputRef references a named output of a task, so consumers do not repeat the producer's paths.
+type OutputRef struct {
+	Task *Task
+	Name string
+}
+
+// Output returns a reference to the named output of the task.
+func (t *Task) Output(name string) OutputRef {
+	return OutputRef{Task: t, Name: name}
+}
+
+// CopyTo returns a dependency on the referenced task that copies the named output to path:
+//
+//	Dependencies: []Dependency{build.Output("binary").CopyTo("/bin/app")}
+func (r OutputRef) CopyTo(path string) Dependency {
+	return Dependency{Task: r.Task, Artifacts: []Artifact{{Output: r.Name, To: path}}}
+}
+
+// declaredOutputs returns the unnamed outputs followed by the paths of the named outputs, sorted by name
+func (t *Task) declaredOutputs() []string {
+	outputs := append([]string{}, t.Outputs...)
+
+	names := make([]string, 0, len(t.NamedOutputs))
+	for name := range t.NamedOutputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := t.NamedOutputs[name]
+		if !containsString(outputs, path) {
+			outputs = append(outputs, path)
+		}
+	}
+	return outputs
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveOutputRefs sets the source path of artifacts that reference a named output of their dependency,
+// for all tasks in the graph of t
+func (t *Task) resolveOutputRefs() error {
+	if t.outputsResolved {
+		return nil
+	}
+	for _, dependency := range t.Dependencies {
+		if err := dependency.Task.resolveOutputRefs(); err != nil {
+			return err
+		}
+
+		for i, artifact := range dependency.Artifacts {
+			if artifact.Output == "" {
+				continue
+			}
+			path, ok := dependency.Task.NamedOutputs[artifact.Output]
+			if !ok {
+				return fmt.Errorf("task '%s' references unknown output '%s' of task '%s'", t.Name, artifact.Output, dependency.Task.Name)
+			}
+			if artifact.From != "" && artifact.From != path {
+				return fmt.Errorf("task '%s' copies output '%s' of task '%s' from %s, but the output is %s",
+					t.Name, artifact.Output, dependency.Task.Name, artifact.From, path)
+			}
+			dependency.Artifacts[i].From = path
+		}
+	}
+	t.outputsResolved = true
+	return nil
+}
@@ -0,0 +1,192 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+)
+
+// Images without a shell, like distroless or scratch images, have nothing to keep a container alive
+// with. A task in pause mode is kept alive by a tiny static pause binary that buildvault carries along
+// and copies into the container, and runs its commands as raw argv. Everything else that needs tools in
+// the container, like file secrets, still needs a helper.
+
+// pausePath is the reserved path the pause binary of a task is injected at
+const pausePath = internalDir + "/pause"
+
+// pauseCode is the machine code of the pause binary per architecture. It installs a handler exiting
+// on SIGTERM and SIGINT, which a container's init process would otherwise ignore, and then waits for
+// signals forever. The code is position independent and needs no libc.
+var pauseCode = map[string]struct {
+	machine uint16 // ELF machine
+	code    []byte
+}{
+	"amd64": {62, []byte{
+		0x48, 0x83, 0xec, 0x20, //                               sub    rsp, 32
+		0x48, 0x8d, 0x05, 0x4d, 0x00, 0x00, 0x00, //             lea    rax, [rip + handler]
+		0x48, 0x89, 0x04, 0x24, //                               mov    [rsp], rax            ; sa_handler
+		0x48, 0xc7, 0x44, 0x24, 0x08, 0x00, 0x00, 0x00, 0x04, // mov    qword [rsp + 8], SA_RESTORER
+		0x48, 0x89, 0x44, 0x24, 0x10, //                         mov    [rsp + 16], rax       ; never returned to
+		0x48, 0xc7, 0x44, 0x24, 0x18, 0x00, 0x00, 0x00, 0x00, // mov    qword [rsp + 24], 0   ; sa_mask
+		0xbf, 0x0f, 0x00, 0x00, 0x00, //                         mov    edi, SIGTERM
+		0xe8, 0x13, 0x00, 0x00, 0x00, //                         call   sigaction
+		0xbf, 0x02, 0x00, 0x00, 0x00, //                         mov    edi, SIGINT
+		0xe8, 0x09, 0x00, 0x00, 0x00, //                         call   sigaction
+		0xb8, 0x22, 0x00, 0x00, 0x00, //                   wait: mov    eax, pause
+		0x0f, 0x05, //                                           syscall
+		0xeb, 0xf7, //                                           jmp    wait
+		0xb8, 0x0d, 0x00, 0x00, 0x00, //              sigaction: mov    eax, rt_sigaction
+		0x48, 0x8d, 0x74, 0x24, 0x08, //                         lea    rsi, [rsp + 8]
+		0x31, 0xd2, //                                           xor    edx, edx
+		0x41, 0xba, 0x08, 0x00, 0x00, 0x00, //                   mov    r10d, 8
+		0x0f, 0x05, //                                           syscall
+		0xc3,       //                                                 ret
+		0x31, 0xff, //                                  handler: xor    edi, edi
+		0xb8, 0xe7, 0x00, 0x00, 0x00, //                         mov    eax, exit_group
+		0x0f, 0x05, //                                           syscall
+	}},
+	"arm64": {183, []byte{
+		0xff, 0x83, 0x00, 0xd1, //            sub  sp, sp, #32
+		0xe1, 0x02, 0x00, 0x10, //            adr  x1, handler
+		0xe1, 0x03, 0x00, 0xf9, //            str  x1, [sp]         ; sa_handler
+		0xff, 0x07, 0x00, 0xf9, //            str  xzr, [sp, #8]    ; sa_flags
+		0xff, 0x0b, 0x00, 0xf9, //            str  xzr, [sp, #16]   ; sa_restorer
+		0xff, 0x0f, 0x00, 0xf9, //            str  xzr, [sp, #24]   ; sa_mask
+		0xe0, 0x01, 0x80, 0xd2, //            mov  x0, #SIGTERM
+		0x0b, 0x00, 0x00, 0x94, //            bl   sigaction
+		0x40, 0x00, 0x80, 0xd2, //            mov  x0, #SIGINT
+		0x09, 0x00, 0x00, 0x94, //            bl   sigaction
+		0xe0, 0x03, 0x1f, 0xaa, //      wait: mov  x0, xzr
+		0xe1, 0x03, 0x1f, 0xaa, //            mov  x1, xzr
+		0xe2, 0x03, 0x1f, 0xaa, //            mov  x2, xzr
+		0xe3, 0x03, 0x1f, 0xaa, //            mov  x3, xzr
+		0xe4, 0x03, 0x1f, 0xaa, //            mov  x4, xzr
+		0x28, 0x09, 0x80, 0xd2, //            mov  x8, #ppoll       ; arm64 has no pause
+		0x01, 0x00, 0x00, 0xd4, //            svc  #0
+		0xf9, 0xff, 0xff, 0x17, //            b    wait
+		0xe1, 0x03, 0x00, 0x91, // sigaction: mov  x1, sp
+		0xe2, 0x03, 0x1f, 0xaa, //            mov  x2, xzr
+		0x03, 0x01, 0x80, 0xd2, //            mov  x3, #8
+		0xc8, 0x10, 0x80, 0xd2, //            mov  x8, #rt_sigaction
+		0x01, 0x00, 0x00, 0xd4, //            svc  #0
+		0xc0, 0x03, 0x5f, 0xd6, //            ret
+		0xe0, 0x03, 0x1f, 0xaa, //   handler: mov  x0, xzr
+		0xc8, 0x0b, 0x80, 0xd2, //            mov  x8, #exit_group
+		0x01, 0x00, 0x00, 0xd4, //            svc  #0
+	}},
+}
+
+const (
+	pauseBase            = 0x400000 // Address the pause binary is loaded at
+	elfHeaderSize        = 64
+	elfProgramHeaderSize = 56
+)
+
+// pauseBinary returns the static ELF executable of the pause process for the Go architecture arch. It
+// consists of the ELF header, a single segment loading the whole file and the code.
+func pauseBinary(arch string) ([]byte, error) {
+	pause, ok := pauseCode[arch]
+	if !ok {
+		return nil, fmt.Errorf("no pause binary for architecture %s", arch)
+	}
+	size := uint64(elfHeaderSize + elfProgramHeaderSize + len(pause.code))
+
+	var b bytes.Buffer
+	b.Write([]byte{0x7f, 'E', 'L', 'F', 2 /* 64 bit */, 1 /* little endian */, 1 /* version */, 0 /* System V ABI */})
+	b.Write(make([]byte, 8))
+	binary.Write(&b, binary.LittleEndian, struct {
+		Type, Machine                    uint16
+		Version                          uint32
+		Entry, ProgHeaderOff, SectionOff uint64
+		Flags                            uint32
+		HeaderSize, ProgHeaderSize       uint16
+		ProgHeaders, SectionHeaderSize   uint16
+		SectionHeaders, SectionNames     uint16
+	}{
+		Type:           2, // Executable
+		Machine:        pause.machine,
+		Version:        1,
+		Entry:          pauseBase + elfHeaderSize + elfProgramHeaderSize,
+		ProgHeaderOff:  elfHeaderSize,
+		HeaderSize:     elfHeaderSize,
+		ProgHeaderSize: elfProgramHeaderSize,
+		ProgHeaders:    1,
+	})
+	binary.Write(&b, binary.LittleEndian, struct {
+		Type, Flags                  uint32
+		Offset, VirtAddr, PhysAddr   uint64
+		FileSize, MemSize, Alignment uint64
+	}{
+		Type:      1, // Loadable
+		Flags:     5, // Readable and executable
+		VirtAddr:  pauseBase,
+		PhysAddr:  pauseBase,
+		FileSize:  size,
+		MemSize:   size,
+		Alignment: 0x10000, // Largest page size of arm64
+	})
+	b.Write(pause.code)
+	return b.Bytes(), nil
+}
+
+// validatePause checks that a task in pause mode leaves the main process to the pause binary
+func (t *Task) validatePause() error {
+	if t.Pause && len(t.KeepAlive) > 0 {
+		return fmt.Errorf("task '%s' is kept alive by the pause binary and cannot have a keep-alive command", t.Name)
+	}
+	return nil
+}
+
+// checkRawArgv checks that a task in pause mode, whose image has no shell and which has no helper,
+// needs nothing but to execute its commands
+func (t *Task) checkRawArgv() error {
+	var needs string
+	switch {
+	case t.Script != "":
+		needs = "its script"
+	case len(t.Commands) > 0 && len(t.Shell) == 0:
+		needs = "its commands, use cmd to run them without a shell"
+	case t.BatchCommands:
+		needs = "batched commands"
+	case t.hasSecretFiles():
+		needs = "file secrets"
+	case t.hasReadOnlyArtifacts():
+		needs = "read-only artifacts"
+	default:
+		return nil
+	}
+	return fmt.Errorf("%w: image %s of task '%s' does not contain %s, which is needed for %s; set a static busybox as helper",
+		ErrNoShell, t.BaseImage, t.Name, shellPath, needs)
+}
+
+// hasReadOnlyArtifacts reports whether t copies an artifact that has to stay unmodified
+func (t *Task) hasReadOnlyArtifacts() bool {
+	for _, dependency := range t.Dependencies {
+		for _, artifact := range dependency.Artifacts {
+			if t.readOnlyArtifact(artifact) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withoutTools reports whether the task container has neither a shell nor a helper, so buildvault can
+// only copy files and execute the raw commands
+func (t *Task) withoutTools() bool {
+	return t.noShell && t.Helper == ""
+}
+
+// injectPause copies the pause binary for the architecture of the task image into its created container
+func (t *Task) injectPause(ctx context.Context, cli DockerAPI) error {
+	inspect, err := cli.ImageInspect(ctx, t.imageID)
+	if err != nil {
+		return fmt.Errorf("error inspecting image %s: %w", t.BaseImage, err)
+	}
+	pause, err := pauseBinary(inspect.Architecture)
+	if err != nil {
+		return fmt.Errorf("error injecting pause binary into task '%s': %w", t.Name, err)
+	}
+	return injectBinary(ctx, cli, t.containerID, pausePath, pause)
+}
@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+)
+
+func TestPauseBinary(t *testing.T) {
+	for arch, machine := range map[string]elf.Machine{"amd64": elf.EM_X86_64, "arm64": elf.EM_AARCH64} {
+		binary, err := pauseBinary(arch)
+		if err != nil {
+			t.Fatalf("No pause binary for %s: %v", arch, err)
+		}
+		file, err := elf.NewFile(bytes.NewReader(binary))
+		if err != nil {
+			t.Fatalf("Pause binary for %s is no ELF file: %v", arch, err)
+		}
+		if file.Type != elf.ET_EXEC || file.Machine != machine || len(file.Progs) != 1 {
+			t.Errorf("Unexpected ELF header for %s: %+v", arch, file.FileHeader)
+		}
+		if load := file.Progs[0]; file.Entry < load.Vaddr || file.Entry >= load.Vaddr+load.Filesz {
+			t.Errorf("Entry point of %s outside of the loaded segment", arch)
+		}
+	}
+
+	if _, err := pauseBinary("s390x"); err == nil {
+		t.Error("Expected an error for an architecture without pause binary")
+	}
+}
+
+func TestPauseBinaryExitsOnSIGTERM(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" && runtime.GOARCH != "arm64" {
+		t.Skip("The pause binary only runs on Linux")
+	}
+	binary, _ := pauseBinary(runtime.GOARCH)
+	path := filepath.Join(t.TempDir(), "pause")
+	if err := os.WriteFile(path, binary, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(path)
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start the pause binary: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Expected the pause binary to wait, it exited with %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected the pause binary to exit cleanly on SIGTERM, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		cmd.Process.Kill()
+		t.Fatal("The pause binary did not exit on SIGTERM")
+	}
+}
+
+func TestPauseContainerConfig(t *testing.T) {
+	task := &Task{Name: "test", BaseImage: "gcr.io/distroless/static", Pause: true, Cmd: [][]string{{"/app", "--version"}}}
+	config := task.containerConfig()
+	if !slices.Equal(config.Entrypoint, []string{""}) || !slices.Equal(config.Cmd, []string{pausePath}) {
+		t.Errorf("Expected the pause binary instead of the entrypoint, got %v and %v", config.Entrypoint, config.Cmd)
+	}
+
+	task.KeepAlive = []string{"/app", "serve"}
+	if err := task.validatePause(); err == nil {
+		t.Error("Expected a keep-alive command in pause mode to be rejected")
+	}
+}
+
+func TestPauseKeepAlive(t *testing.T) {
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:3.20"}, Architecture: "amd64", Files: map[string]string{"/bin/sh": ""}})
+
+	// The pause binary is opt-in, containers are kept alive through the shell of the task by default
+	for _, task := range []*Task{
+		{Name: "shell", BaseImage: "alpine:3.20", Shell: []string{"bash", "-eu", "-c"}, Commands: []string{"true"}},
+		{Name: "pause", BaseImage: "alpine:3.20", Pause: true, Cmd: [][]string{{"true"}}},
+	} {
+		if err := task.Execute(context.Background(), cli); err != nil {
+			t.Fatalf("Failed to execute task %s: %v", task.Name, err)
+		}
+		want := []string{"bash", "-eu", "-c", shellKeepAlive}
+		if task.Pause {
+			want = []string{pausePath}
+		}
+		c, _ := cli.Container(task.generateContainerName())
+		if !slices.Equal(c.Config.Cmd, want) {
+			t.Errorf("Expected keep-alive %v for task %s, got %v", want, task.Name, c.Config.Cmd)
+		}
+		if injected := c.Exists(pausePath); injected != task.Pause {
+			t.Errorf("Expected the pause binary to be injected only in pause mode, got %v for task %s", injected, task.Name)
+		}
+	}
+}
+
+func TestCheckRawArgv(t *testing.T) {
+	task := &Task{Name: "test", BaseImage: "gcr.io/distroless/static", Pause: true, Cmd: [][]string{{"/app", "--version"}},
+		Dependencies: []Dependency{{Task: &Task{Name: "build"}, Artifacts: []Artifact{{From: "/out/app", To: "/app"}}}}}
+	if err := task.checkRawArgv(); err != nil {
+		t.Errorf("Expected raw commands with artifacts to need no tools, got %v", err)
+	}
+
+	for name, task := range map[string]*Task{
+		"commands":     {Name: "test", Pause: true, Commands: []string{"/app --version"}},
+		"script":       {Name: "test", Pause: true, Script: "/app --version"},
+		"file secrets": {Name: "test", Pause: true, Cmd: [][]string{{"/app"}}, Secrets: []Secret{{Name: "token", Mount: true}}},
+		"read-only":    {Name: "test", Pause: true, Cmd: [][]string{{"/app"}}, ReadOnlyArtifacts: true, Dependencies: task.Dependencies},
+	} {
+		if err := task.checkRawArgv(); !errors.Is(err, ErrNoShell) {
+			t.Errorf("Expected %s to need a shell, got %v", name, err)
+		}
+	}
+
+	withShell := &Task{Name: "test", Pause: true, Shell: []string{"/busybox/sh", "-c"}, Commands: []string{"/app --version"}}
+	if err := withShell.checkRawArgv(); err != nil {
+		t.Errorf("Expected commands with a shell of the task to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,621 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Pipeline is the set of tasks defined in a pipeline file.
+type Pipeline struct {
+	Tasks        []*Task           // All tasks of the pipeline in definition order
+	Stages       []string          // Order of the stages tasks are grouped under in run reports
+	Docker       DockerEndpoint    // Docker daemon the pipeline runs on, empty for the environment's default
+	DaemonLimits DaemonLimits      // Concurrent Docker API operations against that daemon
+	Vars         map[string]string // Variables referenced by tasks as ${{ vars.NAME }}
+	ID           string            // Identifies the pipeline file in the labels of task containers, empty if not loaded from a file
+	targets      []*Task           // Tasks executed by Run, all root tasks if empty
+}
+
+// pipelineFile is the on-disk representation of a pipeline (buildvault.yaml).
+type pipelineFile struct {
+	Docker  dockerSpec        `yaml:"docker"`
+	Network *Network          `yaml:"network"` // Default network of tasks without their own
+	Stages  []string          `yaml:"stages"`  // Order of the stages of run reports
+	Prelude string            `yaml:"prelude"` // Default prelude of tasks without their own
+	Cleanup CleanupPolicy     `yaml:"cleanup"` // Default cleanup policy of tasks without their own
+	Vars    map[string]string `yaml:"vars"`    // Values of the variables tasks reference, can be set on the command line
+	Tasks   []taskSpec        `yaml:"tasks"`
+}
+
+type dockerSpec struct {
+	Host      string `yaml:"host"`
+	MaxExecs  int    `yaml:"max_execs"`
+	MaxCopies int    `yaml:"max_copies"`
+	TLS       struct {
+		CACert string `yaml:"ca"`
+		Cert   string `yaml:"cert"`
+		Key    string `yaml:"key"`
+	} `yaml:"tls"`
+}
+
+type taskSpec struct {
+	Name         string            `yaml:"name"`
+	Image        string            `yaml:"image"`
+	Build        *buildSpec        `yaml:"build"`
+	Commands     []string          `yaml:"commands"`
+	Shell        []string          `yaml:"shell"`
+	Cmd          [][]string        `yaml:"cmd"`
+	Script       string            `yaml:"script"`
+	Dependencies []dependencySpec  `yaml:"dependencies"`
+	HashInputs   []string          `yaml:"hash_inputs"`
+	Outputs      outputsSpec       `yaml:"outputs"`
+	Helper       string            `yaml:"helper"`
+	Batch        bool              `yaml:"batch_commands"`
+	Virtual      bool              `yaml:"virtual"`
+	Secrets      []secretSpec      `yaml:"secrets"`
+	Mounts       []Mount           `yaml:"mounts"`
+	Git          []GitSource       `yaml:"git"`
+	Downloads    []Download        `yaml:"downloads"`
+	CacheDirs    []string          `yaml:"cache_dirs"`
+	Exports      []Export          `yaml:"exports"`
+	Network      *Network          `yaml:"network"`
+	ReadOnly     bool              `yaml:"read_only_artifacts"`
+	Services     []Service         `yaml:"services"`
+	Conflicts    ConflictPolicy    `yaml:"artifact_conflicts"`
+	User         string            `yaml:"user"`
+	HostUser     bool              `yaml:"host_user"`
+	Inherit      *ImageInheritance `yaml:"inherit"`
+	Stage        string            `yaml:"stage"`
+	Snapshot     bool              `yaml:"snapshot_on_failure"`
+	ImageMirrors []string          `yaml:"image_mirrors"`
+	OutputImage  string            `yaml:"output_image"`
+	Push         bool              `yaml:"push"`
+	Freshness    time.Duration     `yaml:"freshness"`
+	Prelude      string            `yaml:"prelude"`
+	When         string            `yaml:"when"`
+	AllowFailure bool              `yaml:"allow_failure"`
+	Cleanup      CleanupPolicy     `yaml:"cleanup"`
+	Platform     string            `yaml:"platform"`
+	Platforms    []string          `yaml:"platforms"` // Fans the task out into an instance per platform, see FanOutPlatforms
+	Devices      []string          `yaml:"devices"`
+	GPUs         string            `yaml:"gpus"`
+	Privileged   bool              `yaml:"privileged"`
+	CapAdd       []string          `yaml:"cap_add"`
+	CapDrop      []string          `yaml:"cap_drop"`
+	Seccomp      string            `yaml:"seccomp"`
+	AppArmor     string            `yaml:"apparmor"`
+	ReadOnlyFS   bool              `yaml:"read_only_rootfs"`
+	Writable     []string          `yaml:"writable_paths"`
+	Readiness    *Readiness        `yaml:"readiness"`
+	Entrypoint   []string          `yaml:"entrypoint"`
+	KeepAlive    []string          `yaml:"keep_alive"`
+	Pause        bool              `yaml:"pause"`
+}
+
+// secretSpec names the host source of a secret; values never appear in the pipeline file
+type secretSpec struct {
+	Name  string `yaml:"name"`
+	Env   string `yaml:"env"`
+	File  string `yaml:"file"`
+	Mount bool   `yaml:"mount"`
+}
+
+// outputsSpec accepts either a list of paths or a mapping of output names to paths
+type outputsSpec struct {
+	Paths []string
+	Named map[string]string
+}
+
+func (o *outputsSpec) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.MappingNode {
+		return node.Decode(&o.Named)
+	}
+	return node.Decode(&o.Paths)
+}
+
+type buildSpec struct {
+	Context    string            `yaml:"context"`
+	Dockerfile string            `yaml:"dockerfile"`
+	Args       map[string]string `yaml:"args"`
+}
+
+type dependencySpec struct {
+	Task      string     `yaml:"task"`
+	Container string     `yaml:"container"` // Existing container not managed by buildvault, instead of a task
+	Artifacts []Artifact `yaml:"artifacts"`
+}
+
+// LoadPipeline reads a pipeline file, in HCL if its name ends in .hcl and in YAML otherwise, and resolves
+// task references into a Pipeline.
+func LoadPipeline(path string) (*Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, configErrorf("error reading pipeline file: %w", err)
+	}
+
+	var pipeline *Pipeline
+	if filepath.Ext(path) == ".hcl" {
+		pipeline, err = ParsePipelineHCL(data, path)
+	} else {
+		pipeline, err = ParsePipeline(data)
+	}
+	if err != nil {
+		return nil, &ConfigError{Err: err}
+	}
+
+	// Build contexts, host inputs, exports, bind mounts, helper binaries, secret files and certificates are relative to the pipeline file
+	pipeline.Docker.resolveTLSPaths(filepath.Dir(path))
+	pipeline.ID = pipelineID(path)
+	for _, task := range pipeline.Tasks {
+		task.pipelineID = pipeline.ID
+		if task.Helper != "" && !filepath.IsAbs(task.Helper) {
+			task.Helper = filepath.Join(filepath.Dir(path), task.Helper)
+		}
+		for i, secret := range task.Secrets {
+			if secret.File != "" && !filepath.IsAbs(secret.File) {
+				task.Secrets[i].File = filepath.Join(filepath.Dir(path), secret.File)
+			}
+		}
+		for i, export := range task.Exports {
+			if !filepath.IsAbs(export.To) {
+				task.Exports[i].To = filepath.Join(filepath.Dir(path), export.To)
+			}
+		}
+		for i, m := range task.Mounts {
+			if m.Type == MountBind && !filepath.IsAbs(m.Source) {
+				task.Mounts[i].Source = filepath.Join(filepath.Dir(path), m.Source)
+			}
+		}
+		for _, source := range task.Git {
+			if source.Auth != nil && source.Auth.SSHKey != "" && !filepath.IsAbs(source.Auth.SSHKey) {
+				source.Auth.SSHKey = filepath.Join(filepath.Dir(path), source.Auth.SSHKey)
+			}
+		}
+		if task.Build != nil && !filepath.IsAbs(task.Build.Context) {
+			task.Build.Context = filepath.Join(filepath.Dir(path), task.Build.Context)
+		}
+		for i, input := range task.HashInputs {
+			if !filepath.IsAbs(input) {
+				task.HashInputs[i] = filepath.Join(filepath.Dir(path), input)
+			}
+		}
+		if task.Seccomp != "" && task.Seccomp != profileUnconfined && !filepath.IsAbs(task.Seccomp) {
+			task.Seccomp = filepath.Join(filepath.Dir(path), task.Seccomp)
+		}
+	}
+	return pipeline, nil
+}
+
+// pipelineID derives the ID of a pipeline from the absolute path of its file, so it stays the same
+// across runs from different working directories
+func pipelineID(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	sum := sha256.Sum256([]byte(path))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// ParsePipeline parses pipeline file contents into a Pipeline.
+func ParsePipeline(data []byte) (*Pipeline, error) {
+	var file pipelineFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing pipeline file: %w", err)
+	}
+	return file.pipeline()
+}
+
+// pipeline validates file and resolves its task references into a Pipeline
+func (file *pipelineFile) pipeline() (*Pipeline, error) {
+	pipeline := &Pipeline{
+		Docker: DockerEndpoint{
+			Host:      file.Docker.Host,
+			TLSCACert: file.Docker.TLS.CACert,
+			TLSCert:   file.Docker.TLS.Cert,
+			TLSKey:    file.Docker.TLS.Key,
+		},
+		DaemonLimits: DaemonLimits{
+			MaxExecs:  file.Docker.MaxExecs,
+			MaxCopies: file.Docker.MaxCopies,
+		},
+		Vars: file.Vars,
+	}
+	pipeline.Stages = file.Stages
+	tasksByName := map[string]*Task{}
+
+	// Create all tasks first so dependencies can reference tasks defined later in the file
+	for _, spec := range file.Tasks {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("task without a name in pipeline file")
+		}
+		if _, exists := tasksByName[spec.Name]; exists {
+			return nil, fmt.Errorf("duplicate task name '%s'", spec.Name)
+		}
+
+		task := &Task{
+			Name:              spec.Name,
+			BaseImage:         spec.Image,
+			Commands:          spec.Commands,
+			Shell:             spec.Shell,
+			Cmd:               spec.Cmd,
+			Script:            spec.Script,
+			HashInputs:        spec.HashInputs,
+			Outputs:           spec.Outputs.Paths,
+			NamedOutputs:      spec.Outputs.Named,
+			Helper:            spec.Helper,
+			BatchCommands:     spec.Batch,
+			Virtual:           spec.Virtual,
+			Mounts:            spec.Mounts,
+			Git:               spec.Git,
+			Downloads:         spec.Downloads,
+			CacheDirs:         spec.CacheDirs,
+			Exports:           spec.Exports,
+			Network:           spec.Network,
+			ReadOnlyArtifacts: spec.ReadOnly,
+			Services:          spec.Services,
+			ArtifactConflicts: spec.Conflicts,
+			User:              spec.User,
+			HostUser:          spec.HostUser,
+			Inherit:           spec.Inherit,
+			Stage:             spec.Stage,
+			SnapshotOnFailure: spec.Snapshot,
+			ImageMirrors:      spec.ImageMirrors,
+			OutputImage:       spec.OutputImage,
+			Push:              spec.Push,
+			Freshness:         spec.Freshness,
+			Prelude:           spec.Prelude,
+			AllowFailure:      spec.AllowFailure,
+			Vars:              pipeline.Vars,
+			Platform:          spec.Platform,
+			Devices:           spec.Devices,
+			GPUs:              spec.GPUs,
+			Privileged:        spec.Privileged,
+			CapAdd:            spec.CapAdd,
+			CapDrop:           spec.CapDrop,
+			Seccomp:           spec.Seccomp,
+			AppArmor:          spec.AppArmor,
+			ReadOnlyRootfs:    spec.ReadOnlyFS,
+			WritablePaths:     spec.Writable,
+			Readiness:         spec.Readiness,
+			Entrypoint:        spec.Entrypoint,
+			KeepAlive:         spec.KeepAlive,
+			Pause:             spec.Pause,
+		}
+		if _, err := parsePlatform(spec.Platform); err != nil {
+			return nil, fmt.Errorf("task '%s': %w", spec.Name, err)
+		}
+		if spec.Build != nil {
+			task.Build = &ImageBuild{
+				Context:    spec.Build.Context,
+				Dockerfile: spec.Build.Dockerfile,
+				Args:       spec.Build.Args,
+			}
+		} else if spec.Image == "" && !spec.Virtual {
+			return nil, fmt.Errorf("task '%s' needs either an image or a build", spec.Name)
+		}
+		if spec.Push && spec.OutputImage == "" {
+			return nil, fmt.Errorf("task '%s' pushes its output image but has no output_image", spec.Name)
+		}
+		if len(spec.ImageMirrors) > 0 && spec.Image == "" {
+			return nil, fmt.Errorf("task '%s' has image mirrors but no image to pull", spec.Name)
+		}
+		if spec.When != "" {
+			condition, err := ParseCondition(spec.When)
+			if err != nil {
+				return nil, fmt.Errorf("task '%s': %w", spec.Name, err)
+			}
+			task.When = condition
+		}
+		for _, secretSpec := range spec.Secrets {
+			if secretSpec.Name == "" {
+				return nil, fmt.Errorf("secret without a name in task '%s'", spec.Name)
+			}
+			secret := Secret{Name: secretSpec.Name, Env: secretSpec.Env, File: secretSpec.File, Mount: secretSpec.Mount}
+			if secret.Env == "" && secret.File == "" {
+				secret.Env = secret.Name
+			}
+			task.Secrets = append(task.Secrets, secret)
+		}
+		if task.Network == nil {
+			task.Network = file.Network
+		}
+		if task.Prelude == "" && len(spec.Cmd) == 0 && !spec.Virtual {
+			task.Prelude = file.Prelude
+		}
+		cleanup := spec.Cleanup
+		if cleanup == "" {
+			cleanup = file.Cleanup
+		}
+		policy, err := ParseCleanupPolicy(string(cleanup))
+		if err != nil {
+			return nil, fmt.Errorf("task '%s': %w", spec.Name, err)
+		}
+		task.Cleanup = policy
+		if task.Stage != "" && len(file.Stages) > 0 && !slices.Contains(file.Stages, task.Stage) {
+			return nil, fmt.Errorf("task '%s' is in stage '%s', which is not one of the stages of the pipeline", spec.Name, task.Stage)
+		}
+		tasksByName[spec.Name] = task
+		pipeline.Tasks = append(pipeline.Tasks, task)
+	}
+
+	// Containers referenced by several dependencies are adopted once
+	externalTasks := map[string]*Task{}
+	for _, spec := range file.Tasks {
+		task := tasksByName[spec.Name]
+		for _, depSpec := range spec.Dependencies {
+			if depSpec.Container != "" {
+				if depSpec.Task != "" {
+					return nil, fmt.Errorf("dependency of task '%s' can refer to either a task or a container", spec.Name)
+				}
+				external, ok := externalTasks[depSpec.Container]
+				if !ok {
+					external = ExternalContainer(depSpec.Container)
+					externalTasks[depSpec.Container] = external
+				}
+				task.Dependencies = append(task.Dependencies, Dependency{
+					Task:      external,
+					Artifacts: depSpec.Artifacts,
+				})
+				continue
+			}
+			depTask, ok := tasksByName[depSpec.Task]
+			if !ok {
+				return nil, fmt.Errorf("task '%s' depends on unknown task '%s'", spec.Name, depSpec.Task)
+			}
+			task.Dependencies = append(task.Dependencies, Dependency{
+				Task:      depTask,
+				Artifacts: depSpec.Artifacts,
+			})
+		}
+	}
+
+	for _, spec := range file.Tasks {
+		if len(spec.Platforms) == 0 {
+			continue
+		}
+		if spec.Platform != "" {
+			return nil, fmt.Errorf("task '%s' can either have a platform or platforms", spec.Name)
+		}
+		if err := pipeline.fanOut(tasksByName[spec.Name], spec.Platforms); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, task := range pipeline.Tasks {
+		if !task.isCircularDependencyFree(nil) {
+			return nil, fmt.Errorf("circular dependency found in task '%s'", task.Name)
+		}
+		if err := task.resolveOutputRefs(); err != nil {
+			return nil, err
+		}
+		if err := task.checkVars(); err != nil {
+			return nil, err
+		}
+		if err := task.validateMounts(); err != nil {
+			return nil, err
+		}
+		if err := task.validateGit(); err != nil {
+			return nil, err
+		}
+		if err := task.validateDownloads(); err != nil {
+			return nil, err
+		}
+		if err := task.validateNetwork(); err != nil {
+			return nil, err
+		}
+		if err := task.validateDevices(); err != nil {
+			return nil, err
+		}
+		if err := task.validateSecurity(); err != nil {
+			return nil, err
+		}
+		if err := task.validateReadOnlyRootfs(); err != nil {
+			return nil, err
+		}
+		if err := task.validateServices(); err != nil {
+			return nil, err
+		}
+		if err := task.validateReadiness(); err != nil {
+			return nil, err
+		}
+		if err := task.validatePause(); err != nil {
+			return nil, err
+		}
+		if err := task.validateArtifactConflicts(); err != nil {
+			return nil, err
+		}
+		if err := task.validateUser(); err != nil {
+			return nil, err
+		}
+		if err := task.validateCommands(); err != nil {
+			return nil, err
+		}
+		if err := task.validateScript(); err != nil {
+			return nil, err
+		}
+		if task.Virtual {
+			if err := task.validateVirtual(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return pipeline, nil
+}
+
+// fanOut replaces template by its instances per platform and their group task, which takes over the
+// dependents of template
+func (p *Pipeline) fanOut(template *Task, platforms []string) error {
+	instances, group, err := FanOutPlatforms(template, platforms)
+	if err != nil {
+		return err
+	}
+	for _, instance := range instances {
+		if _, exists := p.Task(instance.Name); exists {
+			return fmt.Errorf("task '%s' fans out into '%s', which is already a task", template.Name, instance.Name)
+		}
+	}
+
+	i := slices.Index(p.Tasks, template)
+	p.Tasks = slices.Concat(p.Tasks[:i], instances, []*Task{group}, p.Tasks[i+1:])
+	for _, task := range p.Tasks {
+		for j, dependency := range task.Dependencies {
+			if dependency.Task != template {
+				continue
+			}
+			if len(dependency.Artifacts) > 0 {
+				return fmt.Errorf("task '%s' copies artifacts of '%s', which fans out over platforms, instead of one of its instances like '%s'",
+					task.Name, template.Name, instances[0].Name)
+			}
+			task.Dependencies[j].Task = group
+		}
+	}
+	return nil
+}
+
+// Task returns the task with the given name.
+func (p *Pipeline) Task(name string) (*Task, bool) {
+	for _, task := range p.Tasks {
+		if task.Name == name {
+			return task, true
+		}
+	}
+	return nil, false
+}
+
+// Roots returns the tasks no other task depends on.
+func (p *Pipeline) Roots() []*Task {
+	referenced := map[*Task]bool{}
+	for _, task := range p.Tasks {
+		for _, dependency := range task.Dependencies {
+			referenced[dependency.Task] = true
+		}
+	}
+
+	var roots []*Task
+	for _, task := range p.Tasks {
+		if !referenced[task] {
+			roots = append(roots, task)
+		}
+	}
+	return roots
+}
+
+// AddTaskOutputs appends output declarations to a task in the pipeline file at path.
+// The file is edited as a YAML node tree so comments and key order are preserved.
+func AddTaskOutputs(path string, taskName string, outputs []string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading pipeline file: %w", err)
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("error parsing pipeline file: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return fmt.Errorf("pipeline file %s is empty", path)
+	}
+
+	tasks := mappingValue(doc.Content[0], "tasks")
+	if tasks == nil || tasks.Kind != yaml.SequenceNode {
+		return fmt.Errorf("pipeline file %s has no task list", path)
+	}
+
+	var taskNode *yaml.Node
+	for _, node := range tasks.Content {
+		if name := mappingValue(node, "name"); name != nil && name.Value == taskName {
+			taskNode = node
+			break
+		}
+	}
+	if taskNode == nil {
+		return fmt.Errorf("unknown task '%s'", taskName)
+	}
+
+	outputsNode := mappingValue(taskNode, "outputs")
+	if outputsNode == nil {
+		outputsNode = &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		taskNode.Content = append(taskNode.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "outputs"},
+			outputsNode,
+		)
+	}
+
+	if outputsNode.Kind == yaml.MappingNode {
+		addNamedOutputs(outputsNode, outputs)
+	} else {
+		existing := map[string]bool{}
+		for _, node := range outputsNode.Content {
+			existing[node.Value] = true
+		}
+		for _, output := range outputs {
+			if !existing[output] {
+				outputsNode.Content = append(outputsNode.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: output})
+				existing[output] = true
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return fmt.Errorf("error encoding pipeline file: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("error encoding pipeline file: %w", err)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("error writing pipeline file: %w", err)
+	}
+	return nil
+}
+
+// addNamedOutputs adds outputs to a mapping of named outputs, named after the base name of their path
+func addNamedOutputs(outputsNode *yaml.Node, outputs []string) {
+	names := map[string]bool{}
+	paths := map[string]bool{}
+	for i := 0; i+1 < len(outputsNode.Content); i += 2 {
+		names[outputsNode.Content[i].Value] = true
+		paths[outputsNode.Content[i+1].Value] = true
+	}
+
+	for _, output := range outputs {
+		if paths[output] {
+			continue
+		}
+		name := filepath.Base(output)
+		for i := 2; names[name]; i++ {
+			name = fmt.Sprintf("%s-%d", filepath.Base(output), i)
+		}
+		outputsNode.Content = append(outputsNode.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: output},
+		)
+		names[name] = true
+		paths[output] = true
+	}
+}
+
+// mappingValue returns the value node stored under key in a YAML mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
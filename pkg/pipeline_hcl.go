@@ -0,0 +1,324 @@
+package pkg
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Pipelines can also be written in HCL, with a block per task:
+//
+//	vars = { go = "1.22" }
+//
+//	task "deps" {
+//	  image    = "golang:${vars.go}"
+//	  commands = ["go mod download"]
+//	}
+//
+//	task "build" {
+//	  image      = "golang:${vars.go}"
+//	  commands   = ["go build -o /out/app ./..."]
+//	  outputs    = ["/out/app"]
+//	  depends_on = ["deps"]
+//	}
+//
+// Attributes are named like the keys of YAML pipeline files. depends_on lists dependencies whose
+// artifacts are not copied, dependency blocks copy artifacts. ${vars.NAME} in HCL strings is the same as
+// ${{ vars.NAME }}, so values set on the command line apply as well. Services, readiness checks, image
+// inheritance and dependencies on existing containers are only available in YAML.
+
+type hclPipelineFile struct {
+	Docker  *hclDocker        `hcl:"docker,block"`
+	Network *hclNetwork       `hcl:"network,block"`
+	Stages  []string          `hcl:"stages,optional"`
+	Prelude string            `hcl:"prelude,optional"`
+	Cleanup string            `hcl:"cleanup,optional"`
+	Vars    map[string]string `hcl:"vars,optional"`
+	Tasks   []hclTask         `hcl:"task,block"`
+}
+
+type hclDocker struct {
+	Host      string `hcl:"host,optional"`
+	MaxExecs  int    `hcl:"max_execs,optional"`
+	MaxCopies int    `hcl:"max_copies,optional"`
+	TLSCACert string `hcl:"tls_ca,optional"`
+	TLSCert   string `hcl:"tls_cert,optional"`
+	TLSKey    string `hcl:"tls_key,optional"`
+}
+
+type hclNetwork struct {
+	Mode       string   `hcl:"mode,optional"`
+	ExtraHosts []string `hcl:"extra_hosts,optional"`
+	DNS        []string `hcl:"dns,optional"`
+}
+
+type hclTask struct {
+	Name         string            `hcl:"name,label"`
+	Image        string            `hcl:"image,optional"`
+	Build        *hclBuild         `hcl:"build,block"`
+	Commands     []string          `hcl:"commands,optional"`
+	Shell        []string          `hcl:"shell,optional"`
+	Cmd          [][]string        `hcl:"cmd,optional"`
+	Script       string            `hcl:"script,optional"`
+	DependsOn    []string          `hcl:"depends_on,optional"`
+	Dependencies []hclDependency   `hcl:"dependency,block"`
+	HashInputs   []string          `hcl:"hash_inputs,optional"`
+	Outputs      []string          `hcl:"outputs,optional"`
+	NamedOutputs map[string]string `hcl:"named_outputs,optional"`
+	Helper       string            `hcl:"helper,optional"`
+	Batch        bool              `hcl:"batch_commands,optional"`
+	Virtual      bool              `hcl:"virtual,optional"`
+	Secrets      []hclSecret       `hcl:"secret,block"`
+	Mounts       []hclMount        `hcl:"mount,block"`
+	Git          []hclGit          `hcl:"git,block"`
+	Downloads    []hclDownload     `hcl:"download,block"`
+	CacheDirs    []string          `hcl:"cache_dirs,optional"`
+	Exports      []hclExport       `hcl:"export,block"`
+	Network      *hclNetwork       `hcl:"network,block"`
+	ReadOnly     bool              `hcl:"read_only_artifacts,optional"`
+	Conflicts    string            `hcl:"artifact_conflicts,optional"`
+	User         string            `hcl:"user,optional"`
+	HostUser     bool              `hcl:"host_user,optional"`
+	Stage        string            `hcl:"stage,optional"`
+	Snapshot     bool              `hcl:"snapshot_on_failure,optional"`
+	ImageMirrors []string          `hcl:"image_mirrors,optional"`
+	OutputImage  string            `hcl:"output_image,optional"`
+	Push         bool              `hcl:"push,optional"`
+	Freshness    string            `hcl:"freshness,optional"`
+	Prelude      string            `hcl:"prelude,optional"`
+	When         string            `hcl:"when,optional"`
+	AllowFailure bool              `hcl:"allow_failure,optional"`
+	Cleanup      string            `hcl:"cleanup,optional"`
+	Platform     string            `hcl:"platform,optional"`
+	Platforms    []string          `hcl:"platforms,optional"`
+	Devices      []string          `hcl:"devices,optional"`
+	GPUs         string            `hcl:"gpus,optional"`
+	Privileged   bool              `hcl:"privileged,optional"`
+	CapAdd       []string          `hcl:"cap_add,optional"`
+	CapDrop      []string          `hcl:"cap_drop,optional"`
+	Seccomp      string            `hcl:"seccomp,optional"`
+	AppArmor     string            `hcl:"apparmor,optional"`
+	ReadOnlyFS   bool              `hcl:"read_only_rootfs,optional"`
+	Writable     []string          `hcl:"writable_paths,optional"`
+	Entrypoint   []string          `hcl:"entrypoint,optional"`
+	KeepAlive    []string          `hcl:"keep_alive,optional"`
+	Pause        bool              `hcl:"pause,optional"`
+}
+
+type hclBuild struct {
+	Context    string            `hcl:"context,optional"`
+	Dockerfile string            `hcl:"dockerfile,optional"`
+	Args       map[string]string `hcl:"args,optional"`
+}
+
+type hclDependency struct {
+	Task      string        `hcl:"task,label"`
+	Artifacts []hclArtifact `hcl:"artifact,block"`
+}
+
+type hclArtifact struct {
+	From     string `hcl:"from,optional"`
+	Output   string `hcl:"output,optional"`
+	To       string `hcl:"to"`
+	ReadOnly bool   `hcl:"read_only,optional"`
+}
+
+type hclSecret struct {
+	Name  string `hcl:"name,label"`
+	Env   string `hcl:"env,optional"`
+	File  string `hcl:"file,optional"`
+	Mount bool   `hcl:"mount,optional"`
+}
+
+type hclMount struct {
+	Type     string `hcl:"type"`
+	Source   string `hcl:"source,optional"`
+	Target   string `hcl:"target"`
+	ReadOnly bool   `hcl:"read_only,optional"`
+}
+
+type hclGit struct {
+	URL        string      `hcl:"url"`
+	Ref        string      `hcl:"ref,optional"`
+	Path       string      `hcl:"path"`
+	Depth      int         `hcl:"depth,optional"`
+	Submodules bool        `hcl:"submodules,optional"`
+	Auth       *hclGitAuth `hcl:"auth,block"`
+}
+
+type hclGitAuth struct {
+	Username    string `hcl:"username,optional"`
+	PasswordEnv string `hcl:"password_env,optional"`
+	SSHKey      string `hcl:"ssh_key,optional"`
+}
+
+type hclDownload struct {
+	URL         string `hcl:"url"`
+	Dest        string `hcl:"dest"`
+	SHA256      string `hcl:"sha256"`
+	Executable  bool   `hcl:"executable,optional"`
+	InContainer bool   `hcl:"in_container,optional"`
+}
+
+type hclExport struct {
+	From string `hcl:"from"`
+	To   string `hcl:"to"`
+}
+
+// ParsePipelineHCL parses an HCL pipeline file into a Pipeline. filename only appears in error messages.
+func ParsePipelineHCL(data []byte, filename string) (*Pipeline, error) {
+	parsed, diags := hclparse.NewParser().ParseHCL(data, filename)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("error parsing pipeline file: %w", diags)
+	}
+
+	ctx, diags := hclVarsContext(parsed.Body)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("error parsing pipeline file: %w", diags)
+	}
+	var spec hclPipelineFile
+	if diags := gohcl.DecodeBody(parsed.Body, ctx, &spec); diags.HasErrors() {
+		return nil, fmt.Errorf("error parsing pipeline file: %w", diags)
+	}
+
+	file, err := spec.pipelineFile()
+	if err != nil {
+		return nil, err
+	}
+	return file.pipeline()
+}
+
+// hclVarsContext returns the evaluation context of an HCL pipeline: vars.NAME of every variable the file
+// defines evaluates to its ${{ vars.NAME }} reference
+func hclVarsContext(body hcl.Body) (*hcl.EvalContext, hcl.Diagnostics) {
+	content, _, diags := body.PartialContent(&hcl.BodySchema{Attributes: []hcl.AttributeSchema{{Name: "vars"}}})
+	if diags.HasErrors() {
+		return nil, diags
+	}
+	references := map[string]cty.Value{}
+	if attr, ok := content.Attributes["vars"]; ok {
+		value, diags := attr.Expr.Value(nil)
+		if diags.HasErrors() {
+			return nil, diags
+		}
+		if value.CanIterateElements() {
+			for name := range value.AsValueMap() {
+				references[name] = cty.StringVal("${{ vars." + name + " }}")
+			}
+		}
+	}
+	return &hcl.EvalContext{Variables: map[string]cty.Value{"vars": cty.ObjectVal(references)}}, nil
+}
+
+// pipelineFile converts f to the representation of YAML pipeline files
+func (f *hclPipelineFile) pipelineFile() (*pipelineFile, error) {
+	file := &pipelineFile{Stages: f.Stages, Prelude: f.Prelude, Cleanup: CleanupPolicy(f.Cleanup), Vars: f.Vars, Network: f.Network.network()}
+	if f.Docker != nil {
+		file.Docker.Host = f.Docker.Host
+		file.Docker.MaxExecs = f.Docker.MaxExecs
+		file.Docker.MaxCopies = f.Docker.MaxCopies
+		file.Docker.TLS.CACert = f.Docker.TLSCACert
+		file.Docker.TLS.Cert = f.Docker.TLSCert
+		file.Docker.TLS.Key = f.Docker.TLSKey
+	}
+
+	for _, task := range f.Tasks {
+		spec := taskSpec{
+			Name:         task.Name,
+			Image:        task.Image,
+			Commands:     task.Commands,
+			Shell:        task.Shell,
+			Cmd:          task.Cmd,
+			Script:       task.Script,
+			HashInputs:   task.HashInputs,
+			Outputs:      outputsSpec{Paths: task.Outputs, Named: task.NamedOutputs},
+			Helper:       task.Helper,
+			Batch:        task.Batch,
+			Virtual:      task.Virtual,
+			CacheDirs:    task.CacheDirs,
+			Network:      task.Network.network(),
+			ReadOnly:     task.ReadOnly,
+			Conflicts:    ConflictPolicy(task.Conflicts),
+			User:         task.User,
+			HostUser:     task.HostUser,
+			Stage:        task.Stage,
+			Snapshot:     task.Snapshot,
+			ImageMirrors: task.ImageMirrors,
+			OutputImage:  task.OutputImage,
+			Push:         task.Push,
+			Prelude:      task.Prelude,
+			When:         task.When,
+			AllowFailure: task.AllowFailure,
+			Cleanup:      CleanupPolicy(task.Cleanup),
+			Platform:     task.Platform,
+			Platforms:    task.Platforms,
+			Devices:      task.Devices,
+			GPUs:         task.GPUs,
+			Privileged:   task.Privileged,
+			CapAdd:       task.CapAdd,
+			CapDrop:      task.CapDrop,
+			Seccomp:      task.Seccomp,
+			AppArmor:     task.AppArmor,
+			ReadOnlyFS:   task.ReadOnlyFS,
+			Writable:     task.Writable,
+			Entrypoint:   task.Entrypoint,
+			KeepAlive:    task.KeepAlive,
+			Pause:        task.Pause,
+		}
+		if len(task.Outputs) > 0 && len(task.NamedOutputs) > 0 {
+			return nil, fmt.Errorf("task '%s' can either have outputs or named outputs", task.Name)
+		}
+		if task.Freshness != "" {
+			freshness, err := time.ParseDuration(task.Freshness)
+			if err != nil {
+				return nil, fmt.Errorf("invalid freshness of task '%s': %w", task.Name, err)
+			}
+			spec.Freshness = freshness
+		}
+		if task.Build != nil {
+			spec.Build = &buildSpec{Context: task.Build.Context, Dockerfile: task.Build.Dockerfile, Args: task.Build.Args}
+		}
+		for _, name := range task.DependsOn {
+			spec.Dependencies = append(spec.Dependencies, dependencySpec{Task: name})
+		}
+		for _, dependency := range task.Dependencies {
+			depSpec := dependencySpec{Task: dependency.Task}
+			for _, artifact := range dependency.Artifacts {
+				depSpec.Artifacts = append(depSpec.Artifacts, Artifact(artifact))
+			}
+			spec.Dependencies = append(spec.Dependencies, depSpec)
+		}
+		for _, secret := range task.Secrets {
+			spec.Secrets = append(spec.Secrets, secretSpec(secret))
+		}
+		for _, m := range task.Mounts {
+			spec.Mounts = append(spec.Mounts, Mount(m))
+		}
+		for _, git := range task.Git {
+			source := GitSource{URL: git.URL, Ref: git.Ref, Path: git.Path, Depth: git.Depth, Submodules: git.Submodules}
+			if git.Auth != nil {
+				source.Auth = (*GitAuth)(git.Auth)
+			}
+			spec.Git = append(spec.Git, source)
+		}
+		for _, download := range task.Downloads {
+			spec.Downloads = append(spec.Downloads, Download(download))
+		}
+		for _, export := range task.Exports {
+			spec.Exports = append(spec.Exports, Export(export))
+		}
+		file.Tasks = append(file.Tasks, spec)
+	}
+	return file, nil
+}
+
+func (n *hclNetwork) network() *Network {
+	if n == nil {
+		return nil
+	}
+	return &Network{Mode: n.Mode, ExtraHosts: n.ExtraHosts, DNS: n.DNS}
+}
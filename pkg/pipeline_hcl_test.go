@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testHCLPipeline = `
+vars = { go = "1.22" }
+
+# Downloads modules once
+task "deps" {
+  image    = "golang:${vars.go}"
+  commands = ["go mod download"]
+}
+
+task "build" {
+  image      = "golang:${vars.go}"
+  commands   = ["go build -o /out/app ./..."]
+  outputs    = ["/out/app"]
+  depends_on = ["deps"]
+  freshness  = "1h"
+  when       = "branch == 'main'"
+}
+
+task "image" {
+  image = "alpine"
+  dependency "build" {
+    artifact {
+      from = "/out/app"
+      to   = "/usr/local/bin/app"
+    }
+  }
+  secret "TOKEN" {}
+}
+`
+
+func TestParsePipelineHCL(t *testing.T) {
+	pipeline, err := ParsePipelineHCL([]byte(testHCLPipeline), "buildvault.hcl")
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+	if len(pipeline.Tasks) != 3 {
+		t.Fatalf("Expected 3 tasks, got %d", len(pipeline.Tasks))
+	}
+
+	build, _ := pipeline.Task("build")
+	if build.BaseImage != "golang:${{ vars.go }}" || build.Freshness != time.Hour || build.When == nil {
+		t.Errorf("Unexpected build task %+v", build)
+	}
+	if len(build.Dependencies) != 1 || build.Dependencies[0].Task.Name != "deps" || len(build.Dependencies[0].Artifacts) != 0 {
+		t.Errorf("Unexpected dependencies of build %+v", build.Dependencies)
+	}
+	if err := pipeline.SetVar("go", "1.23"); err != nil {
+		t.Fatalf("Failed to set variable: %v", err)
+	}
+	if err := build.resolveVars(); err != nil || build.BaseImage != "golang:1.23" {
+		t.Errorf("Expected the variable set on the command line, got %s (%v)", build.BaseImage, err)
+	}
+
+	image, _ := pipeline.Task("image")
+	if artifacts := image.Dependencies[0].Artifacts; len(artifacts) != 1 || artifacts[0].To != "/usr/local/bin/app" {
+		t.Errorf("Unexpected artifacts %+v", artifacts)
+	}
+	if len(image.Secrets) != 1 || image.Secrets[0].Env != "TOKEN" {
+		t.Errorf("Unexpected secrets %+v", image.Secrets)
+	}
+}
+
+func TestParsePipelineHCLErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown attribute":  `task "build" { image = "alpine" imagee = "x" }`,
+		"unknown dependency": `task "build" { image = "alpine" depends_on = ["missing"] }`,
+		"undefined variable": `task "build" { image = "golang:${vars.go}" }`,
+		"invalid freshness":  `task "build" { image = "alpine" freshness = "soon" }`,
+		"syntax error":       `task "build" {`,
+	}
+	for name, data := range tests {
+		if _, err := ParsePipelineHCL([]byte(data), "buildvault.hcl"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestLoadPipelineHCL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buildvault.hcl")
+	if err := os.WriteFile(path, []byte(testHCLPipeline), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := LoadPipeline(path)
+	if err != nil {
+		t.Fatalf("Failed to load pipeline: %v", err)
+	}
+	if roots := pipeline.Roots(); len(roots) != 1 || roots[0].Name != "image" {
+		t.Errorf("Unexpected roots %v", roots)
+	}
+}
@@ -0,0 +1,166 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePipeline(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+tasks:
+  - name: consumer
+    image: alpine
+    dependencies:
+      - task: producer
+        artifacts:
+          - from: /output/data.txt
+            to: /input/data.txt
+    commands:
+      - cat /input/data.txt
+  - name: producer
+    image: alpine
+    commands:
+      - mkdir -p /output
+      - echo data > /output/data.txt
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+
+	consumer, ok := pipeline.Task("consumer")
+	if !ok {
+		t.Fatalf("Task 'consumer' not found")
+	}
+	producer, _ := pipeline.Task("producer")
+
+	if len(consumer.Dependencies) != 1 || consumer.Dependencies[0].Task != producer {
+		t.Fatalf("Consumer dependency should resolve to the producer task")
+	}
+	if artifact := consumer.Dependencies[0].Artifacts[0]; artifact.From != "/output/data.txt" || artifact.To != "/input/data.txt" {
+		t.Errorf("Unexpected artifact: %+v", artifact)
+	}
+
+	roots := pipeline.Roots()
+	if len(roots) != 1 || roots[0] != consumer {
+		t.Errorf("Expected consumer to be the only root task, got %d roots", len(roots))
+	}
+}
+
+func TestParsePipelineErrors(t *testing.T) {
+	tests := map[string]string{
+		"unknown dependency": "tasks:\n  - name: a\n    image: alpine\n    dependencies:\n      - task: missing\n",
+		"duplicate name":     "tasks:\n  - name: a\n    image: alpine\n  - name: a\n    image: alpine\n",
+		"missing name":       "tasks:\n  - image: alpine\n",
+	}
+
+	for name, data := range tests {
+		if _, err := ParsePipeline([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestAddTaskOutputs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buildvault.yaml")
+	original := `# build pipeline
+tasks:
+  - name: build
+    image: alpine
+    outputs:
+      - /output/app
+`
+	if err := os.WriteFile(path, []byte(original), 0o644); err != nil {
+		t.Fatalf("Failed to write pipeline file: %v", err)
+	}
+
+	if err := AddTaskOutputs(path, "build", []string{"/output/app", "/output/docs"}); err != nil {
+		t.Fatalf("Failed to add outputs: %v", err)
+	}
+
+	pipeline, err := LoadPipeline(path)
+	if err != nil {
+		t.Fatalf("Failed to load updated pipeline: %v", err)
+	}
+	task, _ := pipeline.Task("build")
+	if len(task.Outputs) != 2 || task.Outputs[0] != "/output/app" || task.Outputs[1] != "/output/docs" {
+		t.Errorf("Unexpected outputs after update: %v", task.Outputs)
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "# build pipeline") {
+		t.Errorf("Comments should be preserved, got:\n%s", data)
+	}
+
+	if err := AddTaskOutputs(path, "missing", []string{"/x"}); err == nil {
+		t.Errorf("Expected an error for an unknown task")
+	}
+}
+
+func TestParsePipelineBuild(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "buildvault.yaml")
+	data := "tasks:\n  - name: toolchain\n    build:\n      context: ./docker\n      args:\n        VERSION: \"1.0\"\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("Failed to write pipeline file: %v", err)
+	}
+
+	pipeline, err := LoadPipeline(path)
+	if err != nil {
+		t.Fatalf("Failed to load pipeline: %v", err)
+	}
+	task, _ := pipeline.Task("toolchain")
+	if task.Build == nil || task.Build.Context != filepath.Join(dir, "docker") || task.Build.Args["VERSION"] != "1.0" {
+		t.Errorf("Unexpected build definition: %+v", task.Build)
+	}
+
+	if _, err := ParsePipeline([]byte("tasks:\n  - name: a\n")); err == nil {
+		t.Errorf("Expected an error for a task without image or build")
+	}
+	cyclic := "tasks:\n  - name: a\n    image: alpine\n    dependencies:\n      - task: b\n  - name: b\n    image: alpine\n    dependencies:\n      - task: a\n"
+	if _, err := ParsePipeline([]byte(cyclic)); err == nil {
+		t.Errorf("Expected an error for a circular dependency")
+	}
+}
+
+func TestParsePipelineNamedOutputs(t *testing.T) {
+	data := `tasks:
+  - name: build
+    image: golang
+    outputs:
+      binary: /output/app
+  - name: package
+    image: alpine
+    dependencies:
+      - task: build
+        artifacts:
+          - output: binary
+            to: /bin/app
+`
+	pipeline, err := ParsePipeline([]byte(data))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+
+	build, _ := pipeline.Task("build")
+	if build.NamedOutputs["binary"] != "/output/app" || len(build.declaredOutputs()) != 1 {
+		t.Errorf("Unexpected outputs: %v", build.declaredOutputs())
+	}
+	pkgTask, _ := pipeline.Task("package")
+	if artifact := pkgTask.Dependencies[0].Artifacts[0]; artifact.From != "/output/app" || artifact.To != "/bin/app" {
+		t.Errorf("Output reference was not resolved: %+v", artifact)
+	}
+
+	unknown := strings.Replace(data, "output: binary", "output: library", 1)
+	if _, err := ParsePipeline([]byte(unknown)); err == nil {
+		t.Errorf("Expected an error for an unknown output name")
+	}
+
+	// The Go API builds the same dependency
+	dependency := build.Output("binary").CopyTo("/bin/app")
+	consumer := &Task{Name: "consumer", BaseImage: "alpine", Dependencies: []Dependency{dependency}}
+	if err := consumer.resolveOutputRefs(); err != nil || consumer.Dependencies[0].Artifacts[0].From != "/output/app" {
+		t.Errorf("Output reference was not resolved: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// PlanStep describes what executing a task would do.
+type PlanStep struct {
+	Task      string             // Name of the task
+	Hash      string             // Hash of the task, empty if it can only be computed during execution
+	CacheHit  bool               // The outputs are in the artifact store, so the task would be skipped
+	Virtual   bool               // The task only re-exports artifacts of its dependencies and never executes
+	External  bool               // The task is an existing container that is only read from
+	Skipped   bool               // The condition of the task or of one of its dependencies does not hold
+	Reason    string             // Why the task would execute, or why its hash is not known yet
+	Changes   []string           // Components of the hash that changed since the newest stored generation of the task
+	Copies    []PlannedCopy      // Artifacts that would be copied into the task container
+	Conflicts []ArtifactConflict // Artifacts of different dependencies copied to overlapping paths
+	Policy    ConflictPolicy     // How the conflicts are resolved
+}
+
+// PlannedCopy is an artifact copy of a plan step.
+type PlannedCopy struct {
+	Task string // Dependency the artifact comes from
+	From string
+	To   string
+}
+
+// Plan resolves the task graph of t and reports, in execution order, which tasks would be cache hits and
+// which would execute, without touching Docker.
+func (t *Task) Plan(ctx context.Context) ([]PlanStep, error) {
+	return PlanTasks(ctx, []*Task{t})
+}
+
+// PlanTasks plans the execution of several tasks, listing shared dependencies once.
+func PlanTasks(ctx context.Context, tasks []*Task) ([]PlanStep, error) {
+	var steps []PlanStep
+	planned := map[*Task]PlanStep{}
+
+	var visit func(t *Task) error
+	visit = func(t *Task) error {
+		if _, ok := planned[t]; ok {
+			return nil
+		}
+		for _, dependency := range t.Dependencies {
+			if err := visit(dependency.Task); err != nil {
+				return err
+			}
+		}
+
+		step, err := t.planStep(ctx, planned)
+		if err != nil {
+			return err
+		}
+		steps = append(steps, step)
+		planned[t] = step
+		return nil
+	}
+
+	for _, task := range tasks {
+		if !task.isCircularDependencyFree(nil) {
+			return nil, fmt.Errorf("circular dependency found in task '%s'", task.Name)
+		}
+		if err := task.resolveVars(); err != nil {
+			return nil, err
+		}
+		if err := task.resolveOutputRefs(); err != nil {
+			return nil, err
+		}
+		if err := task.hashHostInputs(); err != nil {
+			return nil, err
+		}
+		if err := visit(task); err != nil {
+			return nil, err
+		}
+	}
+	return steps, nil
+}
+
+// planStep plans t, whose dependencies have already been planned
+func (t *Task) planStep(ctx context.Context, planned map[*Task]PlanStep) (PlanStep, error) {
+	if err := t.validateArtifactConflicts(); err != nil {
+		return PlanStep{}, err
+	}
+	step := PlanStep{Task: t.Name, Conflicts: t.artifactConflicts()}
+	step.Policy, _ = ParseConflictPolicy(string(t.ArtifactConflicts))
+	for _, c := range t.resolvedCopies() {
+		step.Copies = append(step.Copies, plannedCopy(c))
+	}
+
+	if t.Container != "" {
+		step.External = true
+		step.Reason = "the ID of its container is only resolved during execution"
+		return step, nil
+	}
+	if t.skippedByCondition() {
+		step.Skipped = true
+		step.Reason = "its condition does not hold"
+		step.Copies = nil
+		return step, nil
+	}
+	for _, dependency := range t.Dependencies {
+		if planned[dependency.Task].Skipped {
+			step.Skipped = true
+			step.Reason = fmt.Sprintf("its dependency '%s' would be skipped", dependency.Task.Name)
+			step.Copies = nil
+			return step, nil
+		}
+	}
+	if t.Build != nil && t.imageID == "" {
+		step.Reason = "its image is built from a Dockerfile during execution"
+		return step, nil
+	}
+	for _, dependency := range t.Dependencies {
+		if planned[dependency.Task].Hash == "" {
+			step.Reason = fmt.Sprintf("the hash of its dependency '%s' is not known yet", dependency.Task.Name)
+			return step, nil
+		}
+		if dependency.Task.Freshness > 0 && !dependency.Task.Virtual && !planned[dependency.Task].CacheHit {
+			step.Reason = fmt.Sprintf("its dependency '%s' would refresh its outputs, whose digests its hash includes", dependency.Task.Name)
+			return step, nil
+		}
+	}
+
+	if t.Virtual {
+		step.Hash = t.generateHash()
+		step.Virtual = true
+		step.Copies = nil
+		return step, nil
+	}
+
+	for _, input := range t.HashInputs {
+		dependency, artifact, ok := t.artifactInputSource(input)
+		if !ok {
+			continue
+		}
+		source, sourcePath, err := resolveArtifactSource(dependency, artifact.From)
+		if err != nil {
+			return step, err
+		}
+		digest, err := plannedArtifactDigest(ctx, source, planned[source], sourcePath)
+		if err != nil {
+			return step, err
+		}
+		if digest == "" {
+			step.Reason = fmt.Sprintf("hash input %s is an artifact of '%s', which would execute", input, dependency.Name)
+			return step, nil
+		}
+		if t.inputDigests == nil {
+			t.inputDigests = map[string]string{}
+		}
+		t.inputDigests[input] = digest
+	}
+
+	step.Hash = t.generateHash()
+	if t.ArtifactStore == nil {
+		step.Reason = "no artifact store is configured"
+		return step, nil
+	}
+
+	manifest, ok, err := t.ArtifactStore.peekManifest(ctx, step.Hash)
+	if err != nil {
+		return step, err
+	}
+	if ok && !t.fresh(manifest, time.Now()) {
+		step.Reason = t.staleReason(manifest, time.Now())
+	} else if ok {
+		step.CacheHit = true
+		step.Copies = nil
+		t.recordStoredOutputs(manifest)
+	} else {
+		step.Reason = "its outputs are not in the artifact store"
+		previous, err := t.ArtifactStore.lastSnapshot(t.Name)
+		if err != nil {
+			return step, err
+		}
+		if previous != nil {
+			step.Changes = t.ExplainStaleness(*previous)
+		}
+	}
+	return step, nil
+}
+
+// plannedArtifactDigest returns the digest of an artifact of a cached dependency as recorded in its
+// store manifest, or "" if it is only known once the dependency executed
+func plannedArtifactDigest(ctx context.Context, dependency *Task, step PlanStep, from string) (string, error) {
+	if !step.CacheHit {
+		return "", nil
+	}
+	manifest, _, err := dependency.ArtifactStore.peekManifest(ctx, step.Hash)
+	if err != nil {
+		return "", err
+	}
+	for _, artifact := range manifest.Artifacts {
+		if artifact.Path == from {
+			return artifact.ContentDigest, nil
+		}
+	}
+	return "", nil
+}
+
+// peekManifest reads the manifest of a task hash from the local store or the remote backend without
+// downloading any artifacts
+func (s *ArtifactStore) peekManifest(ctx context.Context, hash string) (*StoreManifest, bool, error) {
+	data, err := os.ReadFile(s.manifestPath(hash))
+	if errors.Is(err, os.ErrNotExist) && s.remote != nil {
+		return s.remoteManifest(ctx, hash)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error reading store manifest: %w", err)
+	}
+
+	manifest, err := parseStoreManifest(data)
+	if err != nil {
+		return nil, false, err
+	}
+	return manifest, true, nil
+}
@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPlan(t *testing.T) {
+	store, err := NewArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generate := &Task{Name: "generate", BaseImage: "alpine", Outputs: []string{"/out/data"}, ArtifactStore: store}
+	consume := &Task{
+		Name:          "consume",
+		BaseImage:     "alpine",
+		HashInputs:    []string{"/in/data"},
+		Dependencies:  []Dependency{{Task: generate, Artifacts: []Artifact{{From: "/out/data", To: "/in/data"}}}},
+		ArtifactStore: store,
+	}
+
+	steps, err := consume.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	if len(steps) != 2 || steps[0].Task != "generate" || steps[0].CacheHit || steps[0].Hash == "" {
+		t.Fatalf("Unexpected plan: %+v", steps)
+	}
+	if steps[1].Hash != "" || len(steps[1].Copies) != 1 {
+		t.Errorf("The hash of consume depends on an artifact of an executing task: %+v", steps[1])
+	}
+
+	// Once generate is stored, the artifact digest is known from its manifest
+	err = store.writeManifest(&StoreManifest{Task: "generate", Hash: steps[0].Hash, Created: time.Now(), Artifacts: []StoredArtifact{
+		{Path: "/out/data", Digest: "blob", ContentDigest: "content"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	steps, err = consume.Plan(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to plan: %v", err)
+	}
+	if !steps[0].CacheHit || steps[1].Hash == "" || steps[1].CacheHit || steps[1].Reason == "" {
+		t.Errorf("Unexpected plan: %+v", steps)
+	}
+}
@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/containerd/platforms"
+	"github.com/docker/docker/errdefs"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// parsePlatform parses a platform like linux/arm64 for the Docker API, nil for the platform of the daemon
+func parsePlatform(s string) (*ocispec.Platform, error) {
+	if s == "" {
+		return nil, nil
+	}
+	platform, err := platforms.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid platform '%s': %w", s, err)
+	}
+	return &platform, nil
+}
+
+// platform returns the platform the container of t runs on, nil for the platform of the daemon
+func (t *Task) platform() *ocispec.Platform {
+	// Pipeline files are validated when they are loaded
+	platform, _ := parsePlatform(t.Platform)
+	return platform
+}
+
+// imageAvailable reports whether image exists locally, for platform if one is given. Images pulled for
+// another platform have the same tag, so only their architecture tells them apart.
+func imageAvailable(ctx context.Context, cli ImageAPI, image, platform string) (bool, error) {
+	if platform == "" {
+		return imageExistsLocally(cli, image)
+	}
+	want, err := parsePlatform(platform)
+	if err != nil {
+		return false, err
+	}
+	inspect, err := cli.ImageInspect(ctx, image)
+	if errdefs.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error inspecting image %s: %w", image, err)
+	}
+	return platformMatches(*want, ocispec.Platform{OS: inspect.Os, Architecture: inspect.Architecture, Variant: inspect.Variant}), nil
+}
+
+// platformMatches reports whether an image of platform have runs on want
+func platformMatches(want, have ocispec.Platform) bool {
+	return platforms.OnlyStrict(want).Match(have)
+}
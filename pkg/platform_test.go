@@ -0,0 +1,40 @@
+package pkg
+
+import (
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPlatformChangesTaskHash(t *testing.T) {
+	native := &Task{Name: "build", BaseImage: "golang:1.23", Commands: []string{"go build ./..."}}
+	arm := &Task{Name: "build", BaseImage: "golang:1.23", Commands: []string{"go build ./..."}, Platform: "linux/arm64"}
+	if native.generateHash() == arm.generateHash() {
+		t.Error("Expected the platform to change the task hash")
+	}
+}
+
+func TestParsePipelinePlatform(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte("tasks:\n  - name: build\n    image: alpine\n    platform: linux/arm64\n"))
+	if err != nil {
+		t.Fatalf("Expected the pipeline to parse, got %v", err)
+	}
+	platform := pipeline.Tasks[0].platform()
+	if platform == nil || platform.OS != "linux" || platform.Architecture != "arm64" {
+		t.Errorf("Expected linux/arm64, got %v", platform)
+	}
+
+	if _, err := ParsePipeline([]byte("tasks:\n  - name: build\n    image: alpine\n    platform: linux/arm64/v8/extra\n")); err == nil {
+		t.Error("Expected an invalid platform to be rejected")
+	}
+}
+
+func TestPlatformMatches(t *testing.T) {
+	want := ocispec.Platform{OS: "linux", Architecture: "arm64"}
+	if !platformMatches(want, ocispec.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}) {
+		t.Error("Expected an arm64 v8 image to match linux/arm64")
+	}
+	if platformMatches(want, ocispec.Platform{OS: "linux", Architecture: "amd64"}) {
+		t.Error("Expected an amd64 image not to match linux/arm64")
+	}
+}
@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// maxParallelPulls bounds how many images PrePullImages pulls at the same time
+const maxParallelPulls = 4
+
+// PrePullImages pulls the missing base and service images of tasks in parallel, so that a deep pipeline
+// does not wait for the download of each image only once the tasks before it finished. Images built
+// from Dockerfiles, pulled through mirrors or for another platform are left to their tasks. A failed pull is only reported,
+// the task needing the image pulls it again and fails if it still cannot be pulled.
+func PrePullImages(ctx context.Context, cli ImageAPI, tasks []*Task) {
+	var missing []string
+	for _, image := range prePullImages(tasks) {
+		exists, err := imageExistsLocally(cli, image)
+		if err != nil || exists {
+			continue
+		}
+		missing = append(missing, image)
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	// Progress bars of concurrent pulls would overwrite each other
+	mode := pullProgress
+	if mode == PullProgressAuto {
+		mode = PullProgressLines
+	}
+	semaphore := make(chan struct{}, maxParallelPulls)
+	var wg sync.WaitGroup
+	for _, image := range missing {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := acquire(ctx, semaphore)
+			if err != nil {
+				return
+			}
+			defer release()
+			if err := pullWith(ctx, cli, image, "", mode); err != nil {
+				fmt.Printf("Failed to pre-pull image %s: %v\n", image, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// prePullImages returns the images the tasks pull, each once, in execution order
+func prePullImages(tasks []*Task) []string {
+	var images []string
+	seen := map[string]bool{}
+	add := func(image string) {
+		if image != "" && !seen[image] {
+			seen[image] = true
+			images = append(images, image)
+		}
+	}
+	for _, task := range tasks {
+		for _, service := range task.Services {
+			add(service.Image)
+		}
+		if task.imageID != "" || task.Virtual || task.Container != "" || task.Build != nil || len(task.ImageMirrors) > 0 || task.Platform != "" {
+			continue
+		}
+		add(task.BaseImage)
+	}
+	return images
+}
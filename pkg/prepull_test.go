@@ -0,0 +1,23 @@
+package pkg
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPrePullImages(t *testing.T) {
+	tasks := []*Task{
+		{Name: "deps", BaseImage: "node:20"},
+		{Name: "lint", BaseImage: "node:20"},
+		{Name: "test", BaseImage: "golang:1.23", Services: []Service{{Name: "db", Image: "postgres:16"}}},
+		{Name: "built", BaseImage: "ignored", Build: &ImageBuild{Context: "."}},
+		{Name: "mirrored", BaseImage: "alpine:3.20", ImageMirrors: []string{"mirror.example.com"}},
+		{Name: "db", Container: "postgres"},
+		{Name: "all", Virtual: true},
+		{Name: "pulled", BaseImage: "busybox", imageID: "sha256:abc"},
+	}
+	want := []string{"node:20", "postgres:16", "golang:1.23"}
+	if got := prePullImages(tasks); !slices.Equal(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// progressInterval is how often progress of a running copy is reported
+const progressInterval = 2 * time.Second
+
+// CopyProgress is the state of a streaming copy.
+type CopyProgress struct {
+	Bytes   int64         // Bytes copied so far
+	Elapsed time.Duration // Time since the copy started
+	Done    bool          // The source was read completely
+}
+
+// Throughput returns the average bytes per second of the copy.
+func (p CopyProgress) Throughput() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Elapsed.Seconds()
+}
+
+func (p CopyProgress) String() string {
+	return fmt.Sprintf("%s in %s (%s/s)", units.HumanSize(float64(p.Bytes)), p.Elapsed.Round(time.Millisecond), units.HumanSize(p.Throughput()))
+}
+
+// progressReader passes a stream through unchanged and reports the bytes read periodically and at EOF.
+// Nothing is buffered, so arbitrarily large artifacts are copied in constant memory.
+type progressReader struct {
+	r          io.Reader
+	report     func(CopyProgress)
+	bytes      int64
+	start      time.Time
+	lastReport time.Time
+	now        func() time.Time
+}
+
+func newProgressReader(r io.Reader, report func(CopyProgress)) *progressReader {
+	start := time.Now()
+	return &progressReader{r: r, report: report, start: start, lastReport: start, now: time.Now}
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	p.bytes += int64(n)
+
+	now := p.now()
+	if err == io.EOF {
+		p.report(CopyProgress{Bytes: p.bytes, Elapsed: now.Sub(p.start), Done: true})
+	} else if now.Sub(p.lastReport) >= progressInterval {
+		p.lastReport = now
+		p.report(CopyProgress{Bytes: p.bytes, Elapsed: now.Sub(p.start)})
+	}
+	return n, err
+}
@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressReader(t *testing.T) {
+	var reports []CopyProgress
+	p := newProgressReader(strings.NewReader(strings.Repeat("x", 10000)), func(progress CopyProgress) {
+		reports = append(reports, progress)
+	})
+
+	// Every read advances the clock by one second, so every second read is reported
+	clock := p.start
+	p.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	buf := make([]byte, 1000)
+	total := 0
+	for {
+		n, err := p.Read(buf)
+		total += n
+		if err == io.EOF {
+			break
+		}
+	}
+
+	if total != 10000 {
+		t.Fatalf("Expected 10000 bytes, got %d", total)
+	}
+	last := reports[len(reports)-1]
+	if !last.Done || last.Bytes != 10000 || last.Throughput() != 10000.0/11 {
+		t.Errorf("Unexpected final report %+v", last)
+	}
+	if len(reports) != 6 {
+		t.Errorf("Expected 5 intermediate reports and a final one, got %d", len(reports))
+	}
+}
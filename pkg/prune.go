@@ -0,0 +1,160 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// PruneOptions selects which buildvault containers Prune removes. Empty filters match everything.
+type PruneOptions struct {
+	TaskName  string        // Only prune containers of the task with this name
+	OlderThan time.Duration // Only prune containers created longer ago than this
+	Keep      []*Task       // Current pipeline tasks; containers matching their hashes (or their dependencies') are kept
+	DryRun    bool          // Report what would be removed without removing anything
+}
+
+// PrunedContainer describes a container selected by Prune.
+type PrunedContainer struct {
+	ID       string
+	Name     string
+	TaskName string
+	Hash     string
+	Created  time.Time
+}
+
+// parseContainerName splits a buildvault container name into task name and hash.
+// Task names may contain underscores, so the hash is always the last segment.
+func parseContainerName(name string) (taskName string, hash string, ok bool) {
+	name = strings.TrimPrefix(name, "/")
+	rest, ok := strings.CutPrefix(name, containerNamePrefix())
+	if !ok {
+		return "", "", false
+	}
+
+	idx := strings.LastIndex(rest, "_")
+	if idx <= 0 || idx == len(rest)-1 {
+		return "", "", false
+	}
+
+	return rest[:idx], rest[idx+1:], true
+}
+
+// collectHashes returns the hashes of the given tasks and all their transitive dependencies.
+func collectHashes(tasks []*Task) map[string]bool {
+	hashes := map[string]bool{}
+	var visit func(t *Task)
+	visit = func(t *Task) {
+		hash := t.generateHash()
+		if hashes[hash] {
+			return
+		}
+		hashes[hash] = true
+		for _, dependency := range t.Dependencies {
+			visit(dependency.Task)
+		}
+	}
+
+	for _, task := range tasks {
+		visit(task)
+	}
+	return hashes
+}
+
+// listBuildvaultContainers returns all containers managed by buildvault. Computing sizes is expensive
+// for the daemon, so they are only included when withSize is set.
+func listBuildvaultContainers(ctx context.Context, cli ContainerAPI, withSize bool) ([]container.Summary, error) {
+	labelled := filters.NewArgs()
+	labelled.Add("label", labelSource+"="+sourceTask)
+	if namespace != "" {
+		labelled.Add("label", labelNamespace+"="+namespace)
+	}
+	// Containers created before task containers were labelled are only recognizable by their names
+	named := filters.NewArgs()
+	named.Add("name", containerNamePrefix())
+
+	var result []container.Summary
+	seen := map[string]bool{}
+	for _, listFilters := range []filters.Args{labelled, named} {
+		containers, err := cli.ContainerList(ctx, container.ListOptions{
+			All:     true,
+			Size:    withSize,
+			Filters: listFilters,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error listing buildvault containers: %w", err)
+		}
+
+		// The name filter matches substrings, so names are parsed again afterwards
+		for _, containerSummary := range containers {
+			if _, _, ok := taskContainer(containerSummary); ok && !seen[containerSummary.ID] {
+				seen[containerSummary.ID] = true
+				result = append(result, containerSummary)
+			}
+		}
+	}
+	return result, nil
+}
+
+// selectPruneCandidates applies the prune filters to the given containers.
+func selectPruneCandidates(containers []container.Summary, opts PruneOptions, now time.Time) []PrunedContainer {
+	keep := collectHashes(opts.Keep)
+
+	var candidates []PrunedContainer
+	for _, containerSummary := range containers {
+		if len(containerSummary.Names) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(containerSummary.Names[0], "/")
+		taskName, hash, ok := taskContainer(containerSummary)
+		if !ok {
+			continue
+		}
+		created := time.Unix(containerSummary.Created, 0)
+
+		if opts.TaskName != "" && taskName != opts.TaskName {
+			continue
+		}
+		if opts.OlderThan > 0 && now.Sub(created) < opts.OlderThan {
+			continue
+		}
+		if keep[hash] {
+			continue
+		}
+
+		candidates = append(candidates, PrunedContainer{
+			ID:       containerSummary.ID,
+			Name:     name,
+			TaskName: taskName,
+			Hash:     hash,
+			Created:  created,
+		})
+	}
+	return candidates
+}
+
+// Prune removes buildvault task containers matching the given options and returns the containers it selected.
+func Prune(ctx context.Context, cli ContainerAPI, opts PruneOptions) ([]PrunedContainer, error) {
+	containers, err := listBuildvaultContainers(ctx, cli, false)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := selectPruneCandidates(containers, opts, time.Now())
+	if opts.DryRun {
+		return candidates, nil
+	}
+
+	for _, candidate := range candidates {
+		fmt.Printf("Removing container %s (task '%s')\n", candidate.Name, candidate.TaskName)
+		if err := cli.ContainerRemove(ctx, candidate.ID, container.RemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
+			return nil, fmt.Errorf("error removing container %s: %w", candidate.Name, err)
+		}
+	}
+
+	return candidates, nil
+}
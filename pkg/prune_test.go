@@ -0,0 +1,115 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	imagetypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestParseContainerName(t *testing.T) {
+	tests := []struct {
+		name     string
+		taskName string
+		hash     string
+		ok       bool
+	}{
+		{"/buildvault_build_0123456789ab", "build", "0123456789ab", true},
+		{"buildvault_my_task_0123456789ab", "my_task", "0123456789ab", true},
+		{"/other_build_0123456789ab", "", "", false},
+		{"/buildvault_nohash", "", "", false},
+		{"/buildvault_trailing_", "", "", false},
+	}
+
+	for _, tt := range tests {
+		taskName, hash, ok := parseContainerName(tt.name)
+		if taskName != tt.taskName || hash != tt.hash || ok != tt.ok {
+			t.Errorf("parseContainerName(%q) = (%q, %q, %v), want (%q, %q, %v)",
+				tt.name, taskName, hash, ok, tt.taskName, tt.hash, tt.ok)
+		}
+	}
+}
+
+func TestSelectPruneCandidates(t *testing.T) {
+	now := time.Now()
+	current := &Task{Name: "build", BaseImage: "alpine", Commands: []string{"echo current"}}
+	stale := &Task{Name: "build", BaseImage: "alpine", Commands: []string{"echo stale"}}
+	other := &Task{Name: "test", BaseImage: "alpine", Commands: []string{"echo test"}}
+
+	summary := func(task *Task, age time.Duration) container.Summary {
+		return container.Summary{
+			ID:      task.generateHash(),
+			Names:   []string{"/" + task.generateContainerName()},
+			Created: now.Add(-age).Unix(),
+		}
+	}
+	containers := []container.Summary{
+		summary(current, time.Hour),
+		summary(stale, 48*time.Hour),
+		summary(other, 2*time.Hour),
+	}
+
+	names := func(candidates []PrunedContainer) map[string]bool {
+		result := map[string]bool{}
+		for _, candidate := range candidates {
+			result[candidate.Name] = true
+		}
+		return result
+	}
+
+	all := selectPruneCandidates(containers, PruneOptions{}, now)
+	if len(all) != 3 {
+		t.Errorf("Expected all 3 containers without filters, got %d", len(all))
+	}
+
+	byTask := names(selectPruneCandidates(containers, PruneOptions{TaskName: "build"}, now))
+	if len(byTask) != 2 || byTask[other.generateContainerName()] {
+		t.Errorf("Task filter should only select 'build' containers, got %v", byTask)
+	}
+
+	byAge := names(selectPruneCandidates(containers, PruneOptions{OlderThan: 24 * time.Hour}, now))
+	if len(byAge) != 1 || !byAge[stale.generateContainerName()] {
+		t.Errorf("Age filter should only select the stale container, got %v", byAge)
+	}
+
+	unreferenced := names(selectPruneCandidates(containers, PruneOptions{Keep: []*Task{current, other}}, now))
+	if len(unreferenced) != 1 || !unreferenced[stale.generateContainerName()] {
+		t.Errorf("Keep should protect current pipeline containers, got %v", unreferenced)
+	}
+}
+
+func TestSelectSnapshotsAndNetworks(t *testing.T) {
+	now := time.Now()
+	current := &Task{Name: "build", BaseImage: "alpine", Commands: []string{"echo current"}}
+	stale := &Task{Name: "build", BaseImage: "alpine", Commands: []string{"echo stale"}}
+
+	snapshot := func(task *Task, age time.Duration) imagetypes.Summary {
+		return imagetypes.Summary{
+			ID:       "sha256:" + task.generateHash(),
+			RepoTags: []string{snapshotReference(task.Name, task.generateHash())},
+			Created:  now.Add(-age).Unix(),
+			Labels:   map[string]string{labelManaged: "true", labelSnapshot: "true", labelTask: task.Name, labelHash: task.generateHash()},
+		}
+	}
+	images := []imagetypes.Summary{snapshot(current, time.Hour), snapshot(stale, 48*time.Hour)}
+	if selected := selectSnapshots(images, PruneOptions{Keep: []*Task{current}}, now); len(selected) != 1 || selected[0].Ref != snapshotReference("build", stale.generateHash()) {
+		t.Errorf("Expected only the stale snapshot, got %+v", selected)
+	}
+	if selected := selectSnapshots(images, PruneOptions{OlderThan: 24 * time.Hour}, now); len(selected) != 1 {
+		t.Errorf("Expected only the old snapshot, got %+v", selected)
+	}
+
+	servicesNet := func(task *Task) network.Summary {
+		return network.Summary{
+			Name:    servicesNetwork(task.generateContainerName()),
+			Created: now,
+			Labels:  map[string]string{labelManaged: "true", labelTask: task.Name},
+		}
+	}
+	networks := []network.Summary{servicesNet(current), servicesNet(stale), {Name: "bridge"}}
+	if selected := selectNetworks(networks, PruneOptions{Keep: []*Task{current}}, now); len(selected) != 1 || selected[0].Name != servicesNetwork(stale.generateContainerName()) {
+		t.Errorf("Expected only the network of the stale task, got %+v", selected)
+	}
+}
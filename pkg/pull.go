@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	imagetypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/moby/term"
+)
+
+// PullProgress selects how the progress of image pulls is shown.
+type PullProgress string
+
+const (
+	PullProgressAuto  PullProgress = "auto"  // Progress bars per layer on a terminal, lines otherwise
+	PullProgressLines PullProgress = "lines" // A line per layer once it is pulled, and the digest of the image
+	PullProgressQuiet PullProgress = "quiet" // Only which images are pulled, for CI logs
+)
+
+// pullProgress is how pulls show their progress, set with SetPullProgress
+var pullProgress = PullProgressAuto
+
+// ParsePullProgress parses the name of a pull progress mode, empty meaning auto.
+func ParsePullProgress(s string) (PullProgress, error) {
+	switch mode := PullProgress(s); mode {
+	case "", PullProgressAuto:
+		return PullProgressAuto, nil
+	case PullProgressLines, PullProgressQuiet:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("unknown pull progress '%s', expected auto, lines or quiet", s)
+	}
+}
+
+// SetPullProgress shows the progress of all following image pulls according to mode.
+func SetPullProgress(mode PullProgress) {
+	pullProgress = mode
+}
+
+// pullFrom pulls the image ref for platform, empty for the daemon's own, and shows the pull progress on
+// stdout
+func pullFrom(ctx context.Context, cli ImageAPI, ref, platform string) error {
+	return pullWith(ctx, cli, ref, platform, pullProgress)
+}
+
+// pullWith pulls the image ref for platform and shows the pull progress on stdout according to mode
+func pullWith(ctx context.Context, cli ImageAPI, ref, platform string, mode PullProgress) error {
+	fmt.Printf("Pulling image: %s\n", ref)
+	reader, err := cli.ImagePull(ctx, ref, imagetypes.PullOptions{Platform: platform})
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	defer reader.Close()
+
+	if err := renderPull(reader, os.Stdout, mode); err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	return nil
+}
+
+// renderPull shows the JSON message stream of a pull on out. It returns the error the daemon reported
+// in the stream, if any.
+func renderPull(stream io.Reader, out io.Writer, mode PullProgress) error {
+	if mode == PullProgressAuto {
+		if fd, isTerminal := term.GetFdInfo(out); isTerminal {
+			return jsonmessage.DisplayJSONMessagesStream(stream, out, fd, true, nil)
+		}
+	}
+
+	decoder := json.NewDecoder(stream)
+	for {
+		var message jsonmessage.JSONMessage
+		if err := decoder.Decode(&message); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("error reading pull progress: %w", err)
+		}
+		if message.Error != nil {
+			return message.Error
+		}
+		if mode == PullProgressQuiet {
+			continue
+		}
+		switch {
+		case message.ID != "" && (message.Status == "Pull complete" || message.Status == "Already exists"):
+			fmt.Fprintf(out, "%s: %s\n", message.ID, message.Status)
+		case message.ID == "" && message.Status != "":
+			// Digest and summary of the pulled image
+			fmt.Fprintln(out, message.Status)
+		}
+	}
+}
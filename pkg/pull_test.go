@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const pullStream = `{"status":"Pulling from library/alpine","id":"3.20"}
+{"status":"Pulling fs layer","progressDetail":{},"id":"a1b2c3"}
+{"status":"Downloading","progressDetail":{"current":1024,"total":4096},"progress":"[===>   ]","id":"a1b2c3"}
+{"status":"Pull complete","progressDetail":{},"id":"a1b2c3"}
+{"status":"Already exists","progressDetail":{},"id":"d4e5f6"}
+{"status":"Digest: sha256:0123"}
+{"status":"Status: Downloaded newer image for alpine:3.20"}
+`
+
+func TestRenderPull(t *testing.T) {
+	var out bytes.Buffer
+	if err := renderPull(strings.NewReader(pullStream), &out, PullProgressLines); err != nil {
+		t.Fatalf("Expected the pull to be rendered, got %v", err)
+	}
+	want := "a1b2c3: Pull complete\nd4e5f6: Already exists\nDigest: sha256:0123\nStatus: Downloaded newer image for alpine:3.20\n"
+	if out.String() != want {
+		t.Errorf("Expected a line per layer and the summary, got:\n%s", out.String())
+	}
+
+	// Output that is no terminal gets lines in auto mode as well
+	out.Reset()
+	if err := renderPull(strings.NewReader(pullStream), &out, PullProgressAuto); err != nil || out.String() != want {
+		t.Errorf("Expected lines when not writing to a terminal, got %v:\n%s", err, out.String())
+	}
+
+	out.Reset()
+	if err := renderPull(strings.NewReader(pullStream), &out, PullProgressQuiet); err != nil || out.Len() != 0 {
+		t.Errorf("Expected no output in quiet mode, got %v:\n%s", err, out.String())
+	}
+
+	failing := pullStream + `{"errorDetail":{"message":"unauthorized"},"error":"unauthorized"}` + "\n"
+	if err := renderPull(strings.NewReader(failing), &out, PullProgressQuiet); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("Expected the error of the stream, got %v", err)
+	}
+
+	if _, err := ParsePullProgress("bars"); err == nil {
+		t.Error("Expected an unknown pull progress to be rejected")
+	}
+}
@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/docker/api/types"
+	imagetypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/pkg/jsonmessage"
+)
+
+// pushAttempts is how often a push is tried before giving up; registries often fail transiently
+const pushAttempts = 3
+
+// dockerHubAuthKey is the key of Docker Hub credentials in the docker CLI configuration
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// registryConfigDir is the directory of the docker CLI configuration, see SetRegistryConfig
+var registryConfigDir string
+
+// SetRegistryConfig reads registry credentials from the docker CLI configuration in dir instead of
+// $DOCKER_CONFIG or ~/.docker.
+func SetRegistryConfig(dir string) {
+	registryConfigDir = dir
+}
+
+// registryAuth returns the encoded credentials the docker CLI configuration (including credential
+// helpers) has for the registry of ref, empty if it has none
+func registryAuth(ref string) (string, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", fmt.Errorf("error parsing image %s: %w", ref, err)
+	}
+	host := reference.Domain(named)
+	if host == "docker.io" {
+		host = dockerHubAuthKey
+	}
+
+	configFile := config.LoadDefaultConfigFile(io.Discard)
+	if registryConfigDir != "" {
+		if configFile, err = config.Load(registryConfigDir); err != nil {
+			return "", fmt.Errorf("error reading docker configuration %s: %w", registryConfigDir, err)
+		}
+	}
+	auth, err := configFile.GetAuthConfig(host)
+	if err != nil {
+		return "", fmt.Errorf("error reading credentials for %s: %w", host, err)
+	}
+	if auth.Username == "" && auth.IdentityToken == "" && auth.RegistryToken == "" {
+		return "", nil
+	}
+	return registry.EncodeAuthConfig(registry.AuthConfig{
+		Username:      auth.Username,
+		Password:      auth.Password,
+		ServerAddress: auth.ServerAddress,
+		IdentityToken: auth.IdentityToken,
+		RegistryToken: auth.RegistryToken,
+	})
+}
+
+// PushImage pushes the local image ref to its registry with the credentials of the docker CLI and
+// returns the digest of the pushed manifest. Failed pushes are retried with a growing delay.
+func PushImage(ctx context.Context, cli ImageAPI, ref string) (string, error) {
+	auth, err := registryAuth(ref)
+	if err != nil {
+		return "", err
+	}
+
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		digest, err := pushOnce(ctx, cli, ref, auth)
+		if err == nil {
+			fmt.Printf("Pushed image %s@%s\n", ref, digest)
+			return digest, nil
+		}
+		if attempt == pushAttempts || ctx.Err() != nil {
+			return "", fmt.Errorf("error pushing image %s after %d attempts: %w", ref, attempt, err)
+		}
+		fmt.Printf("Failed to push image %s (attempt %d/%d), retrying in %s: %v\n", ref, attempt, pushAttempts, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// pushOnce pushes ref once and returns the digest reported by the daemon
+func pushOnce(ctx context.Context, cli ImageAPI, ref, auth string) (string, error) {
+	fmt.Printf("Pushing image: %s\n", ref)
+	reader, err := cli.ImagePush(ctx, ref, imagetypes.PushOptions{RegistryAuth: auth})
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	var digest string
+	err = jsonmessage.DisplayJSONMessagesStream(reader, os.Stdout, 0, false, func(message jsonmessage.JSONMessage) {
+		var result types.PushResult
+		if message.Aux != nil && json.Unmarshal(*message.Aux, &result) == nil && result.Digest != "" {
+			digest = result.Digest
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if digest == "" {
+		return "", fmt.Errorf("the daemon did not report the digest of the pushed image")
+	}
+	return digest, nil
+}
@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+)
+
+func TestRegistryAuth(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	config := `{"auths": {
+		"ghcr.io": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("me:secret")) + `"},
+		"https://index.docker.io/v1/": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("hub:token")) + `"}
+	}}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	decode := func(encoded string) registry.AuthConfig {
+		var auth registry.AuthConfig
+		data, err := base64.URLEncoding.DecodeString(encoded)
+		if err != nil || json.Unmarshal(data, &auth) != nil {
+			t.Fatalf("Failed to decode %q", encoded)
+		}
+		return auth
+	}
+
+	encoded, err := registryAuth("ghcr.io/me/app:sha")
+	if err != nil || decode(encoded).Username != "me" || decode(encoded).Password != "secret" {
+		t.Errorf("Unexpected credentials for ghcr.io: %q (%v)", encoded, err)
+	}
+	encoded, err = registryAuth("me/app")
+	if err != nil || decode(encoded).Username != "hub" {
+		t.Errorf("Unexpected credentials for Docker Hub: %q (%v)", encoded, err)
+	}
+	if encoded, err := registryAuth("registry.example.com/app"); err != nil || encoded != "" {
+		t.Errorf("Expected no credentials for an unknown registry, got %q (%v)", encoded, err)
+	}
+}
+
+func TestParsePipelinePushWithoutOutputImage(t *testing.T) {
+	_, err := ParsePipeline([]byte(`
+tasks:
+  - name: package
+    image: alpine
+    push: true
+`))
+	if err == nil {
+		t.Errorf("Expected an error for a push without an output image")
+	}
+}
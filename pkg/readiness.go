@@ -0,0 +1,188 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Readiness decides when a service or task container is ready. A command is run in the container until
+// it succeeds, a port is connected to and, with an HTTP path, requested from the host. Without either,
+// the image's HEALTHCHECK is waited for, and images without one are ready once started.
+type Readiness struct {
+	Command  []string      `json:"command" yaml:"command"`   // Run in the container until it succeeds, e.g. [pg_isready]
+	Port     int           `json:"port" yaml:"port"`         // Container port that accepts TCP connections once ready
+	HTTP     string        `json:"http" yaml:"http"`         // Path requested on Port, ready on a 2xx or 3xx response, e.g. /healthz
+	Interval time.Duration `json:"interval" yaml:"interval"` // Time between checks, 1s by default
+	Timeout  time.Duration `json:"timeout" yaml:"timeout"`   // Time to wait for the container, 1m by default
+}
+
+const (
+	defaultReadinessTimeout = time.Minute
+	probeTimeout            = 5 * time.Second // Time a single connection or request of a check may take
+)
+
+// validate checks that r selects one kind of check. A nil Readiness is valid.
+func (r *Readiness) validate() error {
+	if r == nil {
+		return nil
+	}
+	if len(r.Command) > 0 && r.Port != 0 {
+		return errors.New("readiness checks either a command or a port")
+	}
+	if r.Port < 0 || r.Port > 65535 {
+		return fmt.Errorf("invalid readiness port %d", r.Port)
+	}
+	if r.HTTP != "" {
+		if r.Port == 0 {
+			return errors.New("readiness over HTTP needs a port")
+		}
+		if r.HTTP[0] != '/' {
+			return fmt.Errorf("readiness HTTP path %s must start with /", r.HTTP)
+		}
+	}
+	return nil
+}
+
+// validateReadiness checks the readiness check of the task container
+func (t *Task) validateReadiness() error {
+	if err := t.Readiness.validate(); err != nil {
+		return fmt.Errorf("invalid readiness of task '%s': %w", t.Name, err)
+	}
+	return nil
+}
+
+// waitForReadiness blocks after the task container started until its readiness check succeeds, if it
+// has one, so the commands do not run before a slow entrypoint finished
+func (t *Task) waitForReadiness(ctx context.Context, cli DockerAPI) error {
+	if t.Readiness == nil {
+		return nil
+	}
+	if err := waitUntilReady(ctx, cli, t.containerID, t.Readiness); err != nil {
+		return fmt.Errorf("task '%s' %w", t.Name, err)
+	}
+	fmt.Printf("Task '%s' is ready\n", t.Name)
+	return nil
+}
+
+// waitUntilReady checks the container until it is ready, nil readiness using the defaults
+func waitUntilReady(ctx context.Context, cli DockerAPI, containerName string, r *Readiness) error {
+	readiness := Readiness{}
+	if r != nil {
+		readiness = *r
+	}
+	if readiness.Interval <= 0 {
+		readiness.Interval = time.Second
+	}
+	if readiness.Timeout <= 0 {
+		readiness.Timeout = defaultReadinessTimeout
+	}
+
+	deadline := time.Now().Add(readiness.Timeout)
+	var lastErr error
+	for {
+		ready, err := containerReady(ctx, cli, containerName, readiness)
+		if ready {
+			return nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("was not ready after %s: %w", readiness.Timeout, lastErr)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(readiness.Interval):
+		}
+	}
+}
+
+// containerReady checks a container once; the error explains why it is not ready yet
+func containerReady(ctx context.Context, cli DockerAPI, containerName string, readiness Readiness) (bool, error) {
+	info, err := cli.ContainerInspect(ctx, containerName)
+	if err != nil {
+		return false, fmt.Errorf("error inspecting container: %w", err)
+	}
+	if info.State == nil || !info.State.Running {
+		return false, errors.New("the container is not running")
+	}
+
+	switch {
+	case len(readiness.Command) > 0:
+		if _, err := runInContainer(ctx, cli, info.ID, "", readiness.Command); err != nil {
+			return false, err
+		}
+	case readiness.Port != 0:
+		address, err := containerAddress(info)
+		if err != nil {
+			return false, err
+		}
+		if err := probe(ctx, net.JoinHostPort(address, strconv.Itoa(readiness.Port)), readiness.HTTP); err != nil {
+			return false, err
+		}
+	case info.State.Health != nil && info.State.Health.Status != container.Healthy:
+		return false, fmt.Errorf("the container is %s", info.State.Health.Status)
+	}
+	return true, nil
+}
+
+// containerAddress returns the address the host reaches the container at
+func containerAddress(info container.InspectResponse) (string, error) {
+	if info.HostConfig != nil && info.HostConfig.NetworkMode.IsHost() {
+		return "127.0.0.1", nil
+	}
+	if info.NetworkSettings != nil {
+		// Sorted, so the same network is probed on every check
+		names := make([]string, 0, len(info.NetworkSettings.Networks))
+		for name := range info.NetworkSettings.Networks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if endpoint := info.NetworkSettings.Networks[name]; endpoint != nil && endpoint.IPAddress != "" {
+				return endpoint.IPAddress, nil
+			}
+		}
+	}
+	return "", errors.New("the container has no IP address")
+}
+
+// probe connects to address, and requests path over HTTP from it unless path is empty
+func probe(ctx context.Context, address, path string) error {
+	if path == "" {
+		conn, err := (&net.Dialer{Timeout: probeTimeout}).DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+path, nil)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{
+		Timeout: probeTimeout,
+		// A redirect already tells that the server is up
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s responded with %s", req.URL, resp.Status)
+	}
+	return nil
+}
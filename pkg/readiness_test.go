@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+)
+
+func TestValidateReadiness(t *testing.T) {
+	for _, readiness := range []*Readiness{nil, {Command: []string{"pg_isready"}}, {Port: 8080}, {Port: 8080, HTTP: "/healthz"}} {
+		if err := readiness.validate(); err != nil {
+			t.Errorf("Unexpected error for %+v: %v", readiness, err)
+		}
+	}
+
+	for name, readiness := range map[string]*Readiness{
+		"command and port":  {Command: []string{"pg_isready"}, Port: 5432},
+		"port":              {Port: 70000},
+		"http without port": {HTTP: "/healthz"},
+		"relative path":     {Port: 8080, HTTP: "healthz"},
+	} {
+		if err := readiness.validate(); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.Error(w, "starting", http.StatusServiceUnavailable)
+		}
+	}))
+	defer healthy.Close()
+	address := healthy.Listener.Addr().String()
+
+	ctx := context.Background()
+	if err := probe(ctx, address, ""); err != nil {
+		t.Errorf("Expected the port to accept connections, got %v", err)
+	}
+	if err := probe(ctx, address, "/healthz"); err != nil {
+		t.Errorf("Expected a healthy response, got %v", err)
+	}
+	if err := probe(ctx, address, "/ready"); err == nil {
+		t.Error("Expected an error response to be not ready")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := listener.Addr().String()
+	listener.Close()
+	if err := probe(ctx, closed, ""); err == nil {
+		t.Error("Expected a closed port to be not ready")
+	}
+}
+
+func TestContainerAddress(t *testing.T) {
+	info := container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{HostConfig: &container.HostConfig{}},
+		NetworkSettings: &container.NetworkSettings{Networks: map[string]*network.EndpointSettings{
+			"none":     {},
+			"services": {IPAddress: "172.18.0.2"},
+		}},
+	}
+	if address, err := containerAddress(info); err != nil || address != "172.18.0.2" {
+		t.Errorf("Expected the address on the services network, got %s, %v", address, err)
+	}
+
+	info.HostConfig.NetworkMode = "host"
+	if address, _ := containerAddress(info); address != "127.0.0.1" {
+		t.Errorf("Expected the host address with host networking, got %s", address)
+	}
+}
+
+func TestParsePipelineReadiness(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+tasks:
+  - name: test
+    image: app
+    readiness:
+      port: 8080
+      http: /healthz
+      timeout: 2m
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+	readiness := pipeline.Tasks[0].Readiness
+	if readiness == nil || readiness.Port != 8080 || readiness.HTTP != "/healthz" || readiness.Timeout != 2*time.Minute {
+		t.Errorf("Unexpected readiness %+v", readiness)
+	}
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// Read-only artifacts are copied without write permissions, which stops commands running as a regular
+// user. Commands running as root ignore permissions, so the artifacts are also digested before the
+// commands run and checked after every command, failing the task with the command that modified them.
+
+// readOnlyArtifact reports whether a dependency artifact has to stay unmodified in the task container
+func (t *Task) readOnlyArtifact(artifact Artifact) bool {
+	return t.ReadOnlyArtifacts || artifact.ReadOnly
+}
+
+// stripWriteBits removes the write permissions of all entries of a tar stream
+func stripWriteBits(r io.Reader) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		tr := tar.NewReader(r)
+		tw := tar.NewWriter(pw)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			header.Mode &^= 0o222
+			if err := tw.WriteHeader(header); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.CloseWithError(tw.Close())
+	}()
+	return pr
+}
+
+// digestReadOnlyArtifacts records the digests of the read-only artifacts once they were copied
+func (t *Task) digestReadOnlyArtifacts(ctx context.Context, cli ExecAPI) error {
+	t.readOnlyDigests = map[string]string{}
+	for _, c := range t.resolvedCopies() {
+		if !t.readOnlyArtifact(c.artifact) {
+			continue
+		}
+		digest, err := t.digestInContainer(ctx, cli, c.artifact.To)
+		if err != nil {
+			return err
+		}
+		t.readOnlyDigests[c.artifact.To] = digest
+	}
+	return nil
+}
+
+// checkReadOnlyArtifacts fails if a read-only artifact changed since it was copied, blaming by (the
+// command or commands that ran in between)
+func (t *Task) checkReadOnlyArtifacts(ctx context.Context, cli ExecAPI, by string) error {
+	for _, to := range slices.Sorted(maps.Keys(t.readOnlyDigests)) {
+		digest, err := t.digestInContainer(ctx, cli, to)
+		if err != nil {
+			// Most likely the artifact was removed
+			return fmt.Errorf("%s modified the read-only artifact %s of task '%s': %w", by, to, t.Name, err)
+		}
+		if digest != t.readOnlyDigests[to] {
+			return fmt.Errorf("%s modified the read-only artifact %s of task '%s'", by, to, t.Name)
+		}
+	}
+	return nil
+}
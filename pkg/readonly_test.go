@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestStripWriteBits(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "lib/", Mode: 0o755, Typeflag: tar.TypeDir})
+	tw.WriteHeader(&tar.Header{Name: "lib/data.txt", Mode: 0o664, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("data"))
+	tw.Close()
+
+	tr := tar.NewReader(stripWriteBits(&buf))
+	modes := map[string]int64{}
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to read archive: %v", err)
+		}
+		modes[header.Name] = header.Mode
+		if header.Name == "lib/data.txt" {
+			if content, _ := io.ReadAll(tr); string(content) != "data" {
+				t.Errorf("Content should be preserved, got %q", content)
+			}
+		}
+	}
+
+	if modes["lib/"] != 0o555 || modes["lib/data.txt"] != 0o444 {
+		t.Errorf("Unexpected modes %v", modes)
+	}
+}
+
+func TestParsePipelineReadOnlyArtifacts(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+tasks:
+  - name: producer
+    image: alpine
+  - name: consumer
+    image: alpine
+    dependencies:
+      - task: producer
+        artifacts:
+          - from: /out/lib
+            to: /in/lib
+            read_only: true
+          - from: /out/scratch
+            to: /in/scratch
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+
+	consumer, _ := pipeline.Task("consumer")
+	artifacts := consumer.Dependencies[0].Artifacts
+	if !consumer.readOnlyArtifact(artifacts[0]) || consumer.readOnlyArtifact(artifacts[1]) {
+		t.Errorf("Only the artifact marked read-only should be read-only")
+	}
+
+	consumer.ReadOnlyArtifacts = true
+	if !consumer.readOnlyArtifact(artifacts[1]) {
+		t.Errorf("ReadOnlyArtifacts should apply to all artifacts of the task")
+	}
+}
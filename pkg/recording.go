@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// RecordingExecutor executes nothing. It walks a task graph in the order a run would and records what
+// every task would do, so code constructing pipelines can be tested deterministically without Docker.
+// Unlike a plan, it does not consult the artifact store; every task is recorded as executing.
+type RecordingExecutor struct {
+	Tasks      []RecordedTask // Tasks in execution order, shared dependencies once
+	Conditions *ConditionEnv  // Environment the conditions of tasks are evaluated in, detected if nil
+
+	recorded map[*Task]int // Index of recorded tasks
+}
+
+// RecordedTask is what executing a task would have done.
+type RecordedTask struct {
+	Task     string        // Name of the task
+	Hash     string        // Hash of the task, empty if it is only known during execution
+	Image    string        // Base image, empty for virtual tasks and external containers
+	Virtual  bool          // The task only re-exports artifacts of its dependencies
+	Skipped  bool          // The condition of the task or of one of its dependencies does not hold
+	Commands []string      // The commands as written, also when they are batched
+	Argv     [][]string    // Argv of every command as it would be executed, nil for batches and scripts
+	Script   string        // Script the commands would run as, if any
+	Copies   []PlannedCopy // Artifacts that would be copied into the task container
+	Outputs  []string      // Outputs the task would have to produce
+}
+
+// NoopExecutor executes nothing. It only checks that a task graph could be executed, like a run does
+// before touching Docker.
+type NoopExecutor struct{}
+
+// Execute checks the graph of t.
+func (NoopExecutor) Execute(ctx context.Context, t *Task) error {
+	return (&RecordingExecutor{}).Execute(ctx, t)
+}
+
+// Execute records t and its dependencies.
+func (r *RecordingExecutor) Execute(ctx context.Context, t *Task) error {
+	if !t.isCircularDependencyFree(nil) {
+		return configErrorf("circular dependency found in task '%s'", t.Name)
+	}
+	if err := t.resolveVars(); err != nil {
+		return err
+	}
+	if err := t.resolveOutputRefs(); err != nil {
+		return err
+	}
+	if err := t.hashHostInputs(); err != nil {
+		return err
+	}
+	if r.recorded == nil {
+		r.recorded = map[*Task]int{}
+	}
+	return r.record(t)
+}
+
+// Names returns the names of the recorded tasks in execution order.
+func (r *RecordingExecutor) Names() []string {
+	names := make([]string, len(r.Tasks))
+	for i, task := range r.Tasks {
+		names[i] = task.Task
+	}
+	return names
+}
+
+// Task returns the recording of the task name.
+func (r *RecordingExecutor) Task(name string) (RecordedTask, bool) {
+	i := slices.IndexFunc(r.Tasks, func(task RecordedTask) bool { return task.Task == name })
+	if i < 0 {
+		return RecordedTask{}, false
+	}
+	return r.Tasks[i], true
+}
+
+// record records t once its dependencies are recorded
+func (r *RecordingExecutor) record(t *Task) error {
+	if _, done := r.recorded[t]; done {
+		return nil
+	}
+	for _, dependency := range t.sortedDependencies() {
+		if err := r.record(dependency.Task); err != nil {
+			return fmt.Errorf("error executing task dependency %s:  %w", dependency.Task.Name, err)
+		}
+	}
+	if r.Conditions != nil {
+		t.options.conditions = r.Conditions
+	}
+
+	recorded := RecordedTask{Task: t.Name, Virtual: t.Virtual, Outputs: t.declaredOutputs()}
+	recorded.Skipped = t.skippedByCondition()
+	if !recorded.Skipped {
+		if err := t.validate(); err != nil {
+			return err
+		}
+	}
+	hashKnown := t.Container == "" && t.Build == nil
+	for _, dependency := range t.Dependencies {
+		previous := r.Tasks[r.recorded[dependency.Task]]
+		recorded.Skipped = recorded.Skipped || previous.Skipped
+		hashKnown = hashKnown && previous.Hash != ""
+	}
+	for _, input := range t.HashInputs {
+		if _, _, ok := t.artifactInputSource(input); ok {
+			// The digests of dependency artifacts are only known once they are produced
+			hashKnown = false
+		}
+	}
+	if hashKnown {
+		recorded.Hash = t.generateHash()
+	}
+
+	if !t.Virtual && !recorded.Skipped {
+		recorded.Image = t.BaseImage
+		for _, c := range t.resolvedCopies() {
+			recorded.Copies = append(recorded.Copies, plannedCopy(c))
+		}
+		recorded.Commands = slices.Clone(t.commandLines())
+		switch {
+		case t.Script != "":
+			recorded.Script = t.scriptSource()
+		case t.BatchCommands:
+		default:
+			for idx := range recorded.Commands {
+				recorded.Argv = append(recorded.Argv, t.commandArgv(idx))
+			}
+		}
+	}
+
+	r.recorded[t] = len(r.Tasks)
+	r.Tasks = append(r.Tasks, recorded)
+	return nil
+}
@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestRecordingExecutor(t *testing.T) {
+	generate := &Task{Name: "generate", BaseImage: "alpine", Commands: []string{"make data"}, Outputs: []string{"/out/data"}}
+	lint := &Task{Name: "lint", BaseImage: "golang", Cmd: [][]string{{"go", "vet", "./..."}}}
+	consume := &Task{
+		Name:      "consume",
+		BaseImage: "alpine",
+		Script:    "cat /in/data",
+		Dependencies: []Dependency{
+			{Task: lint},
+			{Task: generate, Artifacts: []Artifact{{From: "/out/data", To: "/in/data"}}},
+		},
+	}
+	publish := &Task{
+		Name:         "publish",
+		BaseImage:    "alpine",
+		Commands:     []string{"upload"},
+		When:         func(env ConditionEnv) bool { return env.Branch == "main" },
+		Dependencies: []Dependency{{Task: consume}},
+	}
+	all := &Task{Name: "all", Virtual: true, Dependencies: []Dependency{{Task: publish}, {Task: generate}}}
+
+	recorder := &RecordingExecutor{Conditions: &ConditionEnv{Branch: "feature"}}
+	if err := recorder.Execute(context.Background(), all); err != nil {
+		t.Fatalf("Failed to record: %v", err)
+	}
+	if names := recorder.Names(); !reflect.DeepEqual(names, []string{"generate", "lint", "consume", "publish", "all"}) {
+		t.Fatalf("Unexpected execution order %v", names)
+	}
+
+	recorded, _ := recorder.Task("generate")
+	if recorded.Hash != generate.generateHash() || !reflect.DeepEqual(recorded.Argv, [][]string{{"sh", "-c", "make data"}}) {
+		t.Errorf("Unexpected recording of generate %+v", recorded)
+	}
+	if recorded, _ := recorder.Task("lint"); !reflect.DeepEqual(recorded.Argv, [][]string{{"go", "vet", "./..."}}) {
+		t.Errorf("Unexpected recording of lint %+v", recorded)
+	}
+	recorded, _ = recorder.Task("consume")
+	if recorded.Script == "" || recorded.Argv != nil || len(recorded.Copies) != 1 || recorded.Copies[0].Task != "generate" {
+		t.Errorf("Unexpected recording of consume %+v", recorded)
+	}
+	if recorded, _ := recorder.Task("publish"); !recorded.Skipped || recorded.Commands != nil {
+		t.Errorf("Publish should be skipped off main: %+v", recorded)
+	}
+	if recorded, _ := recorder.Task("all"); !recorded.Virtual || recorded.Hash == "" {
+		t.Errorf("Unexpected recording of all %+v", recorded)
+	}
+
+	// Invalid configurations fail like a run would
+	invalid := &Task{Name: "invalid", BaseImage: "alpine", Commands: []string{"a"}, Cmd: [][]string{{"b"}}}
+	if err := (NoopExecutor{}).Execute(context.Background(), invalid); err == nil {
+		t.Errorf("Expected commands and cmd to be rejected")
+	}
+	cyclic := &Task{Name: "cyclic", BaseImage: "alpine"}
+	cyclic.Dependencies = []Dependency{{Task: cyclic}}
+	var configErr *ConfigError
+	if err := (NoopExecutor{}).Execute(context.Background(), cyclic); !errors.As(err, &configErr) {
+		t.Errorf("Expected a configuration error for a cycle, got %v", err)
+	}
+}
@@ -0,0 +1,220 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// ErrRemoteNotFound is returned by remote backends for keys that do not exist.
+var ErrRemoteNotFound = errors.New("not found in remote cache")
+
+// RemoteBackend is a key/value blob storage shared between machines, e.g. an S3 bucket or an HTTP cache.
+// Keys mirror the local artifact store layout ("tasks/<hash>.json", "blobs/sha256/<digest>").
+type RemoteBackend interface {
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+}
+
+// RemoteMode controls whether an artifact store uploads to its remote backend.
+type RemoteMode int
+
+const (
+	RemoteReadOnly  RemoteMode = iota // Only download cache hits, e.g. for untrusted pull request builds
+	RemoteReadWrite                   // Download cache hits and upload the outputs of executed tasks
+)
+
+// ParseRemoteMode parses "ro"/"read-only" or "rw"/"read-write".
+func ParseRemoteMode(mode string) (RemoteMode, error) {
+	switch mode {
+	case "ro", "read-only":
+		return RemoteReadOnly, nil
+	case "rw", "read-write":
+		return RemoteReadWrite, nil
+	}
+	return 0, fmt.Errorf("unknown remote cache mode '%s', expected ro or rw", mode)
+}
+
+// NewRemoteBackend creates a backend from a URL:
+//
+//	http(s)://host/prefix  plain GET/PUT against an HTTP cache server
+//	s3://bucket/prefix     S3 or S3-compatible storage, configured through the AWS_* environment variables
+//	gs://bucket/prefix     Google Cloud Storage through its S3-compatible API, using HMAC keys
+func NewRemoteBackend(rawURL string) (RemoteBackend, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote cache URL: %w", err)
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return newHTTPBackend(u), nil
+	case "s3":
+		endpoint := os.Getenv("AWS_ENDPOINT_URL")
+		region := os.Getenv("AWS_REGION")
+		if region == "" {
+			region = "us-east-1"
+		}
+		if endpoint == "" {
+			endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+		}
+		return newS3Backend(endpoint, region, u.Host, u.Path,
+			os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN"))
+	case "gs":
+		return newS3Backend("https://storage.googleapis.com", "auto", u.Host, u.Path,
+			os.Getenv("GCS_HMAC_ACCESS_KEY"), os.Getenv("GCS_HMAC_SECRET"), "")
+	}
+	return nil, fmt.Errorf("unsupported remote cache scheme '%s'", u.Scheme)
+}
+
+// SetRemote attaches a remote backend to the store. Local misses are looked up remotely, and in
+// read-write mode saved outputs are uploaded.
+func (s *ArtifactStore) SetRemote(backend RemoteBackend, mode RemoteMode) {
+	s.remote = backend
+	s.remoteMode = mode
+}
+
+func manifestKey(hash string) string {
+	return path.Join("tasks", hash+".json")
+}
+
+func blobKey(digest string) string {
+	return path.Join("blobs", "sha256", digest)
+}
+
+// remoteManifest downloads the manifest of a task hash, without the blobs it references
+func (s *ArtifactStore) remoteManifest(ctx context.Context, hash string) (*StoreManifest, bool, error) {
+	manifestReader, err := s.remote.Get(ctx, manifestKey(hash))
+	if errors.Is(err, ErrRemoteNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("error fetching manifest from remote cache: %w", err)
+	}
+	data, err := io.ReadAll(manifestReader)
+	manifestReader.Close()
+	if err != nil {
+		return nil, false, fmt.Errorf("error fetching manifest from remote cache: %w", err)
+	}
+
+	manifest, err := parseStoreManifest(data)
+	if err != nil {
+		return nil, false, err
+	}
+	return manifest, true, nil
+}
+
+// fetchRemote downloads all blobs a remote manifest references into the local store and saves the
+// manifest there, telling status once it did
+func (s *ArtifactStore) fetchRemote(ctx context.Context, manifest *StoreManifest, status io.Writer) error {
+	for _, artifact := range manifest.Artifacts {
+		if _, err := os.Stat(s.blobPath(artifact.Digest)); err == nil {
+			continue
+		}
+		algorithm := Compression(artifact.Compression)
+		blob, err := s.remote.Get(ctx, blobKey(artifact.Digest)+compressionSuffix(algorithm))
+		if err != nil {
+			return fmt.Errorf("error fetching artifact %s from remote cache: %w", artifact.Path, err)
+		}
+		decompressed, err := decompressStream(blob, algorithm)
+		if err != nil {
+			blob.Close()
+			return fmt.Errorf("error decompressing artifact %s from remote cache: %w", artifact.Path, err)
+		}
+		digest, _, err := s.putBlob(decompressed)
+		decompressed.Close()
+		blob.Close()
+		if err != nil {
+			return err
+		}
+		if digest != artifact.Digest {
+			return fmt.Errorf("artifact %s from remote cache is corrupted: expected digest %s, got %s", artifact.Path, artifact.Digest, digest)
+		}
+	}
+
+	// Blobs of the local store are never compressed
+	for i := range manifest.Artifacts {
+		manifest.Artifacts[i].Compression = ""
+	}
+	fmt.Fprintf(status, "Fetched outputs of task '%s' from remote cache\n", manifest.Task)
+	return s.writeManifest(manifest)
+}
+
+// pushRemote uploads a locally saved manifest and its blobs, telling status once it did. The manifest
+// goes last, so other machines never see a manifest whose blobs are missing.
+func (s *ArtifactStore) pushRemote(ctx context.Context, manifest *StoreManifest, status io.Writer) error {
+	remote := *manifest
+	remote.Artifacts = slices.Clone(manifest.Artifacts)
+	for i, artifact := range remote.Artifacts {
+		algorithm := compressionFor(artifact.Size)
+		blob, size, err := s.openRemoteBlob(artifact, algorithm)
+		if err != nil {
+			return err
+		}
+		err = s.remote.Put(ctx, blobKey(artifact.Digest)+compressionSuffix(algorithm), blob, size)
+		blob.Close()
+		if err != nil {
+			return fmt.Errorf("error uploading artifact %s to remote cache: %w", artifact.Path, err)
+		}
+		if algorithm != CompressionNone {
+			remote.Artifacts[i].Compression = string(algorithm)
+		}
+	}
+
+	data, err := json.MarshalIndent(&remote, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding store manifest: %w", err)
+	}
+	if err := s.remote.Put(ctx, manifestKey(manifest.Hash), strings.NewReader(string(data)), int64(len(data))); err != nil {
+		return fmt.Errorf("error uploading manifest to remote cache: %w", err)
+	}
+
+	fmt.Fprintf(status, "Uploaded outputs of task '%s' to remote cache\n", manifest.Task)
+	return nil
+}
+
+// openRemoteBlob opens the blob of artifact for an upload compressed with algorithm and returns its
+// size. Compressed blobs are written to a temporary file first, remote backends need the size up front.
+func (s *ArtifactStore) openRemoteBlob(artifact StoredArtifact, algorithm Compression) (io.ReadCloser, int64, error) {
+	blob, err := os.Open(s.blobPath(artifact.Digest))
+	if err != nil {
+		return nil, 0, fmt.Errorf("error opening stored artifact %s: %w", artifact.Path, err)
+	}
+	if algorithm == CompressionNone {
+		return blob, artifact.Size, nil
+	}
+	defer blob.Close()
+
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, "blobs"), "compressed-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("error compressing artifact %s: %w", artifact.Path, err)
+	}
+	// The file stays readable through the open descriptor
+	os.Remove(tmp.Name())
+	w, err := compressWriter(tmp, algorithm)
+	if err == nil {
+		if _, err = io.Copy(w, blob); err == nil {
+			err = w.Close()
+		}
+	}
+	var size int64
+	if err == nil {
+		size, err = tmp.Seek(0, io.SeekCurrent)
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		return nil, 0, fmt.Errorf("error compressing artifact %s: %w", artifact.Path, err)
+	}
+	return tmp, size, nil
+}
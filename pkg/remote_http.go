@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// httpBackend stores cache entries with plain GET/PUT requests, as supported by common HTTP build caches
+// (e.g. bazel-remote, nginx with WebDAV). Credentials come from the URL or BUILDVAULT_REMOTE_CACHE_TOKEN.
+type httpBackend struct {
+	baseURL *url.URL
+	token   string
+	client  *http.Client
+}
+
+var _ RemoteBackend = (*httpBackend)(nil)
+
+func newHTTPBackend(baseURL *url.URL) *httpBackend {
+	return &httpBackend{
+		baseURL: baseURL,
+		token:   os.Getenv("BUILDVAULT_REMOTE_CACHE_TOKEN"),
+		client:  http.DefaultClient,
+	}
+}
+
+func (b *httpBackend) newRequest(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	u := *b.baseURL
+	u.Path = path.Join("/", strings.TrimSuffix(u.Path, "/"), key)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if b.token != "" {
+		req.Header.Set("Authorization", "Bearer "+b.token)
+	}
+	return req, nil
+}
+
+func (b *httpBackend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := doStallGuarded(ctx, b.client, nil, func(ctx context.Context, body io.Reader) (*http.Request, error) {
+		return b.newRequest(ctx, http.MethodGet, key, body)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrRemoteNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: unexpected status %s", key, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+func (b *httpBackend) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	resp, err := doStallGuarded(ctx, b.client, r, func(ctx context.Context, body io.Reader) (*http.Request, error) {
+		req, err := b.newRequest(ctx, http.MethodPut, key, body)
+		if err == nil {
+			req.ContentLength = size
+		}
+		return req, err
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("PUT %s: unexpected status %s", key, resp.Status)
+	}
+	return nil
+}
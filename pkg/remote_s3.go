@@ -0,0 +1,176 @@
+package pkg
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Backend stores cache entries in an S3-compatible bucket using path-style requests signed with
+// AWS Signature Version 4. Google Cloud Storage is supported through its interoperability API.
+type s3Backend struct {
+	endpoint     *url.URL
+	region       string
+	bucket       string
+	prefix       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+var _ RemoteBackend = (*s3Backend)(nil)
+
+func newS3Backend(endpoint, region, bucket, prefix, accessKey, secretKey, sessionToken string) (*s3Backend, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid S3 endpoint: %w", err)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("remote cache URL is missing the bucket name")
+	}
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("missing credentials for bucket %s", bucket)
+	}
+
+	return &s3Backend{
+		endpoint:     u,
+		region:       region,
+		bucket:       bucket,
+		prefix:       strings.Trim(prefix, "/"),
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: sessionToken,
+		client:       http.DefaultClient,
+	}, nil
+}
+
+func (b *s3Backend) newRequest(ctx context.Context, method, key string, body io.Reader) (*http.Request, error) {
+	u := *b.endpoint
+	u.Path = path.Join("/", b.bucket, b.prefix, key)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if b.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.sessionToken)
+	}
+	// Streamed uploads can't be hashed up front, which S3 allows over TLS
+	signV4(req, "UNSIGNED-PAYLOAD", b.accessKey, b.secretKey, b.region, time.Now())
+	return req, nil
+}
+
+func (b *s3Backend) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := doStallGuarded(ctx, b.client, nil, func(ctx context.Context, body io.Reader) (*http.Request, error) {
+		return b.newRequest(ctx, http.MethodGet, key, body)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrRemoteNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: unexpected status %s: %s", key, resp.Status, message)
+	}
+	return resp.Body, nil
+}
+
+func (b *s3Backend) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	resp, err := doStallGuarded(ctx, b.client, r, func(ctx context.Context, body io.Reader) (*http.Request, error) {
+		req, err := b.newRequest(ctx, http.MethodPut, key, body)
+		if err == nil {
+			req.ContentLength = size
+		}
+		return req, err
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("PUT %s: unexpected status %s: %s", key, resp.Status, message)
+	}
+	return nil
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header covering the host and all headers already set
+func signV4(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsURIEncode(req.URL.Path),
+		req.URL.Query().Encode(),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode percent-encodes a path the way SigV4 expects, leaving only unreserved characters and slashes
+func awsURIEncode(p string) string {
+	var encoded strings.Builder
+	for _, b := range []byte(p) {
+		if ('A' <= b && b <= 'Z') || ('a' <= b && b <= 'z') || ('0' <= b && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || b == '/' {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return encoded.String()
+}
@@ -0,0 +1,252 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Statuses of tasks in a run report
+const (
+	StatusExecuted = "executed"        // Commands ran and succeeded
+	StatusCached   = "cached"          // Outputs were found in the artifact store
+	StatusFailed   = "failed"          // The task started its own work and failed
+	StatusNotRun   = "not run"         // A dependency failed or the run was interrupted first
+	StatusVirtual  = "virtual"         // Only re-exports artifacts
+	StatusExternal = "external"        // Existing container not managed by buildvault
+	StatusSkipped  = "skipped"         // Its condition or the condition of a dependency did not hold
+	StatusAllowed  = "allowed failure" // The task failed, but is allowed to
+)
+
+// noStage groups the tasks of a report that have no stage
+const noStage = "(no stage)"
+
+// maxTaskLog bounds the command output of a task kept for the report
+const maxTaskLog = 1 << 20
+
+// Report summarizes a run: every task with its status and duration, grouped by stage.
+type Report struct {
+	Started  time.Time     `json:"started"`
+	Duration time.Duration `json:"duration_ns"`
+	Stages   []StageReport `json:"stages"`
+}
+
+// StageReport rolls up the tasks of one stage.
+type StageReport struct {
+	Name      string        `json:"name"`
+	Duration  time.Duration `json:"duration_ns"` // Sum of the durations of its tasks
+	Executed  int           `json:"executed"`
+	CacheHits int           `json:"cache_hits"`
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+	Allowed   int           `json:"allowed_failures"`
+	Tasks     []TaskReport  `json:"tasks"`
+}
+
+// TaskReport is a task of a run report.
+type TaskReport struct {
+	Name         string        `json:"name"`
+	Hash         string        `json:"hash,omitempty"`
+	Status       string        `json:"status"`
+	Duration     time.Duration `json:"duration_ns"` // Time of its own work, without its dependencies
+	Dependencies []string      `json:"dependencies,omitempty"`
+	Level        int           `json:"level"` // Depth in the dependency graph, 0 for tasks without dependencies
+	Log          string        `json:"log,omitempty"`
+	Image        string        `json:"image,omitempty"` // Output image with its digest, if it was pushed
+}
+
+// taskLog keeps the command output of a task for the run report, up to maxTaskLog bytes
+type taskLog struct {
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (l *taskLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if room := maxTaskLog - l.buf.Len(); len(p) > room {
+		l.buf.Write(p[:room])
+		l.truncated = true
+	} else {
+		l.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+func (l *taskLog) String() string {
+	if l == nil {
+		return ""
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.truncated {
+		return l.buf.String() + "\n[output truncated]\n"
+	}
+	return l.buf.String()
+}
+
+// status returns the report status of t after a run
+func (t *Task) status() string {
+	switch {
+	case t.Container != "":
+		return StatusExternal
+	case t.skipped:
+		return StatusSkipped
+	case t.allowedFailure:
+		return StatusAllowed
+	case t.Virtual:
+		return StatusVirtual
+	case t.cacheHit:
+		return StatusCached
+	case t.completed:
+		return StatusExecuted
+	case !t.started.IsZero():
+		return StatusFailed
+	default:
+		return StatusNotRun
+	}
+}
+
+// BuildReport reports on tasks, given in execution order, after a run that started at started. Stages
+// appear in the order of stages, followed by stages only used by tasks and tasks without a stage.
+func BuildReport(tasks []*Task, stages []string, started, finished time.Time) *Report {
+	report := &Report{Started: started.UTC(), Duration: finished.Sub(started)}
+	index := map[string]int{}
+	addStage := func(name string) int {
+		if i, ok := index[name]; ok {
+			return i
+		}
+		index[name] = len(report.Stages)
+		report.Stages = append(report.Stages, StageReport{Name: name})
+		return index[name]
+	}
+	for _, stage := range stages {
+		addStage(stage)
+	}
+
+	// Dependencies come before their dependents in execution order
+	levels := dependencyDepths(tasks)
+
+	var unstaged []*Task
+	for _, task := range tasks {
+		if task.Stage == "" {
+			unstaged = append(unstaged, task)
+			continue
+		}
+		report.Stages[addStage(task.Stage)].add(task, levels[task])
+	}
+	if len(unstaged) > 0 {
+		stage := &report.Stages[addStage(noStage)]
+		for _, task := range unstaged {
+			stage.add(task, levels[task])
+		}
+	}
+	return report
+}
+
+func (s *StageReport) add(t *Task, level int) {
+	task := TaskReport{Name: t.Name, Status: t.status(), Duration: t.duration, Level: level, Log: t.commandLog.String(), Image: t.pushedImage}
+	if t.completed {
+		task.Hash = t.generateHash()
+	}
+	for _, dependency := range t.Dependencies {
+		task.Dependencies = append(task.Dependencies, dependency.Task.Name)
+	}
+	s.Tasks = append(s.Tasks, task)
+	s.Duration += task.Duration
+	switch task.Status {
+	case StatusExecuted:
+		s.Executed++
+	case StatusCached:
+		s.CacheHits++
+	case StatusFailed:
+		s.Failed++
+	case StatusSkipped:
+		s.Skipped++
+	case StatusAllowed:
+		s.Allowed++
+	}
+}
+
+// WriteJSON writes r as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+	return nil
+}
+
+// Graph returns the tasks of r by their level in the dependency graph, tasks without dependencies first
+func (r *Report) Graph() [][]TaskReport {
+	var graph [][]TaskReport
+	for _, stage := range r.Stages {
+		for _, task := range stage.Tasks {
+			for len(graph) <= task.Level {
+				graph = append(graph, nil)
+			}
+			graph[task.Level] = append(graph[task.Level], task)
+		}
+	}
+	return graph
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"round": func(d time.Duration) time.Duration { return d.Round(time.Millisecond) },
+	"class": func(status string) string { return strings.ReplaceAll(status, " ", "-") },
+	"join":  strings.Join,
+	"lines": func(log string) int { return strings.Count(strings.TrimRight(log, "\n"), "\n") + 1 },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>buildvault run {{.Started.Format "2006-01-02 15:04:05"}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: left; vertical-align: top; }
+pre { background: #f6f6f6; padding: 0.5em; max-height: 40em; overflow: auto; }
+.graph { display: flex; gap: 2em; margin-bottom: 2em; }
+.graph div { display: flex; flex-direction: column; gap: 0.5em; }
+.node { border: 1px solid #ccc; border-radius: 4px; padding: 0.3em 0.8em; text-decoration: none; }
+.executed { color: #060; } .cached { color: #06c; } .failed { color: #c00; font-weight: bold; } .not-run, .skipped { color: #888; } .allowed-failure { color: #c60; }
+</style>
+</head>
+<body>
+<h1>buildvault run</h1>
+<p>Started {{.Started.Format "2006-01-02 15:04:05 MST"}}, took {{round .Duration}}</p>
+<h2>Graph</h2>
+<div class="graph">
+{{range .Graph}}<div>
+{{range .}}<a class="node {{class .Status}}" href="#task-{{.Name}}" title="{{if .Dependencies}}depends on {{join .Dependencies ", "}}{{else}}no dependencies{{end}}">{{.Name}}</a>
+{{end}}</div>
+{{end}}</div>
+{{range .Stages}}
+<h2>{{.Name}}</h2>
+<p>{{round .Duration}} in {{len .Tasks}} tasks: {{.Executed}} executed, {{.CacheHits}} cached, {{.Failed}} failed{{if .Allowed}}, {{.Allowed}} allowed to fail{{end}}{{if .Skipped}}, {{.Skipped}} skipped{{end}}</p>
+<table>
+<tr><th>Task</th><th>Status</th><th>Duration</th><th>Hash</th><th>Dependencies</th></tr>
+{{range .Tasks}}<tr id="task-{{.Name}}"><td>{{.Name}}</td><td class="{{class .Status}}">{{.Status}}</td><td>{{round .Duration}}</td><td>{{.Hash}}{{if .Image}}<br>{{.Image}}{{end}}</td><td>{{range $i, $d := .Dependencies}}{{if $i}}, {{end}}<a href="#task-{{$d}}">{{$d}}</a>{{end}}</td></tr>
+{{if .Log}}<tr><td colspan="5"><details{{if or (eq .Status "failed") (eq .Status "allowed failure")}} open{{end}}><summary>Output ({{lines .Log}} lines)</summary><pre>{{.Log}}</pre></details></td></tr>
+{{end}}{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes r as a standalone HTML page.
+func (r *Report) WriteHTML(w io.Writer) error {
+	if err := reportTemplate.Execute(w, r); err != nil {
+		return fmt.Errorf("error writing report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildReport(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	deps := &Task{Name: "deps", BaseImage: "alpine", Stage: "setup", completed: true, cacheHit: true, started: started}
+	build := &Task{Name: "build", BaseImage: "alpine", Stage: "build", completed: true, started: started, duration: 2 * time.Second}
+	test := &Task{Name: "test", BaseImage: "alpine", Stage: "test", started: started, duration: time.Second}
+	pack := &Task{Name: "package", BaseImage: "alpine", Stage: "package"}
+	lint := &Task{Name: "lint", BaseImage: "alpine", completed: true, started: started, duration: time.Second}
+
+	report := BuildReport([]*Task{deps, build, lint, test, pack}, []string{"setup", "build", "test", "package"}, started, started.Add(5*time.Second))
+
+	var names []string
+	for _, stage := range report.Stages {
+		names = append(names, stage.Name)
+	}
+	if strings.Join(names, ",") != "setup,build,test,package,"+noStage {
+		t.Fatalf("Unexpected stage order %v", names)
+	}
+	if setup := report.Stages[0]; setup.CacheHits != 1 || setup.Tasks[0].Status != StatusCached {
+		t.Errorf("Unexpected setup stage %+v", setup)
+	}
+	if build := report.Stages[1]; build.Executed != 1 || build.Duration != 2*time.Second || build.Tasks[0].Hash == "" {
+		t.Errorf("Unexpected build stage %+v", build)
+	}
+	if test := report.Stages[2]; test.Failed != 1 || test.Tasks[0].Status != StatusFailed {
+		t.Errorf("Unexpected test stage %+v", test)
+	}
+	if pack := report.Stages[3]; pack.Tasks[0].Status != StatusNotRun {
+		t.Errorf("Unexpected package stage %+v", pack)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("Failed to write JSON: %v", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || len(decoded.Stages) != 5 {
+		t.Errorf("Unexpected JSON report %s: %v", buf.String(), err)
+	}
+
+	buf.Reset()
+	if err := report.WriteHTML(&buf); err != nil {
+		t.Fatalf("Failed to write HTML: %v", err)
+	}
+	if !strings.Contains(buf.String(), "<h2>package</h2>") || !strings.Contains(buf.String(), `class="not-run"`) {
+		t.Errorf("Unexpected HTML report:\n%s", buf.String())
+	}
+}
+
+func TestParsePipelineStages(t *testing.T) {
+	_, err := ParsePipeline([]byte(`
+stages: [build, test]
+tasks:
+  - name: deploy
+    image: alpine
+    stage: deploy
+`))
+	if err == nil {
+		t.Errorf("Expected an error for a stage that is not declared")
+	}
+}
+
+func TestHTMLReportGraphAndLogs(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	deps := &Task{Name: "deps", BaseImage: "alpine", completed: true, started: started}
+	build := &Task{Name: "build", BaseImage: "alpine", Dependencies: []Dependency{{Task: deps}}, started: started}
+	build.commandLog = &taskLog{}
+	build.commandLog.Write([]byte("compiling\n<error>\n"))
+
+	report := BuildReport([]*Task{deps, build}, nil, started, started.Add(time.Second))
+	graph := report.Graph()
+	if len(graph) != 2 || graph[0][0].Name != "deps" || graph[1][0].Name != "build" {
+		t.Fatalf("Unexpected graph %+v", graph)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteHTML(&buf); err != nil {
+		t.Fatalf("Failed to write HTML: %v", err)
+	}
+	html := buf.String()
+	for _, want := range []string{`<details open><summary>Output (2 lines)</summary>`, "&lt;error&gt;", `<a href="#task-deps">deps</a>`} {
+		if !strings.Contains(html, want) {
+			t.Errorf("Expected %s in HTML report:\n%s", want, html)
+		}
+	}
+}
+
+func TestTaskLogTruncates(t *testing.T) {
+	log := &taskLog{}
+	log.Write(bytes.Repeat([]byte("x"), maxTaskLog+10))
+	if !strings.HasSuffix(log.String(), "[output truncated]\n") || len(log.String()) > maxTaskLog+100 {
+		t.Errorf("Expected the log to be truncated")
+	}
+}
+
+func TestReportAllowedFailuresAndSkippedTasks(t *testing.T) {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	e2e := &Task{Name: "e2e", BaseImage: "alpine", AllowFailure: true, allowedFailure: true, started: started, duration: time.Second}
+	publish := &Task{Name: "publish", BaseImage: "alpine", skipped: true}
+
+	report := BuildReport([]*Task{e2e, publish}, nil, started, started.Add(time.Second))
+	stage := report.Stages[0]
+	if stage.Allowed != 1 || stage.Skipped != 1 || stage.Failed != 0 {
+		t.Errorf("Unexpected stage %+v", stage)
+	}
+	if stage.Tasks[0].Status != StatusAllowed || stage.Tasks[1].Status != StatusSkipped {
+		t.Errorf("Unexpected statuses %s and %s", stage.Tasks[0].Status, stage.Tasks[1].Status)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteHTML(&buf); err != nil {
+		t.Fatalf("Failed to write HTML: %v", err)
+	}
+	if !strings.Contains(buf.String(), "1 allowed to fail, 1 skipped") || !strings.Contains(buf.String(), `class="allowed-failure"`) {
+		t.Errorf("Expected allowed failures and skipped tasks in the HTML report")
+	}
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+// Tasks with a read-only root filesystem can only write to their writable paths, mounts and cache
+// directories, so a pipeline verifies that its tasks write nowhere but where they claim to. Writable
+// paths are anonymous volumes rather than tmpfs mounts: they start with the content of the image, and
+// the daemon can copy artifacts into and outputs out of them. Like mounts, they are not part of the task
+// hash: a task either writes only where it declares and produces the same outputs, or it fails.
+
+// internalDir holds the files buildvault itself puts into task containers, like the helper binary
+const internalDir = "/.buildvault"
+
+// validateReadOnlyRootfs checks that t declares every path it writes to as writable
+func (t *Task) validateReadOnlyRootfs() error {
+	if !t.ReadOnlyRootfs {
+		if len(t.WritablePaths) > 0 {
+			return fmt.Errorf("task '%s' has writable paths but no read-only root filesystem", t.Name)
+		}
+		return nil
+	}
+	for _, writable := range t.WritablePaths {
+		if !path.IsAbs(writable) {
+			return fmt.Errorf("writable path %s of task '%s' must be an absolute path", writable, t.Name)
+		}
+	}
+
+	writable := t.writableRoots()
+	for _, output := range t.declaredOutputs() {
+		if !isUnderAnyPath(output, writable) {
+			return fmt.Errorf("output %s of task '%s' is not under one of its writable paths", output, t.Name)
+		}
+	}
+	for _, dependency := range t.Dependencies {
+		for _, artifact := range dependency.Artifacts {
+			if !isUnderAnyPath(artifact.To, writable) {
+				return fmt.Errorf("artifact %s of task '%s' is copied outside of its writable paths", artifact.To, t.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// writableRoots returns the paths t can write to despite its read-only root filesystem
+func (t *Task) writableRoots() []string {
+	roots := slices.Concat(t.WritablePaths, t.CacheDirs)
+	for _, m := range t.Mounts {
+		if !m.ReadOnly {
+			roots = append(roots, m.Target)
+		}
+	}
+	return roots
+}
+
+// writableMounts returns the anonymous volumes of the writable paths of t and of the files buildvault
+// puts into its container, none if its root filesystem is writable
+func (t *Task) writableMounts() []mount.Mount {
+	if !t.ReadOnlyRootfs {
+		return nil
+	}
+	var mounts []mount.Mount
+	for _, target := range append([]string{internalDir}, t.WritablePaths...) {
+		mounts = append(mounts, mount.Mount{Type: mount.TypeVolume, Target: target})
+	}
+	return mounts
+}
@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/mount"
+)
+
+func TestValidateReadOnlyRootfs(t *testing.T) {
+	task := &Task{
+		Name:           "build",
+		ReadOnlyRootfs: true,
+		WritablePaths:  []string{"/src"},
+		CacheDirs:      []string{"/root/.cache"},
+		Mounts:         []Mount{{Type: MountTmpfs, Target: "/tmp"}},
+		Outputs:        []string{"/src/bin/app", "/root/.cache/report.txt", "/tmp/log.txt"},
+	}
+	if err := task.validateReadOnlyRootfs(); err != nil {
+		t.Fatalf("Expected outputs under writable paths, caches and mounts to be accepted, got %v", err)
+	}
+
+	task.Outputs = append(task.Outputs, "/out/app")
+	if err := task.validateReadOnlyRootfs(); err == nil {
+		t.Error("Expected an output outside of the writable paths to be rejected")
+	}
+	task.Outputs = nil
+
+	task.Dependencies = []Dependency{{Task: &Task{Name: "deps"}, Artifacts: []Artifact{{From: "/deps", To: "/opt/deps"}}}}
+	if err := task.validateReadOnlyRootfs(); err == nil {
+		t.Error("Expected an artifact copied outside of the writable paths to be rejected")
+	}
+
+	if err := (&Task{Name: "build", ReadOnlyRootfs: true, WritablePaths: []string{"src"}}).validateReadOnlyRootfs(); err == nil {
+		t.Error("Expected a relative writable path to be rejected")
+	}
+	if err := (&Task{Name: "build", WritablePaths: []string{"/src"}}).validateReadOnlyRootfs(); err == nil {
+		t.Error("Expected writable paths without a read-only root filesystem to be rejected")
+	}
+}
+
+func TestReadOnlyRootfsHostConfig(t *testing.T) {
+	task := &Task{Name: "build", ReadOnlyRootfs: true, WritablePaths: []string{"/src"}}
+	hostConfig := task.hostConfig()
+	if !hostConfig.ReadonlyRootfs {
+		t.Error("Expected a read-only root filesystem")
+	}
+
+	targets := map[string]mount.Type{}
+	for _, m := range hostConfig.Mounts {
+		targets[m.Target] = m.Type
+	}
+	for _, target := range []string{internalDir, "/src"} {
+		if targets[target] != mount.TypeVolume {
+			t.Errorf("Expected a volume at %s, got mounts %v", target, hostConfig.Mounts)
+		}
+	}
+
+	if mounts := (&Task{Name: "build"}).writableMounts(); len(mounts) != 0 {
+		t.Errorf("Expected no writable mounts with a writable root filesystem, got %v", mounts)
+	}
+}
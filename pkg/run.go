@@ -0,0 +1,139 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// A pipeline can also be assembled in Go: tasks are added with AddTask, the tasks to build are picked with
+// Target, and Run executes them. Dependencies shared by several targets execute once per run, before the
+// first target needing them.
+
+// AddTask adds task to p. Its dependencies have to be added first, so the tasks of a pipeline never
+// form a cycle.
+func (p *Pipeline) AddTask(task *Task) error {
+	if task.Name == "" {
+		return fmt.Errorf("task without a name")
+	}
+	if _, ok := p.Task(task.Name); ok {
+		return fmt.Errorf("duplicate task '%s'", task.Name)
+	}
+	for _, dependency := range task.Dependencies {
+		if existing, ok := p.Task(dependency.Task.Name); !ok || existing != dependency.Task {
+			return fmt.Errorf("task '%s' depends on '%s', which is not in the pipeline", task.Name, dependency.Task.Name)
+		}
+	}
+	p.Tasks = append(p.Tasks, task)
+	return nil
+}
+
+// Target adds the task with the given name to the tasks executed by Run and returns it.
+func (p *Pipeline) Target(name string) (*Task, error) {
+	task, ok := p.Task(name)
+	if !ok {
+		return nil, configErrorf("unknown task '%s'", name)
+	}
+	for _, target := range p.targets {
+		if target == task {
+			return task, nil
+		}
+	}
+	p.targets = append(p.targets, task)
+	return task, nil
+}
+
+// Targets returns the tasks executed by Run: those picked with Target, or all root tasks if none were.
+func (p *Pipeline) Targets() []*Task {
+	if len(p.targets) == 0 {
+		return p.Roots()
+	}
+	return append([]*Task{}, p.targets...)
+}
+
+// AllowedFailures returns the tasks of p that failed during the last run, which they are allowed to.
+func (p *Pipeline) AllowedFailures() []*Task {
+	var failed []*Task
+	for _, task := range p.Tasks {
+		if task.allowedFailure {
+			failed = append(failed, task)
+		}
+	}
+	return failed
+}
+
+// Run executes the targets of p and their dependencies wave by wave, see Levels: the tasks of a wave
+// execute concurrently, once all tasks of the earlier waves completed. A task several targets depend
+// on, directly or not, executes once. The options apply to every task, and a timeout bounds the whole
+// run. A failing task fails the run once the other tasks of its wave finished.
+func (p *Pipeline) Run(ctx context.Context, cli DockerAPI, opts ...ExecuteOption) error {
+	if _, err := p.TopoSort(); err != nil {
+		return err
+	}
+
+	options := newExecuteOptions(opts)
+	ctx, cancel := options.withTimeout(ctx)
+	defer cancel()
+	if options.cleanup == nil {
+		options.cleanup = &containerCleanup{}
+		defer options.cleanup.run(context.WithoutCancel(ctx), cli)
+	}
+	if options.gc {
+		if _, err := p.CollectGarbage(ctx, cli); err != nil {
+			return err
+		}
+	}
+	tasks, err := topoSort(p.Targets())
+	if err != nil {
+		return err
+	}
+	PrePullImages(ctx, cli, tasks)
+	for _, task := range tasks {
+		if task.When != nil && options.conditions == nil {
+			// Detected once up front, the tasks of a wave would detect it concurrently
+			env := DetectConditionEnv()
+			options.conditions = &env
+		}
+	}
+
+	levels, err := p.Levels()
+	if err != nil {
+		return err
+	}
+	for i, level := range levels {
+		var wave []*Task
+		for _, task := range level {
+			if !options.isDone(task) {
+				wave = append(wave, task)
+			}
+		}
+		if len(wave) == 0 {
+			continue
+		}
+		fmt.Printf("Executing wave %d of %d: %s\n", i+1, len(levels), strings.Join(taskNames(wave), ", "))
+		if err := executeWave(ctx, cli, wave, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executeWave executes the tasks of a wave concurrently and returns the errors of those that failed
+func executeWave(ctx context.Context, cli DockerAPI, wave []*Task, options executeOptions) error {
+	if len(wave) == 1 {
+		return wave[0].Execute(ctx, cli, inheritOptions(options))
+	}
+	errs := make([]error, len(wave))
+	var wg sync.WaitGroup
+	for i, task := range wave {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = task.Execute(ctx, cli, inheritOptions(options))
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
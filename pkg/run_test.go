@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+)
+
+func TestPipelineAddTask(t *testing.T) {
+	pipeline := &Pipeline{}
+	shared := &Task{Name: "shared", BaseImage: "alpine"}
+	app := &Task{Name: "app", BaseImage: "alpine", Dependencies: []Dependency{{Task: shared}}}
+
+	if err := pipeline.AddTask(app); err == nil {
+		t.Errorf("Expected an error for a dependency outside the pipeline")
+	}
+	if err := pipeline.AddTask(shared); err != nil {
+		t.Fatalf("Failed to add task: %v", err)
+	}
+	if err := pipeline.AddTask(app); err != nil {
+		t.Fatalf("Failed to add task: %v", err)
+	}
+	if err := pipeline.AddTask(&Task{Name: "app", BaseImage: "alpine"}); err == nil {
+		t.Errorf("Expected an error for a duplicate task")
+	}
+	if err := pipeline.AddTask(&Task{Name: "other", Dependencies: []Dependency{{Task: &Task{Name: "shared"}}}}); err == nil {
+		t.Errorf("Expected an error for a dependency that only shares the name of a pipeline task")
+	}
+}
+
+func TestPipelineTargets(t *testing.T) {
+	pipeline := &Pipeline{}
+	shared := &Task{Name: "shared"}
+	app := &Task{Name: "app", Dependencies: []Dependency{{Task: shared}}}
+	docs := &Task{Name: "docs", Dependencies: []Dependency{{Task: shared}}}
+	for _, task := range []*Task{shared, app, docs} {
+		if err := pipeline.AddTask(task); err != nil {
+			t.Fatalf("Failed to add task: %v", err)
+		}
+	}
+
+	if want := []*Task{app, docs}; !slices.Equal(pipeline.Targets(), want) {
+		t.Errorf("Expected the root tasks without targets, got %v", pipeline.Targets())
+	}
+	if _, err := pipeline.Target("missing"); err == nil {
+		t.Errorf("Expected an error for an unknown target")
+	}
+	for _, name := range []string{"docs", "shared", "docs"} {
+		if _, err := pipeline.Target(name); err != nil {
+			t.Fatalf("Failed to add target: %v", err)
+		}
+	}
+	if want := []*Task{docs, shared}; !slices.Equal(pipeline.Targets(), want) {
+		t.Errorf("Expected targets %v, got %v", want, pipeline.Targets())
+	}
+}
+
+func TestCompletedDependenciesAreNotExecutedAgain(t *testing.T) {
+	shared := &Task{Name: "shared"}
+	task := &Task{Name: "app", Dependencies: []Dependency{{Task: shared}}}
+	task.options = newExecuteOptions([]ExecuteOption{inheritOptions(executeOptions{done: map[*Task]bool{shared: true}})})
+
+	// Executing shared again would need a Docker client
+	if err := task.executeDependencies(context.Background(), nil); err != nil {
+		t.Errorf("Expected the completed dependency to be skipped, got %v", err)
+	}
+}
+
+func TestPipelineRunExecutesWavesConcurrently(t *testing.T) {
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:latest"}, Files: map[string]string{"/bin/sh": ""}})
+	// Both tasks of the second wave have to run at once to get past the barrier
+	var barrier sync.WaitGroup
+	barrier.Add(2)
+	var mu sync.Mutex
+	var order []string
+	cli.Exec = func(e *dockertest.Exec) int {
+		if command, ok := strings.CutPrefix(e.Cmd[len(e.Cmd)-1], "run "); ok {
+			if command != "shared" {
+				barrier.Done()
+				barrier.Wait()
+			}
+			mu.Lock()
+			order = append(order, command)
+			mu.Unlock()
+		}
+		return dockertest.Builtins(e)
+	}
+
+	pipeline := &Pipeline{}
+	shared := &Task{Name: "shared", BaseImage: "alpine", Commands: []string{"run shared"}}
+	app := &Task{Name: "app", BaseImage: "alpine", Commands: []string{"run app"}, Dependencies: []Dependency{{Task: shared}}}
+	docs := &Task{Name: "docs", BaseImage: "alpine", Commands: []string{"run docs"}, Dependencies: []Dependency{{Task: shared}}}
+	for _, task := range []*Task{shared, app, docs} {
+		if err := pipeline.AddTask(task); err != nil {
+			t.Fatalf("Failed to add task: %v", err)
+		}
+	}
+
+	done := make(chan error)
+	go func() { done <- pipeline.Run(context.Background(), cli, WithStdout(io.Discard)) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Expected the tasks of a wave to execute concurrently")
+	}
+	if len(order) != 3 || order[0] != "shared" {
+		t.Errorf("Expected the shared dependency to execute once before its dependents, got %v", order)
+	}
+}
@@ -0,0 +1,128 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// runStateFile is written to the state directory of the workspace when a run is interrupted
+const runStateFile = "run-state.json"
+
+// RunState records an interrupted run: the tasks it would have executed, in execution order, and which
+// of them completed before it was cancelled. The next run of the same targets reports how much of
+// that work is still satisfied.
+type RunState struct {
+	Targets     []string       `json:"targets"`     // Names of the tasks the run was started for
+	Steps       []RunStateStep `json:"steps"`       // All tasks of the run in execution order
+	Interrupted time.Time      `json:"interrupted"` // When the run was cancelled
+}
+
+// RunStateStep is a task of an interrupted run.
+type RunStateStep struct {
+	Task      string `json:"task"`
+	Hash      string `json:"hash,omitempty"` // Hash the task completed with, empty if it did not complete
+	Completed bool   `json:"completed"`
+}
+
+// RunStatePath returns where the state of interrupted runs of the pipeline file at pipelinePath is kept.
+func RunStatePath(pipelinePath string) string {
+	return filepath.Join(filepath.Dir(pipelinePath), stateDir, runStateFile)
+}
+
+// RecordRunState captures which of tasks, given in execution order, completed during the current run.
+func RecordRunState(targets, tasks []*Task, interrupted time.Time) *RunState {
+	state := &RunState{Interrupted: interrupted.UTC()}
+	for _, target := range targets {
+		state.Targets = append(state.Targets, target.Name)
+	}
+	for _, task := range tasks {
+		step := RunStateStep{Task: task.Name, Completed: task.completed}
+		if task.completed {
+			step.Hash = task.generateHash()
+		}
+		state.Steps = append(state.Steps, step)
+	}
+	return state
+}
+
+// Completed returns the number of tasks of s that completed.
+func (s *RunState) Completed() int {
+	completed := 0
+	for _, step := range s.Steps {
+		if step.Completed {
+			completed++
+		}
+	}
+	return completed
+}
+
+// Matches reports whether s was recorded for the same targets.
+func (s *RunState) Matches(targets []*Task) bool {
+	var names []string
+	for _, target := range targets {
+		names = append(names, target.Name)
+	}
+	return slices.Equal(s.Targets, names)
+}
+
+// Satisfied counts the steps of the current plan that need not execute again, and how many tasks that
+// completed in the interrupted run execute again anyway, because their outputs are not in an artifact store.
+func (s *RunState) Satisfied(steps []PlanStep) (satisfied, rerun int) {
+	completed := map[string]bool{}
+	for _, step := range s.Steps {
+		completed[step.Task] = step.Completed
+	}
+	for _, step := range steps {
+		switch {
+		case step.CacheHit || step.Virtual || step.External || step.Skipped:
+			satisfied++
+		case completed[step.Task]:
+			rerun++
+		}
+	}
+	return satisfied, rerun
+}
+
+// Save writes s to path.
+func (s *RunState) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding run state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("error creating run state directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("error writing run state: %w", err)
+	}
+	return nil
+}
+
+// LoadRunState reads the state of an interrupted run, returning nil if there is none.
+func LoadRunState(path string) (*RunState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading run state: %w", err)
+	}
+	var state RunState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("error parsing run state %s: %w", path, err)
+	}
+	return &state, nil
+}
+
+// RemoveRunState deletes the state of an interrupted run once a run completed.
+func RemoveRunState(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error removing run state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRunStateRoundTrip(t *testing.T) {
+	build := &Task{Name: "build", BaseImage: "alpine", completed: true}
+	test := &Task{Name: "test", BaseImage: "alpine", Dependencies: []Dependency{{Task: build}}}
+	interrupted := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	state := RecordRunState([]*Task{test}, []*Task{build, test}, interrupted)
+	if state.Completed() != 1 || state.Steps[0].Hash != build.generateHash() || state.Steps[1].Hash != "" {
+		t.Fatalf("Unexpected state %+v", state)
+	}
+
+	path := RunStatePath(filepath.Join(t.TempDir(), "buildvault.yaml"))
+	if err := state.Save(path); err != nil {
+		t.Fatalf("Failed to save run state: %v", err)
+	}
+	loaded, err := LoadRunState(path)
+	if err != nil {
+		t.Fatalf("Failed to load run state: %v", err)
+	}
+	if !loaded.Matches([]*Task{test}) || loaded.Matches([]*Task{build}) || !loaded.Interrupted.Equal(interrupted) {
+		t.Errorf("Unexpected loaded state %+v", loaded)
+	}
+
+	if err := RemoveRunState(path); err != nil {
+		t.Fatalf("Failed to remove run state: %v", err)
+	}
+	if loaded, err := LoadRunState(path); err != nil || loaded != nil {
+		t.Errorf("Expected no run state after removing it, got %+v, %v", loaded, err)
+	}
+	if err := RemoveRunState(path); err != nil {
+		t.Errorf("Removing a missing run state should succeed: %v", err)
+	}
+}
+
+func TestRunStateSatisfied(t *testing.T) {
+	state := &RunState{Steps: []RunStateStep{
+		{Task: "deps", Completed: true},
+		{Task: "build", Completed: true},
+		{Task: "test"},
+	}}
+	steps := []PlanStep{
+		{Task: "deps", CacheHit: true},
+		{Task: "build", Reason: "not in the artifact store"},
+		{Task: "test", CacheHit: true},
+	}
+	// build completed but its outputs were not stored
+	if satisfied, rerun := state.Satisfied(steps); satisfied != 2 || rerun != 1 {
+		t.Errorf("Expected 2 satisfied tasks and 1 to run again, got %d and %d", satisfied, rerun)
+	}
+}
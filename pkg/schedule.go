@@ -0,0 +1,301 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Schedules start runs of the server on cron expressions, like nightly builds or cache warms. When a
+// schedule fires while its previous run is still running, its overlap policy decides what happens. The
+// time every schedule last fired is kept in a state file, so a schedule missed while the server was down
+// fires once when it starts again.
+
+// OverlapPolicy decides what a schedule does when it fires while its previous run is still running.
+type OverlapPolicy string
+
+const (
+	OverlapSkip           OverlapPolicy = "skip"            // Do not start a run (default)
+	OverlapQueue          OverlapPolicy = "queue"           // Start the run once the previous one finished, at most one waits
+	OverlapCancelPrevious OverlapPolicy = "cancel-previous" // Cancel the previous run and start once it stopped
+)
+
+// ParseOverlapPolicy parses the name of an overlap policy, empty meaning skip.
+func ParseOverlapPolicy(s string) (OverlapPolicy, error) {
+	switch policy := OverlapPolicy(s); policy {
+	case "", OverlapSkip:
+		return OverlapSkip, nil
+	case OverlapQueue, OverlapCancelPrevious:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown overlap policy '%s', expected skip, queue or cancel-previous", s)
+	}
+}
+
+// Schedule starts runs of a pipeline on a cron expression.
+type Schedule struct {
+	Name     string            `yaml:"name"`
+	Cron     string            `yaml:"cron"` // Standard cron expression like "0 3 * * *", or a descriptor like @daily
+	Pipeline string            `yaml:"pipeline"`
+	Targets  []string          `yaml:"targets"` // Tasks to execute, all root tasks if empty
+	Vars     map[string]string `yaml:"vars"`    // Overrides of pipeline variables
+	Force    bool              `yaml:"force"`
+	Overlap  OverlapPolicy     `yaml:"overlap"`
+	Group    string            `yaml:"group"` // Concurrency group of the runs
+	Priority int               `yaml:"priority"`
+}
+
+// ScheduleState is what the scheduler keeps of a schedule across restarts.
+type ScheduleState struct {
+	LastFired time.Time `json:"last_fired"`
+}
+
+// LoadSchedules reads the schedules of a YAML file with a list of them under schedules.
+func LoadSchedules(filename string) ([]Schedule, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, &ConfigError{Err: fmt.Errorf("error reading schedules file: %w", err)}
+	}
+	var file struct {
+		Schedules []Schedule `yaml:"schedules"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, configErrorf("error parsing schedules file %s: %w", filename, err)
+	}
+	names := map[string]bool{}
+	for i := range file.Schedules {
+		schedule := &file.Schedules[i]
+		if err := schedule.validate(); err != nil {
+			return nil, configErrorf("schedule %d of %s: %w", i+1, filename, err)
+		}
+		if names[schedule.Name] {
+			return nil, configErrorf("schedule '%s' of %s is defined twice", schedule.Name, filename)
+		}
+		names[schedule.Name] = true
+	}
+	return file.Schedules, nil
+}
+
+// validate checks the fields of a schedule and defaults its overlap policy
+func (s *Schedule) validate() error {
+	if s.Name == "" {
+		return errors.New("schedule without a name")
+	}
+	if s.Pipeline == "" {
+		return fmt.Errorf("schedule '%s' without a pipeline", s.Name)
+	}
+	if _, err := cron.ParseStandard(s.Cron); err != nil {
+		return fmt.Errorf("invalid cron expression '%s' of schedule '%s': %w", s.Cron, s.Name, err)
+	}
+	policy, err := ParseOverlapPolicy(string(s.Overlap))
+	if err != nil {
+		return fmt.Errorf("schedule '%s': %w", s.Name, err)
+	}
+	s.Overlap = policy
+	return nil
+}
+
+// Scheduler starts the runs of schedules on a server.
+type Scheduler struct {
+	server    *Server
+	statePath string // Where the state of the schedules is kept, empty to forget it on restarts
+
+	mu        sync.Mutex
+	schedules []*scheduled
+	wg        sync.WaitGroup // Runs waiting for their previous run
+}
+
+// scheduled is the state of a schedule
+type scheduled struct {
+	Schedule
+	spec      cron.Schedule
+	next      time.Time
+	lastFired time.Time
+	running   uint64 // ID of the run started last, 0 if none was
+	waiting   bool   // A run waits for the running one to stop
+}
+
+// NewScheduler creates a scheduler of schedules on server, keeping their state in statePath. Schedules
+// that were due while no scheduler ran fire on the first Tick.
+func NewScheduler(server *Server, schedules []Schedule, statePath string) (*Scheduler, error) {
+	state := map[string]ScheduleState{}
+	if statePath != "" {
+		data, err := os.ReadFile(statePath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("error reading schedule state: %w", err)
+		default:
+			if err := json.Unmarshal(data, &state); err != nil {
+				return nil, fmt.Errorf("error parsing schedule state %s: %w", statePath, err)
+			}
+		}
+	}
+
+	scheduler := &Scheduler{server: server, statePath: statePath}
+	now := time.Now()
+	for _, schedule := range schedules {
+		if err := schedule.validate(); err != nil {
+			return nil, &ConfigError{Err: err}
+		}
+		// validate parsed the expression already
+		spec, _ := cron.ParseStandard(schedule.Cron)
+		s := &scheduled{Schedule: schedule, spec: spec, lastFired: state[schedule.Name].LastFired}
+		if s.lastFired.IsZero() {
+			s.next = spec.Next(now)
+		} else {
+			s.next = spec.Next(s.lastFired)
+		}
+		scheduler.schedules = append(scheduler.schedules, s)
+	}
+	return scheduler, nil
+}
+
+// Run fires the schedules on time until ctx is done.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		s.mu.Lock()
+		var next time.Time
+		for _, schedule := range s.schedules {
+			if next.IsZero() || schedule.next.Before(next) {
+				next = schedule.next
+			}
+		}
+		s.mu.Unlock()
+		if next.IsZero() {
+			<-ctx.Done()
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			s.Tick(now)
+		}
+	}
+}
+
+// Tick fires the schedules due at now and saves their state.
+func (s *Scheduler) Tick(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fired := false
+	for _, schedule := range s.schedules {
+		if schedule.next.After(now) {
+			continue
+		}
+		schedule.lastFired = now
+		schedule.next = schedule.spec.Next(now)
+		s.fire(schedule)
+		fired = true
+	}
+	if fired {
+		if err := s.saveState(); err != nil {
+			fmt.Printf("Failed to save the state of the schedules: %v\n", err)
+		}
+	}
+}
+
+// fire starts a run of schedule according to its overlap policy. The caller must hold the lock.
+func (s *Scheduler) fire(schedule *scheduled) {
+	previous, _ := s.server.Run(schedule.running)
+	if previous.Status != RunRunning && previous.Status != RunQueued {
+		s.start(schedule)
+		return
+	}
+
+	switch schedule.Overlap {
+	case OverlapQueue, OverlapCancelPrevious:
+		if schedule.Overlap == OverlapCancelPrevious {
+			fmt.Printf("Schedule '%s' cancels run %d of pipeline '%s'\n", schedule.Name, previous.ID, schedule.Pipeline)
+			s.server.CancelRun(previous.ID)
+		}
+		if schedule.waiting {
+			fmt.Printf("Schedule '%s' already waits for run %d of pipeline '%s'\n", schedule.Name, previous.ID, schedule.Pipeline)
+			return
+		}
+		schedule.waiting = true
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			// The run ends by itself, or when the server closes
+			s.server.WatchRun(context.Background(), previous.ID, func(Event) error { return nil })
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			schedule.waiting = false
+			if s.server.ctx.Err() == nil {
+				s.start(schedule)
+			}
+		}()
+	default:
+		fmt.Printf("Schedule '%s' skipped, run %d of pipeline '%s' is still running\n", schedule.Name, previous.ID, schedule.Pipeline)
+	}
+}
+
+// start starts a run of schedule. The caller must hold the lock.
+func (s *Scheduler) start(schedule *scheduled) {
+	run, err := s.server.StartRun(schedule.Pipeline, RunRequest{
+		Targets:  schedule.Targets,
+		Vars:     schedule.Vars,
+		Force:    schedule.Force,
+		Group:    schedule.Group,
+		Priority: schedule.Priority,
+	})
+	if err != nil {
+		fmt.Printf("Schedule '%s' failed to start pipeline '%s': %v\n", schedule.Name, schedule.Pipeline, err)
+		return
+	}
+	schedule.running = run.ID
+	fmt.Printf("Schedule '%s' started run %d of pipeline '%s'\n", schedule.Name, run.ID, schedule.Pipeline)
+}
+
+// Next returns when the schedule name fires next.
+func (s *Scheduler) Next(name string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, schedule := range s.schedules {
+		if schedule.Name == name {
+			return schedule.next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// saveState writes when the schedules fired last to the state file. The caller must hold the lock.
+func (s *Scheduler) saveState() error {
+	if s.statePath == "" {
+		return nil
+	}
+	state := map[string]ScheduleState{}
+	for _, schedule := range s.schedules {
+		if !schedule.lastFired.IsZero() {
+			state[schedule.Name] = ScheduleState{LastFired: schedule.lastFired}
+		}
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding schedule state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.statePath), 0o755); err != nil {
+		return fmt.Errorf("error creating schedule state directory: %w", err)
+	}
+	if err := os.WriteFile(s.statePath, data, 0o644); err != nil {
+		return fmt.Errorf("error writing schedule state: %w", err)
+	}
+	return nil
+}
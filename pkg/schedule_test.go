@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+)
+
+func TestLoadSchedules(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	os.WriteFile(valid, []byte("schedules:\n  - name: nightly\n    cron: \"0 3 * * *\"\n    pipeline: app\n  - name: warm\n    cron: \"@hourly\"\n    pipeline: app\n    overlap: queue\n"), 0o644)
+	schedules, err := LoadSchedules(valid)
+	if err != nil || len(schedules) != 2 || schedules[0].Overlap != OverlapSkip || schedules[1].Overlap != OverlapQueue {
+		t.Fatalf("Unexpected schedules %+v: %v", schedules, err)
+	}
+
+	for name, content := range map[string]string{
+		"no name":     "schedules:\n  - cron: '@daily'\n    pipeline: app\n",
+		"no pipeline": "schedules:\n  - name: a\n    cron: '@daily'\n",
+		"cron":        "schedules:\n  - name: a\n    cron: '61 * * * *'\n    pipeline: app\n",
+		"overlap":     "schedules:\n  - name: a\n    cron: '@daily'\n    pipeline: app\n    overlap: later\n",
+		"duplicate":   "schedules:\n  - name: a\n    cron: '@daily'\n    pipeline: app\n  - name: a\n    cron: '@hourly'\n    pipeline: app\n",
+	} {
+		file := filepath.Join(dir, "invalid.yaml")
+		os.WriteFile(file, []byte(content), 0o644)
+		var configErr *ConfigError
+		if _, err := LoadSchedules(file); !errors.As(err, &configErr) {
+			t.Errorf("%s: expected a configuration error, got %v", name, err)
+		}
+	}
+}
+
+func TestScheduleExpressions(t *testing.T) {
+	scheduler, err := NewScheduler(nil, []Schedule{
+		{Name: "hourly", Cron: "@hourly", Pipeline: "app"},
+		{Name: "weekdays", Cron: "30 3 * * 1-5", Pipeline: "app"},
+	}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Friday afternoon
+	from := time.Date(2026, 10, 16, 14, 20, 0, 0, time.UTC)
+	for i, want := range []time.Time{
+		time.Date(2026, 10, 16, 15, 0, 0, 0, time.UTC),
+		time.Date(2026, 10, 19, 3, 30, 0, 0, time.UTC),
+	} {
+		if next := scheduler.schedules[i].spec.Next(from); !next.Equal(want) {
+			t.Errorf("Expected schedule '%s' to fire next at %s, got %s", scheduler.schedules[i].Name, want, next)
+		}
+	}
+
+	// Standard expressions have five fields, without seconds
+	if _, err := NewScheduler(nil, []Schedule{{Name: "seconds", Cron: "0 30 3 * * *", Pipeline: "app"}}, ""); err == nil {
+		t.Error("Expected an expression with seconds to be rejected")
+	}
+}
+
+// blockingServer returns a server with the pipeline app, whose runs block until they receive from
+// release or it is closed
+func blockingServer(t *testing.T) (*Server, chan struct{}) {
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:latest"}, Files: map[string]string{"/bin/sh": ""}})
+	release := make(chan struct{})
+	cli.Exec = func(e *dockertest.Exec) int {
+		if strings.HasPrefix(e.Cmd[len(e.Cmd)-1], "block") {
+			<-release
+		}
+		return dockertest.Builtins(e)
+	}
+	server := NewServer(cli, nil)
+	t.Cleanup(server.Close)
+	definition := "tasks:\n  - name: build\n    image: alpine\n    commands: [\"block\"]\n"
+	if err := server.SubmitPipeline("app", []byte(definition), "buildvault.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	return server, release
+}
+
+// waitForRun waits until the run with the given ID finished
+func waitForRun(t *testing.T, server *Server, id uint64) ServerRun {
+	run, err := server.WatchRun(context.Background(), id, func(Event) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return run
+}
+
+func TestSchedulerOverlap(t *testing.T) {
+	for _, policy := range []OverlapPolicy{OverlapSkip, OverlapQueue, OverlapCancelPrevious} {
+		t.Run(string(policy), func(t *testing.T) {
+			server, release := blockingServer(t)
+			scheduler, err := NewScheduler(server, []Schedule{{Name: "hourly", Cron: "@hourly", Pipeline: "app", Overlap: policy}}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, _ := scheduler.Next("hourly")
+			scheduler.Tick(next)
+			scheduler.Tick(next.Add(time.Hour))
+			scheduler.Tick(next.Add(2 * time.Hour))
+			if runs := server.Runs(); len(runs) != 1 {
+				t.Fatalf("Expected only the first run to start while it runs, got %d", len(runs))
+			}
+			close(release)
+			first := waitForRun(t, server, 1)
+			scheduler.wg.Wait()
+
+			runs := server.Runs()
+			switch policy {
+			case OverlapSkip:
+				if len(runs) != 1 || first.Status != RunSucceeded {
+					t.Errorf("Expected the later runs to be skipped, got %+v", runs)
+				}
+			case OverlapQueue:
+				if len(runs) != 2 || first.Status != RunSucceeded {
+					t.Errorf("Expected one run to be queued, got %+v", runs)
+				}
+			case OverlapCancelPrevious:
+				if len(runs) != 2 || first.Status != RunInterrupted {
+					t.Errorf("Expected the first run to be cancelled for the next, got %+v", runs)
+				}
+			}
+			if len(runs) == 2 {
+				if second := waitForRun(t, server, 2); second.Status != RunSucceeded {
+					t.Errorf("Unexpected status of the queued run %s", second.Status)
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerState(t *testing.T) {
+	server, release := blockingServer(t)
+	close(release)
+	state := filepath.Join(t.TempDir(), "schedules.json")
+	schedules := []Schedule{{Name: "daily", Cron: "@daily", Pipeline: "app"}}
+
+	scheduler, err := NewScheduler(server, schedules, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, _ := scheduler.Next("daily"); !next.After(time.Now()) {
+		t.Errorf("Expected a new schedule to fire in the future, not at %v", next)
+	}
+	fired := time.Now().Add(-48 * time.Hour)
+	scheduler.schedules[0].next = fired
+	scheduler.Tick(fired)
+	waitForRun(t, server, 1)
+
+	// A restarted scheduler catches up on the day it missed, once
+	scheduler, err = NewScheduler(server, schedules, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next, _ := scheduler.Next("daily"); next.After(time.Now()) {
+		t.Errorf("Expected the missed run to be due, next is %v", next)
+	}
+	scheduler.Tick(time.Now())
+	if runs := server.Runs(); len(runs) != 2 {
+		t.Fatalf("Expected the missed run to start, got %d runs", len(runs))
+	}
+	if next, _ := scheduler.Next("daily"); !next.After(time.Now()) {
+		t.Errorf("Expected the next run in the future, not at %v", next)
+	}
+}
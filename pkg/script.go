@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// A task script is the alternative to Commands for tasks that are really one shell script: it runs in a
+// single exec, so variables, functions and cd carry over between lines. It stops at the first failing
+// line, unset variable or failing pipeline.
+
+// scriptPrologue makes scripts fail early. Not every sh supports pipefail (e.g. older dash), so it is
+// only enabled where it is available.
+const scriptPrologue = `set -eu
+if (set -o pipefail) 2>/dev/null; then set -o pipefail; fi
+`
+
+// validateScript checks that a task with a script has no commands besides it
+func (t *Task) validateScript() error {
+	if t.Script == "" {
+		return nil
+	}
+	if len(t.Commands) > 0 || len(t.Cmd) > 0 {
+		return fmt.Errorf("task '%s' can either have commands or a script", t.Name)
+	}
+	if t.BatchCommands {
+		return fmt.Errorf("task '%s' has a script, which always runs in a single exec and cannot be batched", t.Name)
+	}
+	return nil
+}
+
+// scriptSource returns the script of t as uploaded to the task container
+func (t *Task) scriptSource() string {
+	return "#!/bin/sh\n" + scriptPrologue + t.withPrelude(t.Script) + "\n"
+}
+
+// scriptRunner returns the command running the script at path: the shell program of the task if it
+// sets one, sh otherwise
+func (t *Task) scriptRunner(path string) []string {
+	if len(t.Shell) > 0 {
+		return []string{t.Shell[0], path}
+	}
+	return t.scriptCommand(path)
+}
+
+// executeScript uploads the script of t and runs it in a single exec
+func (t *Task) executeScript(ctx context.Context, cli DockerAPI, stdout, stderr io.Writer) error {
+	if err := t.uploadBatchScript(ctx, cli, t.scriptSource()); err != nil {
+		return err
+	}
+
+	release, err := acquireExec(ctx, cli)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	fmt.Fprintf(stdout, "Executing script (%d lines)\n", strings.Count(strings.TrimRight(t.Script, "\n"), "\n")+1)
+	started := time.Now()
+	stdin := t.Stdin[0]
+	execResp, err := cli.ContainerExecCreate(ctx, t.containerID, container.ExecOptions{
+		Cmd:          t.scriptRunner(batchScriptPath),
+		Env:          t.execEnv(),
+		User:         t.containerUser(),
+		WorkingDir:   t.workDir(),
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating exec for script: %w", err)
+	}
+
+	attachResp, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return fmt.Errorf("error attaching to exec for script: %w", err)
+	}
+	defer attachResp.Close()
+
+	var stdinDone <-chan error
+	if stdin != nil {
+		stdinDone = pipeStdin(attachResp, stdin)
+	}
+	captured := t.captureStdout(&stdout)
+	if _, err := stdcopy.StdCopy(stdout, stderr, attachResp.Reader); err != nil {
+		return fmt.Errorf("error StdCopy: %w", err)
+	}
+	if err := waitForStdin(attachResp, stdinDone); err != nil {
+		return fmt.Errorf("error reading stdin of script: %w", err)
+	}
+	fmt.Fprintln(stdout)
+
+	inspectResp, err := cli.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return fmt.Errorf("error inspecting exec for script: %w", err)
+	}
+	t.recordCommand("script", inspectResp.ExitCode, time.Since(started), captured)
+	if inspectResp.ExitCode != 0 {
+		return &CommandError{Task: t.Name, ExitCode: inspectResp.ExitCode}
+	}
+	return nil
+}
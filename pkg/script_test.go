@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestScriptSource(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh is not available")
+	}
+
+	task := &Task{Name: "script", Script: "cd /tmp\ngreeting=hello\necho \"$greeting from $(pwd)\"\nfalse\necho never"}
+	out, err := exec.Command("sh", "-c", task.scriptSource()).Output()
+	if err == nil {
+		t.Errorf("Expected the script to stop at the failing line")
+	}
+	if string(out) != "hello from /tmp\n" {
+		t.Errorf("Variables and cd should carry over between lines, got %q", out)
+	}
+
+	unset := &Task{Name: "script", Script: "echo \"$UNSET_VARIABLE\""}
+	if err := exec.Command("sh", "-c", unset.scriptSource()).Run(); err == nil {
+		t.Errorf("Expected unset variables to fail the script")
+	}
+}
+
+func TestValidateScript(t *testing.T) {
+	for name, task := range map[string]*Task{
+		"commands": {Name: "test", Script: "make", Commands: []string{"make"}},
+		"cmd":      {Name: "test", Script: "make", Cmd: [][]string{{"make"}}},
+		"batch":    {Name: "test", Script: "make", BatchCommands: true},
+	} {
+		if err := task.validateScript(); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+
+	task := &Task{Name: "test", Script: "make", Shell: []string{"bash", "-c"}}
+	if err := task.validateScript(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if runner := task.scriptRunner(batchScriptPath); strings.Join(runner, " ") != "bash "+batchScriptPath {
+		t.Errorf("Expected the script to run with bash, got %v", runner)
+	}
+}
@@ -0,0 +1,294 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// secretsDir is the tmpfs file secrets are provided in; it is never part of the container's filesystem layer
+const secretsDir = "/run/secrets"
+
+// secretMask replaces secret values in command output
+const secretMask = "***"
+
+// Secret is a value provided to the commands of a task that never becomes part of its hash or output.
+// The value is given directly or read from the host when the task's inputs are resolved.
+type Secret struct {
+	Name  string // Environment variable name, or file name in /run/secrets when Mount is set
+	Value string // Secret value, read from Env or File if empty
+	Env   string // Host environment variable holding the value
+	File  string // Host file holding the value
+	Mount bool   // Provide the value as the file /run/secrets/<Name> instead of an environment variable
+}
+
+// resolveSecrets reads the values of secrets that are not given directly from the host
+func (t *Task) resolveSecrets() error {
+	for i := range t.Secrets {
+		secret := &t.Secrets[i]
+		if secret.Value != "" {
+			continue
+		}
+		switch {
+		case secret.File != "":
+			data, err := os.ReadFile(secret.File)
+			if err != nil {
+				return fmt.Errorf("error reading secret '%s' of task '%s': %w", secret.Name, t.Name, err)
+			}
+			secret.Value = string(data)
+		case secret.Env != "":
+			value, ok := os.LookupEnv(secret.Env)
+			if !ok {
+				return fmt.Errorf("secret '%s' of task '%s' needs environment variable %s", secret.Name, t.Name, secret.Env)
+			}
+			secret.Value = value
+		default:
+			return fmt.Errorf("secret '%s' of task '%s' has no value", secret.Name, t.Name)
+		}
+	}
+	return nil
+}
+
+// secretKeySize is the size of the random keys secret digests are computed with
+const secretKeySize = 32
+
+// secretKey is the key of secret digests, loaded from its file when first needed
+var secretKey struct {
+	sync.Mutex
+	path string
+	key  []byte
+}
+
+// SetSecretKeyFile digests secret values for task hashes with the key kept in the file at path, which
+// is created with a random key if it does not exist. Without a key file, or if it cannot be read, a
+// random key is used for the process only, so tasks with secrets miss their stored outputs of other runs.
+func SetSecretKeyFile(path string) {
+	secretKey.Lock()
+	defer secretKey.Unlock()
+	secretKey.path = path
+	secretKey.key = nil
+}
+
+// secretDigestKey returns the key of secret digests, loading or creating its file on first use
+func secretDigestKey() []byte {
+	secretKey.Lock()
+	defer secretKey.Unlock()
+	if secretKey.key != nil {
+		return secretKey.key
+	}
+	if secretKey.path != "" {
+		key, err := loadSecretKey(secretKey.path)
+		if err == nil {
+			secretKey.key = key
+			return key
+		}
+		fmt.Fprintf(os.Stderr, "Failed to load the secret key, digesting secrets with a key of this run only: %v\n", err)
+	}
+	secretKey.key = make([]byte, secretKeySize)
+	rand.Read(secretKey.key)
+	return secretKey.key
+}
+
+// loadSecretKey reads the key in the file at path, creating the file with a random key, readable only
+// by the user, if it does not exist yet
+func loadSecretKey(path string) ([]byte, error) {
+	key, err := os.ReadFile(path)
+	if err == nil {
+		if len(key) < secretKeySize {
+			return nil, fmt.Errorf("secret key %s is shorter than %d bytes", path, secretKeySize)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading secret key: %w", err)
+	}
+
+	key = make([]byte, secretKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("error generating secret key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("error creating secret key directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, os.ErrExist) {
+		// Another run created it first
+		return loadSecretKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating secret key: %w", err)
+	}
+	if _, err := file.Write(key); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error writing secret key: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("error writing secret key: %w", err)
+	}
+	return key, nil
+}
+
+// secretDigest digests a secret value for the task hash. It is an HMAC under the secret key, so the
+// digests published in labels and container names cannot be used to guess values, and the name acts as
+// salt, so equal values of different secrets do not produce equal digests.
+func secretDigest(secret Secret) string {
+	mac := hmac.New(sha256.New, secretDigestKey())
+	fmt.Fprintf(mac, "buildvault-secret\x00%s\x00", secret.Name)
+	mac.Write([]byte(secret.Value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// secretEnv returns the environment of command execs, with the values of all environment secrets
+func (t *Task) secretEnv() []string {
+	var env []string
+	for _, secret := range t.Secrets {
+		if !secret.Mount {
+			env = append(env, secret.Name+"="+secret.Value)
+		}
+	}
+	return env
+}
+
+// hasSecretFiles reports whether the task container needs the tmpfs holding file secrets
+func (t *Task) hasSecretFiles() bool {
+	for _, secret := range t.Secrets {
+		if secret.Mount {
+			return true
+		}
+	}
+	return false
+}
+
+// writeSecretFiles writes the file secrets of t into the tmpfs of its running container. The values
+// are passed on stdin of an exec, the archive API would bypass the tmpfs.
+func (t *Task) writeSecretFiles(ctx context.Context, cli ExecAPI) error {
+	for _, secret := range t.Secrets {
+		if !secret.Mount {
+			continue
+		}
+		if err := t.writeSecretFile(ctx, cli, secret); err != nil {
+			return fmt.Errorf("error providing secret '%s' of task '%s': %w", secret.Name, t.Name, err)
+		}
+	}
+	return nil
+}
+
+func (t *Task) writeSecretFile(ctx context.Context, cli ExecAPI, secret Secret) error {
+	release, err := acquireExec(ctx, cli)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	execResp, err := cli.ContainerExecCreate(ctx, t.containerID, container.ExecOptions{
+		Cmd:         t.writeFileCommand(path.Join(secretsDir, secret.Name)),
+		User:        t.containerUser(), // The commands have to be able to read the file
+		AttachStdin: true,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating exec: %w", err)
+	}
+
+	attachResp, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return fmt.Errorf("error attaching to exec: %w", err)
+	}
+	defer attachResp.Close()
+
+	if _, err := io.WriteString(attachResp.Conn, secret.Value); err != nil {
+		return fmt.Errorf("error writing secret: %w", err)
+	}
+	if err := attachResp.CloseWrite(); err != nil {
+		return fmt.Errorf("error writing secret: %w", err)
+	}
+	io.Copy(io.Discard, attachResp.Reader)
+
+	inspectResp, err := cli.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return fmt.Errorf("error inspecting exec: %w", err)
+	}
+	if inspectResp.ExitCode != 0 {
+		return fmt.Errorf("writing the secret file failed with exit code %d", inspectResp.ExitCode)
+	}
+	return nil
+}
+
+// secretValues returns the strings to mask in command output, longest first so a secret containing
+// another one is masked as a whole. Multi-line values are also masked line by line, since output is
+// masked per line.
+func (t *Task) secretValues() []string {
+	seen := map[string]bool{}
+	var values []string
+	add := func(value string) {
+		if strings.TrimSpace(value) == "" || seen[value] {
+			return
+		}
+		seen[value] = true
+		values = append(values, value)
+	}
+	for _, secret := range t.Secrets {
+		add(secret.Value)
+		for _, line := range strings.Split(secret.Value, "\n") {
+			add(strings.TrimRight(line, "\r"))
+		}
+	}
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	return values
+}
+
+// secretMasker replaces secret values in output written to it. Output is buffered per line, so values
+// split across writes are masked as well; flush writes an incomplete last line.
+type secretMasker struct {
+	out    io.Writer
+	values []string
+	buf    []byte
+}
+
+func newSecretMasker(out io.Writer, values []string) *secretMasker {
+	return &secretMasker{out: out, values: values}
+}
+
+func (m *secretMasker) Write(p []byte) (int, error) {
+	m.buf = append(m.buf, p...)
+	end := bytes.LastIndexAny(m.buf, "\r\n")
+	if end < 0 {
+		return len(p), nil
+	}
+	if err := m.emit(m.buf[:end+1]); err != nil {
+		return 0, err
+	}
+	m.buf = append(m.buf[:0], m.buf[end+1:]...)
+	return len(p), nil
+}
+
+func (m *secretMasker) flush() error {
+	if len(m.buf) == 0 {
+		return nil
+	}
+	err := m.emit(m.buf)
+	m.buf = m.buf[:0]
+	return err
+}
+
+func (m *secretMasker) emit(data []byte) error {
+	text := string(data)
+	for _, value := range m.values {
+		text = strings.ReplaceAll(text, value, secretMask)
+	}
+	_, err := io.WriteString(m.out, text)
+	return err
+}
@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecretMaskerMasksSplitWrites(t *testing.T) {
+	var out bytes.Buffer
+	masker := newSecretMasker(&out, (&Task{Secrets: []Secret{{Name: "TOKEN", Value: "s3cr3t-value"}}}).secretValues())
+
+	masker.Write([]byte("token is s3cr"))
+	masker.Write([]byte("3t-value\nnext line without newline s3cr3t-value"))
+	if strings.Contains(out.String(), "next") {
+		t.Errorf("Incomplete lines should be buffered until flushed")
+	}
+	if err := masker.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "token is ***\nnext line without newline ***"
+	if out.String() != want {
+		t.Errorf("Expected %q, got %q", want, out.String())
+	}
+}
+
+func TestSecretValuesMaskMultilineSecrets(t *testing.T) {
+	task := &Task{Secrets: []Secret{{Name: "KEY", Value: "-----BEGIN KEY-----\nabcdef\n-----END KEY-----\n"}}}
+
+	var out bytes.Buffer
+	masker := newSecretMasker(&out, task.secretValues())
+	masker.Write([]byte("cat key: abcdef\n"))
+	masker.flush()
+	if strings.Contains(out.String(), "abcdef") {
+		t.Errorf("Lines of multi-line secrets should be masked, got %q", out.String())
+	}
+}
+
+func TestSecretsHashOnlyDigest(t *testing.T) {
+	task := &Task{Name: "deploy", BaseImage: "alpine", Secrets: []Secret{{Name: "TOKEN", Value: "one"}}}
+	first := task.generateHash()
+
+	task.Secrets[0].Value = "two"
+	if task.generateHash() == first {
+		t.Errorf("Changing a secret value should change the task hash")
+	}
+
+	if strings.Contains(secretDigest(task.Secrets[0]), "two") {
+		t.Errorf("Secret digests must not contain the value")
+	}
+	if secretDigest(Secret{Name: "A", Value: "same"}) == secretDigest(Secret{Name: "B", Value: "same"}) {
+		t.Errorf("Equal values of different secrets should have different digests")
+	}
+}
+
+func TestSecretKeyFile(t *testing.T) {
+	t.Cleanup(func() { SetSecretKeyFile("") })
+	secret := Secret{Name: "TOKEN", Value: "one"}
+	path := filepath.Join(t.TempDir(), ".buildvault", "secret.key")
+	SetSecretKeyFile(path)
+	digest := secretDigest(secret)
+
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0o600 || info.Size() != secretKeySize {
+		t.Fatalf("Expected a random key only the user can read, got %v, %v", info, err)
+	}
+	SetSecretKeyFile(path)
+	if secretDigest(secret) != digest {
+		t.Errorf("Expected the kept key to produce the same digest")
+	}
+	SetSecretKeyFile(filepath.Join(t.TempDir(), "secret.key"))
+	if secretDigest(secret) == digest {
+		t.Errorf("Expected another key to produce another digest")
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv("BUILDVAULT_TEST_TOKEN", "from-env")
+	file := filepath.Join(t.TempDir(), "key")
+	os.WriteFile(file, []byte("from-file"), 0o600)
+
+	task := &Task{Name: "deploy", Secrets: []Secret{
+		{Name: "TOKEN", Env: "BUILDVAULT_TEST_TOKEN"},
+		{Name: "key", File: file, Mount: true},
+	}}
+	if err := task.resolveSecrets(); err != nil {
+		t.Fatalf("Failed to resolve secrets: %v", err)
+	}
+	if task.Secrets[0].Value != "from-env" || task.Secrets[1].Value != "from-file" {
+		t.Errorf("Unexpected secret values %+v", task.Secrets)
+	}
+	if env := task.secretEnv(); len(env) != 1 || env[0] != "TOKEN=from-env" {
+		t.Errorf("Only environment secrets should be in the exec environment, got %v", env)
+	}
+	if !task.hasSecretFiles() {
+		t.Errorf("File secrets need a tmpfs at %s", secretsDir)
+	}
+
+	missing := &Task{Name: "deploy", Secrets: []Secret{{Name: "TOKEN", Env: "BUILDVAULT_TEST_UNSET"}}}
+	if err := missing.resolveSecrets(); err == nil {
+		t.Errorf("Expected an error for an unset environment variable")
+	}
+}
+
+func TestParsePipelineSecrets(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+tasks:
+  - name: deploy
+    image: alpine
+    secrets:
+      - name: NPM_TOKEN
+      - name: deploy_key
+        file: keys/deploy
+        mount: true
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+
+	secrets := pipeline.Tasks[0].Secrets
+	if len(secrets) != 2 || secrets[0].Env != "NPM_TOKEN" || secrets[1].File != "keys/deploy" || !secrets[1].Mount {
+		t.Errorf("Unexpected secrets %+v", secrets)
+	}
+}
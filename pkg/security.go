@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// Tasks run unprivileged with Docker's default capabilities, Seccomp and AppArmor profiles unless they
+// opt out, e.g. to build container images inside the task. The settings are part of the task hash, as
+// they change what commands are able to do.
+
+// profileUnconfined disables a Seccomp or AppArmor profile
+const profileUnconfined = "unconfined"
+
+var capabilityPattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// normalizeCapability turns capability names like sys_admin into SYS_ADMIN, without the CAP_ prefix
+func normalizeCapability(capability string) string {
+	return strings.TrimPrefix(strings.ToUpper(capability), "CAP_")
+}
+
+// validateSecurity checks the capabilities of t and loads its Seccomp profile
+func (t *Task) validateSecurity() error {
+	for _, capability := range slices.Concat(t.CapAdd, t.CapDrop) {
+		if !capabilityPattern.MatchString(normalizeCapability(capability)) {
+			return fmt.Errorf("invalid capability '%s' of task '%s'", capability, t.Name)
+		}
+	}
+	if t.Seccomp != "" && t.Seccomp != profileUnconfined && t.seccompProfile == "" {
+		profile, err := os.ReadFile(t.Seccomp)
+		if err != nil {
+			return fmt.Errorf("error reading Seccomp profile of task '%s': %w", t.Name, err)
+		}
+		t.seccompProfile = string(profile)
+	}
+	return nil
+}
+
+// securityHash describes the privileges of t for its hash, empty for the defaults. Only the content
+// of a Seccomp profile file is included, its path differs between machines sharing a cache.
+func (t *Task) securityHash() string {
+	if !t.Privileged && len(t.CapAdd) == 0 && len(t.CapDrop) == 0 && t.Seccomp == "" && t.AppArmor == "" {
+		return ""
+	}
+	seccomp := t.Seccomp
+	if t.seccompProfile != "" {
+		sum := sha256.Sum256([]byte(t.seccompProfile))
+		seccomp = hex.EncodeToString(sum[:])
+	}
+	return fmt.Sprintf("privileged=%t\x00add=%s\x00drop=%s\x00seccomp=%s\x00apparmor=%s", t.Privileged,
+		strings.Join(sortedCapabilities(t.CapAdd), ","), strings.Join(sortedCapabilities(t.CapDrop), ","), seccomp, t.AppArmor)
+}
+
+// sortedCapabilities returns the normalized capabilities in a stable order
+func sortedCapabilities(capabilities []string) []string {
+	var sorted []string
+	for _, capability := range capabilities {
+		sorted = append(sorted, normalizeCapability(capability))
+	}
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+// applySecurity sets the privileges of t on the host configuration of its container
+func (t *Task) applySecurity(hostConfig *container.HostConfig) {
+	hostConfig.Privileged = t.Privileged
+	hostConfig.CapAdd = sortedCapabilities(t.CapAdd)
+	hostConfig.CapDrop = sortedCapabilities(t.CapDrop)
+	switch {
+	case t.Seccomp == profileUnconfined:
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "seccomp="+profileUnconfined)
+	case t.seccompProfile != "":
+		// Like the docker CLI, the daemon receives the profile itself rather than a path on the client
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "seccomp="+t.seccompProfile)
+	}
+	if t.AppArmor != "" {
+		hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "apparmor="+t.AppArmor)
+	}
+}
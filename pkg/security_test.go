@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSecurityChangesTaskHash(t *testing.T) {
+	plain := &Task{Name: "image", BaseImage: "docker:dind"}
+	privileged := &Task{Name: "image", BaseImage: "docker:dind", Privileged: true}
+	if plain.generateHash() == privileged.generateHash() {
+		t.Error("Expected privileged mode to change the task hash")
+	}
+
+	// Capabilities are compared by name, not by spelling or order
+	a := &Task{Name: "image", BaseImage: "alpine", CapAdd: []string{"sys_admin", "NET_ADMIN"}}
+	b := &Task{Name: "image", BaseImage: "alpine", CapAdd: []string{"CAP_NET_ADMIN", "SYS_ADMIN"}}
+	if a.generateHash() != b.generateHash() {
+		t.Error("Expected equivalent capabilities to have the same hash")
+	}
+}
+
+func TestSeccompProfile(t *testing.T) {
+	dir := t.TempDir()
+	profile := filepath.Join(dir, "seccomp.json")
+	if err := os.WriteFile(profile, []byte(`{"defaultAction":"SCMP_ACT_ALLOW"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(dir, "moved.json")
+	if err := os.WriteFile(moved, []byte(`{"defaultAction":"SCMP_ACT_ALLOW"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	task := &Task{Name: "build", BaseImage: "alpine", Seccomp: profile, AppArmor: profileUnconfined, CapDrop: []string{"all"}}
+	other := &Task{Name: "build", BaseImage: "alpine", Seccomp: moved, AppArmor: profileUnconfined, CapDrop: []string{"ALL"}}
+	for _, task := range []*Task{task, other} {
+		if err := task.validateSecurity(); err != nil {
+			t.Fatalf("Expected the profile to load, got %v", err)
+		}
+	}
+	if task.generateHash() != other.generateHash() {
+		t.Error("Expected the hash to depend on the content of the profile, not its path")
+	}
+
+	hostConfig := task.hostConfig()
+	want := []string{`seccomp={"defaultAction":"SCMP_ACT_ALLOW"}`, "apparmor=unconfined"}
+	if !slices.Equal(hostConfig.SecurityOpt, want) || !slices.Equal(hostConfig.CapDrop, []string{"ALL"}) {
+		t.Errorf("Expected the profiles and dropped capabilities, got %v and %v", hostConfig.SecurityOpt, hostConfig.CapDrop)
+	}
+
+	if err := (&Task{Name: "build", Seccomp: filepath.Join(dir, "missing.json")}).validateSecurity(); err == nil {
+		t.Error("Expected a missing profile to be reported")
+	}
+	if err := (&Task{Name: "build", CapAdd: []string{"sys admin"}}).validateSecurity(); err == nil {
+		t.Error("Expected an invalid capability to be rejected")
+	}
+}
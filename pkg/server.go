@@ -0,0 +1,496 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// In server mode buildvault is a small build service: pipeline definitions are submitted by name, runs of
+// them are started, watched and cancelled, and every finished run is recorded in the history. The
+// Server holds this state, the HTTP API of server_http.go and the gRPC API of server_grpc.go reach it.
+
+// Statuses of runs of the server that have not finished yet
+const (
+	RunQueued  = "queued"  // The run waits for a free slot of the server or of its concurrency group
+	RunRunning = "running" // The run executes
+)
+
+// Limits of the state the server keeps in memory
+const (
+	DefaultKeepRuns = 100   // Finished runs kept if Server.KeepRuns is 0
+	maxRunEvents    = 10000 // Events kept per run, older ones are dropped and missed by later watchers
+)
+
+// ErrPipelineNotFound is returned for runs of pipelines that were never submitted to the server.
+var ErrPipelineNotFound = errors.New("pipeline not found")
+
+// ServerRun is a run started on the server.
+type ServerRun struct {
+	ID        uint64            `json:"id"`
+	Pipeline  string            `json:"pipeline"`
+	Targets   []string          `json:"targets"`
+	Vars      map[string]string `json:"vars,omitempty"`
+	Status    string            `json:"status"` // RunQueued, RunRunning, RunSucceeded, RunFailed or RunInterrupted
+	Group     string            `json:"group,omitempty"`
+	Priority  int               `json:"priority,omitempty"`
+	Queued    time.Time         `json:"queued"`
+	Started   time.Time         `json:"started"` // Zero while the run is queued
+	Duration  time.Duration     `json:"duration_ns,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	Tasks     []TaskRecord      `json:"tasks"`                // Tasks that started so far, all tasks of the run once it finished
+	HistoryID uint64            `json:"history_id,omitempty"` // ID of the run in the history, once recorded
+}
+
+// RunRequest selects what a run of a submitted pipeline executes.
+type RunRequest struct {
+	Targets  []string          `json:"targets"`  // Tasks to execute, all root tasks if empty
+	Vars     map[string]string `json:"vars"`     // Overrides of pipeline variables
+	Force    bool              `json:"force"`    // Execute tasks even if their outputs are in the artifact store
+	Group    string            `json:"group"`    // Concurrency group, limited by the GroupLimits of the server
+	Priority int               `json:"priority"` // Queued runs of higher priority start first
+}
+
+// Server runs submitted pipelines on a Docker daemon.
+type Server struct {
+	ArtifactStore *ArtifactStore // Store of the tasks of every run, nil for none
+	MaxRuns       int            // Runs executing at once, more are queued; 0 for no limit
+	GroupLimits   map[string]int // Runs of a concurrency group executing at once, 1 for groups not listed
+	KeepRuns      int            // Finished runs kept in memory, older ones only remain in the history; 0 for DefaultKeepRuns
+
+	// AllowHostAccess accepts pipelines that reach the host of the server: bind mounts, privileged
+	// containers and secrets read from host files. Anyone who can submit pipelines then controls the host.
+	AllowHostAccess bool
+
+	cli     DockerAPI
+	history *History // Where finished runs are recorded, nil to keep them in memory only
+
+	ctx    context.Context // Cancelled by Close, ending all runs
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu        sync.Mutex
+	pipelines map[string][]byte // Definitions by name
+	formats   map[string]string // File name the definition was submitted as, deciding YAML or HCL
+	runs      map[uint64]*serverRun
+	finished  []uint64 // IDs of the finished runs still in runs, oldest first
+	nextID    uint64
+	queue     []*serverRun   // Runs waiting to start
+	active    int            // Runs executing
+	groups    map[string]int // Runs executing by concurrency group
+}
+
+// serverRun is the state of a run, with its events for watchers
+type serverRun struct {
+	ServerRun
+	cancel  context.CancelFunc
+	events  []Event
+	dropped int           // Events dropped from the front of events to stay within maxRunEvents
+	changed chan struct{} // Closed and replaced on every event and when the run finishes
+	tasks   map[string]int
+	start   func() // Executes the run once it leaves the queue
+}
+
+// NewServer creates a server running pipelines on cli and recording them in history, which may be nil.
+func NewServer(cli DockerAPI, history *History) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		cli:       cli,
+		history:   history,
+		ctx:       ctx,
+		cancel:    cancel,
+		pipelines: map[string][]byte{},
+		formats:   map[string]string{},
+		runs:      map[uint64]*serverRun{},
+		groups:    map[string]int{},
+	}
+}
+
+// Close cancels all runs and waits for them to finish. Queued runs never start.
+func (s *Server) Close() {
+	s.cancel()
+	s.mu.Lock()
+	for _, run := range s.queue {
+		s.dequeued(run)
+	}
+	s.queue = nil
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// SubmitPipeline stores the definition of a pipeline under name, replacing an earlier one. The
+// definition is in HCL if filename ends in .hcl, in YAML otherwise; it has to parse and, unless
+// AllowHostAccess is set, must not reach the host.
+func (s *Server) SubmitPipeline(name string, data []byte, filename string) error {
+	if name == "" {
+		return configErrorf("pipeline without a name")
+	}
+	pipeline, err := parsePipelineAs(data, filename)
+	if err != nil {
+		return &ConfigError{Err: err}
+	}
+	if !s.AllowHostAccess {
+		if err := checkHostAccess(pipeline); err != nil {
+			return &ConfigError{Err: err}
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pipelines[name] = slices.Clone(data)
+	s.formats[name] = filename
+	return nil
+}
+
+// parsePipelineAs parses the definition of a pipeline like LoadPipeline would the file filename
+func parsePipelineAs(data []byte, filename string) (*Pipeline, error) {
+	if filepath.Ext(filename) == ".hcl" {
+		return ParsePipelineHCL(data, filename)
+	}
+	return ParsePipeline(data)
+}
+
+// checkHostAccess rejects pipelines whose tasks bind-mount host paths, run privileged or read secrets
+// from host files
+func checkHostAccess(pipeline *Pipeline) error {
+	for _, task := range pipeline.Tasks {
+		for _, m := range task.Mounts {
+			if m.Type == MountBind {
+				return fmt.Errorf("task '%s' bind-mounts the host path %s, which the server does not allow", task.Name, m.Source)
+			}
+		}
+		if task.Privileged {
+			return fmt.Errorf("task '%s' runs privileged, which the server does not allow", task.Name)
+		}
+		for _, secret := range task.Secrets {
+			if secret.File != "" {
+				return fmt.Errorf("secret '%s' of task '%s' is read from a host file, which the server does not allow", secret.Name, task.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// Pipelines returns the names of the submitted pipelines, sorted.
+func (s *Server) Pipelines() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Pipeline returns the definition of the submitted pipeline name.
+func (s *Server) Pipeline(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.pipelines[name]
+	return data, ok
+}
+
+// StartRun queues a run of the submitted pipeline name, starts it in the background once the limits of
+// the server allow and returns it as queued or started.
+func (s *Server) StartRun(name string, request RunRequest) (ServerRun, error) {
+	s.mu.Lock()
+	data, ok := s.pipelines[name]
+	filename := s.formats[name]
+	s.mu.Unlock()
+	if !ok {
+		return ServerRun{}, fmt.Errorf("%w: %s", ErrPipelineNotFound, name)
+	}
+
+	// Tasks keep the state of their run, so every run gets its own
+	pipeline, err := parsePipelineAs(data, filename)
+	if err != nil {
+		return ServerRun{}, &ConfigError{Err: err}
+	}
+	for name, value := range request.Vars {
+		if err := pipeline.SetVar(name, value); err != nil {
+			return ServerRun{}, err
+		}
+	}
+	for _, target := range request.Targets {
+		if _, err := pipeline.Target(target); err != nil {
+			return ServerRun{}, err
+		}
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	run := &serverRun{
+		ServerRun: ServerRun{
+			Pipeline: name,
+			Targets:  taskNames(pipeline.Targets()),
+			Vars:     request.Vars,
+			Status:   RunQueued,
+			Group:    request.Group,
+			Priority: request.Priority,
+			Queued:   time.Now().UTC(),
+			Tasks:    []TaskRecord{},
+		},
+		cancel:  cancel,
+		changed: make(chan struct{}),
+		tasks:   map[string]int{},
+	}
+	for _, task := range pipeline.Tasks {
+		task.Events = &serverEvents{server: s, run: run}
+		task.ArtifactStore = s.ArtifactStore
+	}
+	opts := []ExecuteOption{WithStdout(io.Discard), WithStderr(io.Discard)}
+	if request.Force {
+		opts = append(opts, WithForce())
+	}
+	run.start = func() {
+		defer s.wg.Done()
+		defer cancel()
+		err := pipeline.Run(ctx, s.cli, opts...)
+		s.finish(run, pipeline, err, ctx.Err() != nil)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ctx.Err() != nil {
+		return ServerRun{}, errors.New("server is closed")
+	}
+	s.nextID++
+	run.ID = s.nextID
+	s.runs[run.ID] = run
+	s.queue = append(s.queue, run)
+	s.dispatch()
+	return run.snapshot(), nil
+}
+
+// dispatch starts the queued runs the limits allow, those of higher priority first. A run waiting for
+// its group does not hold up runs of other groups. The caller must hold the lock.
+func (s *Server) dispatch() {
+	sort.SliceStable(s.queue, func(i, j int) bool { return s.queue[i].Priority > s.queue[j].Priority })
+	var waiting []*serverRun
+	for _, run := range s.queue {
+		if s.ctx.Err() != nil || (s.MaxRuns > 0 && s.active >= s.MaxRuns) || !s.groupAllows(run.Group) {
+			waiting = append(waiting, run)
+			continue
+		}
+		s.active++
+		if run.Group != "" {
+			s.groups[run.Group]++
+		}
+		run.Status = RunRunning
+		run.Started = time.Now().UTC()
+		run.notify()
+		s.wg.Add(1)
+		go run.start()
+	}
+	s.queue = waiting
+}
+
+// groupAllows tells whether another run of group may start. The caller must hold the lock.
+func (s *Server) groupAllows(group string) bool {
+	if group == "" {
+		return true
+	}
+	limit, ok := s.GroupLimits[group]
+	if !ok {
+		limit = 1
+	}
+	return limit <= 0 || s.groups[group] < limit
+}
+
+// dequeued finishes a run that left the queue without starting. The caller must hold the lock.
+func (s *Server) dequeued(run *serverRun) {
+	run.cancel()
+	run.Status = RunInterrupted
+	run.Error = "cancelled while queued"
+	close(run.changed)
+	run.changed = nil
+	s.forget(run)
+}
+
+// forget drops the oldest finished runs beyond KeepRuns once run finished. The caller must hold the lock.
+func (s *Server) forget(run *serverRun) {
+	s.finished = append(s.finished, run.ID)
+	keep := s.KeepRuns
+	if keep <= 0 {
+		keep = DefaultKeepRuns
+	}
+	for len(s.finished) > keep {
+		delete(s.runs, s.finished[0])
+		s.finished = s.finished[1:]
+	}
+}
+
+// finish records the outcome of run and wakes up its watchers
+func (s *Server) finish(run *serverRun, pipeline *Pipeline, runErr error, interrupted bool) {
+	targets := pipeline.Targets()
+	record := NewRunRecord(targets, reachableFrom(targets), run.Started, time.Now(), runErr, interrupted)
+	if s.history != nil {
+		if err := s.history.Record(record); err != nil {
+			fmt.Printf("Failed to record run %d of pipeline '%s': %v\n", run.ID, run.Pipeline, err)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run.Status = record.Status
+	run.Duration = record.Duration
+	run.Error = record.Error
+	run.Tasks = record.Tasks
+	run.HistoryID = record.ID
+	close(run.changed)
+	run.changed = nil
+	s.forget(run)
+
+	s.active--
+	if run.Group != "" {
+		s.groups[run.Group]--
+	}
+	s.dispatch()
+}
+
+// reachableFrom returns targets and all their dependencies in execution order
+func reachableFrom(targets []*Task) []*Task {
+	tasks, err := topoSort(targets)
+	if err != nil {
+		return targets
+	}
+	return tasks
+}
+
+// snapshot copies the state of the run. The caller must hold the lock of the server.
+func (r *serverRun) snapshot() ServerRun {
+	run := r.ServerRun
+	run.Tasks = slices.Clone(r.Tasks)
+	return run
+}
+
+// Run returns the run with the given ID.
+func (s *Server) Run(id uint64) (ServerRun, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[id]
+	if !ok {
+		return ServerRun{}, false
+	}
+	return run.snapshot(), true
+}
+
+// Runs returns the runs still kept in memory, the latest first.
+func (s *Server) Runs() []ServerRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var runs []ServerRun
+	for _, run := range s.runs {
+		runs = append(runs, run.snapshot())
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].ID > runs[j].ID })
+	return runs
+}
+
+// History returns the history the server records runs in, nil if it has none.
+func (s *Server) History() *History {
+	return s.history
+}
+
+// CancelRun interrupts the run with the given ID, or removes it from the queue. Cancelling a finished run
+// does nothing.
+func (s *Server) CancelRun(id uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	run, ok := s.runs[id]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrRunNotFound, id)
+	}
+	if i := slices.Index(s.queue, run); i >= 0 {
+		s.queue = slices.Delete(s.queue, i, i+1)
+		s.dequeued(run)
+		return nil
+	}
+	run.cancel()
+	return nil
+}
+
+// WatchRun calls fn with every event of the run with the given ID, first those that already happened,
+// until the run finished or ctx is done. It returns the run as it finished. Of long runs only the last
+// events are kept, a watcher falling behind skips those dropped in between.
+func (s *Server) WatchRun(ctx context.Context, id uint64, fn func(Event) error) (ServerRun, error) {
+	s.mu.Lock()
+	run, ok := s.runs[id]
+	s.mu.Unlock()
+	if !ok {
+		return ServerRun{}, fmt.Errorf("%w: %d", ErrRunNotFound, id)
+	}
+
+	// The run may be forgotten by the server while it is watched, so it is looked up only once
+	next := 0
+	for {
+		s.mu.Lock()
+		next = max(next, run.dropped)
+		events := run.events[next-run.dropped:]
+		next = run.dropped + len(run.events)
+		changed := run.changed
+		snapshot := run.snapshot()
+		s.mu.Unlock()
+
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return snapshot, err
+			}
+		}
+		if changed == nil {
+			return snapshot, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return snapshot, ctx.Err()
+		}
+	}
+}
+
+// serverEvents collects the events of the tasks of a run
+type serverEvents struct {
+	server *Server
+	run    *serverRun
+}
+
+func (e *serverEvents) Event(event Event) {
+	e.server.mu.Lock()
+	defer e.server.mu.Unlock()
+	run := e.run
+	if len(run.events) >= maxRunEvents {
+		// Reslicing keeps the array, append copies the kept events to a new one once it is full
+		run.events = run.events[1:]
+		run.dropped++
+	}
+	run.events = append(run.events, event)
+
+	switch event.Type {
+	case EventTaskStarted:
+		run.tasks[event.Task] = len(run.Tasks)
+		run.Tasks = append(run.Tasks, TaskRecord{Name: event.Task, Status: RunRunning})
+	case EventTaskFinished:
+		if i, ok := run.tasks[event.Task]; ok {
+			run.Tasks[i].Status = event.Status
+			run.Tasks[i].Duration = event.Duration
+		} else {
+			run.tasks[event.Task] = len(run.Tasks)
+			run.Tasks = append(run.Tasks, TaskRecord{Name: event.Task, Status: event.Status})
+		}
+	}
+
+	run.notify()
+}
+
+// notify wakes up the watchers of a run that has not finished. The caller must hold the lock of the server.
+func (r *serverRun) notify() {
+	if r.changed != nil {
+		close(r.changed)
+		r.changed = make(chan struct{})
+	}
+}
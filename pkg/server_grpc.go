@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+
+	"github.com/benjaminstrasser/buildvault/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The gRPC API of the server is defined in api/buildvault.proto. It offers what the HTTP API does to
+// clients that want typed messages, and streams the progress of runs.
+
+// GRPCServer returns a gRPC server with the API of s registered. With a non-empty token, calls have to
+// authenticate with it as bearer token in their authorization metadata.
+func (s *Server) GRPCServer(token string, opts ...grpc.ServerOption) *grpc.Server {
+	if token != "" {
+		opts = append(opts,
+			grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+				if err := checkGRPCToken(ctx, token); err != nil {
+					return nil, err
+				}
+				return handler(ctx, req)
+			}),
+			grpc.ChainStreamInterceptor(func(srv any, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+				if err := checkGRPCToken(stream.Context(), token); err != nil {
+					return err
+				}
+				return handler(srv, stream)
+			}),
+		)
+	}
+	server := grpc.NewServer(opts...)
+	api.RegisterBuildVaultServer(server, &grpcService{server: s})
+	return server
+}
+
+// checkGRPCToken checks the bearer token of a call
+func checkGRPCToken(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		given, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
+
+// grpcError converts an error of the server to a gRPC status
+func grpcError(err error) error {
+	var configErr *ConfigError
+	switch {
+	case errors.Is(err, ErrPipelineNotFound), errors.Is(err, ErrRunNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.As(err, &configErr):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// grpcService implements the gRPC API on a server
+type grpcService struct {
+	api.UnimplementedBuildVaultServer
+	server *Server
+}
+
+func (g *grpcService) SubmitPipeline(ctx context.Context, req *api.SubmitPipelineRequest) (*api.SubmitPipelineResponse, error) {
+	filename := "buildvault.yaml"
+	if req.GetFormat() == api.SubmitPipelineRequest_FORMAT_HCL {
+		filename = "buildvault.hcl"
+	}
+	if err := g.server.SubmitPipeline(req.GetName(), req.GetDefinition(), filename); err != nil {
+		return nil, grpcError(err)
+	}
+	return &api.SubmitPipelineResponse{Name: req.GetName()}, nil
+}
+
+func (g *grpcService) Run(req *api.RunRequest, stream grpc.ServerStreamingServer[api.RunUpdate]) error {
+	run, err := g.server.StartRun(req.GetPipeline(), RunRequest{
+		Targets:  req.GetTargets(),
+		Vars:     req.GetVars(),
+		Force:    req.GetForce(),
+		Group:    req.GetGroup(),
+		Priority: int(req.GetPriority()),
+	})
+	if err != nil {
+		return grpcError(err)
+	}
+	if err := stream.Send(&api.RunUpdate{Update: &api.RunUpdate_Started{Started: runInfo(run)}}); err != nil {
+		return err
+	}
+	return g.watch(run.ID, stream)
+}
+
+func (g *grpcService) WatchEvents(req *api.WatchEventsRequest, stream grpc.ServerStreamingServer[api.RunUpdate]) error {
+	return g.watch(req.GetRunId(), stream)
+}
+
+// watch streams the events of a run and the finished run
+func (g *grpcService) watch(id uint64, stream grpc.ServerStreamingServer[api.RunUpdate]) error {
+	run, err := g.server.WatchRun(stream.Context(), id, func(event Event) error {
+		return stream.Send(&api.RunUpdate{Update: &api.RunUpdate_Event{Event: eventMessage(event)}})
+	})
+	if err != nil {
+		return grpcError(err)
+	}
+	return stream.Send(&api.RunUpdate{Update: &api.RunUpdate_Finished{Finished: runInfo(run)}})
+}
+
+func (g *grpcService) CancelRun(ctx context.Context, req *api.CancelRunRequest) (*api.RunInfo, error) {
+	if err := g.server.CancelRun(req.GetRunId()); err != nil {
+		return nil, grpcError(err)
+	}
+	run, _ := g.server.Run(req.GetRunId())
+	return runInfo(run), nil
+}
+
+func (g *grpcService) GetRun(ctx context.Context, req *api.GetRunRequest) (*api.RunInfo, error) {
+	run, ok := g.server.Run(req.GetRunId())
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%v: %d", ErrRunNotFound, req.GetRunId())
+	}
+	return runInfo(run), nil
+}
+
+// runInfo converts a run to its message
+func runInfo(run ServerRun) *api.RunInfo {
+	info := &api.RunInfo{
+		Id:        run.ID,
+		Pipeline:  run.Pipeline,
+		Targets:   run.Targets,
+		Vars:      run.Vars,
+		Status:    run.Status,
+		Group:     run.Group,
+		Priority:  int32(run.Priority),
+		Queued:    timestamppb.New(run.Queued),
+		Duration:  durationpb.New(run.Duration),
+		Error:     run.Error,
+		HistoryId: run.HistoryID,
+	}
+	if !run.Started.IsZero() {
+		info.Started = timestamppb.New(run.Started)
+	}
+	for _, task := range run.Tasks {
+		info.Tasks = append(info.Tasks, &api.TaskInfo{
+			Name:        task.Name,
+			Status:      task.Status,
+			Hash:        task.Hash,
+			Duration:    durationpb.New(task.Duration),
+			ExitCode:    int32(task.ExitCode),
+			ContainerId: task.ContainerID,
+			Artifacts:   task.Artifacts,
+		})
+	}
+	return info
+}
+
+// eventMessage converts an event to its message
+func eventMessage(event Event) *api.Event {
+	return &api.Event{
+		Type:     event.Type,
+		Time:     timestamppb.New(event.Time),
+		Task:     event.Task,
+		Stream:   event.Stream,
+		Line:     event.Line,
+		From:     event.From,
+		To:       event.To,
+		Source:   event.Source,
+		Bytes:    event.Bytes,
+		Status:   event.Status,
+		Duration: durationpb.New(event.Duration),
+		Error:    event.Error,
+	}
+}
@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/benjaminstrasser/buildvault/pkg/api"
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCServer(t *testing.T) {
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:latest"}, Files: map[string]string{"/bin/sh": ""}})
+	cli.Exec = func(e *dockertest.Exec) int {
+		if command := e.Cmd[len(e.Cmd)-1]; strings.HasPrefix(command, "build") {
+			e.Stdout.Write([]byte(command + "\n"))
+		}
+		return dockertest.Builtins(e)
+	}
+
+	server := NewServer(cli, nil)
+	defer server.Close()
+	listener := bufconn.Listen(1 << 20)
+	grpcServer := server.GRPCServer("secret")
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := api.NewBuildVaultClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+
+	if _, err := client.GetRun(context.Background(), &api.GetRunRequest{RunId: 1}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("Expected calls without token to be rejected, got %v", err)
+	}
+	if _, err := client.SubmitPipeline(ctx, &api.SubmitPipelineRequest{Name: "app", Definition: []byte("tasks: [{name: a}")}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected an invalid pipeline to be rejected, got %v", err)
+	}
+	definition := "vars:\n  VERSION: dev\ntasks:\n  - name: build\n    image: alpine\n    commands: [\"build ${{ vars.VERSION }}\"]\n"
+	if _, err := client.SubmitPipeline(ctx, &api.SubmitPipelineRequest{Name: "app", Definition: []byte(definition)}); err != nil {
+		t.Fatalf("Failed to submit pipeline: %v", err)
+	}
+
+	stream, err := client.Run(ctx, &api.RunRequest{Pipeline: "other"})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.NotFound {
+		t.Errorf("Expected runs of unknown pipelines not to be found, got %v", err)
+	}
+
+	stream, err = client.Run(ctx, &api.RunRequest{Pipeline: "app", Vars: map[string]string{"VERSION": "1.2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var started, finished *api.RunInfo
+	var types, lines, statuses []string
+	for {
+		update, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case update.GetStarted() != nil:
+			started = update.GetStarted()
+		case update.GetEvent().GetType() == EventStatus:
+			statuses = append(statuses, update.GetEvent().GetLine())
+		case update.GetEvent() != nil:
+			types = append(types, update.GetEvent().GetType())
+			if update.GetEvent().GetType() == EventCommandOutput {
+				lines = append(lines, update.GetEvent().GetLine())
+			}
+		case update.GetFinished() != nil:
+			finished = update.GetFinished()
+		}
+	}
+	if started == nil || started.GetStatus() != RunRunning {
+		t.Fatalf("Expected the started run first, got %v", started)
+	}
+	if len(types) == 0 || types[0] != EventTaskStarted || types[len(types)-1] != EventTaskFinished {
+		t.Errorf("Unexpected events %v", types)
+	}
+	if !slices.ContainsFunc(statuses, func(line string) bool { return strings.HasPrefix(line, "Task: build (Container: ") }) {
+		t.Errorf("Expected the status lines of the task in the stream, got %q", statuses)
+	}
+	if !slices.Contains(lines, "build 1.2") {
+		t.Errorf("Unexpected command output %q", lines)
+	}
+	if finished.GetStatus() != RunSucceeded || len(finished.GetTasks()) != 1 || finished.GetTasks()[0].GetStatus() != StatusExecuted {
+		t.Errorf("Unexpected finished run %v", finished)
+	}
+
+	// Watching a finished run replays its events
+	watch, err := client.WatchEvents(ctx, &api.WatchEventsRequest{RunId: started.GetId()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for {
+		if _, err := watch.Recv(); err != nil {
+			break
+		}
+		count++
+	}
+	if count != len(types)+len(statuses)+1 {
+		t.Errorf("Expected %d events and the finished run, got %d messages", len(types)+len(statuses), count)
+	}
+	if _, err := client.CancelRun(ctx, &api.CancelRunRequest{RunId: 99}); status.Code(err) != codes.NotFound {
+		t.Errorf("Expected cancelling an unknown run to fail, got %v", err)
+	}
+}
@@ -0,0 +1,245 @@
+package pkg
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The HTTP API of the server speaks JSON:
+//
+//	GET    /pipelines                 names of the submitted pipelines
+//	PUT    /pipelines/{name}          submit a pipeline definition, in HCL if ?format=hcl, YAML otherwise
+//	GET    /pipelines/{name}          the submitted definition
+//	POST   /pipelines/{name}/runs     start a run, with an optional RunRequest as body
+//	GET    /runs                      runs since the server started, the latest first
+//	GET    /runs/{id}                 status of a run
+//	GET    /runs/{id}/events          events of a run as server-sent events, until it finished
+//	POST   /runs/{id}/cancel          cancel a run
+//	GET    /history?limit=N           runs recorded in the history
+//	GET    /history/{id}              a run of the history
+
+// maxPipelineSize limits the size of submitted pipeline definitions
+const maxPipelineSize = 4 << 20
+
+// Handler returns the HTTP API of s. With a non-empty token, requests have to authenticate with it as
+// bearer token.
+func (s *Server) Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /pipelines", s.handleListPipelines)
+	mux.HandleFunc("PUT /pipelines/{name}", s.handleSubmitPipeline)
+	mux.HandleFunc("GET /pipelines/{name}", s.handleGetPipeline)
+	mux.HandleFunc("POST /pipelines/{name}/runs", s.handleStartRun)
+	mux.HandleFunc("GET /runs", s.handleListRuns)
+	mux.HandleFunc("GET /runs/{id}", s.handleGetRun)
+	mux.HandleFunc("GET /runs/{id}/events", s.handleRunEvents)
+	mux.HandleFunc("POST /runs/{id}/cancel", s.handleCancelRun)
+	mux.HandleFunc("GET /history", s.handleListHistory)
+	mux.HandleFunc("GET /history/{id}", s.handleGetHistory)
+	if token == "" {
+		return mux
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON responds with value as JSON
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	// The client may be gone, there is nobody to report that to
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError responds with err as JSON object
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// errorStatus returns the HTTP status of an error of the server
+func errorStatus(err error) int {
+	var configErr *ConfigError
+	switch {
+	case errors.Is(err, ErrPipelineNotFound), errors.Is(err, ErrRunNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &configErr):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// pathRunID parses the run ID of the request path
+func pathRunID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid run ID '%s'", r.PathValue("id")))
+		return 0, false
+	}
+	return id, true
+}
+
+func (s *Server) handleListPipelines(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string][]string{"pipelines": s.Pipelines()})
+}
+
+func (s *Server) handleSubmitPipeline(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxPipelineSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("error reading pipeline: %w", err))
+		return
+	}
+	filename := "buildvault.yaml"
+	if r.URL.Query().Get("format") == "hcl" {
+		filename = "buildvault.hcl"
+	}
+	if err := s.SubmitPipeline(r.PathValue("name"), data, filename); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"pipeline": r.PathValue("name")})
+}
+
+func (s *Server) handleGetPipeline(w http.ResponseWriter, r *http.Request) {
+	data, ok := s.Pipeline(r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w: %s", ErrPipelineNotFound, r.PathValue("name")))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(data)
+}
+
+func (s *Server) handleStartRun(w http.ResponseWriter, r *http.Request) {
+	var request RunRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("error decoding run request: %w", err))
+		return
+	}
+	run, err := s.StartRun(r.PathValue("name"), request)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (s *Server) handleListRuns(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string][]ServerRun{"runs": s.Runs()})
+}
+
+func (s *Server) handleGetRun(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathRunID(w, r)
+	if !ok {
+		return
+	}
+	run, ok := s.Run(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w: %d", ErrRunNotFound, id))
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+// handleRunEvents streams the events of a run as server-sent events named after their type, and a last
+// run_finished event with the finished run
+func (s *Server) handleRunEvents(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathRunID(w, r)
+	if !ok {
+		return
+	}
+	if _, ok := s.Run(id); !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w: %d", ErrRunNotFound, id))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher := http.NewResponseController(w)
+	send := func(name string, value any) error {
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+			return err
+		}
+		return flusher.Flush()
+	}
+
+	run, err := s.WatchRun(r.Context(), id, func(event Event) error {
+		return send(event.Type, event)
+	})
+	if err != nil {
+		// The client went away
+		return
+	}
+	send("run_finished", run)
+}
+
+func (s *Server) handleCancelRun(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathRunID(w, r)
+	if !ok {
+		return
+	}
+	if err := s.CancelRun(id); err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	run, _ := s.Run(id)
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+func (s *Server) handleListHistory(w http.ResponseWriter, r *http.Request) {
+	if s.history == nil {
+		writeJSON(w, http.StatusOK, map[string][]RunRecord{"runs": {}})
+		return
+	}
+	limit := 20
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit '%s'", value))
+			return
+		}
+	}
+	runs, err := s.history.Runs(limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if runs == nil {
+		runs = []RunRecord{}
+	}
+	writeJSON(w, http.StatusOK, map[string][]RunRecord{"runs": runs})
+}
+
+func (s *Server) handleGetHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathRunID(w, r)
+	if !ok {
+		return
+	}
+	if s.history == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%w: %d", ErrRunNotFound, id))
+		return
+	}
+	run, err := s.history.Run(id)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, run)
+}
@@ -0,0 +1,229 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+)
+
+func TestServer(t *testing.T) {
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:latest"}, Files: map[string]string{"/bin/sh": ""}})
+	cli.Exec = func(e *dockertest.Exec) int {
+		if command := e.Cmd[len(e.Cmd)-1]; strings.HasPrefix(command, "build") {
+			e.Stdout.Write([]byte(command + "\n"))
+		}
+		return dockertest.Builtins(e)
+	}
+
+	history, err := OpenHistory(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+	server := NewServer(cli, history)
+	defer server.Close()
+	api := httptest.NewServer(server.Handler("secret"))
+	defer api.Close()
+
+	request := func(method, path, body string) *http.Response {
+		req, err := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp, err := http.Get(api.URL + "/pipelines"); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected requests without token to be rejected, got %v", resp.Status)
+	}
+	if resp := request("PUT", "/pipelines/app", "tasks: [{name: a}"); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected an invalid pipeline to be rejected, got %s", resp.Status)
+	}
+	definition := "vars:\n  VERSION: dev\ntasks:\n  - name: build\n    image: alpine\n    commands: [\"build ${{ vars.VERSION }}\"]\n"
+	if resp := request("PUT", "/pipelines/app", definition); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to submit pipeline: %s", resp.Status)
+	}
+	if resp := request("POST", "/pipelines/other/runs", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected runs of unknown pipelines not to be found, got %s", resp.Status)
+	}
+	if resp := request("POST", "/pipelines/app/runs", `{"targets": ["deploy"]}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected runs of unknown targets to be rejected, got %s", resp.Status)
+	}
+
+	resp := request("POST", "/pipelines/app/runs", `{"vars": {"VERSION": "1.2"}}`)
+	var run ServerRun
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil || resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Failed to start run %s: %v", resp.Status, err)
+	}
+
+	// The event stream replays what happened so far and ends with the finished run
+	events := request("GET", "/runs/"+strconv.FormatUint(run.ID, 10)+"/events", "")
+	defer events.Body.Close()
+	var names, lines, statuses []string
+	scanner := bufio.NewScanner(events.Body)
+	for scanner.Scan() {
+		if name, ok := strings.CutPrefix(scanner.Text(), "event: "); ok && name != EventStatus {
+			names = append(names, name)
+		}
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var event Event
+		json.Unmarshal([]byte(data), &event)
+		switch event.Type {
+		case EventCommandOutput:
+			lines = append(lines, event.Line)
+		case EventStatus:
+			statuses = append(statuses, event.Line)
+		}
+	}
+	if len(names) == 0 || names[0] != EventTaskStarted || names[len(names)-1] != "run_finished" {
+		t.Errorf("Unexpected events %v", names)
+	}
+	if !slices.Contains(lines, "build 1.2") {
+		t.Errorf("Unexpected command output %q", lines)
+	}
+	if !slices.ContainsFunc(statuses, func(line string) bool { return strings.HasPrefix(line, "Task: build (Container: ") }) {
+		t.Errorf("Expected the status lines of the task in the stream, got %q", statuses)
+	}
+
+	resp = request("GET", "/runs/"+strconv.FormatUint(run.ID, 10), "")
+	if err := json.NewDecoder(resp.Body).Decode(&run); err != nil {
+		t.Fatal(err)
+	}
+	if run.Status != RunSucceeded || len(run.Tasks) != 1 || run.Tasks[0].Status != StatusExecuted || run.HistoryID == 0 {
+		t.Errorf("Unexpected finished run %+v", run)
+	}
+	if resp := request("GET", "/history/"+strconv.FormatUint(run.HistoryID, 10), ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("Run was not recorded in the history: %s", resp.Status)
+	}
+	if resp := request("POST", "/runs/99/cancel", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected cancelling an unknown run to fail, got %s", resp.Status)
+	}
+}
+
+func TestServerQueue(t *testing.T) {
+	server, release := blockingServer(t)
+	server.MaxRuns = 1
+	start := func(request RunRequest) ServerRun {
+		run, err := server.StartRun("app", request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return run
+	}
+
+	first := start(RunRequest{})
+	low := start(RunRequest{})
+	high := start(RunRequest{Priority: 5})
+	cancelled := start(RunRequest{Priority: 9})
+	if first.Status != RunRunning || low.Status != RunQueued || high.Status != RunQueued {
+		t.Fatalf("Expected runs beyond the limit to be queued, got %s, %s and %s", first.Status, low.Status, high.Status)
+	}
+	if err := server.CancelRun(cancelled.ID); err != nil {
+		t.Fatal(err)
+	}
+	if run := waitForRun(t, server, cancelled.ID); run.Status != RunInterrupted || !run.Started.IsZero() {
+		t.Errorf("Expected the cancelled run to end without starting, got %+v", run)
+	}
+
+	// The run of higher priority starts next, although it was queued later
+	release <- struct{}{}
+	waitForRun(t, server, first.ID)
+	if run, _ := server.Run(high.ID); run.Status != RunRunning {
+		t.Errorf("Expected the run of higher priority to start, got %s", run.Status)
+	}
+	if run, _ := server.Run(low.ID); run.Status != RunQueued {
+		t.Errorf("Expected the run of lower priority to wait, got %s", run.Status)
+	}
+	close(release)
+	if run := waitForRun(t, server, low.ID); run.Status != RunSucceeded {
+		t.Errorf("Unexpected status of the last run %s", run.Status)
+	}
+}
+
+func TestServerGroups(t *testing.T) {
+	server, release := blockingServer(t)
+	server.GroupLimits = map[string]int{"build": 2}
+	var runs []ServerRun
+	for _, group := range []string{"deploy", "deploy", "build", "build", "build", ""} {
+		run, err := server.StartRun("app", RunRequest{Group: group})
+		if err != nil {
+			t.Fatal(err)
+		}
+		runs = append(runs, run)
+	}
+	var statuses []string
+	for _, run := range runs {
+		statuses = append(statuses, run.Status)
+	}
+	if want := []string{RunRunning, RunQueued, RunRunning, RunRunning, RunQueued, RunRunning}; !slices.Equal(statuses, want) {
+		t.Errorf("Expected groups to limit their runs, got %v", statuses)
+	}
+	close(release)
+	for _, run := range runs {
+		if run := waitForRun(t, server, run.ID); run.Status != RunSucceeded {
+			t.Errorf("Unexpected status of run %d: %s", run.ID, run.Status)
+		}
+	}
+}
+
+func TestServerKeepRuns(t *testing.T) {
+	server, release := blockingServer(t)
+	server.KeepRuns = 2
+	close(release)
+	var last ServerRun
+	for range 3 {
+		run, err := server.StartRun("app", RunRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = waitForRun(t, server, run.ID)
+	}
+	runs := server.Runs()
+	if len(runs) != 2 || runs[0].ID != last.ID {
+		t.Errorf("Expected only the last two finished runs to be kept, got %+v", runs)
+	}
+	if _, ok := server.Run(1); ok {
+		t.Error("Expected the oldest run to be forgotten")
+	}
+}
+
+func TestServerHostAccess(t *testing.T) {
+	server := NewServer(dockertest.New(), nil)
+	defer server.Close()
+	definitions := map[string]string{
+		"bind mount":  "tasks:\n  - name: build\n    image: alpine\n    mounts: [{type: bind, source: /etc, target: /host}]\n",
+		"privileged":  "tasks:\n  - name: build\n    image: alpine\n    privileged: true\n",
+		"secret file": "tasks:\n  - name: build\n    image: alpine\n    secrets: [{name: KEY, file: /root/.ssh/id_rsa}]\n",
+	}
+	for name, definition := range definitions {
+		var configErr *ConfigError
+		if err := server.SubmitPipeline("app", []byte(definition), "buildvault.yaml"); !errors.As(err, &configErr) {
+			t.Errorf("Expected a pipeline with a %s to be rejected, got %v", name, err)
+		}
+	}
+
+	server.AllowHostAccess = true
+	for name, definition := range definitions {
+		if err := server.SubmitPipeline("app", []byte(definition), "buildvault.yaml"); err != nil {
+			t.Errorf("Expected a pipeline with a %s to be accepted with host access, got %v", name, err)
+		}
+	}
+}
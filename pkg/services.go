@@ -0,0 +1,237 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+)
+
+// Service is a container started next to the task container for the duration of its commands, e.g. a
+// database for integration tests. The task reaches it under its name on a network shared only by the
+// task and its services.
+type Service struct {
+	Name      string            `json:"name" yaml:"name"`           // Host name of the service
+	Image     string            `json:"image" yaml:"image"`         // Image of the service container
+	Ports     []string          `json:"ports" yaml:"ports"`         // Exposed ports ("5432"), or published to the Docker host ("15432:5432")
+	Env       map[string]string `json:"env" yaml:"env"`             // Environment of the service container
+	Readiness *Readiness        `json:"readiness" yaml:"readiness"` // How to tell that the service accepts requests
+}
+
+// validateServices checks that the services of t have unique names and can share a network with it
+func (t *Task) validateServices() error {
+	names := map[string]bool{}
+	for _, service := range t.Services {
+		if service.Name == "" || service.Image == "" {
+			return fmt.Errorf("services of task '%s' need a name and an image", t.Name)
+		}
+		if names[service.Name] {
+			return fmt.Errorf("duplicate service '%s' in task '%s'", service.Name, t.Name)
+		}
+		names[service.Name] = true
+		if _, _, err := nat.ParsePortSpecs(service.Ports); err != nil {
+			return fmt.Errorf("invalid ports of service '%s' in task '%s': %w", service.Name, t.Name, err)
+		}
+		if err := service.Readiness.validate(); err != nil {
+			return fmt.Errorf("invalid readiness of service '%s' in task '%s': %w", service.Name, t.Name, err)
+		}
+	}
+	if len(t.Services) > 0 && t.Network != nil && t.Network.Mode != "" {
+		return fmt.Errorf("task '%s' has services, which run on their own network and cannot be combined with network mode '%s'", t.Name, t.Network.Mode)
+	}
+	return nil
+}
+
+// servicesNetwork returns the name of the network shared by the task container and its services
+func servicesNetwork(containerName string) string {
+	return containerName + "_services"
+}
+
+// serviceContainerName returns the name of a service container. It does not start with the task container
+// prefix, so prune and du do not mistake it for a task container.
+func serviceContainerName(containerName string, service Service) string {
+	return namespaced("buildvault-service_") + strings.TrimPrefix(containerName, containerNamePrefix()) + "_" + service.Name
+}
+
+// sortedServices returns a copy of services sorted by name
+func sortedServices(services []Service) []Service {
+	sorted := append([]Service{}, services...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// serviceEnv returns the environment of a service container in a stable order
+func serviceEnv(service Service) []string {
+	var env []string
+	for name, value := range service.Env {
+		env = append(env, name+"="+value)
+	}
+	sort.Strings(env)
+	return env
+}
+
+// startServices creates the services network and starts the services of t on it. The returned function
+// tears them down again and is also returned, together with the error, if starting fails halfway.
+func (t *Task) startServices(ctx context.Context, cli DockerAPI, containerName string) (func(), error) {
+	networkName := servicesNetwork(containerName)
+	var started []string
+	teardown := func() {
+		ctx := context.WithoutCancel(ctx)
+		for _, id := range started {
+			if err := cli.ContainerRemove(ctx, id, container.RemoveOptions{Force: true}); err != nil {
+				fmt.Printf("Failed to remove service container: %v\n", err)
+			}
+		}
+		if t.containerID != "" {
+			// The task container is preserved, without the network that is removed now
+			cli.NetworkDisconnect(ctx, networkName, t.containerID, true)
+		}
+		if err := cli.NetworkRemove(ctx, networkName); err != nil {
+			fmt.Printf("Failed to remove services network %s: %v\n", networkName, err)
+		}
+	}
+
+	// A network left behind by an interrupted run is reused
+	if _, err := cli.NetworkInspect(ctx, networkName, network.InspectOptions{}); err != nil {
+		_, err := cli.NetworkCreate(ctx, networkName, network.CreateOptions{
+			Labels: withNamespace(map[string]string{labelManaged: "true", labelTask: t.Name}),
+		})
+		if err != nil {
+			return func() {}, fmt.Errorf("error creating services network: %w", err)
+		}
+	}
+
+	for _, service := range t.Services {
+		id, err := t.startService(ctx, cli, networkName, serviceContainerName(containerName, service), service)
+		if id != "" {
+			started = append(started, id)
+		}
+		if err != nil {
+			return teardown, err
+		}
+	}
+	return teardown, nil
+}
+
+func (t *Task) startService(ctx context.Context, cli DockerAPI, networkName, name string, service Service) (string, error) {
+	if err := ensureImage(ctx, cli, service.Image, "", statusWriter{t}); err != nil {
+		return "", err
+	}
+
+	exposed, bindings, _ := nat.ParsePortSpecs(service.Ports)
+	// The name filter matches substrings, which would include services whose name starts with this one
+	if err := cleanUpRunningContainer(ctx, "^/"+name+"$", cli); err != nil {
+		return "", err
+	}
+
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:        service.Image,
+		Env:          serviceEnv(service),
+		ExposedPorts: exposed,
+		Labels:       withNamespace(map[string]string{labelManaged: "true", labelTask: t.Name}),
+	}, &container.HostConfig{
+		NetworkMode:  container.NetworkMode(networkName),
+		PortBindings: bindings,
+	}, &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			networkName: {Aliases: []string{service.Name}},
+		},
+	}, nil, name)
+	if err != nil {
+		return "", fmt.Errorf("error creating service '%s': %w", service.Name, err)
+	}
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return resp.ID, fmt.Errorf("error starting service '%s': %w", service.Name, err)
+	}
+	fmt.Printf("Started service '%s' (%s)\n", service.Name, service.Image)
+	return resp.ID, nil
+}
+
+// waitForServices blocks until all services of t are ready
+func (t *Task) waitForServices(ctx context.Context, cli DockerAPI, containerName string) error {
+	for _, service := range t.Services {
+		if err := waitUntilReady(ctx, cli, serviceContainerName(containerName, service), service.Readiness); err != nil {
+			return fmt.Errorf("service '%s' %w", service.Name, err)
+		}
+		fmt.Printf("Service '%s' is ready\n", service.Name)
+	}
+	return nil
+}
+
+// PrunedNetwork describes a services network selected by PruneNetworks.
+type PrunedNetwork struct {
+	ID       string
+	Name     string
+	TaskName string
+	Created  time.Time
+}
+
+// selectNetworks applies the prune filters to the given services networks
+func selectNetworks(networks []network.Summary, opts PruneOptions, now time.Time) []PrunedNetwork {
+	keep := collectHashes(opts.Keep)
+
+	var candidates []PrunedNetwork
+	for _, n := range networks {
+		if n.Labels[labelManaged] != "true" || !inNamespace(n.Labels) {
+			continue
+		}
+		if opts.TaskName != "" && n.Labels[labelTask] != opts.TaskName {
+			continue
+		}
+		if opts.OlderThan > 0 && now.Sub(n.Created) < opts.OlderThan {
+			continue
+		}
+		if _, hash, ok := parseContainerName(strings.TrimSuffix(n.Name, "_services")); ok && keep[hash] {
+			continue
+		}
+
+		candidates = append(candidates, PrunedNetwork{
+			ID:       n.ID,
+			Name:     n.Name,
+			TaskName: n.Labels[labelTask],
+			Created:  n.Created,
+		})
+	}
+	return candidates
+}
+
+// PruneNetworks removes the services networks left behind by interrupted runs that match the given
+// options and returns the networks it removed (or would remove in a dry run). Networks with connected
+// containers are kept.
+func PruneNetworks(ctx context.Context, cli NetworkAPI, opts PruneOptions) ([]PrunedNetwork, error) {
+	listFilters := filters.NewArgs()
+	listFilters.Add("label", labelManaged+"=true")
+
+	networks, err := cli.NetworkList(ctx, network.ListOptions{Filters: listFilters})
+	if err != nil {
+		return nil, fmt.Errorf("error listing buildvault networks: %w", err)
+	}
+
+	var removed []PrunedNetwork
+	for _, candidate := range selectNetworks(networks, opts, time.Now()) {
+		// Listing does not include the connected containers
+		inspect, err := cli.NetworkInspect(ctx, candidate.ID, network.InspectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error inspecting network %s: %w", candidate.Name, err)
+		}
+		if len(inspect.Containers) > 0 {
+			fmt.Printf("Keeping network %s, it is used by %d container(s)\n", candidate.Name, len(inspect.Containers))
+			continue
+		}
+
+		if !opts.DryRun {
+			fmt.Printf("Removing network %s (task '%s')\n", candidate.Name, candidate.TaskName)
+			if err := cli.NetworkRemove(ctx, candidate.ID); err != nil {
+				return nil, fmt.Errorf("error removing network %s: %w", candidate.Name, err)
+			}
+		}
+		removed = append(removed, candidate)
+	}
+	return removed, nil
+}
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateServices(t *testing.T) {
+	valid := &Task{Name: "integration", Services: []Service{
+		{Name: "postgres", Image: "postgres:16", Ports: []string{"5432"}},
+		{Name: "redis", Image: "redis:7", Ports: []string{"16379:6379"}},
+	}}
+	if err := valid.validateServices(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	for name, task := range map[string]*Task{
+		"no image":  {Name: "integration", Services: []Service{{Name: "db"}}},
+		"duplicate": {Name: "integration", Services: []Service{{Name: "db", Image: "postgres"}, {Name: "db", Image: "mysql"}}},
+		"ports":     {Name: "integration", Services: []Service{{Name: "db", Image: "postgres", Ports: []string{"not-a-port"}}}},
+		"network":   {Name: "integration", Services: []Service{{Name: "db", Image: "postgres"}}, Network: &Network{Mode: NetworkHost}},
+	} {
+		if err := task.validateServices(); err == nil {
+			t.Errorf("Expected an error for %s", name)
+		}
+	}
+}
+
+func TestServiceNames(t *testing.T) {
+	containerName := "buildvault_integration_0123456789ab"
+	name := serviceContainerName(containerName, Service{Name: "postgres"})
+	if name != "buildvault-service_integration_0123456789ab_postgres" {
+		t.Errorf("Unexpected service container name %s", name)
+	}
+	if _, _, ok := parseContainerName(name); ok {
+		t.Errorf("Service containers must not look like task containers")
+	}
+	if !strings.HasPrefix(servicesNetwork(containerName), containerName) {
+		t.Errorf("The services network should be named after the task container")
+	}
+}
+
+func TestServicesChangeTaskHash(t *testing.T) {
+	task := &Task{Name: "integration", BaseImage: "alpine", Services: []Service{{Name: "db", Image: "postgres:15"}}}
+	before := task.generateHash()
+	task.Services[0].Image = "postgres:16"
+	if task.generateHash() == before {
+		t.Errorf("Changing the image of a service should change the task hash")
+	}
+}
+
+func TestParsePipelineServices(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+tasks:
+  - name: integration
+    image: alpine
+    services:
+      - name: db
+        image: postgres:16
+        env:
+          POSTGRES_PASSWORD: test
+        readiness:
+          command: [pg_isready, -U, postgres]
+          interval: 500ms
+          timeout: 30s
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+
+	service := pipeline.Tasks[0].Services[0]
+	if service.Env["POSTGRES_PASSWORD"] != "test" || service.Readiness == nil {
+		t.Fatalf("Unexpected service %+v", service)
+	}
+	if len(service.Readiness.Command) != 3 || service.Readiness.Interval != 500*time.Millisecond || service.Readiness.Timeout != 30*time.Second {
+		t.Errorf("Unexpected readiness %+v", service.Readiness)
+	}
+}
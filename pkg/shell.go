@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+)
+
+// ErrNoShell is returned when a task's base image lacks the shell buildvault needs to run commands.
+var ErrNoShell = errors.New("base image has no shell")
+
+const shellPath = "/bin/sh"
+
+// shellProbes caches probe results by image ID, since many tasks usually share a base image
+var shellProbes = struct {
+	sync.Mutex
+	results map[string]bool
+}{results: map[string]bool{}}
+
+// imageHasShell checks whether /bin/sh exists in an image. The image is probed by creating (but never
+// starting) a container from it and inspecting the path, so images without any binaries work as well.
+func imageHasShell(ctx context.Context, cli DockerAPI, imageID string) (bool, error) {
+	shellProbes.Lock()
+	result, ok := shellProbes.results[imageID]
+	shellProbes.Unlock()
+	if ok {
+		return result, nil
+	}
+
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:  imageID,
+		Cmd:    []string{shellPath}, // Never executed, but required for images without a default command
+		Labels: map[string]string{labelManaged: "true"},
+	}, nil, nil, nil, "")
+	if err != nil {
+		return false, fmt.Errorf("error creating probe container: %w", err)
+	}
+	defer cli.ContainerRemove(context.WithoutCancel(ctx), resp.ID, container.RemoveOptions{Force: true})
+
+	_, err = cli.ContainerStatPath(ctx, resp.ID, shellPath)
+	if err != nil && !errdefs.IsNotFound(err) {
+		return false, fmt.Errorf("error probing image for %s: %w", shellPath, err)
+	}
+	result = err == nil
+
+	shellProbes.Lock()
+	shellProbes.results[imageID] = result
+	shellProbes.Unlock()
+	return result, nil
+}
+
+// checkShell returns ErrNoShell if the base image of the task cannot run its commands. Images without
+// a shell are accepted if the task has a helper binary, which then provides one, or if it runs only raw
+// commands in pause mode.
+func (t *Task) checkShell(ctx context.Context, cli DockerAPI) error {
+	hasShell, err := imageHasShell(ctx, cli, t.imageID)
+	if err != nil {
+		return err
+	}
+	t.noShell = !hasShell
+	if !hasShell && t.Helper == "" {
+		if t.Pause {
+			return t.checkRawArgv()
+		}
+		return fmt.Errorf("%w: image %s of task '%s' does not contain %s, which is needed to keep the container alive, "+
+			"create directories and run commands; use an image with a shell (e.g. a busybox or alpine variant), set a static busybox as helper or run cmd in pause mode",
+			ErrNoShell, t.BaseImage, t.Name, shellPath)
+	}
+	return nil
+}
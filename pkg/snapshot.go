@@ -0,0 +1,190 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	imagetypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/errdefs"
+)
+
+// snapshotRepository is the repository the containers of failed tasks are committed to. The images are
+// tagged, so they outlive the container until they are pruned with PruneSnapshots.
+const snapshotRepository = "buildvault-debug"
+
+// labelSnapshot marks the images of failed task containers
+const labelSnapshot = "buildvault.snapshot"
+
+// snapshotReference returns the image reference of the snapshot of a failed task. Task names may contain
+// characters repository names may not, like the names of pods.
+func snapshotReference(taskName, hash string) string {
+	name := strings.Trim(kubeNameInvalid.ReplaceAllString(strings.ToLower(taskName), "-"), "-")
+	if name == "" {
+		name = "task"
+	}
+	return fmt.Sprintf("%s/%s:%s", namespacedRepository(snapshotRepository), name, hash)
+}
+
+// snapshotFailure commits the container of t after a command failed, preserving the exact filesystem of
+// the failure for a postmortem with docker run. Failing to do so does not hide the command's error.
+func (t *Task) snapshotFailure(ctx context.Context, cli ContainerAPI) {
+	hash := t.generateHash()
+	reference := snapshotReference(t.Name, hash)
+	// The snapshot keeps the entrypoint, keep-alive, user and environment, so it can be started again
+	config := t.containerConfig()
+	config.Labels = withNamespace(map[string]string{
+		labelManaged:  "true",
+		labelTask:     t.Name,
+		labelHash:     hash,
+		labelSnapshot: "true",
+	})
+	_, err := cli.ContainerCommit(context.WithoutCancel(ctx), t.containerID, container.CommitOptions{
+		Reference: reference,
+		Comment:   fmt.Sprintf("Container of task '%s' after a command failed", t.Name),
+		Config:    config,
+	})
+	if err != nil {
+		fmt.Printf("Failed to snapshot the container of task '%s': %v\n", t.Name, err)
+		return
+	}
+	fmt.Printf("Saved the container of the failed task '%s' as image %s\n", t.Name, reference)
+}
+
+// latestSnapshot returns the most recent snapshot image of the task with the given name
+func latestSnapshot(ctx context.Context, cli ImageAPI, taskName string) (imagetypes.Summary, bool, error) {
+	listFilters := filters.NewArgs()
+	listFilters.Add("label", labelSnapshot+"=true")
+	listFilters.Add("label", labelTask+"="+taskName)
+	images, err := cli.ImageList(ctx, imagetypes.ListOptions{Filters: listFilters})
+	if err != nil {
+		return imagetypes.Summary{}, false, fmt.Errorf("error listing snapshot images: %w", err)
+	}
+	var latest imagetypes.Summary
+	found := false
+	for _, image := range images {
+		if inNamespace(image.Labels) && (!found || image.Created > latest.Created) {
+			latest = image
+			found = true
+		}
+	}
+	return latest, found, nil
+}
+
+// DebugSnapshot opens an interactive shell in a new container of the most recent snapshot of t, the
+// filesystem its container had when a command failed, even if that container was pruned since. The
+// container is removed again when the shell exits.
+func (t *Task) DebugSnapshot(ctx context.Context, cli DockerAPI) error {
+	image, ok, err := latestSnapshot(ctx, cli, t.Name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("task '%s' has no snapshot, run it with --snapshot-on-failure first", t.Name)
+	}
+	reference := image.ID
+	if len(image.RepoTags) > 0 {
+		reference = image.RepoTags[0]
+	}
+
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:  image.ID,
+		Labels: withNamespace(map[string]string{labelManaged: "true", labelTask: t.Name}),
+	}, nil, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("error creating container of snapshot %s: %w", reference, err)
+	}
+	defer cli.ContainerRemove(context.WithoutCancel(ctx), resp.ID, container.RemoveOptions{Force: true})
+	if err := cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("error starting container of snapshot %s: %w", reference, err)
+	}
+
+	shell, err := debugShell(ctx, cli, resp.ID)
+	if err != nil {
+		return fmt.Errorf("task '%s': %w", t.Name, err)
+	}
+	fmt.Printf("Opening a shell in snapshot %s of task '%s'\n", reference, t.Name)
+	for idx, cmd := range t.commandLines() {
+		fmt.Printf("  Command %d: %s\n", idx+1, cmd)
+	}
+	return runShell(ctx, cli, resp.ID, shell, t.containerUser(), t.workDir())
+}
+
+// PrunedImage describes a snapshot image selected by PruneSnapshots.
+type PrunedImage struct {
+	ID       string
+	Ref      string
+	TaskName string
+	Created  time.Time
+}
+
+// selectSnapshots applies the prune filters to the given snapshot images
+func selectSnapshots(images []imagetypes.Summary, opts PruneOptions, now time.Time) []PrunedImage {
+	keep := collectHashes(opts.Keep)
+
+	var candidates []PrunedImage
+	for _, image := range images {
+		if image.Labels[labelManaged] != "true" || image.Labels[labelSnapshot] != "true" || !inNamespace(image.Labels) {
+			continue
+		}
+		created := time.Unix(image.Created, 0)
+
+		if opts.TaskName != "" && image.Labels[labelTask] != opts.TaskName {
+			continue
+		}
+		if opts.OlderThan > 0 && now.Sub(created) < opts.OlderThan {
+			continue
+		}
+		if keep[image.Labels[labelHash]] {
+			continue
+		}
+
+		ref := image.ID
+		if len(image.RepoTags) > 0 {
+			ref = image.RepoTags[0]
+		}
+		candidates = append(candidates, PrunedImage{
+			ID:       image.ID,
+			Ref:      ref,
+			TaskName: image.Labels[labelTask],
+			Created:  created,
+		})
+	}
+	return candidates
+}
+
+// PruneSnapshots removes the snapshot images of failed task containers matching the given options and
+// returns the images it removed (or would remove in a dry run).
+func PruneSnapshots(ctx context.Context, cli ImageAPI, opts PruneOptions) ([]PrunedImage, error) {
+	listFilters := filters.NewArgs()
+	listFilters.Add("label", labelManaged+"=true")
+	listFilters.Add("label", labelSnapshot+"=true")
+
+	images, err := cli.ImageList(ctx, imagetypes.ListOptions{Filters: listFilters})
+	if err != nil {
+		return nil, fmt.Errorf("error listing snapshot images: %w", err)
+	}
+
+	candidates := selectSnapshots(images, opts, time.Now())
+	if opts.DryRun {
+		return candidates, nil
+	}
+
+	var removed []PrunedImage
+	for _, candidate := range candidates {
+		fmt.Printf("Removing snapshot %s (task '%s')\n", candidate.Ref, candidate.TaskName)
+		// Containers started from the image for a postmortem keep it alive
+		if _, err := cli.ImageRemove(ctx, candidate.ID, imagetypes.RemoveOptions{PruneChildren: true}); err != nil {
+			if errdefs.IsConflict(err) {
+				fmt.Printf("Keeping snapshot %s, it is used by a container\n", candidate.Ref)
+				continue
+			}
+			return nil, fmt.Errorf("error removing snapshot %s: %w", candidate.Ref, err)
+		}
+		removed = append(removed, candidate)
+	}
+	return removed, nil
+}
@@ -0,0 +1,56 @@
+package pkg
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+)
+
+func TestSnapshotReference(t *testing.T) {
+	for name, want := range map[string]string{
+		"Build-Docs":     "buildvault-debug/build-docs:0123456789ab",
+		"Build Docs+x@1": "buildvault-debug/build-docs-x-1:0123456789ab",
+		"_":              "buildvault-debug/task:0123456789ab",
+	} {
+		if reference := snapshotReference(name, "0123456789ab"); reference != want {
+			t.Errorf("Expected snapshot reference %s for %q, got %s", want, name, reference)
+		}
+	}
+}
+
+func TestSnapshotOnFailure(t *testing.T) {
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:3.20"}, Architecture: "amd64", Files: map[string]string{"/bin/sh": ""}})
+	cli.Exec = func(e *dockertest.Exec) int {
+		if e.Cmd[len(e.Cmd)-1] == "false" {
+			return 1
+		}
+		return dockertest.Builtins(e)
+	}
+	ctx := context.Background()
+
+	passing := &Task{Name: "Passing Task", BaseImage: "alpine:3.20", Commands: []string{"true"}, SnapshotOnFailure: true}
+	if err := passing.Execute(ctx, cli); err != nil {
+		t.Fatalf("Failed to execute passing task: %v", err)
+	}
+	if _, ok, err := latestSnapshot(ctx, cli, passing.Name); err != nil || ok {
+		t.Errorf("Expected no snapshot of a passing task, got ok=%v err=%v", ok, err)
+	}
+
+	failing := &Task{Name: "Failing Task", BaseImage: "alpine:3.20", Commands: []string{"false"}, SnapshotOnFailure: true}
+	if err := failing.Execute(ctx, cli); err == nil {
+		t.Fatal("Expected the failing task to fail")
+	}
+	snapshot, ok, err := latestSnapshot(ctx, cli, failing.Name)
+	if err != nil || !ok {
+		t.Fatalf("Expected a snapshot of the failing task, got ok=%v err=%v", ok, err)
+	}
+	if reference := snapshotReference(failing.Name, failing.generateHash()); !slices.Equal(snapshot.RepoTags, []string{reference}) {
+		t.Errorf("Expected the snapshot to be tagged %s, got %v", reference, snapshot.RepoTags)
+	}
+	if snapshot.Labels[labelHash] != failing.generateHash() {
+		t.Errorf("Unexpected snapshot labels %v", snapshot.Labels)
+	}
+}
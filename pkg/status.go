@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"context"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// TaskStatus is the state of a task between runs, like git status is for files: whether it is cached,
+// how its last run went and whether its container is still around.
+type TaskStatus struct {
+	PlanStep                // What running the task now would do
+	Container      string   // Preserved container of the current hash, empty if pruned or never created
+	ContainerState string   // State of the container, like exited
+	Pruned         bool     // The last run of the task created a container which no longer exists
+	LastRun        *TaskRun // Most recent recorded run of the task, nil if it was never recorded
+}
+
+// Status reports the state of every task the targets of p need, in execution order, without executing
+// anything. history may be nil to leave out the last runs.
+func (p *Pipeline) Status(ctx context.Context, cli ContainerAPI, history *History) ([]TaskStatus, error) {
+	steps, err := PlanTasks(ctx, p.Targets())
+	if err != nil {
+		return nil, err
+	}
+	containers, err := listBuildvaultContainers(ctx, cli, false)
+	if err != nil {
+		return nil, err
+	}
+	lastRuns := map[string]*TaskRun{}
+	if history != nil {
+		for _, step := range steps {
+			runs, err := history.TaskRuns(step.Task, 1)
+			if err != nil {
+				return nil, err
+			}
+			if len(runs) > 0 {
+				lastRuns[step.Task] = &runs[0]
+			}
+		}
+	}
+	return taskStatuses(steps, containers, lastRuns), nil
+}
+
+// taskStatuses combines the plan steps of tasks with their containers and last runs
+func taskStatuses(steps []PlanStep, containers []container.Summary, lastRuns map[string]*TaskRun) []TaskStatus {
+	type taskHash struct{ task, hash string }
+	byHash := map[taskHash]container.Summary{}
+	byID := map[string]bool{}
+	for _, c := range containers {
+		byID[c.ID] = true
+		if taskName, hash, ok := taskContainer(c); ok && len(c.Names) > 0 {
+			byHash[taskHash{taskName, hash}] = c
+		}
+	}
+
+	var statuses []TaskStatus
+	for _, step := range steps {
+		status := TaskStatus{PlanStep: step, LastRun: lastRuns[step.Task]}
+		if step.Hash != "" && !step.External {
+			if c, ok := byHash[taskHash{step.Task, step.Hash}]; ok {
+				status.Container = strings.TrimPrefix(c.Names[0], "/")
+				status.ContainerState = c.State
+			}
+		}
+		if status.LastRun != nil && status.LastRun.ContainerID != "" && !step.External {
+			status.Pruned = !byID[status.LastRun.ContainerID]
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -0,0 +1,37 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestTaskStatuses(t *testing.T) {
+	steps := []PlanStep{
+		{Task: "deps", Hash: "aaa", CacheHit: true},
+		{Task: "build", Hash: "bbb", Reason: "its outputs are not in the artifact store"},
+		{Task: "test", Reason: "the hash of its dependency 'build' is not known yet"},
+	}
+	containers := []container.Summary{
+		{ID: "c1", Names: []string{"/buildvault_deps_aaa"}, State: "exited"},
+		{ID: "c2", Names: []string{"/buildvault_build_old"}, State: "exited"},
+	}
+	lastRuns := map[string]*TaskRun{
+		"deps":  {RunID: 2, TaskRecord: TaskRecord{Name: "deps", Status: StatusExecuted, ContainerID: "c1"}},
+		"build": {RunID: 2, TaskRecord: TaskRecord{Name: "build", Status: StatusFailed, ContainerID: "c3"}},
+	}
+
+	statuses := taskStatuses(steps, containers, lastRuns)
+	if len(statuses) != 3 {
+		t.Fatalf("Expected 3 statuses, got %d", len(statuses))
+	}
+	if deps := statuses[0]; deps.Container != "buildvault_deps_aaa" || deps.ContainerState != "exited" || deps.Pruned {
+		t.Errorf("Unexpected status of deps %+v", deps)
+	}
+	if build := statuses[1]; build.Container != "" || !build.Pruned || build.LastRun.Status != StatusFailed {
+		t.Errorf("Unexpected status of build %+v", build)
+	}
+	if test := statuses[2]; test.Container != "" || test.Pruned || test.LastRun != nil {
+		t.Errorf("Unexpected status of test %+v", test)
+	}
+}
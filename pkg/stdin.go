@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Host programs can pipe data into commands, e.g. a generated manifest into kubectl apply -f -. The
+// data is not part of the task hash, so tasks with stdin always execute instead of using stored outputs.
+// Readers are consumed by the execution, a task executed again needs new ones.
+
+// validateStdin checks that every stdin of t belongs to a command that runs in its own exec
+func (t *Task) validateStdin() error {
+	if len(t.Stdin) == 0 {
+		return nil
+	}
+	if t.BatchCommands {
+		return fmt.Errorf("task '%s' batches its commands into one exec, which cannot pipe stdin into a single command", t.Name)
+	}
+	commands := len(t.commandLines())
+	if t.Script != "" {
+		commands = 1
+	}
+	for idx := range t.Stdin {
+		if idx < 0 || idx >= commands {
+			return fmt.Errorf("task '%s' has stdin for command %d, but only %d commands", t.Name, idx+1, commands)
+		}
+	}
+	return nil
+}
+
+// pipeStdin copies stdin into an attached exec and then closes its stdin, so the command reads EOF. The
+// returned channel yields the error reading stdin once copying stopped. Failing writes are no error,
+// the command may exit without reading all of its input.
+func pipeStdin(attach types.HijackedResponse, stdin io.Reader) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		source := &stdinSource{Reader: stdin}
+		if _, err := io.Copy(attach.Conn, source); err == nil {
+			attach.CloseWrite()
+		}
+		done <- source.err
+	}()
+	return done
+}
+
+// waitForStdin waits until pipeStdin stopped after the exec finished, nil done meaning no stdin
+func waitForStdin(attach types.HijackedResponse, done <-chan error) error {
+	if done == nil {
+		return nil
+	}
+	// Unblocks writes into the exec if the command exited without reading all of stdin
+	attach.Close()
+	return <-done
+}
+
+// stdinSource records the error reading stdin, to tell it apart from errors writing into the exec
+type stdinSource struct {
+	io.Reader
+	err error
+}
+
+func (s *stdinSource) Read(p []byte) (int, error) {
+	n, err := s.Reader.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.err = err
+	}
+	return n, err
+}
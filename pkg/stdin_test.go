@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestValidateStdin(t *testing.T) {
+	task := &Task{Name: "deploy", Commands: []string{"envsubst < /app.yaml", "kubectl apply -f -"}, Stdin: map[int]io.Reader{1: strings.NewReader("kind: Pod")}}
+	if err := task.validateStdin(); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	task.Stdin = map[int]io.Reader{2: strings.NewReader("kind: Pod")}
+	if err := task.validateStdin(); err == nil {
+		t.Error("Expected stdin for a missing command to be rejected")
+	}
+
+	task.Stdin = map[int]io.Reader{0: strings.NewReader("kind: Pod")}
+	task.BatchCommands = true
+	if err := task.validateStdin(); err == nil {
+		t.Error("Expected stdin of batched commands to be rejected")
+	}
+
+	script := &Task{Name: "deploy", Script: "kubectl apply -f -", Stdin: map[int]io.Reader{0: strings.NewReader("kind: Pod")}}
+	if err := script.validateStdin(); err != nil {
+		t.Errorf("Expected stdin of the script to be accepted, got %v", err)
+	}
+}
+
+func TestPipeStdin(t *testing.T) {
+	exec, daemon := net.Pipe()
+	attach := types.HijackedResponse{Conn: exec, Reader: bufio.NewReader(exec)}
+	done := pipeStdin(attach, strings.NewReader("kind: Pod\n"))
+
+	data, _ := io.ReadAll(io.LimitReader(daemon, int64(len("kind: Pod\n"))))
+	if string(data) != "kind: Pod\n" {
+		t.Errorf("Expected the stdin data, got %q", data)
+	}
+	if err := waitForStdin(attach, done); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	// A command exiting without reading stdin is no error, a failing source is
+	exec, daemon = net.Pipe()
+	daemon.Close()
+	attach = types.HijackedResponse{Conn: exec, Reader: bufio.NewReader(exec)}
+	if err := waitForStdin(attach, pipeStdin(attach, strings.NewReader("unread"))); err != nil {
+		t.Errorf("Expected unread stdin to be ignored, got %v", err)
+	}
+
+	exec, daemon = net.Pipe()
+	go io.Copy(io.Discard, daemon)
+	attach = types.HijackedResponse{Conn: exec}
+	failure := errors.New("generator failed")
+	if err := <-pipeStdin(attach, io.MultiReader(strings.NewReader("kind:"), &failingReader{failure})); !errors.Is(err, failure) {
+		t.Errorf("Expected the error of the source, got %v", err)
+	}
+}
+
+type failingReader struct{ err error }
+
+func (r *failingReader) Read([]byte) (int, error) { return 0, r.err }
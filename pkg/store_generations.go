@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Every task hash saved in the store is a generation of its task. Pinned generations are kept when
+// generations are invalidated; pins are local to the store and are not shared through a remote cache.
+//
+//	<dir>/pins/<hash>  marks the generation of hash as pinned
+
+// StoreGeneration is a task hash saved in the artifact store.
+type StoreGeneration struct {
+	Task      string
+	Hash      string
+	Created   time.Time
+	Size      int64 // Total size of the stored blobs, shared blobs included
+	Pinned    bool
+	Artifacts []StoredArtifact
+}
+
+func (s *ArtifactStore) pinPath(hash string) string {
+	return filepath.Join(s.dir, "pins", hash)
+}
+
+// Generations lists the generations of the local store by task name, the newest generation first.
+func (s *ArtifactStore) Generations() ([]StoreGeneration, error) {
+	manifests, err := s.manifests()
+	if err != nil {
+		return nil, err
+	}
+
+	var generations []StoreGeneration
+	for _, manifest := range manifests {
+		generation := StoreGeneration{
+			Task:      manifest.Task,
+			Hash:      manifest.Hash,
+			Created:   manifest.Created,
+			Artifacts: manifest.Artifacts,
+		}
+		for _, artifact := range manifest.Artifacts {
+			generation.Size += artifact.Size
+		}
+		if _, err := os.Stat(s.pinPath(manifest.Hash)); err == nil {
+			generation.Pinned = true
+		}
+		generations = append(generations, generation)
+	}
+
+	sort.Slice(generations, func(i, j int) bool {
+		a, b := generations[i], generations[j]
+		if a.Task != b.Task {
+			return a.Task < b.Task
+		}
+		return a.Created.After(b.Created)
+	})
+	return generations, nil
+}
+
+// manifests reads all manifests of the local store
+func (s *ArtifactStore) manifests() ([]*StoreManifest, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "tasks", "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing store manifests: %w", err)
+	}
+
+	var manifests []*StoreManifest
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("error reading store manifest: %w", err)
+		}
+		manifest, err := parseStoreManifest(data)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, manifest)
+	}
+	return manifests, nil
+}
+
+// Pin marks the generation of hash as pinned, or removes the mark.
+func (s *ArtifactStore) Pin(hash string, pinned bool) error {
+	if !pinned {
+		if err := os.Remove(s.pinPath(hash)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("error unpinning %s: %w", hash, err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(s.dir, "pins"), 0o755); err != nil {
+		return fmt.Errorf("error pinning %s: %w", hash, err)
+	}
+	if err := os.WriteFile(s.pinPath(hash), nil, 0o644); err != nil {
+		return fmt.Errorf("error pinning %s: %w", hash, err)
+	}
+	return nil
+}
+
+// Invalidate removes the generation of hash from the local store, so its task executes again, along
+// with the blobs no other generation uses. Pinned generations cannot be invalidated. Blobs of a run
+// saving to the store at the same time may be removed as well.
+func (s *ArtifactStore) Invalidate(hash string) error {
+	if _, err := os.Stat(s.pinPath(hash)); err == nil {
+		return fmt.Errorf("generation %s is pinned", hash)
+	}
+	if err := os.Remove(s.manifestPath(hash)); err != nil {
+		return fmt.Errorf("error invalidating %s: %w", hash, err)
+	}
+	return s.removeUnusedBlobs()
+}
+
+// removeUnusedBlobs removes the blobs no manifest refers to
+func (s *ArtifactStore) removeUnusedBlobs() error {
+	manifests, err := s.manifests()
+	if err != nil {
+		return err
+	}
+	used := map[string]bool{}
+	for _, manifest := range manifests {
+		for _, artifact := range manifest.Artifacts {
+			used[artifact.Digest] = true
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.dir, "blobs", "sha256"))
+	if err != nil {
+		return fmt.Errorf("error listing store blobs: %w", err)
+	}
+	for _, entry := range entries {
+		if used[entry.Name()] || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		if err := os.Remove(s.blobPath(entry.Name())); err != nil {
+			return fmt.Errorf("error removing blob %s: %w", entry.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package pkg
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// suggestionIgnoredPaths are scratch and cache locations that never make good artifacts
+var suggestionIgnoredPaths = []string{
+	"/tmp",
+	"/var/tmp",
+	"/var/cache",
+	"/var/log",
+	"/var/lib/apk",
+	"/var/lib/apt",
+	"/var/lib/dpkg",
+	"/run",
+	"/dev",
+	"/proc",
+	"/sys",
+	"/root/.cache",
+	"/etc",
+	internalDir,
+}
+
+// ArtifactSuggestion is a path created by a task that could be declared as one of its outputs.
+type ArtifactSuggestion struct {
+	Path string // Absolute path inside the task container
+	Size int64  // Total size in bytes of the new files below Path
+}
+
+// isUnderPath reports whether p equals or is located below base
+func isUnderPath(p, base string) bool {
+	base = strings.TrimSuffix(base, "/")
+	return p == base || strings.HasPrefix(p, base+"/")
+}
+
+func isUnderAnyPath(p string, bases []string) bool {
+	for _, base := range bases {
+		if isUnderPath(p, base) {
+			return true
+		}
+	}
+	return false
+}
+
+// containerDiff returns the filesystem changes of a container compared to its image
+func containerDiff(ctx context.Context, cli ContainerAPI, containerID string) ([]container.FilesystemChange, error) {
+	changes, err := cli.ContainerDiff(ctx, containerID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting filesystem diff of container: %w", err)
+	}
+	return changes, nil
+}
+
+// suggestionRoots returns the added paths whose parent directory was not added as well,
+// skipping ignored locations and paths that are already known to the task.
+func suggestionRoots(changes []container.FilesystemChange, excluded []string) (roots []string) {
+	var added []string
+	addedSet := map[string]bool{}
+	for _, change := range changes {
+		if change.Kind != container.ChangeAdd {
+			continue
+		}
+		if isUnderAnyPath(change.Path, suggestionIgnoredPaths) || isUnderAnyPath(change.Path, excluded) {
+			continue
+		}
+		addedSet[change.Path] = true
+		added = append(added, change.Path)
+	}
+
+	for _, p := range added {
+		if !addedSet[path.Dir(p)] {
+			roots = append(roots, p)
+		}
+	}
+	return roots
+}
+
+// sizeOfPath returns the total size of the regular files at or below the added path p in a container,
+// except those below excluded. Everything below an added directory is new, so its size is summed up from
+// the headers of a single archive of it instead of one stat per file.
+func sizeOfPath(ctx context.Context, cli ContainerFileAPI, containerID, p string, excluded []string) (int64, error) {
+	reader, stat, err := cli.CopyFromContainer(ctx, containerID, p)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	if !stat.Mode.IsDir() {
+		if stat.Mode.IsRegular() {
+			return stat.Size, nil
+		}
+		return 0, nil
+	}
+
+	var size int64
+	archive := tar.NewReader(reader)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return size, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		name := path.Join(path.Dir(p), header.Name)
+		if header.Typeflag == tar.TypeReg && !isUnderAnyPath(name, excluded) && !isUnderAnyPath(name, suggestionIgnoredPaths) {
+			size += header.Size
+		}
+	}
+}
+
+// SuggestArtifacts inspects the preserved container of an executed task and proposes the largest
+// newly created files and directories as output declarations. At most limit suggestions are returned.
+func SuggestArtifacts(ctx context.Context, cli DockerAPI, t *Task, limit int) ([]ArtifactSuggestion, error) {
+	if t.Virtual || t.Container != "" {
+		return nil, nil
+	}
+
+	containers, err := listTaskContainers(ctx, cli, t.Name, t.generateHash())
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("no container found for task '%s', run it first", t.Name)
+	}
+	containerID := containers[0].ID
+
+	changes, err := containerDiff(ctx, cli, containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Files copied in from dependencies are inputs, and declared outputs need no suggestion
+	excluded := t.declaredOutputs()
+	for _, dependency := range t.Dependencies {
+		for _, artifact := range dependency.Artifacts {
+			excluded = append(excluded, artifact.To)
+		}
+	}
+
+	roots := suggestionRoots(changes, excluded)
+	sizes := map[string]int64{}
+	for _, root := range roots {
+		size, err := sizeOfPath(ctx, cli, containerID, root, excluded)
+		if err != nil {
+			return nil, fmt.Errorf("error inspecting %s in task container: %w", root, err)
+		}
+		sizes[root] = size
+	}
+
+	var suggestions []ArtifactSuggestion
+	for _, root := range roots {
+		if sizes[root] > 0 {
+			suggestions = append(suggestions, ArtifactSuggestion{Path: root, Size: sizes[root]})
+		}
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Size > suggestions[j].Size
+	})
+	if limit > 0 && len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestSuggestionRoots(t *testing.T) {
+	changes := []container.FilesystemChange{
+		{Kind: container.ChangeAdd, Path: "/output"},
+		{Kind: container.ChangeAdd, Path: "/output/app"},
+		{Kind: container.ChangeAdd, Path: "/output/lib"},
+		{Kind: container.ChangeAdd, Path: "/output/lib/a.so"},
+		{Kind: container.ChangeModify, Path: "/usr/local/bin"},
+		{Kind: container.ChangeAdd, Path: "/usr/local/bin/tool"},
+		{Kind: container.ChangeAdd, Path: "/tmp/scratch"},
+		{Kind: container.ChangeAdd, Path: "/input"},
+		{Kind: container.ChangeAdd, Path: "/input/data.txt"},
+	}
+
+	roots := suggestionRoots(changes, []string{"/input/data.txt"})
+
+	wantRoots := []string{"/output", "/usr/local/bin/tool", "/input"}
+	if len(roots) != len(wantRoots) {
+		t.Fatalf("Expected roots %v, got %v", wantRoots, roots)
+	}
+	for i := range wantRoots {
+		if roots[i] != wantRoots[i] {
+			t.Errorf("Expected roots %v, got %v", wantRoots, roots)
+			break
+		}
+	}
+}
+
+// statCounter counts the stat round trips to the daemon
+type statCounter struct {
+	*dockertest.Fake
+	stats int
+}
+
+func (c *statCounter) ContainerStatPath(ctx context.Context, containerID, path string) (container.PathStat, error) {
+	c.stats++
+	return c.Fake.ContainerStatPath(ctx, containerID, path)
+}
+
+func TestSuggestArtifacts(t *testing.T) {
+	fake := dockertest.New()
+	fake.AddImage(dockertest.Image{Tags: []string{"alpine:3.20"}, Files: map[string]string{"/bin/sh": ""}})
+	fake.Exec = func(e *dockertest.Exec) int {
+		if e.Cmd[len(e.Cmd)-1] == "build" {
+			for name, size := range map[string]int{"/output/app": 300, "/output/lib/a.so": 200, "/output/in/app": 1000, "/dist/tool": 50, "/tmp/scratch": 5000} {
+				e.Container.WriteFile(name, make([]byte, size), 0o755)
+			}
+		}
+		return dockertest.Builtins(e)
+	}
+	cli := &statCounter{Fake: fake}
+	build := &Task{Name: "build", BaseImage: "alpine:3.20", Commands: []string{"build"}}
+	task := &Task{Name: "suggest", BaseImage: "alpine:3.20", Commands: []string{"build"},
+		Dependencies: []Dependency{{Task: build, Artifacts: []Artifact{{From: "/output/app", To: "/output/in/app"}}}}}
+	if err := build.Execute(context.Background(), cli); err != nil {
+		t.Fatalf("Failed to execute tasks: %v", err)
+	}
+	if err := task.Execute(context.Background(), cli); err != nil {
+		t.Fatalf("Failed to execute tasks: %v", err)
+	}
+
+	cli.stats = 0
+	suggestions, err := SuggestArtifacts(context.Background(), cli, task, 0)
+	if err != nil {
+		t.Fatalf("Failed to suggest artifacts: %v", err)
+	}
+	want := []ArtifactSuggestion{{Path: "/output", Size: 500}, {Path: "/dist", Size: 50}}
+	if !reflect.DeepEqual(suggestions, want) {
+		t.Errorf("Expected suggestions %+v, got %+v", want, suggestions)
+	}
+	if cli.stats != 0 {
+		t.Errorf("Expected the sizes to come from archives instead of %d stats", cli.stats)
+	}
+}
@@ -5,29 +5,116 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
 	imagetypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/pkg/stdcopy"
 	"io"
 	"os"
+	"path"
 	"slices"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Task represents a container-based task with a base image and a set of commands to run.
 type Task struct {
-	Name         string       // Name of the task (used for container identification)
-	BaseImage    string       // Base Docker image to use
-	Commands     []string     // Slice of commands to execute inside the container
-	Dependencies []Dependency // Map of task name to file patterns to copy from that task
-	containerID  string       // id of the docker container
+	Name              string            // Name of the task (used for container identification)
+	BaseImage         string            // Base Docker image to use
+	Virtual           bool              // No image and commands, only re-exports the artifacts of its dependencies
+	Container         string            // Name or ID of an existing container not managed by buildvault, see ExternalContainer
+	Build             *ImageBuild       // Optional Dockerfile build producing the base image instead of BaseImage
+	Commands          []string          // Slice of commands to execute inside the container
+	Shell             []string          // Shell running each command, which is appended as last argument; sh -c by default
+	Cmd               [][]string        // Raw commands executed without a shell, instead of Commands, e.g. for distroless images
+	Script            string            // Shell script run in a single exec instead of Commands, stopping at the first failure
+	Dependencies      []Dependency      // Map of task name to file patterns to copy from that task
+	HashInputs        []string          // Host paths, or destination paths of dependency artifacts, whose content is part of the hash
+	Outputs           []string          // Paths the task is expected to produce, verified after the commands ran
+	NamedOutputs      map[string]string // Outputs dependents can reference by name instead of by path, see Output
+	ArtifactStore     *ArtifactStore    // Optional host store for outputs; tasks found in it are not executed again
+	OutputMux         *OutputMux        // Optional multiplexer for command output of tasks running at the same time
+	Events            EventSink         // Optional receiver of structured progress events, see Event
+	BatchCommands     bool              // Run all commands with a single exec of an uploaded script, for long command lists
+	Helper            string            // Optional host path of a static helper binary (busybox or buildvault-helper) for minimal images
+	Secrets           []Secret          // Values provided to the commands as environment variables or files, masked in their output
+	Mounts            []Mount           // Host directories, named volumes and tmpfs mounted into the container, e.g. package caches
+	CacheDirs         []string          // Container paths backed by a volume managed by buildvault, reused by every run of the task
+	Exports           []Export          // Artifacts copied to host paths after the task ran
+	Network           *Network          // Optional network mode, extra hosts and DNS servers of the container
+	ReadOnlyArtifacts bool              // Treat all dependency artifacts as read-only, see Artifact.ReadOnly
+	Services          []Service         // Containers started on a network shared with the task while its commands run
+	ArtifactConflicts ConflictPolicy    // What to do with dependency artifacts copied to overlapping paths, merge-dirs by default
+	User              string            // User or UID[:GID] the container and its commands run as, the image's user by default
+	HostUser          bool              // Run as the UID and GID of the host user, so files written to bind mounts belong to them
+	Inherit           *ImageInheritance // Which of ENV, USER and WORKDIR of the base image apply, all of them if nil
+	Stage             string            // Stage the task is grouped under in run reports, e.g. build or test
+	SnapshotOnFailure bool              // Commit the container to a buildvault-debug image when a command fails
+	ImageMirrors      []string          // Registries to pull BaseImage from, in order, before its own registry
+	OutputImage       string            // Image reference the container is committed to after the commands succeed
+	Push              bool              // Push OutputImage to its registry once it is committed
+	Freshness         time.Duration     // Stored results older than this execute again despite an identical hash, 0 for no limit
+	Prelude           string            // Shell code run before every command and the script, e.g. set -euo pipefail
+	When              Condition         // Runs the task only if it holds, nil to always run it
+	AllowFailure      bool              // A failure of the task does not fail the run, but tasks depending on it fail
+	Vars              map[string]string // Values of the ${{ vars.NAME }} references in its images, commands and paths
+	Cleanup           CleanupPolicy     // Whether the container is preserved after the run, keep-always by default
+	Platform          string            // Platform of the image and container like linux/arm64, empty for the daemon's own
+	ManifestList      string            // Virtual tasks only: image to push a manifest list of their dependencies' pushed images as
+	Devices           []string          // Host devices made available in the container like /dev/fuse or /dev/sdb:/dev/xvdc:r
+	GPUs              string            // GPUs requested like docker run --gpus: all, a count or device=0
+	Privileged        bool              // Runs the container privileged, e.g. to build images inside it
+	CapAdd            []string          // Linux capabilities added to the container like SYS_ADMIN
+	CapDrop           []string          // Linux capabilities dropped from the container, ALL for every one
+	Seccomp           string            // Path of a Seccomp profile or unconfined, Docker's default profile if empty
+	AppArmor          string            // Name of a loaded AppArmor profile or unconfined, Docker's default if empty
+	ReadOnlyRootfs    bool              // Runs the container with a read-only root filesystem, see WritablePaths
+	WritablePaths     []string          // Paths writable despite ReadOnlyRootfs, besides mounts and cache directories
+	Readiness         *Readiness        // Checked after the container started and before the first command, e.g. for slow entrypoints
+	Entrypoint        []string          // Overrides the entrypoint of the image, empty but not nil to reset it
+	KeepAlive         []string          // Main process of the container instead of a sleep, passed to the entrypoint
+	Pause             bool              // Keep the container alive with the embedded pause binary, so images without a shell can run Cmd
+	Stdin             map[int]io.Reader // Data piped into the command at the index, or the script at 0, e.g. for kubectl apply -f -
+	CaptureOutput     bool              // Keep the stdout of every command in its CommandResult, e.g. for a version number
+	Stdout            io.Writer         // Standard output of the commands instead of the run's, e.g. a LineWriter
+	Stderr            io.Writer         // Standard error of the commands instead of the run's
+	Git               []GitSource       // Repositories checked out into the container before the commands run
+	Downloads         []Download        // Files fetched over HTTP into the container before the commands run, verified by checksum
+	containerID       string            // id of the docker container
+	imageID           string            // ID of the base image once it is available locally
+	imageEnv          []string          // ENV of the base image once it is available locally
+	imageSource       string            // reference the base image was pulled from during this run, if it was pulled
+	inputDigests      map[string]string // content digests of HashInputs once resolved
+	helperDigest      string            // content digest of the helper binary once resolved
+	gitCommits        map[string]string // commits the Git sources resolved to, by path
+	outputDigests     map[string]string // artifact digests of Outputs computed in the container
+	storedOutputs     string            // digests of the stored outputs of a task with Freshness, see recordStoredOutputs
+	outputSizes       map[string]int64  // total sizes of the files of Outputs computed in the container
+	noShell           bool              // the base image has no /bin/sh, commands run through the helper
+	cacheHit          bool              // the outputs were found in the artifact store, so the task was not executed
+	readOnlyDigests   map[string]string // digests of the read-only artifacts as copied, by destination path
+	completed         bool              // Execute succeeded during this run
+	varsResolved      bool              // resolveVars replaced the variable references, tasks of later waves only read them
+	outputsResolved   bool              // resolveOutputRefs replaced the output references of the dependencies
+	skipped           bool              // The condition of the task or of one of its dependencies did not hold
+	allowedFailure    bool              // The task failed, which AllowFailure tolerated
+	started           time.Time         // when the task's own work began, after its dependencies
+	duration          time.Duration     // time the task's own work took
+	commandLog        *taskLog          // command output kept for the run report
+	commandResults    []CommandResult   // exit codes and durations of the commands that ran
+	pushedImage       string            // OutputImage with the digest it was pushed as during this run
+	pipelineID        string            // ID of the pipeline file the task was loaded from, see Pipeline.ID
+	seccompProfile    string            // content of the Seccomp profile file once loaded
+	options           executeOptions    // settings of the current call of Execute
 }
 
 type Artifact struct {
-	From string `json:"from"`
-	To   string `json:"to"`
+	From     string `json:"from" yaml:"from"`
+	Output   string `json:"output,omitempty" yaml:"output"` // Named output of the dependency, resolved into From
+	To       string `json:"to" yaml:"to"`
+	ReadOnly bool   `json:"read_only,omitempty" yaml:"read_only"` // Fail the task if a command modifies the artifact
 }
 
 type Dependency struct {
@@ -38,33 +125,121 @@ type Dependency struct {
 // generateContainerName creates a deterministic name for the task container
 func (t *Task) generateContainerName() string {
 	hash := t.generateHash()
-	return fmt.Sprintf("buildvault_%s_%s", t.Name, hash)
+	return fmt.Sprintf("%s%s_%s", containerNamePrefix(), t.Name, hash)
 }
 
 func (t *Task) generateHash() string {
 	// Create a hash based on task name, image, and commands for uniqueness
 	hasher := sha256.New()
-	hasher.Write([]byte(t.Name))
-	hasher.Write([]byte(t.BaseImage))
+	for _, part := range t.hashParts() {
+		hasher.Write(part.data)
+	}
+	hash := hex.EncodeToString(hasher.Sum(nil))[:12]
+	return hash
+}
 
-	// Include commands in the hash
+// hashParts returns what the hash of t is computed from, in order. Every part is named after the
+// component of the task it covers, so CacheKey can tell which of them changed.
+func (t *Task) hashParts() hashParts {
+	var parts hashParts
+	parts.addHashVersion()
+	parts.add("name", []byte(t.Name))
+	if t.Container != "" {
+		// Only the identity of an external container is known, not how its files came about
+		parts.add("container", []byte(t.containerID))
+	} else if t.Build != nil {
+		// The tag of a built image never changes, its digest does on every rebuild
+		parts.add("image", []byte(t.imageID))
+	} else {
+		parts.add("image", []byte(t.BaseImage))
+	}
+	// The same image reference resolves to a different image per platform
+	if t.Platform != "" {
+		parts.add("platform", []byte("platform\x00"+t.Platform))
+	}
+
+	// Include commands in the hash, each of them is explained on its own
 	commandsJSON, _ := json.Marshal(t.Commands)
-	hasher.Write(commandsJSON)
+	var commands []hashPart
+	for i, command := range t.Commands {
+		commands = append(commands, hashPart{name: fmt.Sprintf("command #%d", i+1), data: []byte(command)})
+	}
+	parts.add("commands", commandsJSON, commands...)
+	if len(t.Shell) > 0 || len(t.Cmd) > 0 || t.Script != "" {
+		shellJSON, _ := json.Marshal([]any{t.Shell, t.Cmd, t.Script})
+		parts.add("shell, cmd and script", shellJSON)
+	}
+	if t.Prelude != "" {
+		parts.add("prelude", []byte("prelude\x00"+t.Prelude))
+	}
+	// The entrypoint runs before the commands and may prepare the container, the keep-alive only waits
+	if t.Entrypoint != nil {
+		entrypointJSON, _ := json.Marshal(t.Entrypoint)
+		parts.add("entrypoint", append([]byte("entrypoint\x00"), entrypointJSON...))
+	}
 
-	// Loop over dependencies and include them in the hash
-	for _, dependency := range t.Dependencies {
-		hasher.Write([]byte(dependency.Task.Name))
-		for _, pattern := range dependency.Artifacts {
-			hasher.Write([]byte(pattern.To))
-			hasher.Write([]byte(pattern.From))
+	// Only the content digests are included, host paths differ between machines sharing a cache
+	for _, input := range sortedStrings(t.HashInputs) {
+		parts.add("input "+input, []byte(t.inputDigests[input]))
+	}
+	parts.add("helper", []byte(t.helperDigest))
+
+	// Secret values are only included as digests, the hash is part of container names and cache keys
+	for _, secret := range sortedSecrets(t.Secrets) {
+		parts.addf("secret "+secret.Name, "%s\x00%t\x00%s", secret.Name, secret.Mount, secretDigest(secret))
+	}
+
+	// The resolved commit decides what is checked out, not the ref or where the repository is hosted
+	for _, source := range t.Git {
+		parts.addf("git "+source.Path, "git\x00%s\x00%s\x00%t\x00", source.Path, t.gitCommits[source.Path], source.Submodules)
+	}
+	for _, download := range t.Downloads {
+		parts.addf("download "+download.Dest, "download\x00%s\x00%s\x00%t\x00", download.Dest, download.SHA256, download.Executable)
+	}
+
+	t.addMountsHash(&parts)
+
+	// Services are what integration tests run against, e.g. a new database version has to re-run them
+	for _, service := range sortedServices(t.Services) {
+		parts.addf("service "+service.Name, "%s\x00%s\x00%s\x00", service.Name, service.Image, strings.Join(serviceEnv(service), "\x00"))
+	}
+
+	// Loop over dependencies and include them in the hash. Including the dependency's own hash
+	// makes changes (e.g. a rebuilt image) invalidate all downstream tasks. Declaration order
+	// does not matter, artifacts are always copied in canonical order.
+	for _, dependency := range t.sortedDependencies() {
+		data := []byte(dependency.Task.Name + dependency.Task.generateHash())
+		for _, pattern := range sortedArtifacts(dependency.Artifacts) {
+			data = append(data, pattern.To+pattern.From...)
+		}
+		// A refreshed dependency keeps its hash, only its stored outputs tell its results apart
+		if dependency.Task.Freshness > 0 {
+			data = append(data, dependency.Task.storedOutputs...)
 		}
+		parts.add("dependency "+dependency.Task.Name, data)
 	}
 
-	hash := hex.EncodeToString(hasher.Sum(nil))[:12]
-	return hash
+	// The user decides the owner of every output file. Only the flag is included for the host user,
+	// whose IDs differ between machines sharing a cache.
+	if t.User != "" || t.HostUser {
+		parts.addf("user", "%s\x00%t", t.User, t.HostUser)
+	}
+	if inherits := t.inherits(); inherits != (ImageInheritance{Env: true, User: true, WorkDir: true}) {
+		parts.addf("image inheritance", "env=%t\x00user=%t\x00workdir=%t", inherits.Env, inherits.User, inherits.WorkDir)
+	}
+
+	if security := t.securityHash(); security != "" {
+		parts.add("security options", []byte(security))
+	}
+
+	// Only first-wins and last-wins change which artifacts end up in the container
+	if policy, _ := ParseConflictPolicy(string(t.ArtifactConflicts)); policy == ConflictFirstWins || policy == ConflictLastWins {
+		parts.add("artifact conflicts", []byte(policy))
+	}
+	return parts
 }
 
-func imageExistsLocally(cli *client.Client, baseImage string) (bool, error){
+func imageExistsLocally(cli ImageAPI, baseImage string) (bool, error) {
 	images, err := cli.ImageList(context.Background(), imagetypes.ListOptions{})
 	if err != nil {
 		return false, fmt.Errorf("unable to get images, please make sure that docker daemon is up and running: %w", err)
@@ -83,47 +258,40 @@ func imageExistsLocally(cli *client.Client, baseImage string) (bool, error){
 	return false, nil
 }
 
+func pullImage(ctx context.Context, cli ImageAPI, t *Task) error {
+	// Images of Dockerfile-build tasks only exist locally
+	if t.Build != nil {
+		return nil
+	}
+	if len(t.ImageMirrors) > 0 {
+		return t.pullMirroredImage(ctx, cli)
+	}
+	return ensureImage(ctx, cli, t.BaseImage, t.Platform, statusWriter{t})
+}
 
-func pullImage(ctx context.Context, cli *client.Client, t *Task) error {
+// ensureImage pulls image for platform, empty for the daemon's own, unless it already exists locally,
+// which it tells status
+func ensureImage(ctx context.Context, cli ImageAPI, image, platform string, status io.Writer) error {
 	// Check if the image already exists locally
-    exists, err := imageExistsLocally(cli, t.BaseImage)
-    if err != nil {
-        return fmt.Errorf("failed to check for image: %w", err)
-    }
-    
-    if exists {
-        fmt.Printf("Image %s already exists locally\n", t.BaseImage)
-        return nil
-    }
-    
-    // Image doesn't exist, pull it
-    fmt.Printf("Pulling image: %s\n", t.BaseImage)
-    reader, err := cli.ImagePull(ctx, t.BaseImage, imagetypes.PullOptions{})
-    if err != nil {
-        return fmt.Errorf("failed to pull image: %w", err)
-    }
-    defer reader.Close()
-    
-    // Stream the pull progress to stdout
-    if _, err := io.Copy(os.Stdout, reader); err != nil {
-        return fmt.Errorf("error streaming pull output: %w", err)
-    }
-    
-    return nil
+	exists, err := imageAvailable(ctx, cli, image, platform)
+	if err != nil {
+		return fmt.Errorf("failed to check for image: %w", err)
+	}
+
+	if exists {
+		fmt.Fprintf(status, "Image %s already exists locally\n", image)
+		return nil
+	}
+
+	// Image doesn't exist, pull it
+	return pullFrom(ctx, cli, image, platform)
 }
 
 // findTaskContainer looks for a container for the specified task
-func findTaskContainer(ctx context.Context, cli *client.Client, taskName string) (string, bool, error) {
-	// Search for containers with the task name in their name
-	listFilters := filters.NewArgs()
-	listFilters.Add("name", fmt.Sprintf("buildvault_%s_", taskName))
-
-	containers, err := cli.ContainerList(ctx, container.ListOptions{
-		All:     true, // Include stopped containers
-		Filters: listFilters,
-	})
+func findTaskContainer(ctx context.Context, cli ContainerAPI, taskName string) (string, bool, error) {
+	containers, err := listTaskContainers(ctx, cli, taskName, "")
 	if err != nil {
-		return "", false, fmt.Errorf("error searching for task container: %w", err)
+		return "", false, err
 	}
 
 	if len(containers) > 0 {
@@ -133,24 +301,18 @@ func findTaskContainer(ctx context.Context, cli *client.Client, taskName string)
 	return "", false, nil
 }
 
-func (t *Task) isCircularDependencyFree(ctx context.Context, parentHashes []string) bool {
-	if len(t.Dependencies) == 0 {
-		return true
-	}
-
-	currentHash := t.generateHash()
-
+func (t *Task) isCircularDependencyFree(parents []*Task) bool {
 	// Check if current task is already in the parent chain (circular dependency)
-	if slices.Contains(parentHashes, currentHash) {
+	if slices.Contains(parents, t) {
 		return false
 	}
 
 	// Create a new slice for this level to avoid modifying the original
-	newParentHashes := append(append([]string{}, parentHashes...), currentHash)
+	newParents := append(append([]*Task{}, parents...), t)
 
 	// Check all dependencies recursively
 	for _, dependency := range t.Dependencies {
-		if !dependency.Task.isCircularDependencyFree(ctx, newParentHashes) {
+		if !dependency.Task.isCircularDependencyFree(newParents) {
 			return false
 		}
 	}
@@ -158,114 +320,596 @@ func (t *Task) isCircularDependencyFree(ctx context.Context, parentHashes []stri
 	return true
 }
 
-func (t *Task) executeDependenciesAndCopyArtifacts(ctx context.Context, cli *client.Client) error {
+func (t *Task) executeDependencies(ctx context.Context, cli DockerAPI) error {
 	if len(t.Dependencies) == 0 {
-		fmt.Println("No dependencies found")
+		t.statusf("No dependencies found\n")
 		return nil
 	}
 
-	if !t.isCircularDependencyFree(ctx, []string{}) {
-		fmt.Println("Circular dependency found")
-	}
-
-	fmt.Println("Processing dependencies:")
-
-	fmt.Println("Executing dependencies:")
-	// TODO goroutines for parallelism
+	// Pipeline.Run executes the dependencies in earlier waves, only tasks executed on their own get here first
+	t.statusf("Executing dependencies:\n")
 	for _, dependency := range t.Dependencies {
-		fmt.Printf("- %s\n", dependency.Task.Name)
-		if err := dependency.Task.Execute(ctx, cli); err != nil {
+		if t.options.isDone(dependency.Task) {
+			// Shared with another task executed earlier in this run
+			t.statusf("- %s (already %s)\n", dependency.Task.Name, dependency.Task.status())
+			continue
+		}
+		t.statusf("- %s\n", dependency.Task.Name)
+		if err := dependency.Task.Execute(ctx, cli, inheritOptions(t.options)); err != nil {
 			return fmt.Errorf("error executing task dependency %s:  %w", dependency.Task.Name, err)
 		}
 	}
 
-	fmt.Println("Copying artifacts from dependencies:")
-	// run afterward to ensure ordering is kept consistent after goroutines run
-	for _, dependency := range t.Dependencies {
-		fmt.Printf("- %s\n", dependency.Task.Name)
-		for _, artifact := range dependency.Artifacts {
-			fmt.Printf("  Copying %s from task '%s' to current task at %s\n", artifact.From, dependency.Task.Name, artifact.To)
-			if err := copyBetweenContainers(ctx, cli, dependency.Task.containerID, t.containerID, artifact.From, artifact.To); err != nil {
-				return fmt.Errorf("error copying dependency file %s: %w", artifact.From, err)
+	return nil
+}
+
+// maxParallelCopies bounds the artifact copies running at the same time for one task
+const maxParallelCopies = 4
+
+// copyArtifacts copies the artifacts of all dependencies into the task container in canonical order.
+// Copies run concurrently, except that a copy waits for earlier copies to overlapping destinations,
+// and every failed copy is reported with the artifact it belongs to.
+func (t *Task) copyArtifacts(ctx context.Context, cli DockerAPI) error {
+	copies := t.resolvedCopies()
+	if len(copies) == 0 {
+		return nil
+	}
+
+	t.statusf("Copying artifacts from dependencies:\n")
+	// Print the plan up front to ensure ordering is kept consistent after goroutines run
+	for _, c := range copies {
+		t.statusf("  Copying %s from task '%s' to current task at %s\n", c.artifact.From, c.dependency.Name, c.artifact.To)
+	}
+
+	errs := make([]error, len(copies))
+	done := make([]chan struct{}, len(copies))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	semaphore := make(chan struct{}, maxParallelCopies)
+	var wg sync.WaitGroup
+	for i, c := range copies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[i])
+			for j := 0; j < i; j++ {
+				if destinationsOverlap(c.artifact.To, copies[j].artifact.To) {
+					<-done[j]
+				}
 			}
-		}
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			if err := t.copyDependencyArtifact(ctx, cli, c.dependency, c.artifact); err != nil {
+				errs[i] = fmt.Errorf("error copying dependency file %s from task '%s' to %s: %w",
+					c.artifact.From, c.dependency.Name, c.artifact.To, err)
+			}
+		}()
 	}
+	wg.Wait()
 
-	return nil
+	return errors.Join(errs...)
 }
 
-func (t *Task) executeCommands(ctx context.Context, cli *client.Client) error {
-	// Execute all commands in sequence
-	for idx, cmd := range t.Commands {
-		fmt.Printf("Executing command %d: %s\n", idx+1, cmd)
-
-		execResp, err := cli.ContainerExecCreate(ctx, t.containerID, container.ExecOptions{
-			Cmd:          []string{"sh", "-c", cmd},
-			AttachStdout: true,
-			AttachStderr: true,
-		})
+// openDependencyArtifact returns a tar stream of an artifact of an executed dependency, read from its
+// container, or from the artifact store if the dependency was skipped because its outputs were stored
+func openDependencyArtifact(ctx context.Context, cli DockerAPI, dependency *Task, from string) (io.ReadCloser, error) {
+	if dependency.Virtual {
+		source, sourcePath, err := resolveArtifactSource(dependency, from)
 		if err != nil {
-			return fmt.Errorf("error creating exec for command '%s': %w", cmd, err)
+			return nil, err
 		}
+		reader, err := openDependencyArtifact(ctx, cli, source, sourcePath)
+		if err != nil || path.Base(sourcePath) == path.Base(from) {
+			return reader, err
+		}
+		// The artifact carries the name it is re-exported under
+		return rewriteTarPrefix(reader, path.Base(sourcePath), path.Base(from)), nil
+	}
+
+	sourceContainerID := dependency.containerID
 
-		attachResp, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
+	if sourceContainerID == "" && dependency.ArtifactStore != nil {
+		reader, ok, err := dependency.ArtifactStore.Open(ctx, dependency.generateHash(), from)
 		if err != nil {
-			return fmt.Errorf("error attaching to exec for command '%s': %w", cmd, err)
+			return nil, err
 		}
-		defer attachResp.Close()
+		if ok {
+			return reader, nil
+		}
+	}
 
-		_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, attachResp.Reader)
+	if sourceContainerID == "" {
+		// Not a declared output, fall back to the preserved container if it was not pruned
+		containers, err := listTaskContainers(ctx, cli, dependency.Name, dependency.generateHash())
 		if err != nil {
-			return fmt.Errorf("error StdCopy: %w", err)
+			return nil, err
 		}
-		fmt.Println() // Add newline for command output separation
+		if len(containers) == 0 {
+			return nil, fmt.Errorf("%s is not a stored output of task '%s' and its container no longer exists", from, dependency.Name)
+		}
+		sourceContainerID = containers[0].ID
+	}
 
-		// Check the exit code of the command
-		inspectResp, err := cli.ContainerExecInspect(ctx, execResp.ID)
-		if err != nil {
-			return fmt.Errorf("error inspecting exec for command '%s': %w", cmd, err)
+	if err := checkArtifactExists(ctx, cli, dependency, sourceContainerID, from); err != nil {
+		return nil, err
+	}
+	reader, _, err := cli.CopyFromContainer(ctx, sourceContainerID, from)
+	if err != nil {
+		return nil, fmt.Errorf("error copying from source container: %w", err)
+	}
+	return reader, nil
+}
+
+// copyDependencyArtifact streams an artifact of an executed dependency into the task container, reporting progress
+func (t *Task) copyDependencyArtifact(ctx context.Context, cli DockerAPI, dependency *Task, artifact Artifact) error {
+	release, err := acquireCopy(ctx, cli)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	reader, err := openDependencyArtifact(ctx, cli, dependency, artifact.From)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	// Artifacts whose digest is known are verified while they are copied
+	expected, verified, err := expectedArtifactDigest(ctx, dependency, artifact.From)
+	if err != nil {
+		return err
+	}
+	var source io.Reader = reader
+	var verifier *artifactVerifier
+	if verified {
+		verifier = newArtifactVerifier(reader)
+		defer verifier.close()
+		source = verifier
+	}
+
+	var copied int64
+	progress := newProgressReader(source, func(p CopyProgress) {
+		copied = p.Bytes
+		if p.Done {
+			t.statusf("  Copied %s from task '%s': %s\n", artifact.From, dependency.Name, p)
+		} else {
+			t.statusf("  Copying %s from task '%s': %s so far\n", artifact.From, dependency.Name, p)
+		}
+	})
+	var archive io.Reader = progress
+	if t.readOnlyArtifact(artifact) {
+		archive = stripWriteBits(progress)
+	}
+	compressed := compressStream(archive, compressionFor(artifactSize(dependency, artifact.From)))
+	defer compressed.Close()
+	archive = compressed
+	if err := copyTarToContainer(ctx, cli, t.containerID, artifact.To, t.mkdirCommand(), t.containerUser() != "", archive); err != nil {
+		return err
+	}
+	if verifier != nil {
+		if err := verifier.verify(expected); err != nil {
+			return err
+		}
+	}
+	t.emit(Event{Type: EventArtifactCopied, Source: dependency.Name, From: artifact.From, To: artifact.To, Bytes: copied})
+	return nil
+}
+
+func (t *Task) executeCommands(ctx context.Context, cli DockerAPI) error {
+	stdout, stderr := t.options.stdout, t.options.stderr
+	if t.OutputMux != nil {
+		output := t.OutputMux.Writer(t.Name)
+		defer output.Close()
+		stdout, stderr = output, output
+	}
+	stdout, stderr = t.outputWriters(stdout, stderr)
+	defer flushLines(stdout)
+	defer flushLines(stderr)
+	if t.options.logLevel != LogQuiet {
+		return t.runCommands(ctx, cli, stdout, stderr)
+	}
+
+	// Quiet runs only show the output of failing tasks
+	held := &heldOutput{}
+	err := t.runCommands(ctx, cli, held.writer(stdout), held.writer(stderr))
+	if err != nil {
+		held.replay()
+	}
+	return err
+}
+
+// runCommands runs the script, batch or commands of t, writing their output to stdout and stderr
+func (t *Task) runCommands(ctx context.Context, cli DockerAPI, stdout, stderr io.Writer) error {
+	t.commandResults = nil
+	t.commandLog = &taskLog{}
+	stdout, stderr = io.MultiWriter(stdout, t.commandLog), io.MultiWriter(stderr, t.commandLog)
+	if t.Events != nil {
+		stdoutEvents, stderrEvents := &eventWriter{task: t, stream: "stdout"}, &eventWriter{task: t, stream: "stderr"}
+		defer stdoutEvents.flush()
+		defer stderrEvents.flush()
+		stdout, stderr = io.MultiWriter(stdout, stdoutEvents), io.MultiWriter(stderr, stderrEvents)
+	}
+
+	if values := t.secretValues(); len(values) > 0 {
+		maskedStdout, maskedStderr := newSecretMasker(stdout, values), newSecretMasker(stderr, values)
+		defer maskedStdout.flush()
+		defer maskedStderr.flush()
+		stdout, stderr = maskedStdout, maskedStderr
+	}
+
+	if t.Script != "" {
+		if err := t.executeScript(ctx, cli, stdout, stderr); err != nil {
+			return err
+		}
+		return t.checkReadOnlyArtifacts(ctx, cli, "the script")
+	}
+
+	if t.BatchCommands {
+		if err := t.executeBatch(ctx, cli, stdout, stderr); err != nil {
+			return err
 		}
+		return t.checkReadOnlyArtifacts(ctx, cli, "the commands")
+	}
 
-		if inspectResp.ExitCode != 0 {
-			return fmt.Errorf("command '%s' failed with exit code %d", cmd, inspectResp.ExitCode)
+	// Execute all commands in sequence
+	for idx, cmd := range t.commandLines() {
+		if err := t.executeCommand(ctx, cli, idx, cmd, stdout, stderr); err != nil {
+			return err
+		}
+		if err := t.checkReadOnlyArtifacts(ctx, cli, fmt.Sprintf("command '%s'", cmd)); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
+// executeCommand runs the command at idx, shown as cmd, in its own exec and streams its output
+func (t *Task) executeCommand(ctx context.Context, cli ExecAPI, idx int, cmd string, stdout, stderr io.Writer) error {
+	release, err := acquireExec(ctx, cli)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	fmt.Fprintf(stdout, "Executing command %d: %s\n", idx+1, cmd)
+	commandStarted := time.Now()
+
+	stdin := t.Stdin[idx]
+	execResp, err := cli.ContainerExecCreate(ctx, t.containerID, container.ExecOptions{
+		Cmd:          t.commandArgv(idx),
+		Env:          t.execEnv(),
+		User:         t.containerUser(),
+		WorkingDir:   t.workDir(),
+		AttachStdin:  stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating exec for command '%s': %w", cmd, err)
+	}
+
+	attachResp, err := cli.ContainerExecAttach(ctx, execResp.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return fmt.Errorf("error attaching to exec for command '%s': %w", cmd, err)
+	}
+	defer attachResp.Close()
+
+	var stdinDone <-chan error
+	if stdin != nil {
+		stdinDone = pipeStdin(attachResp, stdin)
+	}
+	captured := t.captureStdout(&stdout)
+	_, err = stdcopy.StdCopy(stdout, stderr, attachResp.Reader)
+	if err != nil {
+		return fmt.Errorf("error StdCopy: %w", err)
+	}
+	if err := waitForStdin(attachResp, stdinDone); err != nil {
+		return fmt.Errorf("error reading stdin of command '%s': %w", cmd, err)
+	}
+	fmt.Fprintln(stdout) // Add newline for command output separation
+
+	// Check the exit code of the command
+	inspectResp, err := cli.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return fmt.Errorf("error inspecting exec for command '%s': %w", cmd, err)
+	}
+
+	t.recordCommand(cmd, inspectResp.ExitCode, time.Since(commandStarted), captured)
+	t.verbosef(stdout, "Command %d exited with code %d after %s\n", idx+1, inspectResp.ExitCode, time.Since(commandStarted).Round(time.Millisecond))
+	if inspectResp.ExitCode != 0 {
+		return &CommandError{Task: t.Name, Command: cmd, ExitCode: inspectResp.ExitCode}
+	}
+	return nil
+}
+
+// verifyOutputs checks that all declared outputs exist in the task container
+func (t *Task) verifyOutputs(ctx context.Context, cli ContainerFileAPI) error {
+	for _, output := range t.declaredOutputs() {
+		if _, err := cli.ContainerStatPath(ctx, t.containerID, output); err != nil {
+			return fmt.Errorf("declared output %s of task '%s' was not produced: %w", output, t.Name, err)
+		}
+	}
+	return nil
+}
+
+// prepareImages builds or pulls the base images of all tasks in the graph of t and checks that they can
+// run commands. This happens before any hash is computed (the digests of built images are part of the
+// hashes) and before anything executes, so broken images are reported up front.
+func (t *Task) prepareImages(ctx context.Context, cli DockerAPI) error {
+	for _, dependency := range t.Dependencies {
+		if err := dependency.Task.prepareImages(ctx, cli); err != nil {
+			return err
+		}
+	}
+
+	if t.imageID != "" || t.Virtual || t.Container != "" {
+		return nil
+	}
+
+	if t.Build != nil {
+		if err := t.buildImage(ctx, cli); err != nil {
+			return err
+		}
+	} else {
+		if err := pullImage(ctx, cli, t); err != nil {
+			return err
+		}
+		inspect, err := cli.ImageInspect(ctx, t.BaseImage)
+		if err != nil {
+			return fmt.Errorf("error inspecting image %s: %w", t.BaseImage, err)
+		}
+		t.imageID = inspect.ID
+		if inspect.Config != nil {
+			t.imageEnv = inspect.Config.Env
+		}
+	}
+
+	return t.checkShell(ctx, cli)
+}
+
 // Execute runs the task:
-// 1. Creates or reuses a container with a deterministic name based on task properties
-// 2. Copies artifacts from dependency task containers
-// 3. Executes commands in the container
-// 4. Stops the container but keeps it for future reference
-func (t *Task) Execute(ctx context.Context, cli *client.Client) error {
+// 1. Builds or pulls the images of the whole task graph, checks that they have a shell (or a helper) and hashes host inputs
+// 2. Executes the dependencies and hashes the dependency artifacts used as inputs
+// 3. Skips execution entirely if the task's outputs are already in its artifact store
+// 4. Creates or reuses a container with a deterministic name based on task properties and injects the helper binary
+// 5. Copies artifacts from dependency task containers
+// 6. Executes commands in the container
+// 7. Verifies that the declared outputs exist and hashes them inside the container
+// 8. Commits the container to the output image, if the task has one, and pushes it if asked to
+// 9. Saves the declared outputs to the artifact store
+// 10. Stops the container but keeps it for future reference
+//
+// The options apply to the dependencies it executes as well. A dependency shared by several tasks of the
+// graph executes once. If a task that is allowed to fail fails after its dependencies completed, the
+// failure is only printed, unless the run was interrupted.
+func (t *Task) Execute(ctx context.Context, cli DockerAPI, opts ...ExecuteOption) error {
+	t.options = newExecuteOptions(opts)
+	ctx, cancel := t.options.withTimeout(ctx)
+	defer cancel()
+	if t.options.cleanup == nil {
+		// Called for a target rather than a dependency, the containers of the run are removed at its end
+		t.options.cleanup = &containerCleanup{}
+		defer t.options.cleanup.run(context.WithoutCancel(ctx), cli)
+	}
+
+	err := t.execute(ctx, cli)
+	if err != nil && t.AllowFailure && !t.started.IsZero() && ctx.Err() == nil {
+		// Only failures of its own work are tolerated, failing dependencies and interrupted runs still fail
+		t.statusf("Task '%s' failed, which it is allowed to: %v\n", t.Name, err)
+		t.allowedFailure = true
+		err = nil
+	}
+	// Recorded for the state of interrupted runs and run reports
+	t.completed = err == nil && !t.skipped && !t.allowedFailure
+	t.options.setDone(t, err == nil)
+	t.options.cleanup.add(t)
+	if !t.started.IsZero() {
+		t.duration = time.Since(t.started)
+	}
+	t.emitFinished(err)
+	return err
+}
+
+func (t *Task) execute(ctx context.Context, cli DockerAPI) error {
+	if !t.isCircularDependencyFree(nil) {
+		return configErrorf("circular dependency found in task '%s'", t.Name)
+	}
+
+	if t.Container != "" {
+		if err := t.validateExternal(); err != nil {
+			return err
+		}
+		return t.adoptContainer(ctx, cli)
+	}
+
+	if t.skippedByCondition() {
+		t.skipped = true
+		t.statusf("Task '%s' skipped, its condition does not hold\n", t.Name)
+		return nil
+	}
+
+	if err := t.resolveVars(); err != nil {
+		return err
+	}
+
+	if err := t.resolveOutputRefs(); err != nil {
+		return err
+	}
+
+	if err := t.validate(); err != nil {
+		return err
+	}
+
+	if err := t.prepareImages(ctx, cli); err != nil {
+		return err
+	}
+
+	if err := t.hashHostInputs(); err != nil {
+		return err
+	}
+
+	if err := t.executeDependencies(ctx, cli); err != nil {
+		return err
+	}
+	if dependency, ok := t.skippedDependency(); ok {
+		t.skipped = true
+		t.statusf("Task '%s' skipped, its dependency '%s' was skipped\n", t.Name, dependency.Name)
+		return nil
+	}
+	for _, dependency := range t.Dependencies {
+		if dependency.Task.allowedFailure {
+			return fmt.Errorf("task '%s' depends on '%s', which failed", t.Name, dependency.Task.Name)
+		}
+	}
+	t.started = time.Now()
+	t.emit(Event{Type: EventTaskStarted})
+	t.printHashDetails(os.Stdout)
+
+	if err := t.hashArtifactInputs(ctx, cli); err != nil {
+		return err
+	}
+
+	if t.Virtual {
+		// Dependents read the re-exported artifacts from the upstream tasks directly
+		t.statusf("Task: %s (virtual, re-exports the artifacts of its dependencies)\n", t.Name)
+		if err := t.exportArtifacts(ctx, cli); err != nil {
+			return err
+		}
+		if t.ManifestList != "" {
+			return t.pushManifestList(ctx)
+		}
+		return nil
+	}
+
 	containerName := t.generateContainerName()
-	fmt.Printf("Task: %s (Container: %s)\n", t.Name, containerName)
+	t.statusf("Task: %s (Container: %s)\n", t.Name, containerName)
+
+	unlock, err := t.lockContainer(ctx, containerName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if t.ArtifactStore != nil && !t.options.force && len(t.Stdin) == 0 {
+		stored, err := t.lookupStored(ctx)
+		if err != nil {
+			return err
+		}
+		t.cacheHit = stored && t.outputImageCurrent(ctx, cli)
+		if stored && !t.cacheHit {
+			t.statusf("Task '%s' outputs found in artifact store, executing it anyway to commit image %s\n", t.Name, t.OutputImage)
+		}
+		if t.cacheHit {
+			t.containerID = ""
+			t.statusf("Task '%s' outputs found in artifact store, skipping execution\n", t.Name)
+			if err := t.pushOutputImage(ctx, cli); err != nil {
+				return err
+			}
+			return t.exportArtifacts(ctx, cli)
+		}
+	}
 
 	if err := cleanUpRunningContainer(ctx, containerName, cli); err != nil {
 		return err
 	}
 
-	if err := pullImage(ctx, cli, t); err != nil {
+	if err := t.createCacheVolumes(ctx, cli); err != nil {
 		return err
 	}
 
-	resp, err := createLongLivedContainer(ctx, containerName, t.BaseImage, cli)
+	hostConfig := t.hostConfig()
+	if len(t.Services) > 0 {
+		stopServices, err := t.startServices(ctx, cli, containerName)
+		defer stopServices()
+		if err != nil {
+			return err
+		}
+		hostConfig.NetworkMode = container.NetworkMode(servicesNetwork(containerName))
+	}
+
+	resp, err := createLongLivedContainer(ctx, containerName, t.containerConfig(), hostConfig, t.platform(), t.containerLabels(), cli)
 	if err != nil {
 		return err
 	}
 	t.containerID = resp.ID
 
+	if t.Helper != "" {
+		if err := injectHelper(ctx, cli, t.containerID, t.Helper); err != nil {
+			return err
+		}
+	}
+
+	if t.Pause {
+		if err := t.injectPause(ctx, cli); err != nil {
+			return err
+		}
+	}
+
 	if err := startContainer(ctx, t.containerID, cli); err != nil {
 		return err
 	}
 
-	if err := t.executeDependenciesAndCopyArtifacts(ctx, cli); err != nil {
+	if err := t.waitForReadiness(ctx, cli); err != nil {
+		return err
+	}
+
+	if err := t.writeSecretFiles(ctx, cli); err != nil {
+		return err
+	}
+
+	// Artifacts may be copied into a checkout, e.g. generated code
+	if err := t.checkoutGitSources(ctx, cli); err != nil {
+		return err
+	}
+
+	if err := t.downloadFiles(ctx, cli); err != nil {
+		return err
+	}
+
+	if err := t.copyArtifacts(ctx, cli); err != nil {
+		return err
+	}
+
+	if err := t.digestReadOnlyArtifacts(ctx, cli); err != nil {
+		return err
+	}
+
+	if err := t.waitForServices(ctx, cli, containerName); err != nil {
 		return err
 	}
 
 	if err := t.executeCommands(ctx, cli); err != nil {
+		if t.SnapshotOnFailure {
+			t.snapshotFailure(ctx, cli)
+		}
+		return err
+	}
+
+	if err := t.verifyOutputs(ctx, cli); err != nil {
+		return err
+	}
+	t.digestOutputs(ctx, cli)
+
+	if t.OutputImage != "" {
+		if err := t.CommitImage(ctx, cli, t.OutputImage); err != nil {
+			return err
+		}
+		if err := t.pushOutputImage(ctx, cli); err != nil {
+			return err
+		}
+	}
+
+	if t.ArtifactStore != nil {
+		if err := t.ArtifactStore.Save(ctx, cli, t); err != nil {
+			return err
+		}
+	}
+
+	if err := t.exportArtifacts(ctx, cli); err != nil {
 		return err
 	}
 
@@ -273,8 +917,78 @@ func (t *Task) Execute(ctx context.Context, cli *client.Client) error {
 		return err
 	}
 
-	fmt.Printf("Task '%s' execution complete. Container '%s' is stopped but preserved.\n",
-		t.Name, containerName)
+	t.statusf("Task '%s' execution complete. Container '%s' is stopped but preserved.\n", t.Name, containerName)
 
 	return nil
 }
+
+// validate checks the configuration of t before anything of it is executed
+func (t *Task) validate() error {
+	if t.Virtual {
+		if err := t.validateVirtual(); err != nil {
+			return err
+		}
+	}
+
+	if err := t.validateMounts(); err != nil {
+		return err
+	}
+
+	if err := t.validateGit(); err != nil {
+		return err
+	}
+
+	if err := t.validateDownloads(); err != nil {
+		return err
+	}
+
+	if err := t.validateNetwork(); err != nil {
+		return err
+	}
+
+	if err := t.validateDevices(); err != nil {
+		return err
+	}
+
+	if err := t.validateSecurity(); err != nil {
+		return err
+	}
+
+	if err := t.validateReadOnlyRootfs(); err != nil {
+		return err
+	}
+
+	if err := t.validateServices(); err != nil {
+		return err
+	}
+
+	if err := t.validateReadiness(); err != nil {
+		return err
+	}
+
+	if err := t.validatePause(); err != nil {
+		return err
+	}
+
+	if err := t.validateStdin(); err != nil {
+		return err
+	}
+
+	if err := t.validateCapture(); err != nil {
+		return err
+	}
+
+	if err := t.validateArtifactConflicts(); err != nil {
+		return err
+	}
+
+	if err := t.validateUser(); err != nil {
+		return err
+	}
+
+	if err := t.validateCommands(); err != nil {
+		return err
+	}
+
+	return t.validateScript()
+}
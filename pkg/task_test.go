@@ -2,58 +2,71 @@ package pkg
 
 import (
 	"context"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/api/types/filters"
-	"github.com/docker/docker/client"
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
 )
 
-func setupDockerClient(t *testing.T) *client.Client {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		t.Fatalf("Failed to create Docker client: %v", err)
+// shellRedirect matches the commands of the tests writing files: echo 'text' or cat file, redirected
+var shellRedirect = regexp.MustCompile(`^(echo '([^']*)'|cat (\S+)) (>>?) (\S+)$`)
+
+// shellFake returns a fake daemon with an alpine image whose shell runs redirects of echo and cat, and
+// the builtins of dockertest for other commands
+func shellFake(t *testing.T) *dockertest.Fake {
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"docker.io/library/alpine"}, Files: map[string]string{"/bin/sh": ""}})
+	cli.Exec = func(e *dockertest.Exec) int {
+		if len(e.Cmd) != 3 || e.Cmd[1] != "-c" {
+			return dockertest.Builtins(e)
+		}
+		m := shellRedirect.FindStringSubmatch(e.Cmd[2])
+		if m == nil {
+			return dockertest.Builtins(&dockertest.Exec{Container: e.Container, Cmd: strings.Fields(e.Cmd[2]), WorkingDir: e.WorkingDir, Stdin: e.Stdin, Stdout: e.Stdout, Stderr: e.Stderr})
+		}
+		data := []byte(m[2] + "\n")
+		if m[3] != "" {
+			var err error
+			if data, err = e.Container.ReadFile(m[3]); err != nil {
+				return 1
+			}
+		}
+		if m[4] == ">>" {
+			existing, _ := e.Container.ReadFile(m[5])
+			data = append(existing, data...)
+		}
+		if err := e.Container.WriteFile(m[5], data, 0o644); err != nil {
+			t.Errorf("Failed to write %s: %v", m[5], err)
+			return 1
+		}
+		return 0
 	}
 	return cli
 }
 
-func cleanupContainers(t *testing.T, cli *client.Client, taskNames []string) {
-	ctx := context.Background()
-
-	for _, name := range taskNames {
-		task := Task{Name: name}
-		containerName := task.generateContainerName()
-
-		// Try to find the container
-		listFilters := filters.NewArgs()
-		listFilters.Add("name", containerName)
-
-		containers, err := cli.ContainerList(ctx, container.ListOptions{
-			All:     true,
-			Filters: listFilters,
-		})
-		if err != nil {
-			t.Logf("Error listing containers during cleanup: %v", err)
-			continue
-		}
-
-		// Remove each found container
-		for _, cnt := range containers {
-			t.Logf("Cleaning up container: %s", cnt.ID[:12])
-
-			// Stop if running
-			if cnt.State == "running" {
-				if err := cli.ContainerStop(ctx, cnt.ID, container.StopOptions{Signal: "SIGKILL"}); err != nil {
-					t.Logf("Error stopping container during cleanup: %v", err)
-				}
-			}
+// readContainerFile returns the content of p in the container of task
+func readContainerFile(t *testing.T, cli *dockertest.Fake, task *Task, p string) string {
+	c, ok := cli.Container(task.generateContainerName())
+	if !ok {
+		t.Fatalf("Container of task '%s' not found", task.Name)
+	}
+	data, err := c.ReadFile(p)
+	if err != nil {
+		t.Fatalf("Failed to read %s in the container of task '%s': %v", p, task.Name, err)
+	}
+	return string(data)
+}
 
-			// Remove
-			if err := cli.ContainerRemove(ctx, cnt.ID, container.RemoveOptions{Force: true}); err != nil {
-				t.Logf("Error removing container during cleanup: %v", err)
-			}
-		}
+// assertPreserved fails unless the container of task exists and is stopped
+func assertPreserved(t *testing.T, cli *dockertest.Fake, task *Task) {
+	inspect, err := cli.ContainerInspect(context.Background(), task.generateContainerName())
+	if err != nil {
+		t.Fatalf("Container of task '%s' not found: %v", task.Name, err)
+	}
+	if inspect.State.Running {
+		t.Errorf("Container of task '%s' should be stopped", task.Name)
 	}
 }
 
@@ -102,249 +115,191 @@ func TestGenerateContainerName(t *testing.T) {
 }
 
 func TestTaskExecution(t *testing.T) {
-	cli := setupDockerClient(t)
-	defer cli.Close()
-
-	// Ensure cleanup after all tests
-	taskNames := []string{"test-basic-task"}
-	defer cleanupContainers(t, cli, taskNames)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-
-	// Simple task execution test
-	t.Run("BasicTaskExecution", func(t *testing.T) {
-		task := Task{
-			Name:      "test-basic-task",
-			BaseImage: "docker.io/library/alpine",
-			Commands: []string{
-				"mkdir -p /output", "echo 'Hello from test' > /output/test-file.txt",
-				"cat /output/test-file.txt",
-			},
-		}
-
-		err := task.Execute(ctx, cli)
-		if err != nil {
-			t.Fatalf("Task execution failed: %v", err)
-		}
-
-		// Verify the container exists and is stopped
-		containerName := task.generateContainerName()
-		listFilters := filters.NewArgs()
-		listFilters.Add("name", containerName)
-
-		containers, err := cli.ContainerList(ctx, container.ListOptions{
-			All:     true,
-			Filters: listFilters,
-		})
-		if err != nil {
-			t.Fatalf("Failed to list containers: %v", err)
-		}
-
-		if len(containers) == 0 {
-			t.Fatalf("Container not found after task execution")
-		}
+	cli := shellFake(t)
+	task := Task{
+		Name:      "test-basic-task",
+		BaseImage: "docker.io/library/alpine",
+		Commands: []string{
+			"mkdir -p /output", "echo 'Hello from test' > /output/test-file.txt",
+			"cat /output/test-file.txt",
+		},
+	}
 
-		if containers[0].State != "exited" && containers[0].State != "running" {
-			t.Errorf("Container should be stopped, but state is: %s", containers[0].State)
-		}
-	})
+	if err := task.Execute(context.Background(), cli); err != nil {
+		t.Fatalf("Task execution failed: %v", err)
+	}
+	assertPreserved(t, cli, &task)
+	if content := readContainerFile(t, cli, &task, "/output/test-file.txt"); content != "Hello from test\n" {
+		t.Errorf("Unexpected file content %q", content)
+	}
 }
 
 func TestTaskDependencies(t *testing.T) {
-	cli := setupDockerClient(t)
-	defer cli.Close()
-
-	// Ensure cleanup after all tests
-	taskNames := []string{"test-producer-task", "test-consumer-task"}
-	defer cleanupContainers(t, cli, taskNames)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
-	defer cancel()
-
-	// Test dependency artifacts
-	t.Run("TaskDependencies", func(t *testing.T) {
-		// Create producer task
-		producer := Task{
-			Name:      "test-producer-task",
-			BaseImage: "docker.io/library/alpine",
-			Commands: []string{
-				"mkdir -p /output",
-				"echo 'This is dependency data' > /output/data.txt",
-				"cat /output/data.txt",
-			},
-		}
+	cli := shellFake(t)
+	ctx := context.Background()
 
-		err := producer.Execute(ctx, cli)
-		if err != nil {
-			t.Fatalf("Producer task execution failed: %v", err)
-		}
+	producer := Task{
+		Name:      "test-producer-task",
+		BaseImage: "docker.io/library/alpine",
+		Commands: []string{
+			"mkdir -p /output",
+			"echo 'This is dependency data' > /output/data.txt",
+			"cat /output/data.txt",
+		},
+	}
+	if err := producer.Execute(ctx, cli); err != nil {
+		t.Fatalf("Producer task execution failed: %v", err)
+	}
 
-		// Create consumer task with dependency
-		consumer := Task{
-			Name:      "test-consumer-task",
-			BaseImage: "docker.io/library/alpine",
-			Dependencies: map[string][]string{
-				"test-producer-task": {"/output/data.txt"},
+	consumer := Task{
+		Name:      "test-consumer-task",
+		BaseImage: "docker.io/library/alpine",
+		Dependencies: []Dependency{
+			{
+				Task:      &producer,
+				Artifacts: []Artifact{{From: "/output/data.txt", To: "/output/data.txt"}},
 			},
-			Commands: []string{
-				"cat /output/data.txt",
-				"mkdir -p /result",
-				"echo 'Consumer added this' >> /output/data.txt",
-				"cat /output/data.txt > /result/processed.txt",
-			},
-		}
-
-		err = consumer.Execute(ctx, cli)
-		if err != nil {
-			t.Fatalf("Consumer task execution failed: %v", err)
-		}
-
-		// Verify that both tasks' containers exist and are preserved
-		for _, containerName := range []string{producer.generateContainerName(), consumer.generateContainerName()} {
-
-			listFilters := filters.NewArgs()
-			listFilters.Add("name", containerName)
-
-			containers, err := cli.ContainerList(ctx, container.ListOptions{
-				All:     true,
-				Filters: listFilters,
-			})
-			if err != nil {
-				t.Fatalf("Failed to list containers: %v", err)
-			}
-
-			if len(containers) == 0 {
-				t.Fatalf("Container not found for task: %s", containerName)
-			}
+		},
+		Commands: []string{
+			"cat /output/data.txt",
+			"mkdir -p /result",
+			"echo 'Consumer added this' >> /output/data.txt",
+			"cat /output/data.txt > /result/processed.txt",
+		},
+	}
+	if err := consumer.Execute(ctx, cli); err != nil {
+		t.Fatalf("Consumer task execution failed: %v", err)
+	}
 
-			// Verify container is stopped
-			if containers[0].State != "exited" && containers[0].State != "running" {
-				t.Errorf("Container should be stopped, but state is: %s", containers[0].State)
-			}
-		}
-	})
+	// Both containers are preserved, and the consumer only changed its copy of the artifact
+	assertPreserved(t, cli, &producer)
+	assertPreserved(t, cli, &consumer)
+	if content := readContainerFile(t, cli, &consumer, "/result/processed.txt"); content != "This is dependency data\nConsumer added this\n" {
+		t.Errorf("Unexpected processed content %q", content)
+	}
+	if content := readContainerFile(t, cli, &producer, "/output/data.txt"); content != "This is dependency data\n" {
+		t.Errorf("Unexpected producer content %q", content)
+	}
 }
 
 func TestMultipleDependencies(t *testing.T) {
-	cli := setupDockerClient(t)
-	defer cli.Close()
-
-	// Ensure cleanup after all tests
-	taskNames := []string{"data-source-1", "data-source-2", "data-combiner"}
-	defer cleanupContainers(t, cli, taskNames)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
-	defer cancel()
-
-	t.Run("MultipleDependencySources", func(t *testing.T) {
-		// Create first source task
-		source1 := Task{
-			Name:      "data-source-1",
-			BaseImage: "docker.io/library/alpine",
-			Commands: []string{
-				"mkdir -p /output",
-				"echo 'Source 1 Data' > /output/source1.txt",
+	cli := shellFake(t)
+
+	source1 := Task{
+		Name:      "data-source-1",
+		BaseImage: "docker.io/library/alpine",
+		Commands: []string{
+			"mkdir -p /output",
+			"echo 'Source 1 Data' > /output/source1.txt",
+		},
+	}
+	source2 := Task{
+		Name:      "data-source-2",
+		BaseImage: "docker.io/library/alpine",
+		Commands: []string{
+			"mkdir -p /output",
+			"echo 'Source 2 Data' > /output/source2.txt",
+		},
+	}
+	combiner := Task{
+		Name:      "data-combiner",
+		BaseImage: "docker.io/library/alpine",
+		Dependencies: []Dependency{
+			{
+				Task:      &source1,
+				Artifacts: []Artifact{{From: "/output/source1.txt", To: "/output/source1.txt"}},
 			},
-		}
+			{
+				Task:      &source2,
+				Artifacts: []Artifact{{From: "/output/source2.txt", To: "/output/source2.txt"}},
+			},
+		},
+		Commands: []string{
+			"mkdir -p /combined",
+			"cat /output/source1.txt > /combined/combined.txt",
+			"cat /output/source2.txt >> /combined/combined.txt",
+			"echo 'Both sources combined' >> /combined/combined.txt",
+			"cat /combined/combined.txt",
+		},
+	}
 
-		err := source1.Execute(ctx, cli)
-		if err != nil {
-			t.Fatalf("Source 1 task execution failed: %v", err)
-		}
+	// The sources are executed as dependencies of the combiner
+	if err := combiner.Execute(context.Background(), cli); err != nil {
+		t.Fatalf("Combiner task execution failed: %v", err)
+	}
+	for _, task := range []*Task{&source1, &source2, &combiner} {
+		assertPreserved(t, cli, task)
+	}
+	if content := readContainerFile(t, cli, &combiner, "/combined/combined.txt"); content != "Source 1 Data\nSource 2 Data\nBoth sources combined\n" {
+		t.Errorf("Unexpected combined content %q", content)
+	}
+}
 
-		// Create second source task
-		source2 := Task{
-			Name:      "data-source-2",
-			BaseImage: "docker.io/library/alpine",
-			Commands: []string{
-				"mkdir -p /output",
-				"echo 'Source 2 Data' > /output/source2.txt",
-			},
-		}
+func TestFileContentDependency(t *testing.T) {
+	cli := shellFake(t)
+	ctx := context.Background()
 
-		err = source2.Execute(ctx, cli)
-		if err != nil {
-			t.Fatalf("Source 2 task execution failed: %v", err)
-		}
+	// Unique content shows the artifact is copied, not produced again
+	uniqueContent := "UNIQUE_TEST_CONTENT_" + time.Now().Format(time.RFC3339)
+	producer := Task{
+		Name:      "test-content-producer",
+		BaseImage: "docker.io/library/alpine",
+		Commands: []string{
+			"mkdir -p /data",
+			"echo '" + uniqueContent + "' > /data/unique.txt",
+		},
+	}
+	if err := producer.Execute(ctx, cli); err != nil {
+		t.Fatalf("Producer task execution failed: %v", err)
+	}
 
-		// Create task dependent on both sources
-		combiner := Task{
-			Name:      "data-combiner",
-			BaseImage: "docker.io/library/alpine",
-			Dependencies: map[string][]string{
-				"data-source-1": {"/output/source1.txt"},
-				"data-source-2": {"/output/source2.txt"},
-			},
-			Commands: []string{
-				"mkdir -p /combined",
-				"cat /output/source1.txt > /combined/combined.txt",
-				"cat /output/source2.txt >> /combined/combined.txt",
-				"echo 'Both sources combined' >> /combined/combined.txt",
-				"cat /combined/combined.txt",
-				// Basic verification
-				"grep -q 'Source 1 Data' /combined/combined.txt || exit 1",
-				"grep -q 'Source 2 Data' /combined/combined.txt || exit 1",
+	consumer := Task{
+		Name:      "test-content-verifier",
+		BaseImage: "docker.io/library/alpine",
+		Dependencies: []Dependency{
+			{
+				Task:      &producer,
+				Artifacts: []Artifact{{From: "/data/unique.txt", To: "/data/unique.txt"}},
 			},
-		}
-
-		err = combiner.Execute(ctx, cli)
-		if err != nil {
-			t.Fatalf("Combiner task execution failed: %v", err)
-		}
-	})
+		},
+		Commands: []string{"cat /data/unique.txt > /tmp/verification.txt"},
+	}
+	if err := consumer.Execute(ctx, cli); err != nil {
+		t.Fatalf("Content verification task failed: %v", err)
+	}
+	if content := readContainerFile(t, cli, &consumer, "/tmp/verification.txt"); content != uniqueContent+"\n" {
+		t.Errorf("Expected the content %q to be preserved, got %q", uniqueContent, content)
+	}
 }
 
-func TestFileContentDependency(t *testing.T) {
-	cli := setupDockerClient(t)
-	defer cli.Close()
-
-	// Ensure cleanup after all tests
-	taskNames := []string{"test-content-producer", "test-content-verifier"}
-	defer cleanupContainers(t, cli, taskNames)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-
-	t.Run("VerifyFileContentPreservation", func(t *testing.T) {
-		// Test unique content to verify content preservation
-		uniqueContent := "UNIQUE_TEST_CONTENT_" + time.Now().Format(time.RFC3339)
-
-		// Producer task that creates a file with unique content
-		producer := Task{
-			Name:      "test-content-producer",
-			BaseImage: "docker.io/library/alpine",
-			Commands: []string{
-				"mkdir -p /data",
-				"echo '" + uniqueContent + "' > /data/unique.txt",
-			},
-		}
+func TestHashIncludesUpstreamChanges(t *testing.T) {
+	image := &Task{Name: "image", Build: &ImageBuild{Context: "."}, imageID: "sha256:aaaa"}
+	consumer := &Task{
+		Name:         "consumer",
+		BaseImage:    "alpine",
+		Dependencies: []Dependency{{Task: image, Artifacts: []Artifact{{From: "/app", To: "/app"}}}},
+	}
 
-		err := producer.Execute(ctx, cli)
-		if err != nil {
-			t.Fatalf("Producer task execution failed: %v", err)
-		}
+	before := consumer.generateHash()
+	image.imageID = "sha256:bbbb"
+	after := consumer.generateHash()
 
-		// Consumer task that reads the file and verifies content
-		consumer := Task{
-			Name:      "test-content-verifier",
-			BaseImage: "docker.io/library/alpine",
-			Dependencies: map[string][]string{
-				"test-content-producer": {"/data/unique.txt"},
-			},
-			Commands: []string{
-				// Write the file content to stdout for verification
-				"cat /data/unique.txt > /tmp/verification.txt",
-				// Use grep to check if the unique content exists
-				"grep -q '" + uniqueContent + "' /data/unique.txt || (echo 'Content verification failed' && exit 1)",
-				// If we reach here, the verification passed
-				"echo 'Content verification successful'",
-			},
-		}
+	if before == after {
+		t.Errorf("Rebuilding a dependency image should change the hash of dependent tasks")
+	}
+}
 
-		err = consumer.Execute(ctx, cli)
-		if err != nil {
-			t.Fatalf("Content verification task failed: %v", err)
-		}
-	})
+func TestCircularDependencyDetection(t *testing.T) {
+	a := &Task{Name: "a", BaseImage: "alpine"}
+	b := &Task{Name: "b", BaseImage: "alpine", Dependencies: []Dependency{{Task: a}}}
+	c := &Task{Name: "c", BaseImage: "alpine", Dependencies: []Dependency{{Task: a}, {Task: b}}}
+
+	if !c.isCircularDependencyFree(nil) {
+		t.Errorf("Shared dependencies must not be reported as circular")
+	}
+
+	a.Dependencies = []Dependency{{Task: c}}
+	if c.isCircularDependencyFree(nil) {
+		t.Errorf("Expected a circular dependency to be detected")
+	}
 }
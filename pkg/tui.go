@@ -0,0 +1,250 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// The terminal UI shows the tasks of a run as a tree, dependencies above their dependents, with a
+// spinner and the elapsed time of running tasks, the status of finished ones and their command output in
+// log panes that expand with enter. It is driven by the events of the tasks, see EventSink.
+
+// tuiLogLines is how many lines of command output the log pane of a task keeps
+const tuiLogLines = 12
+
+// tuiTickInterval is how often spinners and durations of running tasks are redrawn
+const tuiTickInterval = 100 * time.Millisecond
+
+var tuiSpinner = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+var (
+	tuiDim    = lipgloss.NewStyle().Faint(true)
+	tuiCursor = lipgloss.NewStyle().Bold(true)
+	tuiBadges = map[string]lipgloss.Style{
+		StatusExecuted: lipgloss.NewStyle().Foreground(lipgloss.Color("2")),
+		StatusCached:   lipgloss.NewStyle().Foreground(lipgloss.Color("4")),
+		StatusFailed:   lipgloss.NewStyle().Foreground(lipgloss.Color("1")).Bold(true),
+		StatusAllowed:  lipgloss.NewStyle().Foreground(lipgloss.Color("3")),
+		StatusSkipped:  tuiDim,
+		StatusVirtual:  tuiDim,
+	}
+)
+
+// TUI is an interactive terminal display of a run. It receives the events of the tasks of the run as
+// their EventSink.
+type TUI struct {
+	program *tea.Program
+}
+
+// NewTUI creates a display of tasks, given in execution order, drawn on out. Pressing q or Ctrl+C calls
+// cancel, which should stop the run.
+func NewTUI(tasks []*Task, out io.Writer, cancel func()) *TUI {
+	model := newTUIModel(tasks, cancel)
+	return &TUI{program: tea.NewProgram(model, tea.WithOutput(out), tea.WithoutSignalHandler())}
+}
+
+// Event updates the display with an event of a task.
+func (u *TUI) Event(event Event) {
+	u.program.Send(tuiEventMsg(event))
+}
+
+// Run draws the display until Finish is called.
+func (u *TUI) Run() error {
+	if _, err := u.program.Run(); err != nil {
+		return fmt.Errorf("error running terminal UI: %w", err)
+	}
+	return nil
+}
+
+// Finish draws the final state of the run, with the error it returned, and ends Run.
+func (u *TUI) Finish(err error) {
+	u.program.Send(tuiFinishedMsg{err: err})
+}
+
+type tuiEventMsg Event
+
+type tuiFinishedMsg struct {
+	err error
+}
+
+type tuiTickMsg struct{}
+
+// tuiTask is the state of a task in the display
+type tuiTask struct {
+	name     string
+	depth    int
+	status   string // Empty while pending, "running" or a report status once finished
+	started  time.Time
+	duration time.Duration
+	log      []string
+	err      string
+	expanded bool
+}
+
+const tuiRunning = "running"
+
+type tuiModel struct {
+	tasks    []*tuiTask
+	byName   map[string]*tuiTask
+	cursor   int
+	frame    int
+	stopping bool
+	finished bool
+	err      error
+	cancel   func()
+	now      func() time.Time
+}
+
+func newTUIModel(tasks []*Task, cancel func()) *tuiModel {
+	model := &tuiModel{byName: map[string]*tuiTask{}, cancel: cancel, now: time.Now}
+	depths := dependencyDepths(tasks)
+	for _, task := range tasks {
+		state := &tuiTask{name: task.Name, depth: depths[task]}
+		model.tasks = append(model.tasks, state)
+		model.byName[task.Name] = state
+	}
+	return model
+}
+
+func (m *tuiModel) Init() tea.Cmd {
+	return tuiTick()
+}
+
+func tuiTick() tea.Cmd {
+	return tea.Tick(tuiTickInterval, func(time.Time) tea.Msg { return tuiTickMsg{} })
+}
+
+func (m *tuiModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tuiTickMsg:
+		if m.finished {
+			return m, nil
+		}
+		m.frame++
+		return m, tuiTick()
+	case tuiEventMsg:
+		m.apply(Event(msg))
+	case tuiFinishedMsg:
+		m.finished = true
+		m.err = msg.err
+		for _, task := range m.tasks {
+			if task.status == "" {
+				task.status = StatusNotRun
+			}
+		}
+		return m, tea.Quit
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			m.cursor = max(m.cursor-1, 0)
+		case "down", "j":
+			m.cursor = min(m.cursor+1, len(m.tasks)-1)
+		case "enter", " ":
+			if m.cursor < len(m.tasks) {
+				m.tasks[m.cursor].expanded = !m.tasks[m.cursor].expanded
+			}
+		case "q", "ctrl+c":
+			if !m.stopping {
+				m.stopping = true
+				m.cancel()
+			}
+		}
+	}
+	return m, nil
+}
+
+// apply records event in the state of its task
+func (m *tuiModel) apply(event Event) {
+	task, ok := m.byName[event.Task]
+	if !ok {
+		return
+	}
+	switch event.Type {
+	case EventTaskStarted:
+		task.status = tuiRunning
+		task.started = event.Time
+	case EventCommandOutput:
+		task.log = append(task.log, event.Line)
+		if len(task.log) > tuiLogLines {
+			task.log = task.log[len(task.log)-tuiLogLines:]
+		}
+	case EventTaskFinished:
+		task.status = event.Status
+		task.duration = event.Duration
+		task.err = event.Error
+		if event.Status == StatusFailed {
+			task.expanded = true
+		}
+	}
+}
+
+func (m *tuiModel) View() string {
+	var b strings.Builder
+	for i, task := range m.tasks {
+		marker := "  "
+		if i == m.cursor && !m.finished {
+			marker = tuiCursor.Render("> ")
+		}
+		indent := strings.Repeat("  ", task.depth)
+		fmt.Fprintf(&b, "%s%s%s %s %s\n", marker, indent, m.icon(task), task.name, m.detail(task))
+		if !task.expanded {
+			continue
+		}
+		for _, line := range task.log {
+			fmt.Fprintf(&b, "  %s  %s\n", indent, tuiDim.Render("│ "+line))
+		}
+		if task.err != "" {
+			fmt.Fprintf(&b, "  %s  %s\n", indent, tuiBadges[StatusFailed].Render("│ "+task.err))
+		}
+	}
+
+	switch {
+	case m.finished && m.err != nil:
+		fmt.Fprintf(&b, "\nRun failed: %v\n", m.err)
+	case m.finished:
+		b.WriteString("\nRun completed\n")
+	case m.stopping:
+		b.WriteString(tuiDim.Render("\nStopping the run...") + "\n")
+	default:
+		b.WriteString(tuiDim.Render("\n↑/↓ select, enter shows the log, q stops the run") + "\n")
+	}
+	return b.String()
+}
+
+// icon is the spinner of a running task or the mark of its outcome
+func (m *tuiModel) icon(task *tuiTask) string {
+	switch task.status {
+	case "":
+		return tuiDim.Render("·")
+	case tuiRunning:
+		return tuiSpinner[m.frame%len(tuiSpinner)]
+	case StatusFailed:
+		return tuiBadges[StatusFailed].Render("✗")
+	case StatusSkipped, StatusNotRun:
+		return tuiDim.Render("-")
+	}
+	return tuiBadges[StatusExecuted].Render("✓")
+}
+
+// detail is the elapsed time of a running task or the status badge and duration of a finished one
+func (m *tuiModel) detail(task *tuiTask) string {
+	switch task.status {
+	case "":
+		return ""
+	case tuiRunning:
+		return tuiDim.Render(m.now().Sub(task.started).Round(100 * time.Millisecond).String())
+	}
+	badge := task.status
+	if style, ok := tuiBadges[task.status]; ok {
+		badge = style.Render("[" + task.status + "]")
+	}
+	if task.duration == 0 {
+		return badge
+	}
+	return badge + " " + tuiDim.Render(task.duration.Round(100*time.Millisecond).String())
+}
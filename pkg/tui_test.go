@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestTUIModelFollowsEvents(t *testing.T) {
+	deps := &Task{Name: "deps"}
+	build := &Task{Name: "build", Dependencies: []Dependency{{Task: deps}}}
+	test := &Task{Name: "test", Dependencies: []Dependency{{Task: build}}}
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	model := newTUIModel([]*Task{deps, build, test}, func() {})
+	model.now = func() time.Time { return started.Add(1500 * time.Millisecond) }
+
+	if model.tasks[1].depth != 1 || model.tasks[2].depth != 2 {
+		t.Fatalf("Expected dependents to be indented below their dependencies, got depths %d and %d", model.tasks[1].depth, model.tasks[2].depth)
+	}
+
+	model.Update(tuiEventMsg{Type: EventTaskStarted, Task: "deps", Time: started})
+	model.Update(tuiEventMsg{Type: EventTaskFinished, Task: "deps", Status: StatusCached})
+	model.Update(tuiEventMsg{Type: EventTaskStarted, Task: "build", Time: started})
+	for i := range tuiLogLines + 3 {
+		model.Update(tuiEventMsg{Type: EventCommandOutput, Task: "build", Line: fmt.Sprintf("line %d", i)})
+	}
+
+	view := model.View()
+	if !strings.Contains(view, "[cached]") || !strings.Contains(view, "build") || !strings.Contains(view, "1.5s") {
+		t.Errorf("Expected the cached dependency and the elapsed time of build, got\n%s", view)
+	}
+	if strings.Contains(view, "line") {
+		t.Errorf("Expected the log of build to be collapsed, got\n%s", view)
+	}
+
+	model.Update(tea.KeyMsg{Type: tea.KeyDown})
+	model.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	view = model.View()
+	if strings.Contains(view, "line 2\n") || !strings.Contains(view, fmt.Sprintf("line %d", tuiLogLines+2)) {
+		t.Errorf("Expected the last %d lines of build, got\n%s", tuiLogLines, view)
+	}
+
+	_, cmd := model.Update(tuiFinishedMsg{err: errors.New("build failed")})
+	if cmd == nil || model.tasks[2].status != StatusNotRun {
+		t.Errorf("Expected the display to quit with test not run, got status %q", model.tasks[2].status)
+	}
+	if view := model.View(); !strings.Contains(view, "Run failed: build failed") {
+		t.Errorf("Expected the error of the run, got\n%s", view)
+	}
+}
+
+func TestTUIModelStopsRunOnce(t *testing.T) {
+	cancelled := 0
+	model := newTUIModel([]*Task{{Name: "build"}}, func() { cancelled++ })
+	model.Update(tea.KeyMsg{Type: tea.KeyCtrlC})
+	model.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	if cancelled != 1 || !strings.Contains(model.View(), "Stopping") {
+		t.Errorf("Expected the run to be cancelled once, got %d", cancelled)
+	}
+}
+
+func TestTUIModelExpandsFailedTasks(t *testing.T) {
+	model := newTUIModel([]*Task{{Name: "build"}}, func() {})
+	model.Update(tuiEventMsg{Type: EventTaskFinished, Task: "build", Status: StatusFailed, Error: "exit code 2"})
+	if !model.tasks[0].expanded || !strings.Contains(model.View(), "exit code 2") {
+		t.Errorf("Expected the failure of build to be shown, got\n%s", model.View())
+	}
+}
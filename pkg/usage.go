@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// ContainerUsage is the disk consumption of a single preserved task container.
+type ContainerUsage struct {
+	Name       string
+	Hash       string
+	Created    time.Time
+	State      string
+	SizeRw     int64 // Size of the container's writable layer
+	SizeRootFs int64 // Size of all files in the container, including the shared image layers
+}
+
+// TaskUsage aggregates the disk consumption of all containers of a task.
+type TaskUsage struct {
+	TaskName   string
+	Containers []ContainerUsage
+	SizeRw     int64 // Sum of the writable layer sizes of all containers of the task
+}
+
+// UsageReport is the disk consumption of all buildvault containers, largest tasks first.
+type UsageReport struct {
+	Tasks       []TaskUsage
+	TotalSizeRw int64 // Disk space that pruning all containers would free, image layers excluded
+}
+
+// buildUsageReport groups container summaries by task and sorts tasks by writable layer size
+func buildUsageReport(containers []container.Summary) *UsageReport {
+	tasksByName := map[string]*TaskUsage{}
+	for _, containerSummary := range containers {
+		if len(containerSummary.Names) == 0 {
+			continue
+		}
+		name := strings.TrimPrefix(containerSummary.Names[0], "/")
+		taskName, hash, ok := taskContainer(containerSummary)
+		if !ok {
+			continue
+		}
+
+		taskUsage, exists := tasksByName[taskName]
+		if !exists {
+			taskUsage = &TaskUsage{TaskName: taskName}
+			tasksByName[taskName] = taskUsage
+		}
+		taskUsage.Containers = append(taskUsage.Containers, ContainerUsage{
+			Name:       name,
+			Hash:       hash,
+			Created:    time.Unix(containerSummary.Created, 0),
+			State:      containerSummary.State,
+			SizeRw:     containerSummary.SizeRw,
+			SizeRootFs: containerSummary.SizeRootFs,
+		})
+		taskUsage.SizeRw += containerSummary.SizeRw
+	}
+
+	report := &UsageReport{}
+	for _, taskUsage := range tasksByName {
+		sort.Slice(taskUsage.Containers, func(i, j int) bool {
+			a, b := taskUsage.Containers[i], taskUsage.Containers[j]
+			if !a.Created.Equal(b.Created) {
+				return a.Created.After(b.Created)
+			}
+			return a.Name < b.Name
+		})
+		report.Tasks = append(report.Tasks, *taskUsage)
+		report.TotalSizeRw += taskUsage.SizeRw
+	}
+	sort.Slice(report.Tasks, func(i, j int) bool {
+		if report.Tasks[i].SizeRw != report.Tasks[j].SizeRw {
+			return report.Tasks[i].SizeRw > report.Tasks[j].SizeRw
+		}
+		return report.Tasks[i].TaskName < report.Tasks[j].TaskName
+	})
+
+	return report
+}
+
+// Usage inspects all buildvault containers and reports their disk consumption per task.
+func Usage(ctx context.Context, cli ContainerAPI) (*UsageReport, error) {
+	containers, err := listBuildvaultContainers(ctx, cli, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return buildUsageReport(containers), nil
+}
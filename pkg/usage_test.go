@@ -0,0 +1,31 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+func TestBuildUsageReport(t *testing.T) {
+	containers := []container.Summary{
+		{Names: []string{"/buildvault_build_aaaaaaaaaaaa"}, SizeRw: 100, Created: 1},
+		{Names: []string{"/buildvault_build_bbbbbbbbbbbb"}, SizeRw: 50, Created: 2},
+		{Names: []string{"/buildvault_test_cccccccccccc"}, SizeRw: 500, Created: 3},
+		{Names: []string{"/unrelated"}, SizeRw: 1000},
+	}
+
+	report := buildUsageReport(containers)
+
+	if report.TotalSizeRw != 650 {
+		t.Errorf("Expected total of 650 bytes, got %d", report.TotalSizeRw)
+	}
+	if len(report.Tasks) != 2 {
+		t.Fatalf("Expected 2 tasks, got %d", len(report.Tasks))
+	}
+	if report.Tasks[0].TaskName != "test" || report.Tasks[1].TaskName != "build" {
+		t.Errorf("Tasks should be sorted by size, got %s, %s", report.Tasks[0].TaskName, report.Tasks[1].TaskName)
+	}
+	if build := report.Tasks[1]; build.SizeRw != 150 || len(build.Containers) != 2 || build.Containers[0].Hash != "bbbbbbbbbbbb" {
+		t.Errorf("Unexpected usage for task build: %+v", build)
+	}
+}
@@ -0,0 +1,37 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// Task containers run as the user of their image, usually root, so files they write to bind mounts end
+// up owned by root on the host. User and HostUser run the container and every command exec as another
+// user instead. buildvault's own execs, like creating artifact directories and digesting outputs, keep
+// running as root, and copied artifacts are handed over to the user.
+
+// rootUser is the user of buildvault's own execs in task containers
+const rootUser = "0"
+
+// validateUser checks that t sets at most one of User and HostUser
+func (t *Task) validateUser() error {
+	if t.User != "" && t.HostUser {
+		return fmt.Errorf("task '%s' can either set a user or run as the host user", t.Name)
+	}
+	if t.HostUser && runtime.GOOS == "windows" {
+		return fmt.Errorf("task '%s' runs as the host user, which is not supported on Windows", t.Name)
+	}
+	return nil
+}
+
+// containerUser returns the user the container and commands of t run as, empty for the image's user
+func (t *Task) containerUser() string {
+	if t.HostUser {
+		return fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+	}
+	if t.User == "" && !t.inherits().User {
+		return rootUser
+	}
+	return t.User
+}
@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestContainerUser(t *testing.T) {
+	if user := (&Task{Name: "test"}).containerUser(); user != "" {
+		t.Errorf("Expected the image's user by default, got %s", user)
+	}
+	if user := (&Task{Name: "test", User: "1000:1000"}).containerUser(); user != "1000:1000" {
+		t.Errorf("Unexpected user %s", user)
+	}
+	if runtime.GOOS != "windows" {
+		want := fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid())
+		if user := (&Task{Name: "test", HostUser: true}).containerUser(); user != want {
+			t.Errorf("Expected the host user %s, got %s", want, user)
+		}
+	}
+
+	if err := (&Task{Name: "test", User: "node", HostUser: true}).validateUser(); err == nil {
+		t.Errorf("Expected an error for a user combined with the host user")
+	}
+}
+
+func TestUserChangesTaskHash(t *testing.T) {
+	task := &Task{Name: "test", BaseImage: "alpine"}
+	before := task.generateHash()
+	task.User = "1000"
+	if task.generateHash() == before {
+		t.Errorf("Changing the user should change the task hash")
+	}
+}
+
+func TestSecretsDirWithUser(t *testing.T) {
+	task := &Task{Name: "test", User: "1000", Secrets: []Secret{{Name: "npmrc", Mount: true}}}
+	mounts := task.containerMounts()
+	if len(mounts) != 1 || mounts[0].Target != secretsDir {
+		t.Fatalf("Expected the secrets tmpfs, got %+v", mounts)
+	}
+	if mounts[0].TmpfsOptions.Mode != 0o1777 {
+		t.Errorf("Expected a sticky world-writable secrets directory, got mode %o", mounts[0].TmpfsOptions.Mode)
+	}
+}
@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"bytes"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// pipelineSchema is the JSON Schema of YAML pipeline files
+//
+//go:embed pipeline.schema.json
+var pipelineSchema []byte
+
+// yamlTypeName matches the Go type names in YAML decoding errors, which mean nothing to pipeline authors
+var yamlTypeName = regexp.MustCompile(` in type \S+`)
+
+// PipelineSchema returns the JSON Schema of YAML pipeline files, for editors and linters.
+func PipelineSchema() []byte {
+	return pipelineSchema
+}
+
+// ValidatePipelineFile checks the pipeline file at path without executing anything and returns the
+// problems it found, none if the file is valid. Besides everything LoadPipeline rejects, it reports
+// unknown keys, which loading ignores, and artifacts copied from paths their dependency does not
+// declare as outputs. The error is only set if the file cannot be read.
+func ValidatePipelineFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading pipeline file: %w", err)
+	}
+
+	// HCL pipelines are decoded strictly anyway
+	if filepath.Ext(path) != ".hcl" {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		var file pipelineFile
+		err := decoder.Decode(&file)
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			var problems []string
+			for _, problem := range typeErr.Errors {
+				problems = append(problems, yamlTypeName.ReplaceAllString(problem, ""))
+			}
+			return problems, nil
+		}
+		if err != nil && err != io.EOF {
+			return []string{err.Error()}, nil
+		}
+	}
+
+	pipeline, err := LoadPipeline(path)
+	if err != nil {
+		return []string{err.Error()}, nil
+	}
+	return pipeline.Validate(), nil
+}
+
+// Validate reports problems of p that loading does not reject: artifacts copied from paths that are not
+// below any output of their dependency. Dependencies without declared outputs are not checked.
+func (p *Pipeline) Validate() []string {
+	var problems []string
+	for _, task := range p.Tasks {
+		for _, dependency := range task.Dependencies {
+			if dependency.Task.Virtual || dependency.Task.Container != "" {
+				continue
+			}
+			outputs := dependency.Task.declaredOutputs()
+			if len(outputs) == 0 {
+				continue
+			}
+			for _, artifact := range dependency.Artifacts {
+				if !producesPath(dependency.Task, outputs, artifact.From) {
+					problems = append(problems, fmt.Sprintf("task '%s' copies %s from '%s', which is not one of its outputs",
+						task.Name, artifact.From, dependency.Task.Name))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// producesPath reports whether path is below one of the outputs of t, comparing them with variables resolved
+func producesPath(t *Task, outputs []string, path string) bool {
+	if resolved, err := Interpolate(path, t.Vars); err == nil {
+		path = resolved
+	}
+	for _, output := range outputs {
+		if resolved, err := Interpolate(output, t.Vars); err == nil {
+			output = resolved
+		}
+		if isUnderPath(path, output) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package pkg
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// schemaKeys collects the property names of every object in a JSON Schema
+func schemaKeys(node any, keys map[string]bool) {
+	switch v := node.(type) {
+	case map[string]any:
+		if properties, ok := v["properties"].(map[string]any); ok {
+			for key := range properties {
+				keys[key] = true
+			}
+		}
+		for _, child := range v {
+			schemaKeys(child, keys)
+		}
+	case []any:
+		for _, child := range v {
+			schemaKeys(child, keys)
+		}
+	}
+}
+
+func TestPipelineSchemaCoversPipelineKeys(t *testing.T) {
+	var schema any
+	if err := json.Unmarshal(PipelineSchema(), &schema); err != nil {
+		t.Fatalf("Schema is not valid JSON: %v", err)
+	}
+	keys := map[string]bool{}
+	schemaKeys(schema, keys)
+
+	for _, spec := range []any{pipelineFile{}, dockerSpec{}, taskSpec{}, buildSpec{}, dependencySpec{}, secretSpec{},
+		Artifact{}, Mount{}, Export{}, Network{}, Service{}, Readiness{}, ImageInheritance{}} {
+		typ := reflect.TypeOf(spec)
+		for i := 0; i < typ.NumField(); i++ {
+			key, _, _ := strings.Cut(typ.Field(i).Tag.Get("yaml"), ",")
+			if key != "" && !keys[key] {
+				t.Errorf("Schema lacks key '%s' of %s", key, typ.Name())
+			}
+		}
+	}
+}
+
+func writePipeline(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "buildvault.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidatePipelineFile(t *testing.T) {
+	problems, err := ValidatePipelineFile(writePipeline(t, `
+tasks:
+  - name: build
+    image: alpine
+    outputs: [/out/app]
+  - name: package
+    image: alpine
+    dependencies:
+      - task: build
+        artifacts:
+          - from: /out/app/bin
+            to: /bin
+          - from: /src
+            to: /src
+`))
+	if err != nil {
+		t.Fatalf("Failed to validate: %v", err)
+	}
+	if len(problems) != 1 || !strings.Contains(problems[0], "copies /src from 'build'") {
+		t.Errorf("Expected the artifact outside of the outputs, got %v", problems)
+	}
+
+	problems, _ = ValidatePipelineFile(writePipeline(t, `
+tasks:
+  - name: build
+    imag: alpine
+    comands: [make]
+`))
+	if len(problems) != 2 || !strings.Contains(problems[0], "field imag not found") || strings.Contains(problems[0], "taskSpec") {
+		t.Errorf("Expected the unknown keys, got %v", problems)
+	}
+
+	problems, _ = ValidatePipelineFile(writePipeline(t, `
+tasks:
+  - name: build
+    image: alpine
+    dependencies:
+      - task: missing
+`))
+	if len(problems) != 1 || !strings.Contains(problems[0], "unknown task 'missing'") {
+		t.Errorf("Expected the unknown dependency, got %v", problems)
+	}
+}
@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Pipeline variables are referenced as ${{ vars.NAME }} in the images, commands, scripts and paths of
+// tasks, and replaced right before a task is planned or executed, so values set on the command line win
+// over those of the pipeline file. Every referenced variable has to be defined. Since values end up in
+// commands, they change the hash of a task like any other change to its commands.
+
+// varReference matches ${{ ... }} with the expression inside as its submatch
+var varReference = regexp.MustCompile(`\$\{\{\s*(.*?)\s*\}\}`)
+
+// Interpolate replaces the ${{ vars.NAME }} references in s with the values of vars.
+func Interpolate(s string, vars map[string]string) (string, error) {
+	var err error
+	result := varReference.ReplaceAllStringFunc(s, func(match string) string {
+		expr := varReference.FindStringSubmatch(match)[1]
+		name, ok := strings.CutPrefix(expr, "vars.")
+		if !ok {
+			err = fmt.Errorf("unsupported expression '%s', expected vars.NAME", expr)
+			return match
+		}
+		value, ok := vars[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("undefined variable '%s'", name)
+		}
+		return value
+	})
+	if err != nil {
+		return "", err
+	}
+	return result, nil
+}
+
+// SetVar sets the variable name of p and its tasks to value, overriding the value of the pipeline file.
+// Only variables the pipeline file defines can be set.
+func (p *Pipeline) SetVar(name, value string) error {
+	if _, ok := p.Vars[name]; !ok {
+		return configErrorf("pipeline has no variable '%s'", name)
+	}
+	p.Vars[name] = value
+	for _, task := range p.Tasks {
+		if task.Vars != nil {
+			task.Vars[name] = value
+		}
+	}
+	return nil
+}
+
+// interpolatedFields calls f with every field of t that may reference variables
+func (t *Task) interpolatedFields(f func(field *string) error) error {
+	fields := []*string{&t.BaseImage, &t.OutputImage, &t.ManifestList, &t.Script, &t.Prelude}
+	for i := range t.Commands {
+		fields = append(fields, &t.Commands[i])
+	}
+	for i := range t.Cmd {
+		for j := range t.Cmd[i] {
+			fields = append(fields, &t.Cmd[i][j])
+		}
+	}
+	for i := range t.Shell {
+		fields = append(fields, &t.Shell[i])
+	}
+	for i := range t.Outputs {
+		fields = append(fields, &t.Outputs[i])
+	}
+	for i := range t.Exports {
+		fields = append(fields, &t.Exports[i].From, &t.Exports[i].To)
+	}
+	for _, dependency := range t.Dependencies {
+		for i := range dependency.Artifacts {
+			fields = append(fields, &dependency.Artifacts[i].From, &dependency.Artifacts[i].To)
+		}
+	}
+	if t.Build != nil {
+		fields = append(fields, &t.Build.Context)
+	}
+	for i := range t.Git {
+		fields = append(fields, &t.Git[i].URL, &t.Git[i].Ref, &t.Git[i].Path)
+	}
+	for i := range t.Downloads {
+		fields = append(fields, &t.Downloads[i].URL, &t.Downloads[i].Dest)
+	}
+	for _, field := range fields {
+		if err := f(field); err != nil {
+			return err
+		}
+	}
+
+	// Map values are not addressable
+	for name, path := range t.NamedOutputs {
+		if err := f(&path); err != nil {
+			return err
+		}
+		t.NamedOutputs[name] = path
+	}
+	if t.Build != nil {
+		for name, value := range t.Build.Args {
+			if err := f(&value); err != nil {
+				return err
+			}
+			t.Build.Args[name] = value
+		}
+	}
+	return nil
+}
+
+// checkVars checks that every variable t references is defined, without replacing the references
+func (t *Task) checkVars() error {
+	return t.interpolatedFields(func(field *string) error {
+		if _, err := Interpolate(*field, t.Vars); err != nil {
+			return fmt.Errorf("task '%s': %w", t.Name, err)
+		}
+		return nil
+	})
+}
+
+// resolveVars replaces the variable references of t and its dependencies with their values
+func (t *Task) resolveVars() error {
+	if t.varsResolved {
+		return nil
+	}
+	for _, dependency := range t.Dependencies {
+		if err := dependency.Task.resolveVars(); err != nil {
+			return err
+		}
+	}
+	err := t.interpolatedFields(func(field *string) error {
+		value, err := Interpolate(*field, t.Vars)
+		if err != nil {
+			return configErrorf("task '%s': %w", t.Name, err)
+		}
+		*field = value
+		return nil
+	})
+	t.varsResolved = err == nil
+	return err
+}
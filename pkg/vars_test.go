@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInterpolate(t *testing.T) {
+	vars := map[string]string{"VERSION": "1.2.3", "EMPTY": ""}
+	got, err := Interpolate("build ${{ vars.VERSION }}${{vars.EMPTY}} and ${HOME} $(pwd)", vars)
+	if err != nil {
+		t.Fatalf("Failed to interpolate: %v", err)
+	}
+	if want := "build 1.2.3 and ${HOME} $(pwd)"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	if _, err := Interpolate("${{ vars.MISSING }}", vars); err == nil || !strings.Contains(err.Error(), "MISSING") {
+		t.Errorf("Expected an error for an undefined variable, got %v", err)
+	}
+	if _, err := Interpolate("${{ env.HOME }}", vars); err == nil {
+		t.Errorf("Expected an error for an unsupported expression")
+	}
+}
+
+func TestPipelineVars(t *testing.T) {
+	pipeline, err := ParsePipeline([]byte(`
+vars:
+  GO: "1.22"
+  OUT: /out
+tasks:
+  - name: build
+    image: golang:${{ vars.GO }}
+    commands:
+      - go build -o ${{ vars.OUT }}/app
+    outputs:
+      - ${{ vars.OUT }}/app
+`))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+	if err := pipeline.SetVar("GO", "1.23"); err != nil {
+		t.Fatalf("Failed to set variable: %v", err)
+	}
+	if err := pipeline.SetVar("UNKNOWN", "x"); err == nil {
+		t.Errorf("Expected an error for a variable the pipeline does not define")
+	}
+
+	task := pipeline.Tasks[0]
+	before := task.generateHash()
+	if err := task.resolveVars(); err != nil {
+		t.Fatalf("Failed to resolve variables: %v", err)
+	}
+	if task.BaseImage != "golang:1.23" || task.Commands[0] != "go build -o /out/app" || task.Outputs[0] != "/out/app" {
+		t.Errorf("Unexpected resolved task %+v", task)
+	}
+	if task.generateHash() == before {
+		t.Errorf("Expected the resolved values to change the hash")
+	}
+
+	if _, err := ParsePipeline([]byte(`
+tasks:
+  - name: build
+    image: alpine
+    commands:
+      - echo ${{ vars.MISSING }}
+`)); err == nil {
+		t.Errorf("Expected an error for an undefined variable")
+	}
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// verbosef writes a detail of the execution of t to w, only in verbose runs
+func (t *Task) verbosef(w io.Writer, format string, args ...any) {
+	if t.options.logLevel == LogVerbose {
+		fmt.Fprintf(w, format, args...)
+	}
+}
+
+// printHashDetails prints, in verbose runs, the hash of t and the digests of its hash inputs
+func (t *Task) printHashDetails(w io.Writer) {
+	if t.options.logLevel != LogVerbose {
+		return
+	}
+	fmt.Fprintf(w, "Task '%s' has hash %s\n", t.Name, t.generateHash())
+	for _, input := range slices.Sorted(maps.Keys(t.inputDigests)) {
+		fmt.Fprintf(w, "  input %s: %s\n", input, t.inputDigests[input])
+	}
+	for _, source := range t.Git {
+		fmt.Fprintf(w, "  git %s at %s: %s\n", source.URL, gitRefName(source), t.gitCommits[source.Path])
+	}
+	for _, download := range t.Downloads {
+		fmt.Fprintf(w, "  download %s: sha256:%s\n", download.Dest, download.SHA256)
+	}
+}
+
+// heldOutput keeps the output of a task until it is known whether the task failed. Writes to stdout
+// and stderr are kept in order, so replaying them interleaves them as they were written.
+type heldOutput struct {
+	mu     sync.Mutex
+	chunks []heldChunk
+}
+
+type heldChunk struct {
+	w    io.Writer
+	data []byte
+}
+
+// writer returns a writer holding back what is written to it for w
+func (h *heldOutput) writer(w io.Writer) io.Writer {
+	return heldWriter{held: h, w: w}
+}
+
+// replay writes the held output to the writers it was meant for
+func (h *heldOutput) replay() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, chunk := range h.chunks {
+		chunk.w.Write(chunk.data)
+	}
+	h.chunks = nil
+}
+
+type heldWriter struct {
+	held *heldOutput
+	w    io.Writer
+}
+
+func (w heldWriter) Write(p []byte) (int, error) {
+	w.held.mu.Lock()
+	defer w.held.mu.Unlock()
+	w.held.chunks = append(w.held.chunks, heldChunk{w: w.w, data: slices.Clone(p)})
+	return len(p), nil
+}
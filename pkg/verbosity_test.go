@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestHeldOutputReplaysInOrder(t *testing.T) {
+	var stdout, stderr, combined bytes.Buffer
+	held := &heldOutput{}
+	out := held.writer(io.MultiWriter(&stdout, &combined))
+	errOut := held.writer(io.MultiWriter(&stderr, &combined))
+
+	fmt.Fprintln(out, "compiling")
+	fmt.Fprintln(errOut, "warning")
+	fmt.Fprintln(out, "done")
+	if combined.Len() != 0 {
+		t.Fatalf("Expected output to be held back, got %q", combined.String())
+	}
+
+	held.replay()
+	if combined.String() != "compiling\nwarning\ndone\n" || stderr.String() != "warning\n" {
+		t.Errorf("Unexpected replayed output %q, stderr %q", combined.String(), stderr.String())
+	}
+	held.replay()
+	if strings.Count(combined.String(), "done") != 1 {
+		t.Errorf("Expected output to be replayed once, got %q", combined.String())
+	}
+}
+
+func TestPrintHashDetailsOnlyWhenVerbose(t *testing.T) {
+	task := &Task{Name: "build", BaseImage: "alpine", inputDigests: map[string]string{"go.sum": "sha256:abc"}}
+	var out bytes.Buffer
+	task.printHashDetails(&out)
+	if out.Len() != 0 {
+		t.Fatalf("Expected nothing outside of verbose runs, got %q", out.String())
+	}
+
+	task.options = newExecuteOptions([]ExecuteOption{WithLogLevel(LogVerbose)})
+	task.printHashDetails(&out)
+	if !strings.Contains(out.String(), task.generateHash()) || !strings.Contains(out.String(), "go.sum: sha256:abc") {
+		t.Errorf("Expected the hash and its inputs, got %q", out.String())
+	}
+}
@@ -0,0 +1,55 @@
+package pkg
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Virtual tasks have no image and no commands. They group artifacts of several upstream tasks under one
+// name, like a Bazel filegroup: the destination paths of their dependency artifacts are the paths they
+// re-export, and dependents copy from those paths as if the virtual task had produced them.
+
+// validateVirtual checks that a virtual task only declares what it can re-export
+func (t *Task) validateVirtual() error {
+	if t.BaseImage != "" || t.Build != nil || len(t.Commands) > 0 || len(t.Cmd) > 0 || t.Script != "" || len(t.HashInputs) > 0 || len(t.Outputs) > 0 || t.Helper != "" || t.OutputImage != "" {
+		return fmt.Errorf("virtual task '%s' can only have dependencies and named outputs", t.Name)
+	}
+	for name, output := range t.NamedOutputs {
+		if _, _, ok := t.resolveVirtualArtifact(output); !ok {
+			return fmt.Errorf("named output '%s' of virtual task '%s' is not re-exported from a dependency", name, t.Name)
+		}
+	}
+	return nil
+}
+
+// resolveVirtualArtifact maps a path re-exported by the virtual task t to the task that produced it and
+// the path there, following chains of virtual tasks. The most specific re-export covering from wins.
+func (t *Task) resolveVirtualArtifact(from string) (*Task, string, bool) {
+	var source *Task
+	var sourcePath string
+	for _, c := range t.resolvedCopies() {
+		if isUnderPath(from, c.artifact.To) {
+			source = c.dependency
+			sourcePath = c.artifact.From + strings.TrimPrefix(from, strings.TrimSuffix(c.artifact.To, "/"))
+		}
+	}
+	if source == nil {
+		return nil, "", false
+	}
+	if source.Virtual {
+		return source.resolveVirtualArtifact(sourcePath)
+	}
+	return source, sourcePath, true
+}
+
+// resolveArtifactSource returns the task and path an artifact of dependency is actually read from
+func resolveArtifactSource(dependency *Task, from string) (*Task, string, error) {
+	if !dependency.Virtual {
+		return dependency, from, nil
+	}
+	source, sourcePath, ok := dependency.resolveVirtualArtifact(from)
+	if !ok {
+		return nil, "", fmt.Errorf("%s is not re-exported by virtual task '%s'", from, dependency.Name)
+	}
+	return source, sourcePath, nil
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"testing"
+)
+
+func TestVirtualTaskResolution(t *testing.T) {
+	data := `tasks:
+  - name: build
+    image: golang
+    outputs: [/out/app, /out/docs]
+  - name: dist
+    virtual: true
+    dependencies:
+      - task: build
+        artifacts:
+          - from: /out/app
+            to: /dist/bin/application
+          - from: /out/docs
+            to: /dist/docs
+    outputs:
+      binary: /dist/bin/application
+  - name: release
+    image: alpine
+    dependencies:
+      - task: dist
+        artifacts:
+          - output: binary
+            to: /usr/bin/application
+          - from: /dist/docs/index.html
+            to: /www/index.html
+`
+	pipeline, err := ParsePipeline([]byte(data))
+	if err != nil {
+		t.Fatalf("Failed to parse pipeline: %v", err)
+	}
+	build, _ := pipeline.Task("build")
+	dist, _ := pipeline.Task("dist")
+
+	source, sourcePath, ok := dist.resolveVirtualArtifact("/dist/docs/index.html")
+	if !ok || source != build || sourcePath != "/out/docs/index.html" {
+		t.Errorf("Unexpected resolution %v %s %v", source, sourcePath, ok)
+	}
+	if _, _, err := resolveArtifactSource(dist, "/elsewhere"); err == nil {
+		t.Errorf("Expected an error for a path the virtual task does not re-export")
+	}
+
+	// Artifacts are read from the upstream task and carry their re-exported name
+	store, err := NewArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, size, err := store.putBlob(bytes.NewReader(buildTar(t, "app", "binary")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	build.ArtifactStore = store
+	err = store.writeManifest(&StoreManifest{Task: "build", Hash: build.generateHash(), Artifacts: []StoredArtifact{{Path: "/out/app", Digest: digest, Size: size}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := openDependencyArtifact(context.Background(), nil, dist, "/dist/bin/application")
+	if err != nil {
+		t.Fatalf("Failed to open re-exported artifact: %v", err)
+	}
+	defer reader.Close()
+	header, err := tar.NewReader(reader).Next()
+	if err != nil || header.Name != "application" {
+		t.Errorf("Expected the artifact to be renamed to application, got %v (%v)", header, err)
+	}
+	io.Copy(io.Discard, reader)
+
+	invalid := `tasks:
+  - name: dist
+    virtual: true
+    commands: [echo]
+`
+	if _, err := ParsePipeline([]byte(invalid)); err == nil {
+		t.Errorf("Expected an error for a virtual task with commands")
+	}
+}
@@ -0,0 +1,229 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watch mode runs the targets of a pipeline, then waits for changes to the host paths their tasks hash
+// (HashInputs and build contexts). After a change, only the tasks whose inputs changed execute again,
+// together with everything depending on them. The other tasks keep their containers from the previous
+// run, which their dependents copy artifacts from as before.
+
+// watchDebounce is how long changes are collected before the tasks execute again, so saving many files
+// at once triggers a single run
+const watchDebounce = 300 * time.Millisecond
+
+// Watch runs the targets of p and executes the tasks affected by every later change to their host inputs
+// again, until ctx is cancelled. Failed runs are reported and the next change runs the failed tasks again.
+func (p *Pipeline) Watch(ctx context.Context, cli DockerAPI, opts ...ExecuteOption) error {
+	tasks, err := p.TopoSort()
+	if err != nil {
+		return err
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	paths := watchedPaths(tasks)
+	for _, path := range paths {
+		if err := watchTree(watcher, path); err != nil {
+			return err
+		}
+	}
+
+	p.watchRun(ctx, cli, nil, opts)
+	for {
+		fmt.Printf("Watching %d paths for changes, press Ctrl+C to stop\n", len(paths))
+		changed, err := waitForChanges(ctx, watcher)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		affected, err := affectedTasks(tasks, changed)
+		if err != nil {
+			fmt.Printf("Failed to hash inputs: %v\n", err)
+			continue
+		}
+
+		done := map[*Task]bool{}
+		var rerun []*Task
+		for _, task := range tasks {
+			if affected[task] || !(task.completed || task.skipped || task.status() == StatusExternal) {
+				rerun = append(rerun, task)
+				continue
+			}
+			done[task] = true
+		}
+		if len(rerun) == 0 {
+			fmt.Println("No task is affected by the changes")
+			continue
+		}
+
+		fmt.Printf("Executing again: %s\n", strings.Join(taskNames(rerun), ", "))
+		for _, task := range rerun {
+			task.resetRun()
+		}
+		p.watchRun(ctx, cli, done, opts)
+	}
+}
+
+// watchRun runs the targets of p, with the tasks in done treated as already completed
+func (p *Pipeline) watchRun(ctx context.Context, cli DockerAPI, done map[*Task]bool, opts []ExecuteOption) {
+	started := time.Now()
+	// Containers are never removed, later runs copy artifacts from the containers of tasks not affected by changes
+	opts = append(opts, func(o *executeOptions) { o.done, o.cleanup = done, &containerCleanup{} })
+	if err := p.Run(ctx, cli, opts...); err != nil {
+		fmt.Printf("Run failed after %s: %v\n", time.Since(started).Round(time.Millisecond), err)
+		return
+	}
+	fmt.Printf("Run completed in %s\n", time.Since(started).Round(time.Millisecond))
+}
+
+// watchedPaths returns the host paths tasks depend on: hash inputs and build contexts
+func watchedPaths(tasks []*Task) []string {
+	var paths []string
+	seen := map[string]bool{}
+	for _, task := range tasks {
+		candidates := []string{}
+		for _, input := range task.HashInputs {
+			if _, _, ok := task.artifactInputSource(input); !ok {
+				candidates = append(candidates, input)
+			}
+		}
+		if task.Build != nil {
+			candidates = append(candidates, task.Build.Context)
+		}
+		for _, path := range candidates {
+			if !seen[path] {
+				seen[path] = true
+				paths = append(paths, path)
+			}
+		}
+	}
+	return paths
+}
+
+// watchTree watches path and, since fsnotify is not recursive, every directory below it. Files are
+// watched through their directory, so editors replacing them on save are noticed.
+func watchTree(watcher *fsnotify.Watcher, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error watching %s: %w", path, err)
+	}
+	if !info.IsDir() {
+		path = filepath.Dir(path)
+	}
+	return filepath.WalkDir(path, func(dir string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("error watching %s: %w", dir, err)
+		}
+		return nil
+	})
+}
+
+// waitForChanges blocks until a change is reported, then until no further change arrives for
+// watchDebounce, and returns the absolute paths that changed. New directories are watched as well.
+func waitForChanges(ctx context.Context, watcher *fsnotify.Watcher) ([]string, error) {
+	var changed []string
+	var quiet <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case err := <-watcher.Errors:
+			return nil, fmt.Errorf("error watching files: %w", err)
+		case event := <-watcher.Events:
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					watchTree(watcher, event.Name)
+				}
+			}
+			if path, err := filepath.Abs(event.Name); err == nil {
+				changed = append(changed, path)
+			}
+			quiet = time.After(watchDebounce)
+		case <-quiet:
+			return changed, nil
+		}
+	}
+}
+
+// affectedTasks hashes the host inputs of tasks, given dependencies first, again and returns those whose
+// inputs or build context changed and everything depending on them
+func affectedTasks(tasks []*Task, changed []string) (map[*Task]bool, error) {
+	affected := map[*Task]bool{}
+	for _, task := range tasks {
+		before := maps.Clone(task.inputDigests)
+		for input := range task.inputDigests {
+			if _, _, ok := task.artifactInputSource(input); !ok {
+				delete(task.inputDigests, input)
+			}
+		}
+		if err := task.hashHostInputs(); err != nil {
+			return nil, err
+		}
+		for _, input := range task.HashInputs {
+			if before[input] != task.inputDigests[input] {
+				affected[task] = true
+			}
+		}
+		if task.Build != nil {
+			// Build contexts are not digested, docker build decides what changed
+			buildContext, _ := filepath.Abs(task.Build.Context)
+			for _, path := range changed {
+				if isUnderPath(path, buildContext) {
+					affected[task] = true
+				}
+			}
+		}
+		for _, dependency := range task.Dependencies {
+			if affected[dependency.Task] {
+				affected[task] = true
+			}
+		}
+	}
+	return affected, nil
+}
+
+// resetRun clears what t recorded during the previous run, so it executes again from scratch. The image
+// of a Dockerfile build is built again, picking up changes to its context.
+func (t *Task) resetRun() {
+	if t.Build != nil {
+		t.imageID = ""
+	}
+	t.containerID = ""
+	t.outputDigests = nil
+	t.storedOutputs = ""
+	t.outputSizes = nil
+	t.readOnlyDigests = nil
+	t.cacheHit = false
+	t.completed = false
+	t.skipped = false
+	t.allowedFailure = false
+	t.started = time.Time{}
+	t.duration = 0
+	t.commandLog = nil
+	t.commandResults = nil
+	t.pushedImage = ""
+}
